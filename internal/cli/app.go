@@ -223,6 +223,10 @@ func (a *App) Run(arguments []string) (err error) {
 func (a *App) RunContext(ctx context.Context, arguments []string) (err error) {
 	a.Setup()
 
+	if a.EnableBashCompletion && len(arguments) == 3 && arguments[1] == CompleteValuesCommand {
+		return a.completeFlagValues(arguments[2])
+	}
+
 	// handle the completion flag separately from the flagset since
 	// completion could be attempted after a flag, but before its value was put
 	// on the command line. this causes the flagset to interpret the completion
@@ -0,0 +1,362 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// CompleteValuesCommand is the hidden argument generated completion scripts
+// pass to ask the application for a flag's dynamic values, for example
+// `croc __complete revoke`.
+const CompleteValuesCommand = "__complete"
+
+// completionEntry is one word offered in a command scope.
+type completionEntry struct {
+	name  string
+	usage string
+}
+
+// completionScope holds what can follow a command path such as "" for the
+// application itself or "relay" for one of its commands.
+type completionScope struct {
+	path    string
+	entries []completionEntry
+	// valueFlags maps flags that take a value to the flag name passed to
+	// CompleteValuesCommand, or "" when the shell should complete files.
+	valueFlags map[string]string
+}
+
+func completionFlagName(name string) string {
+	return prefixFor(name) + name
+}
+
+func appendCompletionFlags(scope *completionScope, seen map[string]bool, flags []Flag) {
+	for _, f := range flags {
+		flag, ok := f.(DocGenerationFlag)
+		if !ok {
+			continue
+		}
+		dynamic := ""
+		if stringFlag, ok := f.(*StringFlag); ok && stringFlag.Complete != nil {
+			dynamic = stringFlag.Name
+		}
+		for _, name := range flag.Names() {
+			name = completionFlagName(strings.TrimSpace(name))
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			scope.entries = append(scope.entries, completionEntry{name: name, usage: flag.GetUsage()})
+			if flag.TakesValue() {
+				scope.valueFlags[name] = dynamic
+			}
+		}
+	}
+}
+
+func newCompletionScope(path string, commands []*Command, flags []Flag) completionScope {
+	scope := completionScope{path: path, valueFlags: make(map[string]string)}
+	for _, command := range commands {
+		if command.Hidden {
+			continue
+		}
+		for _, name := range command.Names() {
+			scope.entries = append(scope.entries, completionEntry{name: name, usage: command.Usage})
+		}
+	}
+	appendCompletionFlags(&scope, make(map[string]bool), flags)
+	return scope
+}
+
+// completionScopes lists every visible command path with the commands and
+// flags that may follow it, in the order the shells should try them.
+func (a *App) completionScopes() []completionScope {
+	flags := a.VisibleFlags()
+	if !a.HideHelp && HelpFlag != nil {
+		flags = append(flags, HelpFlag)
+	}
+	scopes := []completionScope{newCompletionScope("", a.VisibleCommands(), flags)}
+	var walk func(prefix string, commands []*Command)
+	walk = func(prefix string, commands []*Command) {
+		for _, command := range commands {
+			if command.Hidden {
+				continue
+			}
+			flags := command.VisibleFlags()
+			if !command.HideHelp && HelpFlag != nil {
+				flags = append(flags, HelpFlag)
+			}
+			for _, name := range command.Names() {
+				path := strings.TrimSpace(prefix + " " + name)
+				scopes = append(scopes, newCompletionScope(path, command.Subcommands, flags))
+				walk(path, command.Subcommands)
+			}
+		}
+	}
+	walk("", a.VisibleCommands())
+	return scopes
+}
+
+func sortedValueFlags(scope completionScope) []string {
+	names := make([]string, 0, len(scope.valueFlags))
+	for name := range scope.valueFlags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// completeFlagValues prints the dynamic values of the named flag, searching
+// the application and then every command.
+func (a *App) completeFlagValues(name string) error {
+	var find func(flags []Flag, commands []*Command) *StringFlag
+	find = func(flags []Flag, commands []*Command) *StringFlag {
+		for _, f := range flags {
+			if stringFlag, ok := f.(*StringFlag); ok && stringFlag.Complete != nil && stringFlag.Name == name {
+				return stringFlag
+			}
+		}
+		for _, command := range commands {
+			if found := find(command.Flags, command.Subcommands); found != nil {
+				return found
+			}
+		}
+		return nil
+	}
+	flag := find(a.Flags, a.Commands)
+	if flag == nil {
+		return nil
+	}
+	for _, value := range flag.Complete() {
+		if _, err := fmt.Fprintln(a.Writer, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func bashQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+func shellFunctionName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// ToBashCompletion creates a bash completion script for the `*App`.
+func (a *App) ToBashCompletion() (string, error) {
+	var w bytes.Buffer
+	if err := a.writeBashCompletion(&w); err != nil {
+		return "", err
+	}
+	return w.String(), nil
+}
+
+func (a *App) writeBashCompletion(w io.Writer) error {
+	function := "_" + shellFunctionName(a.Name) + "_completion"
+	scopes := a.completionScopes()
+	var b strings.Builder
+	fmt.Fprintf(&b, `# %s bash completion
+
+%s() {
+    local cur prev cmdpath word candidate i opts
+    COMPREPLY=()
+    cur="${COMP_WORDS[COMP_CWORD]}"
+    prev=""
+    if ((COMP_CWORD > 1)); then
+        prev="${COMP_WORDS[COMP_CWORD-1]}"
+    fi
+    cmdpath=""
+    for ((i = 1; i < COMP_CWORD; i++)); do
+        word="${COMP_WORDS[i]}"
+        [[ "$word" == -* ]] && continue
+        candidate="${cmdpath:+$cmdpath }$word"
+        case "$candidate" in
+`, a.Name, function)
+	for _, scope := range scopes[1:] {
+		fmt.Fprintf(&b, "            %s) cmdpath=\"$candidate\" ;;\n", bashQuote(scope.path))
+	}
+	b.WriteString(`        esac
+    done
+    case "$cmdpath:$prev" in
+`)
+	for _, scope := range scopes {
+		for _, name := range sortedValueFlags(scope) {
+			key := bashQuote(scope.path + ":" + name)
+			if dynamic := scope.valueFlags[name]; dynamic != "" {
+				fmt.Fprintf(&b, "        %s)\n            COMPREPLY=($(compgen -W \"$(%s %s %s 2>/dev/null)\" -- \"$cur\"))\n            return 0 ;;\n",
+					key, bashQuote(a.Name), CompleteValuesCommand, bashQuote(dynamic))
+			} else {
+				fmt.Fprintf(&b, "        %s) return 0 ;;\n", key)
+			}
+		}
+	}
+	b.WriteString(`    esac
+    case "$cmdpath" in
+`)
+	for _, scope := range scopes {
+		names := make([]string, len(scope.entries))
+		for i, entry := range scope.entries {
+			names[i] = entry.name
+		}
+		fmt.Fprintf(&b, "        %s) opts=%s ;;\n", bashQuote(scope.path), bashQuote(strings.Join(names, " ")))
+	}
+	fmt.Fprintf(&b, `    esac
+    COMPREPLY=($(compgen -W "$opts" -- "$cur"))
+}
+
+complete -o bashdefault -o default -F %s %s
+`, function, a.Name)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func zshDescribe(entry completionEntry) string {
+	value := strings.ReplaceAll(entry.name, ":", `\:`)
+	if entry.usage != "" {
+		value += ":" + entry.usage
+	}
+	return bashQuote(value)
+}
+
+// ToZshCompletion creates a zsh completion script for the `*App`.
+func (a *App) ToZshCompletion() (string, error) {
+	var w bytes.Buffer
+	if err := a.writeZshCompletion(&w); err != nil {
+		return "", err
+	}
+	return w.String(), nil
+}
+
+func (a *App) writeZshCompletion(w io.Writer) error {
+	function := "_" + shellFunctionName(a.Name)
+	scopes := a.completionScopes()
+	var b strings.Builder
+	fmt.Fprintf(&b, `#compdef %s
+# %s zsh completion
+
+%s() {
+    local cmdpath="" word candidate prev=""
+    local -a opts values
+    (( CURRENT > 2 )) && prev="${words[CURRENT-1]}"
+    for word in "${(@)words[2,CURRENT-1]}"; do
+        [[ "$word" == -* ]] && continue
+        candidate="${cmdpath:+$cmdpath }$word"
+        case "$candidate" in
+`, a.Name, a.Name, function)
+	for _, scope := range scopes[1:] {
+		fmt.Fprintf(&b, "            %s) cmdpath=\"$candidate\" ;;\n", bashQuote(scope.path))
+	}
+	b.WriteString(`        esac
+    done
+    case "$cmdpath:$prev" in
+`)
+	for _, scope := range scopes {
+		for _, name := range sortedValueFlags(scope) {
+			key := bashQuote(scope.path + ":" + name)
+			if dynamic := scope.valueFlags[name]; dynamic != "" {
+				fmt.Fprintf(&b, "        %s)\n            values=(\"${(@f)$(%s %s %s 2>/dev/null)}\")\n            compadd -a values\n            return ;;\n",
+					key, bashQuote(a.Name), CompleteValuesCommand, bashQuote(dynamic))
+			} else {
+				fmt.Fprintf(&b, "        %s) _files; return ;;\n", key)
+			}
+		}
+	}
+	b.WriteString(`    esac
+    case "$cmdpath" in
+`)
+	for _, scope := range scopes {
+		described := make([]string, len(scope.entries))
+		for i, entry := range scope.entries {
+			described[i] = zshDescribe(entry)
+		}
+		fmt.Fprintf(&b, "        %s) opts=(%s) ;;\n", bashQuote(scope.path), strings.Join(described, " "))
+	}
+	fmt.Fprintf(&b, `    esac
+    _describe -t commands '%s' opts || _files
+}
+
+compdef %s %s
+`, shellFunctionName(a.Name), function, a.Name)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func powerShellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// ToPowerShellCompletion creates a PowerShell argument completer for the
+// `*App`.
+func (a *App) ToPowerShellCompletion() (string, error) {
+	var w bytes.Buffer
+	if err := a.writePowerShellCompletion(&w); err != nil {
+		return "", err
+	}
+	return w.String(), nil
+}
+
+func (a *App) writePowerShellCompletion(w io.Writer) error {
+	scopes := a.completionScopes()
+	var b strings.Builder
+	fmt.Fprintf(&b, `# %s PowerShell completion
+
+Register-ArgumentCompleter -Native -CommandName %s -ScriptBlock {
+    param($wordToComplete, $commandAst, $cursorPosition)
+    $scopes = @{
+`, a.Name, powerShellQuote(a.Name))
+	for _, scope := range scopes {
+		fmt.Fprintf(&b, "        %s = @(\n", powerShellQuote(scope.path))
+		for _, entry := range scope.entries {
+			usage := entry.usage
+			if usage == "" {
+				usage = entry.name
+			}
+			fmt.Fprintf(&b, "            @{ Name = %s; Usage = %s }\n", powerShellQuote(entry.name), powerShellQuote(usage))
+		}
+		b.WriteString("        )\n")
+	}
+	b.WriteString("    }\n    $valueFlags = @{\n")
+	for _, scope := range scopes {
+		for _, name := range sortedValueFlags(scope) {
+			fmt.Fprintf(&b, "        %s = %s\n", powerShellQuote(scope.path+":"+name), powerShellQuote(scope.valueFlags[name]))
+		}
+	}
+	fmt.Fprintf(&b, `    }
+    $elements = @($commandAst.CommandElements | Select-Object -Skip 1 | ForEach-Object { $_.ToString() })
+    if ($wordToComplete -ne '' -and $elements.Count -gt 0) {
+        $elements = @($elements | Select-Object -SkipLast 1)
+    }
+    $path = ''
+    foreach ($element in $elements) {
+        if ($element.StartsWith('-')) { continue }
+        $candidate = if ($path) { "$path $element" } else { $element }
+        if ($scopes.ContainsKey($candidate)) { $path = $candidate }
+    }
+    $previous = if ($elements.Count -gt 0) { $elements[-1] } else { '' }
+    $key = "${path}:$previous"
+    if ($valueFlags.ContainsKey($key)) {
+        if ($valueFlags[$key]) {
+            & %s %s $valueFlags[$key] 2>$null | Where-Object { $_ -like "$wordToComplete*" } | ForEach-Object {
+                [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)
+            }
+        }
+        return
+    }
+    $scopes[$path] | Where-Object { $_.Name -like "$wordToComplete*" } | ForEach-Object {
+        $type = if ($_.Name.StartsWith('-')) { 'ParameterName' } else { 'Command' }
+        [System.Management.Automation.CompletionResult]::new($_.Name, $_.Name, $type, $_.Usage)
+    }
+}
+`, powerShellQuote(a.Name), CompleteValuesCommand)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
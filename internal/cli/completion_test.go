@@ -0,0 +1,68 @@
+package cli
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func newCompletionTestApp() *App {
+	app := NewApp()
+	app.Name = "croc"
+	app.EnableBashCompletion = true
+	app.Commands = []*Command{{
+		Name:  "send",
+		Usage: "send files",
+		Flags: []Flag{&StringFlag{Name: "code", Usage: "transfer code"}},
+	}}
+	app.Flags = []Flag{
+		&BoolFlag{Name: "quiet", Usage: "disable output"},
+		&StringFlag{Name: "relay", Usage: "relay address", Complete: func() []string {
+			return []string{"relay.example:9009", "tls://relay.example:9443"}
+		}},
+	}
+	app.Setup()
+	return app
+}
+
+func TestShellCompletionRenderers(t *testing.T) {
+	app := newCompletionTestApp()
+	for name, render := range map[string]func() (string, error){
+		"bash":       app.ToBashCompletion,
+		"zsh":        app.ToZshCompletion,
+		"powershell": app.ToPowerShellCompletion,
+	} {
+		completion, err := render()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, expected := range []string{"send", "--quiet", "--code", "__complete", "'relay'"} {
+			if !strings.Contains(completion, expected) {
+				t.Fatalf("%s completion does not contain %q:\n%s", name, expected, completion)
+			}
+		}
+		if strings.Contains(completion, "'code'") {
+			t.Fatalf("%s completion asks for dynamic --code values:\n%s", name, completion)
+		}
+	}
+}
+
+func TestCompleteFlagValues(t *testing.T) {
+	app := newCompletionTestApp()
+	var output bytes.Buffer
+	app.Writer = &output
+	if err := app.Run([]string{"croc", CompleteValuesCommand, "relay"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := output.String(); got != "relay.example:9009\ntls://relay.example:9443\n" {
+		t.Fatalf("unexpected relay values %q", got)
+	}
+
+	output.Reset()
+	if err := app.Run([]string{"croc", CompleteValuesCommand, "code"}); err != nil {
+		t.Fatal(err)
+	}
+	if output.Len() != 0 {
+		t.Fatalf("flag without completer printed %q", output.String())
+	}
+}
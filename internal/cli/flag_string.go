@@ -16,6 +16,8 @@ type StringFlag struct {
 	DefaultText string
 	Destination *string
 	HasBeenSet  bool
+	// Complete lists dynamic values for generated shell completions.
+	Complete FlagCompleteFunc
 }
 
 // IsSet returns whether or not the flag has been set through env or file
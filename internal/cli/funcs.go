@@ -3,6 +3,9 @@ package cli
 // BashCompleteFunc is an action to execute when the shell completion flag is set
 type BashCompleteFunc func(*Context)
 
+// FlagCompleteFunc lists the values offered when completing a flag's value
+type FlagCompleteFunc func() []string
+
 // BeforeFunc is an action to execute before any subcommands are run, but after
 // the context is ready if a non-nil error is returned, no subcommands are run
 type BeforeFunc func(*Context) error
@@ -2,6 +2,7 @@ package cli
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -20,6 +21,7 @@ import (
 	"github.com/schollz/croc/v11/src/croc"
 	"github.com/schollz/croc/v11/src/models"
 	"github.com/schollz/croc/v11/src/publicrelay"
+	"github.com/schollz/croc/v11/src/relaycluster"
 	"github.com/schollz/croc/v11/src/storeclient"
 	"github.com/schollz/croc/v11/src/tcp"
 	"github.com/schollz/croc/v11/src/termui"
@@ -75,7 +77,7 @@ func newApp() *cli.App {
 			Flags: []cli.Flag{
 				&cli.BoolFlag{Name: "zip", Usage: "zip folder before sending"},
 				&cli.StringFlag{Name: "code", Aliases: []string{"c"}, Usage: "codephrase used to connect to relay (at least 6 characters)"},
-				&cli.StringFlag{Name: "hash", Value: "xxhash", Usage: "hash algorithm (xxhash, imohash, md5, highway)"},
+				&cli.StringFlag{Name: "hash", Value: "xxhash", Usage: "hash algorithm (xxhash, imohash, md5, highway, merkle)"},
 				&cli.StringFlag{Name: "text", Aliases: []string{"t"}, Usage: "send some text"},
 				&cli.BoolFlag{Name: "no-local", Usage: "disable local relay when sending"},
 				&cli.BoolFlag{Name: "no-multi", Usage: "disable multiplexing"},
@@ -91,10 +93,28 @@ func newApp() *cli.App {
 				&cli.IntFlag{Name: "store-downloads", Value: 1, Usage: "number of verified downloads allowed in stored mode"},
 				&cli.StringFlag{Name: "store-expiration", Value: "1d", Usage: "stored lifetime after upload (for example 90m, 12h, 3d, or 2w)"},
 				&cli.StringFlag{Name: "store-url", Value: "https://getcroc.com", Usage: "stored-transfer service origin", EnvVars: []string{"CROC_STORE_URL"}},
+				&cli.StringFlag{Name: "store-notify", Usage: "URL the storage service POSTs to when a stored transfer is downloaded, revoked, or expires", EnvVars: []string{"CROC_STORE_NOTIFY"}},
+				&cli.StringFlag{Name: "store-request", Usage: "upload encrypted files to a recipient's file request link (implies --store)", EnvVars: []string{"CROC_STORE_REQUEST"}},
+				&cli.StringFlag{Name: "store-notify-key", Usage: "base64url 32-byte key used to seal the file names included in notifications", EnvVars: []string{"CROC_STORE_NOTIFY_KEY"}},
+				&cli.DurationFlag{Name: "wait-for-receiver", Usage: "reserve the room on the relay and keep waiting this long for the receiver (for example 8h)"},
+				&cli.StringFlag{Name: "reservation-token", Usage: "token the relay requires to reserve a room for --wait-for-receiver", EnvVars: []string{"CROC_RESERVATION_TOKEN"}},
 			},
 			HelpName: "croc send",
+			Before:   applyProfile,
 			Action:   send,
 		},
+		{
+			Name:        "request",
+			Usage:       "create a link that others can use to upload encrypted files to you",
+			Description: "create a stored-transfer file request; uploads are encrypted to a key only you hold",
+			HelpName:    "croc request",
+			Before:      applyProfile,
+			Action:      requestStored,
+			Flags: []cli.Flag{
+				&cli.StringFlag{Name: "store-expiration", Value: "1d", Usage: "how long the request accepts uploads (for example 90m, 12h, 3d, or 2w)"},
+				&cli.StringFlag{Name: "store-url", Value: "https://getcroc.com", Usage: "stored-transfer service origin", EnvVars: []string{"CROC_STORE_URL"}},
+			},
+		},
 		{
 			Name:        "relay",
 			Usage:       "start your own relay (optional)",
@@ -112,8 +132,31 @@ func newApp() *cli.App {
 				&cli.IntFlag{Name: "source-join-limit", Value: tcp.DEFAULT_SOURCE_JOIN_LIMIT, Usage: "maximum room joins per source IP in the admission window", EnvVars: []string{"CROC_SOURCE_JOIN_LIMIT"}},
 				&cli.IntFlag{Name: "room-join-limit", Value: tcp.DEFAULT_ROOM_JOIN_LIMIT, Usage: "maximum joins per room in the admission window", EnvVars: []string{"CROC_ROOM_JOIN_LIMIT"}},
 				&cli.DurationFlag{Name: "join-limit-window", Value: tcp.DEFAULT_JOIN_LIMIT_WINDOW, Usage: "sliding window for relay admission limits", EnvVars: []string{"CROC_JOIN_LIMIT_WINDOW"}},
+				&cli.StringFlag{Name: "event-log", Usage: "record room lifecycle events to a JSONL file, syslog, or syslog[+tcp]://host:port", EnvVars: []string{"CROC_RELAY_EVENT_LOG"}},
+				&cli.IntFlag{Name: "event-log-max-size", Value: 100, Usage: "rotate the event log file after this many megabytes (0 disables rotation)"},
+				&cli.IntFlag{Name: "event-log-backups", Value: 5, Usage: "rotated event log files to keep"},
+				&cli.StringFlag{Name: "tls-cert", Usage: "serve relay ports over TLS with this PEM certificate", EnvVars: []string{"CROC_RELAY_TLS_CERT"}},
+				&cli.StringFlag{Name: "tls-key", Usage: "PEM private key for --tls-cert", EnvVars: []string{"CROC_RELAY_TLS_KEY"}},
+				&cli.BoolFlag{Name: "tls-self-signed", Usage: "serve TLS with a persistent self-signed certificate that clients pin", EnvVars: []string{"CROC_RELAY_TLS_SELF_SIGNED"}},
+				&cli.BoolFlag{Name: "quic", Usage: "also accept transfers over QUIC on the UDP port of the first relay port", EnvVars: []string{"CROC_RELAY_QUIC"}},
+				&cli.StringSliceFlag{Name: "reservation-token", Usage: "accept room reservations from senders presenting this token (repeatable)", EnvVars: []string{"CROC_RELAY_RESERVATION_TOKENS"}},
+				&cli.DurationFlag{Name: "max-reservation-hold", Value: tcp.DEFAULT_MAX_RESERVATION_HOLD, Usage: "longest a reserved room waits for its peer", EnvVars: []string{"CROC_MAX_RESERVATION_HOLD"}},
+				&cli.IntFlag{Name: "max-reserved-rooms", Value: tcp.DEFAULT_MAX_RESERVED_ROOMS, Usage: "maximum reserved rooms waiting at once", EnvVars: []string{"CROC_MAX_RESERVED_ROOMS"}},
+				&cli.StringFlag{Name: "cluster", Usage: "share rooms with other relay nodes through this backend: redis://[user:password@]host:port[/db] or rediss://...", EnvVars: []string{"CROC_RELAY_CLUSTER"}},
+				&cli.StringFlag{Name: "cluster-host", Usage: "host other cluster nodes reach this relay at", EnvVars: []string{"CROC_RELAY_CLUSTER_HOST"}},
+				&cli.StringFlag{Name: "cluster-secret", Usage: "secret cluster nodes authenticate to each other with", EnvVars: []string{"CROC_RELAY_CLUSTER_SECRET"}},
+				&cli.StringFlag{Name: "tokens-file", Usage: "authenticate users with the per-user tokens of this file instead of --pass (reloaded on SIGHUP, see croc relay-token)", EnvVars: []string{relayTokensFileEnv}},
+				&cli.StringFlag{Name: "admin-socket", Usage: "serve a local admin socket at this path to inspect rooms and evict or ban peers (see croc relay-admin)", EnvVars: []string{relayAdminSocketEnv}},
 			},
 		},
+		newPackCommand(),
+		newUnpackCommand(),
+		newStoreCommand(),
+		newRelayTokenCommand(),
+		newRelayAdminCommand(),
+		newConfigCommand(),
+		newUpdateCommand(),
+		newCompletionCommand(),
 		{
 			Name:   "generate-fish-completion",
 			Usage:  "generate fish completion and output to stdout",
@@ -132,12 +175,16 @@ func newApp() *cli.App {
 		&cli.BoolFlag{Name: "internal-dns", Usage: "use a built-in DNS stub resolver rather than the host operating system"},
 		&cli.BoolFlag{Name: "classic", Usage: "toggle between the classic mode (insecure due to local attack vector) and new mode (secure)"},
 		&cli.BoolFlag{Name: "remember", Usage: "save these settings to reuse next time"},
+		&cli.StringFlag{Name: "profile", Usage: "use the settings of a named profile (see croc config)", EnvVars: []string{"CROC_PROFILE"}, Complete: completeProfiles},
 		&cli.BoolFlag{Name: "debug", Usage: "toggle debug mode"},
 		&cli.BoolFlag{Name: "yes", Usage: "automatically agree to all prompts"},
 		&cli.BoolFlag{Name: "stdout", Usage: "redirect file to stdout"},
 		&cli.BoolFlag{Name: "no-compress", Usage: "disable compression"},
 		&cli.BoolFlag{Name: "ask", Usage: "make sure sender and recipient are prompted"},
 		&cli.BoolFlag{Name: "local", Usage: "force to use only local connections"},
+		&cli.BoolFlag{Name: "no-direct", Usage: "always relay transfers instead of trying a direct peer-to-peer connection"},
+		&cli.BoolFlag{Name: "quic", Usage: "carry transfers over QUIC when the relay supports it, falling back to TCP"},
+		&cli.BoolFlag{Name: "tui", Usage: "show transfers in a full-screen view with per-file status and keys to pause, skip, and cancel"},
 		&cli.BoolFlag{Name: "ignore-stdin", Usage: "ignore piped stdin"},
 		&cli.BoolFlag{Name: "overwrite", Usage: "do not prompt to overwrite or resume"},
 		&cli.BoolFlag{Name: "rename", Usage: "receive files that already exist under a new name instead of prompting"},
@@ -145,14 +192,17 @@ func newApp() *cli.App {
 		&cli.BoolFlag{Name: "quiet", Usage: "disable all output"},
 		&cli.BoolFlag{Name: "disable-clipboard", Usage: "disable copy to clipboard"},
 		&cli.BoolFlag{Name: "extended-clipboard", Usage: "copy full command with secret as env variable to clipboard"},
-		&cli.StringFlag{Name: "revoke", Usage: "revoke a stored transfer using its local sender receipt"},
+		&cli.StringFlag{Name: "revoke", Usage: "revoke a stored transfer using its local sender receipt", Complete: completeStoreReceipts},
 		&cli.StringFlag{Name: "multicast", Value: "239.255.255.250", Usage: "multicast address to use for local discovery"},
+		&cli.StringFlag{Name: "discovery", Value: "multicast,mdns", Usage: "local discovery methods to use (" + strings.Join(croc.DiscoveryMethods, ", ") + ")", EnvVars: []string{"CROC_DISCOVERY"}},
 		&cli.StringFlag{Name: "curve", Value: "p256", Usage: "choose an encryption curve (" + strings.Join(pake.AvailableCurves(), ", ") + ")"},
+		&cli.BoolFlag{Name: "pq", Usage: "require a post-quantum hybrid (ML-KEM-768) key exchange with the peer"},
 		&cli.StringFlag{Name: "ip", Value: "", Usage: "set sender ip if known e.g. 10.0.0.1:9009, [::1]:9009"},
-		&cli.StringFlag{Name: "relay", Value: models.DEFAULT_RELAY, Usage: "address of the relay", EnvVars: []string{"CROC_RELAY"}},
-		&cli.StringFlag{Name: "relay6", Value: models.DEFAULT_RELAY6, Usage: "ipv6 address of the relay", EnvVars: []string{"CROC_RELAY6"}},
+		&cli.StringFlag{Name: "relay", Value: models.DEFAULT_RELAY, Usage: "address of the relay, tls://host:port[#sha256:fingerprint], or wss://croc-web-host[/ws]", EnvVars: []string{"CROC_RELAY"}, Complete: completeRelays},
+		&cli.StringFlag{Name: "relay6", Value: models.DEFAULT_RELAY6, Usage: "ipv6 address of the relay", EnvVars: []string{"CROC_RELAY6"}, Complete: completeRelays},
 		&cli.StringFlag{Name: "out", Value: ".", Usage: "specify an output folder to receive the file"},
 		&cli.StringFlag{Name: "pass", Value: models.DEFAULT_PASSPHRASE, Usage: "password for the relay", EnvVars: []string{"CROC_PASS"}},
+		&cli.StringFlag{Name: "relay-token", Usage: "your token for a relay that authenticates users by token (used instead of --pass)", EnvVars: []string{"CROC_RELAY_TOKEN"}},
 		&cli.StringFlag{Name: "socks5", Value: "", Usage: "add a socks5 proxy", EnvVars: []string{"SOCKS5_PROXY"}},
 		&cli.StringFlag{Name: "connect", Value: "", Usage: "add a http proxy", EnvVars: []string{"HTTP_PROXY"}},
 		&cli.StringFlag{Name: "throttleUpload", Value: "", Usage: "throttle the upload speed e.g. 500k"},
@@ -164,6 +214,9 @@ func newApp() *cli.App {
 		if c.Args().First() == "serve" {
 			return errors.New("the web server has moved to the standalone croc-web binary")
 		}
+		if err := applyProfile(c); err != nil {
+			return err
+		}
 		if c.IsSet("revoke") {
 			return revokeStored(c, c.String("revoke"))
 		}
@@ -459,7 +512,7 @@ func applyRememberedSendOptions(c *cli.Context, options *croc.Options, remembere
 	if !c.IsSet("code") {
 		options.SharedSecret = remembered.SharedSecret
 	}
-	if !c.IsSet("pass") && remembered.RelayPassword != "" {
+	if !setByUser(c, "pass") && remembered.RelayPassword != "" {
 		options.RelayPassword = remembered.RelayPassword
 	}
 	if !c.IsSet("overwrite") {
@@ -468,12 +521,27 @@ func applyRememberedSendOptions(c *cli.Context, options *croc.Options, remembere
 	if !c.IsSet("rename") {
 		options.Rename = remembered.Rename
 	}
-	if !c.IsSet("curve") && remembered.Curve != "" {
+	if !setByUser(c, "curve") && remembered.Curve != "" {
 		options.Curve = remembered.Curve
 	}
+	if !setByUser(c, "pq") {
+		options.PostQuantum = remembered.PostQuantum
+	}
 	if !c.IsSet("local") {
 		options.OnlyLocal = remembered.OnlyLocal
 	}
+	if !c.IsSet("no-direct") {
+		options.DisableDirect = remembered.DisableDirect
+	}
+	if !c.IsSet("quic") {
+		options.QUIC = remembered.QUIC
+	}
+	if !c.IsSet("tui") {
+		options.TUI = remembered.TUI
+	}
+	if !c.IsSet("discovery") && len(remembered.Discovery) > 0 {
+		options.Discovery = remembered.Discovery
+	}
 	if !c.IsSet("hash") {
 		options.HashAlgorithm = remembered.HashAlgorithm
 	}
@@ -483,11 +551,11 @@ func applyRememberedSendOptions(c *cli.Context, options *croc.Options, remembere
 	if !c.IsSet("disable-clipboard") {
 		options.DisableClipboard = remembered.DisableClipboard
 	}
-	if !c.IsSet("relay") && strings.HasPrefix(remembered.RelayAddress, "non-default:") {
+	if !setByUser(c, "relay") && strings.HasPrefix(remembered.RelayAddress, "non-default:") {
 		rememberedAddr := strings.TrimPrefix(remembered.RelayAddress, "non-default:")
 		options.RelayAddress = strings.TrimSpace(rememberedAddr)
 	}
-	if !c.IsSet("relay6") && strings.HasPrefix(remembered.RelayAddress6, "non-default:") {
+	if !setByUser(c, "relay6") && strings.HasPrefix(remembered.RelayAddress6, "non-default:") {
 		rememberedAddr := strings.TrimPrefix(remembered.RelayAddress6, "non-default:")
 		options.RelayAddress6 = strings.TrimSpace(rememberedAddr)
 	}
@@ -506,6 +574,18 @@ func parseRelayPorts(portsFlag string) []string {
 	return ports
 }
 
+// parseDiscovery splits a comma-separated --discovery value. Unknown methods
+// are rejected when the transfer client is created.
+func parseDiscovery(value string) []string {
+	var methods []string
+	for _, method := range strings.Split(value, ",") {
+		if method = strings.ToLower(strings.TrimSpace(method)); method != "" {
+			methods = append(methods, method)
+		}
+	}
+	return methods
+}
+
 func send(c *cli.Context) (err error) {
 	finishVersionCheck := startTransferVersionCheck(c)
 	defer finishVersionCheck()
@@ -513,7 +593,7 @@ func send(c *cli.Context) (err error) {
 	setDebugLevel(c)
 	comm.Socks5Proxy = c.String("socks5")
 	comm.HttpProxy = c.String("connect")
-	if c.Bool("store") {
+	if c.Bool("store") || strings.TrimSpace(c.String("store-request")) != "" {
 		return sendStored(c)
 	}
 
@@ -560,22 +640,36 @@ func send(c *cli.Context) (err error) {
 		Ask:               c.Bool("ask"),
 		NoMultiplexing:    c.Bool("no-multi"),
 		RelayPassword:     determinePass(c),
+		RelayToken:        strings.TrimSpace(c.String("relay-token")),
 		SendingText:       c.String("text") != "",
 		NoCompress:        c.Bool("no-compress"),
 		Overwrite:         c.Bool("overwrite"),
 		Rename:            c.Bool("rename"),
 		Curve:             c.String("curve"),
+		PostQuantum:       c.Bool("pq"),
 		HashAlgorithm:     c.String("hash"),
 		ThrottleUpload:    c.String("throttleUpload"),
 		ZipFolder:         c.Bool("zip"),
 		GitIgnore:         c.Bool("git"),
 		ShowQrCode:        c.Bool("qrcode"),
 		MulticastAddress:  c.String("multicast"),
+		Discovery:         parseDiscovery(c.String("discovery")),
+		DisableDirect:     c.Bool("no-direct"),
+		QUIC:              c.Bool("quic"),
+		TUI:               c.Bool("tui"),
 		Exclude:           excludeStrings,
 		ExcludeFile:       excludeFiles,
 		Quiet:             c.Bool("quiet"),
 		DisableClipboard:  c.Bool("disable-clipboard"),
 		ExtendedClipboard: c.Bool("extended-clipboard"),
+		WaitForReceiver:   c.Duration("wait-for-receiver"),
+		ReservationToken:  strings.TrimSpace(c.String("reservation-token")),
+	}
+	if crocOptions.WaitForReceiver < 0 {
+		return fmt.Errorf("--wait-for-receiver must not be negative")
+	}
+	if crocOptions.WaitForReceiver > 0 && crocOptions.ReservationToken == "" {
+		return fmt.Errorf("--wait-for-receiver requires --reservation-token")
 	}
 	if crocOptions.RelayAddress != models.DEFAULT_RELAY {
 		crocOptions.RelayAddress6 = ""
@@ -841,13 +935,19 @@ func receive(c *cli.Context) (err error) {
 		Stdout:            c.Bool("stdout"),
 		Ask:               c.Bool("ask"),
 		RelayPassword:     determinePass(c),
+		RelayToken:        strings.TrimSpace(c.String("relay-token")),
 		OnlyLocal:         c.Bool("local"),
 		IP:                c.String("ip"),
 		Overwrite:         c.Bool("overwrite"),
 		Rename:            c.Bool("rename"),
 		Curve:             c.String("curve"),
+		PostQuantum:       c.Bool("pq"),
 		TestFlag:          c.Bool("testing"),
 		MulticastAddress:  c.String("multicast"),
+		Discovery:         parseDiscovery(c.String("discovery")),
+		DisableDirect:     c.Bool("no-direct"),
+		QUIC:              c.Bool("quic"),
+		TUI:               c.Bool("tui"),
 		Quiet:             c.Bool("quiet"),
 		DisableClipboard:  c.Bool("disable-clipboard"),
 		ExtendedClipboard: c.Bool("extended-clipboard"),
@@ -909,7 +1009,7 @@ Run croc with no argument and paste the link at the prompt, or use:
 		if crocOptions.SharedSecret == "" {
 			crocOptions.SharedSecret = rememberedOptions.SharedSecret
 		}
-		if !c.IsSet("pass") && rememberedOptions.RelayPassword != "" {
+		if !setByUser(c, "pass") && rememberedOptions.RelayPassword != "" {
 			crocOptions.RelayPassword = rememberedOptions.RelayPassword
 		}
 		if !c.IsSet("overwrite") {
@@ -918,18 +1018,33 @@ Run croc with no argument and paste the link at the prompt, or use:
 		if !c.IsSet("rename") {
 			crocOptions.Rename = rememberedOptions.Rename
 		}
-		if !c.IsSet("curve") && rememberedOptions.Curve != "" {
+		if !setByUser(c, "curve") && rememberedOptions.Curve != "" {
 			crocOptions.Curve = rememberedOptions.Curve
 		}
+		if !setByUser(c, "pq") {
+			crocOptions.PostQuantum = rememberedOptions.PostQuantum
+		}
 		if !c.IsSet("local") {
 			crocOptions.OnlyLocal = rememberedOptions.OnlyLocal
 		}
-		if !c.IsSet("relay") && strings.HasPrefix(rememberedOptions.RelayAddress, "non-default:") {
+		if !c.IsSet("no-direct") {
+			crocOptions.DisableDirect = rememberedOptions.DisableDirect
+		}
+		if !c.IsSet("quic") {
+			crocOptions.QUIC = rememberedOptions.QUIC
+		}
+		if !c.IsSet("tui") {
+			crocOptions.TUI = rememberedOptions.TUI
+		}
+		if !c.IsSet("discovery") && len(rememberedOptions.Discovery) > 0 {
+			crocOptions.Discovery = rememberedOptions.Discovery
+		}
+		if !setByUser(c, "relay") && strings.HasPrefix(rememberedOptions.RelayAddress, "non-default:") {
 			var rememberedAddr = strings.TrimPrefix(rememberedOptions.RelayAddress, "non-default:")
 			rememberedAddr = strings.TrimSpace(rememberedAddr)
 			crocOptions.RelayAddress = rememberedAddr
 		}
-		if !c.IsSet("relay6") && strings.HasPrefix(rememberedOptions.RelayAddress6, "non-default:") {
+		if !setByUser(c, "relay6") && strings.HasPrefix(rememberedOptions.RelayAddress6, "non-default:") {
 			var rememberedAddr = strings.TrimPrefix(rememberedOptions.RelayAddress6, "non-default:")
 			rememberedAddr = strings.TrimSpace(rememberedAddr)
 			crocOptions.RelayAddress6 = rememberedAddr
@@ -1031,10 +1146,23 @@ func relay(c *cli.Context) (err error) {
 	if joinLimitWindow <= 0 {
 		return fmt.Errorf("--join-limit-window must be positive")
 	}
+	maxReservationHold := c.Duration("max-reservation-hold")
+	if maxReservationHold <= 0 {
+		return fmt.Errorf("--max-reservation-hold must be positive")
+	}
+	maxReservedRooms := c.Int("max-reserved-rooms")
+	if maxReservedRooms <= 0 {
+		return fmt.Errorf("--max-reserved-rooms must be positive")
+	}
 	debugString := "info"
 	if c.Bool("debug") {
 		debugString = "debug"
 	}
+	relayAuth, stopRelayAuth, err := watchRelayTokens(strings.TrimSpace(c.String("tokens-file")))
+	if err != nil {
+		return err
+	}
+	defer stopRelayAuth()
 	host := c.String("host")
 	var ports []string
 
@@ -1054,9 +1182,39 @@ func relay(c *cli.Context) (err error) {
 			ports[i] = strconv.Itoa(portString + i)
 		}
 	}
-	if len(ports) < 2 {
+	tlsConfig, err := relayTLSConfig(c, host)
+	if err != nil {
+		return err
+	}
+	if len(ports) < 2 && (tlsConfig == nil || len(ports) == 0) {
 		return fmt.Errorf("relay requires at least two ports; specify --ports with two or more ports or set --transfers to 2+")
 	}
+	clusterBackend, err := openRelayCluster(c, tlsConfig != nil)
+	if err != nil {
+		return err
+	}
+	if clusterBackend != nil {
+		defer clusterBackend.Close()
+	}
+	clusterHost, clusterSecret := strings.TrimSpace(c.String("cluster-host")), c.String("cluster-secret")
+	group := tcp.NewGroup()
+	stopRelayAdmin, err := serveRelayAdmin(strings.TrimSpace(c.String("admin-socket")), group)
+	if err != nil {
+		return err
+	}
+	defer stopRelayAdmin()
+
+	roomEvents, closeRoomEvents, err := openRelayEventLog(
+		strings.TrimSpace(c.String("event-log")),
+		c.Int("event-log-max-size"),
+		c.Int("event-log-backups"),
+	)
+	if err != nil {
+		return err
+	}
+	if closeRoomEvents != nil {
+		defer closeRoomEvents()
+	}
 
 	var roomPaired func()
 	umamiURL := strings.TrimSpace(os.Getenv("UMAMI_URL"))
@@ -1080,6 +1238,10 @@ func relay(c *cli.Context) (err error) {
 	}
 
 	tcpPorts := strings.Join(ports[1:], ",")
+	if len(ports) == 1 {
+		// A single TLS port, such as 443, also carries the transfer rooms.
+		tcpPorts = ports[0]
+	}
 	for i, port := range ports {
 		if i == 0 {
 			continue
@@ -1094,6 +1256,11 @@ func relay(c *cli.Context) (err error) {
 				tcp.WithMaxPendingHandshakes(maxPendingHandshakes),
 				tcp.WithHandshakeTimeout(handshakeTimeout),
 				tcp.WithAdmissionLimits(sourceJoinLimit, roomJoinLimit, joinLimitWindow),
+				tcp.WithRoomEventSink(roomEvents),
+				tcp.WithTLS(tlsConfig),
+				tcp.WithRelayAuth(relayAuth),
+				tcp.WithCluster(clusterBackend, clusterHost, clusterSecret),
+				tcp.WithGroup(group),
 			)
 			if err != nil {
 				panic(err)
@@ -1111,5 +1278,101 @@ func relay(c *cli.Context) (err error) {
 		tcp.WithHandshakeTimeout(handshakeTimeout),
 		tcp.WithAdmissionLimits(sourceJoinLimit, roomJoinLimit, joinLimitWindow),
 		tcp.WithRoomPairedCallback(roomPaired),
+		tcp.WithRoomEventSink(roomEvents),
+		tcp.WithTLS(tlsConfig),
+		tcp.WithQUIC(c.Bool("quic")),
+		tcp.WithReservations(c.StringSlice("reservation-token"), maxReservationHold, maxReservedRooms),
+		tcp.WithRelayAuth(relayAuth),
+		tcp.WithCluster(clusterBackend, clusterHost, clusterSecret),
+		tcp.WithGroup(group),
 	)
 }
+
+// openRelayCluster connects to the backend named by --cluster. It returns a
+// nil backend when the relay runs standalone.
+func openRelayCluster(c *cli.Context, tlsEnabled bool) (relaycluster.Backend, error) {
+	backendURL := strings.TrimSpace(c.String("cluster"))
+	if backendURL == "" {
+		return nil, nil
+	}
+	if strings.TrimSpace(c.String("cluster-host")) == "" {
+		return nil, fmt.Errorf("--cluster requires --cluster-host")
+	}
+	if strings.TrimSpace(c.String("cluster-secret")) == "" {
+		return nil, fmt.Errorf("--cluster requires --cluster-secret")
+	}
+	if tlsEnabled {
+		return nil, fmt.Errorf("--cluster cannot be used with TLS relay ports")
+	}
+	backend, err := relaycluster.Open(backendURL)
+	if err != nil {
+		return nil, fmt.Errorf("could not open relay cluster backend: %w", err)
+	}
+	return backend, nil
+}
+
+// relayTLSConfig builds the relay's TLS configuration from --tls-cert,
+// --tls-key, and --tls-self-signed. It returns nil when TLS is disabled.
+func relayTLSConfig(c *cli.Context, host string) (*tls.Config, error) {
+	certFile := strings.TrimSpace(c.String("tls-cert"))
+	keyFile := strings.TrimSpace(c.String("tls-key"))
+	selfSigned := c.Bool("tls-self-signed")
+	if certFile == "" && keyFile == "" && !selfSigned {
+		return nil, nil
+	}
+	if (certFile == "") != (keyFile == "") {
+		return nil, fmt.Errorf("--tls-cert and --tls-key must be used together")
+	}
+	if certFile == "" {
+		configDir, err := utils.GetConfigDir(true)
+		if err != nil {
+			return nil, err
+		}
+		certFile = filepath.Join(configDir, "relay-tls.crt")
+		keyFile = filepath.Join(configDir, "relay-tls.key")
+	}
+	certificate, err := tcp.LoadCertificate(certFile, keyFile, selfSigned, host, "localhost")
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("relay TLS certificate fingerprint %s", comm.Fingerprint(certificate.Certificate[0]))
+	return &tls.Config{Certificates: []tls.Certificate{certificate}, MinVersion: tls.VersionTLS12}, nil
+}
+
+// openRelayEventLog opens the sink named by --event-log. It returns a nil sink
+// when event logging is disabled.
+func openRelayEventLog(target string, maxMegabytes, backups int) (tcp.RoomEventSink, func() error, error) {
+	if target == "" {
+		return nil, nil, nil
+	}
+	if maxMegabytes < 0 {
+		return nil, nil, fmt.Errorf("--event-log-max-size must not be negative")
+	}
+	if backups < 0 {
+		return nil, nil, fmt.Errorf("--event-log-backups must not be negative")
+	}
+	network, address := "", ""
+	switch {
+	case target == "syslog":
+	case strings.HasPrefix(target, "syslog://"):
+		network, address = "udp", strings.TrimPrefix(target, "syslog://")
+	case strings.HasPrefix(target, "syslog+udp://"):
+		network, address = "udp", strings.TrimPrefix(target, "syslog+udp://")
+	case strings.HasPrefix(target, "syslog+tcp://"):
+		network, address = "tcp", strings.TrimPrefix(target, "syslog+tcp://")
+	default:
+		file, err := tcp.OpenRoomEventFile(target, int64(maxMegabytes)<<20, backups)
+		if err != nil {
+			return nil, nil, fmt.Errorf("open relay event log: %w", err)
+		}
+		return file, file.Close, nil
+	}
+	sink, err := tcp.DialRoomEventSyslog(network, address, "croc-relay")
+	if err != nil {
+		return nil, nil, fmt.Errorf("open relay event log: %w", err)
+	}
+	if closer, ok := sink.(interface{ Close() error }); ok {
+		return sink, closer.Close, nil
+	}
+	return sink, nil, nil
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -186,6 +187,113 @@ func TestRelayRejectsNonPositiveAdmissionLimits(t *testing.T) {
 	}
 }
 
+func TestRelayRejectsNonPositiveReservationLimits(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want string
+	}{
+		{arg: "--max-reservation-hold=0s", want: "--max-reservation-hold must be positive"},
+		{arg: "--max-reserved-rooms=0", want: "--max-reserved-rooms must be positive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.arg, func(t *testing.T) {
+			for _, key := range []string{"CROC_MAX_RESERVATION_HOLD", "CROC_MAX_RESERVED_ROOMS"} {
+				unsetEnv(t, key)
+			}
+			err := newApp().Run([]string{"croc", "relay", tt.arg})
+			if err == nil || err.Error() != tt.want {
+				t.Fatalf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRelayClusterRequiresNodeSettings(t *testing.T) {
+	for _, key := range []string{"CROC_RELAY_CLUSTER", "CROC_RELAY_CLUSTER_HOST", "CROC_RELAY_CLUSTER_SECRET"} {
+		unsetEnv(t, key)
+	}
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unreachable := "redis://:hunter2@" + probe.Addr().String()
+	probe.Close()
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{args: []string{"--cluster", unreachable}, want: "--cluster requires --cluster-host"},
+		{args: []string{"--cluster", unreachable, "--cluster-host", "10.0.0.5"}, want: "--cluster requires --cluster-secret"},
+		{args: []string{"--cluster", unreachable, "--cluster-host", "10.0.0.5", "--cluster-secret", "s3cret"}, want: "could not open relay cluster backend"},
+	}
+	for _, tt := range tests {
+		err := newApp().Run(append([]string{"croc", "relay"}, tt.args...))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Fatalf("relay %v: error = %v, want %q", tt.args, err, tt.want)
+		}
+		if strings.Contains(err.Error(), "hunter2") {
+			t.Fatalf("error leaks the backend password: %v", err)
+		}
+	}
+}
+
+func TestSendWaitForReceiverRequiresReservationToken(t *testing.T) {
+	unsetEnv(t, "CROC_RESERVATION_TOKEN")
+	err := newApp().Run([]string{
+		"croc",
+		"--ignore-stdin",
+		"send",
+		"--wait-for-receiver=8h",
+		"unused-file",
+	})
+	if err == nil || err.Error() != "--wait-for-receiver requires --reservation-token" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestOpenRelayEventLog(t *testing.T) {
+	sink, closeSink, err := openRelayEventLog("", 100, 5)
+	if err != nil || sink != nil || closeSink != nil {
+		t.Fatalf("disabled event log = %v, %v", sink, err)
+	}
+	if _, _, err = openRelayEventLog("rooms.jsonl", -1, 5); err == nil {
+		t.Fatal("negative event log size unexpectedly succeeded")
+	}
+
+	path := filepath.Join(t.TempDir(), "rooms.jsonl")
+	sink, closeSink, err = openRelayEventLog(path, 100, 5)
+	if err != nil {
+		t.Fatalf("open event log: %v", err)
+	}
+	if err = sink.WriteRoomEvent(tcp.RoomEvent{Event: tcp.RoomOpened}); err != nil {
+		t.Fatalf("write event: %v", err)
+	}
+	if err = closeSink(); err != nil {
+		t.Fatalf("close event log: %v", err)
+	}
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read event log: %v", err)
+	}
+	if !strings.Contains(string(contents), `"event":"opened"`) {
+		t.Fatalf("unexpected event log contents: %s", contents)
+	}
+}
+
+func TestRelayTLSRequiresCertificateAndKey(t *testing.T) {
+	for _, key := range []string{"CROC_RELAY_TLS_CERT", "CROC_RELAY_TLS_KEY", "CROC_RELAY_TLS_SELF_SIGNED"} {
+		unsetEnv(t, key)
+	}
+	err := newApp().Run([]string{"croc", "relay", "--tls-cert", "relay.crt"})
+	if err == nil || err.Error() != "--tls-cert and --tls-key must be used together" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = newApp().Run([]string{"croc", "relay", "--ports", "9443"})
+	if err == nil || !strings.Contains(err.Error(), "at least two ports") {
+		t.Fatalf("single plain relay port unexpectedly accepted: %v", err)
+	}
+}
+
 func runRelayWithCapturedMaxRooms(t *testing.T, args []string) int {
 	t.Helper()
 	return runRelayWithCapturedConfig(t, args).maxRoomsOpen
@@ -427,6 +535,19 @@ func TestStoredSendRejectsNonPositiveDownloadCount(t *testing.T) {
 	}
 }
 
+func TestStoredSendRejectsInvalidFileRequest(t *testing.T) {
+	err := newApp().Run([]string{
+		"croc",
+		"--ignore-stdin",
+		"send",
+		"--store-request=https://example.com/r/not-a-request",
+		"unused-file",
+	})
+	if err == nil || !strings.Contains(err.Error(), "invalid --store-request") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
 func TestServeIsNotRegistered(t *testing.T) {
 	for _, command := range newApp().Commands {
 		if command.Name == "serve" {
@@ -481,6 +602,15 @@ func TestParseRelayPorts(t *testing.T) {
 	}
 }
 
+func TestParseDiscovery(t *testing.T) {
+	if got := parseDiscovery(" Multicast, mdns,"); !reflect.DeepEqual(got, []string{"multicast", "mdns"}) {
+		t.Fatalf("parseDiscovery = %#v", got)
+	}
+	if got := parseDiscovery(""); got != nil {
+		t.Fatalf("parseDiscovery(\"\") = %#v, want nil", got)
+	}
+}
+
 func TestResolveSendSharedSecret(t *testing.T) {
 	t.Run("uses env secret", func(t *testing.T) {
 		got := resolveSendSharedSecret("generated-secret", "password-example")
@@ -0,0 +1,93 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/schollz/croc/v11/internal/cli"
+	"github.com/schollz/croc/v11/src/croc"
+	"github.com/schollz/croc/v11/src/models"
+)
+
+var completionShells = []string{"bash", "zsh", "fish", "powershell"}
+
+func newCompletionCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "completion",
+		Usage:     "print a shell completion script (" + strings.Join(completionShells, ", ") + ")",
+		ArgsUsage: "<shell>",
+		Action: func(c *cli.Context) error {
+			var (
+				script string
+				err    error
+			)
+			switch shell := c.Args().First(); shell {
+			case "bash":
+				script, err = c.App.ToBashCompletion()
+			case "zsh":
+				script, err = c.App.ToZshCompletion()
+			case "fish":
+				script, err = c.App.ToFishCompletion()
+			case "powershell", "pwsh":
+				script, err = c.App.ToPowerShellCompletion()
+			default:
+				return fmt.Errorf("usage: croc completion <%s>", strings.Join(completionShells, "|"))
+			}
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprint(c.App.Writer, script)
+			return err
+		},
+	}
+}
+
+// completeRelays offers the default relays and any relay remembered by
+// --remember for send or receive.
+func completeRelays() []string {
+	relays := map[string]bool{models.DEFAULT_RELAY: true, models.DEFAULT_RELAY6: true}
+	receiveConfig, _ := getReceiveConfigFile(false)
+	for _, configFile := range []string{getSendConfigFile(false), receiveConfig} {
+		if configFile == "" {
+			continue
+		}
+		contents, err := os.ReadFile(configFile)
+		if err != nil {
+			continue
+		}
+		var remembered croc.Options
+		if json.Unmarshal(contents, &remembered) != nil {
+			continue
+		}
+		for _, address := range []string{remembered.RelayAddress, remembered.RelayAddress6} {
+			if value, ok := strings.CutPrefix(address, "non-default:"); ok && strings.TrimSpace(value) != "" {
+				relays[strings.TrimSpace(value)] = true
+			}
+		}
+	}
+	values := make([]string, 0, len(relays))
+	for relay := range relays {
+		// The defaults are blank when their names could not be resolved.
+		if relay != "" {
+			values = append(values, relay)
+		}
+	}
+	sort.Strings(values)
+	return values
+}
+
+// completeStoreReceipts offers the transfer IDs that --revoke can revoke.
+func completeStoreReceipts() []string {
+	receipts, err := readStoreReceipts()
+	if err != nil {
+		return nil
+	}
+	ids := make([]string, len(receipts))
+	for i, receipt := range receipts {
+		ids[i] = receipt.ID
+	}
+	return ids
+}
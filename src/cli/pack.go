@@ -0,0 +1,178 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/schollz/croc/v11/internal/cli"
+	"github.com/schollz/croc/v11/src/storeclient"
+	"github.com/schollz/croc/v11/src/termui"
+	"github.com/schollz/croc/v11/src/utils"
+)
+
+// packSecretEnv supplies an archive token or passphrase without a prompt and
+// without exposing it in the process list.
+const packSecretEnv = "CROC_PACK_SECRET"
+
+func newPackCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "pack",
+		Usage:       "encrypt files into a single archive for offline hand-over",
+		Description: "encrypt regular files into one self-contained archive, unlocked by a token or a passphrase",
+		ArgsUsage:   "[filename(s)] -o [archive]",
+		HelpName:    "croc pack",
+		Action:      packArchive,
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: "output", Aliases: []string{"o"}, Usage: "archive file to write"},
+			&cli.BoolFlag{Name: "passphrase", Usage: "protect the archive with a passphrase instead of a random token (read from " + packSecretEnv + " or a prompt)"},
+		},
+	}
+}
+
+func newUnpackCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "unpack",
+		Usage:       "decrypt and verify the files of an archive made by croc pack",
+		Description: "decrypt and verify an archive; the token or passphrase is read from " + packSecretEnv + " or a prompt",
+		ArgsUsage:   "[archive]",
+		HelpName:    "croc unpack",
+		Action:      unpackArchive,
+	}
+}
+
+func packArchive(c *cli.Context) error {
+	setDebugLevel(c)
+	paths := c.Args().Slice()
+	output := strings.TrimSpace(c.String("output"))
+	if len(paths) == 0 || output == "" {
+		return errors.New("usage: croc pack [filename(s)] -o [archive]")
+	}
+	if _, err := os.Stat(output); err == nil && !c.Bool("overwrite") {
+		return fmt.Errorf("%s already exists (use --overwrite)", output)
+	}
+	var options storeclient.PackOptions
+	if c.Bool("passphrase") {
+		passphrase, err := readPackPassphrase()
+		if err != nil {
+			return err
+		}
+		options.Passphrase = passphrase
+	}
+	result, err := storeclient.Pack(
+		context.Background(),
+		paths,
+		output,
+		options,
+		storedCallbacks(c.Bool("quiet")),
+	)
+	if !c.Bool("quiet") {
+		fmt.Fprintln(os.Stderr)
+	}
+	if err != nil {
+		return err
+	}
+	terminalOutput, colorEnabled := termui.Output(os.Stderr)
+	fmt.Fprint(terminalOutput, formatPackInstructions(output, result, colorEnabled))
+	return nil
+}
+
+func formatPackInstructions(output string, result storeclient.PackResult, colorEnabled bool) string {
+	var total int64
+	for _, file := range result.Manifest.Files {
+		total += file.Size
+	}
+	files := fmt.Sprintf("%d files", len(result.Manifest.Files))
+	if len(result.Manifest.Files) == 1 {
+		files = "1 file"
+	}
+	summary := termui.Success(
+		fmt.Sprintf("Encrypted %s (%s) into %s.", files, utils.ByteCountDecimal(total), output),
+		colorEnabled,
+	)
+	if result.Token == "" {
+		return fmt.Sprintf(`%s
+
+%s
+    croc unpack %s
+    and enter the passphrase.
+`,
+			summary,
+			termui.Emphasis("To unpack:", colorEnabled),
+			output,
+		)
+	}
+	return fmt.Sprintf(`%s
+
+%s
+    croc unpack %s
+    then paste this token:
+    %s
+`,
+		summary,
+		termui.Emphasis("To unpack:", colorEnabled),
+		output,
+		termui.Secret(result.Token, colorEnabled),
+	)
+}
+
+func readPackPassphrase() (string, error) {
+	if passphrase := strings.TrimSpace(os.Getenv(packSecretEnv)); passphrase != "" {
+		return passphrase, nil
+	}
+	passphrase, err := utils.GetInput("Enter a passphrase for the archive: ")
+	if err != nil {
+		return "", err
+	}
+	if passphrase == "" {
+		return "", errors.New("archive passphrase is required")
+	}
+	confirmation, err := utils.GetInput("Enter the passphrase again: ")
+	if err != nil {
+		return "", err
+	}
+	if confirmation != passphrase {
+		return "", errors.New("passphrases do not match")
+	}
+	return passphrase, nil
+}
+
+func unpackArchive(c *cli.Context) error {
+	setDebugLevel(c)
+	if c.Args().Len() != 1 {
+		return errors.New("usage: croc unpack [archive]")
+	}
+	if c.Bool("stdout") {
+		return errors.New("--stdout is not supported for archives")
+	}
+	archive, err := storeclient.OpenArchive(c.Args().First())
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+	secret := strings.TrimSpace(os.Getenv(packSecretEnv))
+	if secret == "" {
+		prompt := "Enter the archive token: "
+		if archive.UsesPassphrase() {
+			prompt = "Enter the archive passphrase: "
+		}
+		if secret, err = utils.GetInput(prompt); err != nil {
+			return err
+		}
+	}
+	if err = archive.Unlock(secret); err != nil {
+		return err
+	}
+	output, err := confirmStoredManifest(c, "archive", time.Time{}, archive.Manifest)
+	if err != nil {
+		return err
+	}
+	err = archive.Extract(context.Background(), output, storedCallbacks(c.Bool("quiet")))
+	if !c.Bool("quiet") {
+		fmt.Fprintln(os.Stderr)
+	}
+	return err
+}
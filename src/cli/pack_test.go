@@ -0,0 +1,45 @@
+package cli
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPackAndUnpackCommandsRoundTrip(t *testing.T) {
+	t.Setenv("CROC_CONFIG_DIR", t.TempDir())
+	t.Setenv(packSecretEnv, "correct horse battery staple")
+	input := filepath.Join(t.TempDir(), "notes.txt")
+	want := []byte("handed over on a USB stick\n")
+	if err := os.WriteFile(input, want, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(t.TempDir(), "bundle.croc")
+	output := t.TempDir()
+	run := func(args ...string) error {
+		return newApp().Run(append([]string{"croc", "--quiet"}, args...))
+	}
+
+	if err := run("pack", "--passphrase", "-o", archive, input); err != nil {
+		t.Fatal(err)
+	}
+	if err := run("pack", "--passphrase", "-o", archive, input); err == nil {
+		t.Fatal("expected pack to refuse replacing an existing archive")
+	}
+	if err := run("--yes", "--out", output, "unpack", archive); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(filepath.Join(output, "notes.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("unpacked %q, want %q", got, want)
+	}
+
+	t.Setenv(packSecretEnv, "wrong passphrase")
+	if err = run("--yes", "--out", t.TempDir(), "unpack", archive); err == nil {
+		t.Fatal("expected a wrong passphrase to be rejected")
+	}
+}
@@ -0,0 +1,328 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/schollz/croc/v11/internal/cli"
+	"github.com/schollz/croc/v11/src/utils"
+	log "github.com/schollz/logger"
+)
+
+// profileSettings lists the flags a profile may set, in display order.
+var profileSettings = []string{
+	"relay",
+	"relay6",
+	"port",
+	"transfers",
+	"pass",
+	"relay-token",
+	"curve",
+	"pq",
+	"socks5",
+	"connect",
+	"store-url",
+	"throttleUpload",
+}
+
+var profileNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// profileFile is the on-disk form of profiles.json. Each profile maps flag
+// names to the values used when the flag is not given explicitly.
+type profileFile struct {
+	Profiles map[string]map[string]string `json:"profiles"`
+}
+
+func profilesPath(require bool) (string, error) {
+	directory, err := utils.GetConfigDir(require)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(directory, "profiles.json"), nil
+}
+
+func readProfiles() (profileFile, error) {
+	profiles := profileFile{Profiles: map[string]map[string]string{}}
+	path, err := profilesPath(false)
+	if err != nil {
+		return profiles, err
+	}
+	bytes, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return profiles, nil
+	}
+	if err != nil {
+		return profiles, err
+	}
+	if err = json.Unmarshal(bytes, &profiles); err != nil {
+		return profiles, fmt.Errorf("could not parse %s: %w", path, err)
+	}
+	if profiles.Profiles == nil {
+		profiles.Profiles = map[string]map[string]string{}
+	}
+	return profiles, nil
+}
+
+func writeProfiles(profiles profileFile) error {
+	path, err := profilesPath(true)
+	if err != nil {
+		return err
+	}
+	bytes, err := json.MarshalIndent(profiles, "", "    ")
+	if err != nil {
+		return err
+	}
+	return writePrivateConfigFile(path, append(bytes, '\n'))
+}
+
+func isProfileSetting(key string) bool {
+	for _, setting := range profileSettings {
+		if setting == key {
+			return true
+		}
+	}
+	return false
+}
+
+func validateProfileName(name string) error {
+	if !profileNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid profile name %q: use letters, digits, '.', '_' or '-'", name)
+	}
+	return nil
+}
+
+func validateProfileSetting(key, value string) error {
+	if !isProfileSetting(key) {
+		return fmt.Errorf("unknown profile setting %q (available: %s)", key, strings.Join(profileSettings, ", "))
+	}
+	if value == "" {
+		return nil
+	}
+	switch key {
+	case "port", "transfers":
+		if n, err := strconv.Atoi(value); err != nil || n <= 0 {
+			return fmt.Errorf("profile setting %s must be a positive number", key)
+		}
+	case "pq":
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("profile setting %s must be true or false", key)
+		}
+	}
+	return nil
+}
+
+// profileMetadataKey names the App.Metadata entry that records the flags
+// applyProfile filled in.
+const profileMetadataKey = "profile-flags"
+
+// applyProfile fills in flags from the profile selected with --profile. It
+// runs only for the commands that transfer files: send, request, and the
+// receive action of croc itself. Flags given on the command line or through
+// the environment keep their values, and settings for flags that neither the
+// command nor croc defines are skipped. Remembered settings still take
+// precedence, see setByUser.
+func applyProfile(c *cli.Context) error {
+	name := strings.TrimSpace(c.String("profile"))
+	if name == "" {
+		return nil
+	}
+	profiles, err := readProfiles()
+	if err != nil {
+		return err
+	}
+	settings, ok := profiles.Profiles[name]
+	if !ok {
+		return fmt.Errorf("profile %q does not exist (see croc config list)", name)
+	}
+	applied := map[string]bool{}
+	for _, key := range profileSettings {
+		value, ok := settings[key]
+		if !ok || c.IsSet(key) {
+			continue
+		}
+		for _, ctx := range c.Lineage() {
+			if ctx.Set(key, value) == nil {
+				log.Debugf("profile %s sets --%s", name, key)
+				applied[key] = true
+				break
+			}
+		}
+	}
+	if c.App.Metadata == nil {
+		c.App.Metadata = map[string]interface{}{}
+	}
+	c.App.Metadata[profileMetadataKey] = applied
+	return nil
+}
+
+// setByUser reports whether a flag was given on the command line or through
+// the environment rather than filled in from a profile, so that remembered
+// settings replace profile values but not explicit ones.
+func setByUser(c *cli.Context, key string) bool {
+	if !c.IsSet(key) {
+		return false
+	}
+	applied, _ := c.App.Metadata[profileMetadataKey].(map[string]bool)
+	return !applied[key]
+}
+
+func profileNames() ([]string, error) {
+	profiles, err := readProfiles()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(profiles.Profiles))
+	for name := range profiles.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// completeProfiles offers the saved profile names for --profile.
+func completeProfiles() []string {
+	names, _ := profileNames()
+	return names
+}
+
+func newConfigCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "config",
+		Usage:       "manage named profiles selected with --profile",
+		Description: "manage named profiles of relay, port, password, relay token, curve, post-quantum, proxy, store and throttle settings",
+		HelpName:    "croc config",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "list",
+				Usage:  "list saved profiles",
+				Action: listProfiles,
+			},
+			{
+				Name:      "show",
+				Usage:     "print the settings of a profile",
+				ArgsUsage: "[profile]",
+				Action:    showProfile,
+			},
+			{
+				Name:      "set",
+				Usage:     "create or update a profile (an empty value removes a setting)",
+				ArgsUsage: "<profile> <setting>=<value>...",
+				Description: "create or update a profile; available settings: " +
+					strings.Join(profileSettings, ", "),
+				Action: setProfile,
+			},
+			{
+				Name:      "delete",
+				Usage:     "delete a profile",
+				ArgsUsage: "<profile>",
+				Action:    deleteProfile,
+			},
+		},
+	}
+}
+
+func listProfiles(c *cli.Context) error {
+	names, err := profileNames()
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		_, err = fmt.Fprintln(c.App.Writer, "no profiles saved (create one with croc config set <profile> relay=host:port)")
+		return err
+	}
+	for _, name := range names {
+		if _, err = fmt.Fprintln(c.App.Writer, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func showProfile(c *cli.Context) error {
+	name := c.Args().First()
+	if name == "" {
+		name = strings.TrimSpace(c.String("profile"))
+	}
+	if name == "" {
+		return errors.New("usage: croc config show <profile>")
+	}
+	profiles, err := readProfiles()
+	if err != nil {
+		return err
+	}
+	settings, ok := profiles.Profiles[name]
+	if !ok {
+		return fmt.Errorf("profile %q does not exist", name)
+	}
+	for _, key := range profileSettings {
+		value, ok := settings[key]
+		if !ok {
+			continue
+		}
+		if key == "pass" || key == "relay-token" {
+			value = "********"
+		}
+		if _, err = fmt.Fprintf(c.App.Writer, "%s = %s\n", key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setProfile(c *cli.Context) error {
+	if c.Args().Len() < 2 {
+		return errors.New("usage: croc config set <profile> <setting>=<value>...")
+	}
+	name := c.Args().First()
+	if err := validateProfileName(name); err != nil {
+		return err
+	}
+	profiles, err := readProfiles()
+	if err != nil {
+		return err
+	}
+	settings := profiles.Profiles[name]
+	if settings == nil {
+		settings = map[string]string{}
+	}
+	for _, assignment := range c.Args().Tail() {
+		key, value, ok := strings.Cut(assignment, "=")
+		if !ok {
+			return fmt.Errorf("invalid setting %q: use <setting>=<value>", assignment)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if err = validateProfileSetting(key, value); err != nil {
+			return err
+		}
+		if value == "" {
+			delete(settings, key)
+		} else {
+			settings[key] = value
+		}
+	}
+	profiles.Profiles[name] = settings
+	return writeProfiles(profiles)
+}
+
+func deleteProfile(c *cli.Context) error {
+	name := c.Args().First()
+	if name == "" || c.Args().Len() > 1 {
+		return errors.New("usage: croc config delete <profile>")
+	}
+	profiles, err := readProfiles()
+	if err != nil {
+		return err
+	}
+	if _, ok := profiles.Profiles[name]; !ok {
+		return fmt.Errorf("profile %q does not exist", name)
+	}
+	delete(profiles.Profiles, name)
+	return writeProfiles(profiles)
+}
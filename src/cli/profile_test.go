@@ -0,0 +1,165 @@
+package cli
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/schollz/croc/v11/internal/cli"
+	"github.com/schollz/croc/v11/src/croc"
+	"github.com/schollz/croc/v11/src/models"
+)
+
+func TestConfigCommandManagesProfiles(t *testing.T) {
+	t.Setenv("CROC_CONFIG_DIR", t.TempDir())
+	run := func(args ...string) (string, error) {
+		app := newApp()
+		var output bytes.Buffer
+		app.Writer = &output
+		err := app.Run(append([]string{"croc"}, args...))
+		return output.String(), err
+	}
+
+	if _, err := run("config", "set", "work", "relay=relay.example:9009", "pass=hunter2", "port=9100"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := run("config", "set", "home", "curve=p384"); err != nil {
+		t.Fatal(err)
+	}
+	if output, err := run("config", "list"); err != nil || output != "home\nwork\n" {
+		t.Fatalf("config list = %q, %v", output, err)
+	}
+	output, err := run("config", "show", "work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != "relay = relay.example:9009\nport = 9100\npass = ********\n" {
+		t.Fatalf("config show = %q", output)
+	}
+	if _, err = run("config", "set", "work", "pass="); err != nil {
+		t.Fatal(err)
+	}
+	if output, _ = run("config", "show", "work"); strings.Contains(output, "pass") {
+		t.Fatalf("cleared setting still shown: %q", output)
+	}
+	if _, err = run("config", "set", "work", "port=many"); err == nil {
+		t.Fatal("expected an invalid port to be rejected")
+	}
+	if _, err = run("config", "set", "work", "code=secret"); err == nil {
+		t.Fatal("expected the code phrase to be rejected as a profile setting")
+	}
+	if _, err = run("config", "delete", "home"); err != nil {
+		t.Fatal(err)
+	}
+	if output, _ = run("config", "list"); output != "work\n" {
+		t.Fatalf("config list after delete = %q", output)
+	}
+
+	path, err := profilesPath(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := info.Mode().Perm(); got != 0o600 {
+		t.Fatalf("profile permissions = %o, want 600", got)
+	}
+}
+
+func TestApplyProfileFillsUnsetFlags(t *testing.T) {
+	t.Setenv("CROC_CONFIG_DIR", t.TempDir())
+	if err := writeProfiles(profileFile{Profiles: map[string]map[string]string{
+		"work": {"relay": "relay.example:9009", "pass": "hunter2", "port": "9100"},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var relay, pass string
+	var port int
+	app := cli.NewApp()
+	app.Flags = []cli.Flag{
+		&cli.StringFlag{Name: "profile"},
+		&cli.StringFlag{Name: "relay", Value: "default:9009"},
+		&cli.StringFlag{Name: "pass", Value: "pass123"},
+	}
+	app.Commands = []*cli.Command{{
+		Name:   "send",
+		Flags:  []cli.Flag{&cli.IntFlag{Name: "port", Value: 9009}},
+		Before: applyProfile,
+		Action: func(c *cli.Context) error {
+			relay, pass, port = c.String("relay"), c.String("pass"), c.Int("port")
+			return nil
+		},
+	}}
+
+	if err := app.Run([]string{"croc", "--profile", "work", "--pass", "explicit", "send"}); err != nil {
+		t.Fatal(err)
+	}
+	if relay != "relay.example:9009" || pass != "explicit" || port != 9100 {
+		t.Fatalf("profile values = (%q, %q, %d)", relay, pass, port)
+	}
+
+	if err := app.Run([]string{"croc", "--profile", "missing", "send"}); err == nil ||
+		!strings.Contains(err.Error(), `profile "missing" does not exist`) {
+		t.Fatalf("missing profile error = %v", err)
+	}
+}
+
+func TestProfileLeavesRememberedSettingsAndRelay(t *testing.T) {
+	t.Setenv("CROC_CONFIG_DIR", t.TempDir())
+	if err := writeProfiles(profileFile{Profiles: map[string]map[string]string{
+		"work": {"pass": "hunter2", "port": "9100", "curve": "p384"},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var got croc.Options
+	app := cli.NewApp()
+	app.Flags = []cli.Flag{
+		&cli.StringFlag{Name: "profile"},
+		&cli.StringFlag{Name: "pass", Value: "pass123"},
+		&cli.StringFlag{Name: "curve", Value: "p256"},
+	}
+	app.Commands = []*cli.Command{{
+		Name:   "send",
+		Before: applyProfile,
+		Action: func(c *cli.Context) error {
+			got = croc.Options{RelayPassword: c.String("pass"), Curve: c.String("curve")}
+			applyRememberedSendOptions(c, &got, croc.Options{RelayPassword: "remembered"})
+			return nil
+		},
+	}}
+	if err := app.Run([]string{"croc", "--profile", "work", "send"}); err != nil {
+		t.Fatal(err)
+	}
+	if got.RelayPassword != "remembered" || got.Curve != "p384" {
+		t.Fatalf("send options = (%q, %q), want the remembered password and the profile curve", got.RelayPassword, got.Curve)
+	}
+	if err := app.Run([]string{"croc", "--profile", "work", "--pass", "explicit", "send"}); err != nil {
+		t.Fatal(err)
+	}
+	if got.RelayPassword != "explicit" {
+		t.Fatalf("explicit password = %q", got.RelayPassword)
+	}
+
+	var pass string
+	var port int
+	relayApp := newApp()
+	for _, command := range relayApp.Commands {
+		if command.Name == "relay" {
+			command.Action = func(c *cli.Context) error {
+				pass, port = c.String("pass"), c.Int("port")
+				return nil
+			}
+		}
+	}
+	if err := relayApp.Run([]string{"croc", "--profile", "work", "relay"}); err != nil {
+		t.Fatal(err)
+	}
+	if pass != models.DEFAULT_PASSPHRASE || port != 9009 {
+		t.Fatalf("relay settings = (%q, %d), want the defaults", pass, port)
+	}
+}
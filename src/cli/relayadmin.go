@@ -0,0 +1,183 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/schollz/croc/v11/internal/cli"
+	"github.com/schollz/croc/v11/src/tcp"
+	"github.com/schollz/croc/v11/src/utils"
+	log "github.com/schollz/logger"
+)
+
+// relayAdminSocketEnv names the admin socket for both croc relay and croc
+// relay-admin.
+const relayAdminSocketEnv = "CROC_RELAY_ADMIN_SOCKET"
+
+func newRelayAdminCommand() *cli.Command {
+	flags := []cli.Flag{
+		&cli.StringFlag{Name: "socket", Aliases: []string{"s"}, Usage: "admin socket of the relay (see croc relay --admin-socket)", EnvVars: []string{relayAdminSocketEnv}},
+		&cli.BoolFlag{Name: "json", Usage: "print the relay's response as JSON"},
+	}
+	return &cli.Command{
+		Name:        "relay-admin",
+		Usage:       "inspect the rooms of your own relay and evict or ban peers",
+		Description: "talk to the admin socket of a relay started with --admin-socket; rooms are shown by the same digest room events record",
+		HelpName:    "croc relay-admin",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "rooms",
+				Usage:  "list the open rooms",
+				Flags:  flags,
+				Action: relayAdminAction(tcp.AdminListRooms),
+			},
+			{
+				Name:   "handshakes",
+				Usage:  "list the connections that have not joined a room yet",
+				Flags:  flags,
+				Action: relayAdminAction(tcp.AdminListHandshakes),
+			},
+			{
+				Name:   "limits",
+				Usage:  "list the room joins of each source and the banned sources",
+				Flags:  flags,
+				Action: relayAdminAction(tcp.AdminListLimits),
+			},
+			{
+				Name:      "evict",
+				Usage:     "close a room",
+				ArgsUsage: "<room digest or prefix>",
+				Flags:     flags,
+				Action:    relayAdminAction(tcp.AdminEvictRoom),
+			},
+			{
+				Name:      "ban",
+				Usage:     "refuse a source IP and close its rooms and handshakes",
+				ArgsUsage: "<ip>",
+				Flags: append([]cli.Flag{
+					&cli.DurationFlag{Name: "for", Usage: "how long the ban lasts (0 lasts until unban or a relay restart)"},
+				}, flags...),
+				Action: relayAdminAction(tcp.AdminBanSource),
+			},
+			{
+				Name:      "unban",
+				Usage:     "admit a banned source IP again",
+				ArgsUsage: "<ip>",
+				Flags:     flags,
+				Action:    relayAdminAction(tcp.AdminUnbanSource),
+			},
+		},
+	}
+}
+
+// relayAdminAction sends command to the relay's admin socket and prints the
+// response.
+func relayAdminAction(command string) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		path := strings.TrimSpace(c.String("socket"))
+		if path == "" {
+			return fmt.Errorf("missing --socket (or %s)", relayAdminSocketEnv)
+		}
+		request := tcp.AdminRequest{Command: command}
+		switch command {
+		case tcp.AdminEvictRoom, tcp.AdminBanSource, tcp.AdminUnbanSource:
+			argument := strings.TrimSpace(c.Args().First())
+			if argument == "" || c.Args().Len() > 1 {
+				return fmt.Errorf("usage: croc relay-admin %s %s", command, c.Command.ArgsUsage)
+			}
+			if command == tcp.AdminEvictRoom {
+				request.Room = argument
+			} else {
+				request.Source = argument
+			}
+		default:
+			if c.Args().Len() > 0 {
+				return fmt.Errorf("usage: croc relay-admin %s", command)
+			}
+		}
+		if command == tcp.AdminBanSource {
+			if request.Duration = c.Duration("for"); request.Duration < 0 {
+				return fmt.Errorf("--for must not be negative")
+			}
+		}
+		response, err := tcp.AdminCommand(path, request)
+		if err != nil {
+			return fmt.Errorf("relay admin: %w", err)
+		}
+		if c.Bool("json") {
+			encoder := json.NewEncoder(c.App.Writer)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(response)
+		}
+		return printRelayAdmin(c, command, response, time.Now())
+	}
+}
+
+func printRelayAdmin(c *cli.Context, command string, response tcp.AdminResponse, now time.Time) error {
+	w := tabwriter.NewWriter(c.App.Writer, 0, 0, 2, ' ', 0)
+	switch command {
+	case tcp.AdminListRooms:
+		fmt.Fprintln(w, "ROOM\tPORT\tAGE\tPAIRED\tBYTES\tSOURCES")
+		for _, room := range response.Rooms {
+			paired := "no"
+			if room.Paired {
+				paired = "yes"
+			}
+			if room.Reserved {
+				paired += " (reserved)"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", room.Room, room.Port, relayAdminAge(now, room.OpenedAt),
+				paired, utils.ByteCountDecimal(room.Bytes), strings.Join(room.Sources, ","))
+		}
+	case tcp.AdminListHandshakes:
+		fmt.Fprintln(w, "SOURCE\tPORT\tAGE")
+		for _, handshake := range response.Handshakes {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", handshake.Source, handshake.Port, relayAdminAge(now, handshake.StartedAt))
+		}
+	case tcp.AdminListLimits:
+		fmt.Fprintln(w, "SOURCE\tPORT\tJOINS")
+		for _, source := range response.Sources {
+			fmt.Fprintf(w, "%s\t%s\t%d/%d\n", source.Source, source.Port, source.Joins, source.Limit)
+		}
+		for _, ban := range response.Bans {
+			until := "until unbanned"
+			if !ban.Until.IsZero() {
+				until = "for " + relayAdminAge(ban.Until, now)
+			}
+			fmt.Fprintf(w, "%s\tbanned\t%s\n", ban.Source, until)
+		}
+	case tcp.AdminEvictRoom:
+		fmt.Fprintf(w, "evicted %s\n", c.Args().First())
+	case tcp.AdminBanSource:
+		fmt.Fprintf(w, "banned %s, closing %d rooms and handshakes\n", c.Args().First(), response.Closed)
+	case tcp.AdminUnbanSource:
+		fmt.Fprintf(w, "unbanned %s\n", c.Args().First())
+	}
+	return w.Flush()
+}
+
+func relayAdminAge(now, since time.Time) string {
+	return now.Sub(since).Round(time.Second).String()
+}
+
+// serveRelayAdmin serves the admin socket of the relay's servers in group at
+// path until stop is called. An empty path serves nothing.
+func serveRelayAdmin(path string, group *tcp.Group) (stop func(), err error) {
+	if path == "" {
+		return func() {}, nil
+	}
+	admin, err := tcp.ListenAdmin(path, group)
+	if err != nil {
+		return nil, fmt.Errorf("could not open the relay admin socket: %w", err)
+	}
+	log.Infof("serving the relay admin socket at %s", path)
+	go func() {
+		if serveErr := admin.Serve(); serveErr != nil {
+			log.Warnf("relay admin socket stopped: %v", serveErr)
+		}
+	}()
+	return func() { admin.Close() }, nil
+}
@@ -0,0 +1,85 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/schollz/croc/v11/src/tcp"
+)
+
+func TestRelayAdminCommandTalksToTheSocket(t *testing.T) {
+	unsetEnv(t, relayAdminSocketEnv)
+	// unix socket paths are limited to about a hundred bytes
+	dir, err := os.MkdirTemp("", "croc-admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "admin.sock")
+	stop, err := serveRelayAdmin(path, tcp.NewGroup())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+	run := func(args ...string) (string, error) {
+		app := newApp()
+		var output bytes.Buffer
+		app.Writer = &output
+		app.ErrWriter = io.Discard
+		err := app.Run(append([]string{"croc", "relay-admin"}, args...))
+		return strings.TrimSpace(output.String()), err
+	}
+
+	if _, err = run("rooms"); err == nil || !strings.Contains(err.Error(), "missing --socket") {
+		t.Fatalf("rooms without a socket: %v", err)
+	}
+	output, err := run("rooms", "--socket", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(output, "ROOM") {
+		t.Fatalf("rooms output = %q", output)
+	}
+	output, err = run("limits", "--socket", path, "--json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var response tcp.AdminResponse
+	if err = json.Unmarshal([]byte(output), &response); err != nil {
+		t.Fatalf("limits --json output %q: %v", output, err)
+	}
+	if _, err = run("evict", "--socket", path); err == nil || !strings.Contains(err.Error(), "usage") {
+		t.Fatalf("evict without a room: %v", err)
+	}
+	if _, err = run("ban", "--socket", path, "--for", "-1s", "192.0.2.1"); err == nil || !strings.Contains(err.Error(), "--for") {
+		t.Fatalf("ban with a negative duration: %v", err)
+	}
+	if _, err = run("unban", "--socket", path, "192.0.2.1"); err == nil || !strings.Contains(err.Error(), "not banned") {
+		t.Fatalf("unban of an unbanned source: %v", err)
+	}
+	if _, err = run("ban", "--socket", path, "not-an-ip"); err == nil || !strings.Contains(err.Error(), "invalid source") {
+		t.Fatalf("ban of an invalid source: %v", err)
+	}
+}
+
+func TestServeRelayAdminRefusesASocketInUse(t *testing.T) {
+	dir, err := os.MkdirTemp("", "croc-admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "admin.sock")
+	stop, err := serveRelayAdmin(path, tcp.NewGroup())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+	if _, err = serveRelayAdmin(path, tcp.NewGroup()); err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Fatalf("second admin socket: %v", err)
+	}
+}
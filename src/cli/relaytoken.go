@@ -0,0 +1,271 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/schollz/croc/v11/internal/cli"
+	"github.com/schollz/croc/v11/src/relayauth"
+	log "github.com/schollz/logger"
+)
+
+// relayTokensFileEnv names the token file for both croc relay and croc
+// relay-token.
+const relayTokensFileEnv = "CROC_RELAY_TOKENS_FILE"
+
+func newRelayTokenCommand() *cli.Command {
+	fileFlag := &cli.StringFlag{Name: "file", Aliases: []string{"f"}, Usage: "relay token file (see croc relay --tokens-file)", EnvVars: []string{relayTokensFileEnv}}
+	quotaFlags := []cli.Flag{
+		&cli.IntFlag{Name: "max-connections", Usage: "relay connections the user may hold open at once (0 is unlimited)"},
+		&cli.StringFlag{Name: "max-bytes-per-day", Value: "0", Usage: "bytes the user's peers may send through the relay per UTC day (0 is unlimited)"},
+	}
+	return &cli.Command{
+		Name:        "relay-token",
+		Usage:       "manage the per-user tokens of your own relay",
+		Description: "manage the token file a relay started with --tokens-file authenticates users with; send SIGHUP to the relay to apply changes",
+		HelpName:    "croc relay-token",
+		Subcommands: []*cli.Command{
+			{
+				Name:      "add",
+				Usage:     "add a token for a user and print it once",
+				ArgsUsage: "<name>",
+				Flags:     append([]cli.Flag{fileFlag}, quotaFlags...),
+				Action:    addRelayToken,
+			},
+			{
+				Name:        "sign",
+				Usage:       "print a signed token the relay verifies without listing it",
+				Description: "sign a token with the first signing key of the token file; the token is not stored",
+				ArgsUsage:   "<name>",
+				Flags: append([]cli.Flag{
+					fileFlag,
+					&cli.DurationFlag{Name: "expires", Value: 30 * 24 * time.Hour, Usage: "how long the token is valid (0 never expires)"},
+				}, quotaFlags...),
+				Action: signRelayToken,
+			},
+			{
+				Name:   "keygen",
+				Usage:  "add a signing key for signed tokens",
+				Flags:  []cli.Flag{fileFlag},
+				Action: addRelaySigningKey,
+			},
+			{
+				Name:      "revoke",
+				Usage:     "remove a user's tokens and reject the user's signed tokens",
+				ArgsUsage: "<name>",
+				Flags:     []cli.Flag{fileFlag},
+				Action:    revokeRelayToken,
+			},
+			{
+				Name:   "list",
+				Usage:  "list the users with tokens and the revoked users",
+				Flags:  []cli.Flag{fileFlag},
+				Action: listRelayTokens,
+			},
+		},
+	}
+}
+
+// relayTokenFile reads the token file named by --file. A missing file reads
+// as empty so the first add or keygen creates it.
+func relayTokenFile(c *cli.Context) (string, *relayauth.File, error) {
+	path := strings.TrimSpace(c.String("file"))
+	if path == "" {
+		return "", nil, fmt.Errorf("missing --file (or %s)", relayTokensFileEnv)
+	}
+	f, err := relayauth.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return path, new(relayauth.File), nil
+	}
+	return path, f, err
+}
+
+// relayTokenUser returns the user name argument of a relay-token subcommand.
+func relayTokenUser(c *cli.Context) (string, error) {
+	name := strings.TrimSpace(c.Args().First())
+	if name == "" || c.Args().Len() > 1 {
+		return "", fmt.Errorf("usage: croc relay-token %s <name>", c.Command.Name)
+	}
+	return name, nil
+}
+
+func relayTokenQuota(c *cli.Context) (relayauth.Quota, error) {
+	bytesPerDay, err := strconv.ParseInt(strings.TrimSpace(c.String("max-bytes-per-day")), 10, 64)
+	if err != nil || bytesPerDay < 0 {
+		return relayauth.Quota{}, fmt.Errorf("--max-bytes-per-day must be a number of bytes")
+	}
+	if c.Int("max-connections") < 0 {
+		return relayauth.Quota{}, fmt.Errorf("--max-connections must not be negative")
+	}
+	return relayauth.Quota{MaxConnections: c.Int("max-connections"), MaxBytesPerDay: bytesPerDay}, nil
+}
+
+func addRelayToken(c *cli.Context) error {
+	name, err := relayTokenUser(c)
+	if err != nil {
+		return err
+	}
+	quota, err := relayTokenQuota(c)
+	if err != nil {
+		return err
+	}
+	path, f, err := relayTokenFile(c)
+	if err != nil {
+		return err
+	}
+	token, err := relayauth.NewToken()
+	if err != nil {
+		return err
+	}
+	f.Tokens = append(f.Tokens, relayauth.Entry{Name: name, Hash: relayauth.HashToken(token), Quota: quota})
+	f.Revoked = slices.DeleteFunc(f.Revoked, func(revoked string) bool { return revoked == name })
+	if err = f.WriteFile(path); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.ErrWriter, "added a relay token for %s; it is shown only once:\n", name)
+	_, err = fmt.Fprintln(c.App.Writer, token)
+	return err
+}
+
+func signRelayToken(c *cli.Context) error {
+	name, err := relayTokenUser(c)
+	if err != nil {
+		return err
+	}
+	quota, err := relayTokenQuota(c)
+	if err != nil {
+		return err
+	}
+	expires := c.Duration("expires")
+	if expires < 0 {
+		return fmt.Errorf("--expires must not be negative")
+	}
+	path, f, err := relayTokenFile(c)
+	if err != nil {
+		return err
+	}
+	if len(f.SigningKeys) == 0 {
+		return fmt.Errorf("%s has no signing keys (add one with croc relay-token keygen)", path)
+	}
+	if slices.Contains(f.Revoked, name) {
+		return fmt.Errorf("%s is revoked", name)
+	}
+	claims := relayauth.Claims{Name: name, Quota: quota}
+	if expires > 0 {
+		claims.Expires = time.Now().Add(expires).Unix()
+	}
+	token, err := relayauth.Sign(f.SigningKeys[0], claims)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(c.App.Writer, token)
+	return err
+}
+
+func addRelaySigningKey(c *cli.Context) error {
+	path, f, err := relayTokenFile(c)
+	if err != nil {
+		return err
+	}
+	key, err := relayauth.NewSigningKey()
+	if err != nil {
+		return err
+	}
+	// new tokens are signed with the newest key; older keys keep verifying
+	// the tokens they signed until they are removed from the file
+	f.SigningKeys = append([]string{key}, f.SigningKeys...)
+	if err = f.WriteFile(path); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(c.App.Writer, "added a signing key to %s\n", path)
+	return err
+}
+
+func revokeRelayToken(c *cli.Context) error {
+	name, err := relayTokenUser(c)
+	if err != nil {
+		return err
+	}
+	path, f, err := relayTokenFile(c)
+	if err != nil {
+		return err
+	}
+	f.Tokens = slices.DeleteFunc(f.Tokens, func(entry relayauth.Entry) bool { return entry.Name == name })
+	if !slices.Contains(f.Revoked, name) {
+		f.Revoked = append(f.Revoked, name)
+	}
+	if err = f.WriteFile(path); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(c.App.Writer, "revoked %s (send SIGHUP to the relay to apply)\n", name)
+	return err
+}
+
+func listRelayTokens(c *cli.Context) error {
+	_, f, err := relayTokenFile(c)
+	if err != nil {
+		return err
+	}
+	for _, entry := range f.Tokens {
+		line := entry.Name
+		if entry.MaxConnections > 0 {
+			line += fmt.Sprintf(" max-connections=%d", entry.MaxConnections)
+		}
+		if entry.MaxBytesPerDay > 0 {
+			line += fmt.Sprintf(" max-bytes-per-day=%d", entry.MaxBytesPerDay)
+		}
+		if _, err = fmt.Fprintln(c.App.Writer, line); err != nil {
+			return err
+		}
+	}
+	for _, name := range f.Revoked {
+		if _, err = fmt.Fprintf(c.App.Writer, "%s revoked\n", name); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(c.App.Writer, "%d signing keys\n", len(f.SigningKeys))
+	return err
+}
+
+// watchRelayTokens loads the relay token file at path and reloads it on
+// SIGHUP until stop is called. An empty path returns a nil authenticator,
+// which keeps the shared relay password.
+func watchRelayTokens(path string) (auth *relayauth.Authenticator, stop func(), err error) {
+	if path == "" {
+		return nil, func() {}, nil
+	}
+	if auth, err = relayauth.Load(path); err != nil {
+		return nil, nil, fmt.Errorf("could not load relay tokens: %w", err)
+	}
+	tokens, keys := auth.Users()
+	log.Infof("authenticating relay users with %d tokens and %d signing keys from %s", tokens, keys, path)
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-hangups:
+			}
+			if reloadErr := auth.Reload(); reloadErr != nil {
+				log.Warnf("keeping the previous relay tokens: %v", reloadErr)
+				continue
+			}
+			tokens, keys := auth.Users()
+			log.Infof("reloaded %d relay tokens and %d signing keys", tokens, keys)
+		}
+	}()
+	return auth, func() {
+		signal.Stop(hangups)
+		close(done)
+	}, nil
+}
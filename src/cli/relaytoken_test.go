@@ -0,0 +1,85 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/schollz/croc/v11/src/relayauth"
+)
+
+func TestRelayTokenCommandManagesTokens(t *testing.T) {
+	unsetEnv(t, relayTokensFileEnv)
+	path := filepath.Join(t.TempDir(), "tokens.yaml")
+	run := func(args ...string) (string, error) {
+		app := newApp()
+		var output bytes.Buffer
+		app.Writer = &output
+		app.ErrWriter = io.Discard
+		err := app.Run(append([]string{"croc", "relay-token"}, args...))
+		return strings.TrimSpace(output.String()), err
+	}
+
+	token, err := run("add", "--file", path, "--max-connections", "4", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = run("sign", "--file", path, "bob"); err == nil || !strings.Contains(err.Error(), "no signing keys") {
+		t.Fatalf("sign without a key: %v", err)
+	}
+	if _, err = run("keygen", "--file", path); err != nil {
+		t.Fatal(err)
+	}
+	signed, err := run("sign", "--file", path, "--expires", "1h", "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auth, err := relayauth.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, credential := range map[string]string{"alice": token, "bob": signed} {
+		lease, authErr := auth.Authenticate(credential)
+		if authErr != nil {
+			t.Fatalf("authenticate %s: %v", name, authErr)
+		}
+		if lease.Name() != name {
+			t.Fatalf("token authenticated %q, want %q", lease.Name(), name)
+		}
+		lease.Release()
+	}
+
+	if output, listErr := run("list", "--file", path); listErr != nil || output != "alice max-connections=4\n1 signing keys" {
+		t.Fatalf("list = %q, %v", output, listErr)
+	}
+	if _, err = run("revoke", "--file", path, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err = auth.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = auth.Authenticate(signed); !errors.Is(err, relayauth.ErrInvalidToken) {
+		t.Fatalf("revoked signed token: %v", err)
+	}
+	if _, err = run("sign", "--file", path, "bob"); err == nil {
+		t.Fatal("expected signing a revoked user to fail")
+	}
+	if _, err = run("add", "alice"); err == nil {
+		t.Fatal("expected add without --file to fail")
+	}
+	if _, err = run("add", "--file", path, "--max-bytes-per-day", "lots", "carol"); err == nil {
+		t.Fatal("expected an invalid byte quota to be rejected")
+	}
+}
+
+func TestRelayRejectsInvalidTokensFile(t *testing.T) {
+	unsetEnv(t, relayTokensFileEnv)
+	err := newApp().Run([]string{"croc", "relay", "--tokens-file", filepath.Join(t.TempDir(), "missing.yaml")})
+	if err == nil || !strings.Contains(err.Error(), "could not load relay tokens") {
+		t.Fatalf("error = %v", err)
+	}
+}
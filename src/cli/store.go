@@ -100,7 +100,8 @@ func saveStoreReceipt(receipt storeReceipt) error {
 	}
 	filtered := receipts[:0]
 	for _, existing := range receipts {
-		if existing.ID != receipt.ID {
+		// A mirror shares its transfer ID with the original, so keep both.
+		if existing.ID != receipt.ID || existing.Origin != receipt.Origin {
 			filtered = append(filtered, existing)
 		}
 	}
@@ -172,6 +173,7 @@ func newStyledStoredCallbacks(output io.Writer, quiet, colorEnabled bool) storec
 
 func styleStoredStatus(value string, colorEnabled bool) string {
 	if strings.HasPrefix(value, "Encrypted upload complete") ||
+		strings.HasPrefix(value, "Encrypted mirror complete") ||
 		strings.HasPrefix(value, "Verified download committed") {
 		return termui.Success(value, colorEnabled)
 	}
@@ -219,6 +221,149 @@ func formatStoredSendInstructions(
 	)
 }
 
+func formatRequestedSendInstructions(expiresAt, transferID, downloadLimit string, colorEnabled bool) string {
+	return fmt.Sprintf(`%s
+
+%s
+    croc --revoke %s
+`,
+		termui.Success(
+			fmt.Sprintf("Files were encrypted to the requester and are available until %s or %s.", expiresAt, downloadLimit),
+			colorEnabled,
+		),
+		termui.Emphasis("Revoke before download:", colorEnabled),
+		termui.Secret(transferID, colorEnabled),
+	)
+}
+
+func formatRequestInstructions(expiresAt, browserURL, uploadToken, collectToken string, colorEnabled bool) string {
+	return fmt.Sprintf(`%s
+
+%s
+    %s
+
+%s
+    croc send --store-request %s [filename(s)]
+
+%s
+    Run croc, then paste this token:
+    %s
+`,
+		termui.Success(fmt.Sprintf("File request accepts encrypted uploads until %s.", expiresAt), colorEnabled),
+		termui.Emphasis("Share with uploaders:", colorEnabled),
+		termui.Secret(browserURL, colorEnabled),
+		termui.Emphasis("CLI uploaders:", colorEnabled),
+		termui.Secret(uploadToken, colorEnabled),
+		termui.Emphasis("Collect uploads (keep this secret):", colorEnabled),
+		termui.Secret(collectToken, colorEnabled),
+	)
+}
+
+func requestStored(c *cli.Context) error {
+	setDebugLevel(c)
+	if c.Args().Present() {
+		return errors.New("usage: croc request [--store-expiration 1d]")
+	}
+	expiration, err := storeapi.ParseExpiration(c.String("store-expiration"), false)
+	if err != nil {
+		return fmt.Errorf("invalid --store-expiration: %w", err)
+	}
+	collection, expiresAt, err := new(storeclient.Client).CreateFileRequest(
+		context.Background(),
+		strings.TrimSpace(c.String("store-url")),
+		expiration,
+	)
+	if err != nil {
+		return err
+	}
+	fileRequest, err := collection.FileRequest()
+	if err != nil {
+		return err
+	}
+	browserURL, err := fileRequest.BrowserURL()
+	if err != nil {
+		return err
+	}
+	uploadToken, err := fileRequest.CLIToken()
+	if err != nil {
+		return err
+	}
+	collectToken, err := collection.CLIToken()
+	if err != nil {
+		return err
+	}
+	output, colorEnabled := termui.Output(os.Stderr)
+	fmt.Fprint(output, formatRequestInstructions(
+		expiresAt.Local().Format(time.RFC1123),
+		browserURL,
+		uploadToken,
+		collectToken,
+		colorEnabled,
+	))
+	if !c.Bool("disable-clipboard") {
+		croc.CopyToClipboard(browserURL, c.Bool("quiet"), false)
+	}
+	return nil
+}
+
+// receiveRequested downloads every upload currently available through a file
+// request, one stored transfer at a time.
+func receiveRequested(c *cli.Context, collection storecrypto.RequestCollection) error {
+	transfers, err := new(storeclient.Client).RequestedTransfers(context.Background(), collection)
+	if err != nil {
+		return err
+	}
+	output, colorEnabled := termui.Output(os.Stderr)
+	if len(transfers) == 0 {
+		fmt.Fprintln(output, termui.Warning("No uploads are waiting for this file request.", colorEnabled))
+		return nil
+	}
+	for index, transfer := range transfers {
+		fmt.Fprintf(output, "%s\n", termui.Emphasis(
+			fmt.Sprintf("Upload %d of %d", index+1, len(transfers)),
+			colorEnabled,
+		))
+		if err = receiveStoredShare(c, transfer.Share); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storedNotifyContext is sealed with the sender's notification key so the
+// storage service can echo file names without learning them.
+type storedNotifyContext struct {
+	Files []string `json:"files"`
+}
+
+func storedNotifyOptions(notifyURL, encodedKey string, paths []string) (storeclient.UploadOptions, error) {
+	notifyURL = strings.TrimSpace(notifyURL)
+	encodedKey = strings.TrimSpace(encodedKey)
+	if notifyURL == "" {
+		if encodedKey != "" {
+			return storeclient.UploadOptions{}, errors.New("--store-notify-key requires --store-notify")
+		}
+		return storeclient.UploadOptions{}, nil
+	}
+	options := storeclient.UploadOptions{NotifyURL: notifyURL}
+	if encodedKey == "" {
+		return options, nil
+	}
+	key, err := storecrypto.DecodeBase64URL(encodedKey)
+	if err != nil || len(key) != storecrypto.KeySize {
+		return storeclient.UploadOptions{}, fmt.Errorf(
+			"--store-notify-key must be a base64url-encoded %d-byte key", storecrypto.KeySize,
+		)
+	}
+	notifyContext := storedNotifyContext{Files: make([]string, len(paths))}
+	for index, path := range paths {
+		notifyContext.Files[index] = filepath.Base(path)
+	}
+	options.NotifyKey = key
+	options.NotifyContext, err = json.Marshal(notifyContext)
+	return options, err
+}
+
 func sendStored(c *cli.Context) error {
 	if c.String("text") != "" || c.Bool("zip") || c.Bool("git") ||
 		c.String("exclude") != "" || c.String("exclude-file") != "" {
@@ -240,13 +385,28 @@ func sendStored(c *cli.Context) error {
 	if err != nil {
 		return fmt.Errorf("invalid --store-expiration: %w", err)
 	}
+	options, err := storedNotifyOptions(c.String("store-notify"), c.String("store-notify-key"), paths)
+	if err != nil {
+		return err
+	}
+	options.Downloads = downloads
+	options.Expiration = expiration
+	origin := strings.TrimSpace(c.String("store-url"))
+	if link := strings.TrimSpace(c.String("store-request")); link != "" {
+		fileRequest, parseErr := storecrypto.ParseFileRequest(link)
+		if parseErr != nil {
+			return fmt.Errorf("invalid --store-request: %w", parseErr)
+		}
+		options.Request = &fileRequest
+		origin = fileRequest.Origin
+	}
 
 	client := new(storeclient.Client)
 	result, err := client.UploadWithOptions(
 		context.Background(),
-		strings.TrimSpace(c.String("store-url")),
+		origin,
 		paths,
-		storeclient.UploadOptions{Downloads: downloads, Expiration: expiration},
+		options,
 		storedCallbacks(c.Bool("quiet")),
 	)
 	if !c.Bool("quiet") {
@@ -276,6 +436,15 @@ func sendStored(c *cli.Context) error {
 		downloadLimit = "one verified download"
 	}
 	output, colorEnabled := termui.Output(os.Stderr)
+	if options.Request != nil {
+		fmt.Fprint(output, formatRequestedSendInstructions(
+			result.ExpiresAt.Local().Format(time.RFC1123),
+			result.Share.ID,
+			downloadLimit,
+			colorEnabled,
+		))
+		return nil
+	}
 	fmt.Fprint(output, formatStoredSendInstructions(
 		result.ExpiresAt.Local().Format(time.RFC1123),
 		browserURL,
@@ -294,21 +463,54 @@ func sendStored(c *cli.Context) error {
 }
 
 func receiveStored(c *cli.Context, value string) error {
+	if c.Bool("stdout") {
+		return errors.New("--stdout is not supported for stored transfers")
+	}
+	if collection, collectionErr := storecrypto.ParseRequestCollection(value); collectionErr == nil {
+		return receiveRequested(c, collection)
+	}
 	share, err := storecrypto.ParseShare(value)
 	if err != nil {
 		return err
 	}
-	if c.Bool("stdout") {
-		return errors.New("--stdout is not supported for stored transfers")
-	}
+	return receiveStoredShare(c, share)
+}
+
+func receiveStoredShare(c *cli.Context, share storecrypto.Share) error {
 	client := new(storeclient.Client)
 	manifest, expires, err := client.Inspect(context.Background(), share)
 	if err != nil {
 		return err
 	}
+	output, err := confirmStoredManifest(c, "stored transfer", expires, manifest)
+	if err != nil {
+		return err
+	}
+	err = client.Receive(
+		context.Background(),
+		share,
+		manifest,
+		output,
+		storedCallbacks(c.Bool("quiet")),
+	)
+	if !c.Bool("quiet") {
+		fmt.Fprintln(os.Stderr)
+	}
+	return err
+}
+
+// confirmStoredManifest lists the files of an encrypted stored transfer or
+// archive and asks before receiving them or replacing existing files. It
+// returns the output directory.
+func confirmStoredManifest(
+	c *cli.Context,
+	noun string,
+	expires time.Time,
+	manifest storecrypto.Manifest,
+) (string, error) {
 	total := int64(0)
 	terminalOutput, colorEnabled := termui.Output(os.Stderr)
-	fmt.Fprint(terminalOutput, termui.Emphasis("Encrypted stored transfer", colorEnabled))
+	fmt.Fprint(terminalOutput, termui.Emphasis("Encrypted "+noun, colorEnabled))
 	if !expires.IsZero() {
 		fmt.Fprintf(terminalOutput, " (%s)", termui.Warning(
 			"expires "+expires.Local().Format(time.RFC1123),
@@ -336,38 +538,28 @@ func receiveStored(c *cli.Context, value string) error {
 		destination := filepath.Join(output, file.Name)
 		if _, statErr := os.Stat(destination); statErr == nil && !c.Bool("overwrite") {
 			if c.Bool("yes") {
-				return fmt.Errorf("destination already exists (use --overwrite): %s", destination)
+				return "", fmt.Errorf("destination already exists (use --overwrite): %s", destination)
 			}
 			choice, inputErr := utils.GetInput(fmt.Sprintf(
 				"Replace %s? (y/N) ",
 				termui.Filename(destination, colorEnabled),
 			))
 			if inputErr != nil || !strings.EqualFold(strings.TrimSpace(choice), "y") {
-				return errors.New("stored transfer refused")
+				return "", errors.New(noun + " refused")
 			}
 		}
 	}
 	if !c.Bool("yes") {
 		choice, inputErr := utils.GetInput("Receive these files? (Y/n) ")
 		if inputErr != nil {
-			return inputErr
+			return "", inputErr
 		}
 		normalized := strings.ToLower(strings.TrimSpace(choice))
 		if normalized != "" && normalized != "y" && normalized != "yes" {
-			return errors.New("stored transfer refused")
+			return "", errors.New(noun + " refused")
 		}
 	}
-	err = client.Receive(
-		context.Background(),
-		share,
-		manifest,
-		output,
-		storedCallbacks(c.Bool("quiet")),
-	)
-	if !c.Bool("quiet") {
-		fmt.Fprintln(os.Stderr)
-	}
-	return err
+	return output, nil
 }
 
 func revokeStored(c *cli.Context, transferID string) error {
@@ -380,27 +572,29 @@ func revokeStored(c *cli.Context, transferID string) error {
 	if err != nil {
 		return err
 	}
-	var receipt *storeReceipt
-	for index := range receipts {
-		if receipts[index].ID == id {
-			receipt = &receipts[index]
-			break
+	// Mirrors keep the original ID, so one ID may name copies on several
+	// origins; all of them are revoked.
+	found := false
+	for _, receipt := range receipts {
+		if receipt.ID != id {
+			continue
+		}
+		found = true
+		share := storecrypto.Share{
+			Origin:    receipt.Origin,
+			ID:        receipt.ID,
+			MasterKey: make([]byte, storecrypto.KeySize),
+		}
+		err = new(storeclient.Client).Revoke(context.Background(), share, receipt.UploadToken)
+		var httpErr *storeclient.HTTPError
+		if err != nil && !(errors.As(err, &httpErr) &&
+			(httpErr.StatusCode == http.StatusGone || httpErr.StatusCode == http.StatusNotFound)) {
+			return err
 		}
 	}
-	if receipt == nil {
+	if !found {
 		return fmt.Errorf("no unexpired local revoke receipt for %s", id)
 	}
-	share := storecrypto.Share{
-		Origin:    receipt.Origin,
-		ID:        receipt.ID,
-		MasterKey: make([]byte, storecrypto.KeySize),
-	}
-	err = new(storeclient.Client).Revoke(context.Background(), share, receipt.UploadToken)
-	var httpErr *storeclient.HTTPError
-	if err != nil && !(errors.As(err, &httpErr) &&
-		(httpErr.StatusCode == http.StatusGone || httpErr.StatusCode == http.StatusNotFound)) {
-		return err
-	}
 	if err = removeStoreReceipt(id); err != nil {
 		return err
 	}
@@ -412,3 +606,103 @@ func revokeStored(c *cli.Context, transferID string) error {
 	)
 	return nil
 }
+
+func newStoreCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "store",
+		Usage:       "manage stored transfers on a storage service",
+		Description: "manage encrypted stored transfers created with croc send --store",
+		HelpName:    "croc store",
+		Subcommands: []*cli.Command{
+			{
+				Name:        "mirror",
+				Usage:       "copy a stored transfer to another storage service without decrypting it",
+				Description: "copy the encrypted objects of a stored transfer to another croc-web --store-dir service and print a share for the copy; the token may also be read from CROC_STORE_TOKEN",
+				ArgsUsage:   "[token] --to [origin]",
+				Action:      mirrorStored,
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "to", Usage: "origin of the destination storage service", EnvVars: []string{"CROC_STORE_MIRROR_URL"}},
+					&cli.IntFlag{Name: "store-downloads", Value: 1, Usage: "number of verified downloads allowed at the destination"},
+					&cli.StringFlag{Name: "store-expiration", Value: "1d", Usage: "stored lifetime at the destination (for example 90m, 12h, 3d, or 2w)"},
+				},
+			},
+		},
+	}
+}
+
+func mirrorStored(c *cli.Context) error {
+	setDebugLevel(c)
+	value := strings.TrimSpace(os.Getenv("CROC_STORE_TOKEN"))
+	if c.Args().Len() > 1 || (value != "" && c.Args().Present()) {
+		return errors.New("usage: croc store mirror [token] --to [origin]")
+	}
+	if c.Args().Present() {
+		value = strings.TrimSpace(c.Args().First())
+	}
+	destination := strings.TrimSpace(c.String("to"))
+	if destination == "" {
+		return errors.New("usage: croc store mirror [token] --to [origin]")
+	}
+	if value == "" {
+		var err error
+		if value, err = utils.GetInput("Enter the stored-transfer token: "); err != nil {
+			return err
+		}
+	}
+	share, err := storecrypto.ParseShare(value)
+	if err != nil {
+		return err
+	}
+	downloads := c.Int("store-downloads")
+	if downloads < 1 {
+		return errors.New("--store-downloads must be positive")
+	}
+	expiration, err := storeapi.ParseExpiration(c.String("store-expiration"), false)
+	if err != nil {
+		return fmt.Errorf("invalid --store-expiration: %w", err)
+	}
+
+	result, err := new(storeclient.Client).Mirror(
+		context.Background(),
+		share,
+		destination,
+		storeclient.UploadOptions{Downloads: downloads, Expiration: expiration},
+		storedCallbacks(c.Bool("quiet")),
+	)
+	if !c.Bool("quiet") {
+		fmt.Fprintln(os.Stderr)
+	}
+	if err != nil {
+		return err
+	}
+	browserURL, err := result.Share.BrowserURL()
+	if err != nil {
+		return err
+	}
+	token, err := result.Share.CLIToken()
+	if err != nil {
+		return err
+	}
+	if err = saveStoreReceipt(storeReceipt{
+		ID:          result.Share.ID,
+		Origin:      result.Share.Origin,
+		UploadToken: result.UploadToken,
+		ExpiresAt:   result.ExpiresAt,
+	}); err != nil {
+		return fmt.Errorf("save stored-transfer revoke receipt: %w", err)
+	}
+	downloadLimit := fmt.Sprintf("%d verified downloads", result.Downloads)
+	if result.Downloads == 1 {
+		downloadLimit = "one verified download"
+	}
+	output, colorEnabled := termui.Output(os.Stderr)
+	fmt.Fprint(output, formatStoredSendInstructions(
+		result.ExpiresAt.Local().Format(time.RFC1123),
+		browserURL,
+		token,
+		result.Share.ID,
+		downloadLimit,
+		colorEnabled,
+	))
+	return nil
+}
@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/rivo/uniseg"
 	"github.com/schollz/croc/v11/src/storeclient"
+	"github.com/schollz/croc/v11/src/storecrypto"
 	"github.com/schollz/croc/v11/src/termui"
 	"github.com/schollz/croc/v11/src/utils"
 )
@@ -113,3 +115,73 @@ func TestFormatStoredSendInstructionsUsesRegularCrocPalette(t *testing.T) {
 		t.Fatalf("stored ready message is not green: %q", colored)
 	}
 }
+
+func TestFormatRequestInstructionsHighlightsLinks(t *testing.T) {
+	plain := formatRequestInstructions(
+		"tomorrow", "https://example.com/r/id#v1.public", "croc-store-v1-request.token", "croc-store-v1-collect.token", false,
+	)
+	colored := formatRequestInstructions(
+		"tomorrow", "https://example.com/r/id#v1.public", "croc-store-v1-request.token", "croc-store-v1-collect.token", true,
+	)
+	if termui.Plain(colored) != plain {
+		t.Fatalf("colored request instructions changed text:\n%s", colored)
+	}
+	for _, value := range []string{"https://example.com/r/id#v1.public", "croc-store-v1-request.token", "croc-store-v1-collect.token"} {
+		if !strings.Contains(colored, termui.Yellow+value+termui.Reset) {
+			t.Fatalf("request instructions do not highlight %q: %q", value, colored)
+		}
+	}
+	if !strings.Contains(plain, "croc send --store-request croc-store-v1-request.token") {
+		t.Fatalf("request instructions omit the upload command:\n%s", plain)
+	}
+}
+
+func TestStoredNotifyOptions(t *testing.T) {
+	options, err := storedNotifyOptions("", "", []string{"a.txt"})
+	if err != nil || options.NotifyURL != "" || options.NotifyKey != nil {
+		t.Fatalf("storedNotifyOptions without URL = %+v, %v; want empty options", options, err)
+	}
+	if _, err = storedNotifyOptions("", storecrypto.EncodeBase64URL(make([]byte, storecrypto.KeySize)), nil); err == nil {
+		t.Fatal("storedNotifyOptions accepted a key without a URL")
+	}
+	if _, err = storedNotifyOptions("https://hooks.example/croc", "short", nil); err == nil {
+		t.Fatal("storedNotifyOptions accepted a malformed key")
+	}
+
+	key := bytes.Repeat([]byte{3}, storecrypto.KeySize)
+	options, err = storedNotifyOptions(
+		" https://hooks.example/croc ", storecrypto.EncodeBase64URL(key), []string{"dir/report.pdf", "notes.txt"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.NotifyURL != "https://hooks.example/croc" || !bytes.Equal(options.NotifyKey, key) {
+		t.Fatalf("storedNotifyOptions = %+v; want trimmed URL and decoded key", options)
+	}
+	if got := string(options.NotifyContext); got != `{"files":["report.pdf","notes.txt"]}` {
+		t.Fatalf("notification context = %s; want base file names", got)
+	}
+}
+
+func TestStoreReceiptsKeepMirrorsOfTheSameTransfer(t *testing.T) {
+	t.Setenv("CROC_CONFIG_DIR", t.TempDir())
+	expires := time.Now().Add(time.Hour)
+	for _, origin := range []string{"https://getcroc.com", "https://files.example.com", "https://getcroc.com"} {
+		if err := saveStoreReceipt(storeReceipt{ID: "transfer", Origin: origin, UploadToken: origin, ExpiresAt: expires}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	receipts, err := readStoreReceipts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(receipts) != 2 {
+		t.Fatalf("receipts = %+v, want one per origin", receipts)
+	}
+	if err = removeStoreReceipt("transfer"); err != nil {
+		t.Fatal(err)
+	}
+	if receipts, err = readStoreReceipts(); err != nil || len(receipts) != 0 {
+		t.Fatalf("receipts after removal = %+v, %v", receipts, err)
+	}
+}
@@ -0,0 +1,293 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/schollz/croc/v11/internal/cli"
+	"github.com/schollz/croc/v11/src/selfupdate"
+)
+
+const (
+	releasesEndpoint = "https://api.github.com/repos/schollz/croc/releases"
+	updateTimeout    = 5 * time.Minute
+	// maxReleaseArchiveSize bounds a downloaded release archive.
+	maxReleaseArchiveSize = 128 << 20
+	// maxReleaseListSize bounds the release list of the prerelease channel.
+	maxReleaseListSize = 8 << 20
+
+	updateChannelStable     = "stable"
+	updateChannelPrerelease = "prerelease"
+)
+
+// updatePublicKey is the minisign public key that signs the checksums of
+// release archives. Release builds set it with
+// -ldflags "-X github.com/schollz/croc/v11/src/cli.updatePublicKey=...";
+// builds without it can check for updates but not install them.
+var updatePublicKey string
+
+// releasePlatforms maps GOOS/GOARCH to the platform names of release archives.
+var releasePlatforms = map[string]string{
+	"windows/amd64":   "Windows-64bit",
+	"windows/386":     "Windows-32bit",
+	"windows/arm64":   "Windows-ARM64",
+	"linux/amd64":     "Linux-64bit",
+	"linux/386":       "Linux-32bit",
+	"linux/arm":       "Linux-ARM",
+	"linux/arm64":     "Linux-ARM64",
+	"linux/riscv64":   "Linux-RISCV64",
+	"darwin/amd64":    "macOS-64bit",
+	"darwin/arm64":    "macOS-ARM64",
+	"dragonfly/amd64": "DragonFlyBSD-64bit",
+	"freebsd/amd64":   "FreeBSD-64bit",
+	"freebsd/arm64":   "FreeBSD-ARM64",
+	"netbsd/386":      "NetBSD-32bit",
+	"netbsd/amd64":    "NetBSD-64bit",
+	"netbsd/arm64":    "NetBSD-ARM64",
+	"openbsd/amd64":   "OpenBSD-64bit",
+	"openbsd/arm64":   "OpenBSD-ARM64",
+}
+
+func newUpdateCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "update",
+		Usage:       "replace croc with the latest signed release",
+		Description: "download the latest release for this platform, verify its signature and checksum, and replace the running executable",
+		HelpName:    "croc update",
+		Action:      updateCroc,
+		Flags: []cli.Flag{
+			&cli.BoolFlag{Name: "check", Usage: "only report whether a newer release is available"},
+			&cli.StringFlag{Name: "channel", Value: updateChannelStable, Usage: "release channel (" + updateChannelStable + ", " + updateChannelPrerelease + ")", EnvVars: []string{"CROC_UPDATE_CHANNEL"}},
+		},
+	}
+}
+
+// release is a croc release as described by the GitHub releases API.
+type release struct {
+	TagName string         `json:"tag_name"`
+	Draft   bool           `json:"draft"`
+	Assets  []releaseAsset `json:"assets"`
+}
+
+type releaseAsset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+func (r release) asset(name string) (releaseAsset, error) {
+	for _, asset := range r.Assets {
+		if asset.Name == name {
+			return asset, nil
+		}
+	}
+	return releaseAsset{}, fmt.Errorf("release %s has no %s", r.TagName, name)
+}
+
+type releaseUpdater struct {
+	endpoint       string
+	currentVersion string
+	publicKey      string
+	platform       string
+	executable     string
+	client         *http.Client
+	output         io.Writer
+}
+
+func updateCroc(c *cli.Context) error {
+	setDebugLevel(c)
+	executable, err := os.Executable()
+	if err == nil {
+		executable, err = filepath.EvalSymlinks(executable)
+	}
+	if err != nil {
+		return fmt.Errorf("could not find the croc executable: %w", err)
+	}
+	selfupdate.RemoveOld(executable)
+	updater := releaseUpdater{
+		endpoint:       releasesEndpoint,
+		currentVersion: Version,
+		publicKey:      updatePublicKey,
+		platform:       releasePlatform(),
+		executable:     executable,
+		client:         &http.Client{},
+		output:         os.Stderr,
+	}
+	ctx, cancel := context.WithTimeout(c.Context, updateTimeout)
+	defer cancel()
+	return updater.run(ctx, c.String("channel"), c.Bool("check"))
+}
+
+// releasePlatform returns the platform name of this build's release archive.
+func releasePlatform() string {
+	platform := releasePlatforms[runtime.GOOS+"/"+runtime.GOARCH]
+	if runtime.GOARCH != "arm" {
+		return platform
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "GOARM" && setting.Value == "5" {
+				return "Linux-ARMv5"
+			}
+		}
+	}
+	return platform
+}
+
+func (u releaseUpdater) run(ctx context.Context, channel string, checkOnly bool) error {
+	latest, err := u.latestRelease(ctx, channel)
+	if err != nil {
+		return err
+	}
+	version, _ := parseReleaseVersion(latest.TagName)
+	if !newerRelease(latest.TagName, u.currentVersion) {
+		fmt.Fprintf(u.output, "croc is up to date (current: v%s, latest %s release: v%s).\n", u.currentVersion, channel, version)
+		return nil
+	}
+	if checkOnly {
+		fmt.Fprintf(u.output, "A newer croc version is available: v%s (current: v%s).\nRun: croc update\n", version, u.currentVersion)
+		return nil
+	}
+	if u.publicKey == "" {
+		return errors.New("this build of croc cannot verify releases; download the new version from https://github.com/schollz/croc/releases")
+	}
+	publicKey, err := selfupdate.ParsePublicKey(u.publicKey)
+	if err != nil {
+		return err
+	}
+	if u.platform == "" {
+		return fmt.Errorf("no croc releases are built for %s/%s", runtime.GOOS, runtime.GOARCH)
+	}
+
+	binaryName := "croc"
+	archiveName := fmt.Sprintf("croc_%s_%s.tar.gz", latest.TagName, u.platform)
+	if runtime.GOOS == "windows" {
+		binaryName = "croc.exe"
+		archiveName = fmt.Sprintf("croc_%s_%s.zip", latest.TagName, u.platform)
+	}
+	checksumsName := fmt.Sprintf("croc_%s_checksums.txt", latest.TagName)
+	checksums, err := u.downloadAsset(ctx, latest, checksumsName, maxReleaseResponseSize)
+	if err != nil {
+		return err
+	}
+	signature, err := u.downloadAsset(ctx, latest, checksumsName+".minisig", maxReleaseResponseSize)
+	if err != nil {
+		return err
+	}
+	if err = publicKey.Verify(checksums, signature); err != nil {
+		return fmt.Errorf("%s: %w", checksumsName, err)
+	}
+	// The archive names carry the release tag, so an older signed checksums
+	// file cannot vouch for the archive of a newer release.
+	archive, err := u.downloadAsset(ctx, latest, archiveName, maxReleaseArchiveSize)
+	if err != nil {
+		return err
+	}
+	if err = selfupdate.VerifyChecksum(checksums, archiveName, archive); err != nil {
+		return err
+	}
+	binary, err := selfupdate.ExtractBinary(archiveName, archive, binaryName)
+	if err != nil {
+		return fmt.Errorf("%s: %w", archiveName, err)
+	}
+	if err = selfupdate.Replace(u.executable, binary); err != nil {
+		return fmt.Errorf("could not replace %s: %w", u.executable, err)
+	}
+	fmt.Fprintf(u.output, "Updated croc from v%s to v%s.\n", u.currentVersion, version)
+	return nil
+}
+
+// latestRelease returns the newest release of a channel. The stable channel
+// follows GitHub's latest release; the prerelease channel also includes
+// releases marked as prereleases.
+func (u releaseUpdater) latestRelease(ctx context.Context, channel string) (release, error) {
+	switch channel {
+	case updateChannelStable:
+		var latest release
+		if err := u.getJSON(ctx, u.endpoint+"/latest", maxReleaseResponseSize, &latest); err != nil {
+			return release{}, err
+		}
+		if _, ok := parseReleaseVersion(latest.TagName); !ok {
+			return release{}, errors.New("release response contains an invalid version")
+		}
+		return latest, nil
+	case updateChannelPrerelease:
+		var releases []release
+		if err := u.getJSON(ctx, u.endpoint+"?per_page=30", maxReleaseListSize, &releases); err != nil {
+			return release{}, err
+		}
+		var latest release
+		for _, candidate := range releases {
+			if candidate.Draft {
+				continue
+			}
+			if _, ok := parseReleaseVersion(candidate.TagName); !ok {
+				continue
+			}
+			if latest.TagName == "" || newerRelease(candidate.TagName, latest.TagName) {
+				latest = candidate
+			}
+		}
+		if latest.TagName == "" {
+			return release{}, errors.New("no releases found")
+		}
+		return latest, nil
+	}
+	return release{}, fmt.Errorf("unknown release channel %q (use %s or %s)", channel, updateChannelStable, updateChannelPrerelease)
+}
+
+func (u releaseUpdater) getJSON(ctx context.Context, url string, limit int64, v any) error {
+	body, err := u.get(ctx, url, "application/vnd.github+json", limit)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+func (u releaseUpdater) downloadAsset(ctx context.Context, r release, name string, limit int64) ([]byte, error) {
+	asset, err := r.asset(name)
+	if err != nil {
+		return nil, err
+	}
+	body, err := u.get(ctx, asset.URL, "application/octet-stream", limit)
+	if err != nil {
+		return nil, fmt.Errorf("downloading %s: %w", name, err)
+	}
+	return body, nil
+}
+
+func (u releaseUpdater) get(ctx context.Context, url, accept string, limit int64) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", accept)
+	request.Header.Set("User-Agent", "croc/"+u.currentVersion)
+	response, err := u.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < http.StatusOK || response.StatusCode >= http.StatusMultipleChoices {
+		return nil, fmt.Errorf("release service returned HTTP %d", response.StatusCode)
+	}
+	if response.ContentLength > limit {
+		return nil, errors.New("release response is too large")
+	}
+	body, err := io.ReadAll(io.LimitReader(response.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > limit {
+		return nil, errors.New("release response is too large")
+	}
+	return body, nil
+}
@@ -0,0 +1,260 @@
+package cli
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// releaseStandIn serves a release list and assets like the GitHub API.
+type releaseStandIn struct {
+	server      *httptest.Server
+	releases    []release
+	prereleases map[string]bool
+	assets      map[string][]byte
+}
+
+func newReleaseStandIn(t *testing.T) *releaseStandIn {
+	t.Helper()
+	standIn := &releaseStandIn{prereleases: make(map[string]bool), assets: make(map[string][]byte)}
+	standIn.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/releases/latest":
+			for _, candidate := range standIn.releases {
+				if !candidate.Draft && !standIn.prereleases[candidate.TagName] {
+					_ = json.NewEncoder(w).Encode(candidate)
+					return
+				}
+			}
+			http.NotFound(w, r)
+		case r.URL.Path == "/releases":
+			_ = json.NewEncoder(w).Encode(standIn.releases)
+		case strings.HasPrefix(r.URL.Path, "/download/"):
+			asset, ok := standIn.assets[strings.TrimPrefix(r.URL.Path, "/download/")]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			_, _ = w.Write(asset)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(standIn.server.Close)
+	return standIn
+}
+
+// publish adds a release whose checksums are signed with privateKey.
+func (s *releaseStandIn) publish(t *testing.T, tag string, privateKey ed25519.PrivateKey, binary []byte) {
+	t.Helper()
+	archiveName, archive := testReleaseArchive(t, tag, binary)
+	sum := sha256.Sum256(archive)
+	checksums := []byte(hex.EncodeToString(sum[:]) + "  " + archiveName + "\n")
+	checksumsName := "croc_" + tag + "_checksums.txt"
+	s.assets[archiveName] = archive
+	s.assets[checksumsName] = checksums
+	s.assets[checksumsName+".minisig"] = testMinisign(privateKey, checksums)
+	published := release{TagName: tag}
+	for _, name := range []string{archiveName, checksumsName, checksumsName + ".minisig"} {
+		published.Assets = append(published.Assets, releaseAsset{Name: name, URL: s.server.URL + "/download/" + name})
+	}
+	s.releases = append([]release{published}, s.releases...)
+}
+
+func (s *releaseStandIn) updater(t *testing.T, publicKey ed25519.PublicKey, output *bytes.Buffer) releaseUpdater {
+	t.Helper()
+	executable := filepath.Join(t.TempDir(), "croc")
+	if err := os.WriteFile(executable, []byte("current"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return releaseUpdater{
+		endpoint:       s.server.URL + "/releases",
+		currentVersion: "11.0.0",
+		publicKey:      testMinisignPublicKey(publicKey),
+		platform:       "Test-64bit",
+		executable:     executable,
+		client:         s.server.Client(),
+		output:         output,
+	}
+}
+
+func TestUpdateReplacesExecutable(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	standIn := newReleaseStandIn(t)
+	standIn.publish(t, "v11.1.0", privateKey, []byte("new croc"))
+	var output bytes.Buffer
+	updater := standIn.updater(t, publicKey, &output)
+
+	if err := updater.run(context.Background(), updateChannelStable, true); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output.String(), "A newer croc version is available: v11.1.0") {
+		t.Fatalf("check output = %q", output.String())
+	}
+	assertExecutable(t, updater.executable, "current")
+
+	if err := updater.run(context.Background(), updateChannelStable, false); err != nil {
+		t.Fatal(err)
+	}
+	assertExecutable(t, updater.executable, "new croc")
+	if !strings.Contains(output.String(), "Updated croc from v11.0.0 to v11.1.0.") {
+		t.Fatalf("update output = %q", output.String())
+	}
+
+	output.Reset()
+	updater.currentVersion = "11.1.0"
+	if err := updater.run(context.Background(), updateChannelStable, false); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output.String(), "croc is up to date") {
+		t.Fatalf("up-to-date output = %q", output.String())
+	}
+}
+
+func TestUpdateRejectsUnverifiedReleases(t *testing.T) {
+	publicKey, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	standIn := newReleaseStandIn(t)
+	standIn.publish(t, "v11.1.0", otherKey, []byte("untrusted croc"))
+	var output bytes.Buffer
+	updater := standIn.updater(t, publicKey, &output)
+
+	err = updater.run(context.Background(), updateChannelStable, false)
+	if err == nil || !strings.Contains(err.Error(), "signature") {
+		t.Fatalf("update with a foreign signature returned %v", err)
+	}
+	assertExecutable(t, updater.executable, "current")
+
+	// a correctly signed checksums file does not vouch for a swapped archive
+	_, privateKey, _ := ed25519.GenerateKey(nil)
+	updater.publicKey = testMinisignPublicKey(privateKey.Public().(ed25519.PublicKey))
+	standIn.publish(t, "v11.2.0", privateKey, []byte("new croc"))
+	archiveName, _ := testReleaseArchive(t, "v11.2.0", nil)
+	_, standIn.assets[archiveName] = testReleaseArchive(t, "v11.2.0", []byte("swapped croc"))
+	err = updater.run(context.Background(), updateChannelStable, false)
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("update with a swapped archive returned %v", err)
+	}
+	assertExecutable(t, updater.executable, "current")
+
+	updater.publicKey = ""
+	err = updater.run(context.Background(), updateChannelStable, false)
+	if err == nil || !strings.Contains(err.Error(), "cannot verify releases") {
+		t.Fatalf("update without a public key returned %v", err)
+	}
+}
+
+func TestUpdateChannels(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	standIn := newReleaseStandIn(t)
+	standIn.publish(t, "v11.1.0", privateKey, []byte("stable croc"))
+	standIn.publish(t, "v11.2.0", privateKey, []byte("prerelease croc"))
+	standIn.prereleases["v11.2.0"] = true
+	standIn.releases = append(standIn.releases, release{TagName: "v12.0.0", Draft: true})
+	var output bytes.Buffer
+	updater := standIn.updater(t, publicKey, &output)
+
+	latest, err := updater.latestRelease(context.Background(), updateChannelStable)
+	if err != nil || latest.TagName != "v11.1.0" {
+		t.Fatalf("stable release = %q, %v", latest.TagName, err)
+	}
+	if err := updater.run(context.Background(), updateChannelPrerelease, false); err != nil {
+		t.Fatal(err)
+	}
+	assertExecutable(t, updater.executable, "prerelease croc")
+
+	if _, err := updater.latestRelease(context.Background(), "nightly"); err == nil {
+		t.Fatal("unknown channel was accepted")
+	}
+}
+
+func assertExecutable(t *testing.T, executable, want string) {
+	t.Helper()
+	content, err := os.ReadFile(executable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != want {
+		t.Fatalf("executable = %q, want %q", content, want)
+	}
+}
+
+// testReleaseArchive builds the archive of a release for this platform.
+func testReleaseArchive(t *testing.T, tag string, binary []byte) (string, []byte) {
+	t.Helper()
+	var archive bytes.Buffer
+	if runtime.GOOS == "windows" {
+		zw := zip.NewWriter(&archive)
+		w, err := zw.Create("croc.exe")
+		if err == nil {
+			_, err = w.Write(binary)
+		}
+		if err == nil {
+			err = zw.Close()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fmt.Sprintf("croc_%s_Test-64bit.zip", tag), archive.Bytes()
+	}
+	gz := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gz)
+	err := tw.WriteHeader(&tar.Header{Name: "croc", Mode: 0o755, Size: int64(len(binary)), Typeflag: tar.TypeReg})
+	if err == nil {
+		_, err = tw.Write(binary)
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = gz.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fmt.Sprintf("croc_%s_Test-64bit.tar.gz", tag), archive.Bytes()
+}
+
+var testMinisignKeyID = []byte("crockey1")
+
+func testMinisignPublicKey(publicKey ed25519.PublicKey) string {
+	return base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), testMinisignKeyID...), publicKey...))
+}
+
+// testMinisign makes a legacy minisign signature of message.
+func testMinisign(privateKey ed25519.PrivateKey, message []byte) []byte {
+	signature := ed25519.Sign(privateKey, message)
+	comment := "timestamp:0"
+	global := ed25519.Sign(privateKey, append(bytes.Clone(signature), comment...))
+	return []byte("untrusted comment: signature from minisign secret key\n" +
+		base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), testMinisignKeyID...), signature...)) + "\n" +
+		"trusted comment: " + comment + "\n" +
+		base64.StdEncoding.EncodeToString(global) + "\n")
+}
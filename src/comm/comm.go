@@ -36,11 +36,36 @@ type Comm struct {
 
 // NewConnection gets a new comm to a tcp address
 func NewConnection(address string, timelimit ...time.Duration) (c *Comm, err error) {
+	return Transport{}.NewConnection(address, timelimit...)
+}
+
+// NewConnection gets a new comm to address, over TLS or through croc-web
+// when t reaches the address's host that way.
+func (t Transport) NewConnection(address string, timelimit ...time.Duration) (c *Comm, err error) {
 	tlimit := 30 * time.Second
 	if len(timelimit) > 0 {
 		tlimit = timelimit[0]
 	}
 	var connection net.Conn
+	if endpoint, ok := t.webSocketURL(address); ok {
+		log.Debugf("dialing to %s through %s", address, endpoint)
+		connection, err = dialWebSocket(endpoint, tlimit)
+	} else if connection, err = dial(address, tlimit); err == nil {
+		connection, err = t.wrapTLS(connection, address, tlimit)
+	}
+	if err != nil {
+		err = fmt.Errorf("comm.NewConnection failed: %w", err)
+		log.Debug(err)
+		return
+	}
+	c = New(connection)
+	log.Debugf("connected to '%s'", address)
+	return
+}
+
+// dial connects to address over TCP, through the SOCKS5 or HTTP proxy when
+// one is configured and address is not local.
+func dial(address string, tlimit time.Duration) (connection net.Conn, err error) {
 	if Socks5Proxy != "" && !utils.IsLocalIP(address) {
 		var dialer proxy.Dialer
 		// prepend schema if no schema is given
@@ -86,14 +111,17 @@ func NewConnection(address string, timelimit ...time.Duration) (c *Comm, err err
 		log.Debugf("dialing to %s with timelimit %s", address, tlimit)
 		connection, err = net.DialTimeout("tcp", address, tlimit)
 	}
+	return
+}
+
+// Wrap returns a comm for a connection to address that was dialed by the
+// caller, starting TLS when t reaches the address over TLS.
+func (t Transport) Wrap(connection net.Conn, address string, timelimit time.Duration) (c *Comm, err error) {
+	connection, err = t.wrapTLS(connection, address, timelimit)
 	if err != nil {
-		err = fmt.Errorf("comm.NewConnection failed: %w", err)
-		log.Debug(err)
-		return
+		return nil, fmt.Errorf("comm.Wrap failed: %w", err)
 	}
-	c = New(connection)
-	log.Debugf("connected to '%s'", address)
-	return
+	return New(connection), nil
 }
 
 // New returns a new comm
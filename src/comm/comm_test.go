@@ -6,6 +6,7 @@ import (
 	"encoding/binary"
 	"io"
 	"net"
+	"strings"
 	"testing"
 	"time"
 
@@ -260,3 +261,84 @@ func benchmarkFrameWriter(b *testing.B, payloadSize int, write func(net.Conn) er
 		b.Fatal(err)
 	}
 }
+
+func TestParseRelayAddress(t *testing.T) {
+	plain, transport, err := ParseRelayAddress("relay.example.com:9009")
+	assert.NoError(t, err)
+	assert.Equal(t, "relay.example.com:9009", plain)
+	assert.Equal(t, Transport{}, transport)
+	assert.Equal(t, plain, transport.URL(plain))
+
+	fingerprint := "sha256:" + strings.Repeat("ab", 32)
+	address, transport, err := ParseRelayAddress("tls://tls-relay.example.com:443#" + strings.ToUpper(fingerprint[7:]))
+	assert.NoError(t, err)
+	assert.Equal(t, "tls-relay.example.com:443", address)
+	assert.Equal(t, "tls://tls-relay.example.com:443#"+fingerprint, transport.URL(address))
+	config, ok := transport.tlsConfig("tls-relay.example.com:9010")
+	assert.True(t, ok)
+	assert.Equal(t, "tls-relay.example.com", config.ServerName)
+	assert.True(t, config.InsecureSkipVerify)
+	// other hosts, such as a peer's local relay, are dialed in plain
+	_, ok = transport.tlsConfig("192.168.1.2:9009")
+	assert.False(t, ok)
+	assert.Equal(t, "192.168.1.2:9009", transport.URL("192.168.1.2:9009"))
+
+	address, transport, err = ParseRelayAddress("tls://[::1]:9009")
+	assert.NoError(t, err)
+	config, ok = transport.tlsConfig(address)
+	assert.True(t, ok)
+	assert.False(t, config.InsecureSkipVerify)
+
+	for _, invalid := range []string{
+		"tls://relay.example.com:443#sha256:abcd",
+		"tls://relay.example.com:443/path",
+		"tls://user@relay.example.com:443",
+		"tls://",
+	} {
+		_, _, err = ParseRelayAddress(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestParseWebSocketRelayAddress(t *testing.T) {
+	address, transport, err := ParseRelayAddress("wss://ws-relay.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "ws-relay.example.com:9009", address)
+	assert.Equal(t, "wss://ws-relay.example.com", transport.URL(address))
+	assert.True(t, transport.IsWebSocket())
+	endpoint, ok := transport.webSocketURL("ws-relay.example.com:9011")
+	assert.True(t, ok)
+	assert.Equal(t, "wss://ws-relay.example.com/ws?port=9011&relay=0", endpoint)
+	_, ok = transport.tlsConfig("ws-relay.example.com:9011")
+	assert.False(t, ok)
+
+	address, transport, err = ParseRelayAddress("ws://[::1]:8080/croc/ws?relay=1&port=9109")
+	assert.NoError(t, err)
+	assert.Equal(t, "[::1]:9109", address)
+	endpoint, ok = transport.webSocketURL(address)
+	assert.True(t, ok)
+	assert.Equal(t, "ws://[::1]:8080/croc/ws?port=9109&relay=1", endpoint)
+
+	// the same host given over TLS keeps its own transport
+	_, tlsTransport, err := ParseRelayAddress("tls://ws-relay.example.com:443")
+	assert.NoError(t, err)
+	assert.False(t, tlsTransport.IsWebSocket())
+	_, wsTransport, err := ParseRelayAddress("wss://ws-relay.example.com")
+	assert.NoError(t, err)
+	_, ok = tlsTransport.tlsConfig("ws-relay.example.com:443")
+	assert.True(t, ok)
+	_, ok = wsTransport.webSocketURL("ws-relay.example.com:443")
+	assert.True(t, ok)
+
+	for _, invalid := range []string{
+		"wss://",
+		"wss://user@relay.example.com",
+		"wss://relay.example.com#sha256:abcd",
+		"wss://relay.example.com?relay=-1",
+		"wss://relay.example.com?port=0",
+		"wss://relay.example.com?token=secret",
+	} {
+		_, _, err = ParseRelayAddress(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
@@ -0,0 +1,240 @@
+package comm
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/schollz/logger"
+	"golang.org/x/net/quic"
+)
+
+// QUICProtocol is the ALPN protocol of relay connections over QUIC.
+const QUICProtocol = "croc-relay"
+
+// QUICConfig returns the QUIC settings shared by relays and clients. The
+// buffers are larger than the defaults so that a single connection can carry
+// several transfer streams at full speed.
+func QUICConfig(tlsConfig *tls.Config) *quic.Config {
+	tlsConfig = tlsConfig.Clone()
+	tlsConfig.MinVersion = tls.VersionTLS13
+	tlsConfig.NextProtos = []string{QUICProtocol}
+	return &quic.Config{
+		TLSConfig:                tlsConfig,
+		MaxStreamReadBufferSize:  8 << 20,
+		MaxStreamWriteBufferSize: 8 << 20,
+		MaxConnReadBufferSize:    32 << 20,
+		// transfers can sit idle while the recipient answers a prompt
+		KeepAlivePeriod: 10 * time.Second,
+	}
+}
+
+// DialQUIC opens a QUIC connection to the relay at address and returns count
+// streams over it, verifying the relay's certificate as t does over TLS. The
+// connection is closed with its last stream.
+func (t Transport) DialQUIC(address string, count int, timelimit time.Duration) (conns []net.Conn, err error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, ok := t.tlsConfig(address)
+	if !ok {
+		// Without TLS settings the relay is trusted as much as over plain TCP:
+		// the relay handshake that follows checks the relay password, and
+		// transfers are encrypted end to end.
+		tlsConfig = &tls.Config{ServerName: host, InsecureSkipVerify: true}
+	}
+	endpoint, err := quic.Listen("udp", ":0", nil)
+	if err != nil {
+		return nil, fmt.Errorf("comm.DialQUIC failed: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timelimit)
+	defer cancel()
+	log.Debugf("dialing QUIC to %s", address)
+	conn, err := endpoint.Dial(ctx, "udp", address, QUICConfig(tlsConfig))
+	if err != nil {
+		endpoint.Close(context.Background())
+		return nil, fmt.Errorf("comm.DialQUIC failed: %w", err)
+	}
+
+	session := &quicSession{conn: conn, endpoint: endpoint}
+	session.open.Store(int64(count))
+	for range count {
+		stream, streamErr := conn.NewStream(ctx)
+		if streamErr != nil {
+			for _, opened := range conns {
+				opened.Close()
+			}
+			conn.Abort(nil)
+			endpoint.Close(context.Background())
+			return nil, fmt.Errorf("comm.DialQUIC failed: %w", streamErr)
+		}
+		conns = append(conns, StreamConn(conn, stream, session.release))
+	}
+	log.Debugf("connected to '%s' over QUIC", address)
+	return conns, nil
+}
+
+// quicSession closes a client connection once all of its streams are closed.
+type quicSession struct {
+	conn     *quic.Conn
+	endpoint *quic.Endpoint
+	open     atomic.Int64
+}
+
+func (s *quicSession) release() {
+	if s.open.Add(-1) != 0 {
+		return
+	}
+	go func() {
+		s.conn.Abort(nil)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		s.endpoint.Close(ctx)
+	}()
+}
+
+// StreamConn makes a QUIC stream usable as a net.Conn. release, when not
+// nil, is called once after the stream is closed.
+func StreamConn(conn *quic.Conn, stream *quic.Stream, release func()) net.Conn {
+	return &streamConn{conn: conn, stream: stream, release: release}
+}
+
+type streamConn struct {
+	conn    *quic.Conn
+	stream  *quic.Stream
+	release func()
+	closed  sync.Once
+
+	readDeadline  deadline
+	writeDeadline deadline
+}
+
+func (c *streamConn) Read(b []byte) (int, error) {
+	ctx, done := c.readDeadline.start()
+	defer done()
+	c.stream.SetReadContext(ctx)
+	n, err := c.stream.Read(b)
+	return n, deadlineError(ctx, err)
+}
+
+func (c *streamConn) Write(b []byte) (int, error) {
+	ctx, done := c.writeDeadline.start()
+	defer done()
+	c.stream.SetWriteContext(ctx)
+	n, err := c.stream.Write(b)
+	if err == nil {
+		// small control messages must not wait for the buffer to fill
+		err = c.stream.Flush()
+	}
+	return n, deadlineError(ctx, err)
+}
+
+// Close ends both directions of the stream without waiting for the peer to
+// acknowledge the data, as closing a TCP connection does.
+func (c *streamConn) Close() error {
+	err := net.ErrClosed
+	c.closed.Do(func() {
+		c.stream.CloseRead()
+		c.stream.CloseWrite()
+		if c.release != nil {
+			c.release()
+		}
+		err = nil
+	})
+	return err
+}
+
+func (c *streamConn) LocalAddr() net.Addr {
+	return net.UDPAddrFromAddrPort(c.conn.LocalAddr())
+}
+
+func (c *streamConn) RemoteAddr() net.Addr {
+	return net.UDPAddrFromAddrPort(c.conn.RemoteAddr())
+}
+
+func (c *streamConn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
+	return nil
+}
+
+func (c *streamConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+func (c *streamConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
+	return nil
+}
+
+// deadline implements net.Conn deadlines for one direction of a stream, whose
+// operations are bounded by contexts instead.
+type deadline struct {
+	mu     sync.Mutex
+	at     time.Time
+	cancel context.CancelFunc
+	timer  *time.Timer
+}
+
+// start returns the context for an operation and a function to call when the
+// operation has finished.
+func (d *deadline) start() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	d.mu.Lock()
+	d.cancel = cancel
+	d.armLocked()
+	d.mu.Unlock()
+	return ctx, func() {
+		d.mu.Lock()
+		d.cancel = nil
+		d.stopLocked()
+		d.mu.Unlock()
+		cancel()
+	}
+}
+
+// set changes the deadline, including for an operation in progress.
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.at = t
+	d.stopLocked()
+	if d.cancel != nil {
+		d.armLocked()
+	}
+}
+
+func (d *deadline) armLocked() {
+	if d.at.IsZero() {
+		return
+	}
+	wait := time.Until(d.at)
+	if wait <= 0 {
+		d.cancel()
+		return
+	}
+	d.timer = time.AfterFunc(wait, d.cancel)
+}
+
+func (d *deadline) stopLocked() {
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+}
+
+// deadlineError reports a cancelled operation as a timeout, like net.Conn.
+func deadlineError(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
+		return os.ErrDeadlineExceeded
+	}
+	return err
+}
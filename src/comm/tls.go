@@ -0,0 +1,148 @@
+package comm
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TLSScheme prefixes relay addresses that must be reached over TLS.
+const TLSScheme = "tls://"
+
+// Transport is how a relay written as tls://host:port, or as the ws:// or
+// wss:// URL of a croc-web server, is reached. It applies to every port of
+// the relay's host, since a relay's transfer ports share its listener
+// configuration. The zero Transport dials plain TCP.
+type Transport struct {
+	host      string
+	tls       *relayTLSSettings
+	webSocket *relayWebSocketSettings
+}
+
+type relayTLSSettings struct {
+	fingerprint []byte
+}
+
+// ParseFingerprint parses a SHA-256 certificate fingerprint written as hex,
+// optionally colon-separated and prefixed with "sha256:".
+func ParseFingerprint(value string) ([]byte, error) {
+	value = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(value)), "sha256:")
+	fingerprint, err := hex.DecodeString(strings.ReplaceAll(value, ":", ""))
+	if err != nil || len(fingerprint) != sha256.Size {
+		return nil, errors.New("relay fingerprint must be a SHA-256 certificate hash")
+	}
+	return fingerprint, nil
+}
+
+// Fingerprint formats the SHA-256 fingerprint of a DER certificate.
+func Fingerprint(certificate []byte) string {
+	sum := sha256.Sum256(certificate)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// ParseRelayAddress accepts a relay address, optionally written as
+// tls://host:port, tls://host:port#sha256:<fingerprint>, or as the ws:// or
+// wss:// URL of a croc-web server. It returns the address as host:port and
+// the transport to dial it with; plain addresses are returned unchanged with
+// the zero Transport.
+func ParseRelayAddress(address string) (string, Transport, error) {
+	lower := strings.ToLower(address)
+	if strings.HasPrefix(lower, WebSocketScheme) || strings.HasPrefix(lower, SecureWebSocketScheme) {
+		return parseWebSocketRelay(address)
+	}
+	if !strings.HasPrefix(lower, TLSScheme) {
+		return address, Transport{}, nil
+	}
+	parsed, err := url.Parse(address)
+	if err != nil || parsed.Host == "" || parsed.User != nil ||
+		(parsed.Path != "" && parsed.Path != "/") || parsed.RawQuery != "" {
+		return "", Transport{}, fmt.Errorf("invalid TLS relay address %q", address)
+	}
+	var settings relayTLSSettings
+	if parsed.Fragment != "" {
+		if settings.fingerprint, err = ParseFingerprint(parsed.Fragment); err != nil {
+			return "", Transport{}, err
+		}
+	}
+	return parsed.Host, Transport{host: parsed.Hostname(), tls: &settings}, nil
+}
+
+// Applies reports whether address is on the relay host t was parsed for.
+func (t Transport) Applies(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	return t.host != "" && strings.Trim(host, "[]") == t.host
+}
+
+// URL formats address the way its relay was given, restoring the TLS scheme
+// and fingerprint or the WebSocket URL so that it can be passed to --relay
+// again. Addresses on other hosts are returned unchanged.
+func (t Transport) URL(address string) string {
+	if !t.Applies(address) {
+		return address
+	}
+	if t.webSocket != nil {
+		return t.webSocket.address
+	}
+	address = TLSScheme + address
+	if t.tls.fingerprint != nil {
+		address += "#sha256:" + hex.EncodeToString(t.tls.fingerprint)
+	}
+	return address
+}
+
+func (t Transport) tlsConfig(address string) (*tls.Config, bool) {
+	if t.tls == nil || !t.Applies(address) {
+		return nil, false
+	}
+	config := &tls.Config{ServerName: t.host, MinVersion: tls.VersionTLS12}
+	fingerprint := t.tls.fingerprint
+	if fingerprint != nil {
+		// A pinned certificate replaces chain verification, which allows
+		// self-signed relay certificates.
+		config.InsecureSkipVerify = true
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			return verifyFingerprint(state.PeerCertificates, fingerprint)
+		}
+	}
+	return config, true
+}
+
+func verifyFingerprint(certificates []*x509.Certificate, fingerprint []byte) error {
+	if len(certificates) == 0 {
+		return errors.New("relay presented no certificate")
+	}
+	sum := sha256.Sum256(certificates[0].Raw)
+	if subtle.ConstantTimeCompare(sum[:], fingerprint) != 1 {
+		return fmt.Errorf("relay certificate fingerprint %s does not match", Fingerprint(certificates[0].Raw))
+	}
+	return nil
+}
+
+// wrapTLS starts a client TLS session over connection when t reaches its
+// address over TLS.
+func (t Transport) wrapTLS(connection net.Conn, address string, timeout time.Duration) (net.Conn, error) {
+	config, ok := t.tlsConfig(address)
+	if !ok {
+		return connection, nil
+	}
+	tlsConnection := tls.Client(connection, config)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := tlsConnection.HandshakeContext(ctx); err != nil {
+		connection.Close()
+		return nil, fmt.Errorf("relay TLS handshake: %w", err)
+	}
+	return tlsConnection, nil
+}
@@ -0,0 +1,95 @@
+package comm
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+)
+
+// WebSocket schemes prefix relay addresses that are reached through the
+// /ws endpoint of a croc-web server, for networks that only pass HTTP(S).
+const (
+	WebSocketScheme       = "ws://"
+	SecureWebSocketScheme = "wss://"
+)
+
+// defaultRelayPort matches models.DEFAULT_PORT, the relay port that a
+// WebSocket relay address without ?port= connects to.
+const defaultRelayPort = "9009"
+
+// webSocketReadLimit bounds a single WebSocket message. croc-web forwards the
+// relay stream in 64 KiB messages.
+const webSocketReadLimit = 1024 * 1024
+
+// relayWebSocketSettings name the croc-web endpoint that bridges a relay.
+// Every port of the relay's host is dialed through that endpoint.
+type relayWebSocketSettings struct {
+	endpoint url.URL
+	// address is the relay address as it was given.
+	address string
+}
+
+// parseWebSocketRelay parses ws://host[:port][/path][?relay=N&port=P]. The
+// path defaults to /ws, relay selects one of croc-web's relay hosts, and port
+// is the relay port of the control connection.
+func parseWebSocketRelay(address string) (string, Transport, error) {
+	parsed, err := url.Parse(address)
+	if err != nil || parsed.Host == "" || parsed.User != nil || parsed.Fragment != "" ||
+		(parsed.Scheme != "ws" && parsed.Scheme != "wss") {
+		return "", Transport{}, fmt.Errorf("invalid WebSocket relay address %q", address)
+	}
+	query := parsed.Query()
+	relayIndex, port := "0", defaultRelayPort
+	for key, values := range query {
+		if len(values) != 1 {
+			return "", Transport{}, fmt.Errorf("invalid WebSocket relay address %q", address)
+		}
+		switch key {
+		case "relay":
+			relayIndex = values[0]
+			if index, parseErr := strconv.Atoi(relayIndex); parseErr != nil || index < 0 {
+				return "", Transport{}, fmt.Errorf("invalid relay index %q in %q", relayIndex, address)
+			}
+		case "port":
+			port = values[0]
+			if number, parseErr := strconv.ParseUint(port, 10, 16); parseErr != nil || number == 0 {
+				return "", Transport{}, fmt.Errorf("invalid relay port %q in %q", port, address)
+			}
+		default:
+			return "", Transport{}, fmt.Errorf("unknown WebSocket relay parameter %q in %q", key, address)
+		}
+	}
+	endpoint := *parsed
+	if endpoint.Path == "" || endpoint.Path == "/" {
+		endpoint.Path = "/ws"
+	}
+	endpoint.RawQuery = url.Values{"relay": {relayIndex}}.Encode()
+
+	host := parsed.Hostname()
+	settings := &relayWebSocketSettings{endpoint: endpoint, address: address}
+	return net.JoinHostPort(host, port), Transport{host: host, webSocket: settings}, nil
+}
+
+// IsWebSocket reports whether t dials through a croc-web WebSocket endpoint.
+// Such relays only carry TCP streams.
+func (t Transport) IsWebSocket() bool {
+	return t.webSocket != nil
+}
+
+// webSocketURL returns the WebSocket URL that bridges to address when t
+// reaches its host through croc-web.
+func (t Transport) webSocketURL(address string) (string, bool) {
+	if t.webSocket == nil || !t.Applies(address) {
+		return "", false
+	}
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", false
+	}
+	endpoint := t.webSocket.endpoint
+	query := endpoint.Query()
+	query.Set("port", port)
+	endpoint.RawQuery = query.Encode()
+	return endpoint.String(), true
+}
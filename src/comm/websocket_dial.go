@@ -0,0 +1,36 @@
+//go:build !js
+
+package comm
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// dialWebSocket opens a WebSocket to a croc-web endpoint and returns it as a
+// byte stream. The endpoint is reached through the configured proxy, as
+// relays are.
+func dialWebSocket(endpoint string, timelimit time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timelimit)
+	defer cancel()
+	transport := &http.Transport{
+		DialContext: func(_ context.Context, _, address string) (net.Conn, error) {
+			return dial(address, timelimit)
+		},
+		TLSHandshakeTimeout: timelimit,
+	}
+	defer transport.CloseIdleConnections()
+	socket, _, err := websocket.Dial(ctx, endpoint, &websocket.DialOptions{
+		HTTPClient: &http.Client{Transport: transport},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("relay WebSocket: %w", err)
+	}
+	socket.SetReadLimit(webSocketReadLimit)
+	return websocket.NetConn(context.Background(), socket, websocket.MessageBinary), nil
+}
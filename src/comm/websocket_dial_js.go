@@ -0,0 +1,25 @@
+//go:build js
+
+package comm
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+// dialWebSocket opens a WebSocket to a croc-web endpoint with the browser's
+// WebSocket API, which applies the browser's own proxy settings.
+func dialWebSocket(endpoint string, timelimit time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timelimit)
+	defer cancel()
+	socket, _, err := websocket.Dial(ctx, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("relay WebSocket: %w", err)
+	}
+	socket.SetReadLimit(webSocketReadLimit)
+	return websocket.NetConn(context.Background(), socket, websocket.MessageBinary), nil
+}
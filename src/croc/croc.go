@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"crypto/cipher"
+	"crypto/mlkem"
 	"crypto/rand"
 	"encoding/binary"
 	"encoding/hex"
@@ -18,6 +19,7 @@ import (
 	"path"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -45,6 +47,7 @@ import (
 	"github.com/schollz/croc/v11/src/redact"
 	"github.com/schollz/croc/v11/src/tcp"
 	"github.com/schollz/croc/v11/src/termui"
+	"github.com/schollz/croc/v11/src/transfer"
 	"github.com/schollz/croc/v11/src/utils"
 )
 
@@ -53,6 +56,12 @@ var (
 	handshakeRequest = []byte("handshake")
 
 	alternateSenderRouteTimeout = 10 * time.Second
+
+	// relayKeepaliveTimeout is how long a sender waiting for a receiver
+	// tolerates silence from a relay that pings every second.
+	relayKeepaliveTimeout      = 30 * time.Second
+	reservationRetryMinBackoff = time.Second
+	reservationRetryMaxBackoff = time.Minute
 )
 
 func encryptLocalProbePayload(key, plaintext []byte) ([]byte, error) {
@@ -70,7 +79,7 @@ func decryptLocalProbePayload(key, ciphertext []byte) ([]byte, error) {
 }
 
 const (
-	ReconnectVersion                   = 1
+	ReconnectVersion                   = transfer.ReconnectVersion
 	maxReconnectAttempts               = 10
 	reconnectCandidateHandshakeTimeout = 2 * time.Second
 	maxDecompressedChunkSize           = models.TCP_BUFFER_SIZE/2 + 8
@@ -114,18 +123,33 @@ type Options struct {
 	Overwrite         bool
 	Rename            bool
 	Curve             string
+	PostQuantum       bool
 	HashAlgorithm     string
 	ThrottleUpload    string
 	ZipFolder         bool
 	TestFlag          bool
 	GitIgnore         bool
 	MulticastAddress  string
+	Discovery         []string
+	DisableDirect     bool
+	QUIC              bool
+	TUI               bool
 	ShowQrCode        bool
 	Exclude           []string
 	ExcludeFile       []string
 	Quiet             bool
 	DisableClipboard  bool
 	ExtendedClipboard bool
+	// WaitForReceiver keeps a sender registered in a reserved relay room
+	// for up to this long while no receiver has joined.
+	WaitForReceiver time.Duration
+	// ReservationToken authenticates the room reservation. It is never
+	// remembered.
+	ReservationToken string `json:"-"`
+	// RelayToken, when set, authenticates to the configured relays in place
+	// of RelayPassword. It is never sent to a peer's local relay or
+	// remembered.
+	RelayToken string `json:"-"`
 }
 
 type SimpleMessage struct {
@@ -181,20 +205,44 @@ type Client struct {
 	pakeCurve               string
 	pakeKeys                pakekey.Keys
 	pakeConfirmationPending bool
+	pakeKEM                 string
+	pakeHybridKey           *mlkem.DecapsulationKey768
+	pakeEncapsulationKey    []byte
+	pakeCiphertext          []byte
+	pakeKEMSharedKey        []byte
+	pakeKEMRequested        bool
 	nextReconnectRoom       string
 	relayControlAddress     string
+	// relays dials the configured relays with the transport their address
+	// was given with, and every other relay over plain TCP.
+	relays *tcp.Dialer
+	// tokenRelayHosts are the hosts of the configured relays, the only ones
+	// that are sent Options.RelayToken.
+	tokenRelayHosts         []string
 	reconnectRelayAddresses []string
 	reconnectRelayMu        sync.Mutex
 	reconnectVersion        int
 	peerReconnectVersion    int
-	peerPerFileCompression  bool
-	senderRouteReady        chan struct{}
-	filesReady              chan struct{}
-	filesReadyErr           error
-	senderRouteReadyOnce    sync.Once
-	externalIPReady         chan struct{}
-	externalIPReadyOnce     sync.Once
-	transferStarted         atomic.Bool
+	// session checks the order of file phase messages from the peer.
+	session                *transfer.Session
+	peerPerFileCompression bool
+	peerMerkleChunks       bool
+	merkleTree             *utils.MerkleTree
+	merkleBadChunks        []int64
+	merkleRetryRanges      []int64
+	pendingChunkRanges     []int64
+	merkleRetries          int
+	senderRouteReady       chan struct{}
+	filesReady             chan struct{}
+	filesReadyErr          error
+	senderRouteReadyOnce   sync.Once
+	externalIPReady        chan struct{}
+	externalIPReadyOnce    sync.Once
+	transferStarted        atomic.Bool
+	direct                 *directConnection
+	// route describes how the data connections reach the peer
+	route string
+	tui   *transferTUI
 	// localRelayPort is the control port of the ephemeral local relay started by
 	// setupLocalRelay(). It is captured before any goroutines that might
 	// overwrite c.Options.RelayPorts are launched.
@@ -228,60 +276,22 @@ type Chunk struct {
 }
 
 // FileInfo registers the information about the file
-type FileInfo struct {
-	Name         string      `json:"n,omitempty"`
-	FolderRemote string      `json:"fr,omitempty"`
-	FolderSource string      `json:"fs,omitempty"`
-	Hash         []byte      `json:"h,omitempty"`
-	Size         int64       `json:"s,omitempty"`
-	ModTime      time.Time   `json:"m,omitempty"`
-	IsCompressed bool        `json:"c"`
-	IsEncrypted  bool        `json:"e,omitempty"`
-	Symlink      string      `json:"sy,omitempty"`
-	Mode         os.FileMode `json:"md,omitempty"`
-	TempFile     bool        `json:"tf,omitempty"`
-	IsIgnored    bool        `json:"ig,omitempty"`
-}
+type FileInfo = transfer.FileInfo
 
 // RemoteFileRequest requests specific bytes
-type RemoteFileRequest struct {
-	CurrentFileChunkRanges    []int64
-	FilesToTransferCurrentNum int
-	MachineID                 string
-	ReconnectVersion          int
-	Features                  []string `json:",omitempty"`
-}
+type RemoteFileRequest = transfer.RemoteFileRequest
 
 // SenderInfo lists the files to be transferred
-type SenderInfo struct {
-	FilesToTransfer        []FileInfo
-	EmptyFoldersToTransfer []FileInfo
-	TotalNumberFolders     int
-	MachineID              string
-	Ask                    bool
-	SendingText            bool
-	NoCompress             bool
-	HashAlgorithm          string
-	ReconnectVersion       int
-	NextReconnectRoom      string
-	Features               []string `json:",omitempty"`
-}
-
-const perFileCompressionFeature = "per-file-compression-v1"
+type SenderInfo = transfer.SenderInfo
 
 // ErrRelayConnection marks a failure to establish a relay control or data
 // connection. Callers may use it to invalidate cached relay selections without
 // treating peer or transfer failures as relay availability failures.
 var ErrRelayConnection = errors.New("relay connection failed")
 
-func supportsFeature(features []string, wanted string) bool {
-	for _, feature := range features {
-		if feature == wanted {
-			return true
-		}
-	}
-	return false
-}
+// ErrNoReceiver is returned when Options.WaitForReceiver runs out before a
+// receiver joins the sender's room.
+var ErrNoReceiver = errors.New("no receiver connected")
 
 // New establishes a new connection for transferring files between two instances.
 func New(ops Options) (c *Client, err error) {
@@ -301,6 +311,9 @@ func New(ops Options) (c *Client, err error) {
 		}
 	}
 
+	if err = validateDiscovery(c.Options.Discovery); err != nil {
+		return
+	}
 	codeComponents, err := codephrase.Parse(c.Options.SharedSecret)
 	if err != nil {
 		return
@@ -310,6 +323,22 @@ func New(ops Options) (c *Client, err error) {
 	c.baseRoomName = c.Options.RoomName
 	c.reconnectVersion = ReconnectVersion
 
+	var transport, transport6 comm.Transport
+	if c.Options.RelayAddress, transport, err = comm.ParseRelayAddress(c.Options.RelayAddress); err != nil {
+		return
+	}
+	if c.Options.RelayAddress6, transport6, err = comm.ParseRelayAddress(c.Options.RelayAddress6); err != nil {
+		return
+	}
+	c.relays = tcp.NewDialer(transport, transport6)
+	if c.Options.RelayToken != "" {
+		for _, address := range []string{c.Options.RelayAddress, c.Options.RelayAddress6} {
+			if address != "" {
+				c.tokenRelayHosts = append(c.tokenRelayHosts, relayHost(address))
+			}
+		}
+	}
+
 	c.conn = make([]*comm.Comm, 16)
 
 	// initialize throttler
@@ -374,9 +403,28 @@ func (c *Client) redactError(err error) error {
 		c.baseRoomName,
 		c.Options.RoomName,
 		c.nextReconnectRoom,
+		c.Options.RelayToken,
 	)
 }
 
+// relayCredential returns what authenticates the client to the relay at
+// address: the relay token for the configured relays, the relay password
+// for any other, such as a peer's local relay.
+func (c *Client) relayCredential(address string) string {
+	if c.Options.RelayToken != "" && slices.Contains(c.tokenRelayHosts, relayHost(address)) {
+		return c.Options.RelayToken
+	}
+	return c.Options.RelayPassword
+}
+
+// relayHost returns the host of a relay address with or without a port.
+func relayHost(address string) string {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+	return address
+}
+
 type transferDisconnectError struct {
 	err error
 }
@@ -606,6 +654,7 @@ func (c *Client) canRetryTransfer(err error, attempt int) bool {
 }
 
 func (c *Client) closeAttempt() {
+	c.closeDirect()
 	for _, conn := range c.conn {
 		if conn != nil {
 			conn.Close()
@@ -645,7 +694,13 @@ func (c *Client) resetForReconnectAttempt(attempt int) error {
 	c.pakeCurve = ""
 	c.pakeKeys = pakekey.Keys{}
 	c.pakeConfirmationPending = false
+	c.clearHybridKeyExchange()
+	c.session = nil
 	c.peerPerFileCompression = false
+	c.peerMerkleChunks = false
+	c.merkleBadChunks = nil
+	c.merkleRetryRanges = nil
+	c.pendingChunkRanges = nil
 	c.CurrentFileChunkRanges = nil
 	c.CurrentFileChunkCount = 0
 	c.TotalSent = 0
@@ -730,86 +785,6 @@ func isEmptyFolder(folderPath string) (bool, error) {
 	return false, nil
 }
 
-func normalizeReceiveFolder(folder string) (string, error) {
-	cleanFolder, err := receivefs.Normalize(folder, true)
-	if err != nil {
-		return "", fmt.Errorf("filename must be a local path: %w", err)
-	}
-	if strings.Contains(cleanFolder, ".ssh") {
-		return "", fmt.Errorf("invalid path detected: %q", folder)
-	}
-	return cleanFolder, nil
-}
-
-func normalizeReceiveFilePath(folder, name string) (string, string, error) {
-	cleanFolder, err := normalizeReceiveFolder(folder)
-	if err != nil {
-		return "", "", err
-	}
-	cleanName, err := receivefs.Normalize(name, false)
-	if err != nil || cleanName != path.Base(cleanName) {
-		if err == nil {
-			err = receivefs.ErrUnsafePath
-		}
-		return "", "", fmt.Errorf("filename must be a local path: %w", err)
-	}
-	destination := path.Clean(path.Join(cleanFolder, cleanName))
-	if _, err = receivefs.Normalize(destination, false); err != nil {
-		return "", "", fmt.Errorf("filename must be a local path: %w", err)
-	}
-	return cleanFolder, destination, nil
-}
-
-func validateReceiveSymlinkTarget(folder, target string) error {
-	cleanTarget, err := receivefs.Normalize(target, false)
-	if err != nil {
-		return fmt.Errorf("symlink target must be a local path: %w", err)
-	}
-	if _, err = receivefs.Normalize(path.Join(folder, cleanTarget), false); err != nil {
-		return fmt.Errorf("symlink target escapes receive directory: %w", err)
-	}
-	return nil
-}
-
-func validateReceiveMetadata(files []FileInfo, emptyFolders []FileInfo) ([]FileInfo, []FileInfo, error) {
-	normalizedFiles := make([]FileInfo, len(files))
-	normalizedEmptyFolders := make([]FileInfo, len(emptyFolders))
-	entries := make([]receivefs.Entry, 0, len(files)+len(emptyFolders))
-
-	for i, fi := range files {
-		cleanFolder, destination, err := normalizeReceiveFilePath(fi.FolderRemote, fi.Name)
-		if err != nil {
-			return nil, nil, err
-		}
-		kind := receivefs.KindFile
-		if fi.Symlink != "" {
-			if err := validateReceiveSymlinkTarget(cleanFolder, fi.Symlink); err != nil {
-				return nil, nil, err
-			}
-			kind = receivefs.KindSymlink
-		}
-		entries = append(entries, receivefs.Entry{Path: destination, Kind: kind})
-		normalizedFiles[i] = fi
-		normalizedFiles[i].FolderRemote = cleanFolder
-		normalizedFiles[i].Name = path.Base(strings.ReplaceAll(fi.Name, "\\", "/"))
-	}
-
-	for i, fi := range emptyFolders {
-		cleanFolder, err := normalizeReceiveFolder(fi.FolderRemote)
-		if err != nil {
-			return nil, nil, err
-		}
-		entries = append(entries, receivefs.Entry{Path: cleanFolder, Kind: receivefs.KindDirectory})
-		normalizedEmptyFolders[i] = fi
-		normalizedEmptyFolders[i].FolderRemote = cleanFolder
-	}
-	if _, err := receivefs.ValidateEntries(entries); err != nil {
-		return nil, nil, fmt.Errorf("duplicate destination path: %w", err)
-	}
-
-	return normalizedFiles, normalizedEmptyFolders, nil
-}
-
 func (c *Client) receiveFilesystem() (*receivefs.Root, error) {
 	c.receiveRootMu.Lock()
 	defer c.receiveRootMu.Unlock()
@@ -1185,17 +1160,11 @@ func shouldCompressFile(path string, sample, compressedOutput []byte) (bool, []b
 }
 
 func (c *Client) currentFileUsesCompression() bool {
-	if c.Options.NoCompress {
-		return false
-	}
-	// Older peers only understand the global switch and expect all chunks to
-	// be compressed whenever NoCompress is false.
-	if !c.peerPerFileCompression {
-		return true
+	var file FileInfo
+	if c.FilesToTransferCurrentNum >= 0 && c.FilesToTransferCurrentNum < len(c.FilesToTransfer) {
+		file = c.FilesToTransfer[c.FilesToTransferCurrentNum]
 	}
-	return c.FilesToTransferCurrentNum >= 0 &&
-		c.FilesToTransferCurrentNum < len(c.FilesToTransfer) &&
-		c.FilesToTransfer[c.FilesToTransferCurrentNum].IsCompressed
+	return transfer.FileCompressed(c.Options.NoCompress, c.peerPerFileCompression, file)
 }
 
 func (c *Client) setupLocalRelay() {
@@ -1271,7 +1240,7 @@ func (c *Client) transferOverLocalRelay(errchan chan<- error) {
 	}
 	localControlAddress := "127.0.0.1:" + c.localRelayPort
 	var banner string
-	conn, banner, _, err := tcp.ConnectToTCPServer(localControlAddress, c.Options.RelayPassword, c.Options.RoomName)
+	conn, banner, _, err := c.relays.ConnectToTCPServer(localControlAddress, c.Options.RelayPassword, c.Options.RoomName)
 	log.Debugf("banner: %s", banner)
 	if err != nil {
 		err = fmt.Errorf("could not connect to 127.0.0.1:%s: %w", c.localRelayPort, err)
@@ -1285,7 +1254,20 @@ func (c *Client) transferOverLocalRelay(errchan chan<- error) {
 			errchan <- err
 			return
 		}
-		data, _ := conn.Receive()
+		data, errReceive := conn.Receive()
+		if errReceive != nil {
+			if err := c.ctxErr(); err != nil {
+				errchan <- err
+				return
+			}
+			errReceive = fmt.Errorf("local relay connection closed: %w", errReceive)
+			log.Debug(errReceive)
+			if c.Options.OnlyLocal {
+				errchan <- errReceive
+			}
+			// otherwise the relay route is still in play
+			return
+		}
 		if bytes.Equal(data, handshakeRequest) {
 			break
 		} else if bytes.Equal(data, []byte{1}) {
@@ -1313,17 +1295,29 @@ func (c *Client) transferOverLocalRelay(errchan chan<- error) {
 
 func (c *Client) senderWaitForHandshake(conn *comm.Comm) error {
 	var kB []byte
+	// while only the relay's pings arrive, a silent relay means the
+	// registration is gone
+	keepalive := c.Options.WaitForReceiver > 0
 	for {
 		if err := c.ctxErr(); err != nil {
 			return err
 		}
 		var dataMessage SimpleMessage
 		log.Trace("waiting for bytes")
-		data, errConn := conn.Receive()
+		var data []byte
+		var errConn error
+		if keepalive {
+			data, errConn = conn.ReceiveWithDeadline(time.Now().Add(relayKeepaliveTimeout))
+		} else {
+			data, errConn = conn.Receive()
+		}
 		if errConn != nil {
 			log.Tracef("[%+v] had error: %s", conn, errConn.Error())
 			return errConn
 		}
+		if !bytes.Equal(data, []byte{1}) {
+			keepalive = false
+		}
 		json.Unmarshal(data, &dataMessage)
 		log.Tracef("received local-probe frame (%d bytes)", len(data))
 		if kB != nil {
@@ -1433,6 +1427,103 @@ func (c *Client) senderWaitForHandshake(conn *comm.Comm) error {
 	}
 }
 
+// senderRegisterWithRelay connects the sender to its room on the relay,
+// trying the IPv6 relay first. A positive hold reserves the room for that
+// long.
+func (c *Client) senderRegisterWithRelay(hold time.Duration) (conn *comm.Comm, banner, selectedAddress string, err error) {
+	var ipaddr string
+	durations := []time.Duration{100 * time.Millisecond, 5 * time.Second}
+	for i, address := range []string{c.Options.RelayAddress6, c.Options.RelayAddress} {
+		if address == "" {
+			continue
+		}
+		host, port, _ := net.SplitHostPort(address)
+		log.Debugf("host: '%s', port: '%s'", host, port)
+		// Default port to :9009
+		if port == "" {
+			host = address
+			port = models.DEFAULT_PORT
+		}
+		log.Debugf("got host '%v' and port '%v'", host, port)
+		address = net.JoinHostPort(host, port)
+		log.Debugf("trying connection to %s", address)
+		if hold > 0 {
+			reservation := tcp.Reservation{Token: c.Options.ReservationToken, Hold: hold}
+			conn, banner, ipaddr, err = c.relays.ReserveRoom(address, c.relayCredential(address), c.Options.RoomName, reservation, durations[i])
+		} else {
+			conn, banner, ipaddr, err = c.relays.ConnectToTCPServer(address, c.relayCredential(address), c.Options.RoomName, durations[i])
+		}
+		if err == nil {
+			selectedAddress = address
+			break
+		}
+		log.Debugf("could not establish '%s'", address)
+	}
+	if conn == nil && err == nil {
+		err = fmt.Errorf("could not connect")
+	}
+	if err != nil {
+		err = fmt.Errorf("%w: could not connect to %s: %w", ErrRelayConnection, c.Options.RelayAddress, err)
+		log.Debug(err)
+		return nil, "", "", err
+	}
+	log.Debugf("banner: %s", banner)
+	log.Debugf("connection established: %+v", conn)
+	// Preserve the public relay's observation before a direct route can
+	// switch the sender to its own loopback relay.
+	c.ExternalIP = ipaddr
+	c.markExternalIPReady()
+	return conn, banner, selectedAddress, nil
+}
+
+// senderWaitForReceiver keeps the sender in a reserved relay room until a
+// receiver starts the handshake, registering again whenever the relay drops
+// the connection, until Options.WaitForReceiver runs out.
+func (c *Client) senderWaitForReceiver() (conn *comm.Comm, banner, selectedAddress string, err error) {
+	deadline := time.Now().Add(c.Options.WaitForReceiver)
+	backoff := reservationRetryMinBackoff
+	for {
+		hold := time.Until(deadline)
+		if hold <= 0 {
+			if err != nil {
+				return nil, "", "", fmt.Errorf("%w within %s: %w", ErrNoReceiver, c.Options.WaitForReceiver, err)
+			}
+			return nil, "", "", fmt.Errorf("%w within %s", ErrNoReceiver, c.Options.WaitForReceiver)
+		}
+		conn, banner, selectedAddress, err = c.senderRegisterWithRelay(hold)
+		if err == nil {
+			log.Debugf("waiting up to %s for a receiver", hold.Round(time.Second))
+			// the relay only sweeps expired rooms periodically
+			expire := time.AfterFunc(hold, conn.Close)
+			err = c.senderWaitForHandshake(conn)
+			if !expire.Stop() {
+				err = nil
+			} else if err == nil {
+				return conn, banner, selectedAddress, nil
+			}
+			conn.Close()
+			backoff = reservationRetryMinBackoff
+		}
+		if ctxErr := c.ctxErr(); ctxErr != nil {
+			return nil, "", "", ctxErr
+		}
+		if errors.Is(err, tcp.ErrReservationsUnsupported) || errors.Is(err, tcp.ErrReservationRejected) ||
+			isFatalSenderRouteError(err) {
+			return nil, "", "", err
+		}
+		if err != nil {
+			log.Debugf("registering with relay again after: %v", c.redactError(err))
+		}
+		wait := min(backoff, time.Until(deadline))
+		select {
+		case <-time.After(wait):
+		case <-c.stop.ctx.Done():
+			return nil, "", "", c.stop.ctx.Err()
+		}
+		backoff = min(2*backoff, reservationRetryMaxBackoff)
+	}
+}
+
 func isFatalSenderRouteError(err error) bool {
 	if err == nil {
 		return false
@@ -1476,7 +1567,7 @@ func (c *Client) reconnectRelayAttempt(handshake func(*comm.Comm) error) error {
 	}
 	var reconnectErrors []string
 	for _, address := range candidates {
-		conn, banner, ipaddr, err := tcp.ConnectToTCPServer(address, c.Options.RelayPassword, room)
+		conn, banner, ipaddr, err := c.relays.ConnectToTCPServer(address, c.relayCredential(address), room)
 		if err != nil {
 			reconnectErrors = append(reconnectErrors, fmt.Sprintf("%s: %v", address, err))
 			continue
@@ -1526,11 +1617,35 @@ func (c *Client) receiverReconnectRelayAttempt(attempt int) error {
 	return nil
 }
 
+// receiveCommandFlags returns the global flags the recipient needs, each
+// followed by a space, for the printed receive command.
+func (c *Client) receiveCommandFlags() string {
+	flags := &strings.Builder{}
+	if !c.Options.PublicRelay && c.Options.RelayAddress != models.DEFAULT_RELAY && !c.Options.OnlyLocal {
+		relay := c.relays.Transport(c.Options.RelayAddress).URL(c.Options.RelayAddress)
+		if strings.ContainsAny(relay, "#?&") {
+			relay = "'" + relay + "'"
+		}
+		flags.WriteString("--relay " + relay + " ")
+	}
+	if c.Options.RelayPassword != models.DEFAULT_PASSPHRASE {
+		flags.WriteString("--pass " + c.Options.RelayPassword + " ")
+	}
+	if c.Options.PostQuantum {
+		// only the recipient offers ML-KEM, and this sender refuses
+		// recipients that do not
+		flags.WriteString("--pq ")
+	}
+	return flags.String()
+}
+
 // Send will send the specified file
 func (c *Client) Send(filesInfo []FileInfo, emptyFoldersToTransfer []FileInfo, totalNumberFolders int) (err error) {
 	defer func() { err = c.redactError(err) }()
 	go c.stop.done()
 	defer c.stop.Cancel()
+	c.openTUI()
+	defer c.tui.stop()
 	c.EmptyFoldersToTransfer = emptyFoldersToTransfer
 	c.TotalNumberFolders = totalNumberFolders
 	c.TotalNumberOfContents = len(filesInfo)
@@ -1542,17 +1657,11 @@ func (c *Client) Send(filesInfo []FileInfo, emptyFoldersToTransfer []FileInfo, t
 		close(c.filesReady)
 		hashResult <- c.filesReadyErr
 	}()
-	flags := &strings.Builder{}
-	if !c.Options.PublicRelay && c.Options.RelayAddress != models.DEFAULT_RELAY && !c.Options.OnlyLocal {
-		flags.WriteString("--relay " + c.Options.RelayAddress + " ")
-	}
-	if c.Options.RelayPassword != models.DEFAULT_PASSPHRASE {
-		flags.WriteString("--pass " + c.Options.RelayPassword + " ")
-	}
+	flags := c.receiveCommandFlags()
 	webURL := webReceiveURL(c.Options.SharedSecret)
 	clipboardNotice := ""
 	if !c.Options.DisableClipboard {
-		clipboardText := formatClipboardText(c.Options.SharedSecret, flags.String(), c.Options.ExtendedClipboard)
+		clipboardText := formatClipboardText(c.Options.SharedSecret, flags, c.Options.ExtendedClipboard)
 		if copyToClipboard(clipboardText, true, c.Options.ExtendedClipboard) {
 			clipboardNotice = "code copied to clipboard"
 			if c.Options.ExtendedClipboard {
@@ -1561,7 +1670,7 @@ func (c *Client) Send(filesInfo []FileInfo, emptyFoldersToTransfer []FileInfo, t
 		}
 	}
 	output, colorEnabled := termui.Output(os.Stderr)
-	fmt.Fprint(output, formatSendInstructions(c.Options.SharedSecret, flags.String(), webURL, clipboardNotice, colorEnabled))
+	fmt.Fprint(output, formatSendInstructions(c.Options.SharedSecret, flags, webURL, clipboardNotice, colorEnabled))
 	if c.Options.ShowQrCode {
 		showReceiveCommandQrCode(webURL)
 	}
@@ -1579,58 +1688,35 @@ func (c *Client) Send(filesInfo []FileInfo, emptyFoldersToTransfer []FileInfo, t
 		// add two things to the error channel
 		errchan = make(chan error, 2)
 		c.setupLocalRelay()
-		// broadcast on ipv4
-		go c.broadcastOnLocalNetwork(false)
-		// broadcast on ipv6
-		go c.broadcastOnLocalNetwork(true)
+		if c.discoveryEnabled(DiscoveryMulticast) {
+			// broadcast on ipv4
+			go c.broadcastOnLocalNetwork(false)
+			// broadcast on ipv6
+			go c.broadcastOnLocalNetwork(true)
+		}
+		if c.discoveryEnabled(DiscoveryMDNS) {
+			go c.advertiseOnLocalNetwork()
+		}
 		go c.transferOverLocalRelay(errchan)
 	}
 
 	if !c.Options.OnlyLocal {
 		go func() {
 			defer c.markExternalIPReady()
-			var ipaddr, banner string
 			var conn *comm.Comm
-			var selectedAddress string
+			var banner, selectedAddress string
 			var routeErr error
-			durations := []time.Duration{100 * time.Millisecond, 5 * time.Second}
-			for i, address := range []string{c.Options.RelayAddress6, c.Options.RelayAddress} {
-				if address == "" {
-					continue
-				}
-				host, port, _ := net.SplitHostPort(address)
-				log.Debugf("host: '%s', port: '%s'", host, port)
-				// Default port to :9009
-				if port == "" {
-					host = address
-					port = models.DEFAULT_PORT
-				}
-				log.Debugf("got host '%v' and port '%v'", host, port)
-				address = net.JoinHostPort(host, port)
-				log.Debugf("trying connection to %s", address)
-				conn, banner, ipaddr, routeErr = tcp.ConnectToTCPServer(address, c.Options.RelayPassword, c.Options.RoomName, durations[i])
+			if c.Options.WaitForReceiver > 0 {
+				conn, banner, selectedAddress, routeErr = c.senderWaitForReceiver()
+			} else {
+				conn, banner, selectedAddress, routeErr = c.senderRegisterWithRelay(0)
 				if routeErr == nil {
-					selectedAddress = address
-					break
+					if routeErr = c.senderWaitForHandshake(conn); routeErr != nil {
+						conn.Close()
+					}
 				}
-				log.Debugf("could not establish '%s'", address)
-			}
-			if conn == nil && routeErr == nil {
-				routeErr = fmt.Errorf("could not connect")
 			}
 			if routeErr != nil {
-				routeErr = fmt.Errorf("%w: could not connect to %s: %v", ErrRelayConnection, c.Options.RelayAddress, routeErr)
-				log.Debug(routeErr)
-				errchan <- routeErr
-				return
-			}
-			log.Debugf("banner: %s", banner)
-			log.Debugf("connection established: %+v", conn)
-			// Preserve the public relay's observation before a direct route can
-			// switch the sender to its own loopback relay.
-			c.ExternalIP = ipaddr
-			c.markExternalIPReady()
-			if routeErr = c.senderWaitForHandshake(conn); routeErr != nil {
 				errchan <- routeErr
 				return
 			}
@@ -1700,6 +1786,8 @@ func webReceiveURL(code string) string {
 type peerDiscoveryResult struct {
 	discoveries []peerdiscovery.Discovered
 	err         error
+	// sameRoom marks mDNS results, which only name senders of this room.
+	sameRoom bool
 }
 
 var (
@@ -1710,7 +1798,7 @@ var (
 
 func (c *Client) discoverReceivePeers() (discoveries []peerdiscovery.Discovered) {
 	c.setReceiveStatus(receiveStatusLookingForSender)
-	resultChan := make(chan peerDiscoveryResult, 2)
+	resultChan := make(chan peerDiscoveryResult, 3)
 	stopDiscovery := make(chan struct{})
 	var closeOnce sync.Once
 	closeDiscovery := func() {
@@ -1727,9 +1815,11 @@ func (c *Client) discoverReceivePeers() (discoveries []peerdiscovery.Discovered)
 		}
 	}()
 
+	started := 0
 	startDiscovery := func(settings peerdiscovery.Settings) {
 		settings.StopChan = stopDiscovery
 		discover := peerDiscover
+		started++
 		go func() {
 			found, err := discover(settings)
 			resultChan <- peerDiscoveryResult{
@@ -1739,30 +1829,48 @@ func (c *Client) discoverReceivePeers() (discoveries []peerdiscovery.Discovered)
 		}()
 	}
 
-	startDiscovery(peerdiscovery.Settings{
-		Limit:            1,
-		Payload:          []byte("ok"),
-		Delay:            20 * time.Millisecond,
-		TimeLimit:        receivePeerDiscoveryTimeLimit,
-		MulticastAddress: c.Options.MulticastAddress,
-	})
-	startDiscovery(peerdiscovery.Settings{
-		Limit:     1,
-		Payload:   []byte("ok"),
-		Delay:     20 * time.Millisecond,
-		TimeLimit: receivePeerDiscoveryTimeLimit,
-		IPVersion: peerdiscovery.IPv6,
-	})
+	if c.discoveryEnabled(DiscoveryMulticast) {
+		startDiscovery(peerdiscovery.Settings{
+			Limit:            1,
+			Payload:          []byte("ok"),
+			Delay:            20 * time.Millisecond,
+			TimeLimit:        receivePeerDiscoveryTimeLimit,
+			MulticastAddress: c.Options.MulticastAddress,
+		})
+		startDiscovery(peerdiscovery.Settings{
+			Limit:     1,
+			Payload:   []byte("ok"),
+			Delay:     20 * time.Millisecond,
+			TimeLimit: receivePeerDiscoveryTimeLimit,
+			IPVersion: peerdiscovery.IPv6,
+		})
+	}
+	if c.discoveryEnabled(DiscoveryMDNS) {
+		started++
+		go func() {
+			found, err := c.browseLocalNetwork(stopDiscovery)
+			resultChan <- peerDiscoveryResult{
+				discoveries: found,
+				err:         err,
+				sameRoom:    true,
+			}
+		}()
+	}
 
 	timer := time.NewTimer(receivePeerDiscoveryTimeout)
 	defer timer.Stop()
-	for remaining := 2; remaining > 0; remaining-- {
+	for remaining := started; remaining > 0; remaining-- {
 		select {
 		case result := <-resultChan:
 			if result.err != nil {
 				log.Debugf("peer discovery failed: %v", result.err)
 				continue
 			}
+			if result.sameRoom {
+				// try senders of this room before any other croc sender
+				discoveries = append(result.discoveries, discoveries...)
+				continue
+			}
 			discoveries = append(discoveries, result.discoveries...)
 		case <-timer.C:
 			log.Debug("peer discovery timed out")
@@ -1780,6 +1888,8 @@ func (c *Client) Receive() (err error) {
 	go c.stop.done()
 	defer c.stop.Cancel()
 	defer c.clearReceiveStatus()
+	c.openTUI()
+	defer c.tui.stop()
 	if _, err = c.receiveFilesystem(); err != nil {
 		return err
 	}
@@ -1859,7 +1969,7 @@ func (c *Client) Receive() (err error) {
 		log.Debugf("got host '%v' and port '%v'", host, port)
 		address = net.JoinHostPort(host, port)
 		log.Debugf("trying connection to %s", address)
-		c.conn[0], banner, c.ExternalIP, err = tcp.ConnectToTCPServer(address, c.Options.RelayPassword, c.Options.RoomName, durations[i])
+		c.conn[0], banner, c.ExternalIP, err = c.relays.ConnectToTCPServer(address, c.relayCredential(address), c.Options.RoomName, durations[i])
 		if err == nil {
 			c.setRelayControlAddress(address)
 			break
@@ -2000,7 +2110,7 @@ func (c *Client) Receive() (err error) {
 				// }
 
 				serverTry := net.JoinHostPort(ip, port)
-				conn, banner2, externalIP, errConn := tcp.ConnectToTCPServer(serverTry, c.Options.RelayPassword, c.Options.RoomName, 500*time.Millisecond)
+				conn, banner2, externalIP, errConn := c.relays.ConnectToTCPServer(serverTry, c.Options.RelayPassword, c.Options.RoomName, 500*time.Millisecond)
 				if errConn != nil {
 					log.Debug(c.redactError(errConn))
 					log.Debug("could not connect to " + serverTry)
@@ -2063,13 +2173,12 @@ func (c *Client) transfer() (err error) {
 	if !c.Options.IsSender && !c.Step1ChannelSecured {
 		c.pakeInitiator = append([]byte(nil), c.Pake.Bytes()...)
 		c.pakeCurve = c.Options.Curve
-		err = message.Send(c.conn[0], c.Key, message.Message{
-			Type:    message.TypePAKE,
-			Version: pakekey.ProtocolVersion,
-			Bytes:   c.pakeInitiator,
-			Bytes2:  []byte(c.Options.Curve),
-		})
-		if err != nil {
+		if c.Options.PostQuantum {
+			if err = c.offerHybridKeyExchange(); err != nil {
+				return
+			}
+		}
+		if err = c.sendPakeInit(); err != nil {
 			return
 		}
 	}
@@ -2116,6 +2225,10 @@ func (c *Client) transfer() (err error) {
 		c.SuccessfulTransfer = false
 		log.Tracef("SuccessfulTransfer: %v", c.redactError(err))
 	}
+	if c.tui.isCancelled() {
+		log.Debugf("cancelled in the TUI: %v", c.redactError(err))
+		err = errTransferCancelled
+	}
 	// purge errors that come from successful transfer
 	if c.SuccessfulTransfer {
 		if err != nil {
@@ -2187,7 +2300,7 @@ func (c *Client) extractReceivedArchives() error {
 		if !file.TempFile {
 			continue
 		}
-		_, archivePath, pathErr := normalizeReceiveFilePath(file.FolderRemote, file.Name)
+		_, archivePath, pathErr := transfer.NormalizeReceiveFilePath(file.FolderRemote, file.Name)
 		if pathErr != nil {
 			return errors.New("received archive failed validation or extraction")
 		}
@@ -2212,7 +2325,7 @@ func (c *Client) extractReceivedArchives() error {
 }
 
 func (c *Client) createEmptyFolder(i int) (err error) {
-	folderRemote, err := normalizeReceiveFolder(c.EmptyFoldersToTransfer[i].FolderRemote)
+	folderRemote, err := transfer.NormalizeReceiveFolder(c.EmptyFoldersToTransfer[i].FolderRemote)
 	if err != nil {
 		return
 	}
@@ -2234,23 +2347,22 @@ func (c *Client) createEmptyFolder(i int) (err error) {
 
 func (c *Client) processMessageFileInfo(m message.Message) (done bool, err error) {
 	c.clearReceiveStatus()
-	var senderInfo SenderInfo
-	err = json.Unmarshal(m.Bytes, &senderInfo)
+	if c.session == nil {
+		c.session = transfer.NewReceiverSession()
+	}
+	step, err := c.session.Handle(m)
 	if err != nil {
-		log.Debug(err)
-		return
+		return true, err
 	}
+	senderInfo := *step.Offer
 	c.Options.SendingText = senderInfo.SendingText
 	c.Options.NoCompress = senderInfo.NoCompress
-	c.peerPerFileCompression = supportsFeature(senderInfo.Features, perFileCompressionFeature)
+	c.peerPerFileCompression = transfer.SupportsFeature(senderInfo.Features, transfer.PerFileCompressionFeature)
 	c.Options.HashAlgorithm = senderInfo.HashAlgorithm
 	c.peerReconnectVersion = senderInfo.ReconnectVersion
 	c.nextReconnectRoom = senderInfo.NextReconnectRoom
 	c.TotalNumberFolders = senderInfo.TotalNumberFolders
-	c.FilesToTransfer, c.EmptyFoldersToTransfer, err = validateReceiveMetadata(senderInfo.FilesToTransfer, senderInfo.EmptyFoldersToTransfer)
-	if err != nil {
-		return true, err
-	}
+	c.FilesToTransfer, c.EmptyFoldersToTransfer = senderInfo.FilesToTransfer, senderInfo.EmptyFoldersToTransfer
 	c.TotalNumberOfContents = 0
 	if c.FilesToTransfer != nil {
 		c.TotalNumberOfContents += len(c.FilesToTransfer)
@@ -2259,9 +2371,6 @@ func (c *Client) processMessageFileInfo(m message.Message) (done bool, err error
 		c.TotalNumberOfContents += len(c.EmptyFoldersToTransfer)
 	}
 
-	if c.Options.HashAlgorithm == "" {
-		c.Options.HashAlgorithm = "xxhash"
-	}
 	log.Debugf("using hash algorithm: %s", c.Options.HashAlgorithm)
 	if c.Options.NoCompress {
 		log.Debug("disabling compression")
@@ -2380,9 +2489,7 @@ func (c *Client) processMessageFileInfo(m message.Message) (done bool, err error
 		c.Step3RecipientRequestFile = true
 		c.Step4FileTransferred = true
 		c.markTransferStarted()
-		errStopTransfer := message.Send(c.conn[0], c.Key, message.Message{
-			Type: message.TypeFinished,
-		})
+		errStopTransfer := message.Send(c.conn[0], c.Key, c.session.Finish())
 		if errStopTransfer != nil {
 			err = errStopTransfer
 		}
@@ -2415,6 +2522,31 @@ func (c *Client) processMessagePake(m message.Message, attempt *transferAttemptS
 	}
 
 	var salt []byte
+	if c.Options.IsSender && c.requireHybridKeyExchange(m) {
+		log.Debug("asking the recipient for the post-quantum key exchange")
+		err = message.Send(c.conn[0], nil, message.Message{
+			Type:    message.TypePAKE,
+			Version: pakekey.ProtocolVersion,
+			Message: pakekey.RequireHybridMLKEM768,
+		})
+		if err != nil {
+			return pakeHandshakeError{err: err}
+		}
+		return nil
+	}
+	if !c.Options.IsSender && m.Message == pakekey.RequireHybridMLKEM768 {
+		if c.pakeKEM != "" || len(c.pakeInitiator) == 0 {
+			return pakeHandshakeError{err: errPostQuantumUnavailable}
+		}
+		log.Debug("sender requires the post-quantum key exchange")
+		if err = c.offerHybridKeyExchange(); err != nil {
+			return pakeHandshakeError{err: err}
+		}
+		if err = c.sendPakeInit(); err != nil {
+			return pakeHandshakeError{err: err}
+		}
+		return nil
+	}
 	if c.Options.IsSender {
 		// initialize curve based on the recipient's choice
 		c.pakeCurve = string(m.Bytes2)
@@ -2438,6 +2570,9 @@ func (c *Client) processMessagePake(m message.Message, attempt *transferAttemptS
 			return pakeHandshakeError{err: err}
 		}
 		c.pakeResponder = append([]byte(nil), c.Pake.Bytes()...)
+		if err = c.acceptHybridKeyExchange(m); err != nil {
+			return pakeHandshakeError{err: err}
+		}
 
 		// generate salt and send it back to recipient
 		log.Debug("generating salt")
@@ -2454,8 +2589,10 @@ func (c *Client) processMessagePake(m message.Message, attempt *transferAttemptS
 		err = message.Send(c.conn[0], nil, message.Message{
 			Type:    message.TypePAKE,
 			Version: pakekey.ProtocolVersion,
+			Message: c.pakeKEM,
 			Bytes:   c.pakeResponder,
 			Bytes2:  salt,
+			Bytes3:  c.pakeCiphertext,
 		})
 		if err != nil {
 			return pakeHandshakeError{err: err}
@@ -2472,6 +2609,9 @@ func (c *Client) processMessagePake(m message.Message, attempt *transferAttemptS
 		if err != nil {
 			return pakeHandshakeError{err: err}
 		}
+		if err = c.completeHybridKeyExchange(m); err != nil {
+			return pakeHandshakeError{err: err}
+		}
 		salt = append([]byte(nil), m.Bytes2...)
 		if err = c.derivePakeKeys(salt); err != nil {
 			return pakeHandshakeError{err: err}
@@ -2494,14 +2634,22 @@ func (c *Client) derivePakeKeys(salt []byte) error {
 	if err != nil {
 		return err
 	}
-	c.pakeKeys, err = pakekey.Derive(sharedKey, pakekey.Context{
+	context := pakekey.Context{
 		Purpose:   pakekey.PurposeTransfer,
 		Room:      c.Options.RoomName,
 		Curve:     c.pakeCurve,
 		Initiator: c.pakeInitiator,
 		Responder: c.pakeResponder,
 		Salt:      salt,
-	})
+	}
+	if c.pakeKEM != "" {
+		context.KEM = c.pakeKEM
+		context.EncapsulationKey = c.pakeEncapsulationKey
+		context.Ciphertext = c.pakeCiphertext
+		c.pakeKeys, err = pakekey.DeriveHybrid(sharedKey, c.pakeKEMSharedKey, context)
+	} else {
+		c.pakeKeys, err = pakekey.Derive(sharedKey, context)
+	}
 	if err != nil {
 		return err
 	}
@@ -2539,6 +2687,10 @@ func (c *Client) processMessagePakeConfirm(m message.Message, attempt *transferA
 	c.dataAEAD = dataAEAD
 	c.pakeKeys = pakekey.Keys{}
 	c.pakeConfirmationPending = false
+	if c.pakeKEM != "" {
+		log.Debugf("channel keys include the %s exchange", c.pakeKEM)
+	}
+	c.clearHybridKeyExchange()
 	return c.activateSecureChannel(attempt)
 }
 
@@ -2549,7 +2701,6 @@ func (c *Client) activateSecureChannel(attempt *transferAttemptState) (err error
 	}
 
 	// connects to the other ports of the server for transfer
-	var wg sync.WaitGroup
 	relayControlAddress := c.currentRelayControlAddress()
 	if relayControlAddress == "" {
 		relayControlAddress = c.Options.RelayAddress
@@ -2559,13 +2710,42 @@ func (c *Client) activateSecureChannel(attempt *transferAttemptState) (err error
 	if err != nil {
 		return fmt.Errorf("bad relay address %s: %w", relayControlAddress, err)
 	}
+	// the peers look for a direct connection while the relay ports connect
+	c.startDirectConnection(relayControlAddress)
 
 	if need := len(c.Options.RelayPorts) + 1; len(c.conn) < need {
 		newConn := make([]*comm.Comm, need)
 		copy(newConn, c.conn)
 		c.conn = newConn
 	}
+	c.route = routeRelay
+	if utils.IsLocalIP(relayControlAddress) {
+		c.route = routeLocalRelay
+	}
+	if c.connectDataOverQUIC(relayControlAddress) {
+		c.route += " over QUIC"
+		if !c.Options.IsSender && c.direct == nil {
+			c.startReceivingData(attempt)
+		}
+	} else if err = c.connectDataOverTCP(relayHost, attempt); err != nil {
+		return err
+	}
+	if !c.Options.IsSender {
+		log.Debug("sending external IP")
+		localIPs, _ := utils.GetLocalIPs()
+		err = message.Send(c.conn[0], c.Key, message.Message{
+			Type:    message.TypeExternalIP,
+			Message: preferredPublicIP(c.ExternalIP, localIPs),
+			Bytes:   c.pakeResponder,
+			Bytes2:  encodeDirectCandidates(c.directCandidates()),
+		})
+	}
+	return
+}
 
+// connectDataOverTCP connects to each transfer port of the relay.
+func (c *Client) connectDataOverTCP(relayHost string, attempt *transferAttemptState) error {
+	var wg sync.WaitGroup
 	errc := make(chan error, len(c.Options.RelayPorts))
 	wg.Add(len(c.Options.RelayPorts))
 	for i := 0; i < len(c.Options.RelayPorts); i++ {
@@ -2574,9 +2754,9 @@ func (c *Client) activateSecureChannel(attempt *transferAttemptState) (err error
 			defer wg.Done()
 			server := net.JoinHostPort(relayHost, c.Options.RelayPorts[j])
 			log.Debugf("connecting to %s", server)
-			dataConn, _, _, connErr := tcp.ConnectToTCPServer(
+			dataConn, _, _, connErr := c.relays.ConnectToTCPServer(
 				server,
-				c.Options.RelayPassword,
+				c.relayCredential(server),
 				fmt.Sprintf("%s-%d", c.Options.RoomName, j),
 			)
 			if connErr != nil {
@@ -2585,7 +2765,9 @@ func (c *Client) activateSecureChannel(attempt *transferAttemptState) (err error
 			}
 			c.conn[j+1] = dataConn
 			log.Debugf("connected to %s", server)
-			if !c.Options.IsSender {
+			// with a direct connection pending, the recipient starts reading
+			// once it knows which connections carry the data
+			if !c.Options.IsSender && c.direct == nil {
 				go c.receiveData(j, c.conn[j+1], attempt)
 			}
 		}(i)
@@ -2597,34 +2779,49 @@ func (c *Client) activateSecureChannel(attempt *transferAttemptState) (err error
 			return fmt.Errorf("%w: could not connect transfer ports: %v", ErrRelayConnection, connectErr)
 		}
 	}
-	if !c.Options.IsSender {
-		log.Debug("sending external IP")
-		localIPs, _ := utils.GetLocalIPs()
-		err = message.Send(c.conn[0], c.Key, message.Message{
-			Type:    message.TypeExternalIP,
-			Message: preferredPublicIP(c.ExternalIP, localIPs),
-			Bytes:   c.pakeResponder,
-		})
-	}
-	return
+	return nil
 }
 
-func (c *Client) processExternalIP(m message.Message) (done bool, err error) {
+func (c *Client) processExternalIP(m message.Message, attempt *transferAttemptState) (done bool, err error) {
 	log.Debug("received encrypted external endpoint metadata")
+	var directOffer []string
 	if c.Options.IsSender {
 		c.waitForExternalIP()
 		localIPs, _ := utils.GetLocalIPs()
 		advertisedIP := preferredPublicIP(c.ExternalIP, localIPs)
 		log.Debugf("advertising public IP: %s", advertisedIP)
+		// only offer a direct connection to a recipient that offered one
+		if len(m.Bytes2) > 0 {
+			directOffer = c.directCandidates()
+		}
 		err = message.Send(c.conn[0], c.Key, message.Message{
 			Type:    message.TypeExternalIP,
 			Message: advertisedIP,
+			Bytes2:  encodeDirectCandidates(directOffer),
 		})
 		if err != nil {
 			return true, err
 		}
 	}
 	c.ExternalIPConnected = preferredPeerIP(c.ExternalIPConnected, m.Message)
+	if c.Options.IsSender {
+		if len(directOffer) > 0 {
+			// the channel is secured once the recipient chose the connections
+			c.startSenderPunch(decodeDirectCandidates(m.Bytes2))
+			return
+		}
+		c.closeDirect()
+	} else if c.direct != nil {
+		if len(m.Bytes2) > 0 {
+			err = c.selectDirectConnection(decodeDirectCandidates(m.Bytes2), attempt)
+			if err != nil {
+				return true, err
+			}
+		} else {
+			c.closeDirect()
+			c.startReceivingData(attempt)
+		}
+	}
 	log.Debug("peer endpoint metadata exchange completed")
 	c.Step1ChannelSecured = true
 	if !c.Options.IsSender {
@@ -2648,11 +2845,31 @@ func (c *Client) processMessage(payload []byte, attempt *transferAttemptState) (
 		return
 	}
 
+	var step transfer.Step
+	switch m.Type {
+	case message.TypeFinished, message.TypeRecipientReady, message.TypeCloseSender, message.TypeCloseRecipient:
+		if c.session == nil {
+			return true, fmt.Errorf("%w %q", transfer.ErrUnexpectedMessage, m.Type)
+		}
+		step, err = c.session.Handle(m)
+		if err != nil {
+			return true, err
+		}
+		if step.Reply != nil {
+			if err = message.Send(c.conn[0], c.Key, *step.Reply); err != nil {
+				if step.Done {
+					// The peer already finished; only our acknowledgement was lost.
+					c.tui.finish()
+					c.SuccessfulTransfer = true
+				}
+				return step.Done, err
+			}
+		}
+	}
+
 	switch m.Type {
 	case message.TypeFinished:
-		err = message.Send(c.conn[0], c.Key, message.Message{
-			Type: message.TypeFinished,
-		})
+		c.tui.finish()
 		done = true
 		c.SuccessfulTransfer = true
 		return
@@ -2667,7 +2884,9 @@ func (c *Client) processMessage(payload []byte, attempt *transferAttemptState) (
 			log.Debug(err)
 		}
 	case message.TypeExternalIP:
-		done, err = c.processExternalIP(m)
+		done, err = c.processExternalIP(m, attempt)
+	case message.TypeDirect:
+		done, err = c.processDirect(m)
 	case message.TypeError:
 		// c.spinner.Stop()
 		log.Trace("Peer initiates interruption of my loops and goroutines")
@@ -2678,13 +2897,21 @@ func (c *Client) processMessage(payload []byte, attempt *transferAttemptState) (
 	case message.TypeFileInfo:
 		done, err = c.processMessageFileInfo(m)
 	case message.TypeRecipientReady:
-		var remoteFile RemoteFileRequest
-		err = json.Unmarshal(m.Bytes, &remoteFile)
-		if err != nil {
+		remoteFile := *step.Request
+		c.peerReconnectVersion = remoteFile.ReconnectVersion
+		c.peerPerFileCompression = transfer.SupportsFeature(remoteFile.Features, transfer.PerFileCompressionFeature)
+		c.peerMerkleChunks = transfer.SupportsFeature(remoteFile.Features, transfer.MerkleChunksFeature)
+		if c.currentFileUsesMerkle() && !c.peerMerkleChunks {
+			err = message.Send(c.conn[0], c.Key, message.Message{
+				Type:    message.TypeError,
+				Message: "recipient cannot verify merkle chunks",
+			})
+			if err == nil {
+				err = errors.New("the recipient's croc cannot verify --hash merkle; ask them to upgrade or choose another --hash")
+			}
+			done = true
 			return
 		}
-		c.peerReconnectVersion = remoteFile.ReconnectVersion
-		c.peerPerFileCompression = supportsFeature(remoteFile.Features, perFileCompressionFeature)
 		c.FilesToTransferCurrentNum = remoteFile.FilesToTransferCurrentNum
 		c.CurrentFileChunkRanges = remoteFile.CurrentFileChunkRanges
 		c.CurrentFileChunkCount = utils.ChunkRangesCount(
@@ -2693,6 +2920,7 @@ func (c *Client) processMessage(payload []byte, attempt *transferAttemptState) (
 			models.TCP_BUFFER_SIZE/2,
 		)
 		log.Debugf("current file has %d requested chunks", c.CurrentFileChunkCount)
+		c.tui.requested(c.FilesToTransferCurrentNum)
 		c.Step3RecipientRequestFile = true
 		c.markTransferStarted()
 
@@ -2715,13 +2943,9 @@ func (c *Client) processMessage(payload []byte, attempt *transferAttemptState) (
 		}
 	case message.TypeCloseSender:
 		c.bar.Finish()
-		log.Debug("close-sender received...")
+		log.Debug("close-sender received, sent close-recipient")
 		c.Step4FileTransferred = false
 		c.Step3RecipientRequestFile = false
-		log.Debug("sending close-recipient")
-		err = message.Send(c.conn[0], c.Key, message.Message{
-			Type: message.TypeCloseRecipient,
-		})
 	case message.TypeCloseRecipient:
 		c.Step4FileTransferred = false
 		c.Step3RecipientRequestFile = false
@@ -2750,7 +2974,6 @@ func (c *Client) updateIfSenderChannelSecured() (err error) {
 				return c.stop.ctx.Err()
 			}
 		}
-		var b []byte
 		machID, _ := machineid.ID()
 		nextReconnectRoom := ""
 		if c.reconnectVersion >= ReconnectVersion {
@@ -2760,7 +2983,7 @@ func (c *Client) updateIfSenderChannelSecured() (err error) {
 			}
 			c.nextReconnectRoom = nextReconnectRoom
 		}
-		b, err = json.Marshal(SenderInfo{
+		c.session = transfer.NewSenderSession(SenderInfo{
 			FilesToTransfer:        c.FilesToTransfer,
 			EmptyFoldersToTransfer: c.EmptyFoldersToTransfer,
 			MachineID:              machID,
@@ -2771,16 +2994,15 @@ func (c *Client) updateIfSenderChannelSecured() (err error) {
 			HashAlgorithm:          c.Options.HashAlgorithm,
 			ReconnectVersion:       c.reconnectVersion,
 			NextReconnectRoom:      nextReconnectRoom,
-			Features:               []string{perFileCompressionFeature},
+			Features:               []string{transfer.PerFileCompressionFeature, transfer.MerkleChunksFeature},
 		})
+		var fileInfo message.Message
+		fileInfo, err = c.session.FileInfo()
 		if err != nil {
 			log.Error(err)
 			return
 		}
-		err = message.Send(c.conn[0], c.Key, message.Message{
-			Type:  message.TypeFileInfo,
-			Bytes: b,
-		})
+		err = message.Send(c.conn[0], c.Key, fileInfo)
 		if err != nil {
 			return
 		}
@@ -2795,7 +3017,7 @@ func (c *Client) recipientInitializeFile() (err error) {
 	log.Debugf("working on file %d", c.FilesToTransferCurrentNum)
 
 	// recipient sets the file
-	folderRemote, pathToFile, err := normalizeReceiveFilePath(
+	folderRemote, pathToFile, err := transfer.NormalizeReceiveFilePath(
 		c.FilesToTransfer[c.FilesToTransferCurrentNum].FolderRemote,
 		c.FilesToTransfer[c.FilesToTransferCurrentNum].Name,
 	)
@@ -2822,10 +3044,15 @@ func (c *Client) recipientInitializeFile() (err error) {
 		os.O_RDWR, 0o666)
 	var truncate bool // default false
 	c.CurrentFileChunkRanges = []int64{}
+	retryRanges := c.pendingChunkRanges
+	c.pendingChunkRanges = nil
 	if errOpen == nil {
 		stat, _ := c.CurrentFile.Stat()
 		truncate = stat.Size() != c.FilesToTransfer[c.FilesToTransferCurrentNum].Size
-		if !truncate {
+		if !truncate && retryRanges != nil {
+			// only the chunks that failed verification are requested again
+			c.CurrentFileChunkRanges = retryRanges
+		} else if !truncate {
 			// recipient requests the file and chunks (if empty, then should receive all chunks)
 			// TODO: determine the missing chunks
 			c.CurrentFileChunkRanges = utils.MissingChunks(
@@ -2862,9 +3089,7 @@ func (c *Client) recipientGetFileReady(finished bool) (err error) {
 	if finished {
 		// TODO: do the last finishing stuff
 		log.Debug("finished")
-		err = message.Send(c.conn[0], c.Key, message.Message{
-			Type: message.TypeFinished,
-		})
+		err = message.Send(c.conn[0], c.Key, c.session.Finish())
 		if err != nil {
 			return
 		}
@@ -2884,13 +3109,16 @@ func (c *Client) recipientGetFileReady(finished bool) (err error) {
 	c.CurrentFileIsClosed = false
 	c.receiveMutex.Unlock()
 	machID, _ := machineid.ID()
-	bRequest, _ := json.Marshal(RemoteFileRequest{
+	request, err := c.session.Request(RemoteFileRequest{
 		CurrentFileChunkRanges:    c.CurrentFileChunkRanges,
 		FilesToTransferCurrentNum: c.FilesToTransferCurrentNum,
 		MachineID:                 machID,
 		ReconnectVersion:          c.reconnectVersion,
-		Features:                  []string{perFileCompressionFeature},
+		Features:                  []string{transfer.PerFileCompressionFeature, transfer.MerkleChunksFeature},
 	})
+	if err != nil {
+		return
+	}
 	c.CurrentFileChunkCount = utils.ChunkRangesCount(
 		c.CurrentFileChunkRanges,
 		c.FilesToTransfer[c.FilesToTransferCurrentNum].Size,
@@ -2903,10 +3131,7 @@ func (c *Client) recipientGetFileReady(finished bool) (err error) {
 	}
 
 	log.Debugf("sending recipient ready with %d chunks", c.CurrentFileChunkCount)
-	err = message.Send(c.conn[0], c.Key, message.Message{
-		Type:  message.TypeRecipientReady,
-		Bytes: bRequest,
-	})
+	err = message.Send(c.conn[0], c.Key, request)
 	if err != nil {
 		return
 	}
@@ -2952,7 +3177,7 @@ func formatDescription(description string) string {
 
 func (c *Client) createEmptyFileAndFinish(fileInfo FileInfo, i int) (err error) {
 	log.Debugf("touching file with folder / name")
-	folderRemote, pathToFile, err := normalizeReceiveFilePath(fileInfo.FolderRemote, fileInfo.Name)
+	folderRemote, pathToFile, err := transfer.NormalizeReceiveFilePath(fileInfo.FolderRemote, fileInfo.Name)
 	if err != nil {
 		return
 	}
@@ -2967,7 +3192,7 @@ func (c *Client) createEmptyFileAndFinish(fileInfo FileInfo, i int) (err error)
 		return err
 	}
 	if fileInfo.Symlink != "" {
-		if err = validateReceiveSymlinkTarget(fileInfo.FolderRemote, fileInfo.Symlink); err != nil {
+		if err = transfer.ValidateReceiveSymlinkTarget(fileInfo.FolderRemote, fileInfo.Symlink); err != nil {
 			return
 		}
 		log.Debug("creating symlink")
@@ -3007,6 +3232,15 @@ func (c *Client) updateIfRecipientHasFileInfo() (err error) {
 	if c.Options.IsSender || !c.Step2FileInfoTransferred || c.Step3RecipientRequestFile {
 		return
 	}
+	c.receiveMutex.Lock()
+	c.pendingChunkRanges = c.merkleRetryRanges
+	c.merkleRetryRanges = nil
+	c.receiveMutex.Unlock()
+	if c.pendingChunkRanges != nil {
+		// the current file had chunks that failed verification
+		return c.recipientGetFileReady(false)
+	}
+	c.startTUI()
 	// find the next file to transfer and send that number
 	// if the files are the same size, then look for missing chunks
 	finished := true
@@ -3018,7 +3252,7 @@ func (c *Client) updateIfRecipientHasFileInfo() (err error) {
 		if _, ok := c.FilesHasFinished[i]; ok {
 			continue
 		}
-		if i < c.FilesToTransferCurrentNum {
+		if i < c.FilesToTransferCurrentNum || c.tui.isSkipped(i) {
 			continue
 		}
 		log.Debugf("checking %+v", fileInfo)
@@ -3036,6 +3270,7 @@ func (c *Client) updateIfRecipientHasFileInfo() (err error) {
 			} else {
 				c.numberOfTransferredFiles++
 			}
+			c.tui.setStatus(i, fileVerified)
 			continue
 		}
 		log.Debugf("%s %+x %+x %+v", fileInfo.Name, fileHash, fileInfo.Hash, errHash)
@@ -3072,29 +3307,37 @@ func (c *Client) updateIfRecipientHasFileInfo() (err error) {
 					promptDetail = fmt.Sprintf(" (%2.1f%%)", percentDone)
 					promptSpacing = "   "
 				}
-				output, colorEnabled := termui.Output(os.Stderr)
-				styledAction := termui.Warning(action, colorEnabled)
-				styledChoice := termui.PromptChoices("(y/N)", colorEnabled)
-				if action == "Resume" {
-					styledAction = action
-				}
-				fmt.Fprintf(output, "\n%s %s%s? %s%s(use --overwrite to omit) ",
-					styledAction,
-					quotedFilename(path.Join(fileInfo.FolderRemote, fileInfo.Name), colorEnabled),
-					promptDetail,
-					styledChoice,
-					promptSpacing,
-				)
-				choice, _ := utils.GetInput("")
-				choice = strings.ToLower(choice)
-				if choice != "y" && choice != "yes" {
-					fmt.Fprintf(output, "Skipping %s\n", quotedFilename(path.Join(fileInfo.FolderRemote, fileInfo.Name), colorEnabled))
-					continue
+				if c.tui != nil {
+					if !c.tui.confirm(fmt.Sprintf("%s '%s'%s?", action, path.Join(fileInfo.FolderRemote, fileInfo.Name), promptDetail)) {
+						c.tui.setStatus(i, fileSkipped)
+						continue
+					}
+				} else {
+					output, colorEnabled := termui.Output(os.Stderr)
+					styledAction := termui.Warning(action, colorEnabled)
+					styledChoice := termui.PromptChoices("(y/N)", colorEnabled)
+					if action == "Resume" {
+						styledAction = action
+					}
+					fmt.Fprintf(output, "\n%s %s%s? %s%s(use --overwrite to omit) ",
+						styledAction,
+						quotedFilename(path.Join(fileInfo.FolderRemote, fileInfo.Name), colorEnabled),
+						promptDetail,
+						styledChoice,
+						promptSpacing,
+					)
+					choice, _ := utils.GetInput("")
+					choice = strings.ToLower(choice)
+					if choice != "y" && choice != "yes" {
+						fmt.Fprintf(output, "Skipping %s\n", quotedFilename(path.Join(fileInfo.FolderRemote, fileInfo.Name), colorEnabled))
+						continue
+					}
 				}
 			}
 		} else {
 			log.Debugf("hashes are equal %x == %x", fileHash, fileInfo.Hash)
 			c.numberOfUnchangedFiles++
+			c.tui.setStatus(i, fileUnchanged)
 
 			if !fileInfo.ModTime.IsZero() {
 				if err := root.Chtimes(path.Join(fileInfo.FolderRemote, fileInfo.Name), fileInfo.ModTime, fileInfo.ModTime); err != nil {
@@ -3153,6 +3396,7 @@ func (c *Client) updateState(attempt *transferAttemptState) (err error) {
 			output, _ := termui.Output(os.Stderr)
 			fmt.Fprintf(output, "\nSending (->%s)\n", peerIP(c.ExternalIPConnected))
 			c.firstSend = true
+			c.startTUI()
 			// if there are empty files, show them as already have been transferred now
 			for i := range c.FilesToTransfer {
 				if c.FilesToTransfer[i].Size == 0 {
@@ -3184,6 +3428,17 @@ func (c *Client) updateState(attempt *transferAttemptState) (err error) {
 		if err != nil {
 			return
 		}
+		c.merkleTree = nil
+		if c.currentFileUsesMerkle() {
+			fileInfo := c.FilesToTransfer[c.FilesToTransferCurrentNum]
+			c.merkleTree, err = utils.NewMerkleTree(c.fread, fileInfo.Size)
+			if err != nil {
+				return
+			}
+			if !bytes.Equal(c.merkleTree.Root(), fileInfo.Hash) {
+				return fmt.Errorf("%s changed after it was hashed", fileInfo.Name)
+			}
+		}
 		for i := 0; i < len(c.Options.RelayPorts); i++ {
 			log.Debugf("starting sending over comm %d", i)
 			go c.sendData(i, c.conn[i+1], c.fread, attempt)
@@ -3210,8 +3465,9 @@ func (c *Client) setBar() {
 		c.FilesToTransfer[c.FilesToTransferCurrentNum].Size,
 		models.TCP_BUFFER_SIZE/2,
 	)
+	var bytesDone int64
 	if byteToDo > 0 {
-		bytesDone := c.FilesToTransfer[c.FilesToTransferCurrentNum].Size - byteToDo
+		bytesDone = c.FilesToTransfer[c.FilesToTransferCurrentNum].Size - byteToDo
 		log.Debug(byteToDo)
 		log.Debug(c.FilesToTransfer[c.FilesToTransferCurrentNum].Size)
 		log.Debug(bytesDone)
@@ -3219,6 +3475,8 @@ func (c *Client) setBar() {
 			c.bar.Add64(bytesDone)
 		}
 	}
+	c.tui.setPeer(peerIP(c.ExternalIPConnected), c.route)
+	c.tui.startFile(c.FilesToTransferCurrentNum, max(bytesDone, 0))
 }
 
 func (c *Client) receiveData(i int, dataConn *comm.Comm, attempt *transferAttemptState) {
@@ -3231,6 +3489,7 @@ func (c *Client) receiveData(i int, dataConn *comm.Comm, attempt *transferAttemp
 	var receiveBuffer []byte
 	var decompressedBuffer []byte
 	for {
+		c.tui.waitWhilePaused(c.stop.ctx)
 		data, err := dataConn.ReceiveInto(receiveBuffer)
 		if err != nil {
 			if c.activeTransferStarted() && c.ctxErr() == nil {
@@ -3248,20 +3507,25 @@ func (c *Client) receiveData(i int, dataConn *comm.Comm, attempt *transferAttemp
 			attempt.report(fmt.Errorf("data cipher is not initialized"))
 			return
 		}
+		// the hash algorithm is only known once the file list has arrived
+		maxChunkSize := maxDecompressedChunkSize
+		if c.currentFileUsesMerkle() {
+			maxChunkSize += utils.MaxMerkleProofSize
+		}
 		data, err = crypt.DecryptAEADInPlace(data, c.dataAEAD)
 		if err != nil {
 			attempt.report(err)
 			return
 		}
 		if c.currentFileUsesCompression() {
-			data, err = compress.DecompressTo(decompressedBuffer, data, maxDecompressedChunkSize)
+			data, err = compress.DecompressTo(decompressedBuffer, data, int64(maxChunkSize))
 			if err != nil {
 				attempt.report(fmt.Errorf("decompress data chunk: %w", err))
 				return
 			}
 			decompressedBuffer = data
 		}
-		if len(data) < 9 || len(data) > maxDecompressedChunkSize {
+		if len(data) < 9 || len(data) > maxChunkSize {
 			attempt.report(fmt.Errorf("invalid data chunk size: %d", len(data)))
 			return
 		}
@@ -3295,14 +3559,23 @@ func (c *Client) receiveData(i int, dataConn *comm.Comm, attempt *transferAttemp
 			return
 		}
 		receiveFile := c.CurrentFile
+		fileInfo := c.FilesToTransfer[c.FilesToTransferCurrentNum]
 		c.receiveMutex.Unlock()
 
-		// os.File supports concurrent WriteAt calls. Keep disk I/O outside the
-		// state lock so all relay connections can write in parallel.
-		_, err = receiveFile.WriteAt(data[8:], positionInt64)
-		if err != nil {
-			attempt.report(err)
-			return
+		chunk, verified := data[8:], true
+		if c.currentFileUsesMerkle() {
+			chunk, verified = verifyMerkleChunk(fileInfo, positionInt64, chunk)
+		}
+		if verified {
+			// os.File supports concurrent WriteAt calls. Keep disk I/O outside the
+			// state lock so all relay connections can write in parallel.
+			_, err = receiveFile.WriteAt(chunk, positionInt64)
+			if err != nil {
+				attempt.report(err)
+				return
+			}
+		} else {
+			log.Debugf("chunk at %d of %s failed verification", positionInt64, fileInfo.Name)
 		}
 
 		c.receiveMutex.Lock()
@@ -3310,17 +3583,43 @@ func (c *Client) receiveData(i int, dataConn *comm.Comm, attempt *transferAttemp
 			c.receiveMutex.Unlock()
 			return
 		}
-		c.TotalSent += int64(len(data[8:]))
+		if verified {
+			c.TotalSent += int64(len(chunk))
+		} else {
+			c.merkleBadChunks = append(c.merkleBadChunks, positionInt64)
+		}
 		c.TotalChunksTransferred++
 		finished := c.TotalChunksTransferred == c.CurrentFileChunkCount ||
-			c.TotalSent == c.FilesToTransfer[c.FilesToTransferCurrentNum].Size
+			c.TotalSent == fileInfo.Size
+		var badChunks []int64
 		if finished {
 			c.CurrentFileIsClosed = true
+			badChunks = c.merkleBadChunks
+			c.merkleBadChunks = nil
+			if len(badChunks) == 0 {
+				c.merkleRetries = 0
+			} else {
+				c.merkleRetries++
+				c.merkleRetryRanges = merkleRetryRanges(badChunks)
+			}
 		}
+		retries := c.merkleRetries
 		c.receiveMutex.Unlock()
 
-		c.bar.Add(len(data[8:]))
-		if finished {
+		if verified {
+			c.bar.Add(len(chunk))
+			c.tui.addBytes(len(chunk))
+		}
+		if finished && len(badChunks) > 0 {
+			if err = receiveFile.Close(); err != nil {
+				log.Debugf("error closing %s: %v", receiveFile.Name(), err)
+			}
+			if retries > maxMerkleRetries {
+				attempt.report(fmt.Errorf("%d chunks of %s failed verification %d times", len(badChunks), fileInfo.Name, retries))
+				return
+			}
+			log.Warnf("requesting %d chunks of %s again after they failed verification", len(badChunks), fileInfo.Name)
+		} else if finished {
 			log.Debug("finished receiving!")
 			if err = receiveFile.Close(); err != nil {
 				log.Debugf("error closing %s: %v", receiveFile.Name(), err)
@@ -3354,6 +3653,8 @@ func (c *Client) receiveData(i int, dataConn *comm.Comm, attempt *transferAttemp
 				}
 				fmt.Print(string(b))
 			}
+		}
+		if finished {
 			log.Debug("sending close-sender")
 			err = message.Send(c.conn[0], c.Key, message.Message{
 				Type: message.TypeCloseSender,
@@ -3383,23 +3684,35 @@ func (c *Client) sendData(i int, dataConn *comm.Comm, fread *os.File, attempt *t
 	pos := uint64(readingPos)
 	stride := chunkSize * connectionCount
 	fileSize := c.FilesToTransfer[c.FilesToTransferCurrentNum].Size
+	tree := c.merkleTree
+	leaves := utils.MerkleLeaves(fileSize)
 	payload := make([]byte, 8+chunkSize)
+	if tree != nil {
+		payload = make([]byte, 8+utils.MaxMerkleProofSize+chunkSize)
+	}
 	var encryptedBuffer []byte
 	var compressedBuffer []byte
 	for readingPos < fileSize {
+		c.tui.waitWhilePaused(c.stop.ctx)
 		if err := c.ctxErr(); err != nil {
 			log.Tracef("stopping send %d: %v", i, err)
 			return
 		}
 		// Skip disk I/O entirely for chunks the recipient already has.
-		usableChunk := utils.ChunkRangesContain(c.CurrentFileChunkRanges, int64(pos))
+		usableChunk := transfer.ChunkRangesContain(c.CurrentFileChunkRanges, int64(pos))
 		if !usableChunk {
 			readingPos += stride
 			pos += uint64(stride)
 			continue
 		}
 
-		n, errRead := fread.ReadAt(payload[8:], readingPos)
+		// With Merkle verification, each chunk's proof sits between its
+		// position and its data.
+		proofSize := 0
+		if tree != nil {
+			proofSize = utils.MerkleProofSize(readingPos/chunkSize, leaves)
+		}
+		n, errRead := fread.ReadAt(payload[8+proofSize:8+proofSize+int(chunkSize)], readingPos)
 		if c.limiter != nil {
 			r := c.limiter.ReserveN(time.Now(), n)
 			log.Debugf("Limiting Upload for %d", r.Delay())
@@ -3407,7 +3720,10 @@ func (c *Client) sendData(i int, dataConn *comm.Comm, fread *os.File, attempt *t
 		}
 		if n > 0 {
 			binary.LittleEndian.PutUint64(payload[:8], pos)
-			plain := payload[:8+n]
+			if tree != nil {
+				tree.AppendProof(payload[8:8], readingPos/chunkSize)
+			}
+			plain := payload[:8+proofSize+n]
 			var dataToSend []byte
 			var err error
 			if c.currentFileUsesCompression() {
@@ -3428,6 +3744,7 @@ func (c *Client) sendData(i int, dataConn *comm.Comm, fread *os.File, attempt *t
 				return
 			}
 			c.bar.Add(n)
+			c.tui.addBytes(n)
 			c.mutex.Lock()
 			c.TotalSent += int64(n)
 			c.mutex.Unlock()
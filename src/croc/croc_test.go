@@ -19,14 +19,17 @@ import (
 	"time"
 	"unicode/utf8"
 
+	"github.com/schollz/croc/v11/src/comm"
 	"github.com/schollz/croc/v11/src/message"
 	"github.com/schollz/croc/v11/src/models"
 	"github.com/schollz/croc/v11/src/pakekey"
 	"github.com/schollz/croc/v11/src/tcp"
+	"github.com/schollz/croc/v11/src/transfer"
 	"github.com/schollz/croc/v11/src/utils"
 	log "github.com/schollz/logger"
 	"github.com/schollz/peerdiscovery"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func init() {
@@ -136,7 +139,7 @@ func TestPerFileCompressionNegotiationFallsBackForLegacyPeers(t *testing.T) {
 
 	client.Options.NoCompress = true
 	assert.False(t, client.currentFileUsesCompression(), "global disable always wins")
-	assert.True(t, supportsFeature([]string{"other", perFileCompressionFeature}, perFileCompressionFeature))
+	assert.True(t, transfer.SupportsFeature([]string{"other", transfer.PerFileCompressionFeature}, transfer.PerFileCompressionFeature))
 }
 
 func TestWebReceiveURL(t *testing.T) {
@@ -491,7 +494,7 @@ func TestHostilePortableDestinationNamesRejected(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if _, _, err := validateReceiveMetadata(tt.files, nil); err == nil {
+			if _, _, err := transfer.ValidateReceiveMetadata(tt.files, nil); err == nil {
 				t.Fatal("hostile metadata was accepted")
 			}
 		})
@@ -1988,6 +1991,41 @@ func TestReconnectRetryEligibility(t *testing.T) {
 	assert.False(t, c.canRetryTransfer(transferDisconnectError{err: fmt.Errorf("EOF")}, 0))
 }
 
+func TestFailedReplyStopsProcessingMessage(t *testing.T) {
+	key := make([]byte, 32)
+	local, peer := net.Pipe()
+	peer.Close()
+	session := transfer.NewSenderSession(SenderInfo{FilesToTransfer: []FileInfo{{Name: "a.txt", FolderRemote: ".", Size: 1}}})
+	_, err := session.FileInfo()
+	require.NoError(t, err)
+	request, err := json.Marshal(RemoteFileRequest{})
+	require.NoError(t, err)
+	_, err = session.Handle(message.Message{Type: message.TypeRecipientReady, Bytes: request})
+	require.NoError(t, err)
+	c := &Client{
+		Key:                       key,
+		conn:                      []*comm.Comm{comm.New(local)},
+		session:                   session,
+		Step3RecipientRequestFile: true,
+		stop:                      newStop(context.Background()),
+	}
+	attempt := &transferAttemptState{errc: make(chan error, 1)}
+
+	payload, err := message.Encode(key, message.Message{Type: message.TypeCloseSender})
+	require.NoError(t, err)
+	done, err := c.processMessage(payload, attempt)
+	assert.Error(t, err)
+	assert.False(t, done)
+	assert.True(t, c.Step3RecipientRequestFile, "a failed close-recipient must not close the file")
+
+	payload, err = message.Encode(key, message.Message{Type: message.TypeFinished})
+	require.NoError(t, err)
+	done, err = c.processMessage(payload, attempt)
+	assert.Error(t, err)
+	assert.True(t, done)
+	assert.True(t, c.SuccessfulTransfer, "the peer finished before the acknowledgement was lost")
+}
+
 func TestReconnectFallsBackToRememberedRelay(t *testing.T) {
 	controlPort, stopRelay := startReconnectRelay(t)
 	defer stopRelay()
@@ -2839,3 +2877,91 @@ func TestRunCtx(t *testing.T) {
 		t.Fatal("Test timeout after 5 seconds")
 	}
 }
+
+func TestSenderWaitForReceiver(t *testing.T) {
+	port := freeTestPort(t)
+	ctx, stopRelay := context.WithCancel(context.Background())
+	defer stopRelay()
+	go tcp.RunWithOptionsAsync("127.0.0.1", port, "pass123",
+		tcp.WithCtx(ctx),
+		tcp.WithLogLevel("warn"),
+		tcp.WithReservations([]string{"secret"}, time.Hour, 4))
+	time.Sleep(250 * time.Millisecond)
+
+	newWaitingSender := func(room, token string, wait time.Duration) *Client {
+		return &Client{
+			stop: newStop(context.Background()),
+			Options: Options{
+				IsSender:         true,
+				RoomName:         room,
+				RelayAddress:     "127.0.0.1:" + port,
+				RelayPassword:    "pass123",
+				WaitForReceiver:  wait,
+				ReservationToken: token,
+			},
+		}
+	}
+
+	t.Run("rejected token", func(t *testing.T) {
+		sender := newWaitingSender("wait-rejected", "wrong", time.Hour)
+		_, _, _, err := sender.senderWaitForReceiver()
+		assert.ErrorIs(t, err, tcp.ErrReservationRejected)
+	})
+
+	t.Run("deadline", func(t *testing.T) {
+		sender := newWaitingSender("wait-deadline", "secret", 300*time.Millisecond)
+		start := time.Now()
+		_, _, _, err := sender.senderWaitForReceiver()
+		assert.ErrorIs(t, err, ErrNoReceiver)
+		assert.Less(t, time.Since(start), 5*time.Second)
+	})
+
+	t.Run("receiver joins", func(t *testing.T) {
+		sender := newWaitingSender("wait-joined", "secret", time.Hour)
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			receiver, _, _, err := tcp.ConnectToTCPServer("127.0.0.1:"+port, "pass123", "wait-joined")
+			if err != nil {
+				return
+			}
+			receiver.Send(handshakeRequest)
+		}()
+		conn, _, address, err := sender.senderWaitForReceiver()
+		if err != nil {
+			t.Fatalf("wait for receiver: %v", err)
+		}
+		defer conn.Close()
+		assert.Equal(t, "127.0.0.1:"+port, address)
+	})
+}
+
+func TestRelayCredential(t *testing.T) {
+	c, err := New(Options{
+		SharedSecret:  "1234-relay-token",
+		Curve:         "siec",
+		RelayAddress:  "relay.example.com:9009",
+		RelayAddress6: "[2001:db8::1]:9009",
+		RelayPassword: "pass123",
+		RelayToken:    "user-token",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "user-token", c.relayCredential("relay.example.com:9009"))
+	assert.Equal(t, "user-token", c.relayCredential("relay.example.com:9010"))
+	assert.Equal(t, "user-token", c.relayCredential("[2001:db8::1]:9011"))
+	assert.Equal(t, "pass123", c.relayCredential("192.168.1.20:9009"), "peer relays never see the token")
+	assert.Equal(t, "pass123", c.relayCredential("127.0.0.1:9009"))
+
+	// discovering a peer's relay must not redirect the token to it
+	c.Options.RelayAddress = "192.168.1.20:9009"
+	assert.Equal(t, "pass123", c.relayCredential(c.Options.RelayAddress))
+
+	assert.NotContains(t, c.redactError(errors.New("bad user-token")).Error(), "user-token")
+
+	c, err = New(Options{SharedSecret: "1234-relay-token", Curve: "siec", RelayAddress: "relay.example.com:9009", RelayPassword: "pass123"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "pass123", c.relayCredential("relay.example.com:9009"))
+}
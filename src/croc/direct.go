@@ -0,0 +1,356 @@
+package croc
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/schollz/logger"
+
+	"github.com/schollz/croc/v11/src/comm"
+	"github.com/schollz/croc/v11/src/holepunch"
+	"github.com/schollz/croc/v11/src/message"
+	"github.com/schollz/croc/v11/src/tcp"
+	"github.com/schollz/croc/v11/src/utils"
+)
+
+const (
+	// directGatherTimeout bounds how long a peer looks for its own addresses.
+	directGatherTimeout = 1500 * time.Millisecond
+	// The recipient decides whether the direct connection is used. The sender
+	// keeps punching for longer, so that it cannot give up on a connection
+	// that the recipient has just accepted.
+	directRecipientTimeout = 3 * time.Second
+	directSenderTimeout    = 5 * time.Second
+	directNonceSize        = 16
+)
+
+// Messages exchanged while selecting the direct connection. The recipient
+// sends directSelect with a nonce over a punched connection and the sender
+// answers the first one with directSelected; the recipient then reports its
+// decision over the relay with directConnected or directRelay.
+const (
+	directSelect    = "select"
+	directSelected  = "selected"
+	directConnected = "direct"
+	directRelay     = "relay"
+)
+
+// directConnection is an attempt to replace the relayed data connections with
+// a single direct connection between the peers.
+type directConnection struct {
+	endpoint   *holepunch.Endpoint
+	gathered   chan struct{}
+	candidates []string
+	selected   atomic.Bool
+
+	// the sender punches in the background until the recipient decides
+	cancel context.CancelFunc
+	result chan *comm.Comm
+}
+
+// directAllowed reports whether a direct connection may be tried for a
+// transfer relayed through relayAddress. Local relays are already direct,
+// proxies are used to hide the peers' addresses, and WebSocket relays are
+// used where only HTTP gets through.
+func (c *Client) directAllowed(relayAddress string) bool {
+	return !c.Options.DisableDirect &&
+		!c.Options.OnlyLocal &&
+		comm.Socks5Proxy == "" &&
+		comm.HttpProxy == "" &&
+		!c.relays.Transport(relayAddress).IsWebSocket() &&
+		!utils.IsLocalIP(relayAddress)
+}
+
+// startDirectConnection opens the endpoint for a direct connection and starts
+// gathering the addresses the peer can reach it at.
+func (c *Client) startDirectConnection(relayAddress string) {
+	c.closeDirect()
+	if !c.directAllowed(relayAddress) {
+		return
+	}
+	endpoint, err := holepunch.Listen()
+	if err != nil {
+		log.Debugf("direct connection unavailable: %v", err)
+		return
+	}
+	direct := &directConnection{
+		endpoint: endpoint,
+		gathered: make(chan struct{}),
+	}
+	c.direct = direct
+	go func() {
+		defer close(direct.gathered)
+		direct.candidates = gatherDirectCandidates(c.relays, endpoint, relayAddress, c.relayCredential(relayAddress))
+		log.Debugf("direct connection candidates: %v", direct.candidates)
+	}()
+}
+
+// gatherDirectCandidates returns the addresses at which endpoint may be
+// reached: the address the relay, reached with relays, sees, the address of
+// a port mapping on the gateway, and the local addresses.
+func gatherDirectCandidates(relays *tcp.Dialer, endpoint *holepunch.Endpoint, relayAddress, password string) []string {
+	ctx, cancel := context.WithTimeout(context.Background(), directGatherTimeout)
+	defer cancel()
+
+	var observed, mapped string
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		conn, err := endpoint.Dial(ctx, relayAddress)
+		if err != nil {
+			log.Debugf("direct: could not reach relay: %v", err)
+			return
+		}
+		stop := context.AfterFunc(ctx, func() { conn.Close() })
+		defer stop()
+		observed, err = relays.ObserveExternalAddress(conn, relayAddress, password)
+		if err != nil {
+			log.Debugf("direct: relay did not report the external address: %v", err)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		var err error
+		mapped, err = endpoint.Map(ctx)
+		if err != nil {
+			log.Debugf("direct: %v", err)
+		}
+	}()
+	wg.Wait()
+
+	candidates := []string{observed, mapped}
+	localIPs, _ := utils.GetLocalIPs()
+	for _, ip := range localIPs {
+		candidates = append(candidates, net.JoinHostPort(ip, strconv.Itoa(endpoint.Port())))
+	}
+	return holepunch.Candidates(candidates)
+}
+
+// directCandidates waits for the gathered addresses of the direct endpoint.
+func (c *Client) directCandidates() []string {
+	if c.direct == nil {
+		return nil
+	}
+	<-c.direct.gathered
+	return c.direct.candidates
+}
+
+func encodeDirectCandidates(candidates []string) []byte {
+	if len(candidates) == 0 {
+		return nil
+	}
+	b, _ := json.Marshal(candidates)
+	return b
+}
+
+func decodeDirectCandidates(b []byte) []string {
+	var candidates []string
+	if err := json.Unmarshal(b, &candidates); err != nil {
+		log.Debugf("invalid direct connection candidates: %v", err)
+		return nil
+	}
+	return holepunch.Candidates(candidates)
+}
+
+// punchDirect connects to the peer at one of remotes and agrees on the
+// connection over it.
+func (c *Client) punchDirect(ctx context.Context, remotes []string) (*comm.Comm, error) {
+	direct := c.direct
+	if direct == nil {
+		return nil, holepunch.ErrNoCandidates
+	}
+	conn, err := direct.endpoint.Punch(ctx, remotes, func(ctx context.Context, conn net.Conn) error {
+		return c.directHandshake(ctx, conn, direct)
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("connected directly to %s", conn.RemoteAddr())
+	return comm.New(conn), nil
+}
+
+// directHandshake authenticates a punched connection with the session key.
+// The sender accepts only the first connection selected by the recipient, so
+// that both peers end up with the same one.
+func (c *Client) directHandshake(ctx context.Context, conn net.Conn, direct *directConnection) error {
+	dataConn := comm.New(conn)
+	// comm.New sets long deadlines for transfers
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return err
+		}
+	}
+	if c.Options.IsSender {
+		m, err := receiveDirectMessage(dataConn, c.Key)
+		if err != nil {
+			return err
+		}
+		if m.Message != directSelect || len(m.Bytes) != directNonceSize {
+			return errors.New("unexpected direct connection message")
+		}
+		if !direct.selected.CompareAndSwap(false, true) {
+			return errors.New("direct connection already selected")
+		}
+		return message.Send(dataConn, c.Key, message.Message{
+			Type:    message.TypeDirect,
+			Message: directSelected,
+			Bytes:   m.Bytes,
+		})
+	}
+
+	nonce := make([]byte, directNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	err := message.Send(dataConn, c.Key, message.Message{
+		Type:    message.TypeDirect,
+		Message: directSelect,
+		Bytes:   nonce,
+	})
+	if err != nil {
+		return err
+	}
+	m, err := receiveDirectMessage(dataConn, c.Key)
+	if err != nil {
+		return err
+	}
+	if m.Message != directSelected || !bytes.Equal(m.Bytes, nonce) {
+		return errors.New("unexpected direct connection message")
+	}
+	return nil
+}
+
+func receiveDirectMessage(dataConn *comm.Comm, key []byte) (message.Message, error) {
+	data, err := dataConn.Receive()
+	if err != nil {
+		return message.Message{}, err
+	}
+	m, err := message.Decode(key, data)
+	if err != nil {
+		return message.Message{}, err
+	}
+	if m.Type != message.TypeDirect {
+		return message.Message{}, errors.New("unexpected direct connection message")
+	}
+	return m, nil
+}
+
+// startSenderPunch punches towards the recipient until it reports whether it
+// uses the direct connection.
+func (c *Client) startSenderPunch(remotes []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), directSenderTimeout)
+	direct := c.direct
+	direct.cancel = cancel
+	direct.result = make(chan *comm.Comm, 1)
+	go func() {
+		defer cancel()
+		conn, err := c.punchDirect(ctx, remotes)
+		if err != nil {
+			log.Debugf("direct connection failed: %v", err)
+		}
+		direct.result <- conn
+	}()
+}
+
+// selectDirectConnection punches towards the sender, tells it whether the
+// direct connection is used and starts receiving data.
+func (c *Client) selectDirectConnection(remotes []string, attempt *transferAttemptState) error {
+	ctx, cancel := context.WithTimeout(context.Background(), directRecipientTimeout)
+	defer cancel()
+	dataConn, err := c.punchDirect(ctx, remotes)
+	decision := directConnected
+	if err != nil {
+		log.Debugf("direct connection failed, using the relay: %v", err)
+		decision = directRelay
+	}
+	c.closeDirect()
+	err = message.Send(c.conn[0], c.Key, message.Message{
+		Type:    message.TypeDirect,
+		Message: decision,
+	})
+	if err != nil {
+		if dataConn != nil {
+			dataConn.Close()
+		}
+		return err
+	}
+	if dataConn != nil {
+		c.useDirectConnection(dataConn)
+	}
+	c.startReceivingData(attempt)
+	return nil
+}
+
+// processDirect applies the recipient's decision on the sender.
+func (c *Client) processDirect(m message.Message) (done bool, err error) {
+	if !c.Options.IsSender || c.Step1ChannelSecured || c.direct == nil || c.direct.result == nil {
+		return true, errors.New("unexpected direct connection message")
+	}
+	if m.Message != directConnected {
+		c.direct.cancel()
+	}
+	result := c.direct.result
+	c.direct.result = nil
+	dataConn := <-result
+	c.closeDirect()
+	if m.Message == directConnected {
+		if dataConn == nil {
+			return true, transferDisconnectError{err: errors.New("direct connection was lost")}
+		}
+		c.useDirectConnection(dataConn)
+	} else if dataConn != nil {
+		dataConn.Close()
+	}
+	log.Debug("peer endpoint metadata exchange completed")
+	c.Step1ChannelSecured = true
+	return
+}
+
+// useDirectConnection replaces the relayed data connections.
+func (c *Client) useDirectConnection(dataConn *comm.Comm) {
+	for _, relayConn := range c.conn[1:] {
+		if relayConn != nil {
+			relayConn.Close()
+		}
+	}
+	c.conn = append(c.conn[:1], dataConn)
+	c.Options.RelayPorts = c.Options.RelayPorts[:1]
+	c.route = routeDirect
+}
+
+// startReceivingData reads every data connection on the recipient.
+func (c *Client) startReceivingData(attempt *transferAttemptState) {
+	for i := range c.Options.RelayPorts {
+		go c.receiveData(i, c.conn[i+1], attempt)
+	}
+}
+
+// closeDirect stops punching and releases the endpoint and its port mapping.
+func (c *Client) closeDirect() {
+	if c.direct == nil {
+		return
+	}
+	if c.direct.cancel != nil {
+		c.direct.cancel()
+	}
+	if result := c.direct.result; result != nil {
+		go func() {
+			if dataConn := <-result; dataConn != nil {
+				dataConn.Close()
+			}
+		}()
+	}
+	if err := c.direct.endpoint.Close(); err != nil {
+		log.Tracef("closing direct endpoint: %v", err)
+	}
+	c.direct = nil
+}
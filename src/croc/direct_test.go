@@ -0,0 +1,104 @@
+package croc
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/schollz/croc/v11/src/comm"
+	"github.com/schollz/croc/v11/src/holepunch"
+	"github.com/schollz/croc/v11/src/message"
+)
+
+func TestDirectAllowed(t *testing.T) {
+	c := &Client{}
+	assert.True(t, c.directAllowed("croc.schollz.com:9009"))
+	assert.True(t, c.directAllowed("203.0.113.1:9009"))
+	assert.False(t, c.directAllowed("127.0.0.1:9009"))
+	assert.False(t, c.directAllowed("192.168.1.5:9009"))
+
+	c.Options.DisableDirect = true
+	assert.False(t, c.directAllowed("203.0.113.1:9009"))
+	c.Options = Options{OnlyLocal: true}
+	assert.False(t, c.directAllowed("203.0.113.1:9009"))
+
+	c.Options = Options{}
+	comm.Socks5Proxy = "127.0.0.1:1080"
+	defer func() { comm.Socks5Proxy = "" }()
+	assert.False(t, c.directAllowed("203.0.113.1:9009"))
+}
+
+func TestDirectCandidatesRoundTrip(t *testing.T) {
+	assert.Nil(t, encodeDirectCandidates(nil))
+	encoded := encodeDirectCandidates([]string{"203.0.113.1:4000", "127.0.0.1:4000"})
+	assert.Equal(t, []string{"203.0.113.1:4000"}, decodeDirectCandidates(encoded))
+	assert.Nil(t, decodeDirectCandidates([]byte("not json")))
+}
+
+func TestDirectHandshakeAgreesOnConnection(t *testing.T) {
+	key := make([]byte, 32)
+	newPeer := func(isSender bool) *Client {
+		endpoint, err := holepunch.Listen()
+		require.NoError(t, err)
+		c := &Client{Options: Options{IsSender: isSender}, Key: key}
+		c.direct = &directConnection{endpoint: endpoint, gathered: make(chan struct{})}
+		t.Cleanup(c.closeDirect)
+		return c
+	}
+	sender := newPeer(true)
+	recipient := newPeer(false)
+	loopback := func(c *Client) []string {
+		return []string{net.JoinHostPort("127.0.0.1", strconv.Itoa(c.direct.endpoint.Port()))}
+	}
+
+	sender.startSenderPunch(loopback(recipient))
+	ctx, cancel := context.WithTimeout(context.Background(), directRecipientTimeout)
+	defer cancel()
+	recipientConn, err := recipient.punchDirect(ctx, loopback(sender))
+	require.NoError(t, err)
+	defer recipientConn.Close()
+
+	var senderConn *comm.Comm
+	select {
+	case senderConn = <-sender.direct.result:
+	case <-time.After(directSenderTimeout):
+	}
+	require.NotNil(t, senderConn)
+	defer senderConn.Close()
+	assert.True(t, sender.direct.selected.Load())
+
+	require.NoError(t, message.Send(senderConn, key, message.Message{Type: message.TypeFinished}))
+	data, err := recipientConn.Receive()
+	require.NoError(t, err)
+	m, err := message.Decode(key, data)
+	require.NoError(t, err)
+	assert.Equal(t, message.TypeFinished, m.Type)
+}
+
+func TestDirectHandshakeRejectsWrongKey(t *testing.T) {
+	endpoint, err := holepunch.Listen()
+	require.NoError(t, err)
+	sender := &Client{Options: Options{IsSender: true}, Key: make([]byte, 32)}
+	sender.direct = &directConnection{endpoint: endpoint, gathered: make(chan struct{})}
+	defer sender.closeDirect()
+	sender.startSenderPunch([]string{"127.0.0.1:1"})
+
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(endpoint.Port())))
+	require.NoError(t, err)
+	defer conn.Close()
+	wrongKey := make([]byte, 32)
+	wrongKey[0] = 1
+	require.NoError(t, message.Send(comm.New(conn), wrongKey, message.Message{
+		Type:    message.TypeDirect,
+		Message: directSelect,
+		Bytes:   make([]byte, directNonceSize),
+	}))
+	_, err = comm.New(conn).Receive()
+	assert.Error(t, err)
+	assert.False(t, sender.direct.selected.Load())
+}
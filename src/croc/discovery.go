@@ -0,0 +1,107 @@
+package croc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	log "github.com/schollz/logger"
+	"github.com/schollz/peerdiscovery"
+
+	"github.com/schollz/croc/v11/src/mdns"
+)
+
+// Local discovery methods accepted in Options.Discovery.
+const (
+	DiscoveryMulticast = "multicast"
+	DiscoveryMDNS      = "mdns"
+)
+
+// DiscoveryMethods lists every local discovery method.
+var DiscoveryMethods = []string{DiscoveryMulticast, DiscoveryMDNS}
+
+// mdnsRoomPrefix starts the TXT string that identifies a sender's room.
+const mdnsRoomPrefix = "room="
+
+var mdnsBrowse = mdns.Browse
+
+func validateDiscovery(methods []string) error {
+	for _, method := range methods {
+		if !slices.Contains(DiscoveryMethods, method) {
+			return fmt.Errorf("unknown discovery method %q", method)
+		}
+	}
+	return nil
+}
+
+// discoveryEnabled reports whether a local discovery method is selected. An
+// empty selection keeps the historical multicast-only behavior.
+func (c *Client) discoveryEnabled(method string) bool {
+	if len(c.Options.Discovery) == 0 {
+		return method == DiscoveryMulticast
+	}
+	return slices.Contains(c.Options.Discovery, method)
+}
+
+// mdnsRoomHash is the only room-derived value in an mDNS advertisement: a
+// domain-separated hash of the room name. It does not hide the room. The room
+// name is itself a hash of the code's room selector, the first word of a
+// three-word code or the first four characters of a legacy one, so anyone on
+// the network can enumerate the selectors and recover both the room and that
+// word. It reveals nothing about the PAKE passphrase, the rest of the code,
+// and the room is no more secret than it is to the relay, which sees it on
+// every connection.
+func mdnsRoomHash(room string) string {
+	digest := sha256.Sum256([]byte("croc/mdns/room/v1\x00" + room))
+	return hex.EncodeToString(digest[:16])
+}
+
+func (c *Client) advertiseOnLocalNetwork() {
+	port, err := strconv.Atoi(c.localRelayPort)
+	if err != nil {
+		log.Debugf("mdns: invalid local relay port: %v", err)
+		return
+	}
+	// The instance name is random so that it does not identify the machine.
+	var instance [4]byte
+	_, _ = rand.Read(instance[:])
+	var timeLimit time.Duration
+	// without an external relay the advertisement has to last for the whole transfer
+	if !c.Options.OnlyLocal {
+		timeLimit = 30 * time.Second
+	}
+	err = mdns.Advertise(mdns.Advertisement{
+		Instance:  "croc-" + hex.EncodeToString(instance[:]),
+		Port:      port,
+		Text:      []string{"v=1", mdnsRoomPrefix + mdnsRoomHash(c.Options.RoomName)},
+		TimeLimit: timeLimit,
+		StopChan:  c.stop.stopChan,
+	})
+	if err != nil {
+		log.Debugf("mdns: %v", err)
+	}
+}
+
+// browseLocalNetwork finds senders of this room over mDNS and reports them in
+// the form used by multicast discovery.
+func (c *Client) browseLocalNetwork(stop chan struct{}) ([]peerdiscovery.Discovered, error) {
+	room := mdnsRoomPrefix + mdnsRoomHash(c.Options.RoomName)
+	entries, err := mdnsBrowse(mdns.Query{
+		Match:     func(text []string) bool { return slices.Contains(text, room) },
+		Limit:     1,
+		TimeLimit: receivePeerDiscoveryTimeLimit,
+		StopChan:  stop,
+	})
+	discoveries := make([]peerdiscovery.Discovered, 0, len(entries))
+	for _, entry := range entries {
+		discoveries = append(discoveries, peerdiscovery.Discovered{
+			Address: entry.Address,
+			Payload: []byte("croc" + strconv.Itoa(entry.Port)),
+		})
+	}
+	return discoveries, err
+}
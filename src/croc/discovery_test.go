@@ -0,0 +1,93 @@
+package croc
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/schollz/peerdiscovery"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/schollz/croc/v11/src/codephrase"
+	"github.com/schollz/croc/v11/src/mdns"
+)
+
+func TestMDNSRoomHashRevealsOnlyTheRoomSelector(t *testing.T) {
+	room := strings.Repeat("ab", 32)
+	hash := mdnsRoomHash(room)
+	assert.Len(t, hash, 32)
+	assert.NotContains(t, room, hash)
+	assert.Equal(t, hash, mdnsRoomHash(room))
+	assert.NotEqual(t, hash, mdnsRoomHash(strings.Repeat("cd", 32)))
+
+	// Codes that share a first word advertise the same value, so it carries
+	// nothing about the PAKE passphrase...
+	sent, err := codephrase.Parse("orbit-velvet-lantern")
+	require.NoError(t, err)
+	other, err := codephrase.Parse("orbit-cactus-meadow")
+	require.NoError(t, err)
+	assert.NotEqual(t, sent.PAKEPassphrase, other.PAKEPassphrase)
+	assert.Equal(t, mdnsRoomHash(sent.RoomName), mdnsRoomHash(other.RoomName))
+
+	// ...but a neighbour who enumerates first words recovers the room.
+	advertised := mdnsRoomHash(sent.RoomName)
+	var recovered string
+	for _, word := range []string{"apple", "orbit", "zebra"} {
+		guess, err := codephrase.Parse(word + "-any-words")
+		require.NoError(t, err)
+		if mdnsRoomHash(guess.RoomName) == advertised {
+			recovered = guess.RoomName
+		}
+	}
+	assert.Equal(t, sent.RoomName, recovered)
+}
+
+func TestDiscoveryMethodSelection(t *testing.T) {
+	c := &Client{}
+	assert.True(t, c.discoveryEnabled(DiscoveryMulticast))
+	assert.False(t, c.discoveryEnabled(DiscoveryMDNS))
+
+	c.Options.Discovery = []string{DiscoveryMDNS}
+	assert.False(t, c.discoveryEnabled(DiscoveryMulticast))
+	assert.True(t, c.discoveryEnabled(DiscoveryMDNS))
+
+	assert.NoError(t, validateDiscovery(DiscoveryMethods))
+	assert.EqualError(t, validateDiscovery([]string{"bluetooth"}), `unknown discovery method "bluetooth"`)
+	_, err := New(Options{SharedSecret: "1234-alpha-bravo-charlie", Discovery: []string{"bluetooth"}})
+	assert.Error(t, err)
+}
+
+func TestDiscoverReceivePeersTriesMDNSRoomFirst(t *testing.T) {
+	oldDiscover := peerDiscover
+	oldBrowse := mdnsBrowse
+	defer func() {
+		peerDiscover = oldDiscover
+		mdnsBrowse = oldBrowse
+	}()
+
+	room := strings.Repeat("ab", 32)
+	peerDiscover = func(settings ...peerdiscovery.Settings) ([]peerdiscovery.Discovered, error) {
+		return []peerdiscovery.Discovered{{Address: "10.0.0.9", Payload: []byte("croc9009")}}, nil
+	}
+	mdnsBrowse = func(query mdns.Query) ([]mdns.Entry, error) {
+		// let the multicast results arrive first
+		time.Sleep(20 * time.Millisecond)
+		assert.False(t, query.Match([]string{"v=1", "room=" + mdnsRoomHash("other")}))
+		require.True(t, query.Match([]string{"v=1", "room=" + mdnsRoomHash(room)}))
+		return []mdns.Entry{{Instance: "croc-0a1b2c3d", Address: "10.0.0.2", Port: 9010}}, nil
+	}
+
+	c := &Client{
+		Options: Options{
+			RoomName:  room,
+			Discovery: DiscoveryMethods,
+		},
+		stop: newStop(context.Background()),
+	}
+	defer c.clearReceiveStatus()
+	discoveries := c.discoverReceivePeers()
+	require.Len(t, discoveries, 3)
+	assert.Equal(t, peerdiscovery.Discovered{Address: "10.0.0.2", Payload: []byte("croc9010")}, discoveries[0])
+}
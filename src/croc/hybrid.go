@@ -0,0 +1,97 @@
+package croc
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/schollz/croc/v11/src/message"
+	"github.com/schollz/croc/v11/src/pakekey"
+)
+
+// errPostQuantumUnavailable is returned when --pq is set but the peer did not
+// take part in the hybrid ML-KEM exchange.
+var errPostQuantumUnavailable = errors.New("peer does not support the post-quantum key exchange; upgrade both croc clients or omit --pq")
+
+// offerHybridKeyExchange creates the recipient's ML-KEM key. Its
+// encapsulation key travels with the initial PAKE value.
+func (c *Client) offerHybridKeyExchange() error {
+	decapsulationKey, err := pakekey.GenerateHybridKey()
+	if err != nil {
+		return err
+	}
+	c.pakeKEM = pakekey.HybridMLKEM768
+	c.pakeHybridKey = decapsulationKey
+	c.pakeEncapsulationKey = decapsulationKey.EncapsulationKey().Bytes()
+	return nil
+}
+
+// sendPakeInit sends the recipient's initial PAKE value, with its ML-KEM
+// offer if it made one.
+func (c *Client) sendPakeInit() error {
+	return message.Send(c.conn[0], nil, message.Message{
+		Type:    message.TypePAKE,
+		Version: pakekey.ProtocolVersion,
+		Message: c.pakeKEM,
+		Bytes:   c.pakeInitiator,
+		Bytes2:  []byte(c.pakeCurve),
+		Bytes3:  c.pakeEncapsulationKey,
+	})
+}
+
+// requireHybridKeyExchange reports whether a --pq sender should ask a
+// recipient that made no offer to restart its PAKE with one. It asks once;
+// a second value without an offer is refused by acceptHybridKeyExchange.
+func (c *Client) requireHybridKeyExchange(m message.Message) bool {
+	if !c.Options.PostQuantum || m.Message == pakekey.HybridMLKEM768 || c.pakeKEMRequested {
+		return false
+	}
+	c.pakeKEMRequested = true
+	return true
+}
+
+// acceptHybridKeyExchange answers a recipient's offer, if any. Senders always
+// accept; with --pq they also refuse recipients that made no offer.
+func (c *Client) acceptHybridKeyExchange(m message.Message) (err error) {
+	if m.Message != pakekey.HybridMLKEM768 {
+		if c.Options.PostQuantum {
+			return errPostQuantumUnavailable
+		}
+		return nil
+	}
+	c.pakeKEMSharedKey, c.pakeCiphertext, err = pakekey.Encapsulate(m.Message, m.Bytes3)
+	if err != nil {
+		return err
+	}
+	c.pakeKEM = m.Message
+	c.pakeEncapsulationKey = append([]byte(nil), m.Bytes3...)
+	return nil
+}
+
+// completeHybridKeyExchange decapsulates the sender's answer to the
+// recipient's offer.
+func (c *Client) completeHybridKeyExchange(m message.Message) (err error) {
+	if c.pakeKEM == "" {
+		if m.Message != "" || len(m.Bytes3) != 0 {
+			return fmt.Errorf("unexpected %q exchange from sender", m.Message)
+		}
+		return nil
+	}
+	if m.Message != c.pakeKEM || len(m.Bytes3) == 0 {
+		return errPostQuantumUnavailable
+	}
+	c.pakeCiphertext = append([]byte(nil), m.Bytes3...)
+	c.pakeKEMSharedKey, err = c.pakeHybridKey.Decapsulate(c.pakeCiphertext)
+	if err != nil {
+		return fmt.Errorf("invalid ML-KEM ciphertext: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) clearHybridKeyExchange() {
+	c.pakeKEM = ""
+	c.pakeHybridKey = nil
+	c.pakeEncapsulationKey = nil
+	c.pakeCiphertext = nil
+	c.pakeKEMSharedKey = nil
+	c.pakeKEMRequested = false
+}
@@ -0,0 +1,219 @@
+package croc
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/schollz/croc/v11/src/message"
+	"github.com/schollz/croc/v11/src/models"
+	"github.com/schollz/croc/v11/src/pakekey"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// hybridHandshake runs the PAKE exchange between two clients up to key
+// derivation, without a relay, and returns both sides' channel keys.
+func hybridHandshake(t *testing.T, receiver, sender *Client) (pakekey.Keys, pakekey.Keys, error) {
+	t.Helper()
+	const room = "hybrid-room"
+	receiver.Options.RoomName, sender.Options.RoomName = room, room
+	A, err := pakekey.Init([]byte("passphrase"), 0, "p256", pakekey.PurposeTransfer, room)
+	require.NoError(t, err)
+	B, err := pakekey.Init([]byte("passphrase"), 1, "p256", pakekey.PurposeTransfer, room)
+	require.NoError(t, err)
+	receiver.Pake, sender.Pake = A, B
+	receiver.pakeCurve, sender.pakeCurve = "p256", "p256"
+
+	offer := message.Message{Type: message.TypePAKE, Bytes: A.Bytes()}
+	if receiver.Options.PostQuantum {
+		require.NoError(t, receiver.offerHybridKeyExchange())
+		offer.Message, offer.Bytes3 = receiver.pakeKEM, receiver.pakeEncapsulationKey
+	}
+	receiver.pakeInitiator, sender.pakeInitiator = offer.Bytes, offer.Bytes
+	require.NoError(t, B.Update(offer.Bytes))
+	if err = sender.acceptHybridKeyExchange(offer); err != nil {
+		return pakekey.Keys{}, pakekey.Keys{}, err
+	}
+	answer := message.Message{
+		Type:    message.TypePAKE,
+		Message: sender.pakeKEM,
+		Bytes:   B.Bytes(),
+		Bytes3:  sender.pakeCiphertext,
+	}
+	receiver.pakeResponder, sender.pakeResponder = answer.Bytes, answer.Bytes
+	require.NoError(t, A.Update(answer.Bytes))
+	if err = receiver.completeHybridKeyExchange(answer); err != nil {
+		return pakekey.Keys{}, pakekey.Keys{}, err
+	}
+	salt := make([]byte, pakekey.SaltSize)
+	require.NoError(t, receiver.derivePakeKeys(salt))
+	require.NoError(t, sender.derivePakeKeys(salt))
+	return receiver.pakeKeys, sender.pakeKeys, nil
+}
+
+func TestHybridKeyExchangeNegotiation(t *testing.T) {
+	receiver := &Client{Options: Options{PostQuantum: true}}
+	sender := &Client{}
+	receiverKeys, senderKeys, err := hybridHandshake(t, receiver, sender)
+	require.NoError(t, err)
+	assert.Equal(t, pakekey.HybridMLKEM768, sender.pakeKEM)
+	assert.Equal(t, receiverKeys.EncryptionKey, senderKeys.EncryptionKey)
+	assert.True(t, pakekey.Confirm(senderKeys.ConfirmationA, receiverKeys.ConfirmationA))
+	assert.True(t, pakekey.Confirm(receiverKeys.ConfirmationB, senderKeys.ConfirmationB))
+
+	classicalReceiver, classicalSender := &Client{}, &Client{}
+	classicalKeys, _, err := hybridHandshake(t, classicalReceiver, classicalSender)
+	require.NoError(t, err)
+	assert.Empty(t, classicalSender.pakeKEM)
+	assert.Empty(t, classicalSender.pakeCiphertext)
+	assert.NotEqual(t, classicalKeys.EncryptionKey, receiverKeys.EncryptionKey)
+
+	_, _, err = hybridHandshake(t, &Client{}, &Client{Options: Options{PostQuantum: true}})
+	assert.ErrorIs(t, err, errPostQuantumUnavailable)
+}
+
+func TestHybridKeyExchangeRejectsClassicalAnswer(t *testing.T) {
+	receiver := &Client{}
+	require.NoError(t, receiver.offerHybridKeyExchange())
+	err := receiver.completeHybridKeyExchange(message.Message{Type: message.TypePAKE, Bytes: []byte("responder")})
+	assert.ErrorIs(t, err, errPostQuantumUnavailable)
+
+	classical := &Client{}
+	err = classical.completeHybridKeyExchange(message.Message{
+		Type:    message.TypePAKE,
+		Message: pakekey.HybridMLKEM768,
+		Bytes3:  []byte("ciphertext"),
+	})
+	assert.Error(t, err)
+}
+
+// postQuantumTransfer sends a file from sender to receiver over the test
+// relay and returns both sides' errors and what the receiver wrote.
+func postQuantumTransfer(t *testing.T, sender, receiver Options) (sendErr, receiveErr error, got []byte) {
+	t.Helper()
+	testDir := t.TempDir()
+	sourcePath := filepath.Join(testDir, "pq-source.txt")
+	receiveDir := filepath.Join(testDir, "receive")
+	require.NoError(t, os.MkdirAll(receiveDir, 0o755))
+	require.NoError(t, os.WriteFile(sourcePath, []byte("sent over a hybrid post-quantum channel\n"), 0o644))
+
+	filesInfo, emptyFolders, totalNumberFolders, err := GetFilesInfo([]string{sourcePath}, false, false, nil)
+	require.NoError(t, err)
+	originalCwd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(receiveDir))
+	t.Cleanup(func() {
+		if err := os.Chdir(originalCwd); err != nil {
+			t.Errorf("restore working directory: %v", err)
+		}
+	})
+
+	senderClient, err := New(sender)
+	require.NoError(t, err)
+	receiverClient, err := New(receiver)
+	require.NoError(t, err)
+	sendCh, receiveCh := make(chan error, 1), make(chan error, 1)
+	go func() {
+		sendCh <- senderClient.Send(filesInfo, emptyFolders, totalNumberFolders)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	go func() {
+		receiveCh <- receiverClient.Receive()
+	}()
+	sendErr, receiveErr = <-sendCh, <-receiveCh
+	got, _ = os.ReadFile(filepath.Join(receiveDir, "pq-source.txt"))
+	return sendErr, receiveErr, got
+}
+
+func postQuantumSenderOptions(secret string) Options {
+	return Options{
+		IsSender:      true,
+		SharedSecret:  secret,
+		RelayAddress:  "127.0.0.1:8281",
+		RelayPorts:    []string{"8281"},
+		RelayPassword: "pass123",
+		NoPrompt:      true,
+		DisableLocal:  true,
+		Curve:         "siec",
+		HashAlgorithm: "xxhash",
+		PostQuantum:   true,
+		Overwrite:     true,
+	}
+}
+
+func TestCrocPostQuantumTransfer(t *testing.T) {
+	const secret = "post-quantum-transfer-code"
+	sendErr, receiveErr, got := postQuantumTransfer(t, postQuantumSenderOptions(secret), Options{
+		IsSender:      false,
+		SharedSecret:  secret,
+		RelayAddress:  "127.0.0.1:8281",
+		RelayPassword: "pass123",
+		NoPrompt:      true,
+		DisableLocal:  true,
+		Curve:         "siec",
+		PostQuantum:   true,
+		Overwrite:     true,
+	})
+	assert.NoError(t, sendErr)
+	assert.NoError(t, receiveErr)
+	assert.Equal(t, "sent over a hybrid post-quantum channel\n", string(got))
+}
+
+// receiverFromFlags builds a recipient from the global flags of a printed
+// receive command, as croc's command line would.
+func receiverFromFlags(t *testing.T, secret, printed string) Options {
+	t.Helper()
+	flags := flag.NewFlagSet("croc", flag.ContinueOnError)
+	relay := flags.String("relay", models.DEFAULT_RELAY, "")
+	pass := flags.String("pass", models.DEFAULT_PASSPHRASE, "")
+	pq := flags.Bool("pq", false, "")
+	require.NoError(t, flags.Parse(strings.Fields(printed)))
+	require.Empty(t, flags.Args())
+	return Options{
+		SharedSecret:  secret,
+		RelayAddress:  *relay,
+		RelayPassword: *pass,
+		PostQuantum:   *pq,
+		NoPrompt:      true,
+		DisableLocal:  true,
+		Curve:         "siec",
+		Overwrite:     true,
+	}
+}
+
+func TestPostQuantumSenderPrintsAWorkingReceiveCommand(t *testing.T) {
+	const secret = "post-quantum-printed-code"
+	senderOptions := postQuantumSenderOptions(secret)
+	printer, err := New(senderOptions)
+	require.NoError(t, err)
+	printed := printer.receiveCommandFlags()
+	assert.Contains(t, printed, "--pq ")
+
+	sendErr, receiveErr, got := postQuantumTransfer(t, senderOptions, receiverFromFlags(t, secret, printed))
+	assert.NoError(t, sendErr)
+	assert.NoError(t, receiveErr)
+	assert.Equal(t, "sent over a hybrid post-quantum channel\n", string(got))
+}
+
+func TestPostQuantumSenderUpgradesARecipientWithoutPQ(t *testing.T) {
+	const secret = "post-quantum-upgraded-code"
+	receiver := receiverFromFlags(t, secret, "--relay 127.0.0.1:8281 --pass pass123")
+	require.False(t, receiver.PostQuantum)
+	sendErr, receiveErr, got := postQuantumTransfer(t, postQuantumSenderOptions(secret), receiver)
+	assert.NoError(t, sendErr)
+	assert.NoError(t, receiveErr)
+	assert.Equal(t, "sent over a hybrid post-quantum channel\n", string(got))
+}
+
+func TestPostQuantumSenderRequiresTheOfferOnce(t *testing.T) {
+	sender := &Client{Options: Options{PostQuantum: true}}
+	classical := message.Message{Type: message.TypePAKE, Bytes: []byte("initiator")}
+	assert.True(t, sender.requireHybridKeyExchange(classical))
+	assert.False(t, sender.requireHybridKeyExchange(classical))
+	assert.ErrorIs(t, sender.acceptHybridKeyExchange(classical), errPostQuantumUnavailable)
+	assert.False(t, (&Client{}).requireHybridKeyExchange(classical))
+}
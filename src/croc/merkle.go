@@ -0,0 +1,61 @@
+package croc
+
+import (
+	"slices"
+
+	"github.com/schollz/croc/v11/src/models"
+	"github.com/schollz/croc/v11/src/utils"
+)
+
+// maxMerkleRetries bounds how many times chunks that failed verification are
+// requested again before the transfer is abandoned.
+const maxMerkleRetries = 3
+
+// The sender numbers Merkle leaves by transfer chunk, so the leaf size must
+// equal the chunk size; these fail to compile if either constant changes
+// alone.
+var (
+	_ [utils.MerkleBlockSize - models.TCP_BUFFER_SIZE/2]struct{}
+	_ [models.TCP_BUFFER_SIZE/2 - utils.MerkleBlockSize]struct{}
+)
+
+func (c *Client) currentFileUsesMerkle() bool {
+	return c.Options.HashAlgorithm == "merkle"
+}
+
+// verifyMerkleChunk checks a received chunk, laid out as its proof followed by
+// its data, against the root published in fileInfo. It returns the data.
+func verifyMerkleChunk(fileInfo FileInfo, position int64, payload []byte) ([]byte, bool) {
+	if position < 0 || position%utils.MerkleBlockSize != 0 || position >= max(fileInfo.Size, 1) {
+		return nil, false
+	}
+	index := position / utils.MerkleBlockSize
+	leaves := utils.MerkleLeaves(fileInfo.Size)
+	proofSize := utils.MerkleProofSize(index, leaves)
+	if len(payload) < proofSize {
+		return nil, false
+	}
+	proof, data := payload[:proofSize], payload[proofSize:]
+	if int64(len(data)) != min(fileInfo.Size-position, utils.MerkleBlockSize) {
+		return nil, false
+	}
+	return data, utils.VerifyMerkleProof(fileInfo.Hash, index, leaves, data, proof)
+}
+
+// merkleRetryRanges converts the positions of rejected chunks into the
+// CurrentFileChunkRanges form used to request them again.
+func merkleRetryRanges(positions []int64) []int64 {
+	positions = slices.Clone(positions)
+	slices.Sort(positions)
+	positions = slices.Compact(positions)
+	ranges := []int64{utils.MerkleBlockSize}
+	for _, position := range positions {
+		last := len(ranges) - 2
+		if last > 0 && ranges[last]+ranges[last+1]*utils.MerkleBlockSize == position {
+			ranges[last+1]++
+			continue
+		}
+		ranges = append(ranges, position, 1)
+	}
+	return ranges
+}
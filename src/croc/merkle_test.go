@@ -0,0 +1,202 @@
+package croc
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/schollz/croc/v11/src/comm"
+	"github.com/schollz/croc/v11/src/crypt"
+	"github.com/schollz/croc/v11/src/message"
+	"github.com/schollz/croc/v11/src/models"
+	"github.com/schollz/croc/v11/src/transfer"
+	"github.com/schollz/croc/v11/src/utils"
+	"github.com/schollz/progressbar/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMerkleBlocksMatchTransferChunks(t *testing.T) {
+	assert.Equal(t, models.TCP_BUFFER_SIZE/2, utils.MerkleBlockSize)
+}
+
+func TestMerkleRetryRangesMergeAdjacentChunks(t *testing.T) {
+	const block = utils.MerkleBlockSize
+	assert.Equal(t,
+		[]int64{block, 0, 2, 5 * block, 1, 9 * block, 1},
+		merkleRetryRanges([]int64{9 * block, block, 0, 5 * block, block}),
+	)
+	ranges := merkleRetryRanges([]int64{3 * block})
+	assert.True(t, transfer.ChunkRangesContain(ranges, 3*block))
+	assert.False(t, transfer.ChunkRangesContain(ranges, 2*block))
+	assert.Equal(t, 1, utils.ChunkRangesCount(ranges, 10*block, block))
+}
+
+func TestVerifyMerkleChunkRejectsMisplacedData(t *testing.T) {
+	data := bytes.Repeat([]byte("abc"), utils.MerkleBlockSize)
+	tree, err := utils.NewMerkleTree(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	fileInfo := FileInfo{Size: int64(len(data)), Hash: tree.Root()}
+	leaves := utils.MerkleLeaves(fileInfo.Size)
+
+	payload := tree.AppendProof(nil, 1)
+	payload = append(payload, data[utils.MerkleBlockSize:2*utils.MerkleBlockSize]...)
+	chunk, ok := verifyMerkleChunk(fileInfo, utils.MerkleBlockSize, payload)
+	assert.True(t, ok)
+	assert.Equal(t, data[utils.MerkleBlockSize:2*utils.MerkleBlockSize], chunk)
+
+	_, ok = verifyMerkleChunk(fileInfo, 2*utils.MerkleBlockSize, payload)
+	assert.False(t, ok, "chunk replayed at another position")
+	_, ok = verifyMerkleChunk(fileInfo, utils.MerkleBlockSize+1, payload)
+	assert.False(t, ok, "unaligned position")
+	_, ok = verifyMerkleChunk(fileInfo, utils.MerkleBlockSize, payload[:utils.MerkleProofSize(1, leaves)+10])
+	assert.False(t, ok, "truncated chunk")
+}
+
+// TestMerkleCorruptChunkIsRequestedAgain sends a file whose chunk is read
+// corrupted on the sender, as a failing disk would, and checks that the
+// recipient writes only verified chunks and asks for the bad one again.
+func TestMerkleCorruptChunkIsRequestedAgain(t *testing.T) {
+	const block = utils.MerkleBlockSize
+	size := int64(4*block + 1234)
+	original := make([]byte, size)
+	rand.New(rand.NewSource(2)).Read(original)
+	tree, err := utils.NewMerkleTree(bytes.NewReader(original), size)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	corrupted := bytes.Clone(original)
+	corrupted[2*block+17] ^= 0xff
+	sourcePath := filepath.Join(dir, "source.bin")
+	require.NoError(t, os.WriteFile(sourcePath, corrupted, 0o644))
+	source, err := os.Open(sourcePath)
+	require.NoError(t, err)
+	destination, err := os.Create(filepath.Join(dir, "received.bin"))
+	require.NoError(t, err)
+	require.NoError(t, destination.Truncate(size))
+
+	aead, err := crypt.NewAESGCM(bytes.Repeat([]byte{7}, 32))
+	require.NoError(t, err)
+	fileInfo := FileInfo{Name: "received.bin", Size: size, Hash: tree.Root()}
+	quietBar := func() *progressbar.ProgressBar {
+		return progressbar.NewOptions64(size, progressbar.OptionSetWriter(io.Discard))
+	}
+	dataSend, dataReceive := net.Pipe()
+	controlLocal, controlPeer := net.Pipe()
+	defer controlPeer.Close()
+
+	sender := &Client{
+		Options:         Options{IsSender: true, NoCompress: true, HashAlgorithm: "merkle", RelayPorts: []string{"9009"}},
+		FilesToTransfer: []FileInfo{fileInfo},
+		dataAEAD:        aead,
+		merkleTree:      tree,
+		bar:             quietBar(),
+		mutex:           &sync.Mutex{},
+		stop:            newStop(nil),
+	}
+	receiver := &Client{
+		Options:               Options{NoCompress: true, HashAlgorithm: "merkle"},
+		FilesToTransfer:       []FileInfo{fileInfo},
+		CurrentFile:           destination,
+		CurrentFileChunkCount: int(utils.MerkleLeaves(size)),
+		conn:                  []*comm.Comm{comm.New(controlLocal)},
+		dataAEAD:              aead,
+		bar:                   quietBar(),
+		receiveMutex:          &sync.Mutex{},
+		stop:                  newStop(nil),
+	}
+	attempt := &transferAttemptState{errc: make(chan error, 1)}
+	go sender.sendData(0, comm.New(dataSend), source, attempt)
+	go receiver.receiveData(0, comm.New(dataReceive), attempt)
+
+	raw, err := comm.New(controlPeer).Receive()
+	require.NoError(t, err)
+	closeSender, err := message.Decode(nil, raw)
+	require.NoError(t, err)
+	assert.Equal(t, message.TypeCloseSender, closeSender.Type)
+	select {
+	case err = <-attempt.errc:
+		t.Fatalf("transfer reported %v", err)
+	default:
+	}
+
+	receiver.receiveMutex.Lock()
+	retry := receiver.merkleRetryRanges
+	receiver.receiveMutex.Unlock()
+	assert.Equal(t, []int64{block, 2 * block, 1}, retry)
+	received, err := os.ReadFile(filepath.Join(dir, "received.bin"))
+	require.NoError(t, err)
+	assert.Equal(t, original[:2*block], received[:2*block])
+	assert.Equal(t, make([]byte, block), received[2*block:3*block], "the corrupt chunk is not written")
+	assert.Equal(t, original[3*block:], received[3*block:])
+}
+
+func TestCrocMerkleTransfer(t *testing.T) {
+	testDir := t.TempDir()
+	sourcePath := filepath.Join(testDir, "merkle-source.bin")
+	receiveDir := filepath.Join(testDir, "receive")
+	require.NoError(t, os.MkdirAll(receiveDir, 0o755))
+	want := make([]byte, 5*utils.MerkleBlockSize+321)
+	rand.New(rand.NewSource(3)).Read(want)
+	require.NoError(t, os.WriteFile(sourcePath, want, 0o644))
+
+	filesInfo, emptyFolders, totalNumberFolders, err := GetFilesInfo([]string{sourcePath}, false, false, nil)
+	require.NoError(t, err)
+	originalCwd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(receiveDir))
+	t.Cleanup(func() {
+		if err := os.Chdir(originalCwd); err != nil {
+			t.Errorf("restore working directory: %v", err)
+		}
+	})
+
+	const secret = "merkle-transfer-code"
+	sender, err := New(Options{
+		IsSender:      true,
+		SharedSecret:  secret,
+		RelayAddress:  "127.0.0.1:8281",
+		RelayPorts:    []string{"8281"},
+		RelayPassword: "pass123",
+		NoPrompt:      true,
+		DisableLocal:  true,
+		Curve:         "siec",
+		HashAlgorithm: "merkle",
+		Overwrite:     true,
+	})
+	require.NoError(t, err)
+	receiver, err := New(Options{
+		IsSender:      false,
+		SharedSecret:  secret,
+		RelayAddress:  "127.0.0.1:8281",
+		RelayPassword: "pass123",
+		NoPrompt:      true,
+		DisableLocal:  true,
+		Curve:         "siec",
+		Overwrite:     true,
+	})
+	require.NoError(t, err)
+
+	errCh := make(chan error, 2)
+	go func() {
+		errCh <- sender.Send(filesInfo, emptyFolders, totalNumberFolders)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	go func() {
+		errCh <- receiver.Receive()
+	}()
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, <-errCh)
+	}
+
+	got, err := os.ReadFile(filepath.Join(receiveDir, "merkle-source.bin"))
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+	assert.Equal(t, "merkle", receiver.Options.HashAlgorithm)
+}
@@ -0,0 +1,45 @@
+package croc
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/schollz/logger"
+
+	"github.com/schollz/croc/v11/src/comm"
+)
+
+// quicConnectTimeout bounds the QUIC attempt before falling back to TCP, for
+// networks that drop UDP.
+const quicConnectTimeout = 3 * time.Second
+
+// quicAllowed reports whether the transfer rooms may be joined over QUIC on
+// the relay at relayAddress. Proxies and WebSocket relays only carry TCP.
+func (c *Client) quicAllowed(relayAddress string) bool {
+	return c.Options.QUIC &&
+		comm.Socks5Proxy == "" &&
+		comm.HttpProxy == "" &&
+		!c.relays.Transport(relayAddress).IsWebSocket() &&
+		c.relays.SupportsQUIC(relayAddress)
+}
+
+// connectDataOverQUIC joins the room of every transfer port over a single
+// QUIC connection to the relay. It reports false when the connections must
+// be made over TCP instead.
+func (c *Client) connectDataOverQUIC(relayAddress string) bool {
+	if !c.quicAllowed(relayAddress) {
+		return false
+	}
+	rooms := make([]string, len(c.Options.RelayPorts))
+	for j := range rooms {
+		rooms[j] = fmt.Sprintf("%s-%d", c.Options.RoomName, j)
+	}
+	dataConns, err := c.relays.ConnectToQUICServer(relayAddress, c.relayCredential(relayAddress), c.Options.RelayPorts, rooms, quicConnectTimeout)
+	if err != nil {
+		log.Debugf("QUIC unavailable, using TCP: %v", err)
+		return false
+	}
+	copy(c.conn[1:], dataConns)
+	log.Debugf("connected %d transfer ports over QUIC", len(dataConns))
+	return true
+}
@@ -0,0 +1,106 @@
+package croc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/schollz/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/schollz/croc/v11/src/tcp"
+)
+
+func TestTransferOverQUIC(t *testing.T) {
+	log.SetLevel("warn")
+	ports := freeConsecutiveTestPorts(t, 3)
+	ctx, stopRelay := context.WithCancel(context.Background())
+	defer stopRelay()
+	group := tcp.NewGroup()
+	go tcp.RunWithOptionsAsync("127.0.0.1", ports[0], "pass123",
+		tcp.WithCtx(ctx),
+		tcp.WithLogLevel("warn"),
+		tcp.WithBanner(strings.Join(ports[1:], ",")),
+		tcp.WithQUIC(true),
+		tcp.WithGroup(group))
+	for _, port := range ports[1:] {
+		go tcp.RunWithOptionsAsync("127.0.0.1", port, "pass123",
+			tcp.WithCtx(ctx),
+			tcp.WithLogLevel("warn"),
+			tcp.WithGroup(group))
+	}
+	time.Sleep(250 * time.Millisecond)
+
+	tempFile, cleanup := createTestFile(t, 3<<20)
+	defer cleanup()
+	receivedFile := filepath.Base(tempFile)
+	defer os.Remove(receivedFile)
+
+	relayAddress := net.JoinHostPort("127.0.0.1", ports[0])
+	secret := fmt.Sprintf("quic-%d", time.Now().UnixNano())
+	sender, err := New(Options{
+		IsSender:      true,
+		SharedSecret:  secret,
+		RelayAddress:  relayAddress,
+		RelayPorts:    []string{ports[0]},
+		RelayPassword: "pass123",
+		NoPrompt:      true,
+		DisableLocal:  true,
+		Curve:         "siec",
+		Overwrite:     true,
+		NoCompress:    true,
+		QUIC:          true,
+	})
+	require.NoError(t, err)
+	receiver, err := New(Options{
+		IsSender:      false,
+		SharedSecret:  secret,
+		RelayAddress:  relayAddress,
+		RelayPassword: "pass123",
+		NoPrompt:      true,
+		DisableLocal:  true,
+		Curve:         "siec",
+		Overwrite:     true,
+		NoCompress:    true,
+		QUIC:          true,
+	})
+	require.NoError(t, err)
+	filesInfo, emptyFolders, totalNumberFolders, err := GetFilesInfo([]string{tempFile}, false, false, []string{})
+	require.NoError(t, err)
+
+	errc := make(chan error, 2)
+	go func() {
+		errc <- sender.Send(filesInfo, emptyFolders, totalNumberFolders)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	go func() {
+		errc <- receiver.Receive()
+	}()
+	for range 2 {
+		require.NoError(t, <-errc)
+	}
+
+	assert.True(t, sender.relays.SupportsQUIC(relayAddress))
+	for _, c := range []*Client{sender, receiver} {
+		require.NotNil(t, c.conn[1])
+		_, overUDP := c.conn[1].Connection().RemoteAddr().(*net.UDPAddr)
+		assert.True(t, overUDP, "transfer connection should be a QUIC stream")
+	}
+	sent, err := os.ReadFile(tempFile)
+	require.NoError(t, err)
+	received, err := os.ReadFile(receivedFile)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(sent, received))
+}
+
+func TestQUICAllowed(t *testing.T) {
+	c := &Client{Options: Options{QUIC: true}}
+	assert.False(t, c.quicAllowed("203.0.113.1:9009"), "relay did not advertise QUIC")
+}
@@ -0,0 +1,789 @@
+package croc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rivo/uniseg"
+	log "github.com/schollz/logger"
+	"golang.org/x/term"
+
+	"github.com/schollz/croc/v11/src/termui"
+	"github.com/schollz/croc/v11/src/utils"
+)
+
+const (
+	tuiFrameInterval = 250 * time.Millisecond
+	// tuiSamples is the number of one-second throughput samples kept for the
+	// graph and the rate.
+	tuiSamples    = 240
+	tuiRateWindow = 5
+	tuiGraphRows  = 4
+	// tuiCancelGrace is how long a cancelled transfer waits for the peer to
+	// hang up before the control connection is closed.
+	tuiCancelGrace = 2 * time.Second
+)
+
+// Routes of the data connections shown in the TUI.
+const (
+	routeRelay      = "relay"
+	routeLocalRelay = "local relay"
+	routeDirect     = "direct"
+)
+
+// errTransferCancelled is returned when the transfer was cancelled in the TUI.
+var errTransferCancelled = errors.New("transfer cancelled")
+
+type tuiFileStatus int
+
+const (
+	fileQueued tuiFileStatus = iota
+	fileActive
+	fileVerified
+	fileSkipped
+	fileUnchanged
+)
+
+type tuiFile struct {
+	name   string
+	size   int64
+	done   int64
+	status tuiFileStatus
+}
+
+type tuiKey int
+
+const (
+	keyUp tuiKey = iota
+	keyDown
+	keyPause
+	keySkip
+	keyQuit
+	keyInterrupt
+	keyYes
+	keyNo
+)
+
+// transferTUI is the full-screen view of a transfer enabled with --tui. The
+// transfer reports progress to it; its keys pause the data connections, skip
+// queued files on the recipient and cancel the transfer.
+type transferTUI struct {
+	isSender bool
+	output   io.Writer
+	color    bool
+
+	mu       sync.Mutex
+	files    []tuiFile
+	current  int
+	selected int
+	follow   bool
+	peer     string
+	route    string
+	notice   string
+	question string
+	answer   func(bool)
+	// resume is closed while the transfer is not paused
+	resume      chan struct{}
+	paused      bool
+	cancelled   bool
+	cancel      func()
+	samples     []int64
+	sampleBytes int64
+
+	startOnce sync.Once
+	stopOnce  sync.Once
+	quit      chan struct{}
+	done      chan struct{}
+	restore   func()
+}
+
+// newTransferTUI returns nil, so that the usual progress bars are shown, when
+// the terminal cannot host the full-screen view.
+func newTransferTUI(isSender bool) *transferTUI {
+	if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stderr.Fd())) {
+		log.Debug("not a terminal, showing progress bars instead of the TUI")
+		return nil
+	}
+	output, color := termui.Output(os.Stderr)
+	return newTUIView(isSender, output, color)
+}
+
+func newTUIView(isSender bool, output io.Writer, color bool) *transferTUI {
+	resume := make(chan struct{})
+	close(resume)
+	return &transferTUI{
+		isSender: isSender,
+		output:   output,
+		color:    color,
+		current:  -1,
+		follow:   true,
+		resume:   resume,
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// openTUI prepares the full-screen view for --tui. It is shown once the files
+// are known.
+func (c *Client) openTUI() {
+	if c.Options.TUI && !c.Options.Quiet && !c.Options.Stdout && !c.Options.SendingText {
+		c.tui = newTransferTUI(c.Options.IsSender)
+	}
+}
+
+// startTUI switches to the full-screen view once the files are known.
+func (c *Client) startTUI() {
+	if c.tui == nil {
+		return
+	}
+	cancel := c.stop.cancel
+	c.tui.start(c.FilesToTransfer, func() {
+		control := c.conn[0]
+		c.SendError()
+		if cancel != nil {
+			cancel()
+		}
+		if control != nil {
+			time.AfterFunc(tuiCancelGrace, control.Close)
+		}
+	})
+}
+
+func (t *transferTUI) start(files []FileInfo, cancel func()) {
+	if t == nil {
+		return
+	}
+	t.startOnce.Do(func() {
+		t.mu.Lock()
+		t.cancel = cancel
+		t.files = make([]tuiFile, len(files))
+		for i, file := range files {
+			t.files[i] = tuiFile{name: path.Join(file.FolderRemote, file.Name), size: file.Size}
+			if file.Size == 0 {
+				t.files[i].status = fileVerified
+			}
+		}
+		t.mu.Unlock()
+
+		fd := int(os.Stdin.Fd())
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			log.Debugf("could not start the TUI: %v", err)
+			close(t.done)
+			return
+		}
+		// the alternate screen keeps the terminal's history intact
+		_, _ = io.WriteString(t.output, "\x1b[?1049h\x1b[?25l")
+		t.restore = func() {
+			_, _ = io.WriteString(t.output, "\x1b[?25h\x1b[?1049l")
+			_ = term.Restore(fd, state)
+		}
+		go t.readKeys(os.Stdin)
+		go t.run()
+	})
+}
+
+// stop leaves the full-screen view and prints a summary of the files.
+func (t *transferTUI) stop() {
+	if t == nil {
+		return
+	}
+	t.stopOnce.Do(func() {
+		started := false
+		t.startOnce.Do(func() { close(t.done) })
+		select {
+		case <-t.done:
+		default:
+			started = true
+			close(t.quit)
+			<-t.done
+		}
+		if t.restore != nil {
+			t.restore()
+		}
+		if started {
+			_, _ = io.WriteString(t.output, t.summary()+"\n")
+		}
+	})
+}
+
+func (t *transferTUI) run() {
+	defer close(t.done)
+	frames := time.NewTicker(tuiFrameInterval)
+	defer frames.Stop()
+	seconds := time.NewTicker(time.Second)
+	defer seconds.Stop()
+	t.draw()
+	for {
+		select {
+		case <-t.quit:
+			return
+		case <-seconds.C:
+			t.mu.Lock()
+			t.samples = append(t.samples, t.sampleBytes)
+			if len(t.samples) > tuiSamples {
+				t.samples = t.samples[len(t.samples)-tuiSamples:]
+			}
+			t.sampleBytes = 0
+			t.mu.Unlock()
+		case <-frames.C:
+			t.draw()
+		}
+	}
+}
+
+func (t *transferTUI) draw() {
+	width, height, err := term.GetSize(int(os.Stderr.Fd()))
+	if err != nil || width <= 0 || height <= 0 {
+		width, height = 80, 24
+	}
+	t.mu.Lock()
+	lines := t.render(width, height)
+	t.mu.Unlock()
+	var frame strings.Builder
+	frame.WriteString("\x1b[H")
+	for i, line := range lines {
+		if i > 0 {
+			frame.WriteString("\r\n")
+		}
+		frame.WriteString(line)
+		frame.WriteString("\x1b[K")
+	}
+	frame.WriteString("\x1b[J")
+	_, _ = io.WriteString(t.output, frame.String())
+}
+
+// readKeys handles key presses until the view stops. A read that is still
+// blocked then ends with the next key press, which is discarded.
+func (t *transferTUI) readKeys(input io.Reader) {
+	buffer := make([]byte, 64)
+	for {
+		n, err := input.Read(buffer)
+		select {
+		case <-t.quit:
+			return
+		default:
+		}
+		if err != nil {
+			return
+		}
+		for _, key := range parseKeys(buffer[:n]) {
+			t.handleKey(key)
+		}
+	}
+}
+
+func parseKeys(input []byte) (keys []tuiKey) {
+	for i := 0; i < len(input); i++ {
+		switch b := input[i]; {
+		case b == 0x1b && i+2 < len(input) && (input[i+1] == '[' || input[i+1] == 'O'):
+			switch input[i+2] {
+			case 'A':
+				keys = append(keys, keyUp)
+			case 'B':
+				keys = append(keys, keyDown)
+			}
+			i += 2
+		case b == 0x1b:
+			keys = append(keys, keyNo)
+		case b == 0x03:
+			keys = append(keys, keyInterrupt)
+		case b == 'k':
+			keys = append(keys, keyUp)
+		case b == 'j':
+			keys = append(keys, keyDown)
+		case b == 'p' || b == ' ':
+			keys = append(keys, keyPause)
+		case b == 's':
+			keys = append(keys, keySkip)
+		case b == 'q':
+			keys = append(keys, keyQuit)
+		case b == 'y' || b == 'Y':
+			keys = append(keys, keyYes)
+		case b == 'n' || b == 'N' || b == '\r' || b == '\n':
+			keys = append(keys, keyNo)
+		}
+	}
+	return keys
+}
+
+func (t *transferTUI) handleKey(key tuiKey) {
+	t.mu.Lock()
+	if key == keyInterrupt {
+		t.mu.Unlock()
+		t.cancelTransfer()
+		return
+	}
+	if answer := t.answer; answer != nil {
+		if key == keyYes || key == keyNo || key == keyQuit {
+			t.answer = nil
+			t.question = ""
+			t.mu.Unlock()
+			answer(key == keyYes)
+			return
+		}
+		t.mu.Unlock()
+		return
+	}
+	switch key {
+	case keyUp:
+		if t.selected > 0 {
+			t.selected--
+		}
+		t.follow = false
+	case keyDown:
+		if t.selected < len(t.files)-1 {
+			t.selected++
+		}
+		t.follow = false
+	case keyPause:
+		t.setPausedLocked(!t.paused)
+	case keySkip:
+		t.skipSelectedLocked()
+	case keyQuit:
+		t.askLocked("Cancel the transfer?", func(accepted bool) {
+			if accepted {
+				t.cancelTransfer()
+			}
+		})
+	}
+	t.mu.Unlock()
+}
+
+func (t *transferTUI) setPausedLocked(paused bool) {
+	if paused == t.paused {
+		return
+	}
+	t.paused = paused
+	if paused {
+		t.resume = make(chan struct{})
+		t.notice = "paused"
+	} else {
+		close(t.resume)
+		t.notice = "resumed"
+	}
+}
+
+func (t *transferTUI) skipSelectedLocked() {
+	if t.isSender {
+		t.notice = "only the recipient can skip files"
+		return
+	}
+	if t.selected < 0 || t.selected >= len(t.files) {
+		return
+	}
+	file := &t.files[t.selected]
+	switch file.status {
+	case fileQueued:
+		file.status = fileSkipped
+		t.notice = "skipping " + file.name
+	case fileSkipped:
+		file.status = fileQueued
+		t.notice = "queued " + file.name + " again"
+	case fileActive:
+		t.notice = "files in progress cannot be skipped"
+	default:
+		t.notice = file.name + " is done"
+	}
+}
+
+func (t *transferTUI) cancelTransfer() {
+	t.mu.Lock()
+	if t.cancelled {
+		t.mu.Unlock()
+		return
+	}
+	t.cancelled = true
+	t.notice = "cancelling..."
+	t.setPausedLocked(false)
+	cancel := t.cancel
+	answer := t.answer
+	t.answer = nil
+	t.question = ""
+	t.mu.Unlock()
+	if answer != nil {
+		answer(false)
+	}
+	if cancel != nil {
+		go cancel()
+	}
+}
+
+// askLocked shows a yes/no question in place of the key help; answer is
+// called with the reply.
+func (t *transferTUI) askLocked(question string, answer func(bool)) {
+	t.question = question
+	t.answer = answer
+}
+
+// confirm asks a yes/no question in the view and waits for the reply. It
+// reports false once the transfer has been cancelled.
+func (t *transferTUI) confirm(question string) bool {
+	reply := make(chan bool, 1)
+	t.mu.Lock()
+	if t.cancelled {
+		t.mu.Unlock()
+		return false
+	}
+	t.askLocked(question, func(accepted bool) { reply <- accepted })
+	t.mu.Unlock()
+	select {
+	case accepted := <-reply:
+		return accepted
+	case <-t.quit:
+		return false
+	}
+}
+
+// waitWhilePaused blocks while the transfer is paused.
+func (t *transferTUI) waitWhilePaused(ctx context.Context) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	resume := t.resume
+	t.mu.Unlock()
+	select {
+	case <-resume:
+	case <-ctx.Done():
+	}
+}
+
+func (t *transferTUI) isCancelled() bool {
+	if t == nil {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.cancelled
+}
+
+func (t *transferTUI) setPeer(peer, route string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.peer, t.route = peer, route
+}
+
+// startFile marks file i as in progress with done bytes already present.
+func (t *transferTUI) startFile(i int, done int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if i < 0 || i >= len(t.files) {
+		return
+	}
+	t.current = i
+	t.files[i].status = fileActive
+	t.files[i].done = done
+	if t.follow {
+		t.selected = i
+	}
+}
+
+func (t *transferTUI) addBytes(n int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sampleBytes += int64(n)
+	if t.current >= 0 && t.current < len(t.files) {
+		t.files[t.current].done += int64(n)
+	}
+}
+
+// setStatus records the outcome of file i. A file that was in progress when
+// it turns out to be unchanged has just been received and verified.
+func (t *transferTUI) setStatus(i int, status tuiFileStatus) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if i < 0 || i >= len(t.files) {
+		return
+	}
+	if status == fileUnchanged && t.files[i].status == fileActive {
+		status = fileVerified
+	}
+	t.files[i].status = status
+	if status == fileVerified {
+		t.files[i].done = t.files[i].size
+	}
+}
+
+// requested records that the recipient asked for file i: the files before it
+// that were sent have been verified, and those that were not were skipped.
+func (t *transferTUI) requested(i int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.settleLocked(i)
+}
+
+// finish settles every file once the transfer has completed.
+func (t *transferTUI) finish() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.settleLocked(len(t.files))
+}
+
+func (t *transferTUI) settleLocked(before int) {
+	for i := 0; i < before && i < len(t.files); i++ {
+		switch t.files[i].status {
+		case fileActive:
+			t.files[i].status = fileVerified
+			t.files[i].done = t.files[i].size
+		case fileQueued:
+			t.files[i].status = fileSkipped
+		}
+	}
+}
+
+// isSkipped reports whether the user skipped file i on the recipient.
+func (t *transferTUI) isSkipped(i int) bool {
+	if t == nil {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return i >= 0 && i < len(t.files) && t.files[i].status == fileSkipped
+}
+
+// rate returns the recent throughput in bytes per second.
+func (t *transferTUI) rate() int64 {
+	samples := t.samples
+	if len(samples) > tuiRateWindow {
+		samples = samples[len(samples)-tuiRateWindow:]
+	}
+	if len(samples) == 0 {
+		return 0
+	}
+	var total int64
+	for _, sample := range samples {
+		total += sample
+	}
+	return total / int64(len(samples))
+}
+
+func (t *transferTUI) render(width, height int) []string {
+	var total, done int64
+	counts := make(map[tuiFileStatus]int)
+	for _, file := range t.files {
+		total += file.size
+		counts[file.status]++
+		switch file.status {
+		case fileActive:
+			done += min(file.done, file.size)
+		case fileVerified, fileUnchanged:
+			done += file.size
+		case fileSkipped:
+			total -= file.size
+		}
+	}
+	rate := t.rate()
+
+	direction := "Receiving <- "
+	if t.isSender {
+		direction = "Sending -> "
+	}
+	header := termui.Emphasis("croc", t.color) + "  " + direction + t.peer
+	if t.route != "" {
+		header += " (" + t.route + ")"
+	}
+	if t.paused {
+		header += "  " + termui.Warning("[paused]", t.color)
+	}
+	percent := 100.0
+	if total > 0 {
+		percent = float64(done) / float64(total) * 100
+	}
+	eta := "--"
+	if rate > 0 && total > done {
+		eta = (time.Duration((total-done)/rate) * time.Second).String()
+	} else if total <= done {
+		eta = "0s"
+	}
+	overall := fmt.Sprintf("%s / %s  %3.0f%%  %s/s  ETA %s  (%d/%d files done)",
+		utils.ByteCountDecimal(done), utils.ByteCountDecimal(total), percent,
+		utils.ByteCountDecimal(rate), eta,
+		counts[fileVerified]+counts[fileUnchanged]+counts[fileSkipped], len(t.files))
+
+	lines := []string{fitWidth(header, width), fitWidth(overall, width)}
+	lines = append(lines, throughputGraph(t.samples, width, tuiGraphRows)...)
+	lines = append(lines, strings.Repeat("─", width))
+
+	footer := "↑/↓ select  p pause  s skip  q cancel"
+	if t.isSender {
+		footer = "↑/↓ select  p pause  q cancel"
+	}
+	if t.question != "" {
+		footer = termui.Warning(t.question+" (y/N)", t.color)
+	} else if t.notice != "" {
+		footer += "  " + t.notice
+	}
+
+	listHeight := height - len(lines) - 2
+	if listHeight < 1 {
+		listHeight = 1
+	}
+	first := 0
+	if t.selected >= listHeight {
+		first = t.selected - listHeight + 1
+	}
+	for i := first; i < len(t.files) && i < first+listHeight; i++ {
+		lines = append(lines, t.fileLine(i, width))
+	}
+	for len(lines) < height-2 {
+		lines = append(lines, "")
+	}
+	lines = append(lines, strings.Repeat("─", width), fitWidth(footer, width))
+	return lines
+}
+
+func (t *transferTUI) fileLine(i, width int) string {
+	file := t.files[i]
+	cursor := "  "
+	if i == t.selected {
+		cursor = "> "
+	}
+	label, style := "queued", ""
+	switch file.status {
+	case fileActive:
+		label, style = "receiving", termui.Cyan
+		if t.isSender {
+			label = "sending"
+		}
+		if file.size > 0 {
+			label += fmt.Sprintf(" %3d%%", min(file.done, file.size)*100/file.size)
+		}
+	case fileVerified:
+		label, style = "verified", termui.Green
+	case fileSkipped:
+		label, style = "skipped", termui.Yellow
+	case fileUnchanged:
+		label, style = "unchanged", termui.Green
+	}
+	status := fmt.Sprintf("%-14s", label)
+	if style != "" {
+		status = termui.Color(status, style, t.color)
+	}
+	return fitWidth(fmt.Sprintf("%s%s %10s  %s", cursor, status, utils.ByteCountDecimal(file.size), file.name), width)
+}
+
+// throughputGraph draws the samples as a bar chart of the given height, the
+// most recent sample on the right.
+func throughputGraph(samples []int64, width, rows int) []string {
+	levels := []rune(" ▁▂▃▄▅▆▇█")
+	if len(samples) > width {
+		samples = samples[len(samples)-width:]
+	}
+	var peak int64
+	for _, sample := range samples {
+		peak = max(peak, sample)
+	}
+	graph := make([]string, rows)
+	for row := range rows {
+		line := make([]rune, width)
+		for i := range line {
+			line[i] = ' '
+		}
+		// the bottom row is rows-1
+		base := int64(rows-1-row) * 8
+		for i, sample := range samples {
+			height := int64(0)
+			if peak > 0 {
+				height = (sample*int64(rows)*8 + peak - 1) / peak
+			}
+			fill := min(max(height-base, 0), 8)
+			line[width-len(samples)+i] = levels[fill]
+		}
+		graph[row] = string(line)
+	}
+	return graph
+}
+
+// fitWidth cuts text, which may contain color codes, to width columns.
+func fitWidth(text string, width int) string {
+	if uniseg.StringWidth(termui.Plain(text)) <= width {
+		return text
+	}
+	var out strings.Builder
+	used := 0
+	for len(text) > 0 {
+		if loc := ansiPrefix(text); loc > 0 {
+			out.WriteString(text[:loc])
+			text = text[loc:]
+			continue
+		}
+		cluster, rest, clusterWidth, _ := uniseg.FirstGraphemeClusterInString(text, -1)
+		if used+clusterWidth > width {
+			break
+		}
+		out.WriteString(cluster)
+		used += clusterWidth
+		text = rest
+	}
+	if strings.Contains(out.String(), "\x1b[") {
+		out.WriteString(termui.Reset)
+	}
+	return out.String()
+}
+
+// ansiPrefix returns the length of the color code text starts with.
+func ansiPrefix(text string) int {
+	if !strings.HasPrefix(text, "\x1b[") {
+		return 0
+	}
+	if end := strings.IndexByte(text, 'm'); end > 0 {
+		return end + 1
+	}
+	return 0
+}
+
+// summary describes the outcome of the files after the view has closed.
+func (t *transferTUI) summary() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	counts := make(map[tuiFileStatus]int)
+	for _, file := range t.files {
+		counts[file.status]++
+	}
+	var parts []string
+	for _, part := range []struct {
+		status tuiFileStatus
+		label  string
+	}{
+		{fileVerified, "verified"},
+		{fileUnchanged, "unchanged"},
+		{fileSkipped, "skipped"},
+		{fileQueued, "not transferred"},
+		{fileActive, "incomplete"},
+	} {
+		if counts[part.status] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[part.status], part.label))
+		}
+	}
+	summary := fmt.Sprintf("%d files: %s", len(t.files), strings.Join(parts, ", "))
+	if t.cancelled {
+		summary = "Transfer cancelled. " + summary
+	}
+	return summary
+}
@@ -0,0 +1,204 @@
+package croc
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rivo/uniseg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/schollz/croc/v11/src/termui"
+)
+
+func newTestTUI(isSender bool, sizes ...int64) *transferTUI {
+	t := newTUIView(isSender, io.Discard, false)
+	for i, size := range sizes {
+		t.files = append(t.files, tuiFile{name: "file" + string(rune('a'+i)), size: size})
+	}
+	return t
+}
+
+func TestParseKeys(t *testing.T) {
+	assert.Equal(t,
+		[]tuiKey{keyUp, keyDown, keyUp, keyDown, keyPause, keyPause, keySkip, keyQuit, keyInterrupt, keyYes, keyNo, keyNo},
+		parseKeys([]byte("\x1b[A\x1b[Bkjp sq\x03yn\r")))
+	assert.Equal(t, []tuiKey{keyNo}, parseKeys([]byte{0x1b}))
+	assert.Empty(t, parseKeys([]byte("x\x1b[C")))
+}
+
+func TestTUIRecipientSkipsQueuedFiles(t *testing.T) {
+	view := newTestTUI(false, 10, 20, 30)
+	view.startFile(0, 0)
+	view.handleKey(keySkip)
+	assert.Equal(t, "files in progress cannot be skipped", view.notice)
+
+	view.handleKey(keyDown)
+	view.handleKey(keySkip)
+	assert.True(t, view.isSkipped(1))
+	view.handleKey(keySkip)
+	assert.False(t, view.isSkipped(1), "skipping again queues the file")
+	view.handleKey(keySkip)
+
+	// the selection no longer follows the transfer once it has been moved
+	view.startFile(2, 0)
+	assert.Equal(t, 1, view.selected)
+
+	sender := newTestTUI(true, 10)
+	sender.handleKey(keySkip)
+	assert.Equal(t, fileQueued, sender.files[0].status)
+	assert.Equal(t, "only the recipient can skip files", sender.notice)
+}
+
+func TestTUIStatuses(t *testing.T) {
+	view := newTestTUI(true, 10, 20, 30, 40)
+	view.startFile(0, 4)
+	view.addBytes(6)
+	assert.Equal(t, int64(10), view.files[0].done)
+
+	// the recipient asked for the third file: the first was received and
+	// the second was not needed
+	view.requested(2)
+	assert.Equal(t, fileVerified, view.files[0].status)
+	assert.Equal(t, fileSkipped, view.files[1].status)
+	view.startFile(2, 0)
+	view.finish()
+	assert.Equal(t, fileVerified, view.files[2].status)
+	assert.Equal(t, fileSkipped, view.files[3].status)
+
+	recipient := newTestTUI(false, 10, 20)
+	recipient.startFile(0, 0)
+	recipient.setStatus(0, fileUnchanged)
+	recipient.setStatus(1, fileUnchanged)
+	assert.Equal(t, fileVerified, recipient.files[0].status, "a received file that matches is verified")
+	assert.Equal(t, fileUnchanged, recipient.files[1].status)
+	assert.Equal(t, "2 files: 1 verified, 1 unchanged", recipient.summary())
+}
+
+func TestTUIPauseBlocksData(t *testing.T) {
+	view := newTestTUI(true, 10)
+	view.handleKey(keyPause)
+	assert.True(t, view.paused)
+
+	resumed := make(chan struct{})
+	go func() {
+		view.waitWhilePaused(context.Background())
+		close(resumed)
+	}()
+	select {
+	case <-resumed:
+		t.Fatal("paused transfer continued")
+	case <-time.After(50 * time.Millisecond):
+	}
+	view.handleKey(keyPause)
+	select {
+	case <-resumed:
+	case <-time.After(time.Second):
+		t.Fatal("transfer did not resume")
+	}
+
+	var nilView *transferTUI
+	nilView.waitWhilePaused(context.Background())
+	nilView.addBytes(1)
+	assert.False(t, nilView.isCancelled())
+}
+
+func TestTUICancel(t *testing.T) {
+	view := newTestTUI(false, 10)
+	cancelled := make(chan struct{})
+	view.cancel = func() { close(cancelled) }
+
+	view.handleKey(keyQuit)
+	assert.Equal(t, "Cancel the transfer?", view.question)
+	view.handleKey(keyNo)
+	assert.False(t, view.isCancelled())
+
+	view.handleKey(keyPause)
+	view.handleKey(keyQuit)
+	view.handleKey(keyYes)
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("transfer was not cancelled")
+	}
+	assert.True(t, view.isCancelled())
+	assert.False(t, view.paused, "cancelling releases paused connections")
+	assert.False(t, view.confirm("Overwrite?"), "no questions after cancelling")
+	assert.True(t, strings.HasPrefix(view.summary(), "Transfer cancelled."))
+}
+
+func TestTUIConfirm(t *testing.T) {
+	view := newTestTUI(false, 10)
+	answer := make(chan bool)
+	go func() {
+		answer <- view.confirm("Overwrite 'a'?")
+	}()
+	require.Eventually(t, func() bool {
+		view.mu.Lock()
+		defer view.mu.Unlock()
+		return view.question != ""
+	}, time.Second, 5*time.Millisecond)
+	view.handleKey(keySkip) // ignored while asking
+	view.handleKey(keyYes)
+	assert.True(t, <-answer)
+
+	go func() {
+		answer <- view.confirm("Overwrite 'a'?")
+	}()
+	require.Eventually(t, func() bool {
+		view.mu.Lock()
+		defer view.mu.Unlock()
+		return view.question != ""
+	}, time.Second, 5*time.Millisecond)
+	view.handleKey(keyInterrupt)
+	assert.False(t, <-answer, "cancelling answers a pending question")
+}
+
+func TestTUIRender(t *testing.T) {
+	view := newTestTUI(true, 1000, 3000, 2000)
+	view.peer, view.route = "203.0.113.7", "relay over QUIC"
+	view.startFile(0, 0)
+	view.addBytes(500)
+	view.samples = []int64{100, 200, 400}
+
+	lines := view.render(60, 14)
+	require.Len(t, lines, 14)
+	assert.Equal(t, "croc  Sending -> 203.0.113.7 (relay over QUIC)", lines[0])
+	assert.Contains(t, lines[1], " 8%")
+	assert.Contains(t, lines[1], "(0/3 files done)")
+	assert.Equal(t, "█", lines[2][len(lines[2])-len("█"):], "the latest sample is the peak")
+	assert.Contains(t, lines[7], "> sending  50%")
+	assert.Contains(t, lines[8], "  queued")
+	assert.Contains(t, lines[13], "q cancel")
+	for _, line := range lines {
+		assert.LessOrEqual(t, uniseg.StringWidth(line), 60)
+	}
+
+	// the list scrolls to keep the selection visible
+	view.selected = 2
+	lines = view.render(60, 9)
+	assert.Contains(t, strings.Join(lines, "\n"), "> queued")
+}
+
+func TestThroughputGraph(t *testing.T) {
+	graph := throughputGraph([]int64{0, 4, 8}, 5, 1)
+	assert.Equal(t, []string{"   ▄█"}, graph)
+
+	graph = throughputGraph([]int64{1, 2}, 3, 2)
+	assert.Equal(t, []string{"  █", " ██"}, graph)
+
+	graph = throughputGraph([]int64{1, 2, 3, 4}, 2, 1)
+	assert.Equal(t, []string{"▆█"}, graph)
+}
+
+func TestFitWidth(t *testing.T) {
+	assert.Equal(t, "short", fitWidth("short", 10))
+	assert.Equal(t, "trunc", fitWidth("truncated", 5))
+	assert.Equal(t, "日本", fitWidth("日本語", 5))
+	colored := fitWidth(termui.Color("colored text", termui.Green, true), 7)
+	assert.Equal(t, "colored", termui.Plain(colored))
+	assert.True(t, strings.HasSuffix(colored, termui.Reset))
+}
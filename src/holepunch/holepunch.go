@@ -0,0 +1,263 @@
+// Package holepunch opens direct TCP connections between two peers that may
+// both be behind NATs. Each peer listens and dials from the same local port,
+// so that the connection attempts of both sides open the path through their
+// NATs (simultaneous open), and asks the gateway for a port mapping with
+// NAT-PMP or UPnP where one is available.
+package holepunch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/schollz/logger"
+)
+
+const (
+	// dialTimeout bounds a single connection attempt to a candidate.
+	dialTimeout = time.Second
+	// retryInterval separates attempts to the same candidate. Early attempts
+	// are expected to fail until the peer's NAT has seen outgoing packets.
+	retryInterval = 200 * time.Millisecond
+)
+
+// ErrNoCandidates is returned by Punch when there is no usable address to try.
+var ErrNoCandidates = errors.New("no direct connection candidates")
+
+// Endpoint is a local TCP port that accepts and dials connections.
+type Endpoint struct {
+	listener *net.TCPListener
+	port     int
+
+	mu       sync.Mutex
+	unmap    []func()
+	closed   bool
+	closeErr error
+}
+
+// Listen opens an endpoint on a random port of every interface.
+func Listen() (*Endpoint, error) {
+	config := net.ListenConfig{Control: reusePort}
+	listener, err := config.Listen(context.Background(), "tcp", ":0")
+	if err != nil {
+		return nil, fmt.Errorf("listen for direct connections: %w", err)
+	}
+	return &Endpoint{
+		listener: listener.(*net.TCPListener),
+		port:     listener.Addr().(*net.TCPAddr).Port,
+	}, nil
+}
+
+// Port returns the local port used for every connection of the endpoint.
+func (e *Endpoint) Port() int {
+	return e.port
+}
+
+// Dial connects to address from the endpoint's port, so that the peer and
+// any NAT in between see the same source port as for the other connections.
+func (e *Endpoint) Dial(ctx context.Context, address string) (net.Conn, error) {
+	dialer := net.Dialer{
+		LocalAddr: &net.TCPAddr{Port: e.port},
+		Control:   reusePort,
+	}
+	return dialer.DialContext(ctx, "tcp", address)
+}
+
+// Map asks the gateway to forward the endpoint's port with NAT-PMP and UPnP
+// at the same time, and returns the external address of the first mapping
+// that succeeds. Mappings are removed by Close.
+func (e *Endpoint) Map(ctx context.Context) (string, error) {
+	type result struct {
+		external string
+		remove   func()
+		err      error
+	}
+	mappers := []func(context.Context, int) (string, func(), error){
+		mapNATPMP,
+		mapUPnP,
+	}
+	results := make(chan result, len(mappers))
+	for _, mapper := range mappers {
+		go func() {
+			external, remove, err := mapper(ctx, e.port)
+			results <- result{external: external, remove: remove, err: err}
+		}()
+	}
+	var external string
+	var errs []error
+	for range mappers {
+		r := <-results
+		if r.err != nil {
+			errs = append(errs, r.err)
+			continue
+		}
+		if !e.addMapping(r.remove) {
+			continue
+		}
+		if external == "" {
+			external = r.external
+		}
+	}
+	if external == "" {
+		return "", fmt.Errorf("no port mapping: %w", errors.Join(errs...))
+	}
+	return external, nil
+}
+
+// addMapping records how to remove a mapping, or removes it at once when the
+// endpoint has already been closed.
+func (e *Endpoint) addMapping(remove func()) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		go remove()
+		return false
+	}
+	e.unmap = append(e.unmap, remove)
+	return true
+}
+
+// Punch connects to the peer at one of remotes while accepting connections
+// from it. Each connection is passed to handshake, and the first connection
+// whose handshake succeeds is returned; the others are closed. The handshake
+// must give up when ctx is done.
+func (e *Endpoint) Punch(ctx context.Context, remotes []string, handshake func(context.Context, net.Conn) error) (net.Conn, error) {
+	if len(remotes) == 0 {
+		return nil, ErrNoCandidates
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	winner := make(chan net.Conn)
+	try := func(conn net.Conn) {
+		// abort a handshake that is still running when punching ends
+		stop := context.AfterFunc(ctx, func() {
+			_ = conn.SetDeadline(time.Now())
+		})
+		err := handshake(ctx, conn)
+		if !stop() || err != nil {
+			log.Tracef("direct handshake with %s failed: %v", conn.RemoteAddr(), err)
+			conn.Close()
+			return
+		}
+		select {
+		case winner <- conn:
+		case <-ctx.Done():
+			conn.Close()
+		}
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		e.accept(ctx, try)
+	}()
+	for _, remote := range remotes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e.dialUntilConnected(ctx, remote, try)
+		}()
+	}
+	select {
+	case conn := <-winner:
+		return conn, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("no direct connection: %w", ctx.Err())
+	}
+}
+
+func (e *Endpoint) accept(ctx context.Context, try func(net.Conn)) {
+	if err := e.listener.SetDeadline(time.Time{}); err != nil {
+		return
+	}
+	stop := context.AfterFunc(ctx, func() {
+		_ = e.listener.SetDeadline(time.Now())
+	})
+	defer stop()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := e.listener.Accept()
+		if err != nil {
+			return
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			try(conn)
+		}()
+	}
+}
+
+func (e *Endpoint) dialUntilConnected(ctx context.Context, remote string, try func(net.Conn)) {
+	for {
+		dialCtx, cancel := context.WithTimeout(ctx, dialTimeout)
+		conn, err := e.Dial(dialCtx, remote)
+		cancel()
+		if err == nil {
+			try(conn)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+// Close stops accepting connections and removes any port mapping. Connections
+// returned by Punch stay open.
+func (e *Endpoint) Close() error {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return e.closeErr
+	}
+	e.closed = true
+	unmap := e.unmap
+	e.unmap = nil
+	e.closeErr = e.listener.Close()
+	e.mu.Unlock()
+	for _, remove := range unmap {
+		remove()
+	}
+	return e.closeErr
+}
+
+// Candidates keeps the addresses in remotes that a peer may be reached at:
+// IP literals with a port, without loopback, unspecified or multicast
+// addresses and without duplicates.
+func Candidates(remotes []string) []string {
+	var candidates []string
+	seen := make(map[string]bool)
+	for _, remote := range remotes {
+		host, portText, err := net.SplitHostPort(remote)
+		if err != nil {
+			continue
+		}
+		port, err := strconv.Atoi(portText)
+		if err != nil || port < 1 || port > 65535 {
+			continue
+		}
+		ip := net.ParseIP(host)
+		if ip == nil || ip.IsLoopback() || ip.IsUnspecified() || ip.IsMulticast() {
+			continue
+		}
+		address := net.JoinHostPort(ip.String(), strconv.Itoa(port))
+		if !seen[address] {
+			seen[address] = true
+			candidates = append(candidates, address)
+		}
+	}
+	return candidates
+}
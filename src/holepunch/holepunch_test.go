@@ -0,0 +1,118 @@
+package holepunch
+
+import (
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPunchConnectsBothEndpoints(t *testing.T) {
+	left, err := Listen()
+	require.NoError(t, err)
+	defer left.Close()
+	right, err := Listen()
+	require.NoError(t, err)
+	defer right.Close()
+
+	// the left side names itself in the first byte; the right side accepts
+	// only the first connection on which it arrives
+	var chosen atomic.Bool
+	greet := func(_ context.Context, conn net.Conn) error {
+		_, err := conn.Write([]byte{'L'})
+		if err != nil {
+			return err
+		}
+		_, err = io.ReadFull(conn, make([]byte, 1))
+		return err
+	}
+	answer := func(_ context.Context, conn net.Conn) error {
+		greeting := make([]byte, 1)
+		if _, err := io.ReadFull(conn, greeting); err != nil {
+			return err
+		}
+		if greeting[0] != 'L' || !chosen.CompareAndSwap(false, true) {
+			return io.ErrUnexpectedEOF
+		}
+		_, err := conn.Write([]byte{'R'})
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	rightConn := make(chan net.Conn, 1)
+	go func() {
+		conn, err := right.Punch(ctx, []string{loopback(left.Port())}, answer)
+		assert.NoError(t, err)
+		rightConn <- conn
+	}()
+	leftConn, err := left.Punch(ctx, []string{loopback(right.Port())}, greet)
+	require.NoError(t, err)
+	defer leftConn.Close()
+	accepted := <-rightConn
+	require.NotNil(t, accepted)
+	defer accepted.Close()
+
+	_, err = leftConn.Write([]byte("direct"))
+	require.NoError(t, err)
+	received := make([]byte, 6)
+	_, err = io.ReadFull(accepted, received)
+	require.NoError(t, err)
+	assert.Equal(t, "direct", string(received))
+	assert.Equal(t, leftConn.LocalAddr().String(), accepted.RemoteAddr().String())
+}
+
+func TestPunchGivesUpWithoutPeer(t *testing.T) {
+	endpoint, err := Listen()
+	require.NoError(t, err)
+	defer endpoint.Close()
+	unused, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := unused.Addr().String()
+	require.NoError(t, unused.Close())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	_, err = endpoint.Punch(ctx, []string{address}, func(context.Context, net.Conn) error { return nil })
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	_, err = endpoint.Punch(context.Background(), nil, nil)
+	assert.ErrorIs(t, err, ErrNoCandidates)
+}
+
+func TestCandidates(t *testing.T) {
+	assert.Equal(t, []string{"203.0.113.7:4000", "[2001:db8::7]:4000", "192.168.1.7:4000"}, Candidates([]string{
+		"203.0.113.7:4000",
+		"[2001:db8::7]:4000",
+		"203.0.113.7:4000",
+		"192.168.1.7:4000",
+		"127.0.0.1:4000",
+		"[::1]:4000",
+		"0.0.0.0:4000",
+		"224.0.0.251:4000",
+		"example.com:4000",
+		"203.0.113.7:0",
+		"203.0.113.7:70000",
+		"203.0.113.7",
+	}))
+}
+
+func TestCloseRemovesMappings(t *testing.T) {
+	endpoint, err := Listen()
+	require.NoError(t, err)
+	removed := 0
+	assert.True(t, endpoint.addMapping(func() { removed++ }))
+	require.NoError(t, endpoint.Close())
+	require.NoError(t, endpoint.Close())
+	assert.Equal(t, 1, removed)
+}
+
+func loopback(port int) string {
+	return net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+}
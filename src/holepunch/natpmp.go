@@ -0,0 +1,165 @@
+package holepunch
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NAT-PMP (RFC 6886) constants.
+const (
+	natpmpPort            = 5351
+	natpmpOpExternal      = 0
+	natpmpOpMapTCP        = 2
+	natpmpResponseBit     = 128
+	natpmpInitialInterval = 250 * time.Millisecond
+	// mappingLifetime is the lease requested for a port mapping. A transfer
+	// only needs the mapping while the peers connect.
+	mappingLifetime = 120
+)
+
+func mapNATPMP(ctx context.Context, port int) (string, func(), error) {
+	gateway, err := defaultGateway()
+	if err != nil {
+		return "", nil, fmt.Errorf("nat-pmp: %w", err)
+	}
+	return natpmpMap(ctx, net.JoinHostPort(gateway.String(), strconv.Itoa(natpmpPort)), port)
+}
+
+// natpmpMap maps port on the NAT-PMP gateway at address and returns the
+// external address with a function that deletes the mapping.
+func natpmpMap(ctx context.Context, gateway string, port int) (string, func(), error) {
+	response, err := natpmpCall(ctx, gateway, []byte{0, natpmpOpExternal}, 12)
+	if err != nil {
+		return "", nil, fmt.Errorf("nat-pmp external address: %w", err)
+	}
+	externalIP := net.IP(response[8:12])
+
+	response, err = natpmpCall(ctx, gateway, natpmpMapRequest(port, port, mappingLifetime), 16)
+	if err != nil {
+		return "", nil, fmt.Errorf("nat-pmp mapping: %w", err)
+	}
+	externalPort := int(binary.BigEndian.Uint16(response[10:12]))
+	if externalPort == 0 {
+		return "", nil, errors.New("nat-pmp mapping: gateway returned no port")
+	}
+	remove := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		// a mapping is deleted by requesting it with a lifetime of zero
+		_, _ = natpmpCall(ctx, gateway, natpmpMapRequest(port, 0, 0), 16)
+	}
+	return net.JoinHostPort(externalIP.String(), strconv.Itoa(externalPort)), remove, nil
+}
+
+func natpmpMapRequest(internal, external int, lifetime uint32) []byte {
+	request := make([]byte, 12)
+	request[1] = natpmpOpMapTCP
+	binary.BigEndian.PutUint16(request[4:6], uint16(internal))
+	binary.BigEndian.PutUint16(request[6:8], uint16(external))
+	binary.BigEndian.PutUint32(request[8:12], lifetime)
+	return request
+}
+
+// natpmpCall sends request to the gateway, resending it with the backoff of
+// RFC 6886 until a response of the expected size arrives or ctx is done.
+func natpmpCall(ctx context.Context, gateway string, request []byte, size int) ([]byte, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp4", gateway)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	response := make([]byte, 16)
+	interval := natpmpInitialInterval
+	for {
+		if _, err = conn.Write(request); err != nil {
+			return nil, err
+		}
+		deadline := time.Now().Add(interval)
+		if err = conn.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+		for {
+			var n int
+			n, err = conn.Read(response)
+			if err != nil {
+				break
+			}
+			if n < size || response[0] != 0 || response[1] != request[1]|natpmpResponseBit {
+				continue
+			}
+			if code := binary.BigEndian.Uint16(response[2:4]); code != 0 {
+				return nil, fmt.Errorf("gateway result code %d", code)
+			}
+			return response[:size], nil
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		var netErr net.Error
+		if !errors.As(err, &netErr) || !netErr.Timeout() {
+			return nil, err
+		}
+		interval *= 2
+	}
+}
+
+// defaultGateway returns the IPv4 default gateway from the Linux routing
+// table, or guesses the first address of the local network elsewhere.
+func defaultGateway() (net.IP, error) {
+	if gateway, err := routeGateway("/proc/net/route"); err == nil {
+		return gateway, nil
+	}
+	conn, err := net.Dial("udp4", "192.0.2.1:9")
+	if err != nil {
+		return nil, errors.New("no default gateway")
+	}
+	defer conn.Close()
+	local := conn.LocalAddr().(*net.UDPAddr).IP.To4()
+	if local == nil || !local.IsPrivate() {
+		return nil, errors.New("no default gateway")
+	}
+	return net.IPv4(local[0], local[1], local[2], 1), nil
+}
+
+func routeGateway(path string) (net.IP, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+		gateway, err := hex.DecodeString(fields[2])
+		if err != nil || len(gateway) != 4 {
+			continue
+		}
+		// the routing table holds addresses in host (little-endian) order
+		ip := net.IPv4(gateway[3], gateway[2], gateway[1], gateway[0])
+		if !ip.IsUnspecified() {
+			return ip, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, errors.New("no default route")
+}
@@ -0,0 +1,103 @@
+package holepunch
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeNATPMP answers NAT-PMP requests like a gateway with the external
+// address 203.0.113.9 that maps every port to port+1. The first request is
+// dropped to exercise retransmission.
+func fakeNATPMP(t *testing.T) (string, <-chan []byte) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	requests := make(chan []byte, 10)
+	go func() {
+		buffer := make([]byte, 64)
+		dropped := false
+		for {
+			n, source, err := conn.ReadFromUDP(buffer)
+			if err != nil {
+				return
+			}
+			if !dropped {
+				dropped = true
+				continue
+			}
+			request := append([]byte(nil), buffer[:n]...)
+			requests <- request
+			var response []byte
+			switch request[1] {
+			case natpmpOpExternal:
+				response = make([]byte, 12)
+				copy(response[8:], net.IPv4(203, 0, 113, 9).To4())
+			case natpmpOpMapTCP:
+				response = make([]byte, 16)
+				copy(response[8:10], request[4:6])
+				external := binary.BigEndian.Uint16(request[6:8])
+				if external != 0 {
+					external++
+				}
+				binary.BigEndian.PutUint16(response[10:12], external)
+				copy(response[12:16], request[8:12])
+			default:
+				continue
+			}
+			response[1] = request[1] | natpmpResponseBit
+			_, _ = conn.WriteToUDP(response, source)
+		}
+	}()
+	return conn.LocalAddr().String(), requests
+}
+
+func TestNATPMPMapsAndRemovesPort(t *testing.T) {
+	gateway, requests := fakeNATPMP(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	external, remove, err := natpmpMap(ctx, gateway, 4242)
+	require.NoError(t, err)
+	assert.Equal(t, "203.0.113.9:4243", external)
+	<-requests
+	mapping := <-requests
+	assert.Equal(t, uint16(4242), binary.BigEndian.Uint16(mapping[4:6]))
+	assert.Equal(t, uint32(mappingLifetime), binary.BigEndian.Uint32(mapping[8:12]))
+
+	remove()
+	deletion := <-requests
+	assert.Equal(t, byte(natpmpOpMapTCP), deletion[1])
+	assert.Zero(t, binary.BigEndian.Uint32(deletion[8:12]))
+}
+
+func TestNATPMPGivesUpWithoutGateway(t *testing.T) {
+	silent, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer silent.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, _, err = natpmpMap(ctx, silent.LocalAddr().String(), 4242)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestRouteGateway(t *testing.T) {
+	routes := filepath.Join(t.TempDir(), "route")
+	require.NoError(t, os.WriteFile(routes, []byte(
+		"Iface\tDestination\tGateway \tFlags\tRefCnt\tUse\tMetric\tMask\n"+
+			"eth0\t0001A8C0\t00000000\t0001\t0\t0\t0\t00FFFFFF\n"+
+			"eth0\t00000000\t0101A8C0\t0003\t0\t0\t0\t00000000\n"), 0o600))
+	gateway, err := routeGateway(routes)
+	require.NoError(t, err)
+	assert.Equal(t, "192.168.1.1", gateway.String())
+
+	require.NoError(t, os.WriteFile(routes, []byte("Iface\tDestination\tGateway\n"), 0o600))
+	_, err = routeGateway(routes)
+	assert.Error(t, err)
+}
@@ -0,0 +1,12 @@
+//go:build plan9 || js || wasip1
+
+package holepunch
+
+import (
+	"errors"
+	"syscall"
+)
+
+func reusePort(_, _ string, _ syscall.RawConn) error {
+	return errors.New("hole punching is not supported on this platform")
+}
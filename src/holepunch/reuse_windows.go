@@ -0,0 +1,22 @@
+//go:build windows
+
+package holepunch
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// reusePort lets the listener and every outgoing connection share one local
+// port.
+func reusePort(_, _ string, raw syscall.RawConn) error {
+	var optErr error
+	err := raw.Control(func(fd uintptr) {
+		optErr = windows.SetsockoptInt(windows.Handle(fd), windows.SOL_SOCKET, windows.SO_REUSEADDR, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return optErr
+}
@@ -0,0 +1,352 @@
+package holepunch
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ssdpAddress = "239.255.255.250:1900"
+	// maxUPnPResponse bounds device descriptions and SOAP responses.
+	maxUPnPResponse = 1 << 20
+)
+
+var upnpDeviceTypes = []string{
+	"urn:schemas-upnp-org:device:InternetGatewayDevice:2",
+	"urn:schemas-upnp-org:device:InternetGatewayDevice:1",
+}
+
+var upnpServiceTypes = []string{
+	"urn:schemas-upnp-org:service:WANIPConnection:",
+	"urn:schemas-upnp-org:service:WANPPPConnection:",
+}
+
+// upnpGateway is the WAN connection service of an internet gateway device.
+type upnpGateway struct {
+	controlURL  string
+	serviceType string
+	client      *http.Client
+}
+
+func mapUPnP(ctx context.Context, port int) (string, func(), error) {
+	location, err := ssdpSearch(ctx)
+	if err != nil {
+		return "", nil, fmt.Errorf("upnp: %w", err)
+	}
+	gateway, err := upnpDiscover(ctx, location)
+	if err != nil {
+		return "", nil, fmt.Errorf("upnp: %w", err)
+	}
+	return gateway.mapPort(ctx, port)
+}
+
+// ssdpSearch asks the local network for an internet gateway device and
+// returns the location of its description.
+func ssdpSearch(ctx context.Context) (string, error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	group, err := net.ResolveUDPAddr("udp4", ssdpAddress)
+	if err != nil {
+		return "", err
+	}
+	for _, deviceType := range upnpDeviceTypes {
+		search := "M-SEARCH * HTTP/1.1\r\n" +
+			"HOST: " + ssdpAddress + "\r\n" +
+			"ST: " + deviceType + "\r\n" +
+			"MAN: \"ssdp:discover\"\r\n" +
+			"MX: 1\r\n\r\n"
+		if _, err = conn.WriteTo([]byte(search), group); err != nil {
+			return "", err
+		}
+	}
+	buffer := make([]byte, 2048)
+	for {
+		n, source, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return "", fmt.Errorf("no gateway answered: %w", ctxErr)
+			}
+			return "", err
+		}
+		if location, ok := parseSSDPResponse(buffer[:n], source.IP); ok {
+			return location, nil
+		}
+	}
+}
+
+// parseSSDPResponse returns the description location of a gateway's search
+// response. Only locations served by the responding host are accepted, so
+// that a spoofed response cannot point croc at another machine.
+func parseSSDPResponse(response []byte, source net.IP) (string, bool) {
+	reader := textproto.NewReader(bufio.NewReader(bytes.NewReader(response)))
+	status, err := reader.ReadLine()
+	if err != nil || !strings.HasPrefix(status, "HTTP/1.1 200") {
+		return "", false
+	}
+	header, err := reader.ReadMIMEHeader()
+	if err != nil && len(header) == 0 {
+		return "", false
+	}
+	if !isGatewaySearchTarget(header.Get("ST")) {
+		return "", false
+	}
+	location, err := url.Parse(header.Get("Location"))
+	if err != nil || location.Scheme != "http" {
+		return "", false
+	}
+	host := net.ParseIP(location.Hostname())
+	if host == nil || !host.Equal(source) {
+		return "", false
+	}
+	return location.String(), true
+}
+
+func isGatewaySearchTarget(target string) bool {
+	for _, deviceType := range upnpDeviceTypes {
+		if target == deviceType {
+			return true
+		}
+	}
+	return false
+}
+
+type upnpDescription struct {
+	URLBase string     `xml:"URLBase"`
+	Device  upnpDevice `xml:"device"`
+}
+
+type upnpDevice struct {
+	Services []upnpService `xml:"serviceList>service"`
+	Devices  []upnpDevice  `xml:"deviceList>device"`
+}
+
+type upnpService struct {
+	ServiceType string `xml:"serviceType"`
+	ControlURL  string `xml:"controlURL"`
+}
+
+// findService searches the device tree for a WAN connection service.
+func (d upnpDevice) findService() (upnpService, bool) {
+	for _, service := range d.Services {
+		for _, serviceType := range upnpServiceTypes {
+			if strings.HasPrefix(service.ServiceType, serviceType) && service.ControlURL != "" {
+				return service, true
+			}
+		}
+	}
+	for _, device := range d.Devices {
+		if service, ok := device.findService(); ok {
+			return service, true
+		}
+	}
+	return upnpService{}, false
+}
+
+// upnpDiscover reads the device description at location and returns its WAN
+// connection service.
+func upnpDiscover(ctx context.Context, location string) (*upnpGateway, error) {
+	client := &http.Client{
+		// the gateway was found on the local network and must stay there
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("device description returned %s", response.Status)
+	}
+	var description upnpDescription
+	if err = xml.NewDecoder(io.LimitReader(response.Body, maxUPnPResponse)).Decode(&description); err != nil {
+		return nil, fmt.Errorf("device description: %w", err)
+	}
+	service, ok := description.Device.findService()
+	if !ok {
+		return nil, errors.New("gateway has no WAN connection service")
+	}
+	base, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	if description.URLBase != "" {
+		if urlBase, err := url.Parse(description.URLBase); err == nil && urlBase.Host == base.Host {
+			base = urlBase
+		}
+	}
+	controlURL, err := base.Parse(service.ControlURL)
+	if err != nil || controlURL.Host != base.Host {
+		return nil, errors.New("gateway control URL is not on the gateway")
+	}
+	return &upnpGateway{
+		controlURL:  controlURL.String(),
+		serviceType: service.ServiceType,
+		client:      client,
+	}, nil
+}
+
+func (g *upnpGateway) mapPort(ctx context.Context, port int) (string, func(), error) {
+	control, err := url.Parse(g.controlURL)
+	if err != nil {
+		return "", nil, err
+	}
+	internalClient, err := localAddressFor(control.Host)
+	if err != nil {
+		return "", nil, fmt.Errorf("upnp: %w", err)
+	}
+	portText := strconv.Itoa(port)
+	mapping := [][2]string{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", portText},
+		{"NewProtocol", "TCP"},
+		{"NewInternalPort", portText},
+		{"NewInternalClient", internalClient},
+		{"NewEnabled", "1"},
+		{"NewPortMappingDescription", "croc"},
+		{"NewLeaseDuration", strconv.Itoa(mappingLifetime)},
+	}
+	if _, err = g.call(ctx, "AddPortMapping", mapping); err != nil {
+		// some gateways only support permanent leases (error 725)
+		var soapErr *upnpError
+		if !errors.As(err, &soapErr) || soapErr.code != "725" {
+			return "", nil, err
+		}
+		mapping[len(mapping)-1][1] = "0"
+		if _, err = g.call(ctx, "AddPortMapping", mapping); err != nil {
+			return "", nil, err
+		}
+	}
+	remove := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_, _ = g.call(ctx, "DeletePortMapping", [][2]string{
+			{"NewRemoteHost", ""},
+			{"NewExternalPort", portText},
+			{"NewProtocol", "TCP"},
+		})
+	}
+	values, err := g.call(ctx, "GetExternalIPAddress", nil)
+	if err != nil {
+		remove()
+		return "", nil, err
+	}
+	externalIP := net.ParseIP(values["NewExternalIPAddress"])
+	if externalIP == nil || externalIP.IsUnspecified() {
+		remove()
+		return "", nil, errors.New("upnp: gateway has no external address")
+	}
+	return net.JoinHostPort(externalIP.String(), portText), remove, nil
+}
+
+// upnpError is a SOAP fault returned by a gateway.
+type upnpError struct {
+	action      string
+	code        string
+	description string
+}
+
+func (e *upnpError) Error() string {
+	return fmt.Sprintf("upnp %s failed: %s %s", e.action, e.code, e.description)
+}
+
+// call invokes a SOAP action of the gateway's WAN connection service and
+// returns the text of the response's elements by name.
+func (g *upnpGateway) call(ctx context.Context, action string, arguments [][2]string) (map[string]string, error) {
+	var body strings.Builder
+	body.WriteString(`<?xml version="1.0"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body><u:` + action + ` xmlns:u="` + html.EscapeString(g.serviceType) + `">`)
+	for _, argument := range arguments {
+		body.WriteString("<" + argument[0] + ">" + html.EscapeString(argument[1]) + "</" + argument[0] + ">")
+	}
+	body.WriteString(`</u:` + action + `></s:Body></s:Envelope>`)
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, g.controlURL, strings.NewReader(body.String()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	request.Header.Set("SOAPAction", `"`+g.serviceType+"#"+action+`"`)
+	response, err := g.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	values, err := soapValues(io.LimitReader(response.Body, maxUPnPResponse))
+	if err != nil {
+		return nil, fmt.Errorf("upnp %s: %w", action, err)
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, &upnpError{action: action, code: values["errorCode"], description: values["errorDescription"]}
+	}
+	return values, nil
+}
+
+// soapValues collects the text of every element without children.
+func soapValues(r io.Reader) (map[string]string, error) {
+	values := make(map[string]string)
+	decoder := xml.NewDecoder(r)
+	var name string
+	var text strings.Builder
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			return values, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			name = token.Name.Local
+			text.Reset()
+		case xml.CharData:
+			text.Write(token)
+		case xml.EndElement:
+			if name == token.Name.Local {
+				values[name] = strings.TrimSpace(text.String())
+			}
+			name = ""
+		}
+	}
+}
+
+// localAddressFor returns the local IP address used to reach host.
+func localAddressFor(host string) (string, error) {
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "80")
+	}
+	conn, err := net.Dial("udp4", host)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String(), nil
+}
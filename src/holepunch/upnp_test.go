@@ -0,0 +1,125 @@
+package holepunch
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testDescription = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <device>
+    <deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+    <deviceList>
+      <device>
+        <deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+        <deviceList>
+          <device>
+            <serviceList>
+              <service>
+                <serviceType>urn:schemas-upnp-org:service:WANCommonInterfaceConfig:1</serviceType>
+                <controlURL>/common</controlURL>
+              </service>
+              <service>
+                <serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType>
+                <controlURL>/ctl/IPConn</controlURL>
+              </service>
+            </serviceList>
+          </device>
+        </deviceList>
+      </device>
+    </deviceList>
+  </device>
+</root>`
+
+func fakeGateway(t *testing.T, permanentOnly bool) (*httptest.Server, *[]string) {
+	var mu sync.Mutex
+	var actions []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Path == "/rootDesc.xml" {
+			_, _ = io.WriteString(w, testDescription)
+			return
+		}
+		if r.Method != http.MethodPost || r.URL.Path != "/ctl/IPConn" {
+			http.NotFound(w, r)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		action := strings.Trim(r.Header.Get("SOAPAction"), `"`)
+		action = strings.TrimPrefix(action, "urn:schemas-upnp-org:service:WANIPConnection:1#")
+		mu.Lock()
+		actions = append(actions, action)
+		mu.Unlock()
+		switch {
+		case action == "AddPortMapping" && permanentOnly && !strings.Contains(string(body), "<NewLeaseDuration>0<"):
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = io.WriteString(w, `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><s:Fault><detail><UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>725</errorCode><errorDescription>OnlyPermanentLeasesSupported</errorDescription></UPnPError></detail></s:Fault></s:Body></s:Envelope>`)
+		case action == "AddPortMapping":
+			assert.Contains(t, string(body), "<NewInternalClient>127.0.0.1</NewInternalClient>")
+			assert.Contains(t, string(body), "<NewInternalPort>4242</NewInternalPort>")
+			_, _ = io.WriteString(w, `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><u:AddPortMappingResponse xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1"/></s:Body></s:Envelope>`)
+		case action == "GetExternalIPAddress":
+			_, _ = io.WriteString(w, `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><u:GetExternalIPAddressResponse xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1"><NewExternalIPAddress>198.51.100.4</NewExternalIPAddress></u:GetExternalIPAddressResponse></s:Body></s:Envelope>`)
+		default:
+			_, _ = io.WriteString(w, `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body/></s:Envelope>`)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &actions
+}
+
+func TestUPnPMapsAndRemovesPort(t *testing.T) {
+	for _, permanentOnly := range []bool{false, true} {
+		server, actions := fakeGateway(t, permanentOnly)
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		gateway, err := upnpDiscover(ctx, server.URL+"/rootDesc.xml")
+		require.NoError(t, err)
+		assert.Equal(t, server.URL+"/ctl/IPConn", gateway.controlURL)
+
+		external, remove, err := gateway.mapPort(ctx, 4242)
+		cancel()
+		require.NoError(t, err)
+		assert.Equal(t, "198.51.100.4:4242", external)
+		remove()
+		expected := []string{"AddPortMapping", "GetExternalIPAddress", "DeletePortMapping"}
+		if permanentOnly {
+			expected = append([]string{"AddPortMapping"}, expected...)
+		}
+		assert.Equal(t, expected, *actions)
+	}
+}
+
+func TestUPnPRejectsDescriptionsWithoutWANService(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, `<root><device><serviceList><service><serviceType>urn:schemas-upnp-org:service:Layer3Forwarding:1</serviceType><controlURL>/l3f</controlURL></service></serviceList></device></root>`)
+	}))
+	defer server.Close()
+	_, err := upnpDiscover(context.Background(), server.URL)
+	assert.EqualError(t, err, "gateway has no WAN connection service")
+}
+
+func TestParseSSDPResponse(t *testing.T) {
+	response := "HTTP/1.1 200 OK\r\n" +
+		"CACHE-CONTROL: max-age=120\r\n" +
+		"ST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n" +
+		"LOCATION: http://192.168.1.1:5000/rootDesc.xml\r\n\r\n"
+	location, ok := parseSSDPResponse([]byte(response), net.ParseIP("192.168.1.1"))
+	assert.True(t, ok)
+	assert.Equal(t, "http://192.168.1.1:5000/rootDesc.xml", location)
+
+	_, ok = parseSSDPResponse([]byte(response), net.ParseIP("192.168.1.50"))
+	assert.False(t, ok)
+	_, ok = parseSSDPResponse([]byte(strings.Replace(response, "InternetGatewayDevice", "MediaServer", 1)), net.ParseIP("192.168.1.1"))
+	assert.False(t, ok)
+	_, ok = parseSSDPResponse([]byte("NOTIFY * HTTP/1.1\r\n\r\n"), net.ParseIP("192.168.1.1"))
+	assert.False(t, ok)
+}
@@ -0,0 +1,437 @@
+// Package mdns advertises and browses croc senders with multicast DNS service
+// discovery (RFC 6762 and RFC 6763). It implements only the records croc needs:
+// a PTR record for the service type, SRV and TXT records for the instance, and
+// A and AAAA records for the instance's host.
+package mdns
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// ServiceName is the DNS-SD service type advertised by croc senders.
+const ServiceName = "_croc._tcp.local."
+
+const (
+	mdnsPort = 5353
+	// recordTTL is the RFC 6762 recommendation for records naming a host.
+	recordTTL = 120
+	// cacheFlush marks records that belong to one responder only.
+	cacheFlush = dnsmessage.Class(1 << 15)
+
+	maxPacketSize        = 9000
+	maxAnnounceInterval  = 8 * time.Second
+	queryInterval        = 100 * time.Millisecond
+	defaultBrowseTimeout = time.Second
+)
+
+var groups = []struct {
+	network string
+	address *net.UDPAddr
+}{
+	{"udp4", &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: mdnsPort}},
+	{"udp6", &net.UDPAddr{IP: net.ParseIP("ff02::fb"), Port: mdnsPort}},
+}
+
+// Advertisement describes a service instance published by Advertise.
+type Advertisement struct {
+	// Instance is the instance label, such as "croc-1a2b3c4d". It is also
+	// used as the host label, so it should not identify the machine.
+	Instance string
+	Port     int
+	Text     []string
+	// TimeLimit stops the advertisement after the given duration. A zero or
+	// negative value advertises until StopChan is closed.
+	TimeLimit time.Duration
+	StopChan  chan struct{}
+}
+
+// Query configures Browse.
+type Query struct {
+	// Match selects instances by their TXT strings; nil accepts every instance.
+	Match func(text []string) bool
+	// Limit stops browsing after this many matching instances; zero or
+	// negative browses until the time limit.
+	Limit int
+	// TimeLimit bounds the browse; zero selects one second.
+	TimeLimit time.Duration
+	StopChan  chan struct{}
+}
+
+// Entry is a service instance found by Browse. Address is the source address
+// of the response, which is reachable from the browsing host.
+type Entry struct {
+	Instance string
+	Address  string
+	Port     int
+	Text     []string
+}
+
+type endpoint struct {
+	conn  *net.UDPConn
+	group *net.UDPAddr
+}
+
+type packet struct {
+	data   []byte
+	source *net.UDPAddr
+}
+
+// listen joins the IPv4 and IPv6 mDNS groups. It succeeds if either group
+// can be joined.
+func listen() ([]endpoint, error) {
+	var endpoints []endpoint
+	var errs []error
+	for _, group := range groups {
+		conn, err := net.ListenMulticastUDP(group.network, nil, group.address)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		endpoints = append(endpoints, endpoint{conn: conn, group: group.address})
+	}
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("could not join an mDNS group: %w", errors.Join(errs...))
+	}
+	return endpoints, nil
+}
+
+func receive(endpoints []endpoint, done <-chan struct{}) <-chan packet {
+	packets := make(chan packet, 16)
+	for _, e := range endpoints {
+		go func(conn *net.UDPConn) {
+			buffer := make([]byte, maxPacketSize)
+			for {
+				n, source, err := conn.ReadFromUDP(buffer)
+				if err != nil {
+					return
+				}
+				select {
+				case packets <- packet{data: bytes.Clone(buffer[:n]), source: source}:
+				case <-done:
+					return
+				}
+			}
+		}(e.conn)
+	}
+	return packets
+}
+
+func send(endpoints []endpoint, message []byte) {
+	for _, e := range endpoints {
+		// A group without a route on this host is not an error for the
+		// other group.
+		_, _ = e.conn.WriteToUDP(message, e.group)
+	}
+}
+
+func closeAll(endpoints []endpoint) {
+	for _, e := range endpoints {
+		e.conn.Close()
+	}
+}
+
+func (ad Advertisement) validate() error {
+	if ad.Instance == "" || len(ad.Instance) > 63 || strings.Contains(ad.Instance, ".") {
+		return errors.New("mDNS instance must be a single DNS label")
+	}
+	if ad.Port < 1 || ad.Port > 65535 {
+		return fmt.Errorf("invalid mDNS port %d", ad.Port)
+	}
+	for _, text := range ad.Text {
+		if len(text) > 255 {
+			return errors.New("mDNS TXT strings must be at most 255 bytes")
+		}
+	}
+	return nil
+}
+
+func (ad Advertisement) instanceName() string {
+	return ad.Instance + "." + ServiceName
+}
+
+// Advertise publishes the instance until the time limit passes or StopChan is
+// closed. It announces itself with increasing intervals and answers queries
+// for the service type or the instance.
+func Advertise(ad Advertisement) error {
+	if err := ad.validate(); err != nil {
+		return err
+	}
+	ips := localIPs()
+	response, err := ad.response(ips, recordTTL)
+	if err != nil {
+		return err
+	}
+	goodbye, err := ad.response(ips, 0)
+	if err != nil {
+		return err
+	}
+	endpoints, err := listen()
+	if err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		closeAll(endpoints)
+	}()
+	packets := receive(endpoints, done)
+
+	var deadline <-chan time.Time
+	if ad.TimeLimit > 0 {
+		timer := time.NewTimer(ad.TimeLimit)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	announce := time.NewTimer(0)
+	defer announce.Stop()
+	interval := time.Second
+	for {
+		select {
+		case <-ad.StopChan:
+			send(endpoints, goodbye)
+			return nil
+		case <-deadline:
+			send(endpoints, goodbye)
+			return nil
+		case <-announce.C:
+			send(endpoints, response)
+			announce.Reset(interval)
+			interval = min(2*interval, maxAnnounceInterval)
+		case p := <-packets:
+			if ad.answers(p.data) {
+				send(endpoints, response)
+			}
+		}
+	}
+}
+
+// answers reports whether data is a query that the advertisement answers.
+func (ad Advertisement) answers(data []byte) bool {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(data)
+	if err != nil || header.Response {
+		return false
+	}
+	questions, err := parser.AllQuestions()
+	if err != nil {
+		return false
+	}
+	for _, question := range questions {
+		name := strings.ToLower(question.Name.String())
+		switch {
+		case name == ServiceName &&
+			(question.Type == dnsmessage.TypePTR || question.Type == dnsmessage.TypeALL):
+			return true
+		case name == strings.ToLower(ad.instanceName()):
+			return true
+		}
+	}
+	return false
+}
+
+// response builds an announcement; a ttl of zero withdraws the records.
+func (ad Advertisement) response(ips []net.IP, ttl uint32) ([]byte, error) {
+	serviceName, err := dnsmessage.NewName(ServiceName)
+	if err != nil {
+		return nil, err
+	}
+	instanceName, err := dnsmessage.NewName(ad.instanceName())
+	if err != nil {
+		return nil, err
+	}
+	hostName, err := dnsmessage.NewName(ad.Instance + ".local.")
+	if err != nil {
+		return nil, err
+	}
+	text := ad.Text
+	if len(text) == 0 {
+		text = []string{""}
+	}
+	shared := func(name dnsmessage.Name) dnsmessage.ResourceHeader {
+		return dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET, TTL: ttl}
+	}
+	unique := func(name dnsmessage.Name) dnsmessage.ResourceHeader {
+		return dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET | cacheFlush, TTL: ttl}
+	}
+
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{Response: true, Authoritative: true})
+	builder.EnableCompression()
+	if err = builder.StartAnswers(); err != nil {
+		return nil, err
+	}
+	if err = builder.PTRResource(shared(serviceName), dnsmessage.PTRResource{PTR: instanceName}); err != nil {
+		return nil, err
+	}
+	if err = builder.StartAdditionals(); err != nil {
+		return nil, err
+	}
+	if err = builder.SRVResource(unique(instanceName), dnsmessage.SRVResource{
+		Target: hostName,
+		Port:   uint16(ad.Port),
+	}); err != nil {
+		return nil, err
+	}
+	if err = builder.TXTResource(unique(instanceName), dnsmessage.TXTResource{TXT: text}); err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		if ip4 := ip.To4(); ip4 != nil {
+			err = builder.AResource(unique(hostName), dnsmessage.AResource{A: [4]byte(ip4)})
+		} else {
+			err = builder.AAAAResource(unique(hostName), dnsmessage.AAAAResource{AAAA: [16]byte(ip.To16())})
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return builder.Finish()
+}
+
+// localIPs lists the addresses published for the instance's host. Loopback
+// and link-local addresses are left out; browsers use the response's source
+// address in any case.
+func localIPs() (ips []net.IP) {
+	addresses, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	for _, address := range addresses {
+		prefix, ok := address.(*net.IPNet)
+		if !ok || prefix.IP.IsLoopback() || prefix.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		ips = append(ips, prefix.IP)
+	}
+	return ips
+}
+
+// Browse queries for croc instances and returns those accepted by Match.
+func Browse(query Query) ([]Entry, error) {
+	message, err := browseQuestion()
+	if err != nil {
+		return nil, err
+	}
+	endpoints, err := listen()
+	if err != nil {
+		return nil, err
+	}
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		closeAll(endpoints)
+	}()
+	packets := receive(endpoints, done)
+
+	timeLimit := query.TimeLimit
+	if timeLimit <= 0 {
+		timeLimit = defaultBrowseTimeout
+	}
+	deadline := time.NewTimer(timeLimit)
+	defer deadline.Stop()
+	ticker := time.NewTicker(queryInterval)
+	defer ticker.Stop()
+	send(endpoints, message)
+
+	results := newCollector(query.Match)
+	for {
+		select {
+		case <-query.StopChan:
+			return results.found, nil
+		case <-deadline.C:
+			return results.found, nil
+		case <-ticker.C:
+			send(endpoints, message)
+		case p := <-packets:
+			results.add(p.data, p.source)
+			if query.Limit > 0 && len(results.found) >= query.Limit {
+				return results.found[:query.Limit], nil
+			}
+		}
+	}
+}
+
+func browseQuestion() ([]byte, error) {
+	serviceName, err := dnsmessage.NewName(ServiceName)
+	if err != nil {
+		return nil, err
+	}
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{})
+	if err = builder.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err = builder.Question(dnsmessage.Question{
+		Name:  serviceName,
+		Type:  dnsmessage.TypePTR,
+		Class: dnsmessage.ClassINET,
+	}); err != nil {
+		return nil, err
+	}
+	return builder.Finish()
+}
+
+// collector assembles instances from responses. SRV and TXT records may
+// arrive in different packets, so partial instances are kept until both are
+// known.
+type collector struct {
+	match    func([]string) bool
+	partial  map[string]*Entry
+	reported map[string]bool
+	found    []Entry
+}
+
+func newCollector(match func([]string) bool) *collector {
+	return &collector{
+		match:    match,
+		partial:  make(map[string]*Entry),
+		reported: make(map[string]bool),
+	}
+}
+
+func (c *collector) add(data []byte, source *net.UDPAddr) {
+	var message dnsmessage.Message
+	if err := message.Unpack(data); err != nil || !message.Header.Response {
+		return
+	}
+	address := source.IP.String()
+	if source.Zone != "" {
+		address += "%" + source.Zone
+	}
+	records := append(append(message.Answers, message.Authorities...), message.Additionals...)
+	for _, record := range records {
+		name := strings.ToLower(record.Header.Name.String())
+		instance, ok := strings.CutSuffix(name, "."+ServiceName)
+		if !ok || instance == "" || strings.Contains(instance, ".") || record.Header.TTL == 0 {
+			continue
+		}
+		// Each responder is keyed by its source, so that one host cannot
+		// complete another host's instance.
+		key := address + " " + instance
+		entry := c.partial[key]
+		if entry == nil {
+			entry = &Entry{Instance: instance, Address: address}
+			c.partial[key] = entry
+		}
+		switch body := record.Body.(type) {
+		case *dnsmessage.SRVResource:
+			entry.Port = int(body.Port)
+		case *dnsmessage.TXTResource:
+			entry.Text = append([]string(nil), body.TXT...)
+		}
+	}
+	for key, entry := range c.partial {
+		if c.reported[key] || entry.Port == 0 || entry.Text == nil {
+			continue
+		}
+		c.reported[key] = true
+		if c.match == nil || c.match(entry.Text) {
+			c.found = append(c.found, *entry)
+		}
+	}
+}
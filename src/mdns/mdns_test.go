@@ -0,0 +1,125 @@
+package mdns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestResponseIsCollectedFromSource(t *testing.T) {
+	ad := Advertisement{Instance: "croc-0a1b2c3d", Port: 9009, Text: []string{"v=1", "room=abc"}}
+	response, err := ad.response([]net.IP{net.ParseIP("192.168.1.20"), net.ParseIP("2001:db8::20")}, recordTTL)
+	require.NoError(t, err)
+
+	results := newCollector(func(text []string) bool { return len(text) == 2 && text[1] == "room=abc" })
+	results.add(response, &net.UDPAddr{IP: net.ParseIP("192.168.1.20"), Port: mdnsPort})
+	results.add(response, &net.UDPAddr{IP: net.ParseIP("192.168.1.20"), Port: mdnsPort})
+	require.Len(t, results.found, 1)
+	assert.Equal(t, Entry{
+		Instance: "croc-0a1b2c3d",
+		Address:  "192.168.1.20",
+		Port:     9009,
+		Text:     []string{"v=1", "room=abc"},
+	}, results.found[0])
+
+	results.add(response, &net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: mdnsPort, Zone: "eth0"})
+	require.Len(t, results.found, 2)
+	assert.Equal(t, "fe80::1%eth0", results.found[1].Address)
+}
+
+func TestCollectorSkipsMismatchesAndGoodbyes(t *testing.T) {
+	ad := Advertisement{Instance: "croc-0a1b2c3d", Port: 9009, Text: []string{"room=other"}}
+	source := &net.UDPAddr{IP: net.ParseIP("10.0.0.2"), Port: mdnsPort}
+
+	goodbye, err := ad.response(nil, 0)
+	require.NoError(t, err)
+	results := newCollector(nil)
+	results.add(goodbye, source)
+	assert.Empty(t, results.found)
+
+	response, err := ad.response(nil, recordTTL)
+	require.NoError(t, err)
+	results = newCollector(func(text []string) bool { return text[0] == "room=abc" })
+	results.add(response, source)
+	assert.Empty(t, results.found)
+
+	question, err := browseQuestion()
+	require.NoError(t, err)
+	results.add(question, source)
+	assert.Empty(t, results.found)
+}
+
+func TestAdvertisementAnswersServiceAndInstanceQueries(t *testing.T) {
+	ad := Advertisement{Instance: "croc-0a1b2c3d", Port: 9009}
+	question, err := browseQuestion()
+	require.NoError(t, err)
+	assert.True(t, ad.answers(question))
+
+	query := func(name string, kind dnsmessage.Type) []byte {
+		builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{})
+		require.NoError(t, builder.StartQuestions())
+		require.NoError(t, builder.Question(dnsmessage.Question{
+			Name:  dnsmessage.MustNewName(name),
+			Type:  kind,
+			Class: dnsmessage.ClassINET,
+		}))
+		message, err := builder.Finish()
+		require.NoError(t, err)
+		return message
+	}
+	assert.True(t, ad.answers(query("CROC-0A1B2C3D._croc._tcp.local.", dnsmessage.TypeSRV)))
+	assert.False(t, ad.answers(query("_http._tcp.local.", dnsmessage.TypePTR)))
+	assert.False(t, ad.answers(query("_croc._tcp.local.", dnsmessage.TypeA)))
+
+	response, err := ad.response(nil, recordTTL)
+	require.NoError(t, err)
+	assert.False(t, ad.answers(response))
+}
+
+func TestAdvertisementValidation(t *testing.T) {
+	for _, ad := range []Advertisement{
+		{Instance: "", Port: 9009},
+		{Instance: "croc.host", Port: 9009},
+		{Instance: "croc", Port: 0},
+		{Instance: "croc", Port: 70000},
+		{Instance: "croc", Port: 9009, Text: []string{string(make([]byte, 256))}},
+	} {
+		assert.Error(t, Advertise(ad))
+	}
+}
+
+func TestAdvertiseAndBrowse(t *testing.T) {
+	endpoints, err := listen()
+	if err != nil {
+		t.Skipf("mDNS groups are unavailable: %v", err)
+	}
+	closeAll(endpoints)
+
+	stop := make(chan struct{})
+	advertised := make(chan error, 1)
+	go func() {
+		advertised <- Advertise(Advertisement{
+			Instance: "croc-test",
+			Port:     9009,
+			Text:     []string{"room=test"},
+			StopChan: stop,
+		})
+	}()
+	entries, err := Browse(Query{
+		Match:     func(text []string) bool { return len(text) == 1 && text[0] == "room=test" },
+		Limit:     1,
+		TimeLimit: time.Second,
+	})
+	close(stop)
+	require.NoError(t, err)
+	require.NoError(t, <-advertised)
+	if len(entries) == 0 {
+		t.Skip("multicast is not looped back on this host")
+	}
+	assert.Equal(t, "croc-test", entries[0].Instance)
+	assert.Equal(t, 9009, entries[0].Port)
+}
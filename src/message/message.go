@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"fmt"
 
-	"github.com/schollz/croc/v11/src/comm"
 	"github.com/schollz/croc/v11/src/compress"
 	"github.com/schollz/croc/v11/src/crypt"
 	log "github.com/schollz/logger"
@@ -25,6 +24,7 @@ const (
 	TypeCloseSender    Type = "close-sender"
 	TypeRecipientReady Type = "recipientready"
 	TypeFileInfo       Type = "fileinfo"
+	TypeDirect         Type = "direct"
 )
 
 // Message is the possible payload for messaging
@@ -34,6 +34,7 @@ type Message struct {
 	Message string `json:"m,omitempty"`
 	Bytes   []byte `json:"b,omitempty"`
 	Bytes2  []byte `json:"b2,omitempty"`
+	Bytes3  []byte `json:"b3,omitempty"`
 	Num     int    `json:"n,omitempty"`
 }
 
@@ -42,8 +43,13 @@ func (m Message) String() string {
 	return string(b)
 }
 
+// Sender writes one framed message to the peer, like a comm.Comm.
+type Sender interface {
+	Send([]byte) error
+}
+
 // Send will send out
-func Send(c *comm.Comm, key []byte, m Message) (err error) {
+func Send(c Sender, key []byte, m Message) (err error) {
 	mSend, err := Encode(key, m)
 	if err != nil {
 		return
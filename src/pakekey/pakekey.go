@@ -4,6 +4,7 @@ package pakekey
 
 import (
 	"crypto/hmac"
+	"crypto/mlkem"
 	"crypto/sha256"
 	"encoding/binary"
 	"fmt"
@@ -22,8 +23,17 @@ const (
 	PurposeTransfer   = "peer-transfer"
 	PurposeLocalProbe = "local-ip-probe"
 
-	identityDomain   = "croc/spake2/participant/v2"
-	transcriptDomain = "croc/spake2/channel/v2"
+	// HybridMLKEM768 names the optional key schedule that mixes an ML-KEM-768
+	// shared key into the PAKE session key. Party A offers it by sending an
+	// encapsulation key with its initial PAKE value.
+	HybridMLKEM768 = "mlkem768-hybrid-v1"
+	// RequireHybridMLKEM768 is Party B's answer to an initial PAKE value
+	// without an offer when B insists on HybridMLKEM768; A restarts with one.
+	RequireHybridMLKEM768 = "require-" + HybridMLKEM768
+
+	identityDomain         = "croc/spake2/participant/v2"
+	transcriptDomain       = "croc/spake2/channel/v2"
+	hybridTranscriptDomain = "croc/spake2-mlkem768/channel/v2"
 )
 
 // Context contains the public session values authenticated by the channel key
@@ -36,6 +46,13 @@ type Context struct {
 	Initiator []byte
 	Responder []byte
 	Salt      []byte
+
+	// KEM, EncapsulationKey and Ciphertext describe a hybrid exchange: party
+	// A's ML-KEM encapsulation key and party B's ciphertext. They are empty
+	// for a classical exchange.
+	KEM              string
+	EncapsulationKey []byte
+	Ciphertext       []byte
 }
 
 // Keys contains the traffic key and the role-specific confirmation tags.
@@ -71,9 +88,56 @@ func Init(password []byte, role int, curve, purpose, room string) (*pake.Pake, e
 	return pake.InitCurveWithIdentities(password, role, curve, idA, idB)
 }
 
+// GenerateHybridKey creates party A's ML-KEM-768 decapsulation key. Its
+// encapsulation key is sent alongside the initial PAKE value.
+func GenerateHybridKey() (*mlkem.DecapsulationKey768, error) {
+	return mlkem.GenerateKey768()
+}
+
+// Encapsulate answers party A's hybrid offer. It returns the KEM shared key
+// and the ciphertext party B sends back with its PAKE response.
+func Encapsulate(kem string, encapsulationKey []byte) (sharedKey, ciphertext []byte, err error) {
+	if kem != HybridMLKEM768 {
+		return nil, nil, fmt.Errorf("unsupported hybrid PAKE mode %q", kem)
+	}
+	key, err := mlkem.NewEncapsulationKey768(encapsulationKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid ML-KEM encapsulation key: %w", err)
+	}
+	sharedKey, ciphertext = key.Encapsulate()
+	return sharedKey, ciphertext, nil
+}
+
 // Derive expands a PAKE session key into a traffic key and mutual key-
 // confirmation tags bound to the exact croc transcript.
 func Derive(sharedKey []byte, context Context) (Keys, error) {
+	if context.KEM != "" || len(context.EncapsulationKey) != 0 || len(context.Ciphertext) != 0 {
+		return Keys{}, fmt.Errorf("hybrid PAKE context requires a KEM shared key")
+	}
+	return derive(sharedKey, nil, context)
+}
+
+// DeriveHybrid is Derive for a hybrid exchange. Both the PAKE session key and
+// the ML-KEM shared key feed the key schedule, so the channel stays
+// confidential unless both are broken, and the confirmation tags also
+// authenticate the encapsulation key and ciphertext.
+func DeriveHybrid(sharedKey, kemSharedKey []byte, context Context) (Keys, error) {
+	if context.KEM != HybridMLKEM768 {
+		return Keys{}, fmt.Errorf("unsupported hybrid PAKE mode %q", context.KEM)
+	}
+	if len(context.EncapsulationKey) != mlkem.EncapsulationKeySize768 {
+		return Keys{}, fmt.Errorf("ML-KEM encapsulation key must be %d bytes", mlkem.EncapsulationKeySize768)
+	}
+	if len(context.Ciphertext) != mlkem.CiphertextSize768 {
+		return Keys{}, fmt.Errorf("ML-KEM ciphertext must be %d bytes", mlkem.CiphertextSize768)
+	}
+	if len(kemSharedKey) != mlkem.SharedKeySize {
+		return Keys{}, fmt.Errorf("ML-KEM shared key must be %d bytes", mlkem.SharedKeySize)
+	}
+	return derive(sharedKey, kemSharedKey, context)
+}
+
+func derive(sharedKey, kemSharedKey []byte, context Context) (Keys, error) {
 	if len(sharedKey) == 0 {
 		return Keys{}, fmt.Errorf("PAKE session key is required")
 	}
@@ -92,8 +156,12 @@ func Derive(sharedKey []byte, context Context) (Keys, error) {
 	}
 	version := make([]byte, 8)
 	binary.LittleEndian.PutUint64(version, ProtocolVersion)
+	domain, secret := transcriptDomain, sharedKey
+	if context.KEM != "" {
+		domain, secret = hybridTranscriptDomain, frame(sharedKey, kemSharedKey)
+	}
 	transcript := frame(
-		[]byte(transcriptDomain),
+		[]byte(domain),
 		version,
 		[]byte(context.Purpose),
 		[]byte(context.Room),
@@ -104,9 +172,16 @@ func Derive(sharedKey []byte, context Context) (Keys, error) {
 		context.Responder,
 		context.Salt,
 	)
+	if context.KEM != "" {
+		transcript = append(transcript, frame(
+			[]byte(context.KEM),
+			context.EncapsulationKey,
+			context.Ciphertext,
+		)...)
+	}
 
 	material := make([]byte, 96)
-	if _, err = io.ReadFull(hkdf.New(sha256.New, sharedKey, context.Salt, transcript), material); err != nil {
+	if _, err = io.ReadFull(hkdf.New(sha256.New, secret, context.Salt, transcript), material); err != nil {
 		return Keys{}, fmt.Errorf("derive PAKE channel keys: %w", err)
 	}
 	encryptionKey := append([]byte(nil), material[:32]...)
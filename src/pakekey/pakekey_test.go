@@ -148,3 +148,90 @@ func TestFramingIsUnambiguous(t *testing.T) {
 		t.Fatal("length-prefixed transcript framing is ambiguous")
 	}
 }
+
+func TestHybridAgreementBindsKEMExchange(t *testing.T) {
+	decapsulationKey, err := GenerateHybridKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encapsulationKey := decapsulationKey.EncapsulationKey().Bytes()
+	kemSharedB, ciphertext, err := Encapsulate(HybridMLKEM768, encapsulationKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kemSharedA, err := decapsulationKey.Decapsulate(ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared := []byte("a fixed shared session key")
+	context := Context{
+		Purpose: PurposeTransfer, Room: "room-one", Curve: "p256",
+		Initiator: []byte("initiator"), Responder: []byte("responder"),
+		Salt: bytes.Repeat([]byte{3}, SaltSize),
+		KEM:  HybridMLKEM768, EncapsulationKey: encapsulationKey, Ciphertext: ciphertext,
+	}
+	keysA, err := DeriveHybrid(shared, kemSharedA, context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keysB, err := DeriveHybrid(shared, kemSharedB, context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(keysA.EncryptionKey, keysB.EncryptionKey) ||
+		!Confirm(keysA.ConfirmationA, keysB.ConfirmationA) ||
+		!Confirm(keysA.ConfirmationB, keysB.ConfirmationB) {
+		t.Fatal("peers derived different hybrid channel material")
+	}
+
+	classical := context
+	classical.KEM, classical.EncapsulationKey, classical.Ciphertext = "", nil, nil
+	classicalKeys, err := Derive(shared, classical)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(classicalKeys.EncryptionKey, keysA.EncryptionKey) ||
+		Confirm(classicalKeys.ConfirmationA, keysA.ConfirmationA) {
+		t.Fatal("hybrid exchange derived the classical channel material")
+	}
+
+	otherKEMShared := bytes.Repeat([]byte{4}, len(kemSharedA))
+	tampered, err := DeriveHybrid(shared, otherKEMShared, context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Confirm(keysA.ConfirmationA, tampered.ConfirmationA) {
+		t.Fatal("different ML-KEM shared key retained a valid confirmation")
+	}
+	otherCiphertext := context
+	otherCiphertext.Ciphertext = bytes.Clone(ciphertext)
+	otherCiphertext.Ciphertext[0] ^= 1
+	tampered, err = DeriveHybrid(shared, kemSharedA, otherCiphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Confirm(keysA.ConfirmationB, tampered.ConfirmationB) {
+		t.Fatal("changed ML-KEM ciphertext retained a valid confirmation")
+	}
+}
+
+func TestHybridValidation(t *testing.T) {
+	if _, _, err := Encapsulate("mlkem512", make([]byte, 800)); err == nil {
+		t.Fatal("expected unsupported hybrid mode error")
+	}
+	if _, _, err := Encapsulate(HybridMLKEM768, []byte("short")); err == nil {
+		t.Fatal("expected invalid encapsulation key error")
+	}
+	context := Context{
+		Purpose: PurposeTransfer, Room: "room", Curve: "p256",
+		Initiator: []byte("a"), Responder: []byte("b"),
+		Salt: make([]byte, SaltSize),
+		KEM:  HybridMLKEM768, EncapsulationKey: []byte("short"), Ciphertext: []byte("short"),
+	}
+	if _, err := Derive([]byte("key"), context); err == nil {
+		t.Fatal("classical derivation accepted a hybrid context")
+	}
+	if _, err := DeriveHybrid([]byte("key"), make([]byte, 32), context); err == nil {
+		t.Fatal("expected ML-KEM size error")
+	}
+}
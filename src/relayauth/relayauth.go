@@ -0,0 +1,461 @@
+// Package relayauth authenticates relay users by per-user tokens instead of a
+// shared relay password, and enforces per-user quotas.
+//
+// A relay reads its tokens from a YAML file that stores only token hashes.
+// The file may also hold signing keys, which let an operator issue signed
+// tokens that the relay verifies without listing them, and a list of revoked
+// user names.
+package relayauth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// maxFileSize bounds the token file.
+const maxFileSize = 4 << 20
+
+// signedPrefix starts every signed token.
+const signedPrefix = "croc1."
+
+// hashPrefix starts every token hash in the token file.
+const hashPrefix = "sha256:"
+
+// minKeySize is the smallest accepted signing key, in bytes.
+const minKeySize = 32
+
+// Errors returned by Authenticate.
+var (
+	ErrInvalidToken  = errors.New("invalid relay token")
+	ErrQuotaExceeded = errors.New("relay token quota exceeded")
+)
+
+// Quota limits what one user may use of a relay. Zero fields are unlimited.
+type Quota struct {
+	// MaxConnections is the number of relay connections the user may hold
+	// open at once, across all relay ports.
+	MaxConnections int `yaml:"max-connections,omitempty" json:"conn,omitempty"`
+	// MaxBytesPerDay is the number of bytes the user's peers may send
+	// through the relay per UTC day. It is checked when a connection is
+	// authenticated and charged as the connection relays.
+	MaxBytesPerDay int64 `yaml:"max-bytes-per-day,omitempty" json:"bytes,omitempty"`
+}
+
+// Entry is one user's token in the token file.
+type Entry struct {
+	Name  string `yaml:"name"`
+	Hash  string `yaml:"hash"`
+	Quota `yaml:",inline"`
+}
+
+// File is the relay token file.
+type File struct {
+	SigningKeys []string `yaml:"signing-keys,omitempty"`
+	Tokens      []Entry  `yaml:"tokens,omitempty"`
+	Revoked     []string `yaml:"revoked,omitempty"`
+}
+
+// Claims are the contents of a signed token.
+type Claims struct {
+	Name    string `json:"sub"`
+	Expires int64  `json:"exp,omitempty"`
+	Quota
+}
+
+// ReadFile reads and validates the token file at path.
+func ReadFile(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxFileSize {
+		return nil, fmt.Errorf("relay token file %s is larger than %d bytes", path, maxFileSize)
+	}
+	var f File
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err = decoder.Decode(&f); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("relay token file %s: %w", path, err)
+	}
+	if err = f.validate(); err != nil {
+		return nil, fmt.Errorf("relay token file %s: %w", path, err)
+	}
+	return &f, nil
+}
+
+// WriteFile writes f to path with mode 0600, replacing it atomically.
+func (f *File) WriteFile(path string) error {
+	if err := f.validate(); err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(f)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err = tmp.Chmod(0o600); err == nil {
+		_, err = tmp.Write(data)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (f *File) validate() error {
+	for i, key := range f.SigningKeys {
+		if _, err := decodeKey(key); err != nil {
+			return fmt.Errorf("signing key %d: %w", i+1, err)
+		}
+	}
+	for i, entry := range f.Tokens {
+		if strings.TrimSpace(entry.Name) == "" {
+			return fmt.Errorf("token %d has no name", i+1)
+		}
+		if _, err := decodeHash(entry.Hash); err != nil {
+			return fmt.Errorf("token %q: %w", entry.Name, err)
+		}
+		if err := entry.Quota.validate(); err != nil {
+			return fmt.Errorf("token %q: %w", entry.Name, err)
+		}
+	}
+	return nil
+}
+
+func (q Quota) validate() error {
+	if q.MaxConnections < 0 {
+		return errors.New("max-connections must not be negative")
+	}
+	if q.MaxBytesPerDay < 0 {
+		return errors.New("max-bytes-per-day must not be negative")
+	}
+	return nil
+}
+
+// NewToken returns a new random token to hand to a user.
+func NewToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the hash of token as stored in the token file.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hashPrefix + hex.EncodeToString(sum[:])
+}
+
+// NewSigningKey returns a new random signing key for the token file.
+func NewSigningKey() (string, error) {
+	b := make([]byte, minKeySize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Sign issues a signed token carrying claims, using a signing key from the
+// token file.
+func Sign(key string, claims Claims) (string, error) {
+	secret, err := decodeKey(key)
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(claims.Name) == "" {
+		return "", errors.New("signed token has no name")
+	}
+	if err = claims.Quota.validate(); err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := signedPrefix + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature(secret, signed)), nil
+}
+
+func signature(secret []byte, signed string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+	return mac.Sum(nil)
+}
+
+func decodeKey(key string) ([]byte, error) {
+	secret, err := base64.RawURLEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing key: %w", err)
+	}
+	if len(secret) < minKeySize {
+		return nil, fmt.Errorf("signing key must be at least %d bytes", minKeySize)
+	}
+	return secret, nil
+}
+
+func decodeHash(hash string) ([sha256.Size]byte, error) {
+	var digest [sha256.Size]byte
+	value, ok := strings.CutPrefix(strings.TrimSpace(hash), hashPrefix)
+	if !ok {
+		return digest, fmt.Errorf("hash must start with %q", hashPrefix)
+	}
+	decoded, err := hex.DecodeString(value)
+	if err != nil || len(decoded) != sha256.Size {
+		return digest, errors.New("hash must be a hex SHA-256 digest")
+	}
+	copy(digest[:], decoded)
+	return digest, nil
+}
+
+// Authenticator verifies relay tokens against a token file and tracks each
+// user's usage. It is safe for concurrent use and shared by all ports of a
+// relay, so quotas apply across ports.
+type Authenticator struct {
+	path string
+	now  func() time.Time
+
+	mu      sync.Mutex
+	keys    [][]byte
+	tokens  map[[sha256.Size]byte]Entry
+	revoked map[string]bool
+	usage   map[string]*usage
+	// day is the UTC day, in Unix seconds, that usage was last pruned on.
+	day int64
+}
+
+// usage is one user's connections and relayed bytes. The byte count is
+// atomic so that charging relayed bytes does not take the authenticator's
+// lock.
+type usage struct {
+	connections int
+	day         atomic.Int64
+	bytes       atomic.Int64
+}
+
+// Load reads the token file at path.
+func Load(path string) (*Authenticator, error) {
+	a := &Authenticator{path: path, now: time.Now, usage: make(map[string]*usage)}
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Reload reads the token file again. Usage is kept; connections of users
+// whose tokens were removed or revoked stay open until they close.
+func (a *Authenticator) Reload() error {
+	f, err := ReadFile(a.path)
+	if err != nil {
+		return err
+	}
+	keys := make([][]byte, 0, len(f.SigningKeys))
+	for _, key := range f.SigningKeys {
+		secret, _ := decodeKey(key)
+		keys = append(keys, secret)
+	}
+	tokens := make(map[[sha256.Size]byte]Entry, len(f.Tokens))
+	for _, entry := range f.Tokens {
+		digest, _ := decodeHash(entry.Hash)
+		entry.Name = strings.TrimSpace(entry.Name)
+		tokens[digest] = entry
+	}
+	revoked := make(map[string]bool, len(f.Revoked))
+	for _, name := range f.Revoked {
+		revoked[strings.TrimSpace(name)] = true
+	}
+	a.mu.Lock()
+	a.keys, a.tokens, a.revoked = keys, tokens, revoked
+	a.mu.Unlock()
+	return nil
+}
+
+// Users returns the number of tokens listed in the token file and of
+// signing keys.
+func (a *Authenticator) Users() (tokens, keys int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.tokens), len(a.keys)
+}
+
+// Authenticate verifies token and, if its user is within quota, counts a new
+// connection for the user. The returned lease must be released when the
+// connection ends.
+func (a *Authenticator) Authenticate(token string) (*Lease, error) {
+	token = strings.TrimSpace(token)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := a.now()
+	a.prune(now)
+
+	var name string
+	var quota Quota
+	if strings.HasPrefix(token, signedPrefix) {
+		claims, err := a.verifySigned(token, now)
+		if err != nil {
+			return nil, err
+		}
+		name, quota = claims.Name, claims.Quota
+	} else {
+		entry, ok := a.tokens[sha256.Sum256([]byte(token))]
+		if !ok {
+			return nil, ErrInvalidToken
+		}
+		name, quota = entry.Name, entry.Quota
+	}
+	if a.revoked[name] {
+		return nil, ErrInvalidToken
+	}
+
+	u := a.usage[name]
+	if u == nil {
+		u = new(usage)
+		a.usage[name] = u
+	}
+	u.rollover(now)
+	if quota.MaxConnections > 0 && u.connections >= quota.MaxConnections {
+		return nil, ErrQuotaExceeded
+	}
+	if quota.MaxBytesPerDay > 0 && u.bytes.Load() >= quota.MaxBytesPerDay {
+		return nil, ErrQuotaExceeded
+	}
+	u.connections++
+	return &Lease{auth: a, name: name, usage: u, maxBytes: quota.MaxBytesPerDay}, nil
+}
+
+func (a *Authenticator) verifySigned(token string, now time.Time) (Claims, error) {
+	var claims Claims
+	dot := strings.LastIndex(token, ".")
+	if dot < len(signedPrefix) {
+		return claims, ErrInvalidToken
+	}
+	signed := token[:dot]
+	sig, err := base64.RawURLEncoding.DecodeString(token[dot+1:])
+	if err != nil {
+		return claims, ErrInvalidToken
+	}
+	valid := 0
+	for _, key := range a.keys {
+		valid |= subtle.ConstantTimeCompare(sig, signature(key, signed))
+	}
+	if valid != 1 {
+		return claims, ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(signed, signedPrefix))
+	if err != nil || json.Unmarshal(payload, &claims) != nil {
+		return claims, ErrInvalidToken
+	}
+	claims.Name = strings.TrimSpace(claims.Name)
+	if claims.Name == "" || claims.Quota.validate() != nil {
+		return claims, ErrInvalidToken
+	}
+	if claims.Expires != 0 && now.Unix() >= claims.Expires {
+		return claims, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// prune drops the usage of users without connections once the day rolls
+// over, so that users removed from the token file or whose signed tokens
+// expired are not kept forever. Their bytes would reset anyway.
+func (a *Authenticator) prune(now time.Time) {
+	day := utcDay(now)
+	if day == a.day {
+		return
+	}
+	a.day = day
+	for name, u := range a.usage {
+		if u.connections == 0 && u.day.Load() < day {
+			delete(a.usage, name)
+		}
+	}
+}
+
+func (u *usage) rollover(now time.Time) {
+	day := utcDay(now)
+	for {
+		current := u.day.Load()
+		if current >= day {
+			return
+		}
+		if u.day.CompareAndSwap(current, day) {
+			u.bytes.Store(0)
+			return
+		}
+	}
+}
+
+func utcDay(now time.Time) int64 {
+	return now.UTC().Truncate(24 * time.Hour).Unix()
+}
+
+// Lease is one authenticated relay connection of a user.
+type Lease struct {
+	auth     *Authenticator
+	name     string
+	usage    *usage
+	maxBytes int64
+	released bool
+}
+
+// Name returns the name of the lease's user. It is empty for a nil lease.
+func (l *Lease) Name() string {
+	if l == nil {
+		return ""
+	}
+	return l.name
+}
+
+// Charge counts n bytes the connection relayed against the user's daily
+// quota, and reports whether the user may keep relaying. A nil lease is
+// never limited.
+func (l *Lease) Charge(n int64) bool {
+	if l == nil {
+		return true
+	}
+	l.usage.rollover(l.auth.now())
+	used := l.usage.bytes.Add(n)
+	return l.maxBytes <= 0 || used < l.maxBytes
+}
+
+// Release ends the connection. Only the first call has an effect; a nil
+// lease is ignored.
+func (l *Lease) Release() {
+	if l == nil {
+		return
+	}
+	l.auth.mu.Lock()
+	defer l.auth.mu.Unlock()
+	if l.released {
+		return
+	}
+	l.released = true
+	l.usage.connections--
+}
@@ -0,0 +1,237 @@
+package relayauth
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTokenFile(t *testing.T, f *File) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "tokens.yaml")
+	require.NoError(t, f.WriteFile(path))
+	return path
+}
+
+func TestFileRoundTrip(t *testing.T) {
+	key, err := NewSigningKey()
+	require.NoError(t, err)
+	f := &File{
+		SigningKeys: []string{key},
+		Tokens: []Entry{
+			{Name: "alice", Hash: HashToken("alice-token"), Quota: Quota{MaxConnections: 4, MaxBytesPerDay: 1 << 30}},
+		},
+		Revoked: []string{"mallory"},
+	}
+	path := writeTokenFile(t, f)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	if os.PathSeparator == '/' {
+		assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	}
+	got, err := ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, f, got)
+}
+
+func TestReadFileRejectsInvalidEntries(t *testing.T) {
+	dir := t.TempDir()
+	for name, contents := range map[string]string{
+		"unknown key": "users: []\n",
+		"no name":     "tokens:\n  - hash: " + HashToken("x") + "\n",
+		"plain token": "tokens:\n  - name: alice\n    hash: alice-token\n",
+		"short key":   "signing-keys: [c2hvcnQ]\n",
+		"negative":    "tokens:\n  - name: alice\n    hash: " + HashToken("x") + "\n    max-connections: -1\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, "tokens.yaml")
+			require.NoError(t, os.WriteFile(path, []byte(contents), 0o600))
+			_, err := ReadFile(path)
+			assert.Error(t, err)
+		})
+	}
+
+	path := filepath.Join(dir, "empty.yaml")
+	require.NoError(t, os.WriteFile(path, nil, 0o600))
+	f, err := ReadFile(path)
+	require.NoError(t, err)
+	assert.Empty(t, f.Tokens)
+}
+
+func TestAuthenticateListedTokens(t *testing.T) {
+	token, err := NewToken()
+	require.NoError(t, err)
+	path := writeTokenFile(t, &File{Tokens: []Entry{{Name: "alice", Hash: HashToken(token)}}})
+	a, err := Load(path)
+	require.NoError(t, err)
+
+	lease, err := a.Authenticate(token + "\n")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", lease.Name())
+	lease.Release()
+
+	_, err = a.Authenticate("pass123")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	require.NoError(t, (&File{
+		Tokens:  []Entry{{Name: "alice", Hash: HashToken(token)}},
+		Revoked: []string{"alice"},
+	}).WriteFile(path))
+	require.NoError(t, a.Reload())
+	_, err = a.Authenticate(token)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestAuthenticateSignedTokens(t *testing.T) {
+	key, err := NewSigningKey()
+	require.NoError(t, err)
+	otherKey, err := NewSigningKey()
+	require.NoError(t, err)
+	a, err := Load(writeTokenFile(t, &File{SigningKeys: []string{key}}))
+	require.NoError(t, err)
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	a.now = func() time.Time { return now }
+
+	token, err := Sign(key, Claims{Name: "bob", Expires: now.Add(time.Hour).Unix()})
+	require.NoError(t, err)
+	lease, err := a.Authenticate(token)
+	require.NoError(t, err)
+	assert.Equal(t, "bob", lease.Name())
+	lease.Release()
+
+	expired, err := Sign(key, Claims{Name: "bob", Expires: now.Add(-time.Second).Unix()})
+	require.NoError(t, err)
+	_, err = a.Authenticate(expired)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	foreign, err := Sign(otherKey, Claims{Name: "bob"})
+	require.NoError(t, err)
+	_, err = a.Authenticate(foreign)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	// changing the claims invalidates the signature
+	forged := signedPrefix + "eyJzdWIiOiJldmUifQ" + token[len(token)-44:]
+	_, err = a.Authenticate(forged)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	_, err = a.Authenticate(signedPrefix)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	_, err = Sign(key, Claims{})
+	assert.Error(t, err)
+	_, err = Sign("c2hvcnQ", Claims{Name: "bob"})
+	assert.Error(t, err)
+}
+
+func TestQuotas(t *testing.T) {
+	key, err := NewSigningKey()
+	require.NoError(t, err)
+	path := writeTokenFile(t, &File{
+		SigningKeys: []string{key},
+		Tokens: []Entry{
+			{Name: "alice", Hash: HashToken("alice-token"), Quota: Quota{MaxConnections: 2, MaxBytesPerDay: 100}},
+		},
+	})
+	a, err := Load(path)
+	require.NoError(t, err)
+	now := time.Date(2026, 10, 19, 23, 0, 0, 0, time.UTC)
+	a.now = func() time.Time { return now }
+
+	first, err := a.Authenticate("alice-token")
+	require.NoError(t, err)
+	second, err := a.Authenticate("alice-token")
+	require.NoError(t, err)
+	_, err = a.Authenticate("alice-token")
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+
+	assert.True(t, first.Charge(40))
+	first.Release()
+	first.Release()
+	third, err := a.Authenticate("alice-token")
+	require.NoError(t, err)
+	third.Release()
+
+	// signed tokens of the same user share its usage
+	signed, err := Sign(key, Claims{Name: "alice", Quota: Quota{MaxConnections: 2}})
+	require.NoError(t, err)
+	_, err = a.Authenticate(signed)
+	require.NoError(t, err)
+	_, err = a.Authenticate(signed)
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+
+	assert.False(t, second.Charge(60), "the connection used up the daily bytes")
+	second.Release()
+	_, err = a.Authenticate("alice-token")
+	assert.ErrorIs(t, err, ErrQuotaExceeded, "daily bytes are used up")
+
+	now = now.Add(2 * time.Hour)
+	lease, err := a.Authenticate("alice-token")
+	require.NoError(t, err, "bytes reset on the next UTC day")
+	lease.Release()
+
+	var nilLease *Lease
+	nilLease.Release()
+	assert.True(t, nilLease.Charge(10))
+	assert.Empty(t, nilLease.Name())
+}
+
+func TestChargeIsSharedAcrossConnections(t *testing.T) {
+	path := writeTokenFile(t, &File{Tokens: []Entry{
+		{Name: "alice", Hash: HashToken("alice-token"), Quota: Quota{MaxBytesPerDay: 1 << 20}},
+	}})
+	a, err := Load(path)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for range 8 {
+		lease, err := a.Authenticate("alice-token")
+		require.NoError(t, err)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer lease.Release()
+			for range 1000 {
+				lease.Charge(16)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.EqualValues(t, 8*1000*16, a.usage["alice"].bytes.Load())
+}
+
+func TestIdleUsageIsPrunedOnTheNextDay(t *testing.T) {
+	path := writeTokenFile(t, &File{Tokens: []Entry{
+		{Name: "alice", Hash: HashToken("alice-token")},
+		{Name: "bob", Hash: HashToken("bob-token")},
+	}})
+	a, err := Load(path)
+	require.NoError(t, err)
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	a.now = func() time.Time { return now }
+
+	alice, err := a.Authenticate("alice-token")
+	require.NoError(t, err)
+	assert.True(t, alice.Charge(10))
+	alice.Release()
+	bob, err := a.Authenticate("bob-token")
+	require.NoError(t, err)
+	defer bob.Release()
+
+	// alice is removed from the token file; the usage stays for the day
+	require.NoError(t, (&File{Tokens: []Entry{{Name: "bob", Hash: HashToken("bob-token")}}}).WriteFile(path))
+	require.NoError(t, a.Reload())
+	_, err = a.Authenticate("alice-token")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	assert.Contains(t, a.usage, "alice")
+
+	now = now.Add(24 * time.Hour)
+	_, err = a.Authenticate("alice-token")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	assert.NotContains(t, a.usage, "alice")
+	assert.Contains(t, a.usage, "bob", "users with open connections are kept")
+}
@@ -0,0 +1,75 @@
+package relaycluster
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Memory is a Backend for relay nodes in one process, such as in tests.
+type Memory struct {
+	now func() time.Time
+
+	mu     sync.Mutex
+	claims map[string]memoryClaim
+}
+
+type memoryClaim struct {
+	node    string
+	expires time.Time
+}
+
+// NewMemory returns an empty in-memory backend.
+func NewMemory() *Memory {
+	return &Memory{now: time.Now, claims: make(map[string]memoryClaim)}
+}
+
+// holder returns the live claim on key. It must be called with m.mu held.
+func (m *Memory) holder(key string, now time.Time) (memoryClaim, bool) {
+	claim, ok := m.claims[key]
+	if ok && !now.Before(claim.expires) {
+		delete(m.claims, key)
+		return memoryClaim{}, false
+	}
+	return claim, ok
+}
+
+// Claim implements Backend.
+func (m *Memory) Claim(_ context.Context, key, node string, ttl time.Duration) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	if claim, ok := m.holder(key, now); ok && claim.node != node {
+		return claim.node, nil
+	}
+	m.claims[key] = memoryClaim{node: node, expires: now.Add(ttl)}
+	return node, nil
+}
+
+// Renew implements Backend.
+func (m *Memory) Renew(_ context.Context, key, node string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	claim, ok := m.holder(key, now)
+	if !ok || claim.node != node {
+		return false, nil
+	}
+	m.claims[key] = memoryClaim{node: node, expires: now.Add(ttl)}
+	return true, nil
+}
+
+// Release implements Backend.
+func (m *Memory) Release(_ context.Context, key, node string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if claim, ok := m.claims[key]; ok && claim.node == node {
+		delete(m.claims, key)
+	}
+	return nil
+}
+
+// Close implements Backend.
+func (m *Memory) Close() error {
+	return nil
+}
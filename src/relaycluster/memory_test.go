@@ -0,0 +1,76 @@
+package relaycluster
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testBackend checks the claim semantics every Backend must provide.
+func testBackend(t *testing.T, b Backend) {
+	t.Helper()
+	ctx := context.Background()
+
+	holder, err := b.Claim(ctx, "room", "node-a:9009", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, "node-a:9009", holder)
+	holder, err = b.Claim(ctx, "room", "node-b:9009", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, "node-a:9009", holder, "a held room is not taken over")
+	holder, err = b.Claim(ctx, "room", "node-a:9009", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, "node-a:9009", holder, "claiming again is harmless")
+
+	renewed, err := b.Renew(ctx, "room", "node-a:9009", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, renewed)
+	renewed, err = b.Renew(ctx, "room", "node-b:9009", time.Minute)
+	require.NoError(t, err)
+	assert.False(t, renewed)
+
+	require.NoError(t, b.Release(ctx, "room", "node-b:9009"))
+	holder, err = b.Claim(ctx, "room", "node-b:9009", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, "node-a:9009", holder, "other nodes cannot release a claim they do not hold")
+
+	require.NoError(t, b.Release(ctx, "room", "node-a:9009"))
+	renewed, err = b.Renew(ctx, "room", "node-a:9009", time.Minute)
+	require.NoError(t, err)
+	assert.False(t, renewed)
+	holder, err = b.Claim(ctx, "room", "node-b:9009", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, "node-b:9009", holder)
+
+	require.NoError(t, b.Close())
+}
+
+func TestMemoryBackend(t *testing.T) {
+	testBackend(t, NewMemory())
+}
+
+func TestMemoryClaimsExpire(t *testing.T) {
+	m := NewMemory()
+	now := time.Now()
+	m.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	_, err := m.Claim(ctx, "room", "node-a:9009", 30*time.Second)
+	require.NoError(t, err)
+	now = now.Add(20 * time.Second)
+	renewed, err := m.Renew(ctx, "room", "node-a:9009", 30*time.Second)
+	require.NoError(t, err)
+	assert.True(t, renewed)
+
+	now = now.Add(29 * time.Second)
+	holder, err := m.Claim(ctx, "room", "node-b:9009", 30*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "node-a:9009", holder, "renewal extends the claim")
+
+	now = now.Add(time.Second)
+	holder, err = m.Claim(ctx, "room", "node-b:9009", 30*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "node-b:9009", holder, "a stopped node's claim expires")
+}
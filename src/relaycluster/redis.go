@@ -0,0 +1,277 @@
+package relaycluster
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redisTimeout bounds each command sent to the server.
+const redisTimeout = 5 * time.Second
+
+// maxRedisBulk bounds a bulk string reply.
+const maxRedisBulk = 1 << 20
+
+// The claim scripts run atomically on the server. A missing key reads as
+// false in Lua.
+const (
+	claimScript = `local holder = redis.call('GET', KEYS[1])
+if not holder or holder == ARGV[1] then
+  redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+  return ARGV[1]
+end
+return holder`
+	renewScript = `if redis.call('GET', KEYS[1]) == ARGV[1] then
+  return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0`
+	releaseScript = `if redis.call('GET', KEYS[1]) == ARGV[1] then
+  return redis.call('DEL', KEYS[1])
+end
+return 0`
+)
+
+// Redis is a Backend on a Redis-compatible server. Each claim is a key that
+// expires with the claim.
+type Redis struct {
+	address   string
+	tlsConfig *tls.Config
+	username  string
+	password  string
+	db        int
+	timeout   time.Duration
+
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// redisError is an error reply of the server.
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// DialRedis connects to the Redis-compatible server at u, a redis:// or
+// rediss:// URL.
+func DialRedis(u *url.URL) (*Redis, error) {
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return nil, fmt.Errorf("unsupported redis scheme %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, errors.New("redis URL has no host")
+	}
+	r := &Redis{address: u.Host, timeout: redisTimeout}
+	if u.Port() == "" {
+		r.address = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.Scheme == "rediss" {
+		r.tlsConfig = &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12}
+	}
+	if u.User != nil {
+		var ok bool
+		r.username = u.User.Username()
+		if r.password, ok = u.User.Password(); !ok {
+			// redis://secret@host names only a password
+			r.username, r.password = "", r.username
+		}
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		n, err := strconv.Atoi(db)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid redis database %q", db)
+		}
+		r.db = n
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	if _, err := r.do(ctx, "PING"); err != nil {
+		return nil, fmt.Errorf("could not reach redis at %s: %w", r.address, err)
+	}
+	return r, nil
+}
+
+// Claim implements Backend.
+func (r *Redis) Claim(ctx context.Context, key, node string, ttl time.Duration) (string, error) {
+	reply, err := r.do(ctx, "EVAL", claimScript, "1", key, node, redisMilliseconds(ttl))
+	if err != nil {
+		return "", err
+	}
+	holder, ok := reply.(string)
+	if !ok {
+		return "", fmt.Errorf("unexpected redis reply %v", reply)
+	}
+	return holder, nil
+}
+
+// Renew implements Backend.
+func (r *Redis) Renew(ctx context.Context, key, node string, ttl time.Duration) (bool, error) {
+	reply, err := r.do(ctx, "EVAL", renewScript, "1", key, node, redisMilliseconds(ttl))
+	if err != nil {
+		return false, err
+	}
+	renewed, ok := reply.(int64)
+	if !ok {
+		return false, fmt.Errorf("unexpected redis reply %v", reply)
+	}
+	return renewed == 1, nil
+}
+
+// Release implements Backend.
+func (r *Redis) Release(ctx context.Context, key, node string) error {
+	_, err := r.do(ctx, "EVAL", releaseScript, "1", key, node)
+	return err
+}
+
+// Close implements Backend.
+func (r *Redis) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conn == nil {
+		return nil
+	}
+	err := r.conn.Close()
+	r.conn = nil
+	return err
+}
+
+func redisMilliseconds(d time.Duration) string {
+	return strconv.FormatInt(max(d.Milliseconds(), 1), 10)
+}
+
+// do sends one command, connecting first if needed. A connection that fails
+// is dropped and dialed again by the next command.
+func (r *Redis) do(ctx context.Context, args ...string) (any, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conn == nil {
+		if err := r.connect(ctx); err != nil {
+			return nil, err
+		}
+	}
+	reply, err := r.roundTrip(ctx, args...)
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		r.conn.Close()
+		r.conn = nil
+	}
+	return reply, err
+}
+
+// connect dials the server and authenticates. It must be called with r.mu
+// held.
+func (r *Redis) connect(ctx context.Context) error {
+	dialer := net.Dialer{Timeout: r.timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", r.address)
+	if err != nil {
+		return err
+	}
+	if r.tlsConfig != nil {
+		tlsConn := tls.Client(conn, r.tlsConfig)
+		if err = tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return err
+		}
+		conn = tlsConn
+	}
+	r.conn, r.reader = conn, bufio.NewReader(conn)
+	if r.password != "" {
+		auth := []string{"AUTH", r.password}
+		if r.username != "" {
+			auth = []string{"AUTH", r.username, r.password}
+		}
+		_, err = r.roundTrip(ctx, auth...)
+	}
+	if err == nil && r.db != 0 {
+		_, err = r.roundTrip(ctx, "SELECT", strconv.Itoa(r.db))
+	}
+	if err != nil {
+		r.conn.Close()
+		r.conn = nil
+	}
+	return err
+}
+
+// roundTrip writes a command and reads its reply. It must be called with
+// r.mu held.
+func (r *Redis) roundTrip(ctx context.Context, args ...string) (any, error) {
+	deadline := time.Now().Add(r.timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	if err := r.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	var command strings.Builder
+	fmt.Fprintf(&command, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&command, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(r.conn, command.String()); err != nil {
+		return nil, err
+	}
+	return readRedisReply(r.reader)
+}
+
+// readRedisReply reads one RESP reply: a string, an int64, nil, a []any, or
+// a redisError.
+func readRedisReply(reader *bufio.Reader) (any, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("malformed redis reply %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, redisError(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil || n > maxRedisBulk {
+			return nil, fmt.Errorf("malformed redis bulk length %q", body)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err = io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		if string(data[n:]) != "\r\n" {
+			return nil, errors.New("malformed redis bulk string")
+		}
+		return string(data[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil || n > maxRedisBulk {
+			return nil, fmt.Errorf("malformed redis array length %q", body)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = readRedisReply(reader); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unknown redis reply type %q", kind)
+}
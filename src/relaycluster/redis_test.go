@@ -0,0 +1,168 @@
+package relaycluster
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRedis speaks enough RESP to run the claim scripts against a map.
+type fakeRedis struct {
+	listener net.Listener
+	password string
+
+	mu       sync.Mutex
+	keys     map[string]string
+	commands []string
+	conns    []net.Conn
+}
+
+func startFakeRedis(t *testing.T, password string) *fakeRedis {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	f := &fakeRedis{listener: listener, password: password, keys: make(map[string]string)}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			f.mu.Lock()
+			f.conns = append(f.conns, conn)
+			f.mu.Unlock()
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+// dropConnections closes every connection the server accepted.
+func (f *fakeRedis) dropConnections() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, conn := range f.conns {
+		conn.Close()
+	}
+	f.conns = nil
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	authenticated := f.password == ""
+	for {
+		request, err := readRedisReply(reader)
+		if err != nil {
+			return
+		}
+		items, _ := request.([]any)
+		args := make([]string, len(items))
+		for i, item := range items {
+			args[i], _ = item.(string)
+		}
+		if len(args) == 0 {
+			return
+		}
+		f.mu.Lock()
+		f.commands = append(f.commands, args[0])
+		reply := "-ERR unknown command\r\n"
+		switch {
+		case args[0] == "AUTH":
+			if args[len(args)-1] == f.password {
+				authenticated = true
+				reply = "+OK\r\n"
+			} else {
+				reply = "-WRONGPASS invalid password\r\n"
+			}
+		case !authenticated:
+			reply = "-NOAUTH Authentication required.\r\n"
+		case args[0] == "PING":
+			reply = "+PONG\r\n"
+		case args[0] == "SELECT":
+			reply = "+OK\r\n"
+		case args[0] == "EVAL" && len(args) >= 5:
+			reply = f.eval(args[1], args[3], args[4])
+		}
+		f.mu.Unlock()
+		if _, err = conn.Write([]byte(reply)); err != nil {
+			return
+		}
+	}
+}
+
+// eval runs one of the claim scripts. It must be called with f.mu held.
+func (f *fakeRedis) eval(script, key, node string) string {
+	holder, held := f.keys[key]
+	switch script {
+	case claimScript:
+		if !held || holder == node {
+			f.keys[key] = node
+			holder = node
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(holder), holder)
+	case renewScript, releaseScript:
+		if !held || holder != node {
+			return ":0\r\n"
+		}
+		if script == releaseScript {
+			delete(f.keys, key)
+		}
+		return ":1\r\n"
+	}
+	return "-ERR unknown script\r\n"
+}
+
+func TestRedisBackend(t *testing.T) {
+	f := startFakeRedis(t, "hunter2")
+	b, err := Open("redis://:hunter2@" + f.listener.Addr().String() + "/2")
+	require.NoError(t, err)
+	f.mu.Lock()
+	assert.Equal(t, []string{"AUTH", "SELECT", "PING"}, f.commands)
+	f.mu.Unlock()
+	testBackend(t, b)
+}
+
+func TestRedisReconnects(t *testing.T) {
+	f := startFakeRedis(t, "")
+	u, err := url.Parse("redis://" + f.listener.Addr().String())
+	require.NoError(t, err)
+	r, err := DialRedis(u)
+	require.NoError(t, err)
+	defer r.Close()
+	ctx := context.Background()
+
+	_, err = r.Claim(ctx, "room", "node-a:9009", time.Minute)
+	require.NoError(t, err)
+	f.dropConnections()
+	_, err = r.Claim(ctx, "room", "node-b:9009", time.Minute)
+	assert.Error(t, err, "the dropped connection fails once")
+	holder, err := r.Claim(ctx, "room", "node-b:9009", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, "node-a:9009", holder)
+}
+
+func TestOpenRejectsInvalidBackends(t *testing.T) {
+	f := startFakeRedis(t, "hunter2")
+	_, err := Open("redis://:wrong@" + f.listener.Addr().String())
+	assert.ErrorContains(t, err, "WRONGPASS")
+	assert.NotContains(t, err.Error(), "wrong@")
+
+	_, err = Open("etcd://127.0.0.1:2379")
+	assert.ErrorContains(t, err, "unsupported cluster backend")
+	_, err = Open("redis://:secret@[::1")
+	require.Error(t, err)
+	assert.False(t, strings.Contains(err.Error(), "secret"))
+	_, err = Open("redis://127.0.0.1:6379/db")
+	assert.ErrorContains(t, err, "invalid redis database")
+}
@@ -0,0 +1,47 @@
+// Package relaycluster records which node of a relay cluster holds each room,
+// so that relay nodes behind one load balancer can send a peer to the node
+// where the other peer of its room waits.
+//
+// A node claims a room when its first peer arrives and keeps the claim alive
+// while the room is open. Claims expire on their own, so the rooms of a node
+// that stops are taken over by the other nodes.
+package relaycluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// Backend stores room claims shared by the nodes of a cluster. It must be
+// safe for concurrent use.
+type Backend interface {
+	// Claim makes node the holder of key for ttl unless another node holds
+	// it, and returns the holder.
+	Claim(ctx context.Context, key, node string, ttl time.Duration) (holder string, err error)
+	// Renew extends node's claim on key by ttl. It reports false if node no
+	// longer holds key.
+	Renew(ctx context.Context, key, node string, ttl time.Duration) (bool, error)
+	// Release removes node's claim on key. Claims of other nodes are kept.
+	Release(ctx context.Context, key, node string) error
+	// Close releases the backend's resources.
+	Close() error
+}
+
+// Open connects to the backend at rawURL. Redis-compatible servers are
+// reached with redis://[user:password@]host[:port][/db], or rediss:// for
+// TLS.
+func Open(rawURL string) (Backend, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		// the parse error would repeat the URL and its password
+		return nil, errors.New("invalid cluster backend URL")
+	}
+	switch u.Scheme {
+	case "redis", "rediss":
+		return DialRedis(u)
+	}
+	return nil, fmt.Errorf("unsupported cluster backend %q (use redis:// or rediss://)", u.Scheme)
+}
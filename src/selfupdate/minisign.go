@@ -0,0 +1,81 @@
+package selfupdate
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// Signature algorithms of minisign. Legacy signatures sign the message
+// itself, current ones sign its BLAKE2b-512 hash.
+const (
+	minisignLegacy    = "Ed"
+	minisignPrehashed = "ED"
+	minisignKeyIDSize = 8
+	trustedComment    = "trusted comment: "
+)
+
+// PublicKey is a minisign public key.
+type PublicKey struct {
+	id  [minisignKeyIDSize]byte
+	key ed25519.PublicKey
+}
+
+// ParsePublicKey reads a minisign public key, either the base64 line alone or
+// the whole .pub file with its comment.
+func ParsePublicKey(text string) (PublicKey, error) {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	encoded := strings.TrimSpace(lines[len(lines)-1])
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(raw) != 2+minisignKeyIDSize+ed25519.PublicKeySize || string(raw[:2]) != minisignLegacy {
+		return PublicKey{}, errors.New("invalid minisign public key")
+	}
+	var key PublicKey
+	copy(key.id[:], raw[2:2+minisignKeyIDSize])
+	key.key = ed25519.PublicKey(raw[2+minisignKeyIDSize:])
+	return key, nil
+}
+
+// Verify checks a minisign signature of message, including the signature of
+// its trusted comment.
+func (k PublicKey) Verify(message, signature []byte) error {
+	lines := strings.Split(strings.ReplaceAll(string(signature), "\r\n", "\n"), "\n")
+	if len(lines) < 4 || !strings.HasPrefix(lines[2], trustedComment) {
+		return errors.New("invalid minisign signature")
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(raw) != 2+minisignKeyIDSize+ed25519.SignatureSize {
+		return errors.New("invalid minisign signature")
+	}
+	if !bytes.Equal(raw[2:2+minisignKeyIDSize], k.id[:]) {
+		return fmt.Errorf("signature was made with key %X, not %X", raw[2:2+minisignKeyIDSize], k.id[:])
+	}
+	signed := message
+	switch string(raw[:2]) {
+	case minisignLegacy:
+	case minisignPrehashed:
+		hash := blake2b.Sum512(message)
+		signed = hash[:]
+	default:
+		return fmt.Errorf("unsupported minisign algorithm %q", raw[:2])
+	}
+	if !ed25519.Verify(k.key, signed, raw[2+minisignKeyIDSize:]) {
+		return errors.New("signature verification failed")
+	}
+
+	globalSignature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil || len(globalSignature) != ed25519.SignatureSize {
+		return errors.New("invalid minisign trusted comment signature")
+	}
+	// the trusted comment is signed together with the file's signature
+	commented := append(bytes.Clone(raw[2+minisignKeyIDSize:]), strings.TrimPrefix(lines[2], trustedComment)...)
+	if !ed25519.Verify(k.key, commented, globalSignature) {
+		return errors.New("trusted comment verification failed")
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+// Package selfupdate verifies croc release archives and replaces the running
+// executable with the binary they contain.
+package selfupdate
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// MaxBinarySize bounds the binary extracted from a release archive.
+const MaxBinarySize = 256 << 20
+
+// VerifyChecksum checks the SHA-256 of an archive against its line in a
+// sha256sum checksums file.
+func VerifyChecksum(checksums []byte, name string, archive []byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// sha256sum marks files hashed in binary mode with '*'
+		if len(fields) != 2 || strings.TrimPrefix(fields[1], "*") != name {
+			continue
+		}
+		want, err := hex.DecodeString(fields[0])
+		if err != nil || len(want) != sha256.Size {
+			return fmt.Errorf("invalid checksum for %s", name)
+		}
+		got := sha256.Sum256(archive)
+		if subtle.ConstantTimeCompare(got[:], want) != 1 {
+			return fmt.Errorf("checksum mismatch for %s", name)
+		}
+		return nil
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("no checksum for %s", name)
+}
+
+// ExtractBinary returns the executable called binary from the root of a
+// .zip or .tar.gz release archive.
+func ExtractBinary(archiveName string, archive []byte, binary string) ([]byte, error) {
+	switch {
+	case strings.HasSuffix(archiveName, ".zip"):
+		return extractZip(archive, binary)
+	case strings.HasSuffix(archiveName, ".tar.gz"):
+		return extractTarGz(archive, binary)
+	}
+	return nil, fmt.Errorf("unsupported archive %s", archiveName)
+}
+
+func extractZip(archive []byte, binary string) ([]byte, error) {
+	reader, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range reader.File {
+		if path.Clean(file.Name) != binary || !file.Mode().IsRegular() {
+			continue
+		}
+		content, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer content.Close()
+		return readBinary(content)
+	}
+	return nil, fmt.Errorf("archive does not contain %s", binary)
+}
+
+func extractTarGz(archive []byte, binary string) ([]byte, error) {
+	decompressed, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, err
+	}
+	defer decompressed.Close()
+	reader := tar.NewReader(decompressed)
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("archive does not contain %s", binary)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if path.Clean(header.Name) == binary && header.Typeflag == tar.TypeReg {
+			return readBinary(reader)
+		}
+	}
+}
+
+func readBinary(r io.Reader) ([]byte, error) {
+	binary, err := io.ReadAll(io.LimitReader(r, MaxBinarySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(binary) > MaxBinarySize {
+		return nil, errors.New("binary is too large")
+	}
+	if len(binary) == 0 {
+		return nil, errors.New("binary is empty")
+	}
+	return binary, nil
+}
+
+// Replace atomically replaces the executable with binary, keeping its
+// permissions. The new binary is written next to the executable and renamed
+// over it, so that a failed update leaves the old one in place.
+func Replace(executable string, binary []byte) error {
+	info, err := os.Stat(executable)
+	if err != nil {
+		return err
+	}
+	dir, name := filepath.Split(executable)
+	temp, err := os.CreateTemp(dir, "."+name+".new-*")
+	if err != nil {
+		return fmt.Errorf("could not write next to %s: %w", executable, err)
+	}
+	tempName := temp.Name()
+	defer os.Remove(tempName)
+	if _, err = temp.Write(binary); err != nil {
+		temp.Close()
+		return err
+	}
+	if err = temp.Sync(); err != nil {
+		temp.Close()
+		return err
+	}
+	if err = temp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tempName, info.Mode().Perm()); err != nil {
+		return err
+	}
+
+	if runtime.GOOS != "windows" {
+		return os.Rename(tempName, executable)
+	}
+	// Windows cannot replace a running executable, but it can rename it.
+	old := executable + ".old"
+	_ = os.Remove(old)
+	if err = os.Rename(executable, old); err != nil {
+		return err
+	}
+	if err = os.Rename(tempName, executable); err != nil {
+		_ = os.Rename(old, executable)
+		return err
+	}
+	return nil
+}
+
+// RemoveOld deletes the executable left behind by an update on Windows.
+func RemoveOld(executable string) {
+	_ = os.Remove(executable + ".old")
+}
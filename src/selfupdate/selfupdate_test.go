@@ -0,0 +1,135 @@
+package selfupdate
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/blake2b"
+)
+
+func TestVerifyMinisign(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	keyID := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	key, err := ParsePublicKey("untrusted comment: minisign public key\n" + encodePublicKey(keyID, publicKey) + "\n")
+	require.NoError(t, err)
+
+	message := []byte("checksums")
+	for _, algorithm := range []string{minisignLegacy, minisignPrehashed} {
+		signature := sign(privateKey, keyID, algorithm, message, "timestamp:1\tfile:checksums.txt")
+		require.NoError(t, key.Verify(message, signature), algorithm)
+		assert.Error(t, key.Verify([]byte("tampered"), signature), algorithm)
+	}
+
+	signature := sign(privateKey, keyID, minisignPrehashed, message, "release")
+	tampered := bytes.Replace(signature, []byte("trusted comment: release"), []byte("trusted comment: other"), 1)
+	assert.ErrorContains(t, key.Verify(message, tampered), "trusted comment")
+
+	_, otherKey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	assert.Error(t, key.Verify(message, sign(otherKey, keyID, minisignPrehashed, message, "release")))
+	assert.ErrorContains(t, key.Verify(message, sign(privateKey, []byte("otherkey"), minisignPrehashed, message, "release")), "key")
+
+	_, err = ParsePublicKey("not a key")
+	assert.Error(t, err)
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	archive := []byte("archive")
+	sum := sha256.Sum256(archive)
+	checksums := []byte(hex.EncodeToString(sum[:]) + "  croc_v1.0.0_Linux-64bit.tar.gz\n" +
+		hex.EncodeToString(make([]byte, sha256.Size)) + " *croc_v1.0.0_Windows-64bit.zip\n")
+
+	assert.NoError(t, VerifyChecksum(checksums, "croc_v1.0.0_Linux-64bit.tar.gz", archive))
+	assert.ErrorContains(t, VerifyChecksum(checksums, "croc_v1.0.0_Windows-64bit.zip", archive), "mismatch")
+	assert.ErrorContains(t, VerifyChecksum(checksums, "croc_v1.0.0_macOS-64bit.tar.gz", archive), "no checksum")
+}
+
+func TestExtractBinary(t *testing.T) {
+	binary := []byte("#!/bin/sh\necho croc\n")
+
+	var tarGz bytes.Buffer
+	gz := gzip.NewWriter(&tarGz)
+	tw := tar.NewWriter(gz)
+	for _, file := range []struct {
+		name    string
+		content []byte
+	}{{"LICENSE", []byte("license")}, {"croc", binary}} {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: file.name, Mode: 0o755, Size: int64(len(file.content)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write(file.content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	extracted, err := ExtractBinary("croc.tar.gz", tarGz.Bytes(), "croc")
+	require.NoError(t, err)
+	assert.Equal(t, binary, extracted)
+	_, err = ExtractBinary("croc.tar.gz", tarGz.Bytes(), "croc.exe")
+	assert.Error(t, err)
+
+	var zipped bytes.Buffer
+	zw := zip.NewWriter(&zipped)
+	w, err := zw.Create("croc.exe")
+	require.NoError(t, err)
+	_, err = w.Write(binary)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	extracted, err = ExtractBinary("croc.zip", zipped.Bytes(), "croc.exe")
+	require.NoError(t, err)
+	assert.Equal(t, binary, extracted)
+
+	_, err = ExtractBinary("croc.rar", zipped.Bytes(), "croc.exe")
+	assert.Error(t, err)
+}
+
+func TestReplace(t *testing.T) {
+	executable := filepath.Join(t.TempDir(), "croc")
+	require.NoError(t, os.WriteFile(executable, []byte("old"), 0o750))
+
+	require.NoError(t, Replace(executable, []byte("new")))
+	content, err := os.ReadFile(executable)
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(content))
+	info, err := os.Stat(executable)
+	require.NoError(t, err)
+	if info.Mode().Perm() != 0o666 {
+		// Windows only reports whether files are writable
+		assert.Equal(t, os.FileMode(0o750), info.Mode().Perm())
+	}
+	RemoveOld(executable)
+	entries, err := os.ReadDir(filepath.Dir(executable))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "temporary files are removed")
+
+	assert.Error(t, Replace(filepath.Join(t.TempDir(), "missing"), []byte("new")))
+}
+
+// encodePublicKey and sign produce minisign keys and signatures for tests.
+func encodePublicKey(keyID []byte, publicKey ed25519.PublicKey) string {
+	return base64.StdEncoding.EncodeToString(append(append([]byte(minisignLegacy), keyID...), publicKey...))
+}
+
+func sign(privateKey ed25519.PrivateKey, keyID []byte, algorithm string, message []byte, comment string) []byte {
+	signed := message
+	if algorithm == minisignPrehashed {
+		hash := blake2b.Sum512(message)
+		signed = hash[:]
+	}
+	signature := ed25519.Sign(privateKey, signed)
+	global := ed25519.Sign(privateKey, append(bytes.Clone(signature), comment...))
+	return []byte("untrusted comment: signature from minisign secret key\n" +
+		base64.StdEncoding.EncodeToString(append(append([]byte(algorithm), keyID...), signature...)) + "\n" +
+		"trusted comment: " + comment + "\n" +
+		base64.StdEncoding.EncodeToString(global) + "\n")
+}
@@ -0,0 +1,328 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/schollz/croc/v11/src/diskusage"
+)
+
+// AdminPrefix is the path under which AdminHandler serves operator requests.
+const AdminPrefix = "/api/v1/store/admin"
+
+// AdminUsage summarizes quota and reservation accounting.
+type AdminUsage struct {
+	ReservedBytes  int64          `json:"reservedBytes"`
+	MaxTotalBytes  int64          `json:"maxTotalBytes"`
+	MinFreeBytes   int64          `json:"minFreeBytes"`
+	AvailableBytes int64          `json:"availableBytes"`
+	ActiveUploads  int            `json:"activeUploads"`
+	Transfers      map[string]int `json:"transfers"`
+}
+
+// AdminTransfer describes one stored transfer without capabilities,
+// verifiers, notification details, or anything derived from plaintext.
+type AdminTransfer struct {
+	ID                 string    `json:"id"`
+	State              string    `json:"state"`
+	CreatedAt          time.Time `json:"createdAt"`
+	ExpiresAt          time.Time `json:"expiresAt,omitzero"`
+	ClaimExpiresAt     time.Time `json:"claimExpiresAt,omitzero"`
+	TombstoneExpiresAt time.Time `json:"tombstoneExpiresAt,omitzero"`
+	CiphertextBytes    int64     `json:"ciphertextBytes"`
+	ReservedBytes      int64     `json:"reservedBytes"`
+	DownloadsTotal     int       `json:"downloadsTotal"`
+	DownloadsRemaining int       `json:"downloadsRemaining"`
+}
+
+// AdminWindow reports creation rate-limit state for one client IP.
+type AdminWindow struct {
+	IP            string    `json:"ip"`
+	Created       int       `json:"created"`
+	Limit         int       `json:"limit"`
+	ActiveUploads int       `json:"activeUploads"`
+	ResetsAt      time.Time `json:"resetsAt,omitzero"`
+}
+
+// AdminNetwork maps an admin listen address to a network and address. A
+// "unix:" prefix or an absolute path selects a UNIX socket.
+func AdminNetwork(address string) (string, string) {
+	address = strings.TrimSpace(address)
+	if path, ok := strings.CutPrefix(address, "unix:"); ok {
+		return "unix", path
+	}
+	if filepath.IsAbs(address) {
+		return "unix", address
+	}
+	return "tcp", address
+}
+
+// ListenAdmin listens on an admin address. TCP addresses must be loopback,
+// because the admin API has no authentication. UNIX sockets replace a stale
+// socket file, refuse one that another process still serves, and are
+// restricted to the owning user.
+func ListenAdmin(address string) (net.Listener, error) {
+	network, address := AdminNetwork(address)
+	if address == "" {
+		return nil, errors.New("stored-transfer admin address cannot be empty")
+	}
+	if network != "unix" {
+		if !loopbackAddress(address) {
+			return nil, fmt.Errorf("stored-transfer admin address %s is not a loopback address; use 127.0.0.1, [::1], or a unix: socket", address)
+		}
+		return net.Listen(network, address)
+	}
+	if info, err := os.Lstat(address); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", address)
+		}
+		if conn, dialErr := net.DialTimeout("unix", address, time.Second); dialErr == nil {
+			conn.Close()
+			return nil, fmt.Errorf("stored-transfer admin socket %s is in use", address)
+		}
+		if err = os.Remove(address); err != nil {
+			return nil, err
+		}
+	}
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Chmod(address, 0o600); err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// loopbackAddress reports whether a TCP listen address binds only loopback.
+func loopbackAddress(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip, err := netip.ParseAddr(host)
+	return err == nil && ip.Unmap().IsLoopback()
+}
+
+// AdminHandler serves the operator API. It must only be exposed on a private
+// listener; it performs no authentication of its own.
+func (s *Service) AdminHandler() http.Handler {
+	return http.HandlerFunc(s.serveAdmin)
+}
+
+func (s *Service) serveAdmin(response http.ResponseWriter, request *http.Request) {
+	response.Header().Set("Cache-Control", "no-store")
+	response.Header().Set("X-Content-Type-Options", "nosniff")
+	relative, ok := strings.CutPrefix(request.URL.Path, AdminPrefix)
+	if !ok {
+		http.NotFound(response, request)
+		return
+	}
+	segments := strings.Split(strings.Trim(relative, "/"), "/")
+	switch {
+	case len(segments) == 1 && segments[0] == "usage":
+		if request.Method != http.MethodGet {
+			methodNotAllowed(response)
+			return
+		}
+		usage, err := s.AdminUsage()
+		if err != nil {
+			http.Error(response, "could not read stored transfers", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(response, http.StatusOK, usage)
+	case len(segments) == 1 && segments[0] == "transfers":
+		if request.Method != http.MethodGet {
+			methodNotAllowed(response)
+			return
+		}
+		transfers, err := s.AdminTransfers(request.URL.Query().Get("state"))
+		if err != nil {
+			http.Error(response, "could not read stored transfers", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(response, http.StatusOK, transfers)
+	case len(segments) == 3 && segments[0] == "transfers" && segments[2] == "expire":
+		if request.Method != http.MethodPost {
+			methodNotAllowed(response)
+			return
+		}
+		switch err := s.ForceExpire(segments[1]); {
+		case errors.Is(err, os.ErrNotExist):
+			http.NotFound(response, request)
+		case errors.Is(err, errAlreadyTerminal):
+			http.Error(response, err.Error(), http.StatusConflict)
+		case err != nil:
+			http.Error(response, "could not expire stored transfer", http.StatusInternalServerError)
+		default:
+			response.WriteHeader(http.StatusNoContent)
+		}
+	case len(segments) == 1 && segments[0] == "windows":
+		if request.Method != http.MethodGet {
+			methodNotAllowed(response)
+			return
+		}
+		writeJSON(response, http.StatusOK, s.AdminWindows())
+	default:
+		http.NotFound(response, request)
+	}
+}
+
+var errAlreadyTerminal = errors.New("stored transfer is already consumed, revoked, or expired")
+
+func (s *Service) readAllMetadata() ([]*metadata, error) {
+	var all []*metadata
+	err := filepath.WalkDir(s.settings().Root, func(path string, entry os.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if entry.IsDir() || entry.Name() != "metadata.json" {
+			return nil
+		}
+		meta, readErr := readMetadata(path)
+		if errors.Is(readErr, os.ErrNotExist) {
+			return nil
+		}
+		if readErr != nil {
+			return readErr
+		}
+		all = append(all, meta)
+		return nil
+	})
+	return all, err
+}
+
+// AdminUsage reports quota usage and the number of transfers in each state.
+func (s *Service) AdminUsage() (AdminUsage, error) {
+	all, err := s.readAllMetadata()
+	if err != nil {
+		return AdminUsage{}, err
+	}
+	usage := AdminUsage{
+		MaxTotalBytes: s.settings().MaxTotalBytes,
+		MinFreeBytes:  s.settings().MinFreeBytes,
+		Transfers:     make(map[string]int),
+	}
+	for _, meta := range all {
+		usage.Transfers[string(meta.State)]++
+	}
+	if disk := diskusage.NewDiskUsage(s.settings().Root); disk != nil {
+		usage.AvailableBytes = int64(disk.Available())
+	}
+	s.mu.Lock()
+	usage.ReservedBytes = s.reservedBytes
+	for _, count := range s.activeUploads {
+		usage.ActiveUploads += count
+	}
+	s.mu.Unlock()
+	return usage, nil
+}
+
+// AdminTransfers lists transfers oldest first, optionally limited to one state.
+func (s *Service) AdminTransfers(filter string) ([]AdminTransfer, error) {
+	all, err := s.readAllMetadata()
+	if err != nil {
+		return nil, err
+	}
+	transfers := make([]AdminTransfer, 0, len(all))
+	for _, meta := range all {
+		if filter != "" && string(meta.State) != filter {
+			continue
+		}
+		ciphertext := meta.ManifestBytes
+		for _, size := range meta.ChunkBytes {
+			ciphertext += size
+		}
+		expiresAt := meta.ExpiresAt
+		if meta.State == stateUploading {
+			expiresAt = meta.UploadExpiresAt
+		}
+		transfers = append(transfers, AdminTransfer{
+			ID:                 meta.ID,
+			State:              string(meta.State),
+			CreatedAt:          meta.CreatedAt,
+			ExpiresAt:          expiresAt,
+			ClaimExpiresAt:     meta.ClaimExpiresAt,
+			TombstoneExpiresAt: meta.TombstoneExpiresAt,
+			CiphertextBytes:    ciphertext,
+			ReservedBytes:      meta.ReservedBytes,
+			DownloadsTotal:     meta.DownloadsTotal,
+			DownloadsRemaining: meta.DownloadsRemaining,
+		})
+	}
+	slices.SortFunc(transfers, func(left, right AdminTransfer) int {
+		if compared := left.CreatedAt.Compare(right.CreatedAt); compared != 0 {
+			return compared
+		}
+		return strings.Compare(left.ID, right.ID)
+	})
+	return transfers, nil
+}
+
+// ForceExpire expires an uploading or available transfer immediately and
+// deletes its ciphertext, exactly as if its lifetime had elapsed.
+func (s *Service) ForceExpire(id string) error {
+	lock := s.lockFor(id)
+	lock.Lock()
+	defer lock.Unlock()
+	meta, err := s.load(id)
+	if err != nil {
+		return err
+	}
+	if isTombstone(meta.State) {
+		return errAlreadyTerminal
+	}
+	return s.tombstone(meta, stateExpired)
+}
+
+// AdminWindows reports creation windows and active uploads by client IP.
+func (s *Service) AdminWindows() []AdminWindow {
+	cutoff := s.now().Add(-time.Hour)
+	s.mu.Lock()
+	byIP := make(map[string]*AdminWindow)
+	entry := func(ip string) *AdminWindow {
+		if byIP[ip] == nil {
+			byIP[ip] = &AdminWindow{IP: ip, Limit: s.settings().CreatePerHour}
+		}
+		return byIP[ip]
+	}
+	for ip, window := range s.creationWindows {
+		for _, created := range window.times {
+			if !created.After(cutoff) {
+				continue
+			}
+			current := entry(ip)
+			current.Created++
+			if current.ResetsAt.IsZero() || created.Add(time.Hour).Before(current.ResetsAt) {
+				current.ResetsAt = created.Add(time.Hour)
+			}
+		}
+	}
+	for ip, count := range s.activeUploads {
+		if count > 0 {
+			entry(ip).ActiveUploads = count
+		}
+	}
+	s.mu.Unlock()
+
+	windows := make([]AdminWindow, 0, len(byIP))
+	for _, window := range byIP {
+		windows = append(windows, *window)
+	}
+	slices.SortFunc(windows, func(left, right AdminWindow) int {
+		return strings.Compare(left.IP, right.IP)
+	})
+	return windows
+}
@@ -0,0 +1,169 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func adminRequest(t *testing.T, service *Service, method, target string) *httptest.ResponseRecorder {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	service.AdminHandler().ServeHTTP(recorder, httptest.NewRequest(method, target, nil))
+	return recorder
+}
+
+func TestAdminListsTransfersAndUsageWithoutCapabilities(t *testing.T) {
+	clock := &testClock{now: time.Unix(1_700_000_000, 0).UTC()}
+	service := newTestService(t, clock)
+	available := createFixture(t, service)
+	clock.Add(time.Minute)
+	uploading := createUploadingFixtureOptions(t, service, 0, nil)
+
+	recorder := adminRequest(t, service, http.MethodGet, AdminPrefix+"/transfers")
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	assert.Equal(t, "no-store", recorder.Header().Get("Cache-Control"))
+	for _, secret := range []string{available.redeemToken, available.uploadToken, uploading.uploadToken, "Verifier"} {
+		assert.NotContains(t, recorder.Body.String(), secret)
+	}
+	var transfers []AdminTransfer
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &transfers))
+	require.Len(t, transfers, 2)
+	assert.Equal(t, available.id, transfers[0].ID)
+	assert.Equal(t, "available", transfers[0].State)
+	assert.Equal(t, int64(len(available.manifest)+len(available.chunk)), transfers[0].CiphertextBytes)
+	assert.Equal(t, available.expiresAt, transfers[0].ExpiresAt)
+	assert.Equal(t, uploading.id, transfers[1].ID)
+	assert.Equal(t, "uploading", transfers[1].State)
+
+	recorder = adminRequest(t, service, http.MethodGet, AdminPrefix+"/transfers?state=uploading")
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &transfers))
+	require.Len(t, transfers, 1)
+	assert.Equal(t, uploading.id, transfers[0].ID)
+
+	recorder = adminRequest(t, service, http.MethodGet, AdminPrefix+"/usage")
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	var usage AdminUsage
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &usage))
+	assert.Equal(t, service.reservedBytes, usage.ReservedBytes)
+	assert.Positive(t, usage.ReservedBytes)
+	assert.Equal(t, int64(4<<20), usage.MaxTotalBytes)
+	assert.Equal(t, 1, usage.ActiveUploads)
+	assert.Equal(t, map[string]int{"available": 1, "uploading": 1}, usage.Transfers)
+}
+
+func TestAdminForceExpireDeletesCiphertextAndReleasesQuota(t *testing.T) {
+	clock := &testClock{now: time.Unix(1_700_000_000, 0).UTC()}
+	service := newTestService(t, clock)
+	fixture := createFixture(t, service)
+
+	target := fmt.Sprintf("%s/transfers/%s/expire", AdminPrefix, fixture.id)
+	assert.Equal(t, http.StatusMethodNotAllowed, adminRequest(t, service, http.MethodGet, target).Code)
+	recorder := adminRequest(t, service, http.MethodPost, target)
+	require.Equal(t, http.StatusNoContent, recorder.Code, recorder.Body.String())
+	assert.Zero(t, service.reservedBytes)
+	_, err := os.Stat(service.manifestPath(fixture.id))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	claim := request(t, service, http.MethodPost,
+		fmt.Sprintf("/api/v1/store/transfers/%s/claim", fixture.id), fixture.redeemToken, nil)
+	assert.Equal(t, http.StatusGone, claim.Code)
+	assert.Equal(t, http.StatusConflict, adminRequest(t, service, http.MethodPost, target).Code)
+	assert.Equal(t, http.StatusNotFound, adminRequest(t, service, http.MethodPost,
+		AdminPrefix+"/transfers/not-an-id/expire").Code)
+}
+
+func TestAdminWindowsReportCreationRateState(t *testing.T) {
+	start := time.Unix(1_700_000_000, 0).UTC()
+	clock := &testClock{now: start}
+	service := newTestService(t, clock)
+	createFixture(t, service)
+	clock.Add(10 * time.Minute)
+	createUploadingFixtureOptions(t, service, 0, nil)
+
+	recorder := adminRequest(t, service, http.MethodGet, AdminPrefix+"/windows")
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	var windows []AdminWindow
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &windows))
+	require.Len(t, windows, 1)
+	assert.Equal(t, AdminWindow{
+		IP:            "192.0.2.1",
+		Created:       2,
+		Limit:         100,
+		ActiveUploads: 1,
+		ResetsAt:      start.Add(time.Hour),
+	}, windows[0])
+
+	clock.Add(time.Hour)
+	windows = service.AdminWindows()
+	require.Len(t, windows, 1)
+	assert.Zero(t, windows[0].Created)
+	assert.Equal(t, 1, windows[0].ActiveUploads)
+}
+
+func TestListenAdminUnixSocket(t *testing.T) {
+	clock := &testClock{now: time.Unix(1_700_000_000, 0).UTC()}
+	service := newTestService(t, clock)
+	path := filepath.Join(t.TempDir(), "admin.sock")
+	network, address := AdminNetwork("unix:" + path)
+	assert.Equal(t, "unix", network)
+	assert.Equal(t, path, address)
+	network, _ = AdminNetwork("127.0.0.1:9015")
+	assert.Equal(t, "tcp", network)
+
+	listener, err := ListenAdmin(path)
+	require.NoError(t, err)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	server := &http.Server{Handler: service.AdminHandler()}
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(func() { _ = server.Close() })
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	response, err := client.Get("http://admin" + AdminPrefix + "/usage")
+	require.NoError(t, err)
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode, string(body))
+
+	_, err = ListenAdmin("unix:" + path)
+	assert.ErrorContains(t, err, "in use")
+
+	stalePath := filepath.Join(t.TempDir(), "stale.sock")
+	stale, err := net.Listen("unix", stalePath)
+	require.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	require.NoError(t, stale.Close())
+	listener, err = ListenAdmin("unix:" + stalePath)
+	require.NoError(t, err)
+	require.NoError(t, listener.Close())
+}
+
+func TestListenAdminRefusesNonLoopbackTCP(t *testing.T) {
+	for _, address := range []string{":0", "0.0.0.0:0", "[::]:0", "192.0.2.1:0", "admin.example.com:0", "127.0.0.1"} {
+		_, err := ListenAdmin(address)
+		assert.ErrorContains(t, err, "not a loopback address", address)
+	}
+	for _, address := range []string{"127.0.0.1:0", "localhost:0"} {
+		listener, err := ListenAdmin(address)
+		require.NoError(t, err, address)
+		require.NoError(t, listener.Close())
+	}
+}
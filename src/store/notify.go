@@ -0,0 +1,298 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/schollz/croc/v11/src/storecrypto"
+	log "github.com/schollz/logger"
+)
+
+const (
+	maxNotifyURLBytes     = 2048
+	maxNotifyContextBytes = 4 << 10
+	notifyQueueSize       = 256
+	notifyWorkers         = 8
+	notifyBacklog         = 32
+	notifyAttempts        = 5
+	notifyRequestTimeout  = 10 * time.Second
+	notifyBackoff         = 2 * time.Second
+)
+
+type notifyEvent string
+
+const (
+	eventDownloaded notifyEvent = "downloaded"
+	eventConsumed   notifyEvent = "consumed"
+	eventRevoked    notifyEvent = "revoked"
+	eventExpired    notifyEvent = "expired"
+)
+
+// notifyDeclaration is supplied by the sender at creation time. Context is
+// sealed by the sender with storecrypto.SealNotification and is only echoed.
+type notifyDeclaration struct {
+	URL     string `json:"url"`
+	Context string `json:"context,omitempty"`
+}
+
+// notification is the JSON body POSTed to a sender's notification URL.
+type notification struct {
+	Protocol           string      `json:"protocol"`
+	ID                 string      `json:"id"`
+	Event              notifyEvent `json:"event"`
+	At                 time.Time   `json:"at"`
+	DownloadsRemaining int         `json:"downloadsRemaining"`
+	Context            string      `json:"context,omitempty"`
+}
+
+type notifyDelivery struct {
+	url  string
+	body notification
+}
+
+// notifier delivers notifications from a bounded queue so that state
+// transitions never wait on a sender's endpoint. A pool of workers sends
+// them, at most one at a time to each destination host, so that a slow or
+// unreachable endpoint holds up only its own notifications.
+type notifier struct {
+	client    *http.Client
+	deliver   chan notifyDelivery
+	backoff   time.Duration
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+
+	mu sync.Mutex
+	// busy holds the notifications waiting for each destination host that a
+	// worker is delivering to.
+	busy map[string][]notifyDelivery
+}
+
+func newNotifier(allowPrivate bool, roots *x509.CertPool) *notifier {
+	dialer := &net.Dialer{Timeout: notifyRequestTimeout}
+	if !allowPrivate {
+		// Checking the dialed address rather than the URL host also covers DNS
+		// names that resolve to internal addresses.
+		dialer.Control = func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip, err := netip.ParseAddr(host)
+			if err != nil || !publicAddress(ip) {
+				return fmt.Errorf("notification destination %s is not a public address", host)
+			}
+			return nil
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	n := &notifier{
+		client: &http.Client{
+			Timeout: notifyRequestTimeout,
+			Transport: &http.Transport{
+				DialContext:         dialer.DialContext,
+				TLSClientConfig:     &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12},
+				TLSHandshakeTimeout: notifyRequestTimeout,
+				MaxIdleConns:        16,
+				IdleConnTimeout:     time.Minute,
+			},
+			CheckRedirect: func(_ *http.Request, _ []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		deliver: make(chan notifyDelivery, notifyQueueSize),
+		backoff: notifyBackoff,
+		ctx:     ctx,
+		cancel:  cancel,
+		busy:    make(map[string][]notifyDelivery),
+	}
+	n.wg.Add(notifyWorkers)
+	for range notifyWorkers {
+		go n.run()
+	}
+	return n
+}
+
+func publicAddress(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !ip.IsLoopback() &&
+		!ip.IsLinkLocalUnicast() && !netip.MustParsePrefix("100.64.0.0/10").Contains(ip)
+}
+
+// validNotifyDeclaration accepts absolute HTTPS URLs and a bounded sealed
+// context.
+func validNotifyDeclaration(declaration *notifyDeclaration) bool {
+	if declaration == nil {
+		return true
+	}
+	if len(declaration.URL) > maxNotifyURLBytes || len(declaration.Context) > maxNotifyContextBytes {
+		return false
+	}
+	parsed, err := url.Parse(declaration.URL)
+	if err != nil || parsed.Host == "" || parsed.User != nil || parsed.Fragment != "" {
+		return false
+	}
+	if parsed.Scheme != "https" {
+		return false
+	}
+	if declaration.Context != "" {
+		sealed, decodeErr := storecrypto.DecodeBase64URL(declaration.Context)
+		if decodeErr != nil || len(sealed) < 29 {
+			return false
+		}
+	}
+	return true
+}
+
+func (n *notifier) close() {
+	if n == nil {
+		return
+	}
+	n.closeOnce.Do(func() {
+		n.cancel()
+		n.wg.Wait()
+	})
+}
+
+func (n *notifier) enqueue(delivery notifyDelivery) {
+	if n == nil {
+		return
+	}
+	select {
+	case <-n.ctx.Done():
+	case n.deliver <- delivery:
+	default:
+		log.Debugf("dropping stored-transfer %s notification because the queue is full", delivery.body.Event)
+	}
+}
+
+func (n *notifier) run() {
+	defer n.wg.Done()
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case delivery := <-n.deliver:
+			n.deliverTo(delivery)
+		}
+	}
+}
+
+// deliverTo sends delivery, and then every notification queued for its
+// destination meanwhile, unless another worker is already delivering to the
+// destination; then delivery waits in that worker's backlog.
+func (n *notifier) deliverTo(delivery notifyDelivery) {
+	destination := notifyDestination(delivery.url)
+	n.mu.Lock()
+	if backlog, ok := n.busy[destination]; ok {
+		if len(backlog) < notifyBacklog {
+			n.busy[destination] = append(backlog, delivery)
+		} else {
+			log.Debugf("dropping stored-transfer %s notification because %s is backlogged", delivery.body.Event, destination)
+		}
+		n.mu.Unlock()
+		return
+	}
+	n.busy[destination] = nil
+	n.mu.Unlock()
+	for {
+		if err := n.send(delivery); err != nil && n.ctx.Err() == nil {
+			log.Debugf("stored-transfer %s notification failed: %v", delivery.body.Event, err)
+		}
+		n.mu.Lock()
+		backlog := n.busy[destination]
+		if len(backlog) == 0 || n.ctx.Err() != nil {
+			delete(n.busy, destination)
+			n.mu.Unlock()
+			return
+		}
+		delivery = backlog[0]
+		n.busy[destination] = backlog[1:]
+		n.mu.Unlock()
+	}
+}
+
+// notifyDestination returns the host that rawURL delivers to.
+func notifyDestination(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return strings.ToLower(parsed.Host)
+}
+
+// send retries network failures, 429, and 5xx responses with exponential
+// backoff. Other responses are final.
+func (n *notifier) send(delivery notifyDelivery) error {
+	body, err := json.Marshal(delivery.body)
+	if err != nil {
+		return err
+	}
+	var last error
+	for attempt := 0; attempt < notifyAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-n.ctx.Done():
+				return n.ctx.Err()
+			case <-time.After(n.backoff << (attempt - 1)):
+			}
+		}
+		request, requestErr := http.NewRequestWithContext(n.ctx, http.MethodPost, delivery.url, bytes.NewReader(body))
+		if requestErr != nil {
+			return requestErr
+		}
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set("X-Croc-Event", string(delivery.body.Event))
+		response, doErr := n.client.Do(request)
+		if doErr != nil {
+			last = doErr
+			continue
+		}
+		response.Body.Close()
+		switch {
+		case response.StatusCode >= 200 && response.StatusCode < 300:
+			return nil
+		case response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500:
+			last = fmt.Errorf("notification endpoint returned HTTP %d", response.StatusCode)
+		default:
+			return fmt.Errorf("notification endpoint returned HTTP %d", response.StatusCode)
+		}
+	}
+	if last == nil {
+		last = errors.New("notification was not delivered")
+	}
+	return last
+}
+
+// notify queues an event for a transfer that registered a notification URL.
+// Callers hold the transfer lock and pass the metadata after the transition.
+func (s *Service) notify(meta *metadata, event notifyEvent) {
+	if s.notifier == nil || meta.NotifyURL == "" {
+		return
+	}
+	s.notifier.enqueue(notifyDelivery{
+		url: meta.NotifyURL,
+		body: notification{
+			Protocol:           storecrypto.Protocol,
+			ID:                 meta.ID,
+			Event:              event,
+			At:                 s.now().UTC(),
+			DownloadsRemaining: meta.DownloadsRemaining,
+			Context:            meta.NotifyContext,
+		},
+	})
+}
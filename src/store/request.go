@@ -0,0 +1,284 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/schollz/croc/v11/src/storecrypto"
+)
+
+// maxRequestTransfers bounds how many uploads one file request records.
+const maxRequestTransfers = 256
+
+// fileRequest is the persistent record of a recipient-initiated request. It
+// holds only the owner capability verifier and the IDs of uploaded transfers;
+// the request's public key travels in the link and is never stored.
+type fileRequest struct {
+	Version       int       `json:"version"`
+	ID            string    `json:"id"`
+	CreatedAt     time.Time `json:"createdAt"`
+	ExpiresAt     time.Time `json:"expiresAt"`
+	OwnerVerifier string    `json:"ownerVerifier"`
+	Transfers     []string  `json:"transfers,omitempty"`
+}
+
+type requestDeclaration struct {
+	Protocol       string `json:"protocol"`
+	OwnerVerifier  string `json:"ownerVerifier"`
+	ExpiresSeconds *int64 `json:"expiresSeconds,omitempty"`
+}
+
+type requestResponse struct {
+	ID        string    `json:"id"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// uploadRequest links a new transfer to a file request. WrappedKey is the
+// transfer key sealed to the request's public key.
+type uploadRequest struct {
+	ID         string `json:"id"`
+	WrappedKey string `json:"wrappedKey"`
+}
+
+type requestedTransfer struct {
+	ID                 string    `json:"id"`
+	WrappedKey         string    `json:"wrappedKey"`
+	ExpiresAt          time.Time `json:"expiresAt"`
+	DownloadsRemaining int       `json:"downloadsRemaining"`
+}
+
+func (s *Service) requestPath(id string) string {
+	return filepath.Join(s.settings().Root, "requests", id[:2], id+".json")
+}
+
+func (s *Service) loadRequest(id string) (*fileRequest, error) {
+	if !validID(id) {
+		return nil, os.ErrNotExist
+	}
+	bytes, err := os.ReadFile(s.requestPath(id))
+	if err != nil {
+		return nil, err
+	}
+	var record fileRequest
+	if err = json.Unmarshal(bytes, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (s *Service) saveRequest(record *fileRequest) error {
+	return writeJSONAtomic(s.requestPath(record.ID), record)
+}
+
+func validUploadRequest(input *uploadRequest) bool {
+	if input == nil {
+		return true
+	}
+	wrapped, err := storecrypto.DecodeBase64URL(input.WrappedKey)
+	return validID(input.ID) && err == nil && len(wrapped) == storecrypto.WrappedKeySize
+}
+
+// attachToRequest records a new transfer under its file request.
+func (s *Service) attachToRequest(requestID, transferID string) (int, string) {
+	lock := s.lockFor(requestID)
+	lock.Lock()
+	defer lock.Unlock()
+	record, err := s.loadRequest(requestID)
+	if err != nil {
+		return http.StatusNotFound, "file request not found"
+	}
+	if !record.ExpiresAt.After(s.now()) {
+		return http.StatusGone, "file request has expired"
+	}
+	if len(record.Transfers) >= maxRequestTransfers {
+		return http.StatusConflict, "file request has received the maximum number of uploads"
+	}
+	record.Transfers = append(record.Transfers, transferID)
+	if err = s.saveRequest(record); err != nil {
+		return http.StatusInternalServerError, "could not update file request"
+	}
+	return 0, ""
+}
+
+// serveRequests serves /api/v1/store/requests and its descendants.
+func (s *Service) serveRequests(response http.ResponseWriter, request *http.Request) {
+	relative := strings.TrimPrefix(request.URL.Path, "/api/v1/store/requests")
+	if relative == "" || relative == "/" {
+		if request.Method == http.MethodPost {
+			s.createFileRequest(response, request)
+			return
+		}
+		methodNotAllowed(response)
+		return
+	}
+	segments := strings.Split(strings.Trim(relative, "/"), "/")
+	if !validID(segments[0]) {
+		http.NotFound(response, request)
+		return
+	}
+	switch {
+	case len(segments) == 2 && segments[1] == "transfers" && request.Method == http.MethodGet:
+		s.listRequestTransfers(response, request, segments[0])
+	case len(segments) == 1 && request.Method == http.MethodDelete:
+		s.closeRequest(response, request, segments[0])
+	default:
+		methodNotAllowed(response)
+	}
+}
+
+func (s *Service) createFileRequest(response http.ResponseWriter, request *http.Request) {
+	if !s.allowCreation(s.clientIP(request), false) {
+		response.Header().Set("Retry-After", "3600")
+		http.Error(response, "stored-transfer creation rate exceeded", http.StatusTooManyRequests)
+		return
+	}
+	var input requestDeclaration
+	if err := decodeJSON(request, &input, 4<<10); err != nil {
+		http.Error(response, "invalid file request", http.StatusBadRequest)
+		return
+	}
+	expiresSeconds := int64(DefaultExpiration / time.Second)
+	if input.ExpiresSeconds != nil {
+		expiresSeconds = *input.ExpiresSeconds
+	}
+	ownerVerifier, err := storecrypto.DecodeBase64URL(input.OwnerVerifier)
+	if input.Protocol != storecrypto.Protocol || err != nil || len(ownerVerifier) != sha256.Size ||
+		expiresSeconds < int64(MinExpiration/time.Second) || expiresSeconds > MaxExpirationSeconds {
+		http.Error(response, "invalid file request", http.StatusBadRequest)
+		return
+	}
+	if maximum := int64(s.settings().MaxExpiration / time.Second); maximum > 0 && expiresSeconds > maximum {
+		expiresSeconds = maximum
+	}
+	id, err := storecrypto.GenerateTransferID()
+	if err != nil {
+		http.Error(response, "could not create file request", http.StatusInternalServerError)
+		return
+	}
+	now := s.now()
+	record := &fileRequest{
+		Version:       storecrypto.Version,
+		ID:            id,
+		CreatedAt:     now,
+		ExpiresAt:     now.Add(time.Duration(expiresSeconds) * time.Second),
+		OwnerVerifier: input.OwnerVerifier,
+	}
+	if err = s.saveRequest(record); err != nil {
+		http.Error(response, "could not persist file request", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(response, http.StatusCreated, requestResponse{ID: id, ExpiresAt: record.ExpiresAt})
+}
+
+func (s *Service) listRequestTransfers(response http.ResponseWriter, request *http.Request, id string) {
+	lock := s.lockFor(id)
+	lock.Lock()
+	record, err := s.loadRequest(id)
+	lock.Unlock()
+	if err != nil || !capabilityMatches(bearer(request), record.OwnerVerifier) {
+		http.NotFound(response, request)
+		return
+	}
+	now := s.now()
+	transfers := make([]requestedTransfer, 0, len(record.Transfers))
+	for _, transferID := range record.Transfers {
+		transferLock := s.lockFor(transferID)
+		transferLock.Lock()
+		meta, loadErr := s.load(transferID)
+		transferLock.Unlock()
+		if loadErr != nil || meta.RequestID != id ||
+			(meta.State != stateAvailable && meta.State != stateClaimed) || !meta.ExpiresAt.After(now) {
+			continue
+		}
+		transfers = append(transfers, requestedTransfer{
+			ID:                 meta.ID,
+			WrappedKey:         meta.WrappedKey,
+			ExpiresAt:          meta.ExpiresAt,
+			DownloadsRemaining: meta.DownloadsRemaining,
+		})
+	}
+	response.Header().Set("X-Croc-Expires-At", record.ExpiresAt.UTC().Format(time.RFC3339))
+	writeJSON(response, http.StatusOK, transfers)
+}
+
+// closeRequest stops accepting uploads. Completed uploads remain available
+// until they are downloaded or expire, but can no longer be listed.
+func (s *Service) closeRequest(response http.ResponseWriter, request *http.Request, id string) {
+	lock := s.lockFor(id)
+	lock.Lock()
+	defer lock.Unlock()
+	record, err := s.loadRequest(id)
+	if err != nil || !capabilityMatches(bearer(request), record.OwnerVerifier) {
+		http.NotFound(response, request)
+		return
+	}
+	if err = os.Remove(s.requestPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		http.Error(response, "could not close file request", http.StatusInternalServerError)
+		return
+	}
+	response.WriteHeader(http.StatusNoContent)
+}
+
+// sweepRequests removes expired file requests once none of their uploads
+// remain available, so a requester can still collect late uploads.
+func (s *Service) sweepRequests(now time.Time) error {
+	var ids []string
+	err := filepath.WalkDir(filepath.Join(s.settings().Root, "requests"), func(path string, entry os.DirEntry, walkErr error) error {
+		if errors.Is(walkErr, os.ErrNotExist) {
+			return filepath.SkipDir
+		}
+		if walkErr != nil {
+			return walkErr
+		}
+		if id, ok := strings.CutSuffix(entry.Name(), ".json"); ok && !entry.IsDir() && validID(id) {
+			ids = append(ids, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		lock := s.lockFor(id)
+		lock.Lock()
+		record, loadErr := s.loadRequest(id)
+		lock.Unlock()
+		if errors.Is(loadErr, os.ErrNotExist) {
+			continue
+		}
+		if loadErr != nil {
+			return loadErr
+		}
+		// Expired requests accept no further uploads, so the transfer list
+		// cannot change once this check starts.
+		if record.ExpiresAt.After(now) || s.requestHasLiveTransfers(record) {
+			continue
+		}
+		lock.Lock()
+		loadErr = os.Remove(s.requestPath(id))
+		lock.Unlock()
+		if loadErr != nil && !errors.Is(loadErr, os.ErrNotExist) {
+			return loadErr
+		}
+	}
+	return nil
+}
+
+func (s *Service) requestHasLiveTransfers(record *fileRequest) bool {
+	for _, transferID := range record.Transfers {
+		lock := s.lockFor(transferID)
+		lock.Lock()
+		meta, err := s.load(transferID)
+		lock.Unlock()
+		if err == nil && !isTombstone(meta.State) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,172 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/schollz/croc/v11/src/storecrypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createTestRequest(t *testing.T, service *Service, expiresSeconds *int64) (requestResponse, string) {
+	t.Helper()
+	owner, err := randomCapability()
+	require.NoError(t, err)
+	body, err := json.Marshal(requestDeclaration{
+		Protocol:       storecrypto.Protocol,
+		OwnerVerifier:  storecrypto.EncodeBase64URL(storecrypto.CapabilityVerifier(owner)),
+		ExpiresSeconds: expiresSeconds,
+	})
+	require.NoError(t, err)
+	recorder := request(t, service, http.MethodPost, "/api/v1/store/requests", "", body)
+	require.Equal(t, http.StatusCreated, recorder.Code, recorder.Body.String())
+	var created requestResponse
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &created))
+	return created, storecrypto.EncodeBase64URL(owner)
+}
+
+func testUploadRequest(id string) *uploadRequest {
+	return &uploadRequest{
+		ID:         id,
+		WrappedKey: storecrypto.EncodeBase64URL(bytes.Repeat([]byte{5}, storecrypto.WrappedKeySize)),
+	}
+}
+
+func listRequest(t *testing.T, service *Service, id, owner string) []requestedTransfer {
+	t.Helper()
+	recorder := request(t, service, http.MethodGet,
+		fmt.Sprintf("/api/v1/store/requests/%s/transfers", id), owner, nil)
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	var transfers []requestedTransfer
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &transfers))
+	return transfers
+}
+
+func TestFileRequestCollectsCompletedUploads(t *testing.T) {
+	start := time.Unix(1_700_000_000, 0).UTC()
+	clock := &testClock{now: start}
+	service := newTestService(t, clock)
+	created, owner := createTestRequest(t, service, nil)
+	assert.Equal(t, start.Add(DefaultExpiration), created.ExpiresAt)
+
+	uploading := createUploadingFixtureForRequest(t, service, 0, nil, testUploadRequest(created.ID))
+	assert.Empty(t, listRequest(t, service, created.ID, owner), "incomplete uploads are not listed")
+
+	recorder := request(t, service, http.MethodPost,
+		fmt.Sprintf("/api/v1/store/transfers/%s/complete", uploading.id), uploading.uploadToken, nil)
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	transfers := listRequest(t, service, created.ID, owner)
+	require.Len(t, transfers, 1)
+	assert.Equal(t, uploading.id, transfers[0].ID)
+	assert.Equal(t, testUploadRequest(created.ID).WrappedKey, transfers[0].WrappedKey)
+	assert.Equal(t, 1, transfers[0].DownloadsRemaining)
+
+	for _, token := range []string{"", uploading.redeemToken, storecrypto.EncodeBase64URL(bytes.Repeat([]byte{6}, 32))} {
+		recorder = request(t, service, http.MethodGet,
+			fmt.Sprintf("/api/v1/store/requests/%s/transfers", created.ID), token, nil)
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+	}
+
+	claim := claimTransfer(t, service, uploading)
+	require.Equal(t, http.StatusNoContent, commitTransfer(t, service, uploading, claim.ClaimToken).Code)
+	assert.Empty(t, listRequest(t, service, created.ID, owner), "consumed uploads are not listed")
+}
+
+func TestFileRequestRejectsUnknownExpiredAndClosedRequests(t *testing.T) {
+	clock := &testClock{now: time.Unix(1_700_000_000, 0).UTC()}
+	service := newTestService(t, clock)
+
+	unknown := storecrypto.EncodeBase64URL(bytes.Repeat([]byte{7}, storecrypto.TransferIDLen))
+	body, err := json.Marshal(createRequest{
+		Protocol:       storecrypto.Protocol,
+		ManifestBytes:  64,
+		ChunkBytes:     []int64{33},
+		RedeemVerifier: storecrypto.EncodeBase64URL(bytes.Repeat([]byte{1}, 32)),
+		DeclaredFiles:  1,
+		PlaintextBytes: 5,
+		Request:        testUploadRequest(unknown),
+	})
+	require.NoError(t, err)
+	recorder := request(t, service, http.MethodPost, "/api/v1/store/transfers", "", body)
+	assert.Equal(t, http.StatusNotFound, recorder.Code, recorder.Body.String())
+	assert.Zero(t, service.reservedBytes)
+	assert.Zero(t, service.activeUploads["192.0.2.1"])
+
+	invalid := testUploadRequest(unknown)
+	invalid.WrappedKey = "short"
+	body, err = json.Marshal(createRequest{Protocol: storecrypto.Protocol, Request: invalid})
+	require.NoError(t, err)
+	recorder = request(t, service, http.MethodPost, "/api/v1/store/transfers", "", body)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	seconds := int64(time.Hour / time.Second)
+	created, owner := createTestRequest(t, service, &seconds)
+	clock.Add(time.Hour)
+	body, err = json.Marshal(createRequest{
+		Protocol:       storecrypto.Protocol,
+		ManifestBytes:  64,
+		ChunkBytes:     []int64{33},
+		RedeemVerifier: storecrypto.EncodeBase64URL(bytes.Repeat([]byte{1}, 32)),
+		DeclaredFiles:  1,
+		PlaintextBytes: 5,
+		Request:        testUploadRequest(created.ID),
+	})
+	require.NoError(t, err)
+	recorder = request(t, service, http.MethodPost, "/api/v1/store/transfers", "", body)
+	assert.Equal(t, http.StatusGone, recorder.Code, recorder.Body.String())
+
+	second, secondOwner := createTestRequest(t, service, nil)
+	recorder = request(t, service, http.MethodDelete, "/api/v1/store/requests/"+second.ID, owner, nil)
+	assert.Equal(t, http.StatusNotFound, recorder.Code, "another request's owner cannot close it")
+	recorder = request(t, service, http.MethodDelete, "/api/v1/store/requests/"+second.ID, secondOwner, nil)
+	assert.Equal(t, http.StatusNoContent, recorder.Code)
+	recorder = request(t, service, http.MethodGet,
+		fmt.Sprintf("/api/v1/store/requests/%s/transfers", second.ID), secondOwner, nil)
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
+
+func TestSweepKeepsExpiredRequestsWithLiveUploads(t *testing.T) {
+	clock := &testClock{now: time.Unix(1_700_000_000, 0).UTC()}
+	service := newTestService(t, clock)
+	seconds := int64(time.Hour / time.Second)
+	created, owner := createTestRequest(t, service, &seconds)
+	fixture := createUploadingFixtureForRequest(t, service, 0, nil, testUploadRequest(created.ID))
+	recorder := request(t, service, http.MethodPost,
+		fmt.Sprintf("/api/v1/store/transfers/%s/complete", fixture.id), fixture.uploadToken, nil)
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	empty, _ := createTestRequest(t, service, &seconds)
+
+	clock.Add(2 * time.Hour)
+	require.NoError(t, service.Sweep())
+	assert.Len(t, listRequest(t, service, created.ID, owner), 1)
+	_, err := service.loadRequest(empty.ID)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	clock.Add(DefaultExpiration)
+	require.NoError(t, service.Sweep())
+	_, err = service.loadRequest(created.ID)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestFileRequestsShareTheCreationRateLimit(t *testing.T) {
+	clock := &testClock{now: time.Unix(1_700_000_000, 0).UTC()}
+	service, err := New(Config{
+		Root:            t.TempDir(),
+		MinFreeBytes:    1,
+		CreatePerHour:   1,
+		Now:             clock.Time,
+		DisableRootLock: true,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, service.Close()) })
+	createTestRequest(t, service, nil)
+	assert.Zero(t, service.activeUploads["192.0.2.1"], "requests do not hold upload slots")
+	recorder := request(t, service, http.MethodPost, "/api/v1/store/requests", "", []byte(`{}`))
+	assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+}
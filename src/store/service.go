@@ -7,6 +7,7 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"crypto/subtle"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -21,6 +22,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/schollz/croc/v11/src/diskusage"
@@ -71,6 +73,15 @@ type Config struct {
 	Now              func() time.Time
 	CleanupInterval  time.Duration
 	DisableRootLock  bool
+	// Notifications lets senders register a URL that receives a POST when a
+	// transfer is downloaded, consumed, revoked, or expires.
+	Notifications bool
+	// NotifyAllowPrivate permits notification deliveries to loopback and
+	// private addresses. It is intended for tests and closed networks.
+	NotifyAllowPrivate bool
+	// NotifyRootCAs replaces the system roots used to verify notification
+	// endpoints, for closed networks with their own certificate authority.
+	NotifyRootCAs *x509.CertPool
 }
 
 // PublicConfig is safe to expose to web clients.
@@ -104,6 +115,10 @@ type metadata struct {
 	DownloadsRemaining int       `json:"downloadsRemaining,omitempty"`
 	CommittedClaims    []string  `json:"committedClaims,omitempty"`
 	ClientIP           string    `json:"clientIP,omitempty"`
+	NotifyURL          string    `json:"notifyURL,omitempty"`
+	NotifyContext      string    `json:"notifyContext,omitempty"`
+	RequestID          string    `json:"requestID,omitempty"`
+	WrappedKey         string    `json:"wrappedKey,omitempty"`
 }
 
 type creationWindow struct {
@@ -112,7 +127,8 @@ type creationWindow struct {
 
 // Service owns a filesystem store and serves its versioned HTTP API.
 type Service struct {
-	config Config
+	// config is replaced as a whole by Reconfigure.
+	config atomic.Pointer[Config]
 	now    func() time.Time
 
 	mu               sync.Mutex
@@ -122,17 +138,23 @@ type Service struct {
 	activeUploads    map[string]int
 	creationWindows  map[string]*creationWindow
 	rootLock         *rootLock
+	notifier         *notifier
 }
 
 type createRequest struct {
-	Protocol       string  `json:"protocol"`
-	ManifestBytes  int64   `json:"manifestBytes"`
-	ChunkBytes     []int64 `json:"chunkBytes"`
-	RedeemVerifier string  `json:"redeemVerifier"`
-	DeclaredFiles  int     `json:"files"`
-	PlaintextBytes int64   `json:"plaintextBytes"`
-	Downloads      *int    `json:"downloads,omitempty"`
-	ExpiresSeconds *int64  `json:"expiresSeconds,omitempty"`
+	// ID is set only when a transfer is imported from another origin, whose
+	// sealed objects are bound to their original identifier.
+	ID             string             `json:"id,omitempty"`
+	Protocol       string             `json:"protocol"`
+	ManifestBytes  int64              `json:"manifestBytes"`
+	ChunkBytes     []int64            `json:"chunkBytes"`
+	RedeemVerifier string             `json:"redeemVerifier"`
+	DeclaredFiles  int                `json:"files"`
+	PlaintextBytes int64              `json:"plaintextBytes"`
+	Downloads      *int               `json:"downloads,omitempty"`
+	ExpiresSeconds *int64             `json:"expiresSeconds,omitempty"`
+	Notify         *notifyDeclaration `json:"notify,omitempty"`
+	Request        *uploadRequest     `json:"request,omitempty"`
 }
 
 type createResponse struct {
@@ -154,19 +176,66 @@ type completeResponse struct {
 
 // New creates or opens a filesystem store and reconstructs quota state.
 func New(config Config) (*Service, error) {
+	config, err := normalizeConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	root := config.Root
+	if err = os.MkdirAll(root, 0o700); err != nil {
+		return nil, fmt.Errorf("create stored-transfer directory: %w", err)
+	}
+	if err = os.Chmod(root, 0o700); err != nil {
+		return nil, fmt.Errorf("secure stored-transfer directory: %w", err)
+	}
+
+	var lock *rootLock
+	if !config.DisableRootLock {
+		lock, err = acquireRootLock(root)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	service := &Service{
+		now:              config.Now,
+		transferLockSeed: maphash.MakeSeed(),
+		activeUploads:    make(map[string]int),
+		creationWindows:  make(map[string]*creationWindow),
+		rootLock:         lock,
+	}
+	service.config.Store(&config)
+	if err = service.recover(); err != nil {
+		if lock != nil {
+			_ = lock.Close()
+		}
+		return nil, err
+	}
+	if err = service.Sweep(); err != nil {
+		if lock != nil {
+			_ = lock.Close()
+		}
+		return nil, err
+	}
+	if config.Notifications {
+		service.notifier = newNotifier(config.NotifyAllowPrivate, config.NotifyRootCAs)
+	}
+	return service, nil
+}
+
+func normalizeConfig(config Config) (Config, error) {
 	if strings.TrimSpace(config.Root) == "" {
-		return nil, errors.New("stored-transfer directory cannot be empty")
+		return Config{}, errors.New("stored-transfer directory cannot be empty")
 	}
 	root, err := filepath.Abs(config.Root)
 	if err != nil {
-		return nil, fmt.Errorf("resolve stored-transfer directory: %w", err)
+		return Config{}, fmt.Errorf("resolve stored-transfer directory: %w", err)
 	}
 	config.Root = root
 	if config.MaxTransferBytes <= 0 {
 		config.MaxTransferBytes = DefaultMaxTransfer
 	}
 	if config.MaxTransferBytes > int64(MaxChunkObjects)*storecrypto.ChunkSize {
-		return nil, fmt.Errorf(
+		return Config{}, fmt.Errorf(
 			"stored-transfer byte limit cannot exceed %d bytes",
 			int64(MaxChunkObjects)*storecrypto.ChunkSize,
 		)
@@ -175,7 +244,7 @@ func New(config Config) (*Service, error) {
 		config.MaxTotalBytes = DefaultMaxTotal
 	}
 	if config.MinFreeBytes < 0 {
-		return nil, errors.New("stored-transfer free-space reserve cannot be negative")
+		return Config{}, errors.New("stored-transfer free-space reserve cannot be negative")
 	}
 	if config.MinFreeBytes == 0 {
 		config.MinFreeBytes = DefaultMinFree
@@ -184,16 +253,16 @@ func New(config Config) (*Service, error) {
 		config.MaxFiles = DefaultMaxFiles
 	}
 	if config.MaxFiles > storecrypto.MaxFiles {
-		return nil, fmt.Errorf("stored-transfer file limit cannot exceed %d", storecrypto.MaxFiles)
+		return Config{}, fmt.Errorf("stored-transfer file limit cannot exceed %d", storecrypto.MaxFiles)
 	}
 	if config.MaxDownloads <= 0 {
 		config.MaxDownloads = DefaultMaxDownloads
 	}
 	if config.MaxExpiration < 0 {
-		return nil, errors.New("stored-transfer maximum expiration cannot be negative")
+		return Config{}, errors.New("stored-transfer maximum expiration cannot be negative")
 	}
 	if config.MaxExpiration > 0 && config.MaxExpiration < MinExpiration {
-		return nil, fmt.Errorf("stored-transfer maximum expiration must be at least %s", MinExpiration)
+		return Config{}, fmt.Errorf("stored-transfer maximum expiration must be at least %s", MinExpiration)
 	}
 	if config.CreatePerHour <= 0 {
 		config.CreatePerHour = DefaultCreatePerHour
@@ -207,55 +276,50 @@ func New(config Config) (*Service, error) {
 	if config.CleanupInterval <= 0 {
 		config.CleanupInterval = cleanupInterval
 	}
-	if err = os.MkdirAll(root, 0o700); err != nil {
-		return nil, fmt.Errorf("create stored-transfer directory: %w", err)
-	}
-	if err = os.Chmod(root, 0o700); err != nil {
-		return nil, fmt.Errorf("secure stored-transfer directory: %w", err)
-	}
+	return config, nil
+}
 
-	var lock *rootLock
-	if !config.DisableRootLock {
-		lock, err = acquireRootLock(root)
-		if err != nil {
-			return nil, err
-		}
+// Close stops notification delivery and releases the exclusive store-root
+// lock. Queued notifications are dropped.
+func (s *Service) Close() error {
+	s.notifier.close()
+	if s.rootLock == nil {
+		return nil
 	}
+	return s.rootLock.Close()
+}
 
-	service := &Service{
-		config:           config,
-		now:              config.Now,
-		transferLockSeed: maphash.MakeSeed(),
-		activeUploads:    make(map[string]int),
-		creationWindows:  make(map[string]*creationWindow),
-		rootLock:         lock,
+// Reconfigure replaces the limits and trusted proxies of a running store.
+// Transfers that were already created keep the limits they were accepted
+// under. The directory and notification delivery cannot change.
+func (s *Service) Reconfigure(config Config) error {
+	current := s.settings()
+	config.Now = current.Now
+	config.CleanupInterval = current.CleanupInterval
+	config.DisableRootLock = current.DisableRootLock
+	config.NotifyAllowPrivate = current.NotifyAllowPrivate
+	config.NotifyRootCAs = current.NotifyRootCAs
+	config, err := normalizeConfig(config)
+	if err != nil {
+		return err
 	}
-	if err = service.recover(); err != nil {
-		if lock != nil {
-			_ = lock.Close()
-		}
-		return nil, err
+	if config.Root != current.Root {
+		return errors.New("the stored-transfer directory cannot change while running")
 	}
-	if err = service.Sweep(); err != nil {
-		if lock != nil {
-			_ = lock.Close()
-		}
-		return nil, err
+	if config.Notifications != current.Notifications {
+		return errors.New("stored-transfer notifications cannot change while running")
 	}
-	return service, nil
+	s.config.Store(&config)
+	return nil
 }
 
-// Close releases the exclusive store-root lock.
-func (s *Service) Close() error {
-	if s.rootLock == nil {
-		return nil
-	}
-	return s.rootLock.Close()
+func (s *Service) settings() *Config {
+	return s.config.Load()
 }
 
 // RunCleanup sweeps expired data until the context is cancelled.
 func (s *Service) RunCleanup(ctx context.Context) {
-	ticker := time.NewTicker(s.config.CleanupInterval)
+	ticker := time.NewTicker(s.settings().CleanupInterval)
 	defer ticker.Stop()
 	for {
 		select {
@@ -270,21 +334,21 @@ func (s *Service) RunCleanup(ctx context.Context) {
 // PublicConfig returns browser-safe limits.
 func (s *Service) PublicConfig() PublicConfig {
 	effectiveDefault := DefaultExpiration
-	if s.config.MaxExpiration > 0 && s.config.MaxExpiration < effectiveDefault {
-		effectiveDefault = s.config.MaxExpiration
+	if s.settings().MaxExpiration > 0 && s.settings().MaxExpiration < effectiveDefault {
+		effectiveDefault = s.settings().MaxExpiration
 	}
 	return PublicConfig{
 		Enabled:           true,
-		MaxTransferBytes:  s.config.MaxTransferBytes,
-		MaxFiles:          s.config.MaxFiles,
-		MaxDownloads:      s.config.MaxDownloads,
+		MaxTransferBytes:  s.settings().MaxTransferBytes,
+		MaxFiles:          s.settings().MaxFiles,
+		MaxDownloads:      s.settings().MaxDownloads,
 		ExpiresSeconds:    int64(effectiveDefault / time.Second),
-		MaxExpiresSeconds: int64(s.config.MaxExpiration / time.Second),
+		MaxExpiresSeconds: int64(s.settings().MaxExpiration / time.Second),
 	}
 }
 
 func (s *Service) transferDir(id string) string {
-	return filepath.Join(s.config.Root, id[:2], id)
+	return filepath.Join(s.settings().Root, id[:2], id)
 }
 
 func (s *Service) metadataPath(id string) string {
@@ -312,7 +376,7 @@ func (s *Service) lockFor(id string) *sync.Mutex {
 }
 
 func (s *Service) recover() error {
-	return filepath.WalkDir(s.config.Root, func(path string, entry os.DirEntry, walkErr error) error {
+	return filepath.WalkDir(s.settings().Root, func(path string, entry os.DirEntry, walkErr error) error {
 		if walkErr != nil {
 			return walkErr
 		}
@@ -334,11 +398,12 @@ func (s *Service) recover() error {
 	})
 }
 
-// Sweep expires incomplete and available transfers and removes old tombstones.
+// Sweep expires incomplete and available transfers, removes old tombstones,
+// and drops expired file requests.
 func (s *Service) Sweep() error {
 	now := s.now()
 	var ids []string
-	err := filepath.WalkDir(s.config.Root, func(path string, entry os.DirEntry, walkErr error) error {
+	err := filepath.WalkDir(s.settings().Root, func(path string, entry os.DirEntry, walkErr error) error {
 		if walkErr != nil {
 			return walkErr
 		}
@@ -386,7 +451,7 @@ func (s *Service) Sweep() error {
 			return readErr
 		}
 	}
-	return nil
+	return s.sweepRequests(now)
 }
 
 func isTombstone(value state) bool {
@@ -483,6 +548,9 @@ func (s *Service) tombstone(meta *metadata, terminal state) error {
 		meta.ReservedBytes = reservation
 		return err
 	}
+	if !wasUploading {
+		s.notify(meta, notifyEvent(terminal))
+	}
 	purgeErr := s.purgeCiphertext(meta.ID)
 	s.mu.Lock()
 	s.reservedBytes -= reservation
@@ -560,7 +628,7 @@ func (s *Service) clientIP(request *http.Request) string {
 	}
 	remote, err := netip.ParseAddr(strings.Trim(remoteHost, "[]"))
 	if err == nil {
-		for _, prefix := range s.config.TrustedProxies {
+		for _, prefix := range s.settings().TrustedProxies {
 			if prefix.Contains(remote) {
 				forwarded := strings.TrimSpace(strings.Split(request.Header.Get("X-Forwarded-For"), ",")[0])
 				if address, parseErr := netip.ParseAddr(forwarded); parseErr == nil {
@@ -574,12 +642,14 @@ func (s *Service) clientIP(request *http.Request) string {
 	return remoteHost
 }
 
-func (s *Service) allowCreation(ip string) bool {
+// allowCreation consumes one of ip's hourly creations. Uploads also take an
+// active-upload slot; file requests do not.
+func (s *Service) allowCreation(ip string, upload bool) bool {
 	now := s.now()
 	cutoff := now.Add(-time.Hour)
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if s.activeUploads[ip] >= s.config.MaxActiveUploads {
+	if upload && s.activeUploads[ip] >= s.settings().MaxActiveUploads {
 		return false
 	}
 	window := s.creationWindows[ip]
@@ -594,22 +664,24 @@ func (s *Service) allowCreation(ip string) bool {
 		}
 	}
 	window.times = recent
-	if len(recent) >= s.config.CreatePerHour {
+	if len(recent) >= s.settings().CreatePerHour {
 		return false
 	}
 	window.times = append(window.times, now)
-	s.activeUploads[ip]++
+	if upload {
+		s.activeUploads[ip]++
+	}
 	return true
 }
 
 func (s *Service) reserve(bytes int64) bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if bytes <= 0 || bytes > s.config.MaxTotalBytes-s.reservedBytes {
+	if bytes <= 0 || bytes > s.settings().MaxTotalBytes-s.reservedBytes {
 		return false
 	}
-	usage := diskusage.NewDiskUsage(s.config.Root)
-	if usage == nil || int64(usage.Available()) < bytes+s.config.MinFreeBytes {
+	usage := diskusage.NewDiskUsage(s.settings().Root)
+	if usage == nil || int64(usage.Available()) < bytes+s.settings().MinFreeBytes {
 		return false
 	}
 	s.reservedBytes += bytes
@@ -625,7 +697,8 @@ func (s *Service) unreserve(bytes int64) {
 	s.mu.Unlock()
 }
 
-// ServeHTTP serves /api/v1/store/transfers and its descendants.
+// ServeHTTP serves /api/v1/store/transfers, /api/v1/store/requests, and
+// their descendants.
 func (s *Service) ServeHTTP(response http.ResponseWriter, request *http.Request) {
 	response.Header().Set("Cache-Control", "no-store")
 	response.Header().Set("Referrer-Policy", "no-referrer")
@@ -635,6 +708,10 @@ func (s *Service) ServeHTTP(response http.ResponseWriter, request *http.Request)
 		return
 	}
 
+	if strings.HasPrefix(request.URL.Path, "/api/v1/store/requests") {
+		s.serveRequests(response, request)
+		return
+	}
 	relative := strings.TrimPrefix(request.URL.Path, "/api/v1/store/transfers")
 	segments := strings.Split(strings.Trim(relative, "/"), "/")
 	if relative == "" || relative == "/" {
@@ -727,7 +804,7 @@ func decodeJSON(request *http.Request, value any, max int64) error {
 
 func (s *Service) create(response http.ResponseWriter, request *http.Request) {
 	ip := s.clientIP(request)
-	if !s.allowCreation(ip) {
+	if !s.allowCreation(ip, true) {
 		response.Header().Set("Retry-After", "3600")
 		http.Error(response, "stored-transfer creation rate exceeded", http.StatusTooManyRequests)
 		return
@@ -760,19 +837,25 @@ func (s *Service) create(response http.ResponseWriter, request *http.Request) {
 		http.Error(response, "invalid stored-transfer declaration", http.StatusBadRequest)
 		return
 	}
-	if maximum := int64(s.config.MaxExpiration / time.Second); maximum > 0 && expiresSeconds > maximum {
+	if maximum := int64(s.settings().MaxExpiration / time.Second); maximum > 0 && expiresSeconds > maximum {
 		expiresSeconds = maximum
 	}
 	redeemVerifier, err := storecrypto.DecodeBase64URL(input.RedeemVerifier)
 	if input.Protocol != storecrypto.Protocol || err != nil || len(redeemVerifier) != sha256.Size ||
 		input.ManifestBytes < 29 || input.ManifestBytes > MaxManifestBytes ||
-		input.DeclaredFiles < 1 || input.DeclaredFiles > s.config.MaxFiles ||
-		input.PlaintextBytes < 0 || input.PlaintextBytes > s.config.MaxTransferBytes ||
+		input.DeclaredFiles < 1 || input.DeclaredFiles > s.settings().MaxFiles ||
+		input.PlaintextBytes < 0 || input.PlaintextBytes > s.settings().MaxTransferBytes ||
 		len(input.ChunkBytes) > MaxChunkObjects ||
-		downloads < 1 || downloads > s.config.MaxDownloads {
+		downloads < 1 || downloads > s.settings().MaxDownloads ||
+		!validNotifyDeclaration(input.Notify) || !validUploadRequest(input.Request) ||
+		(input.ID != "" && (!validID(input.ID) || input.Request != nil)) {
 		http.Error(response, "invalid stored-transfer declaration", http.StatusBadRequest)
 		return
 	}
+	if input.Notify != nil && s.notifier == nil {
+		http.Error(response, "stored-transfer notifications are disabled", http.StatusBadRequest)
+		return
+	}
 	var reserved = input.ManifestBytes
 	var estimatedPlain int64
 	for _, size := range input.ChunkBytes {
@@ -783,7 +866,7 @@ func (s *Service) create(response http.ResponseWriter, request *http.Request) {
 		reserved += size
 		estimatedPlain += size - 28
 	}
-	if estimatedPlain != input.PlaintextBytes || estimatedPlain > s.config.MaxTransferBytes {
+	if estimatedPlain != input.PlaintextBytes || estimatedPlain > s.settings().MaxTransferBytes {
 		http.Error(response, "stored transfer exceeds the configured limit", http.StatusRequestEntityTooLarge)
 		return
 	}
@@ -798,16 +881,33 @@ func (s *Service) create(response http.ResponseWriter, request *http.Request) {
 		}
 	}()
 
-	id, err := storecrypto.GenerateTransferID()
-	if err != nil {
-		http.Error(response, "could not create stored transfer", http.StatusInternalServerError)
-		return
+	id := input.ID
+	if id == "" {
+		if id, err = storecrypto.GenerateTransferID(); err != nil {
+			http.Error(response, "could not create stored transfer", http.StatusInternalServerError)
+			return
+		}
+	} else {
+		// An imported ID must never replace a live transfer or a tombstone.
+		lock := s.lockFor(id)
+		lock.Lock()
+		defer lock.Unlock()
+		if _, loadErr := s.load(id); !errors.Is(loadErr, os.ErrNotExist) {
+			http.Error(response, "stored transfer already exists", http.StatusConflict)
+			return
+		}
 	}
 	uploadToken, err := randomCapability()
 	if err != nil {
 		http.Error(response, "could not create stored transfer", http.StatusInternalServerError)
 		return
 	}
+	if input.Request != nil {
+		if status, message := s.attachToRequest(input.Request.ID, id); status != 0 {
+			http.Error(response, message, status)
+			return
+		}
+	}
 	now := s.now()
 	meta := &metadata{
 		Version:            storecrypto.Version,
@@ -825,6 +925,14 @@ func (s *Service) create(response http.ResponseWriter, request *http.Request) {
 		ExpiresSeconds:     expiresSeconds,
 		ClientIP:           ip,
 	}
+	if input.Notify != nil {
+		meta.NotifyURL = input.Notify.URL
+		meta.NotifyContext = input.Notify.Context
+	}
+	if input.Request != nil {
+		meta.RequestID = input.Request.ID
+		meta.WrappedKey = input.Request.WrappedKey
+	}
 	if err = s.save(meta); err != nil {
 		http.Error(response, "could not persist stored transfer", http.StatusInternalServerError)
 		return
@@ -1153,9 +1261,12 @@ func (s *Service) commit(response http.ResponseWriter, request *http.Request, id
 			http.Error(response, "could not consume stored transfer", http.StatusInternalServerError)
 			return
 		}
-	} else if err = s.save(meta); err != nil {
-		http.Error(response, "could not commit stored download", http.StatusInternalServerError)
-		return
+	} else {
+		if err = s.save(meta); err != nil {
+			http.Error(response, "could not commit stored download", http.StatusInternalServerError)
+			return
+		}
+		s.notify(meta, eventDownloaded)
 	}
 	remainingDownloadsHeader(response, meta.DownloadsRemaining)
 	response.WriteHeader(http.StatusNoContent)
@@ -255,6 +255,13 @@ func createFixtureOptions(t *testing.T, service *Service, downloads int, expires
 }
 
 func createUploadingFixtureOptions(t *testing.T, service *Service, downloads int, expiresSeconds *int64) storedFixture {
+	t.Helper()
+	return createUploadingFixtureForRequest(t, service, downloads, expiresSeconds, nil)
+}
+
+func createUploadingFixtureForRequest(
+	t *testing.T, service *Service, downloads int, expiresSeconds *int64, fileRequest *uploadRequest,
+) storedFixture {
 	t.Helper()
 	key, err := storecrypto.GenerateKey()
 	require.NoError(t, err)
@@ -285,6 +292,7 @@ func createUploadingFixtureOptions(t *testing.T, service *Service, downloads int
 		DeclaredFiles:  1,
 		PlaintextBytes: 5,
 		ExpiresSeconds: expiresSeconds,
+		Request:        fileRequest,
 	}
 	if downloads > 0 {
 		input.Downloads = &downloads
@@ -596,11 +604,64 @@ func TestStoredDownloadDeclarationDefaultsAndLimits(t *testing.T) {
 	zero := 0
 	response = create(&zero)
 	assert.Equal(t, http.StatusBadRequest, response.Code)
-	overLimit := service.config.MaxDownloads + 1
+	overLimit := service.settings().MaxDownloads + 1
 	response = create(&overLimit)
 	assert.Equal(t, http.StatusBadRequest, response.Code)
 }
 
+func TestReconfigureKeepsInFlightUploads(t *testing.T) {
+	clock := &testClock{now: time.Unix(1_700_000_000, 0).UTC()}
+	service := newTestService(t, clock)
+	uploading := createUploadingFixtureOptions(t, service, 5, nil)
+
+	config := *service.settings()
+	config.MaxDownloads = 2
+	require.NoError(t, service.Reconfigure(config))
+	assert.Equal(t, 2, service.PublicConfig().MaxDownloads)
+
+	recorder := request(t, service, http.MethodPost,
+		fmt.Sprintf("/api/v1/store/transfers/%s/complete", uploading.id),
+		uploading.uploadToken, nil)
+	assert.Equal(t, http.StatusOK, recorder.Code, "an upload finishes under the limits it was created with")
+	createFixtureDownloads(t, service, 2)
+
+	moved := config
+	moved.Root = t.TempDir()
+	assert.Error(t, service.Reconfigure(moved))
+	invalid := config
+	invalid.MaxFiles = storecrypto.MaxFiles + 1
+	assert.Error(t, service.Reconfigure(invalid))
+	assert.Equal(t, 10, service.PublicConfig().MaxFiles, "a rejected configuration is not applied")
+}
+
+func TestImportedTransferIDIsValidatedAndUnique(t *testing.T) {
+	clock := &testClock{now: time.Unix(1_700_000_000, 0).UTC()}
+	service := newTestService(t, clock)
+	redeem := storecrypto.EncodeBase64URL(make([]byte, sha256.Size))
+	id, err := storecrypto.GenerateTransferID()
+	require.NoError(t, err)
+
+	create := func(id string) *httptest.ResponseRecorder {
+		body, err := json.Marshal(createRequest{
+			ID:             id,
+			Protocol:       storecrypto.Protocol,
+			ManifestBytes:  29,
+			RedeemVerifier: redeem,
+			DeclaredFiles:  1,
+		})
+		require.NoError(t, err)
+		return request(t, service, http.MethodPost, "/api/v1/store/transfers", "", body)
+	}
+
+	response := create(id)
+	require.Equal(t, http.StatusCreated, response.Code, response.Body.String())
+	var created createResponse
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &created))
+	assert.Equal(t, id, created.ID)
+	assert.Equal(t, http.StatusConflict, create(id).Code)
+	assert.Equal(t, http.StatusBadRequest, create("not-an-id").Code)
+}
+
 func TestLegacyStoredMetadataDefaultsToOneDownload(t *testing.T) {
 	clock := &testClock{now: time.Unix(1_700_000_000, 0).UTC()}
 	service := newTestService(t, clock)
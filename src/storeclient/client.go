@@ -66,17 +66,34 @@ type UploadResult struct {
 type UploadOptions struct {
 	Downloads  int
 	Expiration time.Duration
+	// NotifyURL asks the service to POST download, revoke, and expiry events
+	// to the sender. NotifyContext is sealed with NotifyKey before upload so
+	// the service only echoes ciphertext.
+	NotifyURL     string
+	NotifyKey     []byte
+	NotifyContext []byte
+	// Request uploads to a recipient's file request. The transfer key is
+	// wrapped to the request's public key so the requester can collect it.
+	Request *storecrypto.FileRequest
 }
 
 type createRequest struct {
-	Protocol       string  `json:"protocol"`
-	ManifestBytes  int64   `json:"manifestBytes"`
-	ChunkBytes     []int64 `json:"chunkBytes"`
-	RedeemVerifier string  `json:"redeemVerifier"`
-	Files          int     `json:"files"`
-	PlaintextBytes int64   `json:"plaintextBytes"`
-	Downloads      *int    `json:"downloads,omitempty"`
-	ExpiresSeconds *int64  `json:"expiresSeconds,omitempty"`
+	ID             string             `json:"id,omitempty"`
+	Protocol       string             `json:"protocol"`
+	ManifestBytes  int64              `json:"manifestBytes"`
+	ChunkBytes     []int64            `json:"chunkBytes"`
+	RedeemVerifier string             `json:"redeemVerifier"`
+	Files          int                `json:"files"`
+	PlaintextBytes int64              `json:"plaintextBytes"`
+	Downloads      *int               `json:"downloads,omitempty"`
+	ExpiresSeconds *int64             `json:"expiresSeconds,omitempty"`
+	Notify         *notifyRequest     `json:"notify,omitempty"`
+	Request        *uploadRequestBody `json:"request,omitempty"`
+}
+
+type notifyRequest struct {
+	URL     string `json:"url"`
+	Context string `json:"context,omitempty"`
 }
 
 type createResponse struct {
@@ -227,6 +244,10 @@ type preparedUpload struct {
 	manifestJSON []byte
 	chunkBytes   []int64
 	totalBytes   int64
+	// id and sealedManifest are set when mirroring: the destination must keep
+	// the source's transfer ID because every sealed object is bound to it.
+	id             string
+	sealedManifest []byte
 }
 
 // Upload hashes, encrypts, and uploads regular files. Upload sessions are not
@@ -264,24 +285,9 @@ func (c *Client) UploadWithOptions(
 	options UploadOptions,
 	callbacks Callbacks,
 ) (result UploadResult, err error) {
-	if err = validateOrigin(origin); err != nil {
+	if options, err = normalizeUploadOptions(origin, options); err != nil {
 		return result, err
 	}
-	if options.Downloads == 0 {
-		options.Downloads = 1
-	}
-	if options.Downloads < 1 {
-		return result, errors.New("stored-transfer downloads must be positive")
-	}
-	if options.Expiration == 0 {
-		options.Expiration = 24 * time.Hour
-	}
-	if options.Expiration < time.Minute {
-		return result, errors.New("stored-transfer expiration must be at least one minute")
-	}
-	if options.Expiration%time.Second != 0 {
-		return result, errors.New("stored-transfer expiration must use whole seconds")
-	}
 	prepared, err := prepareUpload(ctx, paths, callbacks)
 	if err != nil {
 		return result, err
@@ -316,6 +322,37 @@ func (c *Client) UploadWithOptions(
 	return result, nil
 }
 
+func normalizeUploadOptions(origin string, options UploadOptions) (UploadOptions, error) {
+	if err := validateOrigin(origin); err != nil {
+		return options, err
+	}
+	if options.Downloads == 0 {
+		options.Downloads = 1
+	}
+	if options.Downloads < 1 {
+		return options, errors.New("stored-transfer downloads must be positive")
+	}
+	if options.Expiration == 0 {
+		options.Expiration = 24 * time.Hour
+	}
+	if options.Expiration < time.Minute {
+		return options, errors.New("stored-transfer expiration must be at least one minute")
+	}
+	if options.Expiration%time.Second != 0 {
+		return options, errors.New("stored-transfer expiration must use whole seconds")
+	}
+	if options.NotifyURL == "" && (options.NotifyKey != nil || options.NotifyContext != nil) {
+		return options, errors.New("stored-transfer notification context requires a notification URL")
+	}
+	if options.NotifyContext != nil && len(options.NotifyKey) != storecrypto.KeySize {
+		return options, fmt.Errorf("stored-transfer notification key must be %d bytes", storecrypto.KeySize)
+	}
+	if options.Request != nil && options.Request.Origin != origin {
+		return options, errors.New("file request belongs to a different storage service")
+	}
+	return options, nil
+}
+
 func validateOrigin(origin string) error {
 	probe := storecrypto.Share{
 		Origin:    origin,
@@ -446,15 +483,44 @@ func (c *Client) createUploadWithOptions(
 		seconds := int64(options.Expiration / time.Second)
 		requestedExpiration = &seconds
 	}
+	var notify *notifyRequest
+	if options.NotifyURL != "" {
+		notify = &notifyRequest{URL: options.NotifyURL}
+		if options.NotifyContext != nil {
+			sealed, sealErr := storecrypto.SealNotification(options.NotifyKey, options.NotifyContext)
+			if sealErr != nil {
+				return UploadResult{}, sealErr
+			}
+			notify.Context = storecrypto.EncodeBase64URL(sealed)
+		}
+	}
+	var fileRequest *uploadRequestBody
+	if options.Request != nil {
+		wrapped, wrapErr := storecrypto.WrapRequestKey(*options.Request, master)
+		if wrapErr != nil {
+			return UploadResult{}, wrapErr
+		}
+		fileRequest = &uploadRequestBody{
+			ID:         options.Request.ID,
+			WrappedKey: storecrypto.EncodeBase64URL(wrapped),
+		}
+	}
+	manifestBytes := int64(len(prepared.manifestJSON) + 28)
+	if prepared.sealedManifest != nil {
+		manifestBytes = int64(len(prepared.sealedManifest))
+	}
 	create := createRequest{
+		ID:             prepared.id,
 		Protocol:       storecrypto.Protocol,
-		ManifestBytes:  int64(len(prepared.manifestJSON) + 28),
+		ManifestBytes:  manifestBytes,
 		ChunkBytes:     prepared.chunkBytes,
 		RedeemVerifier: storecrypto.EncodeBase64URL(storecrypto.CapabilityVerifier(redeem)),
-		Files:          len(prepared.files),
+		Files:          len(prepared.manifest.Files),
 		PlaintextBytes: prepared.totalBytes,
 		Downloads:      requestedDownloads,
 		ExpiresSeconds: requestedExpiration,
+		Notify:         notify,
+		Request:        fileRequest,
 	}
 	status(callbacks, "Reserving encrypted temporary storage…")
 	request, err := jsonRequest(ctx, http.MethodPost, apiURL(origin, ""), "", create)
@@ -505,6 +571,9 @@ func (c *Client) createUploadWithOptions(
 			err,
 		)
 	}
+	if prepared.id != "" && result.Share.ID != prepared.id {
+		return result, errors.New("storage service did not keep the mirrored transfer id")
+	}
 	if !validCapability(result.UploadToken) {
 		return result, errors.New("storage service returned an invalid upload capability")
 	}
@@ -750,13 +819,31 @@ func (c *Client) putWithRetry(ctx context.Context, target, token string, payload
 
 // Inspect fetches and decrypts a manifest without claiming the transfer.
 func (c *Client) Inspect(ctx context.Context, share storecrypto.Share) (storecrypto.Manifest, time.Time, error) {
-	if _, err := share.BrowserURL(); err != nil {
+	ciphertext, expires, err := c.fetchManifest(ctx, share)
+	if err != nil {
 		return storecrypto.Manifest{}, time.Time{}, err
 	}
-	redeem, err := storecrypto.RedeemCapability(share.MasterKey)
+	manifest, err := storecrypto.OpenManifest(
+		share.MasterKey,
+		share.ID,
+		ciphertext,
+		1<<40,
+	)
 	if err != nil {
 		return storecrypto.Manifest{}, time.Time{}, err
 	}
+	return manifest, expires, nil
+}
+
+// fetchManifest returns the sealed manifest and the transfer's expiry.
+func (c *Client) fetchManifest(ctx context.Context, share storecrypto.Share) ([]byte, time.Time, error) {
+	if _, err := share.BrowserURL(); err != nil {
+		return nil, time.Time{}, err
+	}
+	redeem, err := storecrypto.RedeemCapability(share.MasterKey)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
 	request, err := jsonRequest(
 		ctx,
 		http.MethodGet,
@@ -765,28 +852,19 @@ func (c *Client) Inspect(ctx context.Context, share storecrypto.Share) (storecry
 		nil,
 	)
 	if err != nil {
-		return storecrypto.Manifest{}, time.Time{}, err
+		return nil, time.Time{}, err
 	}
 	response, err := c.do(request)
 	if err != nil {
-		return storecrypto.Manifest{}, time.Time{}, err
+		return nil, time.Time{}, err
 	}
 	defer response.Body.Close()
 	ciphertext, err := io.ReadAll(io.LimitReader(response.Body, 256<<10))
 	if err != nil {
-		return storecrypto.Manifest{}, time.Time{}, err
-	}
-	manifest, err := storecrypto.OpenManifest(
-		share.MasterKey,
-		share.ID,
-		ciphertext,
-		1<<40,
-	)
-	if err != nil {
-		return storecrypto.Manifest{}, time.Time{}, err
+		return nil, time.Time{}, err
 	}
 	expires, _ := time.Parse(time.RFC3339, response.Header.Get("X-Croc-Expires-At"))
-	return manifest, expires, nil
+	return ciphertext, expires, nil
 }
 
 // Receive claims, downloads, verifies, and atomically installs all files.
@@ -1347,10 +1425,12 @@ func (c *Client) Revoke(
 	return err
 }
 
-// IsStoredValue reports whether input looks like a stored URL or CLI token.
+// IsStoredValue reports whether input looks like a stored URL, CLI token, or
+// file request collection token.
 func IsStoredValue(value string) bool {
 	value = strings.TrimSpace(value)
-	if strings.HasPrefix(value, storecrypto.Protocol+".") {
+	if strings.HasPrefix(value, storecrypto.Protocol+".") ||
+		strings.HasPrefix(value, storecrypto.CollectProtocol+".") {
 		return true
 	}
 	parsed, err := url.Parse(value)
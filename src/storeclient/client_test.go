@@ -2,6 +2,7 @@ package storeclient
 
 import (
 	"context"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"net/http"
@@ -261,6 +262,80 @@ func TestUploadRejectsExpirationBelowOneMinute(t *testing.T) {
 	assert.ErrorContains(t, err, "at least one minute")
 }
 
+func TestUploadNotificationIsDeliveredWithSealedContext(t *testing.T) {
+	events := make(chan map[string]any, 4)
+	hook := httptest.NewTLSServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		var event map[string]any
+		if err := json.NewDecoder(request.Body).Decode(&event); err == nil {
+			events <- event
+		}
+		response.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(hook.Close)
+	roots := x509.NewCertPool()
+	roots.AddCert(hook.Certificate())
+	service, err := store.New(store.Config{
+		Root:               t.TempDir(),
+		MaxTransferBytes:   8 << 20,
+		MaxTotalBytes:      32 << 20,
+		MinFreeBytes:       1,
+		CreatePerHour:      100,
+		MaxActiveUploads:   10,
+		DisableRootLock:    true,
+		Notifications:      true,
+		NotifyAllowPrivate: true,
+		NotifyRootCAs:      roots,
+	})
+	require.NoError(t, err)
+	server := httptest.NewServer(service)
+	t.Cleanup(func() {
+		server.Close()
+		require.NoError(t, service.Close())
+	})
+	client := &Client{HTTP: server.Client()}
+
+	file := filepath.Join(t.TempDir(), "notify.txt")
+	require.NoError(t, os.WriteFile(file, []byte("hello"), 0o600))
+	notifyKey, err := storecrypto.GenerateKey()
+	require.NoError(t, err)
+	result, err := client.UploadWithOptions(
+		context.Background(), server.URL, []string{file},
+		UploadOptions{NotifyURL: hook.URL, NotifyKey: notifyKey, NotifyContext: []byte("for accounting")},
+		Callbacks{},
+	)
+	require.NoError(t, err)
+	manifest, _, err := client.Inspect(context.Background(), result.Share)
+	require.NoError(t, err)
+	require.NoError(t, client.Receive(context.Background(), result.Share, manifest, t.TempDir(), Callbacks{}))
+
+	select {
+	case event := <-events:
+		assert.Equal(t, "consumed", event["event"])
+		assert.Equal(t, result.Share.ID, event["id"])
+		sealed, decodeErr := storecrypto.DecodeBase64URL(event["context"].(string))
+		require.NoError(t, decodeErr)
+		opened, openErr := storecrypto.OpenNotification(notifyKey, sealed)
+		require.NoError(t, openErr)
+		assert.Equal(t, "for accounting", string(opened))
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for stored-transfer notification")
+	}
+}
+
+func TestUploadRejectsNotificationContextWithoutURLOrKey(t *testing.T) {
+	client, origin := testStack(t)
+	_, err := client.UploadWithOptions(
+		context.Background(), origin, []string{"unused"},
+		UploadOptions{NotifyContext: []byte("label")}, Callbacks{},
+	)
+	assert.ErrorContains(t, err, "requires a notification URL")
+	_, err = client.UploadWithOptions(
+		context.Background(), origin, []string{"unused"},
+		UploadOptions{NotifyURL: "https://hooks.example.test", NotifyContext: []byte("label")}, Callbacks{},
+	)
+	assert.ErrorContains(t, err, "notification key")
+}
+
 func TestCustomExpirationFailsAgainstStrictOldServerButDefaultStillWorks(t *testing.T) {
 	type oldCreateRequest struct {
 		Protocol       string  `json:"protocol"`
@@ -0,0 +1,177 @@
+package storeclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/schollz/croc/v11/src/storecrypto"
+)
+
+// Mirror copies a stored transfer to another service without decrypting its
+// files. The sealed manifest and chunks are uploaded unchanged under the same
+// transfer ID, so the returned share keeps the source's key and differs only
+// in its origin. The source claim is released rather than committed, so
+// mirroring does not consume one of the source's downloads.
+func (c *Client) Mirror(
+	ctx context.Context,
+	share storecrypto.Share,
+	destination string,
+	options UploadOptions,
+	callbacks Callbacks,
+) (result UploadResult, err error) {
+	if _, err = share.BrowserURL(); err != nil {
+		return result, err
+	}
+	if options, err = normalizeUploadOptions(destination, options); err != nil {
+		return result, err
+	}
+	if options.Request != nil {
+		return result, errors.New("mirrored transfers cannot answer a file request")
+	}
+	if strings.TrimSuffix(destination, "/") == strings.TrimSuffix(share.Origin, "/") {
+		return result, errors.New("mirror destination is the source storage service")
+	}
+
+	status(callbacks, "Fetching encrypted manifest…")
+	sealed, _, err := c.fetchManifest(ctx, share)
+	if err != nil {
+		return result, err
+	}
+	// Only the manifest is opened, to declare the chunk sizes at the destination.
+	manifest, err := storecrypto.OpenManifest(share.MasterKey, share.ID, sealed, 1<<40)
+	if err != nil {
+		return result, err
+	}
+	refs := storecrypto.ChunkRefs(manifest)
+	prepared := preparedUpload{
+		manifest:       manifest,
+		chunkBytes:     make([]int64, len(refs)),
+		id:             share.ID,
+		sealedManifest: sealed,
+	}
+	for index, ref := range refs {
+		prepared.chunkBytes[index] = int64(ref.PlainSize + 28)
+		prepared.totalBytes += int64(ref.PlainSize)
+	}
+
+	result, err = c.createUploadWithOptions(ctx, destination, share.MasterKey, prepared, options, callbacks)
+	if err != nil {
+		var httpErr *HTTPError
+		if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusConflict {
+			return result, errors.New("the destination already holds this transfer")
+		}
+		return result, err
+	}
+	completed := false
+	defer func() {
+		if completed {
+			return
+		}
+		revokeCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = c.Revoke(revokeCtx, result.Share, result.UploadToken)
+	}()
+
+	status(callbacks, "Copying encrypted manifest…")
+	if err = c.putWithRetry(
+		ctx,
+		apiURL(result.Share.Origin, "/"+result.Share.ID+"/manifest"),
+		result.UploadToken,
+		sealed,
+	); err != nil {
+		return result, err
+	}
+	if err = c.mirrorChunks(ctx, share, result, manifest, refs, prepared.totalBytes, callbacks); err != nil {
+		return result, err
+	}
+	result.ExpiresAt, err = c.completeUpload(ctx, result, callbacks)
+	if err != nil {
+		return result, err
+	}
+	completed = true
+	status(callbacks, "Encrypted mirror complete")
+	return result, nil
+}
+
+func (c *Client) mirrorChunks(
+	ctx context.Context,
+	share storecrypto.Share,
+	result UploadResult,
+	manifest storecrypto.Manifest,
+	refs []storecrypto.ChunkRef,
+	total int64,
+	callbacks Callbacks,
+) error {
+	if len(refs) == 0 {
+		return nil
+	}
+	status(callbacks, "Claiming source transfer…")
+	claimToken, err := c.claim(ctx, share)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		releaseCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = c.releaseClaim(releaseCtx, share, claimToken)
+	}()
+
+	var sent int64
+	fileBytes := make([]int64, len(manifest.Files))
+	for _, ref := range refs {
+		chunk, chunkErr := c.getChunk(ctx, share, claimToken, ref.ObjectIndex)
+		if chunkErr != nil && expiredClaim(chunkErr) {
+			if claimToken, err = c.claim(ctx, share); err != nil {
+				return err
+			}
+			chunk, chunkErr = c.getChunk(ctx, share, claimToken, ref.ObjectIndex)
+		}
+		if chunkErr != nil {
+			return chunkErr
+		}
+		if len(chunk) != ref.PlainSize+28 {
+			return fmt.Errorf("source returned a truncated chunk %d", ref.ObjectIndex)
+		}
+		if err = c.putWithRetry(
+			ctx,
+			apiURL(result.Share.Origin, fmt.Sprintf("/%s/chunks/%d", result.Share.ID, ref.ObjectIndex)),
+			result.UploadToken,
+			chunk,
+		); err != nil {
+			return err
+		}
+		file := manifest.Files[ref.FileIndex]
+		fileBytes[ref.FileIndex] += int64(ref.PlainSize)
+		sent += int64(ref.PlainSize)
+		progress(callbacks, Progress{
+			FileIndex: ref.FileIndex, FileCount: len(manifest.Files), FileName: file.Name,
+			FileBytes: fileBytes[ref.FileIndex], FileSize: file.Size,
+			TotalBytes: sent, TotalSize: total,
+		})
+	}
+	return nil
+}
+
+// releaseClaim returns a claimed transfer to the available state without
+// consuming a download.
+func (c *Client) releaseClaim(ctx context.Context, share storecrypto.Share, claimToken string) error {
+	request, err := jsonRequest(
+		ctx,
+		http.MethodDelete,
+		apiURL(share.Origin, "/"+share.ID+"/claim"),
+		claimToken,
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+	response, err := c.do(request)
+	if err == nil {
+		response.Body.Close()
+	}
+	return err
+}
@@ -0,0 +1,79 @@
+package storeclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMirrorCopiesSealedTransferWithoutConsumingSource(t *testing.T) {
+	client, source := testStack(t)
+	_, destination := testStack(t)
+	input := t.TempDir()
+	large := filepath.Join(input, "large.bin")
+	largeBytes := make([]byte, 4<<20+91)
+	for index := range largeBytes {
+		largeBytes[index] = byte(index % 241)
+	}
+	require.NoError(t, os.WriteFile(large, largeBytes, 0o600))
+	empty := filepath.Join(input, "empty.txt")
+	require.NoError(t, os.WriteFile(empty, nil, 0o600))
+	original, err := client.Upload(context.Background(), source, []string{large, empty}, Callbacks{})
+	require.NoError(t, err)
+
+	mirrored, err := client.Mirror(
+		context.Background(),
+		original.Share,
+		destination,
+		UploadOptions{Downloads: 2},
+		Callbacks{},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, destination, mirrored.Share.Origin)
+	assert.Equal(t, original.Share.ID, mirrored.Share.ID)
+	assert.Equal(t, original.Share.MasterKey, mirrored.Share.MasterKey)
+	assert.Equal(t, 2, mirrored.Downloads)
+
+	for _, share := range []UploadResult{mirrored, original} {
+		manifest, _, inspectErr := client.Inspect(context.Background(), share.Share)
+		require.NoError(t, inspectErr)
+		output := t.TempDir()
+		require.NoError(t, client.Receive(context.Background(), share.Share, manifest, output, Callbacks{}))
+		assert.Equal(t, largeBytes, mustReadFile(t, filepath.Join(output, "large.bin")))
+	}
+
+	_, err = client.Mirror(context.Background(), original.Share, destination, UploadOptions{}, Callbacks{})
+	assert.Error(t, err)
+}
+
+func TestMirrorRejectsExistingDestinationTransfer(t *testing.T) {
+	client, source := testStack(t)
+	_, destination := testStack(t)
+	file := filepath.Join(t.TempDir(), "notes.txt")
+	require.NoError(t, os.WriteFile(file, []byte("notes"), 0o600))
+	original, err := client.UploadWithDownloads(context.Background(), source, []string{file}, 2, Callbacks{})
+	require.NoError(t, err)
+
+	mirrored, err := client.Mirror(context.Background(), original.Share, destination, UploadOptions{}, Callbacks{})
+	require.NoError(t, err)
+	_, err = client.Mirror(context.Background(), original.Share, destination, UploadOptions{}, Callbacks{})
+	require.EqualError(t, err, "the destination already holds this transfer")
+
+	// The failed attempt must not revoke the first mirror.
+	_, _, err = client.Inspect(context.Background(), mirrored.Share)
+	require.NoError(t, err)
+
+	_, err = client.Mirror(context.Background(), original.Share, source, UploadOptions{}, Callbacks{})
+	require.EqualError(t, err, "mirror destination is the source storage service")
+	require.NoError(t, client.Revoke(context.Background(), mirrored.Share, mirrored.UploadToken))
+	_, _, err = client.Inspect(context.Background(), mirrored.Share)
+	var httpErr *HTTPError
+	require.True(t, errors.As(err, &httpErr))
+	assert.Equal(t, http.StatusGone, httpErr.StatusCode)
+}
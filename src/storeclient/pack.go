@@ -0,0 +1,397 @@
+package storeclient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/schollz/croc/v11/src/receivefs"
+	"github.com/schollz/croc/v11/src/storecrypto"
+)
+
+// An archive is the stored-transfer format in one file:
+//
+//	"croc-pack-v1\n"  magic
+//	id                16-byte transfer ID, bound into the manifest and chunk AAD
+//	mode              1 byte: packModeToken or packModePassphrase
+//	salt              storecrypto.PackSaltSize bytes, zero for token archives
+//	manifest size     uint32, big endian
+//	manifest          sealed manifest
+//	chunks            every sealed chunk in storecrypto.ChunkRefs order
+//
+// Chunk sizes follow from the manifest, so nothing else is framed.
+const (
+	packMagic          = storecrypto.PackProtocol + "\n"
+	packModeToken      = 0
+	packModePassphrase = 1
+	packHeaderSize     = len(packMagic) + storecrypto.TransferIDLen + 1 + storecrypto.PackSaltSize + 4
+
+	// sealedChunkOverhead is the AES-GCM nonce and tag around every chunk.
+	sealedChunkOverhead = 12 + 16
+)
+
+// PackOptions configures Pack. An empty Passphrase seals the archive with a
+// random key that is returned as a token.
+type PackOptions struct {
+	Passphrase string
+}
+
+// PackResult describes a written archive.
+type PackResult struct {
+	ID       string
+	Token    string
+	Manifest storecrypto.Manifest
+	Size     int64
+}
+
+// Pack hashes and encrypts regular files into a self-contained archive at
+// output. The archive is written to a temporary file and renamed into place
+// only once it is complete.
+func Pack(
+	ctx context.Context,
+	paths []string,
+	output string,
+	options PackOptions,
+	callbacks Callbacks,
+) (result PackResult, err error) {
+	prepared, err := prepareUpload(ctx, paths, callbacks)
+	if err != nil {
+		return result, err
+	}
+	result.Manifest = prepared.manifest
+	result.ID, err = storecrypto.GenerateTransferID()
+	if err != nil {
+		return result, err
+	}
+	id, err := storecrypto.DecodeBase64URL(result.ID)
+	if err != nil {
+		return result, err
+	}
+	mode := byte(packModeToken)
+	salt := make([]byte, storecrypto.PackSaltSize)
+	var master []byte
+	if options.Passphrase != "" {
+		mode = packModePassphrase
+		if salt, err = storecrypto.GeneratePackSalt(); err != nil {
+			return result, err
+		}
+		master, err = storecrypto.PassphraseKey(options.Passphrase, salt)
+	} else {
+		master, err = storecrypto.GenerateKey()
+		if err == nil {
+			result.Token, err = storecrypto.PackKey{ID: result.ID, MasterKey: master}.Token()
+		}
+	}
+	if err != nil {
+		return result, err
+	}
+	sealedManifest, err := storecrypto.SealManifestJSON(master, result.ID, prepared.manifestJSON)
+	if err != nil {
+		return result, err
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(output), "."+filepath.Base(output)+".*.part")
+	if err != nil {
+		return result, err
+	}
+	tempName := temp.Name()
+	defer func() {
+		if err != nil {
+			temp.Close()
+			os.Remove(tempName)
+		}
+	}()
+	writer := bufio.NewWriterSize(temp, 1<<20)
+	header := make([]byte, 0, packHeaderSize)
+	header = append(header, packMagic...)
+	header = append(header, id...)
+	header = append(header, mode)
+	header = append(header, salt...)
+	header = binary.BigEndian.AppendUint32(header, uint32(len(sealedManifest)))
+	if _, err = writer.Write(header); err != nil {
+		return result, err
+	}
+	if _, err = writer.Write(sealedManifest); err != nil {
+		return result, err
+	}
+	if err = packFiles(ctx, writer, result.ID, master, prepared, callbacks); err != nil {
+		return result, err
+	}
+	if err = writer.Flush(); err != nil {
+		return result, err
+	}
+	if err = temp.Sync(); err != nil {
+		return result, err
+	}
+	if err = temp.Close(); err != nil {
+		return result, err
+	}
+	if err = os.Rename(tempName, output); err != nil {
+		return result, err
+	}
+	result.Size = int64(len(header) + len(sealedManifest))
+	for _, size := range prepared.chunkBytes {
+		result.Size += size
+	}
+	status(callbacks, "Encrypted archive complete")
+	return result, nil
+}
+
+func packFiles(
+	ctx context.Context,
+	writer io.Writer,
+	id string,
+	master []byte,
+	prepared preparedUpload,
+	callbacks Callbacks,
+) error {
+	chunkCipher, err := storecrypto.NewChunkCipher(master)
+	if err != nil {
+		return err
+	}
+	refs := storecrypto.ChunkRefs(prepared.manifest)
+	buffer := make([]byte, storecrypto.ChunkSize+sealedChunkOverhead)
+	nonceSize := chunkCipher.NonceSize()
+	var packed int64
+	for fileIndex, file := range prepared.files {
+		status(callbacks, "Packing "+file.manifest.Name)
+		handle, err := os.Open(file.path)
+		if err != nil {
+			return err
+		}
+		var fileBytes int64
+		for chunk := 0; chunk < file.manifest.ChunkCount; chunk++ {
+			if err = ctx.Err(); err != nil {
+				break
+			}
+			ref := refs[file.manifest.FirstChunk+chunk]
+			plaintext := buffer[nonceSize : nonceSize+ref.PlainSize]
+			if _, err = handle.ReadAt(plaintext, int64(chunk)*storecrypto.ChunkSize); err != nil {
+				break
+			}
+			var sealed []byte
+			if sealed, err = chunkCipher.SealInPlace(buffer, id, ref); err != nil {
+				break
+			}
+			if _, err = writer.Write(sealed); err != nil {
+				break
+			}
+			fileBytes += int64(ref.PlainSize)
+			packed += int64(ref.PlainSize)
+			progress(callbacks, Progress{
+				FileIndex: fileIndex, FileCount: len(prepared.files), FileName: file.manifest.Name,
+				FileBytes: fileBytes, FileSize: file.manifest.Size,
+				TotalBytes: packed, TotalSize: prepared.totalBytes,
+			})
+		}
+		closeErr := handle.Close()
+		if err != nil {
+			return err
+		}
+		if closeErr != nil {
+			return closeErr
+		}
+	}
+	return nil
+}
+
+// Archive is an opened pack file. Its manifest is available after Unlock.
+type Archive struct {
+	ID       string
+	Manifest storecrypto.Manifest
+
+	file           *os.File
+	mode           byte
+	salt           []byte
+	sealedManifest []byte
+	master         []byte
+}
+
+// OpenArchive reads and checks the header of the archive at name.
+func OpenArchive(name string) (*Archive, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	archive, err := readArchiveHeader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return archive, nil
+}
+
+func readArchiveHeader(file *os.File) (*Archive, error) {
+	header := make([]byte, packHeaderSize)
+	if _, err := io.ReadFull(file, header); err != nil || !bytes.HasPrefix(header, []byte(packMagic)) {
+		return nil, errors.New("not a croc archive")
+	}
+	fields := header[len(packMagic):]
+	archive := &Archive{
+		ID:   storecrypto.EncodeBase64URL(fields[:storecrypto.TransferIDLen]),
+		file: file,
+		mode: fields[storecrypto.TransferIDLen],
+		salt: bytes.Clone(fields[storecrypto.TransferIDLen+1 : storecrypto.TransferIDLen+1+storecrypto.PackSaltSize]),
+	}
+	if archive.mode != packModeToken && archive.mode != packModePassphrase {
+		return nil, fmt.Errorf("unsupported archive key mode %d", archive.mode)
+	}
+	manifestSize := binary.BigEndian.Uint32(fields[len(fields)-4:])
+	if manifestSize > maxJSONResponse {
+		return nil, errors.New("archive manifest is too large")
+	}
+	archive.sealedManifest = make([]byte, manifestSize)
+	if _, err := io.ReadFull(file, archive.sealedManifest); err != nil {
+		return nil, errors.New("archive is truncated")
+	}
+	return archive, nil
+}
+
+// UsesPassphrase reports whether the archive is unlocked by a passphrase
+// rather than a token.
+func (a *Archive) UsesPassphrase() bool {
+	return a.mode == packModePassphrase
+}
+
+// Unlock derives the archive key from a token or passphrase and decrypts the
+// manifest.
+func (a *Archive) Unlock(secret string) error {
+	var master []byte
+	if a.UsesPassphrase() {
+		if storecrypto.IsPackToken(secret) {
+			return errors.New("this archive is protected by a passphrase, not a token")
+		}
+		key, err := storecrypto.PassphraseKey(secret, a.salt)
+		if err != nil {
+			return err
+		}
+		master = key
+	} else {
+		key, err := storecrypto.ParsePackToken(secret)
+		if err != nil {
+			return err
+		}
+		if key.ID != a.ID {
+			return errors.New("token belongs to a different archive")
+		}
+		master = key.MasterKey
+	}
+	manifest, err := storecrypto.OpenManifest(master, a.ID, a.sealedManifest, 1<<40)
+	if err != nil {
+		if a.UsesPassphrase() {
+			return errors.New("wrong passphrase or damaged archive")
+		}
+		return err
+	}
+	stat, err := a.file.Stat()
+	if err != nil {
+		return err
+	}
+	size := int64(packHeaderSize + len(a.sealedManifest))
+	for _, ref := range storecrypto.ChunkRefs(manifest) {
+		size += int64(ref.PlainSize + sealedChunkOverhead)
+	}
+	if stat.Size() != size {
+		return fmt.Errorf("archive should be %d bytes but is %d", size, stat.Size())
+	}
+	a.Manifest = manifest
+	a.master = master
+	return nil
+}
+
+// Extract decrypts and verifies every file into outputDirectory. Each file is
+// written to a hidden part file and renamed over its destination only after
+// its SHA-256 hash matches the manifest.
+func (a *Archive) Extract(ctx context.Context, outputDirectory string, callbacks Callbacks) error {
+	if a.master == nil {
+		return errors.New("archive is locked")
+	}
+	if outputDirectory == "" {
+		outputDirectory = "."
+	}
+	absoluteOutput, err := filepath.Abs(outputDirectory)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(absoluteOutput, 0o755); err != nil {
+		return err
+	}
+	root, err := receivefs.OpenRoot(absoluteOutput)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	chunkCipher, err := storecrypto.NewChunkCipher(a.master)
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReaderSize(a.file, 1<<20)
+	if _, err = a.file.Seek(int64(packHeaderSize+len(a.sealedManifest)), io.SeekStart); err != nil {
+		return err
+	}
+	refs := storecrypto.ChunkRefs(a.Manifest)
+	buffer := make([]byte, storecrypto.ChunkSize+sealedChunkOverhead)
+	total := manifestSize(a.Manifest)
+	var extracted int64
+	for fileIndex, file := range a.Manifest.Files {
+		status(callbacks, "Unpacking "+file.Name)
+		partPath := path.Join(".", "."+file.Name+".croc-"+a.ID+".part")
+		handle, err := root.OpenFile(partPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+		if err != nil {
+			return err
+		}
+		var fileBytes int64
+		for chunk := 0; chunk < file.ChunkCount; chunk++ {
+			if err = ctx.Err(); err != nil {
+				break
+			}
+			ref := refs[file.FirstChunk+chunk]
+			sealed := buffer[:ref.PlainSize+sealedChunkOverhead]
+			if _, err = io.ReadFull(reader, sealed); err != nil {
+				err = errors.New("archive is truncated")
+				break
+			}
+			var plaintext []byte
+			if plaintext, err = chunkCipher.OpenInPlace(a.ID, ref, sealed); err != nil {
+				break
+			}
+			if _, err = handle.Write(plaintext); err != nil {
+				break
+			}
+			fileBytes += int64(ref.PlainSize)
+			extracted += int64(ref.PlainSize)
+			progress(callbacks, Progress{
+				FileIndex: fileIndex, FileCount: len(a.Manifest.Files), FileName: file.Name,
+				FileBytes: fileBytes, FileSize: file.Size,
+				TotalBytes: extracted, TotalSize: total,
+			})
+		}
+		if err == nil {
+			err = handle.Sync()
+		}
+		if closeErr := handle.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = installVerifiedFile(ctx, file, partPath, root, callbacks)
+		}
+		if err != nil {
+			_ = root.Remove(partPath)
+			return err
+		}
+	}
+	status(callbacks, "Verified archive unpacked")
+	return nil
+}
+
+// Close closes the archive file.
+func (a *Archive) Close() error {
+	return a.file.Close()
+}
@@ -0,0 +1,118 @@
+package storeclient
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/schollz/croc/v11/src/storecrypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func packTestFiles(t *testing.T) ([]string, map[string][]byte) {
+	t.Helper()
+	input := t.TempDir()
+	contents := map[string][]byte{
+		"alpha.bin": make([]byte, storecrypto.ChunkSize+137),
+		"empty.txt": {},
+	}
+	for index := range contents["alpha.bin"] {
+		contents["alpha.bin"][index] = byte(index % 251)
+	}
+	var paths []string
+	for _, name := range []string{"alpha.bin", "empty.txt"} {
+		path := filepath.Join(input, name)
+		require.NoError(t, os.WriteFile(path, contents[name], 0o600))
+		paths = append(paths, path)
+	}
+	return paths, contents
+}
+
+func unpackArchive(t *testing.T, archivePath, secret string) (string, error) {
+	t.Helper()
+	archive, err := OpenArchive(archivePath)
+	require.NoError(t, err)
+	defer archive.Close()
+	if err = archive.Unlock(secret); err != nil {
+		return "", err
+	}
+	output := t.TempDir()
+	return output, archive.Extract(context.Background(), output, Callbacks{})
+}
+
+func TestPackUnpackWithToken(t *testing.T) {
+	paths, contents := packTestFiles(t)
+	archivePath := filepath.Join(t.TempDir(), "bundle.croc")
+	result, err := Pack(context.Background(), paths, archivePath, PackOptions{}, Callbacks{})
+	require.NoError(t, err)
+	require.True(t, storecrypto.IsPackToken(result.Token))
+	info, err := os.Stat(archivePath)
+	require.NoError(t, err)
+	assert.Equal(t, result.Size, info.Size())
+
+	archive, err := OpenArchive(archivePath)
+	require.NoError(t, err)
+	assert.False(t, archive.UsesPassphrase())
+	assert.Equal(t, result.ID, archive.ID)
+	require.NoError(t, archive.Close())
+
+	output, err := unpackArchive(t, archivePath, result.Token)
+	require.NoError(t, err)
+	for name, want := range contents {
+		got, readErr := os.ReadFile(filepath.Join(output, name))
+		require.NoError(t, readErr)
+		assert.Equal(t, want, got, name)
+	}
+	entries, err := os.ReadDir(output)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2, "part files must not be left behind")
+
+	other, err := Pack(context.Background(), paths[:1], filepath.Join(t.TempDir(), "other.croc"), PackOptions{}, Callbacks{})
+	require.NoError(t, err)
+	_, err = unpackArchive(t, archivePath, other.Token)
+	assert.ErrorContains(t, err, "different archive")
+}
+
+func TestPackUnpackWithPassphrase(t *testing.T) {
+	paths, contents := packTestFiles(t)
+	archivePath := filepath.Join(t.TempDir(), "bundle.croc")
+	result, err := Pack(context.Background(), paths, archivePath, PackOptions{Passphrase: "correct horse"}, Callbacks{})
+	require.NoError(t, err)
+	assert.Empty(t, result.Token)
+
+	_, err = unpackArchive(t, archivePath, "wrong horse")
+	assert.ErrorContains(t, err, "wrong passphrase")
+
+	output, err := unpackArchive(t, archivePath, "correct horse")
+	require.NoError(t, err)
+	got, err := os.ReadFile(filepath.Join(output, "alpha.bin"))
+	require.NoError(t, err)
+	assert.Equal(t, contents["alpha.bin"], got)
+}
+
+func TestUnpackRejectsDamagedArchive(t *testing.T) {
+	paths, _ := packTestFiles(t)
+	archivePath := filepath.Join(t.TempDir(), "bundle.croc")
+	result, err := Pack(context.Background(), paths, archivePath, PackOptions{}, Callbacks{})
+	require.NoError(t, err)
+	original, err := os.ReadFile(archivePath)
+	require.NoError(t, err)
+
+	damaged := append([]byte(nil), original...)
+	damaged[len(damaged)-1] ^= 1
+	require.NoError(t, os.WriteFile(archivePath, damaged, 0o600))
+	output, err := unpackArchive(t, archivePath, result.Token)
+	assert.ErrorContains(t, err, "authentication failed")
+	_, statErr := os.Stat(filepath.Join(output, "alpha.bin"))
+	assert.True(t, os.IsNotExist(statErr), "damaged files must not be installed")
+
+	require.NoError(t, os.WriteFile(archivePath, original[:len(original)-10], 0o600))
+	_, err = unpackArchive(t, archivePath, result.Token)
+	assert.ErrorContains(t, err, "archive should be")
+
+	require.NoError(t, os.WriteFile(archivePath, []byte("not an archive"), 0o600))
+	_, err = OpenArchive(archivePath)
+	assert.ErrorContains(t, err, "not a croc archive")
+}
@@ -0,0 +1,175 @@
+package storeclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/schollz/croc/v11/src/storecrypto"
+)
+
+// RequestedTransfer is one upload received through a file request, with its
+// master key already unwrapped.
+type RequestedTransfer struct {
+	Share              storecrypto.Share
+	ExpiresAt          time.Time
+	DownloadsRemaining int
+}
+
+type uploadRequestBody struct {
+	ID         string `json:"id"`
+	WrappedKey string `json:"wrappedKey"`
+}
+
+type fileRequestDeclaration struct {
+	Protocol       string `json:"protocol"`
+	OwnerVerifier  string `json:"ownerVerifier"`
+	ExpiresSeconds *int64 `json:"expiresSeconds,omitempty"`
+}
+
+type fileRequestResponse struct {
+	ID        string    `json:"id"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type requestedTransferResponse struct {
+	ID                 string    `json:"id"`
+	WrappedKey         string    `json:"wrappedKey"`
+	ExpiresAt          time.Time `json:"expiresAt"`
+	DownloadsRemaining int       `json:"downloadsRemaining"`
+}
+
+func requestsURL(origin, suffix string) string {
+	return strings.TrimSuffix(origin, "/") + "/api/v1/store/requests" + suffix
+}
+
+// CreateFileRequest registers a file request. The returned collection holds
+// the requester's secret; share only its FileRequest with uploaders.
+func (c *Client) CreateFileRequest(
+	ctx context.Context,
+	origin string,
+	expiration time.Duration,
+) (storecrypto.RequestCollection, time.Time, error) {
+	if err := validateOrigin(origin); err != nil {
+		return storecrypto.RequestCollection{}, time.Time{}, err
+	}
+	if expiration == 0 {
+		expiration = 24 * time.Hour
+	}
+	if expiration < time.Minute || expiration%time.Second != 0 {
+		return storecrypto.RequestCollection{}, time.Time{},
+			errors.New("file request expiration must be whole seconds and at least one minute")
+	}
+	secret, err := storecrypto.GenerateKey()
+	if err != nil {
+		return storecrypto.RequestCollection{}, time.Time{}, err
+	}
+	owner, err := storecrypto.RequestOwnerCapability(secret)
+	if err != nil {
+		return storecrypto.RequestCollection{}, time.Time{}, err
+	}
+	declaration := fileRequestDeclaration{
+		Protocol:      storecrypto.Protocol,
+		OwnerVerifier: storecrypto.EncodeBase64URL(storecrypto.CapabilityVerifier(owner)),
+	}
+	if expiration != 24*time.Hour {
+		seconds := int64(expiration / time.Second)
+		declaration.ExpiresSeconds = &seconds
+	}
+	request, err := jsonRequest(ctx, http.MethodPost, requestsURL(origin, ""), "", declaration)
+	if err != nil {
+		return storecrypto.RequestCollection{}, time.Time{}, err
+	}
+	response, err := c.do(request)
+	if err != nil {
+		return storecrypto.RequestCollection{}, time.Time{}, err
+	}
+	var created fileRequestResponse
+	if err = decodeResponse(response, &created); err != nil {
+		return storecrypto.RequestCollection{}, time.Time{}, err
+	}
+	collection := storecrypto.RequestCollection{Origin: origin, ID: created.ID, Secret: secret}
+	if _, err = collection.CLIToken(); err != nil {
+		return storecrypto.RequestCollection{}, time.Time{},
+			fmt.Errorf("storage service returned an invalid file request id: %w", err)
+	}
+	return collection, created.ExpiresAt, nil
+}
+
+// RequestedTransfers lists completed uploads that are still available through
+// a file request. Uploads whose key cannot be unwrapped are skipped because an
+// uploader, not the requester, chose them.
+func (c *Client) RequestedTransfers(
+	ctx context.Context,
+	collection storecrypto.RequestCollection,
+) ([]RequestedTransfer, error) {
+	owner, err := storecrypto.RequestOwnerCapability(collection.Secret)
+	if err != nil {
+		return nil, err
+	}
+	request, err := jsonRequest(
+		ctx,
+		http.MethodGet,
+		requestsURL(collection.Origin, "/"+collection.ID+"/transfers"),
+		storecrypto.EncodeBase64URL(owner),
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+	response, err := c.do(request)
+	if err != nil {
+		return nil, err
+	}
+	var listed []requestedTransferResponse
+	if err = decodeResponse(response, &listed); err != nil {
+		return nil, err
+	}
+	transfers := make([]RequestedTransfer, 0, len(listed))
+	for _, entry := range listed {
+		wrapped, decodeErr := storecrypto.DecodeBase64URL(entry.WrappedKey)
+		if decodeErr != nil {
+			continue
+		}
+		master, unwrapErr := storecrypto.UnwrapRequestKey(collection, wrapped)
+		if unwrapErr != nil {
+			continue
+		}
+		share := storecrypto.Share{Origin: collection.Origin, ID: entry.ID, MasterKey: master}
+		if _, shareErr := share.BrowserURL(); shareErr != nil {
+			continue
+		}
+		transfers = append(transfers, RequestedTransfer{
+			Share:              share,
+			ExpiresAt:          entry.ExpiresAt,
+			DownloadsRemaining: entry.DownloadsRemaining,
+		})
+	}
+	return transfers, nil
+}
+
+// CloseFileRequest stops a file request from accepting further uploads.
+func (c *Client) CloseFileRequest(ctx context.Context, collection storecrypto.RequestCollection) error {
+	owner, err := storecrypto.RequestOwnerCapability(collection.Secret)
+	if err != nil {
+		return err
+	}
+	request, err := jsonRequest(
+		ctx,
+		http.MethodDelete,
+		requestsURL(collection.Origin, "/"+collection.ID),
+		storecrypto.EncodeBase64URL(owner),
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+	response, err := c.do(request)
+	if err == nil {
+		response.Body.Close()
+	}
+	return err
+}
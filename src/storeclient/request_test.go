@@ -0,0 +1,87 @@
+package storeclient
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/schollz/croc/v11/src/storecrypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileRequestUploadAndCollect(t *testing.T) {
+	client, origin := testStack(t)
+	collection, expiresAt, err := client.CreateFileRequest(context.Background(), origin, 2*time.Hour)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(2*time.Hour), expiresAt, time.Minute)
+	fileRequest, err := collection.FileRequest()
+	require.NoError(t, err)
+	link, err := fileRequest.BrowserURL()
+	require.NoError(t, err)
+	parsed, err := storecrypto.ParseFileRequest(link)
+	require.NoError(t, err)
+
+	file := filepath.Join(t.TempDir(), "invoice.pdf")
+	require.NoError(t, os.WriteFile(file, []byte("requested contents"), 0o600))
+	uploaded, err := client.UploadWithOptions(context.Background(), parsed.Origin, []string{file},
+		UploadOptions{Request: &parsed}, Callbacks{})
+	require.NoError(t, err)
+
+	transfers, err := client.RequestedTransfers(context.Background(), collection)
+	require.NoError(t, err)
+	require.Len(t, transfers, 1)
+	assert.Equal(t, uploaded.Share, transfers[0].Share)
+	assert.Equal(t, 1, transfers[0].DownloadsRemaining)
+
+	manifest, _, err := client.Inspect(context.Background(), transfers[0].Share)
+	require.NoError(t, err)
+	output := t.TempDir()
+	require.NoError(t, client.Receive(context.Background(), transfers[0].Share, manifest, output, Callbacks{}))
+	received, err := os.ReadFile(filepath.Join(output, "invoice.pdf"))
+	require.NoError(t, err)
+	assert.Equal(t, "requested contents", string(received))
+
+	transfers, err = client.RequestedTransfers(context.Background(), collection)
+	require.NoError(t, err)
+	assert.Empty(t, transfers)
+
+	require.NoError(t, client.CloseFileRequest(context.Background(), collection))
+	_, err = client.UploadWithOptions(context.Background(), parsed.Origin, []string{file},
+		UploadOptions{Request: &parsed}, Callbacks{})
+	var httpErr *HTTPError
+	require.ErrorAs(t, err, &httpErr)
+	assert.Equal(t, 404, httpErr.StatusCode)
+}
+
+func TestFileRequestUploadRejectsAnotherOrigin(t *testing.T) {
+	client, origin := testStack(t)
+	request := storecrypto.FileRequest{
+		Origin:    "https://elsewhere.example",
+		ID:        storecrypto.EncodeBase64URL(make([]byte, storecrypto.TransferIDLen)),
+		PublicKey: make([]byte, storecrypto.PublicKeySize),
+	}
+	file := filepath.Join(t.TempDir(), "a.txt")
+	require.NoError(t, os.WriteFile(file, []byte("a"), 0o600))
+	_, err := client.UploadWithOptions(context.Background(), origin, []string{file},
+		UploadOptions{Request: &request}, Callbacks{})
+	assert.ErrorContains(t, err, "different storage service")
+}
+
+func TestIsStoredValueRecognizesCollectionTokens(t *testing.T) {
+	collection := storecrypto.RequestCollection{
+		Origin: "https://files.example",
+		ID:     storecrypto.EncodeBase64URL(make([]byte, storecrypto.TransferIDLen)),
+		Secret: make([]byte, storecrypto.KeySize),
+	}
+	token, err := collection.CLIToken()
+	require.NoError(t, err)
+	assert.True(t, IsStoredValue(token))
+	fileRequest, err := collection.FileRequest()
+	require.NoError(t, err)
+	public, err := fileRequest.CLIToken()
+	require.NoError(t, err)
+	assert.False(t, IsStoredValue(public), "public request links are for uploading, not receiving")
+}
@@ -0,0 +1,91 @@
+package storecrypto
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	// PackProtocol prefixes offline archives and the tokens that unlock them.
+	PackProtocol = "croc-pack-v1"
+	// PackSaltSize is the length of the Argon2id salt of a passphrase archive.
+	PackSaltSize = 16
+
+	packArgonTime    = 3
+	packArgonMemory  = 64 * 1024
+	packArgonThreads = 4
+)
+
+// PackKey unlocks an offline archive that was sealed with a random key. The
+// archive ID is part of every chunk's associated data, as for a Share.
+type PackKey struct {
+	ID        string
+	MasterKey []byte
+}
+
+// Token formats a pack key in the style of Share.CLIToken.
+func (key PackKey) Token() (string, error) {
+	if err := validateShare(Share{ID: key.ID, MasterKey: key.MasterKey}); err != nil {
+		return "", err
+	}
+	return strings.Join([]string{
+		PackProtocol,
+		key.ID,
+		rawURL.EncodeToString(key.MasterKey),
+	}, "."), nil
+}
+
+// IsPackToken reports whether value looks like a pack token rather than a
+// passphrase.
+func IsPackToken(value string) bool {
+	return strings.HasPrefix(strings.TrimSpace(value), PackProtocol+".")
+}
+
+// ParsePackToken parses a token produced by PackKey.Token.
+func ParsePackToken(value string) (PackKey, error) {
+	parts := strings.Split(strings.TrimSpace(value), ".")
+	if len(parts) != 3 || parts[0] != PackProtocol {
+		return PackKey{}, errors.New("invalid archive token")
+	}
+	master, err := rawURL.DecodeString(parts[2])
+	if err != nil {
+		return PackKey{}, errors.New("invalid archive key")
+	}
+	key := PackKey{ID: parts[1], MasterKey: master}
+	if err = validateShare(Share{ID: key.ID, MasterKey: key.MasterKey}); err != nil {
+		return PackKey{}, err
+	}
+	return key, nil
+}
+
+// GeneratePackSalt returns a fresh salt for PassphraseKey.
+func GeneratePackSalt() ([]byte, error) {
+	salt := make([]byte, PackSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("generate archive salt: %w", err)
+	}
+	return salt, nil
+}
+
+// PassphraseKey stretches a passphrase into an archive master key with
+// Argon2id, so that the archive can be opened without a token.
+func PassphraseKey(passphrase string, salt []byte) ([]byte, error) {
+	if passphrase == "" {
+		return nil, errors.New("archive passphrase is required")
+	}
+	if len(salt) != PackSaltSize {
+		return nil, fmt.Errorf("archive salt must be %d bytes", PackSaltSize)
+	}
+	return argon2.IDKey(
+		[]byte(passphrase),
+		salt,
+		packArgonTime,
+		packArgonMemory,
+		packArgonThreads,
+		KeySize,
+	), nil
+}
@@ -0,0 +1,50 @@
+package storecrypto
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPackTokenRoundTrip(t *testing.T) {
+	key := PackKey{
+		ID:        EncodeBase64URL(bytes.Repeat([]byte{9}, TransferIDLen)),
+		MasterKey: bytes.Repeat([]byte{7}, KeySize),
+	}
+	token, err := key.Token()
+	require.NoError(t, err)
+	assert.True(t, IsPackToken(token))
+	parsed, err := ParsePackToken(" " + token + "\n")
+	require.NoError(t, err)
+	assert.Equal(t, key, parsed)
+
+	for _, invalid := range []string{
+		"croc-pack-v1.short.key",
+		Protocol + "." + key.ID + "." + EncodeBase64URL(key.MasterKey),
+		token + ".extra",
+	} {
+		_, err = ParsePackToken(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestPassphraseKey(t *testing.T) {
+	salt := bytes.Repeat([]byte{1}, PackSaltSize)
+	first, err := PassphraseKey("correct horse", salt)
+	require.NoError(t, err)
+	again, err := PassphraseKey("correct horse", salt)
+	require.NoError(t, err)
+	assert.Equal(t, first, again)
+	assert.Len(t, first, KeySize)
+
+	otherSalt, err := PassphraseKey("correct horse", bytes.Repeat([]byte{2}, PackSaltSize))
+	require.NoError(t, err)
+	assert.NotEqual(t, first, otherSalt)
+
+	_, err = PassphraseKey("", salt)
+	assert.Error(t, err)
+	_, err = PassphraseKey("correct horse", salt[:8])
+	assert.Error(t, err)
+}
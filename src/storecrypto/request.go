@@ -0,0 +1,251 @@
+package storecrypto
+
+import (
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	// RequestProtocol prefixes the public token uploaders use for a file request.
+	RequestProtocol = Protocol + "-request"
+	// CollectProtocol prefixes the requester's secret collection token.
+	CollectProtocol = Protocol + "-collect"
+	// PublicKeySize is the length of a file request's X25519 public key.
+	PublicKeySize = 32
+	// WrappedKeySize is the length of a transfer key wrapped to a request.
+	WrappedKeySize = PublicKeySize + 12 + KeySize + 16
+)
+
+// FileRequest is the public half of a file request. Anyone holding it can
+// upload files that only the requester can decrypt.
+type FileRequest struct {
+	Origin    string
+	ID        string
+	PublicKey []byte
+}
+
+// RequestCollection is the requester's secret half of a file request. Its
+// secret derives the X25519 private key and the owner capability used to list
+// uploads.
+type RequestCollection struct {
+	Origin string
+	ID     string
+	Secret []byte
+}
+
+func requestPrivateKey(secret []byte) (*ecdh.PrivateKey, error) {
+	scalar, err := derive(secret, "request-private")
+	if err != nil {
+		return nil, err
+	}
+	return ecdh.X25519().NewPrivateKey(scalar)
+}
+
+// RequestPublicKey returns the public key uploaders wrap transfer keys to.
+func RequestPublicKey(secret []byte) ([]byte, error) {
+	private, err := requestPrivateKey(secret)
+	if err != nil {
+		return nil, err
+	}
+	return private.PublicKey().Bytes(), nil
+}
+
+// RequestOwnerCapability derives the capability that lists a request's
+// uploads. The service stores only its verifier.
+func RequestOwnerCapability(secret []byte) ([]byte, error) {
+	return derive(secret, "request-owner")
+}
+
+func requestWrapKey(shared, ephemeral, recipient []byte, requestID string) ([]byte, error) {
+	salt := make([]byte, 0, len(ephemeral)+len(recipient))
+	salt = append(append(salt, ephemeral...), recipient...)
+	return hkdf.Key(sha256.New, shared, salt, Protocol+"/request-wrap\x00"+requestID, KeySize)
+}
+
+// WrapRequestKey encrypts a transfer's master key to a file request's public
+// key with an ephemeral X25519 exchange. The result is bound to the request ID.
+func WrapRequestKey(request FileRequest, master []byte) ([]byte, error) {
+	if len(master) != KeySize {
+		return nil, fmt.Errorf("stored-transfer key must be %d bytes", KeySize)
+	}
+	recipient, err := ecdh.X25519().NewPublicKey(request.PublicKey)
+	if err != nil {
+		return nil, errors.New("invalid file request public key")
+	}
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate file request key: %w", err)
+	}
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return nil, errors.New("invalid file request public key")
+	}
+	ephemeralPublic := ephemeral.PublicKey().Bytes()
+	key, err := requestWrapKey(shared, ephemeralPublic, request.PublicKey, request.ID)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := seal(key, "request-key", master, []byte(Protocol+"\x00"+request.ID+"\x00request-key"), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return append(ephemeralPublic, sealed...), nil
+}
+
+// UnwrapRequestKey recovers a transfer's master key uploaded to a request.
+func UnwrapRequestKey(collection RequestCollection, wrapped []byte) ([]byte, error) {
+	if len(wrapped) != WrappedKeySize {
+		return nil, errors.New("invalid wrapped file request key")
+	}
+	private, err := requestPrivateKey(collection.Secret)
+	if err != nil {
+		return nil, err
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(wrapped[:PublicKeySize])
+	if err != nil {
+		return nil, errors.New("invalid wrapped file request key")
+	}
+	shared, err := private.ECDH(ephemeral)
+	if err != nil {
+		return nil, errors.New("invalid wrapped file request key")
+	}
+	key, err := requestWrapKey(shared, wrapped[:PublicKeySize], private.PublicKey().Bytes(), collection.ID)
+	if err != nil {
+		return nil, err
+	}
+	return open(key, "request-key", wrapped[PublicKeySize:], []byte(Protocol+"\x00"+collection.ID+"\x00request-key"))
+}
+
+// FileRequest returns the public request that uploaders receive.
+func (collection RequestCollection) FileRequest() (FileRequest, error) {
+	public, err := RequestPublicKey(collection.Secret)
+	if err != nil {
+		return FileRequest{}, err
+	}
+	return FileRequest{Origin: collection.Origin, ID: collection.ID, PublicKey: public}, nil
+}
+
+// BrowserURL formats a file request as a fragment URL. The public key follows
+// "#" so that it stays out of access logs, although it is not secret.
+func (request FileRequest) BrowserURL() (string, error) {
+	origin, err := normalizeOrigin(request.Origin)
+	if err != nil {
+		return "", err
+	}
+	if err = validateRequestParts(request.ID, request.PublicKey, PublicKeySize); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/r/%s#v1.%s", origin, request.ID, rawURL.EncodeToString(request.PublicKey)), nil
+}
+
+// CLIToken formats a file request for `croc send --store-request`.
+func (request FileRequest) CLIToken() (string, error) {
+	return formatRequestToken(RequestProtocol, request.Origin, request.ID, request.PublicKey, PublicKeySize)
+}
+
+// CLIToken formats the requester's secret collection token.
+func (collection RequestCollection) CLIToken() (string, error) {
+	return formatRequestToken(CollectProtocol, collection.Origin, collection.ID, collection.Secret, KeySize)
+}
+
+func formatRequestToken(protocol, origin, id string, key []byte, keySize int) (string, error) {
+	origin, err := normalizeOrigin(origin)
+	if err != nil {
+		return "", err
+	}
+	if err = validateRequestParts(id, key, keySize); err != nil {
+		return "", err
+	}
+	return strings.Join([]string{
+		protocol,
+		rawURL.EncodeToString([]byte(origin)),
+		id,
+		rawURL.EncodeToString(key),
+	}, "."), nil
+}
+
+func parseRequestToken(protocol, value string, keySize int) (string, string, []byte, error) {
+	parts := strings.Split(strings.TrimSpace(value), ".")
+	if len(parts) != 4 || parts[0] != protocol {
+		return "", "", nil, errors.New("invalid file request token")
+	}
+	originBytes, err := rawURL.DecodeString(parts[1])
+	if err != nil {
+		return "", "", nil, errors.New("invalid file request origin")
+	}
+	origin, err := normalizeOrigin(string(originBytes))
+	if err != nil {
+		return "", "", nil, err
+	}
+	key, err := rawURL.DecodeString(parts[3])
+	if err != nil {
+		return "", "", nil, errors.New("invalid file request key")
+	}
+	if err = validateRequestParts(parts[2], key, keySize); err != nil {
+		return "", "", nil, err
+	}
+	return origin, parts[2], key, nil
+}
+
+// ParseFileRequest parses a file request browser URL or CLI token.
+func ParseFileRequest(value string) (FileRequest, error) {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, RequestProtocol+".") {
+		origin, id, key, err := parseRequestToken(RequestProtocol, value, PublicKeySize)
+		if err != nil {
+			return FileRequest{}, err
+		}
+		return FileRequest{Origin: origin, ID: id, PublicKey: key}, nil
+	}
+	parsed, err := url.Parse(value)
+	if err != nil || parsed.Scheme == "" || parsed.Host == "" ||
+		parsed.User != nil || parsed.RawQuery != "" {
+		return FileRequest{}, errors.New("invalid file request URL")
+	}
+	segments := strings.Split(strings.Trim(parsed.EscapedPath(), "/"), "/")
+	if len(segments) != 2 || segments[0] != "r" {
+		return FileRequest{}, errors.New("invalid file request URL path")
+	}
+	fragment := strings.TrimPrefix(parsed.Fragment, "v1.")
+	if fragment == parsed.Fragment {
+		return FileRequest{}, errors.New("file request URL is missing a v1 key")
+	}
+	key, err := rawURL.DecodeString(fragment)
+	if err != nil {
+		return FileRequest{}, errors.New("invalid file request URL key")
+	}
+	origin, err := normalizeOrigin(parsed.Scheme + "://" + parsed.Host)
+	if err != nil {
+		return FileRequest{}, err
+	}
+	if err = validateRequestParts(segments[1], key, PublicKeySize); err != nil {
+		return FileRequest{}, err
+	}
+	return FileRequest{Origin: origin, ID: segments[1], PublicKey: key}, nil
+}
+
+// ParseRequestCollection parses a requester's collection token.
+func ParseRequestCollection(value string) (RequestCollection, error) {
+	origin, id, secret, err := parseRequestToken(CollectProtocol, value, KeySize)
+	if err != nil {
+		return RequestCollection{}, err
+	}
+	return RequestCollection{Origin: origin, ID: id, Secret: secret}, nil
+}
+
+func validateRequestParts(id string, key []byte, keySize int) error {
+	decoded, err := rawURL.DecodeString(id)
+	if err != nil || len(decoded) != TransferIDLen || rawURL.EncodeToString(decoded) != id {
+		return errors.New("invalid file request id")
+	}
+	if len(key) != keySize {
+		return errors.New("invalid file request key length")
+	}
+	return nil
+}
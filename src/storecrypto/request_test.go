@@ -0,0 +1,76 @@
+package storecrypto
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testCollection() RequestCollection {
+	return RequestCollection{
+		Origin: "https://files.example.test",
+		ID:     EncodeBase64URL(bytes.Repeat([]byte{8}, TransferIDLen)),
+		Secret: bytes.Repeat([]byte{9}, KeySize),
+	}
+}
+
+func TestRequestKeyWrapRoundTrip(t *testing.T) {
+	collection := testCollection()
+	request, err := collection.FileRequest()
+	require.NoError(t, err)
+	master := bytes.Repeat([]byte{1}, KeySize)
+
+	wrapped, err := WrapRequestKey(request, master)
+	require.NoError(t, err)
+	assert.Len(t, wrapped, WrappedKeySize)
+	again, err := WrapRequestKey(request, master)
+	require.NoError(t, err)
+	assert.NotEqual(t, wrapped, again, "wrapping must use a fresh ephemeral key")
+
+	unwrapped, err := UnwrapRequestKey(collection, wrapped)
+	require.NoError(t, err)
+	assert.Equal(t, master, unwrapped)
+
+	other := collection
+	other.Secret = bytes.Repeat([]byte{2}, KeySize)
+	_, err = UnwrapRequestKey(other, wrapped)
+	assert.ErrorContains(t, err, "authentication failed")
+	moved := collection
+	moved.ID = EncodeBase64URL(bytes.Repeat([]byte{3}, TransferIDLen))
+	_, err = UnwrapRequestKey(moved, wrapped)
+	assert.ErrorContains(t, err, "authentication failed")
+}
+
+func TestRequestTokensRoundTrip(t *testing.T) {
+	collection := testCollection()
+	request, err := collection.FileRequest()
+	require.NoError(t, err)
+
+	browserURL, err := request.BrowserURL()
+	require.NoError(t, err)
+	assert.Contains(t, browserURL, "https://files.example.test/r/"+request.ID+"#v1.")
+	fromURL, err := ParseFileRequest(browserURL)
+	require.NoError(t, err)
+	assert.Equal(t, request, fromURL)
+
+	token, err := request.CLIToken()
+	require.NoError(t, err)
+	fromToken, err := ParseFileRequest(token)
+	require.NoError(t, err)
+	assert.Equal(t, request, fromToken)
+
+	secretToken, err := collection.CLIToken()
+	require.NoError(t, err)
+	parsed, err := ParseRequestCollection(secretToken)
+	require.NoError(t, err)
+	assert.Equal(t, collection, parsed)
+
+	_, err = ParseFileRequest(secretToken)
+	assert.Error(t, err)
+	_, err = ParseRequestCollection(token)
+	assert.Error(t, err)
+	_, err = ParseShare(token)
+	assert.Error(t, err)
+}
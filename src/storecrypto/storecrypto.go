@@ -253,6 +253,24 @@ func OpenChunk(master []byte, id string, ref ChunkRef, ciphertext []byte) ([]byt
 	return plaintext, nil
 }
 
+func notificationAAD() []byte {
+	return []byte(Protocol + "\x00notify")
+}
+
+// SealNotification encrypts sender-chosen context with a key held by the
+// sender's notification receiver. The storage service stores and echoes the
+// ciphertext in download and expiry notifications without being able to read
+// it.
+func SealNotification(key, plaintext []byte) ([]byte, error) {
+	return seal(key, "notify", plaintext, notificationAAD(), rand.Reader)
+}
+
+// OpenNotification authenticates and decrypts context echoed by a storage
+// service notification.
+func OpenNotification(key, ciphertext []byte) ([]byte, error) {
+	return open(key, "notify", ciphertext, notificationAAD())
+}
+
 // ChunkCipher reuses the derived data key and AEAD across chunks in one
 // transfer. It also supports caller-owned output buffers for the hot path.
 type ChunkCipher struct {
@@ -83,6 +83,22 @@ func TestChunkContextPreventsReordering(t *testing.T) {
 	assert.ErrorContains(t, err, "authentication failed")
 }
 
+func TestNotificationContextRoundTrip(t *testing.T) {
+	key := bytes.Repeat([]byte{5}, KeySize)
+	ciphertext, err := SealNotification(key, []byte(`{"label":"quarterly report"}`))
+	require.NoError(t, err)
+	assert.NotContains(t, string(ciphertext), "quarterly")
+
+	plaintext, err := OpenNotification(key, ciphertext)
+	require.NoError(t, err)
+	assert.Equal(t, `{"label":"quarterly report"}`, string(plaintext))
+
+	_, err = OpenNotification(bytes.Repeat([]byte{6}, KeySize), ciphertext)
+	assert.ErrorContains(t, err, "authentication failed")
+	_, err = SealNotification([]byte("short"), nil)
+	assert.Error(t, err)
+}
+
 func TestShareURLAndTokenRoundTrip(t *testing.T) {
 	share := Share{
 		Origin:    "https://files.example.test",
@@ -0,0 +1,359 @@
+package tcp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	log "github.com/schollz/logger"
+)
+
+// Commands accepted on the admin socket.
+const (
+	AdminListRooms      = "rooms"
+	AdminListHandshakes = "handshakes"
+	AdminListLimits     = "limits"
+	AdminEvictRoom      = "evict"
+	AdminBanSource      = "ban"
+	AdminUnbanSource    = "unban"
+)
+
+// adminTimeout bounds an idle admin connection.
+const adminTimeout = time.Minute
+
+// minAdminRoomPrefix is the shortest room hash prefix that evicts a room.
+const minAdminRoomPrefix = 8
+
+// AdminRequest is one command sent to a relay's admin socket, as one JSON
+// line.
+type AdminRequest struct {
+	Command string `json:"command"`
+	// Room is a room's hash, or a unique prefix of it, to evict.
+	Room string `json:"room,omitempty"`
+	// Source is the IP address to ban or unban.
+	Source string `json:"source,omitempty"`
+	// Duration limits a ban; zero bans until the source is unbanned or the
+	// relay restarts.
+	Duration time.Duration `json:"duration,omitempty"`
+}
+
+// AdminResponse answers an AdminRequest, as one JSON line.
+type AdminResponse struct {
+	Error      string           `json:"error,omitempty"`
+	Rooms      []AdminRoom      `json:"rooms,omitempty"`
+	Handshakes []AdminHandshake `json:"handshakes,omitempty"`
+	Sources    []AdminSource    `json:"sources,omitempty"`
+	Bans       []AdminBan       `json:"bans,omitempty"`
+	// Closed counts the rooms and handshakes an evict or ban closed.
+	Closed int `json:"closed,omitempty"`
+}
+
+// AdminRoom is an open room. Room is the same digest room events record.
+type AdminRoom struct {
+	Port     string    `json:"port"`
+	Room     string    `json:"room"`
+	OpenedAt time.Time `json:"openedAt"`
+	Paired   bool      `json:"paired"`
+	PairedAt time.Time `json:"pairedAt,omitzero"`
+	Bytes    int64     `json:"bytes"`
+	Reserved bool      `json:"reserved,omitempty"`
+	Sources  []string  `json:"sources,omitempty"`
+	Users    []string  `json:"users,omitempty"`
+}
+
+// AdminHandshake is a connection that has not finished its handshake.
+type AdminHandshake struct {
+	Port      string    `json:"port"`
+	Source    string    `json:"source"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+// AdminSource is a source's room joins within the admission window.
+type AdminSource struct {
+	Port   string `json:"port"`
+	Source string `json:"source"`
+	Joins  int    `json:"joins"`
+	Limit  int    `json:"limit"`
+}
+
+// AdminBan is a banned source. A zero Until bans it until it is unbanned.
+type AdminBan struct {
+	Source string    `json:"source"`
+	Until  time.Time `json:"until,omitzero"`
+}
+
+// AdminServer serves the admin socket of the servers of a relay.
+type AdminServer struct {
+	path     string
+	listener net.Listener
+	group    *Group
+}
+
+// ListenAdmin listens on the unix socket at path, readable only by its
+// owner, for the servers of group. A stale socket left by a relay that
+// stopped is replaced.
+func ListenAdmin(path string, group *Group) (*AdminServer, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, dialErr := net.DialTimeout("unix", path, time.Second); dialErr == nil {
+			conn.Close()
+			return nil, fmt.Errorf("admin socket %s is in use", path)
+		}
+		if err = os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Chmod(path, 0o600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return &AdminServer{path: path, listener: listener, group: group}, nil
+}
+
+// Serve answers admin connections until the server is closed.
+func (a *AdminServer) Serve() error {
+	for {
+		conn, err := a.listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		go a.serveConn(conn)
+	}
+}
+
+// Close stops the server and removes its socket.
+func (a *AdminServer) Close() error {
+	err := a.listener.Close()
+	if removeErr := os.Remove(a.path); removeErr != nil && !errors.Is(removeErr, os.ErrNotExist) && err == nil {
+		err = removeErr
+	}
+	return err
+}
+
+// AdminCommand sends request to the admin socket at path and returns the
+// relay's response.
+func AdminCommand(path string, request AdminRequest) (AdminResponse, error) {
+	var response AdminResponse
+	conn, err := net.DialTimeout("unix", path, 5*time.Second)
+	if err != nil {
+		return response, err
+	}
+	defer conn.Close()
+	if err = conn.SetDeadline(time.Now().Add(30 * time.Second)); err != nil {
+		return response, err
+	}
+	if err = json.NewEncoder(conn).Encode(request); err != nil {
+		return response, err
+	}
+	if err = json.NewDecoder(conn).Decode(&response); err != nil {
+		return response, err
+	}
+	if response.Error != "" {
+		return response, errors.New(response.Error)
+	}
+	return response, nil
+}
+
+func (a *AdminServer) serveConn(conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	encoder := json.NewEncoder(conn)
+	for {
+		if err := conn.SetDeadline(time.Now().Add(adminTimeout)); err != nil {
+			return
+		}
+		if !scanner.Scan() {
+			return
+		}
+		var request AdminRequest
+		response := AdminResponse{}
+		if err := json.Unmarshal(scanner.Bytes(), &request); err != nil {
+			response.Error = fmt.Sprintf("invalid request: %v", err)
+		} else {
+			response = handleAdmin(a.group.list(), request)
+		}
+		if err := encoder.Encode(response); err != nil {
+			return
+		}
+	}
+}
+
+func handleAdmin(servers []*server, request AdminRequest) (response AdminResponse) {
+	switch request.Command {
+	case AdminListRooms:
+		for _, s := range servers {
+			response.Rooms = append(response.Rooms, s.adminRooms()...)
+		}
+		slices.SortFunc(response.Rooms, func(a, b AdminRoom) int { return a.OpenedAt.Compare(b.OpenedAt) })
+	case AdminListHandshakes:
+		for _, s := range servers {
+			s.handshakes.Range(func(key, value any) bool {
+				response.Handshakes = append(response.Handshakes, AdminHandshake{
+					Port:      s.port,
+					Source:    canonicalSource(key.(net.Conn).RemoteAddr()),
+					StartedAt: value.(time.Time),
+				})
+				return true
+			})
+		}
+		slices.SortFunc(response.Handshakes, func(a, b AdminHandshake) int { return a.StartedAt.Compare(b.StartedAt) })
+	case AdminListLimits:
+		bans := make(map[string]time.Time)
+		for _, s := range servers {
+			if s.admissionLimits == nil {
+				continue
+			}
+			joins, serverBans := s.admissionLimits.usage()
+			for source, n := range joins {
+				response.Sources = append(response.Sources, AdminSource{Port: s.port, Source: source, Joins: n, Limit: s.sourceJoinLimit})
+			}
+			for source, until := range serverBans {
+				bans[source] = until
+			}
+		}
+		slices.SortFunc(response.Sources, func(a, b AdminSource) int {
+			return strings.Compare(a.Port+" "+a.Source, b.Port+" "+b.Source)
+		})
+		for source, until := range bans {
+			response.Bans = append(response.Bans, AdminBan{Source: source, Until: until})
+		}
+		slices.SortFunc(response.Bans, func(a, b AdminBan) int { return strings.Compare(a.Source, b.Source) })
+	case AdminEvictRoom:
+		closed, err := evictAdminRoom(servers, strings.ToLower(strings.TrimSpace(request.Room)))
+		if err != nil {
+			response.Error = err.Error()
+		}
+		response.Closed = closed
+	case AdminBanSource, AdminUnbanSource:
+		address, err := netip.ParseAddr(strings.TrimSpace(request.Source))
+		if err != nil {
+			response.Error = fmt.Sprintf("invalid source IP %q", request.Source)
+			return
+		}
+		source := address.Unmap().String()
+		if request.Command == AdminUnbanSource {
+			unbanned := false
+			for _, s := range servers {
+				if s.admissionLimits != nil && s.admissionLimits.unban(source) {
+					unbanned = true
+				}
+			}
+			if !unbanned {
+				response.Error = fmt.Sprintf("%s is not banned", source)
+			}
+			return
+		}
+		if request.Duration < 0 {
+			response.Error = "ban duration must not be negative"
+			return
+		}
+		var until time.Time
+		if request.Duration > 0 {
+			until = time.Now().Add(request.Duration)
+		}
+		for _, s := range servers {
+			response.Closed += s.banSource(source, until)
+		}
+		log.Infof("admin banned %s", source)
+	default:
+		response.Error = fmt.Sprintf("unknown admin command %q", request.Command)
+	}
+	return
+}
+
+// adminRooms lists the server's open rooms.
+func (s *server) adminRooms() []AdminRoom {
+	s.rooms.Lock()
+	defer s.rooms.Unlock()
+	rooms := make([]AdminRoom, 0, len(s.rooms.rooms))
+	for name, roomData := range s.rooms.rooms {
+		room := AdminRoom{
+			Port:     s.port,
+			Room:     hashRoom(name),
+			OpenedAt: roomData.opened,
+			Paired:   roomData.full,
+			Reserved: roomData.reserved,
+		}
+		if roomData.stats != nil {
+			room.PairedAt = roomData.stats.paired
+			room.Bytes = roomData.stats.transferred.Load()
+			room.Sources = append([]string(nil), roomData.stats.sources...)
+			room.Users = append([]string(nil), roomData.stats.users...)
+		}
+		rooms = append(rooms, room)
+	}
+	return rooms
+}
+
+// evictAdminRoom closes the one room whose hash starts with prefix.
+func evictAdminRoom(servers []*server, prefix string) (int, error) {
+	if len(prefix) < minAdminRoomPrefix {
+		return 0, fmt.Errorf("room must be at least %d characters of its hash", minAdminRoomPrefix)
+	}
+	var owner *server
+	var match string
+	for _, s := range servers {
+		s.rooms.Lock()
+		for name := range s.rooms.rooms {
+			if !strings.HasPrefix(hashRoom(name), prefix) {
+				continue
+			}
+			if owner != nil {
+				s.rooms.Unlock()
+				return 0, fmt.Errorf("room %s is ambiguous", prefix)
+			}
+			owner, match = s, name
+		}
+		s.rooms.Unlock()
+	}
+	if owner == nil {
+		return 0, fmt.Errorf("no open room matches %s", prefix)
+	}
+	owner.deleteRoom(match, ReasonAdminEvicted)
+	log.Infof("admin evicted room %s", hashRoom(match))
+	return 1, nil
+}
+
+// banSource bans source until until and closes its rooms and handshakes. It
+// returns how many it closed.
+func (s *server) banSource(source string, until time.Time) (closed int) {
+	if s.admissionLimits == nil {
+		return 0
+	}
+	s.admissionLimits.ban(source, until)
+	var rooms []string
+	s.rooms.Lock()
+	for name, roomData := range s.rooms.rooms {
+		if roomData.stats != nil && slices.Contains(roomData.stats.sources, source) {
+			rooms = append(rooms, name)
+		}
+	}
+	s.rooms.Unlock()
+	for _, room := range rooms {
+		s.deleteRoom(room, ReasonBanned)
+	}
+	s.handshakes.Range(func(key, _ any) bool {
+		if conn := key.(net.Conn); canonicalSource(conn.RemoteAddr()) == source {
+			conn.Close()
+			closed++
+		}
+		return true
+	})
+	return closed + len(rooms)
+}
@@ -0,0 +1,148 @@
+package tcp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startTestAdmin serves the admin socket of group in a short temporary
+// directory, as unix socket paths are limited to about a hundred bytes.
+func startTestAdmin(t *testing.T, group *Group) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "croc-admin")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "admin.sock")
+	admin, err := ListenAdmin(path, group)
+	require.NoError(t, err)
+	go admin.Serve()
+	t.Cleanup(func() { admin.Close() })
+	return path
+}
+
+// portRooms returns the listed rooms of the server on port.
+func portRooms(t *testing.T, path, port string) []AdminRoom {
+	t.Helper()
+	response, err := AdminCommand(path, AdminRequest{Command: AdminListRooms})
+	require.NoError(t, err)
+	var rooms []AdminRoom
+	for _, room := range response.Rooms {
+		if room.Port == port {
+			rooms = append(rooms, room)
+		}
+	}
+	return rooms
+}
+
+func TestListenAdminRefusesSocketInUse(t *testing.T) {
+	path := startTestAdmin(t, NewGroup())
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	_, err = ListenAdmin(path, NewGroup())
+	assert.ErrorContains(t, err, "in use")
+
+	file := filepath.Join(filepath.Dir(path), "file")
+	require.NoError(t, os.WriteFile(file, nil, 0o600))
+	_, err = ListenAdmin(file, NewGroup())
+	assert.ErrorContains(t, err, "not a socket")
+}
+
+func TestAdminListsAndEvictsRooms(t *testing.T) {
+	group := NewGroup()
+	s, address, stopServer := startConfiguredTestServer(t, WithGroup(group))
+	defer stopServer()
+	path := startTestAdmin(t, group)
+	_, otherAddress, stopOther := startConfiguredTestServer(t)
+	defer stopOther()
+
+	first, _, _, err := ConnectToTCPServer(address, "pass123", "admin-room")
+	require.NoError(t, err)
+	defer first.Close()
+	other, _, _, err := ConnectToTCPServer(otherAddress, "pass123", "other-relay-room")
+	require.NoError(t, err)
+	defer other.Close()
+
+	// rooms of relays outside the group are not listed
+	listed, err := AdminCommand(path, AdminRequest{Command: AdminListRooms})
+	require.NoError(t, err)
+	require.Len(t, listed.Rooms, 1)
+	rooms := portRooms(t, path, s.port)
+	require.Len(t, rooms, 1)
+	assert.Equal(t, hashRoom("admin-room"), rooms[0].Room)
+	assert.False(t, rooms[0].Paired)
+	assert.Equal(t, []string{"127.0.0.1"}, rooms[0].Sources)
+	assert.WithinDuration(t, time.Now(), rooms[0].OpenedAt, time.Minute)
+
+	limits, err := AdminCommand(path, AdminRequest{Command: AdminListLimits})
+	require.NoError(t, err)
+	assert.Contains(t, limits.Sources, AdminSource{Port: s.port, Source: "127.0.0.1", Joins: 1, Limit: s.sourceJoinLimit})
+
+	_, err = AdminCommand(path, AdminRequest{Command: AdminEvictRoom, Room: "abc"})
+	assert.ErrorContains(t, err, "at least")
+	_, err = AdminCommand(path, AdminRequest{Command: AdminEvictRoom, Room: "zzzzzzzzzz"})
+	assert.ErrorContains(t, err, "no open room")
+
+	response, err := AdminCommand(path, AdminRequest{Command: AdminEvictRoom, Room: rooms[0].Room[:12]})
+	require.NoError(t, err)
+	assert.Equal(t, 1, response.Closed)
+	assert.Empty(t, portRooms(t, path, s.port))
+	closed := make(chan struct{})
+	go func() {
+		// keepalives may still be queued ahead of the close
+		for _, receiveErr := first.Receive(); receiveErr == nil; _, receiveErr = first.Receive() {
+		}
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the evicted peer stayed connected")
+	}
+}
+
+func TestAdminBansAndUnbansSources(t *testing.T) {
+	group := NewGroup()
+	s, address, stopServer := startConfiguredTestServer(t, WithGroup(group))
+	defer stopServer()
+	path := startTestAdmin(t, group)
+
+	first, _, _, err := ConnectToTCPServer(address, "pass123", "banned-room")
+	require.NoError(t, err)
+	defer first.Close()
+
+	_, err = AdminCommand(path, AdminRequest{Command: AdminBanSource, Source: "not-an-ip"})
+	assert.ErrorContains(t, err, "invalid source")
+	_, err = AdminCommand(path, AdminRequest{Command: AdminUnbanSource, Source: "127.0.0.1"})
+	assert.ErrorContains(t, err, "not banned")
+
+	response, err := AdminCommand(path, AdminRequest{Command: AdminBanSource, Source: "::ffff:127.0.0.1"})
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, response.Closed, 1)
+	assert.Empty(t, portRooms(t, path, s.port))
+
+	limits, err := AdminCommand(path, AdminRequest{Command: AdminListLimits})
+	require.NoError(t, err)
+	assert.Contains(t, limits.Bans, AdminBan{Source: "127.0.0.1"})
+
+	_, _, _, err = ConnectToTCPServer(address, "pass123", "banned-room", time.Second)
+	assert.Error(t, err, "a banned source joined a room")
+
+	_, err = AdminCommand(path, AdminRequest{Command: AdminUnbanSource, Source: "127.0.0.1"})
+	require.NoError(t, err)
+	again, _, _, err := ConnectToTCPServer(address, "pass123", "banned-room")
+	require.NoError(t, err)
+	again.Close()
+}
+
+func TestAdminRejectsUnknownCommands(t *testing.T) {
+	path := startTestAdmin(t, NewGroup())
+	_, err := AdminCommand(path, AdminRequest{Command: "shutdown"})
+	assert.ErrorContains(t, err, "unknown admin command")
+}
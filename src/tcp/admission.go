@@ -16,12 +16,16 @@ type admissionLimiter struct {
 	window      time.Duration
 	now         func() time.Time
 	checks      uint64
+	// banned maps banned sources to the end of their ban; a zero time bans
+	// a source until it is unbanned.
+	banned map[string]time.Time
 }
 
 func newAdmissionLimiter(sourceLimit, roomLimit int, window time.Duration) *admissionLimiter {
 	return &admissionLimiter{
 		sources:     make(map[string][]time.Time),
 		rooms:       make(map[string][]time.Time),
+		banned:      make(map[string]time.Time),
 		sourceLimit: sourceLimit,
 		roomLimit:   roomLimit,
 		window:      window,
@@ -33,6 +37,9 @@ func (l *admissionLimiter) allow(source, room string) bool {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	now := l.now()
+	if l.bannedAt(source, now) {
+		return false
+	}
 	cutoff := now.Add(-l.window)
 	sourceEvents := pruneAdmissions(l.sources[source], cutoff)
 	roomEvents := pruneAdmissions(l.rooms[room], cutoff)
@@ -54,6 +61,62 @@ func (l *admissionLimiter) allow(source, room string) bool {
 	return true
 }
 
+// isBanned reports whether source is banned.
+func (l *admissionLimiter) isBanned(source string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.bannedAt(source, l.now())
+}
+
+// bannedAt reports whether source is banned at now, forgetting an expired
+// ban. It must be called with l.mu held.
+func (l *admissionLimiter) bannedAt(source string, now time.Time) bool {
+	until, ok := l.banned[source]
+	if ok && !until.IsZero() && !now.Before(until) {
+		delete(l.banned, source)
+		return false
+	}
+	return ok
+}
+
+// ban refuses source until until, or until it is unbanned if until is zero.
+func (l *admissionLimiter) ban(source string, until time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.banned[source] = until
+}
+
+// unban lifts the ban of source and reports whether it was banned.
+func (l *admissionLimiter) unban(source string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	banned := l.bannedAt(source, l.now())
+	delete(l.banned, source)
+	return banned
+}
+
+// usage returns the joins of each source within the window and the bans in
+// force.
+func (l *admissionLimiter) usage() (joins map[string]int, bans map[string]time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	cutoff := now.Add(-l.window)
+	joins = make(map[string]int)
+	for source, events := range l.sources {
+		if n := len(pruneAdmissions(events, cutoff)); n > 0 {
+			joins[source] = n
+		}
+	}
+	bans = make(map[string]time.Time)
+	for source := range l.banned {
+		if l.bannedAt(source, now) {
+			bans[source] = l.banned[source]
+		}
+	}
+	return joins, bans
+}
+
 func pruneAdmissions(events []time.Time, cutoff time.Time) []time.Time {
 	first := 0
 	for first < len(events) && !events[first].After(cutoff) {
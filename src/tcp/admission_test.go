@@ -146,3 +146,35 @@ func TestWithAdmissionLimits(t *testing.T) {
 		}
 	}
 }
+
+func TestAdmissionLimiterBans(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	limiter := newAdmissionLimiter(20, 20, time.Minute)
+	limiter.now = func() time.Time { return now }
+	limiter.ban("192.0.2.1", time.Time{})
+	limiter.ban("192.0.2.2", now.Add(time.Minute))
+	if limiter.allow("192.0.2.1", "room") || limiter.allow("192.0.2.2", "room") {
+		t.Fatal("banned source was admitted")
+	}
+	if !limiter.allow("192.0.2.3", "room") {
+		t.Fatal("a ban refused another source")
+	}
+	joins, bans := limiter.usage()
+	if joins["192.0.2.3"] != 1 || joins["192.0.2.1"] != 0 || len(bans) != 2 {
+		t.Fatalf("usage = %v, %v", joins, bans)
+	}
+
+	now = now.Add(time.Minute)
+	if limiter.isBanned("192.0.2.2") {
+		t.Fatal("ban outlived its duration")
+	}
+	if limiter.unban("192.0.2.2") {
+		t.Fatal("unban reported an expired ban")
+	}
+	if !limiter.unban("192.0.2.1") || limiter.isBanned("192.0.2.1") {
+		t.Fatal("unban did not lift the ban")
+	}
+	if !limiter.allow("192.0.2.1", "room") {
+		t.Fatal("unbanned source was refused")
+	}
+}
@@ -0,0 +1,79 @@
+package tcp
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/schollz/croc/v11/src/relayauth"
+)
+
+// Errors returned when a relay that authenticates per-user tokens refuses
+// the client's token.
+var (
+	ErrRelayTokenRejected = fmt.Errorf("relay rejected token: %w", relayauth.ErrInvalidToken)
+	ErrRelayQuotaExceeded = fmt.Errorf("relay token over quota: %w", relayauth.ErrQuotaExceeded)
+)
+
+// WithRelayAuth makes the relay authenticate peers with the per-user tokens
+// of auth instead of the shared relay password, and enforce their quotas.
+// A nil auth keeps the shared password.
+func WithRelayAuth(auth *relayauth.Authenticator) serverOptsFunc {
+	return func(s *server) error {
+		s.auth = auth
+		return nil
+	}
+}
+
+// releaseLeases returns the peers' token leases.
+func (r roomInfo) releaseLeases() {
+	r.firstLease.Release()
+	r.secondLease.Release()
+}
+
+// errByteQuotaUsed stops a pipe when a peer's user has relayed its daily
+// bytes.
+var errByteQuotaUsed = fmt.Errorf("relay token used its daily bytes: %w", relayauth.ErrQuotaExceeded)
+
+// meteredReader counts the bytes read from a peer and charges them to the
+// peer's lease, failing once the lease's user is over its byte quota.
+type meteredReader struct {
+	r           io.Reader
+	transferred *atomic.Int64
+	lease       *relayauth.Lease
+}
+
+func (m meteredReader) Read(p []byte) (int, error) {
+	n, err := m.r.Read(p)
+	if n > 0 {
+		if m.transferred != nil {
+			m.transferred.Add(int64(n))
+		}
+		if !m.lease.Charge(int64(n)) {
+			return n, errByteQuotaUsed
+		}
+	}
+	return n, err
+}
+
+// relayAuthError maps a relay's refusal of the client's credential to an
+// error.
+func relayAuthError(data []byte) error {
+	switch string(data) {
+	case "bad password":
+		return fmt.Errorf("bad password")
+	case "bad token":
+		return ErrRelayTokenRejected
+	case "quota exceeded":
+		return ErrRelayQuotaExceeded
+	}
+	return fmt.Errorf("invalid relay response")
+}
+
+// leaseUsers returns the user holding lease for room events, if any.
+func leaseUsers(lease *relayauth.Lease) []string {
+	if lease == nil {
+		return nil
+	}
+	return []string{lease.Name()}
+}
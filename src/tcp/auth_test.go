@@ -0,0 +1,150 @@
+package tcp
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/schollz/croc/v11/src/comm"
+	"github.com/schollz/croc/v11/src/relayauth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRelayAuthTokens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.yaml")
+	require.NoError(t, (&relayauth.File{Tokens: []relayauth.Entry{
+		{Name: "alice", Hash: relayauth.HashToken("alice-token"), Quota: relayauth.Quota{MaxConnections: 1}},
+		{Name: "bob", Hash: relayauth.HashToken("bob-token")},
+	}}).WriteFile(path))
+	auth, err := relayauth.Load(path)
+	require.NoError(t, err)
+	events := new(recordedRoomEvents)
+	_, address, stopServer := startConfiguredTestServer(t, WithRelayAuth(auth), WithRoomEventSink(events))
+	defer stopServer()
+
+	_, _, _, err = ConnectToTCPServer(address, "pass123", "authenticated")
+	assert.ErrorIs(t, err, ErrRelayTokenRejected)
+	assert.ErrorIs(t, err, relayauth.ErrInvalidToken)
+
+	first, _, _, err := ConnectToTCPServer(address, "alice-token", "authenticated")
+	require.NoError(t, err)
+	defer first.Close()
+	_, _, _, err = ConnectToTCPServer(address, "alice-token", "other")
+	assert.ErrorIs(t, err, ErrRelayQuotaExceeded)
+	assert.NotContains(t, err.Error(), "alice-token")
+
+	second, _, _, err := ConnectToTCPServer(address, "bob-token", "authenticated")
+	require.NoError(t, err)
+	payload := []byte("authenticated payload")
+	require.NoError(t, second.Send(payload))
+	for {
+		got, receiveErr := first.Receive()
+		require.NoError(t, receiveErr)
+		if bytes.Equal(got, []byte{1}) {
+			continue
+		}
+		assert.Equal(t, payload, got)
+		break
+	}
+	second.Close()
+
+	recorded := events.waitFor(t, 3)
+	byEvent := make(map[string]RoomEvent)
+	for _, event := range recorded {
+		byEvent[event.Event] = event
+	}
+	assert.Equal(t, []string{"alice"}, byEvent[RoomOpened].Users)
+	assert.Equal(t, []string{"alice", "bob"}, byEvent[RoomPaired].Users)
+	assert.Equal(t, []string{"alice", "bob"}, byEvent[RoomClosed].Users)
+
+	// closing the room returns the connection to alice's quota
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		again, _, _, connectErr := ConnectToTCPServer(address, "alice-token", "again")
+		if connectErr == nil {
+			again.Close()
+			break
+		}
+		require.ErrorIs(t, connectErr, ErrRelayQuotaExceeded)
+		if time.Now().After(deadline) {
+			t.Fatal("lease was not released with the room")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestRelayAuthByteQuota(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.yaml")
+	require.NoError(t, (&relayauth.File{Tokens: []relayauth.Entry{
+		{Name: "alice", Hash: relayauth.HashToken("alice-token"), Quota: relayauth.Quota{MaxBytesPerDay: 1000}},
+		{Name: "bob", Hash: relayauth.HashToken("bob-token")},
+	}}).WriteFile(path))
+	auth, err := relayauth.Load(path)
+	require.NoError(t, err)
+	events := new(recordedRoomEvents)
+	_, address, stopServer := startConfiguredTestServer(t, WithRelayAuth(auth), WithRoomEventSink(events))
+	defer stopServer()
+
+	// receive returns the first payload that is not a keepalive
+	receive := func(c *comm.Comm) ([]byte, error) {
+		for {
+			got, err := c.Receive()
+			if err != nil || !bytes.Equal(got, []byte{1}) {
+				return got, err
+			}
+		}
+	}
+
+	// bob's bytes are not charged to alice
+	alice, _, _, err := ConnectToTCPServer(address, "alice-token", "download")
+	require.NoError(t, err)
+	bob, _, _, err := ConnectToTCPServer(address, "bob-token", "download")
+	require.NoError(t, err)
+	payload := bytes.Repeat([]byte("b"), 4000)
+	require.NoError(t, bob.Send(payload))
+	got, err := receive(alice)
+	require.NoError(t, err)
+	assert.Equal(t, payload, got)
+	bob.Close()
+	alice.Close()
+	closed := events.waitFor(t, 3)[2]
+	assert.Equal(t, RoomClosed, closed.Event)
+	assert.Equal(t, ReasonComplete, closed.Reason)
+
+	// alice's own bytes cut the pipe once her daily bytes are used up
+	alice, _, _, err = ConnectToTCPServer(address, "alice-token", "upload")
+	require.NoError(t, err)
+	defer alice.Close()
+	bob, _, _, err = ConnectToTCPServer(address, "bob-token", "upload")
+	require.NoError(t, err)
+	defer bob.Close()
+	require.NoError(t, alice.Send(bytes.Repeat([]byte("a"), 4000)))
+	done := make(chan error, 1)
+	go func() {
+		for {
+			if _, receiveErr := bob.Receive(); receiveErr != nil {
+				done <- receiveErr
+				return
+			}
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the pipe outlived the byte quota")
+	}
+	closed = events.waitFor(t, 6)[5]
+	assert.Equal(t, RoomClosed, closed.Event)
+	assert.Equal(t, ReasonQuotaExceeded, closed.Reason)
+	_, _, _, err = ConnectToTCPServer(address, "alice-token", "again")
+	assert.ErrorIs(t, err, ErrRelayQuotaExceeded)
+}
+
+func TestRelayAuthErrors(t *testing.T) {
+	assert.EqualError(t, relayAuthError([]byte("bad password")), "bad password")
+	assert.ErrorIs(t, relayAuthError([]byte("bad token")), ErrRelayTokenRejected)
+	assert.ErrorIs(t, relayAuthError([]byte("quota exceeded")), ErrRelayQuotaExceeded)
+	assert.EqualError(t, relayAuthError([]byte("nope")), "invalid relay response")
+}
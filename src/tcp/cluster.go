@@ -0,0 +1,218 @@
+package tcp
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/schollz/croc/v11/src/comm"
+	"github.com/schollz/croc/v11/src/crypt"
+	"github.com/schollz/croc/v11/src/relaycluster"
+	log "github.com/schollz/logger"
+)
+
+// clusterKeyPrefix namespaces room claims in the cluster backend. Rooms are
+// claimed by the hash of their name, so the backend never sees room names.
+const clusterKeyPrefix = "croc:room:"
+
+var (
+	// clusterClaimTTL is how long a claim outlives a node that stops
+	// renewing it.
+	clusterClaimTTL      = 30 * time.Second
+	clusterRenewInterval = 10 * time.Second
+	// clusterTimeout bounds each backend call and connecting to another node.
+	clusterTimeout = 2 * time.Second
+)
+
+// errRoomForwarded ends the handling of a connection that was relayed
+// through the cluster node holding its room.
+var errRoomForwarded = errors.New("room relayed through another cluster node")
+
+// WithCluster makes the relay one node of a cluster that shares room claims
+// through backend. A peer whose room is held by another node is relayed
+// through that node, which other nodes reach at host on this relay's port
+// and authenticate to with secret. A nil backend keeps the relay standalone.
+func WithCluster(backend relaycluster.Backend, host, secret string) serverOptsFunc {
+	return func(s *server) error {
+		if backend == nil {
+			s.cluster = nil
+			return nil
+		}
+		if host = strings.TrimSpace(host); host == "" {
+			return fmt.Errorf("cluster node host must not be empty")
+		}
+		if secret = strings.TrimSpace(secret); secret == "" {
+			return fmt.Errorf("cluster secret must not be empty")
+		}
+		s.cluster, s.clusterHost, s.clusterSecret = backend, host, secret
+		return nil
+	}
+}
+
+// clusterNode is the address other nodes reach this relay at.
+func (s *server) clusterNode() string {
+	return net.JoinHostPort(s.clusterHost, s.port)
+}
+
+func clusterKey(room string) string {
+	return clusterKeyPrefix + hashRoom(room)
+}
+
+func clusterContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), clusterTimeout)
+}
+
+// isClusterSecret reports whether password is the secret cluster nodes
+// forward peers with.
+func (s *server) isClusterSecret(password string) bool {
+	if s.cluster == nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimSpace(password)), []byte(s.clusterSecret)) == 1
+}
+
+// roomHolder claims room for this node unless it is open here already or
+// another node holds it, and returns that other node's address. Without a
+// reachable backend the room stays on this node.
+func (s *server) roomHolder(room string) string {
+	s.rooms.Lock()
+	_, local := s.rooms.rooms[room]
+	s.rooms.Unlock()
+	if local {
+		return ""
+	}
+	ctx, cancel := clusterContext()
+	defer cancel()
+	holder, err := s.cluster.Claim(ctx, clusterKey(room), s.clusterNode(), clusterClaimTTL)
+	if err != nil {
+		log.Warnf("cluster backend unavailable, serving room on this node: %v", err)
+		return ""
+	}
+	if holder == s.clusterNode() {
+		return ""
+	}
+	return holder
+}
+
+// claimRoom records that this node holds room.
+func (s *server) claimRoom(room string) {
+	ctx, cancel := clusterContext()
+	defer cancel()
+	holder, err := s.cluster.Claim(ctx, clusterKey(room), s.clusterNode(), clusterClaimTTL)
+	if err != nil {
+		log.Debugf("could not claim room: %v", err)
+	} else if holder != s.clusterNode() {
+		log.Debugf("room is also open on cluster node %s", holder)
+	}
+}
+
+// releaseRoom removes this node's claim on a closed room.
+func (s *server) releaseRoom(room string) {
+	if s.cluster == nil {
+		return
+	}
+	ctx, cancel := clusterContext()
+	defer cancel()
+	if err := s.cluster.Release(ctx, clusterKey(room), s.clusterNode()); err != nil {
+		log.Debugf("could not release room claim: %v", err)
+	}
+}
+
+// releaseUnopenedRoom removes the claim roomHolder took for room when the
+// peer was refused before the room opened on this node.
+func (s *server) releaseUnopenedRoom(room string) {
+	s.rooms.Lock()
+	_, open := s.rooms.rooms[room]
+	s.rooms.Unlock()
+	if !open {
+		s.releaseRoom(room)
+	}
+}
+
+// renewRoomClaims keeps this node's claims on its open rooms alive until the
+// relay stops, claiming rooms again whose claims were lost.
+func (s *server) renewRoomClaims() {
+	ticker := time.NewTicker(clusterRenewInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s.rooms.Lock()
+		rooms := slices.Collect(maps.Keys(s.rooms.rooms))
+		s.rooms.Unlock()
+		for _, room := range rooms {
+			ctx, cancel := clusterContext()
+			renewed, err := s.cluster.Renew(ctx, clusterKey(room), s.clusterNode(), clusterClaimTTL)
+			cancel()
+			if err != nil {
+				log.Debugf("could not renew room claim: %v", err)
+			} else if !renewed {
+				s.claimRoom(room)
+			}
+		}
+	}
+}
+
+// forwardRoom joins the peer's room on the cluster node at holder and relays
+// c through it until the room closes. It returns false, after removing the
+// node's claim on the room, if the node cannot be reached.
+func (s *server) forwardRoom(c *comm.Comm, holder string, handshake handshakeResult) (bool, error) {
+	var reservation *Reservation
+	if handshake.reservation != nil {
+		reservation = &Reservation{
+			Token: handshake.reservation.Token,
+			Hold:  time.Duration(handshake.reservation.Hold) * time.Second,
+		}
+	}
+	upstream, err := comm.NewConnection(holder, clusterTimeout)
+	var reply []byte
+	if err == nil {
+		if err = upstream.Connection().SetDeadline(time.Now().Add(clusterTimeout)); err == nil {
+			reply, _, _, _, err = requestRoom(upstream, s.clusterSecret, handshake.room, reservation)
+		}
+		if err == nil {
+			err = upstream.Connection().SetDeadline(time.Time{})
+		}
+	}
+	if err != nil {
+		if upstream != nil {
+			upstream.Close()
+		}
+		log.Warnf("cluster node %s unavailable, taking over its room: %v", holder, err)
+		ctx, cancel := clusterContext()
+		defer cancel()
+		if releaseErr := s.cluster.Release(ctx, clusterKey(handshake.room), holder); releaseErr != nil {
+			log.Debugf("could not release room claim: %v", releaseErr)
+		}
+		return false, nil
+	}
+	defer upstream.Close()
+
+	bSend, err := crypt.Encrypt(reply, handshake.strongKeyForEncryption)
+	if err != nil {
+		return true, err
+	}
+	if err = c.Send(bSend); err != nil {
+		return true, err
+	}
+	if !bytes.Equal(reply, []byte("ok")) {
+		return true, fmt.Errorf("cluster node %s refused room: %s", holder, reply)
+	}
+	log.Debugf("relaying room through cluster node %s", holder)
+	// the node holding the room charges the other peer's lease
+	if err = pipe(c.Connection(), upstream.Connection(), nil, handshake.lease, nil); err != nil {
+		log.Debugf("room %s: %v", hashRoom(handshake.room), err)
+	}
+	handshake.lease.Release()
+	return true, errRoomForwarded
+}
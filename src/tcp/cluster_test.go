@@ -0,0 +1,142 @@
+package tcp
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/schollz/croc/v11/src/comm"
+	"github.com/schollz/croc/v11/src/relaycluster"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// receivePayload reads the next frame from c that is not a relay ping.
+func receivePayload(t *testing.T, c *comm.Comm) []byte {
+	t.Helper()
+	for {
+		got, err := c.Receive()
+		require.NoError(t, err)
+		if !bytes.Equal(got, []byte{1}) {
+			return got
+		}
+	}
+}
+
+func TestWithClusterOption(t *testing.T) {
+	s := newDefaultServer()
+	defer s.stop.Cancel()
+	backend := relaycluster.NewMemory()
+
+	assert.Error(t, WithCluster(backend, " ", "secret")(s))
+	assert.Error(t, WithCluster(backend, "10.0.0.5", "")(s))
+	require.NoError(t, WithCluster(backend, " 10.0.0.5 ", " secret ")(s))
+	s.port = "9009"
+	assert.Equal(t, "10.0.0.5:9009", s.clusterNode())
+	assert.True(t, s.isClusterSecret("secret\n"))
+	assert.False(t, s.isClusterSecret("pass123"))
+	require.NoError(t, WithCluster(nil, "", "")(s))
+	assert.Nil(t, s.cluster)
+	assert.False(t, s.isClusterSecret("secret"))
+}
+
+func TestClusterForwardsPeersToRoomHolder(t *testing.T) {
+	backend := relaycluster.NewMemory()
+	nodeA, addressA, stopA := startConfiguredTestServer(t, WithCluster(backend, "127.0.0.1", "cluster-secret"))
+	defer stopA()
+	nodeB, addressB, stopB := startConfiguredTestServer(t, WithCluster(backend, "127.0.0.1", "cluster-secret"))
+	defer stopB()
+
+	first, _, _, err := ConnectToTCPServer(addressA, "pass123", "clustered")
+	require.NoError(t, err)
+	defer first.Close()
+	holder, err := backend.Claim(context.Background(), clusterKey("clustered"), nodeB.clusterNode(), time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, nodeA.clusterNode(), holder)
+
+	second, _, _, err := ConnectToTCPServer(addressB, "pass123", "clustered")
+	require.NoError(t, err)
+	defer second.Close()
+	require.NoError(t, second.Send([]byte("through node b")))
+	assert.Equal(t, []byte("through node b"), receivePayload(t, first))
+	require.NoError(t, first.Send([]byte("back from node a")))
+	assert.Equal(t, []byte("back from node a"), receivePayload(t, second))
+
+	nodeB.rooms.Lock()
+	assert.NotContains(t, nodeB.rooms.rooms, "clustered")
+	nodeB.rooms.Unlock()
+
+	// the holder refuses a third peer, and the forwarding node passes that on
+	_, _, _, err = ConnectToTCPServer(addressB, "pass123", "clustered")
+	assert.Error(t, err)
+
+	second.Close()
+	waitForConnectionClose(t, first)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		holder, err = backend.Claim(context.Background(), clusterKey("clustered"), nodeB.clusterNode(), time.Minute)
+		require.NoError(t, err)
+		if holder == nodeB.clusterNode() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("closed room was not released")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestClusterTakesOverRoomsOfUnreachableNodes(t *testing.T) {
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	stopped := probe.Addr().String()
+	probe.Close()
+
+	backend := relaycluster.NewMemory()
+	_, err = backend.Claim(context.Background(), clusterKey("orphaned"), stopped, time.Minute)
+	require.NoError(t, err)
+	node, address, stopNode := startConfiguredTestServer(t, WithCluster(backend, "127.0.0.1", "cluster-secret"))
+	defer stopNode()
+
+	first, _, _, err := ConnectToTCPServer(address, "pass123", "orphaned")
+	require.NoError(t, err)
+	defer first.Close()
+	node.rooms.Lock()
+	assert.Contains(t, node.rooms.rooms, "orphaned")
+	node.rooms.Unlock()
+	holder, err := backend.Claim(context.Background(), clusterKey("orphaned"), stopped, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, node.clusterNode(), holder)
+}
+
+func TestClusterReleasesClaimOfRefusedRoom(t *testing.T) {
+	backend := relaycluster.NewMemory()
+	node, address, stopNode := startConfiguredTestServer(t,
+		WithCluster(backend, "127.0.0.1", "cluster-secret"),
+		WithReservations([]string{"secret"}, time.Hour, 1))
+	defer stopNode()
+
+	reserved, _, _, err := ReserveRoom(address, "pass123", "reserved-room", Reservation{Token: "secret", Hold: time.Hour})
+	require.NoError(t, err)
+	defer reserved.Close()
+	_, _, _, err = ReserveRoom(address, "pass123", "refused-room", Reservation{Token: "secret", Hold: time.Hour})
+	assert.ErrorIs(t, err, ErrReservationsFull)
+
+	holder, err := backend.Claim(context.Background(), clusterKey("refused-room"), "10.0.0.9:9009", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.9:9009", holder, "a refused room must not stay claimed")
+	holder, err = backend.Claim(context.Background(), clusterKey("reserved-room"), "10.0.0.9:9009", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, node.clusterNode(), holder)
+}
+
+func TestClusterRejectsTLS(t *testing.T) {
+	s := newDefaultServer()
+	defer s.stop.Cancel()
+	require.NoError(t, WithCluster(relaycluster.NewMemory(), "127.0.0.1", "cluster-secret")(s))
+	s.tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	assert.ErrorContains(t, s.start(), "TLS")
+}
@@ -12,4 +12,6 @@ const (
 	DEFAULT_SOURCE_JOIN_LIMIT      = 30
 	DEFAULT_ROOM_JOIN_LIMIT        = 30
 	DEFAULT_JOIN_LIMIT_WINDOW      = time.Minute
+	DEFAULT_MAX_RESERVATION_HOLD   = 24 * time.Hour
+	DEFAULT_MAX_RESERVED_ROOMS     = 64
 )
@@ -0,0 +1,36 @@
+package tcp
+
+import (
+	"sync"
+
+	"github.com/schollz/croc/v11/src/comm"
+)
+
+// Dialer connects one client to relays. It reaches the host of each relay
+// given as tls://, ws:// or wss:// with that relay's transport and remembers
+// which relays offered QUIC, so that clients in one process share neither.
+// The zero Dialer reaches every relay over plain TCP, and a nil Dialer
+// also remembers nothing.
+type Dialer struct {
+	transports []comm.Transport
+	quic       sync.Map
+}
+
+// NewDialer returns a Dialer that reaches the hosts of transports with them
+// and every other relay, such as a peer's local relay, over plain TCP.
+func NewDialer(transports ...comm.Transport) *Dialer {
+	return &Dialer{transports: transports}
+}
+
+// Transport returns the transport d reaches the relay at address with.
+func (d *Dialer) Transport(address string) comm.Transport {
+	if d == nil {
+		return comm.Transport{}
+	}
+	for _, transport := range d.transports {
+		if transport.Applies(address) {
+			return transport
+		}
+	}
+	return comm.Transport{}
+}
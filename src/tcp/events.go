@@ -0,0 +1,223 @@
+package tcp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/schollz/logger"
+)
+
+// Room lifecycle event names.
+const (
+	RoomOpened   = "opened"
+	RoomPaired   = "paired"
+	RoomClosed   = "closed"
+	RoomRejected = "rejected"
+)
+
+// Reasons recorded with closed and rejected room events.
+const (
+	ReasonComplete    = "complete"
+	ReasonPeerGone    = "peer_gone"
+	ReasonSendFailed  = "send_failed"
+	ReasonTTL         = "ttl"
+	ReasonEvicted     = "evicted"
+	ReasonShutdown    = "shutdown"
+	ReasonRoomFull    = "room_full"
+	ReasonRateLimited = "rate_limited"
+
+	ReasonReservationRejected = "reservation_rejected"
+	ReasonReservationsFull    = "reservations_full"
+
+	// Rooms closed from the admin socket.
+	ReasonAdminEvicted = "admin_evicted"
+	ReasonBanned       = "banned"
+
+	// Rooms closed when a peer's user runs out of daily relay bytes.
+	ReasonQuotaExceeded = "quota_exceeded"
+)
+
+// RoomEvent is one relay room lifecycle record. Room is a SHA-256 digest of
+// the room name, so that records can be correlated without revealing it.
+type RoomEvent struct {
+	Time     time.Time `json:"time"`
+	Event    string    `json:"event"`
+	Port     string    `json:"port"`
+	Room     string    `json:"room"`
+	Sources  []string  `json:"sources,omitempty"`
+	Users    []string  `json:"users,omitempty"`
+	OpenedAt time.Time `json:"openedAt,omitzero"`
+	PairedAt time.Time `json:"pairedAt,omitzero"`
+	ClosedAt time.Time `json:"closedAt,omitzero"`
+	Bytes    int64     `json:"bytes,omitempty"`
+	Reason   string    `json:"reason,omitempty"`
+	Reserved bool      `json:"reserved,omitempty"`
+}
+
+// RoomEventSink receives room lifecycle events. Implementations must be safe
+// for concurrent use; a relay with several ports shares one sink.
+type RoomEventSink interface {
+	WriteRoomEvent(RoomEvent) error
+}
+
+// roomStats accumulates what a room's closing event reports. Sources, users
+// and paired are guarded by the room map mutex.
+type roomStats struct {
+	sources     []string
+	users       []string
+	paired      time.Time
+	transferred atomic.Int64
+}
+
+func hashRoom(room string) string {
+	sum := sha256.Sum256([]byte(room))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *server) emitRoomEvent(event RoomEvent) {
+	if s.roomEvents == nil {
+		return
+	}
+	event.Time = time.Now().UTC()
+	event.Port = s.port
+	if err := s.roomEvents.WriteRoomEvent(event); err != nil {
+		log.Warnf("could not record room event: %v", err)
+	}
+}
+
+// closedRoomEvent describes a room being removed. It must be called with the
+// room map locked.
+func closedRoomEvent(room string, roomData roomInfo, reason string) RoomEvent {
+	event := RoomEvent{
+		Event:    RoomClosed,
+		Room:     hashRoom(room),
+		OpenedAt: roomData.opened,
+		ClosedAt: time.Now().UTC(),
+		Reason:   reason,
+		Reserved: roomData.reserved,
+	}
+	if roomData.stats != nil {
+		event.Sources = append([]string(nil), roomData.stats.sources...)
+		event.Users = append([]string(nil), roomData.stats.users...)
+		event.PairedAt = roomData.stats.paired
+		event.Bytes = roomData.stats.transferred.Load()
+	}
+	return event
+}
+
+// RoomEventFile appends room events as JSON lines and rotates the file once
+// it would exceed its size limit, keeping numbered backups. When rotation
+// fails it keeps appending to the current file and tries again on a later
+// write.
+type RoomEventFile struct {
+	mu       sync.Mutex
+	path     string
+	maxBytes int64
+	backups  int
+	file     *os.File
+	size     int64
+	closed   bool
+}
+
+// OpenRoomEventFile opens path for appending with mode 0600. A non-positive
+// maxBytes disables rotation.
+func OpenRoomEventFile(path string, maxBytes int64, backups int) (*RoomEventFile, error) {
+	if backups < 0 {
+		return nil, errors.New("event log backups must not be negative")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	f := &RoomEventFile{path: path, maxBytes: maxBytes, backups: backups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RoomEventFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *RoomEventFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+	if f.backups == 0 {
+		if err := os.Remove(f.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return f.open()
+	}
+	for i := f.backups - 1; i >= 1; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if err := os.Rename(f.path, f.path+".1"); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+// WriteRoomEvent appends one event, reopening the file if an earlier
+// rotation left it closed.
+func (f *RoomEventFile) WriteRoomEvent(event RoomEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return os.ErrClosed
+	}
+	if f.file != nil && f.maxBytes > 0 && f.size > 0 && f.size+int64(len(line)) > f.maxBytes {
+		if err = f.rotate(); err != nil {
+			log.Warnf("could not rotate room event log %s: %v", f.path, err)
+		}
+	}
+	if f.file == nil {
+		if err = f.open(); err != nil {
+			return fmt.Errorf("reopen event log: %w", err)
+		}
+	}
+	n, err := f.file.Write(line)
+	f.size += int64(n)
+	return err
+}
+
+// Close closes the current file.
+func (f *RoomEventFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
@@ -0,0 +1,10 @@
+//go:build windows || plan9
+
+package tcp
+
+import "errors"
+
+// DialRoomEventSyslog is unavailable on platforms without log/syslog.
+func DialRoomEventSyslog(network, address, tag string) (RoomEventSink, error) {
+	return nil, errors.New("syslog event logging is not supported on this platform")
+}
@@ -0,0 +1,34 @@
+//go:build !windows && !plan9
+
+package tcp
+
+import (
+	"encoding/json"
+	"log/syslog"
+)
+
+type roomEventSyslog struct {
+	writer *syslog.Writer
+}
+
+// DialRoomEventSyslog sends room events as JSON messages to syslog. An empty
+// network and address use the local syslog daemon.
+func DialRoomEventSyslog(network, address, tag string) (RoomEventSink, error) {
+	writer, err := syslog.Dial(network, address, syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &roomEventSyslog{writer: writer}, nil
+}
+
+func (l *roomEventSyslog) WriteRoomEvent(event RoomEvent) error {
+	message, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return l.writer.Info(string(message))
+}
+
+func (l *roomEventSyslog) Close() error {
+	return l.writer.Close()
+}
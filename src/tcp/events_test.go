@@ -0,0 +1,184 @@
+package tcp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordedRoomEvents struct {
+	mu     sync.Mutex
+	events []RoomEvent
+}
+
+func (r *recordedRoomEvents) WriteRoomEvent(event RoomEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+	return nil
+}
+
+func (r *recordedRoomEvents) waitFor(t *testing.T, count int) []RoomEvent {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		r.mu.Lock()
+		events := append([]RoomEvent(nil), r.events...)
+		r.mu.Unlock()
+		if len(events) >= count {
+			return events
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("recorded %d room events, want %d: %+v", len(events), count, events)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRoomEventsRecordLifecycle(t *testing.T) {
+	events := new(recordedRoomEvents)
+	_, address, stopServer := startConfiguredTestServer(t, WithRoomEventSink(events))
+	defer stopServer()
+
+	first, _, _, err := ConnectToTCPServer(address, "pass123", "audited")
+	require.NoError(t, err)
+	defer first.Close()
+	second, _, _, err := ConnectToTCPServer(address, "pass123", "audited")
+	require.NoError(t, err)
+	_, _, _, err = ConnectToTCPServer(address, "pass123", "audited")
+	assert.Error(t, err)
+
+	payload := []byte("audited payload")
+	require.NoError(t, second.Send(payload))
+	for {
+		got, receiveErr := first.Receive()
+		require.NoError(t, receiveErr)
+		if bytes.Equal(got, []byte{1}) {
+			continue
+		}
+		assert.Equal(t, payload, got)
+		break
+	}
+	second.Close()
+
+	recorded := events.waitFor(t, 4)
+	room := hashRoom("audited")
+	byEvent := make(map[string]RoomEvent)
+	for _, event := range recorded {
+		assert.Equal(t, room, event.Room)
+		assert.NotContains(t, event.Room, "audited")
+		byEvent[event.Event] = event
+	}
+	assert.Equal(t, []string{"127.0.0.1"}, byEvent[RoomOpened].Sources)
+	assert.Equal(t, []string{"127.0.0.1", "127.0.0.1"}, byEvent[RoomPaired].Sources)
+	assert.Equal(t, ReasonRoomFull, byEvent[RoomRejected].Reason)
+
+	closed := byEvent[RoomClosed]
+	assert.Equal(t, ReasonComplete, closed.Reason)
+	assert.False(t, closed.OpenedAt.IsZero())
+	assert.False(t, closed.PairedAt.IsZero())
+	assert.False(t, closed.ClosedAt.Before(closed.PairedAt))
+	assert.GreaterOrEqual(t, closed.Bytes, int64(len(payload)))
+}
+
+func TestRoomEventsRecordEvictionAndTTL(t *testing.T) {
+	events := new(recordedRoomEvents)
+	_, address, stopServer := startConfiguredTestServer(t,
+		WithMaxRoomsOpen(1),
+		WithRoomEventSink(events),
+		WithRoomCleanupInterval(50*time.Millisecond),
+		WithRoomTTL(time.Second),
+	)
+	defer stopServer()
+
+	oldest, _, _, err := ConnectToTCPServer(address, "pass123", "oldest")
+	require.NoError(t, err)
+	defer oldest.Close()
+	replacement, _, _, err := ConnectToTCPServer(address, "pass123", "replacement")
+	require.NoError(t, err)
+	defer replacement.Close()
+
+	reasons := make(map[string]string)
+	deadline := time.Now().Add(3 * time.Second)
+	for len(reasons) < 2 && time.Now().Before(deadline) {
+		for _, event := range events.waitFor(t, 1) {
+			if _, seen := reasons[event.Room]; event.Event == RoomClosed && !seen {
+				reasons[event.Room] = event.Reason
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, map[string]string{
+		hashRoom("oldest"):      ReasonEvicted,
+		hashRoom("replacement"): ReasonTTL,
+	}, reasons)
+}
+
+func TestRoomEventFileRotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "rooms.jsonl")
+	eventLog, err := OpenRoomEventFile(path, 200, 2)
+	require.NoError(t, err)
+	for i := 0; i < 8; i++ {
+		require.NoError(t, eventLog.WriteRoomEvent(RoomEvent{
+			Event:  RoomClosed,
+			Room:   hashRoom("rotated"),
+			Reason: ReasonComplete,
+		}))
+	}
+	require.NoError(t, eventLog.Close())
+	assert.ErrorIs(t, eventLog.WriteRoomEvent(RoomEvent{}), os.ErrClosed)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	assert.LessOrEqual(t, info.Size(), int64(200))
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		file, openErr := os.Open(name)
+		require.NoError(t, openErr)
+		scanner := bufio.NewScanner(file)
+		lines := 0
+		for scanner.Scan() {
+			var event RoomEvent
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+			assert.Equal(t, RoomClosed, event.Event)
+			lines++
+		}
+		file.Close()
+		assert.Positive(t, lines, name)
+	}
+	_, err = os.Stat(path + ".3")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestRoomEventFileKeepsWritingWhenRotationFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rooms.jsonl")
+	// A non-empty directory in place of the backup makes the rename fail.
+	require.NoError(t, os.MkdirAll(filepath.Join(path+".1", "blocker"), 0o700))
+	eventLog, err := OpenRoomEventFile(path, 200, 1)
+	require.NoError(t, err)
+	defer eventLog.Close()
+	event := RoomEvent{Event: RoomClosed, Room: hashRoom("rotated"), Reason: ReasonComplete}
+	for i := 0; i < 4; i++ {
+		require.NoError(t, eventLog.WriteRoomEvent(event))
+	}
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Greater(t, info.Size(), int64(200))
+
+	require.NoError(t, os.RemoveAll(path+".1"))
+	require.NoError(t, eventLog.WriteRoomEvent(event))
+	info, err = os.Stat(path + ".1")
+	require.NoError(t, err)
+	assert.True(t, info.Mode().IsRegular())
+	info, err = os.Stat(path)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, info.Size(), int64(200))
+}
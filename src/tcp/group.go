@@ -0,0 +1,59 @@
+package tcp
+
+import (
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Group holds the servers started for the ports of one relay, so that its
+// QUIC listener can hand each stream to the server of the port it names and
+// its admin socket reaches every port. A server started without a group is
+// in a group of its own.
+type Group struct {
+	servers sync.Map
+}
+
+// NewGroup returns an empty group.
+func NewGroup() *Group {
+	return new(Group)
+}
+
+// WithGroup runs the server in group, next to the other ports of its relay.
+// A nil group keeps the server on its own.
+func WithGroup(group *Group) serverOptsFunc {
+	return func(s *server) error {
+		if group != nil {
+			s.group = group
+		}
+		return nil
+	}
+}
+
+func (g *Group) add(s *server) {
+	g.servers.Store(s.port, s)
+}
+
+func (g *Group) remove(s *server) {
+	g.servers.CompareAndDelete(s.port, s)
+}
+
+// server returns the running server of port.
+func (g *Group) server(port string) (*server, bool) {
+	value, ok := g.servers.Load(port)
+	if !ok {
+		return nil, false
+	}
+	return value.(*server), true
+}
+
+// list returns the running servers by port.
+func (g *Group) list() []*server {
+	var servers []*server
+	g.servers.Range(func(_, value any) bool {
+		servers = append(servers, value.(*server))
+		return true
+	})
+	slices.SortFunc(servers, func(a, b *server) int { return strings.Compare(a.port, b.port) })
+	return servers
+}
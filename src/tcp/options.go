@@ -94,6 +94,15 @@ func WithRoomPairedCallback(callback func()) serverOptsFunc {
 	}
 }
 
+// WithRoomEventSink records room lifecycle events to sink. The sink is
+// shared, not closed, by the server.
+func WithRoomEventSink(sink RoomEventSink) serverOptsFunc {
+	return func(s *server) error {
+		s.roomEvents = sink
+		return nil
+	}
+}
+
 func WithRoomCleanupInterval(interval time.Duration) serverOptsFunc {
 	return func(s *server) error {
 		s.roomCleanupInterval = interval
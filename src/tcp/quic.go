@@ -0,0 +1,189 @@
+package tcp
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/schollz/logger"
+	"golang.org/x/net/quic"
+
+	"github.com/schollz/croc/v11/src/comm"
+	"github.com/schollz/croc/v11/src/redact"
+)
+
+// featureQUIC is advertised in the handshake response of a relay that also
+// accepts QUIC on the UDP port of the same number.
+const featureQUIC = "quic"
+
+// maxStreamHeader bounds the port a QUIC stream names before the handshake.
+const maxStreamHeader = 5
+
+// WithQUIC, when enabled, also serves the relay over QUIC on the UDP port
+// with the same number. One QUIC connection carries a stream for each of the
+// ports listed in the banner, so clients need a single UDP port instead of
+// one TCP connection per port. The servers of those ports must be in the
+// server's group (see WithGroup); rooms are then shared by clients
+// connecting over TCP and over QUIC.
+func WithQUIC(enabled bool) serverOptsFunc {
+	return func(s *server) error {
+		s.quic = enabled
+		return nil
+	}
+}
+
+// SupportsQUIC reports whether the relay at address advertised QUIC when d
+// last connected to it.
+func (d *Dialer) SupportsQUIC(address string) bool {
+	if d == nil {
+		return false
+	}
+	_, ok := d.quic.Load(address)
+	return ok
+}
+
+func (d *Dialer) rememberFeatures(address string, features []string) {
+	if d == nil {
+		return
+	}
+	if slices.Contains(features, featureQUIC) {
+		d.quic.Store(address, struct{}{})
+	} else {
+		d.quic.Delete(address)
+	}
+}
+
+// ConnectToQUICServer opens a QUIC connection to the relay at address and
+// joins rooms[i] on ports[i] of the relay over a stream for each. It returns
+// the joined streams in order, or closes all of them on error.
+func (d *Dialer) ConnectToQUICServer(address, password string, ports, rooms []string, timelimit time.Duration) (conns []*comm.Comm, err error) {
+	defer func() { err = redact.Error(err, append([]string{password}, rooms...)...) }()
+	if len(ports) != len(rooms) {
+		return nil, fmt.Errorf("%d ports for %d rooms", len(ports), len(rooms))
+	}
+	streams, err := d.Transport(address).DialQUIC(address, len(ports), timelimit)
+	if err != nil {
+		log.Debug(err)
+		return nil, err
+	}
+	conns = make([]*comm.Comm, len(streams))
+	errs := make([]error, len(streams))
+	var wg sync.WaitGroup
+	for i, stream := range streams {
+		conns[i] = comm.New(stream)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if errs[i] = conns[i].Send([]byte(ports[i])); errs[i] != nil {
+				return
+			}
+			_, _, _, errs[i] = joinRoom(conns[i], password, rooms[i], nil)
+		}()
+	}
+	wg.Wait()
+	for _, joinErr := range errs {
+		if joinErr != nil {
+			for _, c := range conns {
+				c.Close()
+			}
+			log.Debug(joinErr)
+			return nil, joinErr
+		}
+	}
+	log.Debugf("joined %d rooms over QUIC", len(conns))
+	return conns, nil
+}
+
+// runQUIC accepts QUIC connections on addr until the server stops.
+func (s *server) runQUIC(network, addr string) error {
+	tlsConfig := s.tlsConfig
+	if tlsConfig == nil {
+		// clients that do not pin a certificate rely on the relay password
+		// and on end-to-end encryption, as they do over plain TCP
+		certPEM, keyPEM, err := selfSignedCertificate([]string{s.host})
+		if err != nil {
+			return err
+		}
+		certificate, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return err
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{certificate}}
+	}
+	endpoint, err := quic.Listen(network, addr, comm.QUICConfig(tlsConfig))
+	if err != nil {
+		return fmt.Errorf("error listening on %s: %w", addr, err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		endpoint.Close(ctx)
+	}()
+	log.Infof("starting QUIC server on %s", addr)
+	s.quicListening.Store(true)
+	defer s.quicListening.Store(false)
+
+	for {
+		conn, err := endpoint.Accept(s.stop.ctx)
+		if err != nil {
+			return Ignore(err)
+		}
+		log.Debugf("client %s connected over QUIC", conn.RemoteAddr())
+		s.stop.wg.Add(1)
+		go func() {
+			defer s.stop.wg.Done()
+			s.serveQUIC(conn)
+		}()
+	}
+}
+
+// serveQUIC relays every stream of a QUIC connection.
+func (s *server) serveQUIC(conn *quic.Conn) {
+	stop := context.AfterFunc(s.stop.ctx, func() {
+		conn.Abort(nil)
+	})
+	defer stop()
+	for {
+		stream, err := conn.AcceptStream(s.stop.ctx)
+		if err != nil {
+			log.Tracef("QUIC connection from %s ended: %v", conn.RemoteAddr(), err)
+			return
+		}
+		go s.dispatchStream(comm.StreamConn(conn, stream, nil))
+	}
+}
+
+// dispatchStream reads the port a stream is for and hands it to the server
+// of that port. Only the ports of this relay are served.
+func (s *server) dispatchStream(connection net.Conn) {
+	c := comm.New(connection)
+	if err := connection.SetDeadline(time.Now().Add(s.handshakeTimeout)); err != nil {
+		connection.Close()
+		return
+	}
+	header, err := c.Receive()
+	if err != nil || len(header) > maxStreamHeader {
+		log.Debugf("relay-%s: invalid QUIC stream header", connection.RemoteAddr())
+		connection.Close()
+		return
+	}
+	port := string(header)
+	if port != s.port && !slices.Contains(strings.Split(s.banner, ","), port) {
+		log.Debugf("relay-%s: QUIC stream for unknown port %q", connection.RemoteAddr(), port)
+		connection.Close()
+		return
+	}
+	target, ok := s.group.server(port)
+	if !ok {
+		connection.Close()
+		return
+	}
+	if err := target.serve(connection); err != nil {
+		log.Tracef("relay-%s: %v", connection.RemoteAddr(), err)
+	}
+}
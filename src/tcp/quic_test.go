@@ -0,0 +1,88 @@
+package tcp
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/schollz/croc/v11/src/comm"
+)
+
+func startQUICTestServer(t *testing.T) (*server, string, func()) {
+	t.Helper()
+	s, address, stopServer := startConfiguredTestServer(t, WithQUIC(true))
+	deadline := time.Now().Add(2 * time.Second)
+	for !s.quicListening.Load() {
+		if time.Now().After(deadline) {
+			stopServer()
+			t.Fatal("test server did not start listening over QUIC")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return s, address, stopServer
+}
+
+func receiveSkippingKeepalives(t *testing.T, c *comm.Comm) []byte {
+	t.Helper()
+	for {
+		data, err := c.Receive()
+		if err != nil {
+			t.Fatalf("receive: %v", err)
+		}
+		if !bytes.Equal(data, []byte{1}) {
+			return data
+		}
+	}
+}
+
+func TestQUICStreamJoinsRoomWithTCPPeer(t *testing.T) {
+	s, address, stopServer := startQUICTestServer(t)
+	defer stopServer()
+
+	dialer := NewDialer()
+	tcpConn, _, _, err := dialer.ConnectToTCPServer(address, "pass123", "quicRoom")
+	if err != nil {
+		t.Fatalf("connect over TCP: %v", err)
+	}
+	defer tcpConn.Close()
+	assert.True(t, dialer.SupportsQUIC(address))
+	assert.False(t, NewDialer().SupportsQUIC(address), "another client has not joined the relay")
+
+	conns, err := dialer.ConnectToQUICServer(address, "pass123", []string{s.port}, []string{"quicRoom"}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("connect over QUIC: %v", err)
+	}
+	defer conns[0].Close()
+
+	assert.NoError(t, conns[0].Send([]byte("hello over quic")))
+	assert.Equal(t, []byte("hello over quic"), receiveSkippingKeepalives(t, tcpConn))
+	assert.NoError(t, tcpConn.Send([]byte("hello over tcp")))
+	assert.Equal(t, []byte("hello over tcp"), receiveSkippingKeepalives(t, conns[0]))
+}
+
+func TestQUICRejectsWrongPasswordAndUnknownPort(t *testing.T) {
+	s, address, stopServer := startQUICTestServer(t)
+	defer stopServer()
+
+	dialer := NewDialer()
+	_, err := dialer.ConnectToQUICServer(address, "wrongpass", []string{s.port}, []string{"room"}, 5*time.Second)
+	assert.EqualError(t, err, "bad password")
+
+	_, err = dialer.ConnectToQUICServer(address, "pass123", []string{"1"}, []string{"room"}, 5*time.Second)
+	assert.Error(t, err)
+}
+
+func TestRelayWithoutQUICIsNotAdvertised(t *testing.T) {
+	address, stopServer := startTestServer(t, 1)
+	defer stopServer()
+
+	dialer := NewDialer()
+	c, _, _, err := dialer.ConnectToTCPServer(address, "pass123", "plainRoom")
+	if err != nil {
+		t.Fatalf("connect over TCP: %v", err)
+	}
+	defer c.Close()
+	assert.False(t, dialer.SupportsQUIC(address))
+}
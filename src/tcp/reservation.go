@@ -0,0 +1,164 @@
+package tcp
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/schollz/croc/v11/src/comm"
+	"github.com/schollz/croc/v11/src/redact"
+)
+
+// featureReservations is advertised in the handshake response of a relay
+// that accepts room reservations.
+const featureReservations = "reserve"
+
+// reservationPrefix marks a room frame that carries a reservation request
+// instead of a bare room name.
+const reservationPrefix = "reserve|||"
+
+// Errors returned when a relay refuses to reserve a room.
+var (
+	ErrReservationsUnsupported = errors.New("relay does not support room reservations")
+	ErrReservationRejected     = errors.New("relay rejected room reservation token")
+	ErrReservationsFull        = errors.New("relay has no free room reservations")
+)
+
+// Reservation asks the relay to hold a room open for up to Hold while its
+// first peer waits, instead of the relay's room TTL. The relay may shorten
+// Hold to its own maximum.
+type Reservation struct {
+	Token string
+	Hold  time.Duration
+}
+
+type reservationRequest struct {
+	Room  string `json:"room"`
+	Token string `json:"token"`
+	Hold  int64  `json:"hold"`
+}
+
+// WithReservations lets peers that present one of tokens reserve a room for
+// up to maxHold. At most maxRooms reserved rooms may wait at the same time;
+// they do not count toward, and are never evicted by, the max rooms open
+// limit. Without tokens, reservations stay disabled.
+func WithReservations(tokens []string, maxHold time.Duration, maxRooms int) serverOptsFunc {
+	return func(s *server) error {
+		if maxHold <= 0 {
+			return fmt.Errorf("max reservation hold must be positive")
+		}
+		if maxRooms <= 0 {
+			return fmt.Errorf("max reserved rooms must be positive")
+		}
+		var digests [][sha256.Size]byte
+		for _, token := range tokens {
+			if token = strings.TrimSpace(token); token != "" {
+				digests = append(digests, sha256.Sum256([]byte(token)))
+			}
+		}
+		s.reservationTokens = digests
+		s.maxReservationHold = maxHold
+		s.maxReservedRooms = maxRooms
+		return nil
+	}
+}
+
+// validReservationToken reports whether token is one of the configured
+// reservation tokens.
+func (s *server) validReservationToken(token string) bool {
+	digest := sha256.Sum256([]byte(token))
+	valid := 0
+	for _, candidate := range s.reservationTokens {
+		valid |= subtle.ConstantTimeCompare(digest[:], candidate[:])
+	}
+	return valid == 1
+}
+
+// reservationHold returns how long reservation may hold its room, capped at
+// the relay's maximum, or zero if its token is not accepted.
+func (s *server) reservationHold(reservation *reservationRequest) time.Duration {
+	if len(s.reservationTokens) == 0 || !s.validReservationToken(reservation.Token) {
+		return 0
+	}
+	hold := time.Duration(reservation.Hold) * time.Second
+	if hold <= 0 || hold > s.maxReservationHold {
+		hold = s.maxReservationHold
+	}
+	return hold
+}
+
+// parseRoomFrame splits the decrypted room frame into the room name and an
+// optional reservation request.
+func parseRoomFrame(frame []byte) (room string, reservation *reservationRequest, err error) {
+	payload, ok := strings.CutPrefix(string(frame), reservationPrefix)
+	if !ok {
+		return string(frame), nil, nil
+	}
+	reservation = new(reservationRequest)
+	if err = json.Unmarshal([]byte(payload), reservation); err != nil {
+		return "", nil, fmt.Errorf("invalid reservation request: %w", err)
+	}
+	if reservation.Room == "" {
+		return "", nil, fmt.Errorf("invalid reservation request: missing room")
+	}
+	return reservation.Room, reservation, nil
+}
+
+// roomFrame builds the room frame a client sends to join room, reserving it
+// if reservation is not nil.
+func roomFrame(room string, reservation *Reservation) ([]byte, error) {
+	if reservation == nil {
+		return []byte(room), nil
+	}
+	payload, err := json.Marshal(reservationRequest{
+		Room:  room,
+		Token: reservation.Token,
+		Hold:  int64(reservation.Hold.Round(time.Second) / time.Second),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(reservationPrefix), payload...), nil
+}
+
+// expired reports whether a room has outlived its reservation or, for rooms
+// without one, the relay's room TTL.
+func (r roomInfo) expired(now time.Time, ttl time.Duration) bool {
+	if !r.expires.IsZero() {
+		return now.After(r.expires)
+	}
+	return now.Sub(r.opened) > ttl
+}
+
+// ReserveRoom connects to the relay at address like ConnectToTCPServer but
+// asks it to hold room open for the reservation's hold time while waiting for
+// the other peer.
+func ReserveRoom(address, password, room string, reservation Reservation, timelimit ...time.Duration) (c *comm.Comm, banner string, ipaddr string, err error) {
+	return new(Dialer).ReserveRoom(address, password, room, reservation, timelimit...)
+}
+
+// ReserveRoom reserves room on the relay at address like the package's
+// ReserveRoom, with the transport of the address's host.
+func (d *Dialer) ReserveRoom(address, password, room string, reservation Reservation, timelimit ...time.Duration) (c *comm.Comm, banner string, ipaddr string, err error) {
+	defer func() { err = redact.Error(err, password, room, reservation.Token) }()
+	return d.connectToRoom(address, password, room, &reservation, timelimit...)
+}
+
+// roomRefusalError maps a relay's refusal of a room frame to an error.
+func roomRefusalError(data []byte) error {
+	switch string(data) {
+	case "rate limited":
+		return ErrAdmissionLimited
+	case "reservation rejected":
+		return ErrReservationRejected
+	case "reservations full":
+		return ErrReservationsFull
+	case "reservations unsupported":
+		return ErrReservationsUnsupported
+	}
+	return fmt.Errorf("relay admission rejected")
+}
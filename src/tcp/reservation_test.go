@@ -0,0 +1,204 @@
+package tcp
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReservationsOption(t *testing.T) {
+	s := newDefaultServer()
+	defer s.stop.Cancel()
+
+	assert.Error(t, WithReservations([]string{"secret"}, 0, 1)(s))
+	assert.Error(t, WithReservations([]string{"secret"}, time.Hour, 0)(s))
+	assert.NoError(t, WithReservations([]string{"", " "}, time.Hour, 1)(s))
+	assert.Empty(t, s.reservationTokens)
+
+	assert.NoError(t, WithReservations([]string{" secret ", "other"}, time.Hour, 3)(s))
+	assert.Len(t, s.reservationTokens, 2)
+	assert.Equal(t, time.Hour, s.maxReservationHold)
+	assert.Equal(t, 3, s.maxReservedRooms)
+	assert.True(t, s.validReservationToken("secret"))
+	assert.True(t, s.validReservationToken("other"))
+	assert.False(t, s.validReservationToken("wrong"))
+
+	assert.Equal(t, 30*time.Minute, s.reservationHold(&reservationRequest{Token: "secret", Hold: 1800}))
+	assert.Equal(t, time.Hour, s.reservationHold(&reservationRequest{Token: "secret", Hold: 86400}))
+	assert.Equal(t, time.Hour, s.reservationHold(&reservationRequest{Token: "secret"}))
+	assert.Zero(t, s.reservationHold(&reservationRequest{Token: "wrong", Hold: 60}))
+}
+
+func TestRoomFrameRoundTrip(t *testing.T) {
+	frame, err := roomFrame("room", nil)
+	assert.NoError(t, err)
+	room, reservation, err := parseRoomFrame(frame)
+	assert.NoError(t, err)
+	assert.Equal(t, "room", room)
+	assert.Nil(t, reservation)
+
+	frame, err = roomFrame("room", &Reservation{Token: "secret", Hold: 8 * time.Hour})
+	assert.NoError(t, err)
+	room, reservation, err = parseRoomFrame(frame)
+	assert.NoError(t, err)
+	assert.Equal(t, "room", room)
+	assert.Equal(t, &reservationRequest{Room: "room", Token: "secret", Hold: 8 * 3600}, reservation)
+
+	_, _, err = parseRoomFrame([]byte(reservationPrefix + "{"))
+	assert.Error(t, err)
+	_, _, err = parseRoomFrame([]byte(reservationPrefix + `{"token":"secret"}`))
+	assert.Error(t, err)
+}
+
+func TestReservedRoomsBypassWaitingRoomLimit(t *testing.T) {
+	s := newDefaultServer()
+	defer s.stop.Cancel()
+	s.maxRoomsOpen = 1
+	s.maxReservedRooms = 2
+	s.rooms.rooms = make(map[string]roomInfo)
+
+	reserved := s.admitToRoom("reserved", roomPeer{hold: time.Hour})
+	assert.True(t, reserved.created)
+	assert.True(t, s.rooms.rooms["reserved"].reserved)
+
+	waiting := s.admitToRoom("waiting", roomPeer{})
+	assert.True(t, waiting.created)
+	assert.False(t, waiting.evicted)
+
+	evicted := s.admitToRoom("replacement", roomPeer{})
+	assert.True(t, evicted.evicted)
+	assert.Equal(t, "waiting", evicted.evictedRoom)
+	assert.Contains(t, s.rooms.rooms, "reserved")
+
+	assert.True(t, s.admitToRoom("second-reserved", roomPeer{hold: time.Hour}).created)
+	full := s.admitToRoom("third-reserved", roomPeer{hold: time.Hour})
+	assert.True(t, full.reservationsFull)
+	assert.NotContains(t, s.rooms.rooms, "third-reserved")
+
+	renewed := s.admitToRoom("reserved", roomPeer{hold: 2 * time.Hour})
+	assert.True(t, renewed.created)
+	assert.True(t, renewed.renewed)
+	assert.False(t, s.rooms.rooms["reserved"].full)
+
+	joined := s.admitToRoom("reserved", roomPeer{})
+	assert.False(t, joined.created)
+	assert.True(t, s.rooms.rooms["reserved"].full)
+	assert.True(t, s.admitToRoom("third-reserved", roomPeer{hold: time.Hour}).created)
+}
+
+func TestReservedRoomExpiry(t *testing.T) {
+	now := time.Now()
+	ttl := time.Hour
+
+	assert.False(t, roomInfo{opened: now.Add(-time.Minute)}.expired(now, ttl))
+	assert.True(t, roomInfo{opened: now.Add(-2 * time.Hour)}.expired(now, ttl))
+	assert.False(t, roomInfo{
+		opened:   now.Add(-2 * time.Hour),
+		reserved: true,
+		expires:  now.Add(6 * time.Hour),
+	}.expired(now, ttl))
+	assert.True(t, roomInfo{
+		opened:   now.Add(-2 * time.Hour),
+		reserved: true,
+		expires:  now.Add(-time.Second),
+	}.expired(now, ttl))
+}
+
+func TestReserveRoom(t *testing.T) {
+	_, plainAddress, stopPlain := startConfiguredTestServer(t)
+	defer stopPlain()
+	_, _, _, err := ReserveRoom(plainAddress, "pass123", "reserved-room", Reservation{Token: "secret", Hold: time.Hour})
+	assert.ErrorIs(t, err, ErrReservationsUnsupported)
+
+	s, address, stopServer := startConfiguredTestServer(t,
+		WithMaxRoomsOpen(1),
+		WithReservations([]string{"secret"}, time.Hour, 1))
+	defer stopServer()
+
+	_, _, _, err = ReserveRoom(address, "pass123", "reserved-room", Reservation{Token: "wrong", Hold: time.Hour})
+	assert.ErrorIs(t, err, ErrReservationRejected)
+	assert.NotContains(t, err.Error(), "wrong")
+
+	sender, _, _, err := ReserveRoom(address, "pass123", "reserved-room", Reservation{Token: "secret", Hold: time.Hour})
+	if err != nil {
+		t.Fatalf("reserve room: %v", err)
+	}
+	defer sender.Close()
+
+	_, _, _, err = ReserveRoom(address, "pass123", "other-reserved-room", Reservation{Token: "secret", Hold: time.Hour})
+	assert.ErrorIs(t, err, ErrReservationsFull)
+
+	// an ordinary waiting room at the limit must not evict the reservation
+	waiting, _, _, err := ConnectToTCPServer(address, "pass123", "waiting-room")
+	if err != nil {
+		t.Fatalf("connect waiting room: %v", err)
+	}
+	defer waiting.Close()
+	s.rooms.Lock()
+	assert.Contains(t, s.rooms.rooms, "reserved-room")
+	s.rooms.Unlock()
+
+	receiver, _, _, err := ConnectToTCPServer(address, "pass123", "reserved-room")
+	if err != nil {
+		t.Fatalf("join reserved room: %v", err)
+	}
+	defer receiver.Close()
+
+	want := []byte("scheduled handoff")
+	if err := receiver.Send(want); err != nil {
+		t.Fatalf("send through reserved room: %v", err)
+	}
+	for {
+		got, receiveErr := sender.Receive()
+		if receiveErr != nil {
+			t.Fatalf("receive through reserved room: %v", receiveErr)
+		}
+		if bytes.Equal(got, []byte{1}) {
+			continue
+		}
+		assert.Equal(t, want, got)
+		break
+	}
+}
+
+func TestReserveRoomRenewalReplacesWaitingPeer(t *testing.T) {
+	_, address, stopServer := startConfiguredTestServer(t,
+		WithReservations([]string{"secret"}, time.Hour, 1))
+	defer stopServer()
+
+	stale, _, _, err := ReserveRoom(address, "pass123", "reserved-room", Reservation{Token: "secret", Hold: time.Hour})
+	if err != nil {
+		t.Fatalf("reserve room: %v", err)
+	}
+	defer stale.Close()
+
+	renewed, _, _, err := ReserveRoom(address, "pass123", "reserved-room", Reservation{Token: "secret", Hold: time.Hour})
+	if err != nil {
+		t.Fatalf("renew reservation: %v", err)
+	}
+	defer renewed.Close()
+	waitForConnectionClose(t, stale)
+
+	receiver, _, _, err := ConnectToTCPServer(address, "pass123", "reserved-room")
+	if err != nil {
+		t.Fatalf("join renewed room: %v", err)
+	}
+	defer receiver.Close()
+	want := []byte("after renewal")
+	if err := receiver.Send(want); err != nil {
+		t.Fatalf("send through renewed room: %v", err)
+	}
+	for {
+		got, receiveErr := renewed.Receive()
+		if receiveErr != nil {
+			t.Fatalf("receive through renewed room: %v", receiveErr)
+		}
+		if bytes.Equal(got, []byte{1}) {
+			continue
+		}
+		assert.Equal(t, want, got)
+		break
+	}
+}
@@ -3,12 +3,16 @@ package tcp
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"net"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/schollz/logger"
@@ -17,6 +21,8 @@ import (
 	"github.com/schollz/croc/v11/src/comm"
 	"github.com/schollz/croc/v11/src/crypt"
 	"github.com/schollz/croc/v11/src/redact"
+	"github.com/schollz/croc/v11/src/relayauth"
+	"github.com/schollz/croc/v11/src/relaycluster"
 )
 
 type server struct {
@@ -26,6 +32,9 @@ type server struct {
 	banner     string
 	password   string
 	roomPaired func()
+	roomEvents RoomEventSink
+	tlsConfig  *tls.Config
+	quic       bool
 	rooms      roomMap
 	started    chan struct{}
 
@@ -39,6 +48,17 @@ type server struct {
 	roomJoinLimit        int
 	joinLimitWindow      time.Duration
 	admissionLimits      *admissionLimiter
+	quicListening        atomic.Bool
+	reservationTokens    [][sha256.Size]byte
+	maxReservationHold   time.Duration
+	maxReservedRooms     int
+	auth                 *relayauth.Authenticator
+	cluster              relaycluster.Backend
+	clusterHost          string
+	clusterSecret        string
+	group                *Group
+	// handshakes maps connections in their handshake to when they arrived.
+	handshakes sync.Map
 
 	// stopRoomCleanup chan struct{}
 	// replaced by stop ctx.go
@@ -46,10 +66,24 @@ type server struct {
 }
 
 type roomInfo struct {
-	first  *comm.Comm
-	second *comm.Comm
-	opened time.Time
-	full   bool
+	first    *comm.Comm
+	second   *comm.Comm
+	opened   time.Time
+	full     bool
+	stats    *roomStats
+	reserved bool
+	// expires, when set, replaces the relay's room TTL for this room.
+	expires time.Time
+	// leases of the peers' relay tokens, released with the room
+	firstLease  *relayauth.Lease
+	secondLease *relayauth.Lease
+}
+
+// roomPeer is a connection asking to be admitted to a room.
+type roomPeer struct {
+	conn  *comm.Comm
+	hold  time.Duration
+	lease *relayauth.Lease
 }
 
 type roomMap struct {
@@ -60,15 +94,25 @@ type roomMap struct {
 type roomAdmission struct {
 	created           bool
 	full              bool
+	reservationsFull  bool
+	renewed           bool
+	replaced          *comm.Comm
 	otherConnection   *comm.Comm
+	otherLease        *relayauth.Lease
 	evicted           bool
 	evictedRoom       string
 	evictedConnection *comm.Comm
+	evictedEvent      RoomEvent
+	stats             *roomStats
 }
 
 type handshakeResult struct {
 	room                   string
+	reservation            *reservationRequest
+	lease                  *relayauth.Lease
 	strongKeyForEncryption []byte
+	// forwarded is set for peers another cluster node relays to this one.
+	forwarded bool
 }
 
 const pingRoom = "pinglkasjdlfjsaldjf"
@@ -86,6 +130,7 @@ func newDefaultServer() *server {
 	s.joinLimitWindow = DEFAULT_JOIN_LIMIT_WINDOW
 	s.debugLevel = DEFAULT_LOG_LEVEL
 	s.started = make(chan struct{})
+	s.group = NewGroup()
 	// s.stopRoomCleanup = make(chan struct{}) replaced by stop
 	s.stop = newStop(context.Background())
 	return s
@@ -94,7 +139,17 @@ func newDefaultServer() *server {
 // admitToRoom atomically creates a waiting room, joins an existing waiting
 // room, or reports that an existing room is already full. When creating a room
 // at capacity, it removes the oldest waiting room before inserting the new one.
-func (s *server) admitToRoom(room string, c *comm.Comm) roomAdmission {
+// A positive hold reserves the room instead: reserved rooms have their own
+// limit, are never evicted, and a new reservation of a reserved room that is
+// still waiting replaces its peer. An admitted peer's lease is released with
+// the room.
+func (s *server) admitToRoom(room string, peer roomPeer) roomAdmission {
+	c, hold := peer.conn, peer.hold
+	var sources []string
+	if c != nil {
+		sources = []string{canonicalSource(c.Connection().RemoteAddr())}
+	}
+	users := leaseUsers(peer.lease)
 	s.rooms.Lock()
 	defer s.rooms.Unlock()
 
@@ -103,10 +158,51 @@ func (s *server) admitToRoom(room string, c *comm.Comm) roomAdmission {
 		if roomData.full {
 			return roomAdmission{full: true}
 		}
+		if hold > 0 && roomData.reserved {
+			replaced := roomData.first
+			roomData.firstLease.Release()
+			roomData.first = c
+			roomData.firstLease = peer.lease
+			roomData.expires = time.Now().Add(hold)
+			s.rooms.rooms[room] = roomData
+			return roomAdmission{created: true, renewed: true, replaced: replaced, stats: roomData.stats}
+		}
 		roomData.second = c
+		roomData.secondLease = peer.lease
 		roomData.full = true
+		if roomData.reserved {
+			roomData.expires = time.Now().Add(s.roomTTL)
+		}
+		if roomData.stats != nil {
+			roomData.stats.sources = append(roomData.stats.sources, sources...)
+			roomData.stats.users = append(roomData.stats.users, users...)
+			roomData.stats.paired = time.Now().UTC()
+		}
 		s.rooms.rooms[room] = roomData
-		return roomAdmission{otherConnection: roomData.first}
+		return roomAdmission{otherConnection: roomData.first, otherLease: roomData.firstLease, stats: roomData.stats}
+	}
+
+	if hold > 0 {
+		reservedRooms := 0
+		for _, candidateData := range s.rooms.rooms {
+			if candidateData.reserved && !candidateData.full {
+				reservedRooms++
+			}
+		}
+		if reservedRooms >= s.maxReservedRooms {
+			return roomAdmission{reservationsFull: true}
+		}
+		opened := time.Now()
+		result := roomAdmission{created: true, stats: &roomStats{sources: sources, users: users}}
+		s.rooms.rooms[room] = roomInfo{
+			first:      c,
+			firstLease: peer.lease,
+			opened:     opened,
+			stats:      result.stats,
+			reserved:   true,
+			expires:    opened.Add(hold),
+		}
+		return result
 	}
 
 	waitingRooms := 0
@@ -114,7 +210,7 @@ func (s *server) admitToRoom(room string, c *comm.Comm) roomAdmission {
 	oldestRoom := ""
 	var oldestRoomData roomInfo
 	for candidate, candidateData := range s.rooms.rooms {
-		if candidateData.full {
+		if candidateData.full || candidateData.reserved {
 			continue
 		}
 		waitingRooms++
@@ -125,16 +221,20 @@ func (s *server) admitToRoom(room string, c *comm.Comm) roomAdmission {
 		}
 	}
 
-	result := roomAdmission{created: true}
+	result := roomAdmission{created: true, stats: &roomStats{sources: sources, users: users}}
 	if waitingRooms >= s.maxRoomsOpen && oldestRoomFound {
 		delete(s.rooms.rooms, oldestRoom)
+		oldestRoomData.releaseLeases()
 		result.evicted = true
 		result.evictedRoom = oldestRoom
 		result.evictedConnection = oldestRoomData.first
+		result.evictedEvent = closedRoomEvent(oldestRoom, oldestRoomData, ReasonEvicted)
 	}
 	s.rooms.rooms[room] = roomInfo{
-		first:  c,
-		opened: time.Now(),
+		first:      c,
+		firstLease: peer.lease,
+		opened:     time.Now(),
+		stats:      result.stats,
 	}
 	return result
 }
@@ -162,6 +262,9 @@ func Run(debugLevel, host, port, password string, banner ...string) (err error)
 func (s *server) start() (err error) {
 	log.SetLevel(s.debugLevel)
 	log.Debug("starting relay with configured authentication")
+	if s.cluster != nil && s.tlsConfig != nil {
+		return fmt.Errorf("cluster nodes cannot forward peers to TLS relay ports")
+	}
 
 	s.rooms.Lock()
 	s.rooms.rooms = make(map[string]roomInfo)
@@ -174,6 +277,13 @@ func (s *server) start() (err error) {
 		defer s.stop.wg.Done()
 		s.deleteOldRooms()
 	}()
+	if s.cluster != nil {
+		s.stop.wg.Add(1)
+		go func() {
+			defer s.stop.wg.Done()
+			s.renewRoomClaims()
+		}()
+	}
 	// defer s.stopRoomDeletion()
 	defer s.stop.Cancel()
 	if s.stop.gui {
@@ -216,6 +326,9 @@ func (s *server) run() (err error) {
 		return fmt.Errorf("error listening on %s: %w", addr, err)
 	}
 	defer s.stop.server.Close()
+	if s.tlsConfig != nil {
+		s.stop.server = tls.NewListener(s.stop.server, s.tlsConfig)
+	}
 	close(s.started)
 
 	go func() {
@@ -231,6 +344,18 @@ func (s *server) run() (err error) {
 		}
 	}()
 
+	s.group.add(s)
+	defer s.group.remove(s)
+	if s.quic {
+		s.stop.wg.Add(1)
+		go func() {
+			defer s.stop.wg.Done()
+			if err := s.runQUIC("udp"+strings.TrimPrefix(network, "tcp"), addr); err != nil {
+				log.Errorf("QUIC relay on %s stopped: %v", addr, err)
+			}
+		}()
+	}
+
 	// spawn a new goroutine whenever a client connects
 	for {
 		connection, err := s.stop.server.Accept()
@@ -238,103 +363,125 @@ func (s *server) run() (err error) {
 			return fmt.Errorf("problem accepting connection: %w", err)
 		}
 		log.Debugf("client %s connected", connection.RemoteAddr().String())
-		select {
-		case s.handshakeSlots <- struct{}{}:
-		case <-s.stop.ctx.Done():
-			connection.Close()
-			return s.stop.ctx.Err()
-		default:
-			log.Debugf("rejecting client %s: too many pending handshakes", connection.RemoteAddr().String())
-			connection.Close()
-			continue
+		if err := s.serve(connection); err != nil {
+			return err
 		}
-		handshakeDeadline := time.Now().Add(s.handshakeTimeout)
-		s.stop.wg.Add(1)
-		go func(connection net.Conn, handshakeDeadline time.Time) {
-			defer s.stop.wg.Done()
-			handshakePending := true
-			releaseHandshake := func() {
-				if handshakePending {
-					<-s.handshakeSlots
-					handshakePending = false
-				}
+	}
+}
+
+// serve admits a client connection and relays it once it has joined a room.
+// It only returns an error when the server is stopping.
+func (s *server) serve(connection net.Conn) error {
+	if s.admissionLimits != nil && s.admissionLimits.isBanned(canonicalSource(connection.RemoteAddr())) {
+		log.Debugf("rejecting banned client %s", connection.RemoteAddr().String())
+		connection.Close()
+		return nil
+	}
+	select {
+	case s.handshakeSlots <- struct{}{}:
+	case <-s.stop.ctx.Done():
+		connection.Close()
+		return s.stop.ctx.Err()
+	default:
+		log.Debugf("rejecting client %s: too many pending handshakes", connection.RemoteAddr().String())
+		connection.Close()
+		return nil
+	}
+	handshakeDeadline := time.Now().Add(s.handshakeTimeout)
+	s.handshakes.Store(connection, time.Now())
+	s.stop.wg.Add(1)
+	go func(connection net.Conn, handshakeDeadline time.Time) {
+		defer s.stop.wg.Done()
+		handshakePending := true
+		releaseHandshake := func() {
+			if handshakePending {
+				<-s.handshakeSlots
+				s.handshakes.Delete(connection)
+				handshakePending = false
 			}
-			defer releaseHandshake()
-			stopCloseOnCancel := context.AfterFunc(s.stop.ctx, func() {
-				connection.Close()
-			})
-			defer stopCloseOnCancel()
-
-			c := comm.New(connection)
-			handshake, errCommunication := s.clientHandshake(c, handshakeDeadline)
-			releaseHandshake()
-			room := handshake.room
-			if errCommunication != nil {
-				if netErr, ok := errCommunication.(net.Error); ok && netErr.Timeout() {
-					log.Debugf("relay-%s: handshake timed out", connection.RemoteAddr().String())
-				} else {
-					log.Debugf("relay-%s: %s", connection.RemoteAddr().String(), errCommunication.Error())
-				}
-				connection.Close()
-				return
+		}
+		defer releaseHandshake()
+		stopCloseOnCancel := context.AfterFunc(s.stop.ctx, func() {
+			connection.Close()
+		})
+		defer stopCloseOnCancel()
+
+		c := comm.New(connection)
+		handshake, errCommunication := s.clientHandshake(c, handshakeDeadline)
+		releaseHandshake()
+		room := handshake.room
+		if errCommunication != nil {
+			if netErr, ok := errCommunication.(net.Error); ok && netErr.Timeout() {
+				log.Debugf("relay-%s: handshake timed out", connection.RemoteAddr().String())
+			} else {
+				log.Debugf("relay-%s: %s", connection.RemoteAddr().String(), errCommunication.Error())
 			}
-			if room == pingRoom {
-				log.Debugf("got ping")
-				connection.Close()
+			connection.Close()
+			return
+		}
+		if room == pingRoom {
+			log.Debugf("got ping")
+			connection.Close()
+			return
+		}
+		if err := connection.SetDeadline(time.Time{}); err != nil {
+			log.Debugf("relay-%s: failed to clear handshake deadline: %v", connection.RemoteAddr().String(), err)
+			handshake.lease.Release()
+			connection.Close()
+			return
+		}
+		room, errCommunication = s.clientCommunication(c, handshake)
+		if errCommunication != nil {
+			log.Debugf("relay-%s: %s", connection.RemoteAddr().String(), errCommunication.Error())
+			connection.Close()
+			return
+		}
+		ticker := time.NewTicker(1 * time.Second)
+		defer ticker.Stop()
+		for {
+			// check connection
+			log.Tracef("checking waiting relay connection for %+v", c)
+			deleteIt := false
+			s.rooms.Lock()
+			roomData, ok := s.rooms.rooms[room]
+			if !ok {
+				log.Debug("room is gone")
+				s.rooms.Unlock()
 				return
 			}
-			if err := connection.SetDeadline(time.Time{}); err != nil {
-				log.Debugf("relay-%s: failed to clear handshake deadline: %v", connection.RemoteAddr().String(), err)
-				connection.Close()
-				return
+			if roomData.first != nil && roomData.second != nil {
+				log.Debug("rooms ready")
+				s.rooms.Unlock()
+				break
 			}
-			room, errCommunication = s.clientCommunication(c, handshake)
-			if errCommunication != nil {
-				log.Debugf("relay-%s: %s", connection.RemoteAddr().String(), errCommunication.Error())
-				connection.Close()
+			if roomData.first != c {
+				log.Debug("reservation renewed by another connection")
+				s.rooms.Unlock()
 				return
 			}
-			ticker := time.NewTicker(1 * time.Second)
-			defer ticker.Stop()
-			for {
-				// check connection
-				log.Tracef("checking waiting relay connection for %+v", c)
-				deleteIt := false
-				s.rooms.Lock()
-				roomData, ok := s.rooms.rooms[room]
-				if !ok {
-					log.Debug("room is gone")
-					s.rooms.Unlock()
-					return
-				}
-				if roomData.first != nil && roomData.second != nil {
-					log.Debug("rooms ready")
-					s.rooms.Unlock()
-					break
-				}
-				if roomData.first != nil {
-					errSend := roomData.first.Send([]byte{1})
-					if errSend != nil {
-						log.Debug(errSend)
-						deleteIt = true
-					}
-				}
-				s.rooms.Unlock()
-				if deleteIt {
-					s.deleteRoom(room)
-					break
-				}
-				select {
-				case <-s.stop.ctx.Done():
-					log.Tracef("check: %v", s.stop.ctx.Err())
-					s.deleteRoom(room)
-					return
-				case <-ticker.C:
-					// time.Sleep(1 * time.Second)
+			if roomData.first != nil {
+				errSend := roomData.first.Send([]byte{1})
+				if errSend != nil {
+					log.Debug(errSend)
+					deleteIt = true
 				}
 			}
-		}(connection, handshakeDeadline)
-	}
+			s.rooms.Unlock()
+			if deleteIt {
+				s.deleteRoom(room, ReasonPeerGone)
+				break
+			}
+			select {
+			case <-s.stop.ctx.Done():
+				log.Tracef("check: %v", s.stop.ctx.Err())
+				s.deleteRoom(room, ReasonShutdown)
+				return
+			case <-ticker.C:
+				// time.Sleep(1 * time.Second)
+			}
+		}
+	}(connection, handshakeDeadline)
+	return nil
 }
 
 // deleteOldRooms checks for rooms at a regular interval and removes those that
@@ -347,11 +494,13 @@ func (s *server) deleteOldRooms() {
 	}()
 	for next := true; next; {
 		roomsToDelete := []string{}
+		reason := ReasonTTL
 		select {
 		case <-ticker.C:
+			now := time.Now()
 			s.rooms.Lock()
 			for room, roomData := range s.rooms.rooms {
-				if time.Since(roomData.opened) > s.roomTTL {
+				if roomData.expired(now, s.roomTTL) {
 					roomsToDelete = append(roomsToDelete, room)
 				}
 			}
@@ -363,6 +512,7 @@ func (s *server) deleteOldRooms() {
 				time.Sleep(time.Millisecond)
 			}
 			log.Debug("stop room cleanup fired")
+			reason = ReasonShutdown
 			s.rooms.Lock()
 			for room := range s.rooms.rooms {
 				roomsToDelete = append(roomsToDelete, room)
@@ -371,7 +521,7 @@ func (s *server) deleteOldRooms() {
 			next = false
 		}
 		for _, room := range roomsToDelete {
-			s.deleteRoom(room)
+			s.deleteRoom(room, reason)
 			log.Debug("waiting room cleaned up")
 		}
 	}
@@ -444,7 +594,32 @@ func (s *server) clientHandshake(c *comm.Comm, deadline time.Time) (result hands
 	if err != nil {
 		return
 	}
-	if strings.TrimSpace(string(passwordBytes)) != strings.TrimSpace(s.password) {
+	if s.isClusterSecret(string(passwordBytes)) {
+		result.forwarded = true
+	} else if s.auth != nil {
+		var lease *relayauth.Lease
+		if lease, err = s.auth.Authenticate(string(passwordBytes)); err != nil {
+			reply := "bad token"
+			if errors.Is(err, relayauth.ErrQuotaExceeded) {
+				reply = "quota exceeded"
+			}
+			enc, encryptErr := crypt.Encrypt([]byte(reply), strongKeyForEncryption)
+			if encryptErr != nil {
+				return handshakeResult{}, encryptErr
+			}
+			if sendErr := send(enc); sendErr != nil {
+				return handshakeResult{}, fmt.Errorf("send error: %w", sendErr)
+			}
+			return handshakeResult{}, err
+		}
+		log.Debugf("authenticated relay user %q", lease.Name())
+		defer func() {
+			if err != nil {
+				lease.Release()
+			}
+		}()
+		result.lease = lease
+	} else if strings.TrimSpace(string(passwordBytes)) != strings.TrimSpace(s.password) {
 		passwordErr := fmt.Errorf("bad password")
 		enc, encryptErr := crypt.Encrypt([]byte(passwordErr.Error()), strongKeyForEncryption)
 		if encryptErr != nil {
@@ -462,7 +637,19 @@ func (s *server) clientHandshake(c *comm.Comm, deadline time.Time) (result hands
 		banner = "ok"
 	}
 	log.Debugf("sending '%s'", banner)
-	bSend, err := crypt.Encrypt([]byte(banner+"|||"+c.Connection().RemoteAddr().String()), strongKeyForEncryption)
+	response := banner + "|||" + c.Connection().RemoteAddr().String()
+	var features []string
+	if s.quicListening.Load() {
+		features = append(features, featureQUIC)
+	}
+	if len(s.reservationTokens) > 0 {
+		features = append(features, featureReservations)
+	}
+	// clients that predate the features field only read the first two
+	if len(features) > 0 {
+		response += "|||" + strings.Join(features, ",")
+	}
+	bSend, err := crypt.Encrypt([]byte(response), strongKeyForEncryption)
 	if err != nil {
 		return
 	}
@@ -481,7 +668,10 @@ func (s *server) clientHandshake(c *comm.Comm, deadline time.Time) (result hands
 	if err != nil {
 		return
 	}
-	result.room = string(roomBytes)
+	result.room, result.reservation, err = parseRoomFrame(roomBytes)
+	if err != nil {
+		return handshakeResult{}, err
+	}
 	result.strongKeyForEncryption = strongKeyForEncryption
 	return
 }
@@ -490,26 +680,68 @@ func (s *server) clientCommunication(c *comm.Comm, handshake handshakeResult) (r
 	room = handshake.room
 	strongKeyForEncryption := handshake.strongKeyForEncryption
 	var bSend []byte
+	// an admitted peer's lease is released with its room
+	leaseHeld := false
+	defer func() {
+		if !leaseHeld {
+			handshake.lease.Release()
+		}
+	}()
 	if s.admissionLimits == nil {
 		s.admissionLimits = newAdmissionLimiter(s.sourceJoinLimit, s.roomJoinLimit, s.joinLimitWindow)
 	}
-	if !s.admissionLimits.allow(canonicalSource(c.Connection().RemoteAddr()), room) {
-		bSend, err = crypt.Encrypt([]byte("rate limited"), strongKeyForEncryption)
-		if err == nil {
-			err = c.Send(bSend)
+	source := canonicalSource(c.Connection().RemoteAddr())
+	// forwarded peers were admitted by the node that forwarded them
+	if !handshake.forwarded && !s.admissionLimits.allow(source, room) {
+		return room, s.rejectRoom(c, room, source, strongKeyForEncryption, "rate limited", ReasonRateLimited, ErrAdmissionLimited)
+	}
+
+	var hold time.Duration
+	if handshake.reservation != nil {
+		if len(s.reservationTokens) == 0 {
+			return room, s.rejectRoom(c, room, source, strongKeyForEncryption, "reservations unsupported", ReasonReservationRejected, ErrReservationsUnsupported)
 		}
-		if err != nil {
-			return room, err
+		if hold = s.reservationHold(handshake.reservation); hold == 0 {
+			return room, s.rejectRoom(c, room, source, strongKeyForEncryption, "reservation rejected", ReasonReservationRejected, ErrReservationRejected)
+		}
+	}
+
+	if s.cluster != nil && !handshake.forwarded {
+		// a second attempt follows another node taking over the room
+		for attempt := 0; attempt < 2; attempt++ {
+			holder := s.roomHolder(room)
+			if holder == "" {
+				break
+			}
+			if forwarded, forwardErr := s.forwardRoom(c, holder, handshake); forwarded {
+				leaseHeld = errors.Is(forwardErr, errRoomForwarded)
+				return room, forwardErr
+			}
 		}
-		return room, ErrAdmissionLimited
 	}
 
-	admission := s.admitToRoom(room, c)
+	admission := s.admitToRoom(room, roomPeer{conn: c, hold: hold, lease: handshake.lease})
+	leaseHeld = !admission.full && !admission.reservationsFull
+	if !admission.created && s.cluster != nil && !handshake.forwarded {
+		s.releaseUnopenedRoom(room)
+	}
+	if admission.reservationsFull {
+		return room, s.rejectRoom(c, room, source, strongKeyForEncryption, "reservations full", ReasonReservationsFull, ErrReservationsFull)
+	}
+	if admission.replaced != nil {
+		log.Debug("replacing peer waiting in reserved room")
+		admission.replaced.Close()
+	}
 	if admission.evicted {
 		log.Debug("evicting oldest waiting room at capacity")
 		if admission.evictedConnection != nil {
 			admission.evictedConnection.Close()
 		}
+		s.releaseRoom(admission.evictedRoom)
+		s.emitRoomEvent(admission.evictedEvent)
+	}
+	if admission.created && handshake.forwarded && s.cluster != nil {
+		s.claimRoom(room)
 	}
 
 	// create the room if it is new
@@ -523,13 +755,30 @@ func (s *server) clientCommunication(c *comm.Comm, handshake handshakeResult) (r
 		err = c.Send(bSend)
 		if err != nil {
 			log.Error(err)
-			s.deleteRoom(room)
+			s.deleteRoom(room, ReasonSendFailed)
 			return
 		}
 		log.Debug("room has first peer")
+		if admission.renewed {
+			return
+		}
+		s.emitRoomEvent(RoomEvent{
+			Event:    RoomOpened,
+			Room:     hashRoom(room),
+			Sources:  []string{source},
+			Users:    leaseUsers(handshake.lease),
+			OpenedAt: time.Now().UTC(),
+			Reserved: hold > 0,
+		})
 		return
 	}
 	if admission.full {
+		s.emitRoomEvent(RoomEvent{
+			Event:   RoomRejected,
+			Room:    hashRoom(room),
+			Sources: []string{source},
+			Reason:  ReasonRoomFull,
+		})
 		bSend, err = crypt.Encrypt([]byte("room full"), strongKeyForEncryption)
 		if err != nil {
 			return
@@ -549,9 +798,14 @@ func (s *server) clientCommunication(c *comm.Comm, handshake handshakeResult) (r
 	wg.Add(1)
 
 	// start piping
+	var pipeErr error
 	go func(com1, com2 *comm.Comm, wg *sync.WaitGroup) {
 		log.Debug("starting pipes")
-		pipe(com1.Connection(), com2.Connection())
+		var transferred *atomic.Int64
+		if admission.stats != nil {
+			transferred = &admission.stats.transferred
+		}
+		pipeErr = pipe(com1.Connection(), com2.Connection(), transferred, admission.otherLease, handshake.lease)
 		wg.Done()
 		log.Debug("done piping")
 	}(otherConnection, c, &wg)
@@ -563,24 +817,61 @@ func (s *server) clientCommunication(c *comm.Comm, handshake handshakeResult) (r
 	}
 	err = c.Send(bSend)
 	if err != nil {
-		s.deleteRoom(room)
+		s.deleteRoom(room, ReasonSendFailed)
 		return
 	}
 	if s.roomPaired != nil {
 		s.roomPaired()
 	}
+	if admission.stats != nil {
+		s.rooms.Lock()
+		event := RoomEvent{
+			Event:    RoomPaired,
+			Room:     hashRoom(room),
+			Sources:  append([]string(nil), admission.stats.sources...),
+			Users:    append([]string(nil), admission.stats.users...),
+			PairedAt: admission.stats.paired,
+		}
+		s.rooms.Unlock()
+		s.emitRoomEvent(event)
+	}
 	wg.Wait()
 
 	// delete room
-	s.deleteRoom(room)
+	if errors.Is(pipeErr, errByteQuotaUsed) {
+		log.Debugf("room %s: %v", hashRoom(room), pipeErr)
+		s.deleteRoom(room, ReasonQuotaExceeded)
+		return
+	}
+	s.deleteRoom(room, ReasonComplete)
 	return
 }
 
-func (s *server) deleteRoom(room string) {
+// rejectRoom records that source was refused room for reason, tells the peer
+// with reply and returns err.
+func (s *server) rejectRoom(c *comm.Comm, room, source string, strongKeyForEncryption []byte, reply, reason string, err error) error {
+	s.emitRoomEvent(RoomEvent{
+		Event:   RoomRejected,
+		Room:    hashRoom(room),
+		Sources: []string{source},
+		Reason:  reason,
+	})
+	bSend, encryptErr := crypt.Encrypt([]byte(reply), strongKeyForEncryption)
+	if encryptErr == nil {
+		encryptErr = c.Send(bSend)
+	}
+	if encryptErr != nil {
+		return encryptErr
+	}
+	return err
+}
+
+// deleteRoom closes a room's connections, removes it, and records why.
+func (s *server) deleteRoom(room, reason string) {
 	s.rooms.Lock()
-	defer s.rooms.Unlock()
 	roomData, ok := s.rooms.rooms[room]
 	if !ok {
+		s.rooms.Unlock()
 		return
 	}
 	log.Debug("deleting room")
@@ -591,21 +882,48 @@ func (s *server) deleteRoom(room string) {
 		roomData.second.Close()
 	}
 	delete(s.rooms.rooms, room)
+	roomData.releaseLeases()
+	event := closedRoomEvent(room, roomData, reason)
+	s.rooms.Unlock()
+	s.releaseRoom(room)
+	s.emitRoomEvent(event)
 }
 
 // pipe creates a full-duplex pipe between the two sockets and
-// transfers data from one to the other.
-func pipe(conn1 net.Conn, conn2 net.Conn) {
+// transfers data from one to the other. When one direction ends it closes
+// both sockets and waits for the other, so transferred, if not nil, holds the
+// total relayed in both directions on return. The bytes each socket sends
+// are charged to its lease, lease1 or lease2, and the pipe stops with
+// errByteQuotaUsed when a lease's user runs out of bytes.
+func pipe(conn1 net.Conn, conn2 net.Conn, transferred *atomic.Int64, lease1, lease2 *relayauth.Lease) error {
 	copyDone := make(chan error, 2)
-	copyDirection := func(dst, src net.Conn) {
-		_, err := io.Copy(dst, src)
+	copyDirection := func(dst, src net.Conn, lease *relayauth.Lease) {
+		if lease != nil {
+			_, err := io.Copy(dst, meteredReader{r: src, transferred: transferred, lease: lease})
+			copyDone <- err
+			return
+		}
+		n, err := io.Copy(dst, src)
+		if transferred != nil {
+			transferred.Add(n)
+		}
 		copyDone <- err
 	}
-	go copyDirection(conn2, conn1)
-	go copyDirection(conn1, conn2)
-	if err := <-copyDone; err != nil && !errors.Is(err, net.ErrClosed) {
+	go copyDirection(conn2, conn1, lease1)
+	go copyDirection(conn1, conn2, lease2)
+	err := <-copyDone
+	if err != nil && !errors.Is(err, net.ErrClosed) {
 		log.Debugf("relay pipe closed: %v", err)
 	}
+	conn1.Close()
+	conn2.Close()
+	if otherErr := <-copyDone; errors.Is(otherErr, errByteQuotaUsed) {
+		err = otherErr
+	}
+	if errors.Is(err, errByteQuotaUsed) {
+		return err
+	}
+	return nil
 }
 
 func PingServer(address string) (err error) {
@@ -689,90 +1007,145 @@ func MeasureServerLatencyContext(ctx context.Context, address string, timeout ti
 // ConnectToTCPServer will initiate a new connection
 // to the specified address, room with optional time limit
 func ConnectToTCPServer(address, password, room string, timelimit ...time.Duration) (c *comm.Comm, banner string, ipaddr string, err error) {
+	return new(Dialer).ConnectToTCPServer(address, password, room, timelimit...)
+}
+
+// ConnectToTCPServer connects to the relay at address with the transport of
+// its host and joins room, with an optional time limit.
+func (d *Dialer) ConnectToTCPServer(address, password, room string, timelimit ...time.Duration) (c *comm.Comm, banner string, ipaddr string, err error) {
 	defer func() { err = redact.Error(err, password, room) }()
-	if len(timelimit) > 0 {
-		c, err = comm.NewConnection(address, timelimit[0])
-	} else {
-		c, err = comm.NewConnection(address)
-	}
+	return d.connectToRoom(address, password, room, nil, timelimit...)
+}
+
+func (d *Dialer) connectToRoom(address, password, room string, reservation *Reservation, timelimit ...time.Duration) (c *comm.Comm, banner string, ipaddr string, err error) {
+	c, err = d.Transport(address).NewConnection(address, timelimit...)
 	if err != nil {
 		log.Debug(err)
 		return
 	}
+	var features []string
+	banner, ipaddr, features, err = joinRoom(c, password, room, reservation)
+	if err == nil {
+		d.rememberFeatures(address, features)
+	}
+	return
+}
 
-	// get PAKE connection with server to establish strong key to transfer info
-	A, err := pake.InitCurve(weakKey, 0, "siec")
+// joinRoom authenticates to the relay over c and joins room, reserving it if
+// reservation is not nil.
+func joinRoom(c *comm.Comm, password, room string, reservation *Reservation) (banner string, ipaddr string, features []string, err error) {
+	reply, banner, ipaddr, features, err := requestRoom(c, password, room, reservation)
 	if err != nil {
+		return
+	}
+	if !bytes.Equal(reply, []byte("ok")) {
+		err = roomRefusalError(reply)
 		log.Debug(err)
 		return
 	}
-	err = c.Send(A.Bytes())
+	log.Debug("all set")
+	return
+}
+
+// requestRoom authenticates to the relay over c, asks to join room and
+// returns the relay's reply.
+func requestRoom(c *comm.Comm, password, room string, reservation *Reservation) (reply []byte, banner string, ipaddr string, features []string, err error) {
+	strongKeyForEncryption, banner, ipaddr, features, err := relayHandshake(c, password)
+	if err != nil {
+		return
+	}
+	if reservation != nil && !slices.Contains(features, featureReservations) {
+		err = ErrReservationsUnsupported
+		return
+	}
+	frame, err := roomFrame(room, reservation)
+	if err != nil {
+		return
+	}
+	log.Debug("sending encrypted room identifier")
+	bSend, err := crypt.Encrypt(frame, strongKeyForEncryption)
 	if err != nil {
 		log.Debug(err)
 		return
 	}
-	Bbytes, err := c.Receive()
+	err = c.Send(bSend)
 	if err != nil {
 		log.Debug(err)
 		return
 	}
-	err = A.Update(Bbytes)
+	log.Debug("waiting for room confirmation")
+	enc, err := c.Receive()
 	if err != nil {
 		log.Debug(err)
 		return
 	}
-	strongKey, err := A.SessionKey()
+	reply, err = crypt.Decrypt(enc, strongKeyForEncryption)
 	if err != nil {
 		log.Debug(err)
+	}
+	return
+}
+
+// ObserveExternalAddress authenticates to the relay at address over conn and
+// returns the address the relay sees the connection coming from, without
+// joining a room. conn is closed.
+func (d *Dialer) ObserveExternalAddress(conn net.Conn, address, password string) (ipaddr string, err error) {
+	defer func() { err = redact.Error(err, password) }()
+	c, err := d.Transport(address).Wrap(conn, address, 30*time.Second)
+	if err != nil {
+		conn.Close()
 		return
 	}
-	strongKeyForEncryption, salt, err := crypt.New(strongKey, nil)
+	defer c.Close()
+	_, _, ipaddr, _, err = relayHandshake(c, password)
+	return
+}
+
+// relayHandshake authenticates to the relay and returns the key for the rest
+// of the handshake, the relay's banner, the address it sees the client at and
+// the optional features it offers.
+func relayHandshake(c *comm.Comm, password string) (strongKeyForEncryption []byte, banner string, ipaddr string, features []string, err error) {
+	// get PAKE connection with server to establish strong key to transfer info
+	A, err := pake.InitCurve(weakKey, 0, "siec")
 	if err != nil {
 		log.Debug(err)
 		return
 	}
-	// send salt
-	err = c.Send(salt)
+	err = c.Send(A.Bytes())
 	if err != nil {
 		log.Debug(err)
 		return
 	}
-
-	log.Debug("sending encrypted relay authentication")
-	bSend, err := crypt.Encrypt([]byte(password), strongKeyForEncryption)
+	Bbytes, err := c.Receive()
 	if err != nil {
 		log.Debug(err)
 		return
 	}
-	err = c.Send(bSend)
+	err = A.Update(Bbytes)
 	if err != nil {
 		log.Debug(err)
 		return
 	}
-	log.Debug("waiting for first ok")
-	enc, err := c.Receive()
+	strongKey, err := A.SessionKey()
 	if err != nil {
 		log.Debug(err)
 		return
 	}
-	data, err := crypt.Decrypt(enc, strongKeyForEncryption)
+	var salt []byte
+	strongKeyForEncryption, salt, err = crypt.New(strongKey, nil)
 	if err != nil {
 		log.Debug(err)
 		return
 	}
-	if !strings.Contains(string(data), "|||") {
-		if bytes.Equal(data, []byte("bad password")) {
-			err = fmt.Errorf("bad password")
-		} else {
-			err = fmt.Errorf("invalid relay response")
-		}
+	// send salt
+	err = c.Send(salt)
+	if err != nil {
 		log.Debug(err)
 		return
 	}
-	banner = strings.Split(string(data), "|||")[0]
-	ipaddr = strings.Split(string(data), "|||")[1]
-	log.Debug("sending encrypted room identifier")
-	bSend, err = crypt.Encrypt([]byte(room), strongKeyForEncryption)
+
+	log.Debug("sending encrypted relay authentication")
+	bSend, err := crypt.Encrypt([]byte(password), strongKeyForEncryption)
 	if err != nil {
 		log.Debug(err)
 		return
@@ -782,26 +1155,26 @@ func ConnectToTCPServer(address, password, room string, timelimit ...time.Durati
 		log.Debug(err)
 		return
 	}
-	log.Debug("waiting for room confirmation")
-	enc, err = c.Receive()
+	log.Debug("waiting for first ok")
+	enc, err := c.Receive()
 	if err != nil {
 		log.Debug(err)
 		return
 	}
-	data, err = crypt.Decrypt(enc, strongKeyForEncryption)
+	data, err := crypt.Decrypt(enc, strongKeyForEncryption)
 	if err != nil {
 		log.Debug(err)
 		return
 	}
-	if !bytes.Equal(data, []byte("ok")) {
-		if bytes.Equal(data, []byte("rate limited")) {
-			err = ErrAdmissionLimited
-		} else {
-			err = fmt.Errorf("relay admission rejected")
-		}
+	if !strings.Contains(string(data), "|||") {
+		err = relayAuthError(data)
 		log.Debug(err)
 		return
 	}
-	log.Debug("all set")
+	fields := strings.Split(string(data), "|||")
+	banner, ipaddr = fields[0], fields[1]
+	if len(fields) > 2 {
+		features = strings.Split(fields[2], ",")
+	}
 	return
 }
@@ -92,7 +92,7 @@ func TestAdmitToRoomEvictsOldestWaitingRoom(t *testing.T) {
 		},
 	}
 
-	result := s.admitToRoom("incoming", nil)
+	result := s.admitToRoom("incoming", roomPeer{})
 
 	assert.True(t, result.created)
 	assert.True(t, result.evicted)
@@ -112,19 +112,19 @@ func TestAdmitToRoomJoinsAtCapacityAndExcludesFullRooms(t *testing.T) {
 		},
 	}
 
-	joined := s.admitToRoom("joining", nil)
+	joined := s.admitToRoom("joining", roomPeer{})
 	assert.False(t, joined.created)
 	assert.False(t, joined.full)
 	assert.False(t, joined.evicted)
 	assert.True(t, s.rooms.rooms["joining"].full)
 
-	created := s.admitToRoom("waiting", nil)
+	created := s.admitToRoom("waiting", roomPeer{})
 	assert.True(t, created.created)
 	assert.False(t, created.evicted)
 	assert.Contains(t, s.rooms.rooms, "joining")
 	assert.Contains(t, s.rooms.rooms, "waiting")
 
-	evicted := s.admitToRoom("replacement", nil)
+	evicted := s.admitToRoom("replacement", roomPeer{})
 	assert.True(t, evicted.evicted)
 	assert.Equal(t, "waiting", evicted.evictedRoom)
 	assert.Contains(t, s.rooms.rooms, "joining")
@@ -145,7 +145,7 @@ func TestConcurrentRoomAdmissionRespectsWaitingRoomLimit(t *testing.T) {
 		go func(room int) {
 			defer wg.Done()
 			<-start
-			s.admitToRoom(fmt.Sprintf("room-%d", room), nil)
+			s.admitToRoom(fmt.Sprintf("room-%d", room), roomPeer{})
 		}(i)
 	}
 	close(start)
@@ -355,7 +355,8 @@ func startConfiguredTestServer(t *testing.T, opts ...serverOptsFunc) (*server, s
 		t.Fatal("test server did not start listening")
 	}
 	deadline := time.Now().Add(2 * time.Second)
-	for PingServer(address) != nil {
+	// Plain pings cannot reach a TLS listener; its started signal suffices.
+	for s.tlsConfig == nil && PingServer(address) != nil {
 		if time.Now().After(deadline) {
 			cancel()
 			t.Fatal("test server did not become reachable")
@@ -899,3 +900,24 @@ func TestDualStackRelayBridgesIPv4AndIPv6(t *testing.T) {
 	}
 	assert.Equal(t, want, got)
 }
+
+func TestObserveExternalAddress(t *testing.T) {
+	address, stopServer := startTestServer(t, 1)
+	defer stopServer()
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("dial relay: %v", err)
+	}
+	local := conn.LocalAddr().String()
+	observed, err := new(Dialer).ObserveExternalAddress(conn, address, "pass123")
+	assert.NoError(t, err)
+	assert.Equal(t, local, observed)
+
+	conn, err = net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("dial relay: %v", err)
+	}
+	_, err = new(Dialer).ObserveExternalAddress(conn, address, "wrongpass")
+	assert.EqualError(t, err, "bad password")
+}
@@ -0,0 +1,108 @@
+package tcp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// selfSignedValidity is long because clients pin the certificate itself
+// rather than trusting its expiry.
+const selfSignedValidity = 10 * 365 * 24 * time.Hour
+
+// WithTLS serves the relay over TLS. Clients reach it with a tls:// relay
+// address.
+func WithTLS(config *tls.Config) serverOptsFunc {
+	return func(s *server) error {
+		if config != nil && len(config.Certificates) == 0 && config.GetCertificate == nil {
+			return fmt.Errorf("relay TLS requires a certificate")
+		}
+		s.tlsConfig = config
+		return nil
+	}
+}
+
+// LoadCertificate loads a PEM certificate and key. When createMissing is set
+// and neither file exists, it first writes a self-signed ECDSA certificate
+// valid for hosts.
+func LoadCertificate(certFile, keyFile string, createMissing bool, hosts ...string) (tls.Certificate, error) {
+	_, certErr := os.Stat(certFile)
+	_, keyErr := os.Stat(keyFile)
+	if createMissing && errors.Is(certErr, os.ErrNotExist) && errors.Is(keyErr, os.ErrNotExist) {
+		if err := writeSelfSignedCertificate(certFile, keyFile, hosts); err != nil {
+			return tls.Certificate{}, fmt.Errorf("create self-signed relay certificate: %w", err)
+		}
+	}
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("load relay certificate: %w", err)
+	}
+	return certificate, nil
+}
+
+func writeSelfSignedCertificate(certFile, keyFile string, hosts []string) error {
+	certPEM, keyPEM, err := selfSignedCertificate(hosts)
+	if err != nil {
+		return err
+	}
+	for _, path := range []string{certFile, keyFile} {
+		if err = os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			return err
+		}
+	}
+	if err = os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		return err
+	}
+	return os.WriteFile(certFile, certPEM, 0o644)
+}
+
+// selfSignedCertificate creates a PEM certificate and key valid for hosts.
+func selfSignedCertificate(hosts []string) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "croc relay"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if host != "" {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
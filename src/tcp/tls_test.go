@@ -0,0 +1,84 @@
+package tcp
+
+import (
+	"bytes"
+	"crypto/tls"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/schollz/croc/v11/src/comm"
+)
+
+func TestLoadCertificateCreatesStableSelfSignedCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "relay.crt")
+	keyFile := filepath.Join(dir, "relay.key")
+
+	_, err := LoadCertificate(certFile, keyFile, false)
+	assert.Error(t, err)
+
+	first, err := LoadCertificate(certFile, keyFile, true, "relay.example.com", "127.0.0.1")
+	require.NoError(t, err)
+	info, err := os.Stat(keyFile)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	second, err := LoadCertificate(certFile, keyFile, true)
+	require.NoError(t, err)
+	assert.Equal(t, first.Certificate[0], second.Certificate[0])
+	assert.Error(t, WithTLS(&tls.Config{})(newDefaultServer()))
+}
+
+func TestTLSRelayWithPinnedCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certificate, err := LoadCertificate(filepath.Join(dir, "relay.crt"), filepath.Join(dir, "relay.key"), true)
+	require.NoError(t, err)
+	_, address, stopServer := startConfiguredTestServer(t,
+		WithTLS(&tls.Config{Certificates: []tls.Certificate{certificate}}),
+	)
+	defer stopServer()
+	_, port, err := net.SplitHostPort(address)
+	require.NoError(t, err)
+
+	wrong := strings.Repeat("00", 32)
+	relay, wrongTransport, err := comm.ParseRelayAddress("tls://127.0.0.1:" + port + "#sha256:" + wrong)
+	require.NoError(t, err)
+	wrongDialer := NewDialer(wrongTransport)
+	_, _, _, err = wrongDialer.ConnectToTCPServer(relay, "pass123", "pinned")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "fingerprint")
+
+	pinned := "tls://127.0.0.1:" + port + "#" + comm.Fingerprint(certificate.Certificate[0])
+	relay, pinnedTransport, err := comm.ParseRelayAddress(pinned)
+	require.NoError(t, err)
+	assert.Equal(t, address, relay)
+	dialer := NewDialer(pinnedTransport)
+	first, _, _, err := dialer.ConnectToTCPServer(relay, "pass123", "pinned")
+	require.NoError(t, err)
+	defer first.Close()
+	// another client of the same host keeps its own pin
+	_, _, _, err = wrongDialer.ConnectToTCPServer(relay, "pass123", "pinned")
+	assert.ErrorContains(t, err, "fingerprint")
+	second, _, _, err := dialer.ConnectToTCPServer(relay, "pass123", "pinned")
+	require.NoError(t, err)
+	defer second.Close()
+	_, isTLS := second.Connection().(*tls.Conn)
+	assert.True(t, isTLS)
+
+	require.NoError(t, second.Send([]byte("over tls")))
+	for {
+		got, receiveErr := first.Receive()
+		require.NoError(t, receiveErr)
+		if bytes.Equal(got, []byte{1}) {
+			continue
+		}
+		assert.Equal(t, []byte("over tls"), got)
+		break
+	}
+}
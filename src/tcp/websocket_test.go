@@ -0,0 +1,97 @@
+package tcp
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/schollz/croc/v11/src/comm"
+	"github.com/schollz/croc/v11/src/webrelay"
+)
+
+func TestWebSocketRelayThroughCrocWeb(t *testing.T) {
+	_, address, stopServer := startConfiguredTestServer(t)
+	defer stopServer()
+	_, port, err := net.SplitHostPort(address)
+	require.NoError(t, err)
+	handler, err := webrelay.Handler(webrelay.Config{
+		RelayHost:    "127.0.0.1",
+		AllowedPorts: []string{port},
+		StaticFiles: fstest.MapFS{
+			"index.html":  {Data: []byte("<!doctype html><html><head></head><body></body></html>")},
+			"default.txt": {Data: []byte("#!/bin/sh\n")},
+		},
+	})
+	require.NoError(t, err)
+	gateway := httptest.NewServer(handler)
+	defer gateway.Close()
+	_, gatewayPort, err := net.SplitHostPort(gateway.Listener.Addr().String())
+	require.NoError(t, err)
+
+	// Proxies are skipped for local IPs, so name the gateway by host.
+	relayURL := "ws://localhost:" + gatewayPort + "?port=" + port
+	relay, transport, err := comm.ParseRelayAddress(relayURL)
+	require.NoError(t, err)
+	assert.Equal(t, "localhost:"+port, relay)
+	assert.Equal(t, relayURL, transport.URL(relay))
+	dialer := NewDialer(transport)
+
+	first, _, _, err := dialer.ConnectToTCPServer(relay, "pass123", "websocket")
+	require.NoError(t, err)
+	defer first.Close()
+
+	// The second peer reaches croc-web through an HTTP CONNECT proxy.
+	var tunnels atomic.Int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "CONNECT only", http.StatusMethodNotAllowed)
+			return
+		}
+		upstream, dialErr := net.Dial("tcp", r.Host)
+		if dialErr != nil {
+			http.Error(w, dialErr.Error(), http.StatusBadGateway)
+			return
+		}
+		client, _, hijackErr := http.NewResponseController(w).Hijack()
+		if hijackErr != nil {
+			upstream.Close()
+			return
+		}
+		tunnels.Add(1)
+		_, _ = client.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		go func() {
+			_, _ = io.Copy(upstream, client)
+			upstream.Close()
+		}()
+		_, _ = io.Copy(client, upstream)
+		client.Close()
+	}))
+	defer proxy.Close()
+	comm.HttpProxy = proxy.URL
+	defer func() { comm.HttpProxy = "" }()
+
+	second, _, _, err := dialer.ConnectToTCPServer(relay, "pass123", "websocket")
+	require.NoError(t, err)
+	defer second.Close()
+	assert.Equal(t, int32(1), tunnels.Load())
+
+	payload := bytes.Repeat([]byte("over websocket "), 10_000)
+	require.NoError(t, second.Send(payload))
+	for {
+		got, receiveErr := first.Receive()
+		require.NoError(t, receiveErr)
+		if bytes.Equal(got, []byte{1}) {
+			continue
+		}
+		assert.Equal(t, payload, got)
+		break
+	}
+}
@@ -0,0 +1,125 @@
+package transfer
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/schollz/croc/v11/src/receivefs"
+)
+
+// DefaultHashAlgorithm is assumed when a sender does not name one.
+const DefaultHashAlgorithm = "xxhash"
+
+// ParseSenderInfo decodes the file list of a fileinfo message and validates
+// every destination in it.
+func ParseSenderInfo(data []byte) (SenderInfo, error) {
+	var info SenderInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return SenderInfo{}, fmt.Errorf("invalid file list: %w", err)
+	}
+	var err error
+	info.FilesToTransfer, info.EmptyFoldersToTransfer, err = ValidateReceiveMetadata(info.FilesToTransfer, info.EmptyFoldersToTransfer)
+	if err != nil {
+		return SenderInfo{}, err
+	}
+	for _, file := range info.FilesToTransfer {
+		if file.Size < 0 {
+			return SenderInfo{}, fmt.Errorf("invalid size for %q", file.Name)
+		}
+	}
+	if info.HashAlgorithm == "" {
+		info.HashAlgorithm = DefaultHashAlgorithm
+	}
+	return info, nil
+}
+
+// NormalizeReceiveFolder cleans a folder named by the sender, rejecting
+// paths that leave the receive directory.
+func NormalizeReceiveFolder(folder string) (string, error) {
+	cleanFolder, err := receivefs.Normalize(folder, true)
+	if err != nil {
+		return "", fmt.Errorf("filename must be a local path: %w", err)
+	}
+	if strings.Contains(cleanFolder, ".ssh") {
+		return "", fmt.Errorf("invalid path detected: %q", folder)
+	}
+	return cleanFolder, nil
+}
+
+// NormalizeReceiveFilePath cleans the folder and name of a file named by the
+// sender and returns the folder and the file's destination path.
+func NormalizeReceiveFilePath(folder, name string) (string, string, error) {
+	cleanFolder, err := NormalizeReceiveFolder(folder)
+	if err != nil {
+		return "", "", err
+	}
+	cleanName, err := receivefs.Normalize(name, false)
+	if err != nil || cleanName != path.Base(cleanName) {
+		if err == nil {
+			err = receivefs.ErrUnsafePath
+		}
+		return "", "", fmt.Errorf("filename must be a local path: %w", err)
+	}
+	destination := path.Clean(path.Join(cleanFolder, cleanName))
+	if _, err = receivefs.Normalize(destination, false); err != nil {
+		return "", "", fmt.Errorf("filename must be a local path: %w", err)
+	}
+	return cleanFolder, destination, nil
+}
+
+// ValidateReceiveSymlinkTarget checks that a symlink in folder points inside
+// the receive directory.
+func ValidateReceiveSymlinkTarget(folder, target string) error {
+	cleanTarget, err := receivefs.Normalize(target, false)
+	if err != nil {
+		return fmt.Errorf("symlink target must be a local path: %w", err)
+	}
+	if _, err = receivefs.Normalize(path.Join(folder, cleanTarget), false); err != nil {
+		return fmt.Errorf("symlink target escapes receive directory: %w", err)
+	}
+	return nil
+}
+
+// ValidateReceiveMetadata checks that the files and empty folders a sender
+// offers stay inside the receive directory and do not collide, and returns
+// them with cleaned folders and names.
+func ValidateReceiveMetadata(files []FileInfo, emptyFolders []FileInfo) ([]FileInfo, []FileInfo, error) {
+	normalizedFiles := make([]FileInfo, len(files))
+	normalizedEmptyFolders := make([]FileInfo, len(emptyFolders))
+	entries := make([]receivefs.Entry, 0, len(files)+len(emptyFolders))
+
+	for i, fi := range files {
+		cleanFolder, destination, err := NormalizeReceiveFilePath(fi.FolderRemote, fi.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+		kind := receivefs.KindFile
+		if fi.Symlink != "" {
+			if err := ValidateReceiveSymlinkTarget(cleanFolder, fi.Symlink); err != nil {
+				return nil, nil, err
+			}
+			kind = receivefs.KindSymlink
+		}
+		entries = append(entries, receivefs.Entry{Path: destination, Kind: kind})
+		normalizedFiles[i] = fi
+		normalizedFiles[i].FolderRemote = cleanFolder
+		normalizedFiles[i].Name = path.Base(strings.ReplaceAll(fi.Name, "\\", "/"))
+	}
+
+	for i, fi := range emptyFolders {
+		cleanFolder, err := NormalizeReceiveFolder(fi.FolderRemote)
+		if err != nil {
+			return nil, nil, err
+		}
+		entries = append(entries, receivefs.Entry{Path: cleanFolder, Kind: receivefs.KindDirectory})
+		normalizedEmptyFolders[i] = fi
+		normalizedEmptyFolders[i].FolderRemote = cleanFolder
+	}
+	if _, err := receivefs.ValidateEntries(entries); err != nil {
+		return nil, nil, fmt.Errorf("duplicate destination path: %w", err)
+	}
+
+	return normalizedFiles, normalizedEmptyFolders, nil
+}
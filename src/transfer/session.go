@@ -0,0 +1,219 @@
+package transfer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/schollz/croc/v11/src/message"
+)
+
+// ErrUnexpectedMessage marks a control message that the peer sent out of
+// order or that does not belong to this side of the transfer.
+var ErrUnexpectedMessage = errors.New("unexpected message")
+
+// PeerError is an error reported by the peer.
+type PeerError struct {
+	Message string
+}
+
+func (e *PeerError) Error() string {
+	return "peer error: " + e.Message
+}
+
+// Step tells the caller what a control message from the peer asks for.
+type Step struct {
+	// Reply is sent back to the peer when it is set.
+	Reply *message.Message
+	// Offer is the validated file list of a fileinfo message.
+	Offer *SenderInfo
+	// Request is the recipient's validated request for a file.
+	Request *RemoteFileRequest
+	// Compressed reports whether the chunks of the requested file are
+	// compressed.
+	Compressed bool
+	// Closed reports that the peer closed the current file.
+	Closed bool
+	// Done reports that the transfer is finished.
+	Done bool
+}
+
+// Session follows the file phase of one side of a transfer, once the peers
+// share a key: the sender offers files with FileInfo, the recipient asks for
+// each with Request and closes it with FileReceived, and either side ends
+// with Finish. Handle checks every control message from the peer. A Session
+// does no I/O; the caller sends the messages it returns and moves the data.
+// It also keeps the chunk ranges of the current file and the reconnect state
+// the peers agreed on.
+type Session struct {
+	sender bool
+	info   SenderInfo
+	// offered is set once the file list was sent or received.
+	offered bool
+	// current is the requested file, or -1 between files.
+	current            int
+	perFileCompression bool
+	finished           bool
+	// ranges are the chunk ranges requested for the current file.
+	ranges []int64
+	// reconnectVersion and peerReconnectVersion are the reconnect protocol
+	// versions of this side and of the peer.
+	reconnectVersion     int
+	peerReconnectVersion int
+}
+
+// NewSenderSession returns the sender's session for offering info.
+func NewSenderSession(info SenderInfo) *Session {
+	return &Session{sender: true, info: info, current: -1, reconnectVersion: info.ReconnectVersion}
+}
+
+// NewReceiverSession returns the recipient's session.
+func NewReceiverSession() *Session {
+	return &Session{current: -1}
+}
+
+// FileInfo returns the sender's fileinfo message.
+func (s *Session) FileInfo() (message.Message, error) {
+	if !s.sender {
+		return message.Message{}, errors.New("only the sender offers files")
+	}
+	b, err := json.Marshal(s.info)
+	if err != nil {
+		return message.Message{}, err
+	}
+	s.offered = true
+	return message.Message{Type: message.TypeFileInfo, Bytes: b}, nil
+}
+
+// Request returns the recipient's recipientready message asking for a file.
+func (s *Session) Request(request RemoteFileRequest) (message.Message, error) {
+	if s.sender || !s.offered {
+		return message.Message{}, errors.New("files can only be requested after the sender offered them")
+	}
+	if err := validateFileRequest(request, s.info.FilesToTransfer); err != nil {
+		return message.Message{}, err
+	}
+	b, err := json.Marshal(request)
+	if err != nil {
+		return message.Message{}, err
+	}
+	s.current = request.FilesToTransferCurrentNum
+	s.ranges = request.CurrentFileChunkRanges
+	s.reconnectVersion = request.ReconnectVersion
+	return message.Message{Type: message.TypeRecipientReady, Bytes: b}, nil
+}
+
+// Requested reports whether the chunk of the current file at position was
+// requested.
+func (s *Session) Requested(position int64) bool {
+	return s.current >= 0 && ChunkRangesContain(s.ranges, position)
+}
+
+// ReconnectRoom returns the room the sender offered for meeting again after
+// a dropped connection, or "" unless both peers speak ReconnectVersion.
+func (s *Session) ReconnectRoom() string {
+	if s.reconnectVersion < ReconnectVersion || s.peerReconnectVersion < ReconnectVersion {
+		return ""
+	}
+	return s.info.NextReconnectRoom
+}
+
+// FileReceived returns the recipient's close-sender message once every
+// requested chunk of the current file arrived.
+func (s *Session) FileReceived() (message.Message, error) {
+	if s.sender || s.current < 0 {
+		return message.Message{}, errors.New("no file is being received")
+	}
+	return message.Message{Type: message.TypeCloseSender}, nil
+}
+
+// Finish returns the finished message that ends the transfer.
+func (s *Session) Finish() message.Message {
+	s.finished = true
+	return message.Message{Type: message.TypeFinished}
+}
+
+// Handle checks a control message of the file phase from the peer and
+// returns what it asks for.
+func (s *Session) Handle(m message.Message) (Step, error) {
+	switch {
+	case m.Type == message.TypeError:
+		return Step{Done: true}, &PeerError{Message: m.Message}
+	case m.Type == message.TypeFinished:
+		step := Step{Done: true}
+		if !s.finished {
+			reply := s.Finish()
+			step.Reply = &reply
+		}
+		return step, nil
+	case m.Type == message.TypeFileInfo && !s.sender:
+		info, err := ParseSenderInfo(m.Bytes)
+		if err != nil {
+			return Step{Done: true}, err
+		}
+		s.info = info
+		s.offered = true
+		s.current = -1
+		s.peerReconnectVersion = info.ReconnectVersion
+		return Step{Offer: &info}, nil
+	case m.Type == message.TypeRecipientReady && s.sender && s.offered:
+		request, err := ParseFileRequest(m.Bytes, s.info.FilesToTransfer)
+		if err != nil {
+			return Step{Done: true}, err
+		}
+		s.current = request.FilesToTransferCurrentNum
+		s.ranges = request.CurrentFileChunkRanges
+		s.peerReconnectVersion = request.ReconnectVersion
+		s.perFileCompression = SupportsFeature(request.Features, PerFileCompressionFeature)
+		return Step{
+			Request:    &request,
+			Compressed: FileCompressed(s.info.NoCompress, s.perFileCompression, s.info.FilesToTransfer[s.current]),
+		}, nil
+	case m.Type == message.TypeCloseSender && s.sender:
+		s.current = -1
+		s.ranges = nil
+		reply := message.Message{Type: message.TypeCloseRecipient}
+		return Step{Reply: &reply, Closed: true}, nil
+	case m.Type == message.TypeCloseRecipient && !s.sender && s.current >= 0:
+		s.current = -1
+		s.ranges = nil
+		return Step{Closed: true}, nil
+	}
+	return Step{Done: true}, fmt.Errorf("%w %q", ErrUnexpectedMessage, m.Type)
+}
+
+// ParseFileRequest decodes the recipient's request for one of files.
+func ParseFileRequest(data []byte, files []FileInfo) (RemoteFileRequest, error) {
+	var request RemoteFileRequest
+	if err := json.Unmarshal(data, &request); err != nil {
+		return RemoteFileRequest{}, fmt.Errorf("invalid file request: %w", err)
+	}
+	if err := validateFileRequest(request, files); err != nil {
+		return RemoteFileRequest{}, err
+	}
+	return request, nil
+}
+
+func validateFileRequest(request RemoteFileRequest, files []FileInfo) error {
+	if request.FilesToTransferCurrentNum < 0 || request.FilesToTransferCurrentNum >= len(files) {
+		return fmt.Errorf("file %d was not offered", request.FilesToTransferCurrentNum)
+	}
+	return validateChunkRanges(request.CurrentFileChunkRanges)
+}
+
+// validateChunkRanges checks the layout of a chunk range list: empty for the
+// whole file, or the chunk size followed by start and count pairs.
+func validateChunkRanges(ranges []int64) error {
+	if len(ranges) == 0 {
+		return nil
+	}
+	if len(ranges)%2 == 0 || ranges[0] <= 0 {
+		return errors.New("invalid chunk ranges")
+	}
+	for i := 1; i < len(ranges); i += 2 {
+		if ranges[i] < 0 || ranges[i+1] < 0 {
+			return errors.New("invalid chunk ranges")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+// Package transfer implements the file phase of the croc protocol without any
+// I/O: the wire types that describe files, the validation of what a peer
+// offers and requests, and a Session that checks the order of control
+// messages. The CLI and the browser's WebAssembly bridge both build on it.
+package transfer
+
+import (
+	"os"
+	"slices"
+	"time"
+)
+
+// ReconnectVersion is the version of the reconnect protocol this package
+// speaks. Peers reconnect only when both advertise it.
+const ReconnectVersion = 1
+
+// Features advertised in SenderInfo and RemoteFileRequest.
+const (
+	// PerFileCompressionFeature lets the sender compress some files and not
+	// others; without it every chunk is compressed unless NoCompress is set.
+	PerFileCompressionFeature = "per-file-compression-v1"
+	// MerkleChunksFeature lets the recipient verify chunks against a Merkle
+	// tree and ask for failed ones again.
+	MerkleChunksFeature = "merkle-chunks-v1"
+)
+
+// FileInfo registers the information about the file
+type FileInfo struct {
+	Name         string      `json:"n,omitempty"`
+	FolderRemote string      `json:"fr,omitempty"`
+	FolderSource string      `json:"fs,omitempty"`
+	Hash         []byte      `json:"h,omitempty"`
+	Size         int64       `json:"s,omitempty"`
+	ModTime      time.Time   `json:"m,omitempty"`
+	IsCompressed bool        `json:"c"`
+	IsEncrypted  bool        `json:"e,omitempty"`
+	Symlink      string      `json:"sy,omitempty"`
+	Mode         os.FileMode `json:"md,omitempty"`
+	TempFile     bool        `json:"tf,omitempty"`
+	IsIgnored    bool        `json:"ig,omitempty"`
+}
+
+// RemoteFileRequest requests specific bytes
+type RemoteFileRequest struct {
+	CurrentFileChunkRanges    []int64
+	FilesToTransferCurrentNum int
+	MachineID                 string
+	ReconnectVersion          int
+	Features                  []string `json:",omitempty"`
+}
+
+// SenderInfo lists the files to be transferred
+type SenderInfo struct {
+	FilesToTransfer        []FileInfo
+	EmptyFoldersToTransfer []FileInfo
+	TotalNumberFolders     int
+	MachineID              string
+	Ask                    bool
+	SendingText            bool
+	NoCompress             bool
+	HashAlgorithm          string
+	ReconnectVersion       int
+	NextReconnectRoom      string
+	Features               []string `json:",omitempty"`
+}
+
+// SupportsFeature reports whether a peer advertised the wanted feature.
+func SupportsFeature(features []string, wanted string) bool {
+	return slices.Contains(features, wanted)
+}
+
+// FileCompressed reports whether the chunks of file are compressed, given
+// the sender's NoCompress setting and whether the peers negotiated
+// PerFileCompressionFeature.
+func FileCompressed(noCompress, perFileCompression bool, file FileInfo) bool {
+	if noCompress {
+		return false
+	}
+	// Older peers only understand the global switch and expect all chunks to
+	// be compressed whenever NoCompress is false.
+	if !perFileCompression {
+		return true
+	}
+	return file.IsCompressed
+}
@@ -0,0 +1,177 @@
+package transfer
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/schollz/croc/v11/src/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileCompressed(t *testing.T) {
+	compressed := FileInfo{Name: "a.txt", IsCompressed: true}
+	plain := FileInfo{Name: "a.zip"}
+	assert.False(t, FileCompressed(true, true, compressed))
+	assert.True(t, FileCompressed(false, false, plain))
+	assert.True(t, FileCompressed(false, true, compressed))
+	assert.False(t, FileCompressed(false, true, plain))
+	assert.True(t, SupportsFeature([]string{MerkleChunksFeature, PerFileCompressionFeature}, PerFileCompressionFeature))
+	assert.False(t, SupportsFeature(nil, PerFileCompressionFeature))
+}
+
+func TestParseSenderInfo(t *testing.T) {
+	info, err := ParseSenderInfo([]byte(`{"FilesToTransfer":[{"n":"a.txt","fr":"./dir","s":3}]}`))
+	require.NoError(t, err)
+	assert.Equal(t, DefaultHashAlgorithm, info.HashAlgorithm)
+	assert.Equal(t, "dir", info.FilesToTransfer[0].FolderRemote)
+
+	for data, want := range map[string]string{
+		`not json`: "invalid file list",
+		`{"FilesToTransfer":[{"n":"../a.txt","fr":"."}]}`:                     "filename must be a local path",
+		`{"FilesToTransfer":[{"n":"a.txt","fr":"."},{"n":"a.txt","fr":"."}]}`: "duplicate destination path",
+		`{"FilesToTransfer":[{"n":"a.txt","fr":".","s":-1}]}`:                 "invalid size",
+		`{"FilesToTransfer":[{"n":"a","fr":".","sy":"/etc/passwd"}]}`:         "symlink target",
+	} {
+		_, err := ParseSenderInfo([]byte(data))
+		assert.ErrorContains(t, err, want, data)
+	}
+}
+
+func TestParseFileRequest(t *testing.T) {
+	files := []FileInfo{{Name: "a.txt", FolderRemote: "."}}
+	request, err := ParseFileRequest([]byte(`{"FilesToTransferCurrentNum":0,"CurrentFileChunkRanges":[4096,0,2]}`), files)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{4096, 0, 2}, request.CurrentFileChunkRanges)
+
+	for _, data := range []string{
+		`{"FilesToTransferCurrentNum":1}`,
+		`{"FilesToTransferCurrentNum":-1}`,
+		`{"CurrentFileChunkRanges":[4096,0]}`,
+		`{"CurrentFileChunkRanges":[0,0,2]}`,
+		`{"CurrentFileChunkRanges":[4096,-1,2]}`,
+		`[]`,
+	} {
+		_, err := ParseFileRequest([]byte(data), files)
+		assert.Error(t, err, data)
+	}
+}
+
+func TestSession(t *testing.T) {
+	sender := NewSenderSession(SenderInfo{
+		FilesToTransfer: []FileInfo{{Name: "a.txt", FolderRemote: ".", Size: 3, IsCompressed: true}},
+	})
+	receiver := NewReceiverSession()
+
+	_, err := receiver.Request(RemoteFileRequest{})
+	assert.Error(t, err)
+	_, err = sender.Handle(message.Message{Type: message.TypeRecipientReady, Bytes: []byte(`{}`)})
+	assert.ErrorIs(t, err, ErrUnexpectedMessage)
+
+	offer, err := sender.FileInfo()
+	require.NoError(t, err)
+	step, err := receiver.Handle(offer)
+	require.NoError(t, err)
+	require.NotNil(t, step.Offer)
+	assert.Equal(t, "a.txt", step.Offer.FilesToTransfer[0].Name)
+
+	_, err = receiver.Request(RemoteFileRequest{FilesToTransferCurrentNum: 1})
+	assert.Error(t, err)
+	ready, err := receiver.Request(RemoteFileRequest{Features: []string{PerFileCompressionFeature}})
+	require.NoError(t, err)
+	step, err = sender.Handle(ready)
+	require.NoError(t, err)
+	require.NotNil(t, step.Request)
+	assert.True(t, step.Compressed)
+
+	closeSender, err := receiver.FileReceived()
+	require.NoError(t, err)
+	step, err = sender.Handle(closeSender)
+	require.NoError(t, err)
+	assert.True(t, step.Closed)
+	require.NotNil(t, step.Reply)
+	step, err = receiver.Handle(*step.Reply)
+	require.NoError(t, err)
+	assert.True(t, step.Closed)
+	_, err = receiver.Handle(message.Message{Type: message.TypeCloseRecipient})
+	assert.ErrorIs(t, err, ErrUnexpectedMessage)
+
+	step, err = sender.Handle(receiver.Finish())
+	require.NoError(t, err)
+	assert.True(t, step.Done)
+	require.NotNil(t, step.Reply)
+	step, err = receiver.Handle(*step.Reply)
+	require.NoError(t, err)
+	assert.True(t, step.Done)
+	assert.Nil(t, step.Reply)
+
+	_, err = sender.Handle(message.Message{Type: message.TypeError, Message: "refusing files"})
+	var peerErr *PeerError
+	require.ErrorAs(t, err, &peerErr)
+	assert.Equal(t, "peer error: refusing files", err.Error())
+}
+
+func TestSessionRejectsInvalidOffer(t *testing.T) {
+	b, err := json.Marshal(SenderInfo{FilesToTransfer: []FileInfo{{Name: "../a.txt", FolderRemote: "."}}})
+	require.NoError(t, err)
+	step, err := NewReceiverSession().Handle(message.Message{Type: message.TypeFileInfo, Bytes: b})
+	assert.ErrorContains(t, err, "filename must be a local path")
+	assert.True(t, step.Done)
+}
+
+func TestChunkRangesContain(t *testing.T) {
+	ranges := []int64{10, 0, 1, 40, 2, 70, 3}
+	assert.True(t, ChunkRangesContain(ranges, 50))
+	assert.False(t, ChunkRangesContain(ranges, 60))
+	assert.True(t, ChunkRangesContain(nil, 60))
+	assert.False(t, ChunkRangesContain([]int64{0, 0, 1}, 0))
+}
+
+func TestSessionChunkRangesAndReconnect(t *testing.T) {
+	sender := NewSenderSession(SenderInfo{
+		FilesToTransfer:   []FileInfo{{Name: "a.txt", FolderRemote: ".", Size: 100}},
+		ReconnectVersion:  ReconnectVersion,
+		NextReconnectRoom: "next-room",
+	})
+	receiver := NewReceiverSession()
+	assert.False(t, sender.Requested(0))
+
+	offer, err := sender.FileInfo()
+	require.NoError(t, err)
+	_, err = receiver.Handle(offer)
+	require.NoError(t, err)
+	assert.Empty(t, receiver.ReconnectRoom())
+
+	ready, err := receiver.Request(RemoteFileRequest{
+		CurrentFileChunkRanges: []int64{10, 40, 2, 70, 3},
+		ReconnectVersion:       ReconnectVersion,
+	})
+	require.NoError(t, err)
+	_, err = sender.Handle(ready)
+	require.NoError(t, err)
+	assert.Equal(t, "next-room", sender.ReconnectRoom())
+	assert.Equal(t, "next-room", receiver.ReconnectRoom())
+	for _, session := range []*Session{sender, receiver} {
+		assert.True(t, session.Requested(50))
+		assert.False(t, session.Requested(60))
+		assert.True(t, session.Requested(90))
+	}
+
+	closeSender, err := receiver.FileReceived()
+	require.NoError(t, err)
+	_, err = sender.Handle(closeSender)
+	require.NoError(t, err)
+	assert.False(t, sender.Requested(50))
+
+	old := NewSenderSession(SenderInfo{
+		FilesToTransfer:   []FileInfo{{Name: "a.txt", FolderRemote: ".", Size: 100}},
+		ReconnectVersion:  ReconnectVersion,
+		NextReconnectRoom: "next-room",
+	})
+	_, err = old.FileInfo()
+	require.NoError(t, err)
+	_, err = old.Handle(message.Message{Type: message.TypeRecipientReady, Bytes: []byte(`{}`)})
+	require.NoError(t, err)
+	assert.Empty(t, old.ReconnectRoom())
+	assert.True(t, old.Requested(60))
+}
@@ -150,6 +150,8 @@ func HashFileCtx(ctx context.Context, fname string, algorithm string, showProgre
 		return XXHashReader(sr, bar)
 	case "highway":
 		return HighwayHashReader(sr, bar)
+	case "merkle":
+		return MerkleHashReader(sr, bar)
 	default:
 		return nil, fmt.Errorf("unsupported algorithm: %s", algorithm)
 	}
@@ -271,6 +273,25 @@ func HighwayHashReader(sr *io.SectionReader, bar *progressbar.ProgressBar) ([]by
 	return h.Sum(nil), nil
 }
 
+// MerkleHashReader returns the Merkle root for a SectionReader.
+func MerkleHashReader(sr *io.SectionReader, bar *progressbar.ProgressBar) ([]byte, error) {
+	if _, err := sr.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	h := newMerkleHasher()
+	if bar != nil {
+		if _, err := io.Copy(io.MultiWriter(h, bar), sr); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := io.Copy(h, sr); err != nil {
+			return nil, err
+		}
+	}
+	return h.Sum(), nil
+}
+
 // Helper function to update existing HashFile to use HashFileCtx
 // func HashFile(fname string, algorithm string, showProgress ...bool) ([]byte, error) {
 // 	return HashFileCtx(context.Background(), fname, algorithm, showProgress...)
@@ -0,0 +1,222 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/schollz/progressbar/v3"
+)
+
+// MerkleBlockSize is the leaf size of the "merkle" hash. It equals the
+// transfer chunk size, models.TCP_BUFFER_SIZE/2, so that every chunk can be
+// verified on its own; the croc package refuses to compile otherwise.
+const MerkleBlockSize = 32 * 1024
+
+// MaxMerkleProofSize bounds the proof carried by one chunk: one sibling hash
+// per tree level, and a file of 2^64 bytes has fewer than 64 levels.
+const MaxMerkleProofSize = 64 * sha256.Size
+
+// The tree is left-balanced: each level pairs nodes from the left and an
+// unpaired last node moves up unchanged. Leaves and interior nodes use
+// distinct prefixes, so a node can never be presented as a leaf.
+func merkleLeaf(block []byte) (sum [sha256.Size]byte) {
+	h := sha256.New()
+	h.Write([]byte{0})
+	h.Write(block)
+	h.Sum(sum[:0])
+	return
+}
+
+func merkleNode(left, right [sha256.Size]byte) (sum [sha256.Size]byte) {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(left[:])
+	h.Write(right[:])
+	h.Sum(sum[:0])
+	return
+}
+
+// MerkleLeaves returns the number of leaves for a file of size bytes. An
+// empty file has a single empty leaf.
+func MerkleLeaves(size int64) int64 {
+	if size <= 0 {
+		return 1
+	}
+	return (size + MerkleBlockSize - 1) / MerkleBlockSize
+}
+
+// MerkleProofSize returns the length in bytes of the proof for leaf index.
+func MerkleProofSize(index, leaves int64) int {
+	size := 0
+	for width := leaves; width > 1; width = (width + 1) / 2 {
+		if index^1 < width {
+			size += sha256.Size
+		}
+		index /= 2
+	}
+	return size
+}
+
+// VerifyMerkleProof reports whether block is leaf index of the tree with the
+// given root and number of leaves.
+func VerifyMerkleProof(root []byte, index, leaves int64, block, proof []byte) bool {
+	if index < 0 || index >= leaves || len(proof) != MerkleProofSize(index, leaves) {
+		return false
+	}
+	sum := merkleLeaf(block)
+	for width := leaves; width > 1; width = (width + 1) / 2 {
+		if index^1 < width {
+			var sibling [sha256.Size]byte
+			copy(sibling[:], proof)
+			proof = proof[sha256.Size:]
+			if index%2 == 0 {
+				sum = merkleNode(sum, sibling)
+			} else {
+				sum = merkleNode(sibling, sum)
+			}
+		}
+		index /= 2
+	}
+	return bytes.Equal(sum[:], root)
+}
+
+// MerkleTree holds every level of a file's tree so that proofs can be served
+// for any chunk. It takes about 64 bytes of memory per 32 KiB of file.
+type MerkleTree struct {
+	levels [][][sha256.Size]byte
+}
+
+// NewMerkleTree reads size bytes from r and builds their tree.
+func NewMerkleTree(r io.ReaderAt, size int64) (*MerkleTree, error) {
+	leaves := make([][sha256.Size]byte, MerkleLeaves(size))
+	block := make([]byte, MerkleBlockSize)
+	for i := range leaves {
+		offset := int64(i) * MerkleBlockSize
+		n := int(min(size-offset, MerkleBlockSize))
+		if n < 0 {
+			n = 0
+		}
+		if _, err := r.ReadAt(block[:n], offset); err != nil && !(errors.Is(err, io.EOF) && n == 0) {
+			return nil, err
+		}
+		leaves[i] = merkleLeaf(block[:n])
+	}
+	t := &MerkleTree{levels: [][][sha256.Size]byte{leaves}}
+	for level := leaves; len(level) > 1; {
+		next := make([][sha256.Size]byte, (len(level)+1)/2)
+		for i := range next {
+			if 2*i+1 < len(level) {
+				next[i] = merkleNode(level[2*i], level[2*i+1])
+			} else {
+				next[i] = level[2*i]
+			}
+		}
+		t.levels = append(t.levels, next)
+		level = next
+	}
+	return t, nil
+}
+
+// Root returns the tree's root hash.
+func (t *MerkleTree) Root() []byte {
+	root := t.levels[len(t.levels)-1][0]
+	return root[:]
+}
+
+// AppendProof appends the sibling hashes that authenticate leaf index.
+func (t *MerkleTree) AppendProof(proof []byte, index int64) []byte {
+	for _, level := range t.levels[:len(t.levels)-1] {
+		if sibling := index ^ 1; sibling < int64(len(level)) {
+			proof = append(proof, level[sibling][:]...)
+		}
+		index /= 2
+	}
+	return proof
+}
+
+type merkleSubtree struct {
+	sum    [sha256.Size]byte
+	leaves int64
+}
+
+// merkleHasher computes a root while streaming, keeping only the roots of
+// the complete subtrees seen so far.
+type merkleHasher struct {
+	block []byte
+	stack []merkleSubtree
+	empty bool
+}
+
+func newMerkleHasher() *merkleHasher {
+	return &merkleHasher{block: make([]byte, 0, MerkleBlockSize), empty: true}
+}
+
+func (m *merkleHasher) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		n := min(len(p), MerkleBlockSize-len(m.block))
+		m.block = append(m.block, p[:n]...)
+		p = p[n:]
+		if len(m.block) == MerkleBlockSize {
+			m.push()
+		}
+	}
+	return written, nil
+}
+
+func (m *merkleHasher) push() {
+	m.empty = false
+	m.stack = append(m.stack, merkleSubtree{sum: merkleLeaf(m.block), leaves: 1})
+	m.block = m.block[:0]
+	for n := len(m.stack); n > 1 && m.stack[n-2].leaves == m.stack[n-1].leaves; n-- {
+		m.stack[n-2] = merkleSubtree{
+			sum:    merkleNode(m.stack[n-2].sum, m.stack[n-1].sum),
+			leaves: 2 * m.stack[n-1].leaves,
+		}
+		m.stack = m.stack[:n-1]
+	}
+}
+
+func (m *merkleHasher) Sum() []byte {
+	if len(m.block) > 0 || m.empty {
+		m.push()
+	}
+	sum := m.stack[len(m.stack)-1].sum
+	for i := len(m.stack) - 2; i >= 0; i-- {
+		sum = merkleNode(m.stack[i].sum, sum)
+	}
+	return sum[:]
+}
+
+// MerkleHashFile returns the root of a SHA-256 Merkle tree over the file's
+// MerkleBlockSize blocks.
+func MerkleHashFile(fname string, doShowProgress bool) (root []byte, err error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	h := newMerkleHasher()
+	var w io.Writer = h
+	if doShowProgress {
+		stat, _ := f.Stat()
+		fnameShort := shortenProgressFilename(fname)
+		bar := progressbar.NewOptions64(stat.Size(),
+			progressbar.OptionSetWriter(os.Stderr),
+			progressbar.OptionShowBytes(true),
+			progressbar.OptionSetDescription(fmt.Sprintf("Hashing %s", fnameShort)),
+			progressbar.OptionClearOnFinish(),
+			progressbar.OptionFullWidth(),
+		)
+		w = io.MultiWriter(h, bar)
+	}
+	if _, err = io.Copy(w, f); err != nil {
+		return
+	}
+	return h.Sum(), nil
+}
@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMerkleTreeMatchesStreamingRootAndProofs(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for _, size := range []int64{0, 1, MerkleBlockSize, MerkleBlockSize + 1, 3 * MerkleBlockSize, 7*MerkleBlockSize - 5, 12 * MerkleBlockSize} {
+		data := make([]byte, size)
+		random.Read(data)
+
+		tree, err := NewMerkleTree(bytes.NewReader(data), size)
+		require.NoError(t, err)
+		h := newMerkleHasher()
+		_, _ = h.Write(data)
+		assert.Equal(t, tree.Root(), h.Sum(), "size %d", size)
+
+		leaves := MerkleLeaves(size)
+		for index := int64(0); index < leaves; index++ {
+			start := index * MerkleBlockSize
+			block := data[start:min(start+MerkleBlockSize, size)]
+			proof := tree.AppendProof(nil, index)
+			assert.Len(t, proof, MerkleProofSize(index, leaves))
+			assert.True(t, VerifyMerkleProof(tree.Root(), index, leaves, block, proof), "size %d index %d", size, index)
+			if len(block) > 0 {
+				tampered := bytes.Clone(block)
+				tampered[0] ^= 1
+				assert.False(t, VerifyMerkleProof(tree.Root(), index, leaves, tampered, proof))
+			}
+			if leaves > 1 {
+				assert.False(t, VerifyMerkleProof(tree.Root(), (index+1)%leaves, leaves, block, proof))
+			}
+		}
+	}
+}
+
+func TestMerkleHashFile(t *testing.T) {
+	data := bytes.Repeat([]byte("merkle"), MerkleBlockSize)
+	name := filepath.Join(t.TempDir(), "merkle.bin")
+	require.NoError(t, os.WriteFile(name, data, 0o644))
+
+	tree, err := NewMerkleTree(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	root, err := HashFile(name, "merkle")
+	require.NoError(t, err)
+	assert.Equal(t, tree.Root(), root)
+	root, err = HashFileCtx(t.Context(), name, "merkle")
+	require.NoError(t, err)
+	assert.Equal(t, tree.Root(), root)
+}
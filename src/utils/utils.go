@@ -153,6 +153,8 @@ func HashFile(fname string, algorithm string, showProgress ...bool) (hash256 []b
 		return XXHashFile(fname, doShowProgress)
 	case "highway":
 		return HighwayHashFile(fname, doShowProgress)
+	case "merkle":
+		return MerkleHashFile(fname, doShowProgress)
 	}
 	err = fmt.Errorf("unspecified algorithm")
 	return
@@ -491,34 +493,6 @@ func ChunkRangesBytes(chunkRanges []int64, fileSize, defaultChunkSize int64) int
 	return total
 }
 
-// ChunkRangesContain reports whether the chunk at position is requested.
-// An empty range list requests every chunk.
-func ChunkRangesContain(chunkRanges []int64, position int64) bool {
-	if len(chunkRanges) == 0 {
-		return true
-	}
-	chunkSize := chunkRanges[0]
-	if chunkSize <= 0 {
-		return false
-	}
-	pairs := (len(chunkRanges) - 1) / 2
-	low, high := 0, pairs
-	for low < high {
-		mid := low + (high-low)/2
-		if chunkRanges[1+mid*2] <= position {
-			low = mid + 1
-		} else {
-			high = mid
-		}
-	}
-	if low == 0 {
-		return false
-	}
-	index := 1 + (low-1)*2
-	start, count := chunkRanges[index], chunkRanges[index+1]
-	return count > 0 && position < start+count*chunkSize
-}
-
 // ChunkRangesToChunks converts chunk ranges to list
 func ChunkRangesToChunks(chunkRanges []int64) (chunks []int64) {
 	if len(chunkRanges) == 0 {
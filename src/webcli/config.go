@@ -0,0 +1,322 @@
+package webcli
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/schollz/croc/v11/internal/cli"
+	storeapi "github.com/schollz/croc/v11/src/store"
+	"go.yaml.in/yaml/v3"
+)
+
+// maxConfigFileSize bounds the croc-web configuration file.
+const maxConfigFileSize = 1 << 20
+
+// configFile is the YAML configuration of croc-web. Its keys match the
+// command-line flags; the store section holds the store-* flags without
+// their prefix. Flags and environment variables override the file.
+type configFile struct {
+	Bind    *string  `yaml:"bind"`
+	Public  *string  `yaml:"public"`
+	Relays  []string `yaml:"relays"`
+	Ports   []string `yaml:"ports"`
+	Pass    *string  `yaml:"pass"`
+	Origins []string `yaml:"origins"`
+	Debug   *bool    `yaml:"debug"`
+
+	WebRTCStun []string `yaml:"webrtc-stun"`
+	NoWebRTC   *bool    `yaml:"no-webrtc"`
+
+	Store storeFile `yaml:"store"`
+	TLS   tlsFile   `yaml:"tls"`
+	Web   webFile   `yaml:"web"`
+}
+
+type storeFile struct {
+	Dir            *string  `yaml:"dir"`
+	MaxTransfer    *string  `yaml:"max-transfer"`
+	Quota          *string  `yaml:"quota"`
+	MinFree        *string  `yaml:"min-free"`
+	MaxFiles       *int     `yaml:"max-files"`
+	Downloads      *int     `yaml:"downloads"`
+	MaxExpiration  *string  `yaml:"max-expiration"`
+	CreateRate     *int     `yaml:"create-rate"`
+	ActiveUploads  *int     `yaml:"active-uploads"`
+	TrustedProxies []string `yaml:"trusted-proxies"`
+	Admin          *string  `yaml:"admin"`
+	Notifications  *bool    `yaml:"notifications"`
+}
+
+// tlsFile holds the tls-*, acme-*, redirect-http and hsts flags.
+type tlsFile struct {
+	Cert          *string        `yaml:"cert"`
+	Key           *string        `yaml:"key"`
+	ACMEDomains   []string       `yaml:"acme-domains"`
+	ACMEEmail     *string        `yaml:"acme-email"`
+	ACMEDirectory *string        `yaml:"acme-directory"`
+	ACMECache     *string        `yaml:"acme-cache"`
+	RedirectHTTP  *string        `yaml:"redirect-http"`
+	HSTS          *time.Duration `yaml:"hsts"`
+}
+
+// webFile holds the settings that are otherwise read from the environment.
+type webFile struct {
+	UmamiURL       *string `yaml:"umami-url"`
+	UmamiWebsiteID *string `yaml:"umami-website-id"`
+	GoogleAdSense  *string `yaml:"google-adsense"`
+	GoogleAdsTXT   *string `yaml:"google-ads-txt"`
+}
+
+// serveConfig is the effective croc-web configuration.
+type serveConfig struct {
+	publicAddress string
+	bind          string
+	bindExplicit  bool
+	relays        []string
+	ports         []string
+	pass          string
+	origins       []string
+	debug         bool
+	webRTCServers []string
+	noWebRTC      bool
+
+	storeDir      string
+	storeAdmin    string
+	store         storeapi.Config
+	notifications bool
+
+	tlsCert       string
+	tlsKey        string
+	acmeDomains   []string
+	acmeEmail     string
+	acmeDirectory string
+	acmeCache     string
+	redirectHTTP  string
+	hsts          time.Duration
+
+	umamiURL       string
+	umamiWebsiteID string
+	googleAdSense  string
+	googleAdsTXT   string
+}
+
+func readConfigFile(name string) (configFile, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return configFile{}, err
+	}
+	defer file.Close()
+	contents, err := io.ReadAll(io.LimitReader(file, maxConfigFileSize+1))
+	if err != nil {
+		return configFile{}, err
+	}
+	if len(contents) > maxConfigFileSize {
+		return configFile{}, fmt.Errorf("%s is too large", name)
+	}
+	var config configFile
+	decoder := yaml.NewDecoder(bytes.NewReader(contents))
+	decoder.KnownFields(true)
+	if err = decoder.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
+		return configFile{}, fmt.Errorf("%s: %w", name, err)
+	}
+	return config, nil
+}
+
+// loadServeConfig combines the flags with the file named by --config.
+func loadServeConfig(c *cli.Context) (serveConfig, error) {
+	var file configFile
+	if name := strings.TrimSpace(c.String("config")); name != "" {
+		var err error
+		if file, err = readConfigFile(name); err != nil {
+			return serveConfig{}, err
+		}
+	}
+	str := func(flag string, value *string) string {
+		if value != nil && !c.IsSet(flag) {
+			return *value
+		}
+		return c.String(flag)
+	}
+	integer := func(flag string, value *int) int {
+		if value != nil && !c.IsSet(flag) {
+			return *value
+		}
+		return c.Int(flag)
+	}
+	boolean := func(flag string, value *bool) bool {
+		if value != nil && !c.IsSet(flag) {
+			return *value
+		}
+		return c.Bool(flag)
+	}
+	duration := func(flag string, value *time.Duration) time.Duration {
+		if value != nil && !c.IsSet(flag) {
+			return *value
+		}
+		return c.Duration(flag)
+	}
+	list := func(flag string, values []string, parse func(string) []string) []string {
+		if values != nil && !c.IsSet(flag) {
+			return values
+		}
+		return parse(c.String(flag))
+	}
+	env := func(variable string, value *string) string {
+		if current := os.Getenv(variable); current != "" || value == nil {
+			return current
+		}
+		return *value
+	}
+
+	config := serveConfig{
+		publicAddress:  c.Args().First(),
+		bind:           str("bind", file.Bind),
+		bindExplicit:   c.IsSet("bind") || file.Bind != nil,
+		relays:         list("relays", file.Relays, parseRelayHosts),
+		ports:          list("ports", file.Ports, parseRelayPorts),
+		pass:           determinePass(str("pass", file.Pass)),
+		origins:        list("origins", file.Origins, parseRelayHosts),
+		debug:          boolean("debug", file.Debug),
+		webRTCServers:  list("webrtc-stun", file.WebRTCStun, parseRelayHosts),
+		noWebRTC:       boolean("no-webrtc", file.NoWebRTC),
+		storeDir:       strings.TrimSpace(str("store-dir", file.Store.Dir)),
+		storeAdmin:     strings.TrimSpace(str("store-admin", file.Store.Admin)),
+		notifications:  boolean("store-notifications", file.Store.Notifications),
+		tlsCert:        strings.TrimSpace(str("tls-cert", file.TLS.Cert)),
+		tlsKey:         strings.TrimSpace(str("tls-key", file.TLS.Key)),
+		acmeDomains:    list("acme-domains", file.TLS.ACMEDomains, parseRelayHosts),
+		acmeEmail:      strings.TrimSpace(str("acme-email", file.TLS.ACMEEmail)),
+		acmeDirectory:  strings.TrimSpace(str("acme-directory", file.TLS.ACMEDirectory)),
+		acmeCache:      strings.TrimSpace(str("acme-cache", file.TLS.ACMECache)),
+		redirectHTTP:   strings.TrimSpace(str("redirect-http", file.TLS.RedirectHTTP)),
+		hsts:           duration("hsts", file.TLS.HSTS),
+		umamiURL:       env("UMAMI_URL", file.Web.UmamiURL),
+		umamiWebsiteID: env("UMAMI_WEBSITE_ID", file.Web.UmamiWebsiteID),
+		googleAdSense:  env("GOOGLE_ADSENSE", file.Web.GoogleAdSense),
+		googleAdsTXT:   env("GOOGLE_ADS_TXT", file.Web.GoogleAdsTXT),
+	}
+	if config.publicAddress == "" && file.Public != nil {
+		config.publicAddress = *file.Public
+	}
+	if config.tlsCert != "" || len(config.acmeDomains) > 0 {
+		// HTTPS is served directly rather than behind a local reverse proxy.
+		if !config.bindExplicit {
+			config.bind, config.bindExplicit = ":443", true
+		}
+		if len(config.acmeDomains) > 0 && config.acmeCache == "" {
+			cacheDir, err := os.UserCacheDir()
+			if err != nil {
+				return serveConfig{}, fmt.Errorf("choose an --acme-cache directory: %w", err)
+			}
+			config.acmeCache = filepath.Join(cacheDir, "croc-web", "acme")
+		}
+	}
+	if config.storeAdmin != "" && config.storeDir == "" {
+		return serveConfig{}, errors.New("--store-admin requires --store-dir")
+	}
+	if config.storeDir == "" {
+		return config, nil
+	}
+
+	downloads := integer("store-downloads", file.Store.Downloads)
+	if downloads < 1 {
+		return serveConfig{}, errors.New("--store-downloads must be positive")
+	}
+	maxExpiration, err := storeapi.ParseExpiration(str("store-max-expiration", file.Store.MaxExpiration), true)
+	if err != nil {
+		return serveConfig{}, fmt.Errorf("invalid --store-max-expiration: %w", err)
+	}
+	maxTransfer, err := parseByteSize(str("store-max-transfer", file.Store.MaxTransfer))
+	if err != nil {
+		return serveConfig{}, fmt.Errorf("invalid --store-max-transfer: %w", err)
+	}
+	maxTotal, err := parseByteSize(str("store-quota", file.Store.Quota))
+	if err != nil {
+		return serveConfig{}, fmt.Errorf("invalid --store-quota: %w", err)
+	}
+	minFree, err := parseByteSize(str("store-min-free", file.Store.MinFree))
+	if err != nil {
+		return serveConfig{}, fmt.Errorf("invalid --store-min-free: %w", err)
+	}
+	proxies := c.StringSlice("store-trusted-proxy")
+	if file.Store.TrustedProxies != nil && !c.IsSet("store-trusted-proxy") {
+		proxies = file.Store.TrustedProxies
+	}
+	var trusted []netip.Prefix
+	for _, value := range proxies {
+		prefix, prefixErr := netip.ParsePrefix(strings.TrimSpace(value))
+		if prefixErr != nil {
+			return serveConfig{}, fmt.Errorf("invalid --store-trusted-proxy %q: %w", value, prefixErr)
+		}
+		trusted = append(trusted, prefix)
+	}
+	config.store = storeapi.Config{
+		Root:             config.storeDir,
+		MaxTransferBytes: maxTransfer,
+		MaxTotalBytes:    maxTotal,
+		MinFreeBytes:     minFree,
+		MaxFiles:         integer("store-max-files", file.Store.MaxFiles),
+		MaxDownloads:     downloads,
+		MaxExpiration:    maxExpiration,
+		CreatePerHour:    integer("store-create-rate", file.Store.CreateRate),
+		MaxActiveUploads: integer("store-active-uploads", file.Store.ActiveUploads),
+		TrustedProxies:   trusted,
+		Notifications:    config.notifications,
+	}
+	return config, nil
+}
+
+// restartRequired lists the settings of next that differ from the running
+// configuration but cannot change without a restart.
+func (config serveConfig) restartRequired(next serveConfig) []string {
+	var changed []string
+	for _, setting := range []struct {
+		name    string
+		changed bool
+	}{
+		{"bind", config.bind != next.bind},
+		{"public", config.publicAddress != next.publicAddress},
+		{"store.dir", config.storeDir != next.storeDir},
+		{"store.admin", config.storeAdmin != next.storeAdmin},
+		{"store.notifications", config.notifications != next.notifications},
+		{"tls", config.tlsCert != next.tlsCert || config.tlsKey != next.tlsKey ||
+			!slices.Equal(config.acmeDomains, next.acmeDomains) || config.acmeEmail != next.acmeEmail ||
+			config.acmeDirectory != next.acmeDirectory || config.acmeCache != next.acmeCache ||
+			config.redirectHTTP != next.redirectHTTP || config.hsts != next.hsts},
+		{"web", config.umamiURL != next.umamiURL || config.umamiWebsiteID != next.umamiWebsiteID ||
+			config.googleAdSense != next.googleAdSense || config.googleAdsTXT != next.googleAdsTXT},
+	} {
+		if setting.changed {
+			changed = append(changed, setting.name)
+		}
+	}
+	return changed
+}
+
+// withRestartSettings returns next with the settings that need a restart
+// taken from the running configuration.
+func (config serveConfig) withRestartSettings(next serveConfig) serveConfig {
+	next.bind, next.bindExplicit = config.bind, config.bindExplicit
+	next.publicAddress = config.publicAddress
+	next.storeAdmin = config.storeAdmin
+	next.tlsCert, next.tlsKey = config.tlsCert, config.tlsKey
+	next.acmeDomains, next.acmeEmail = config.acmeDomains, config.acmeEmail
+	next.acmeDirectory, next.acmeCache = config.acmeDirectory, config.acmeCache
+	next.redirectHTTP, next.hsts = config.redirectHTTP, config.hsts
+	next.umamiURL, next.umamiWebsiteID = config.umamiURL, config.umamiWebsiteID
+	next.googleAdSense, next.googleAdsTXT = config.googleAdSense, config.googleAdsTXT
+	if next.storeDir != config.storeDir {
+		next.storeDir, next.store = config.storeDir, config.store
+	}
+	next.notifications = config.notifications
+	next.store.Notifications = config.notifications
+	return next
+}
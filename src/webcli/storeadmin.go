@@ -0,0 +1,234 @@
+package webcli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/schollz/croc/v11/internal/cli"
+	storeapi "github.com/schollz/croc/v11/src/store"
+	"github.com/schollz/croc/v11/src/utils"
+)
+
+const storeAdminTimeout = 30 * time.Second
+
+func newStoreCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "store",
+		Usage: "manage encrypted temporary storage",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "admin",
+				Usage: "inspect a running service through its admin API",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "admin", Usage: "admin API address or unix:/path (see --store-admin)", EnvVars: []string{"CROC_STORE_ADMIN"}},
+					&cli.BoolFlag{Name: "json", Usage: "print raw JSON"},
+				},
+				Subcommands: []*cli.Command{
+					{
+						Name:   "usage",
+						Usage:  "show quota, reservation, and state counts",
+						Action: storeAdminUsage,
+					},
+					{
+						Name:  "transfers",
+						Usage: "list transfers with their state and size",
+						Flags: []cli.Flag{
+							&cli.StringFlag{Name: "state", Usage: "only list transfers in this state"},
+						},
+						Action: storeAdminTransfers,
+					},
+					{
+						Name:      "expire",
+						Usage:     "expire transfers now and delete their ciphertext",
+						ArgsUsage: "<transfer-id>...",
+						Action:    storeAdminExpire,
+					},
+					{
+						Name:   "windows",
+						Usage:  "show per-IP creation windows and active uploads",
+						Action: storeAdminWindows,
+					},
+				},
+			},
+		},
+	}
+}
+
+type storeAdminClient struct {
+	client *http.Client
+	base   string
+}
+
+func newStoreAdminClient(c *cli.Context) (*storeAdminClient, error) {
+	address := strings.TrimSpace(c.String("admin"))
+	if address == "" {
+		return nil, errors.New("--admin or CROC_STORE_ADMIN is required")
+	}
+	network, dialAddress := storeapi.AdminNetwork(address)
+	base := "http://" + dialAddress
+	if network == "unix" {
+		// The host is ignored when dialing a socket.
+		base = "http://store-admin"
+	}
+	dialer := &net.Dialer{Timeout: storeAdminTimeout}
+	return &storeAdminClient{
+		client: &http.Client{
+			Timeout: storeAdminTimeout,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return dialer.DialContext(ctx, network, dialAddress)
+				},
+			},
+		},
+		base: base + storeapi.AdminPrefix,
+	}, nil
+}
+
+func (a *storeAdminClient) do(method, path string, value any) ([]byte, error) {
+	request, err := http.NewRequest(method, a.base+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := a.client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("contact stored-transfer admin API: %w", err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(response.Body, 64<<20))
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode/100 != 2 {
+		message := strings.TrimSpace(string(body))
+		if message == "" {
+			message = response.Status
+		}
+		return nil, fmt.Errorf("stored-transfer admin API: %s", message)
+	}
+	if value != nil {
+		if err = json.Unmarshal(body, value); err != nil {
+			return nil, fmt.Errorf("decode stored-transfer admin response: %w", err)
+		}
+	}
+	return body, nil
+}
+
+func storeAdminUsage(c *cli.Context) error {
+	client, err := newStoreAdminClient(c)
+	if err != nil {
+		return err
+	}
+	var usage storeapi.AdminUsage
+	body, err := client.do(http.MethodGet, "/usage", &usage)
+	if err != nil || c.Bool("json") {
+		return writeRawJSON(c, body, err)
+	}
+	w := tabwriter.NewWriter(c.App.Writer, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "reserved\t%s of %s\n",
+		utils.ByteCountDecimal(usage.ReservedBytes), utils.ByteCountDecimal(usage.MaxTotalBytes))
+	fmt.Fprintf(w, "disk available\t%s (keeping %s free)\n",
+		utils.ByteCountDecimal(usage.AvailableBytes), utils.ByteCountDecimal(usage.MinFreeBytes))
+	fmt.Fprintf(w, "active uploads\t%d\n", usage.ActiveUploads)
+	states := make([]string, 0, len(usage.Transfers))
+	for state := range usage.Transfers {
+		states = append(states, state)
+	}
+	sort.Strings(states)
+	for _, state := range states {
+		fmt.Fprintf(w, "%s\t%d\n", state, usage.Transfers[state])
+	}
+	return w.Flush()
+}
+
+func storeAdminTransfers(c *cli.Context) error {
+	client, err := newStoreAdminClient(c)
+	if err != nil {
+		return err
+	}
+	path := "/transfers"
+	if state := strings.TrimSpace(c.String("state")); state != "" {
+		path += "?state=" + url.QueryEscape(state)
+	}
+	var transfers []storeapi.AdminTransfer
+	body, err := client.do(http.MethodGet, path, &transfers)
+	if err != nil || c.Bool("json") {
+		return writeRawJSON(c, body, err)
+	}
+	w := tabwriter.NewWriter(c.App.Writer, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATE\tSIZE\tRESERVED\tDOWNLOADS\tCREATED\tEXPIRES")
+	for _, transfer := range transfers {
+		expires := "-"
+		if !transfer.ExpiresAt.IsZero() {
+			expires = transfer.ExpiresAt.Local().Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d/%d\t%s\t%s\n",
+			transfer.ID,
+			transfer.State,
+			utils.ByteCountDecimal(transfer.CiphertextBytes),
+			utils.ByteCountDecimal(transfer.ReservedBytes),
+			transfer.DownloadsRemaining,
+			transfer.DownloadsTotal,
+			transfer.CreatedAt.Local().Format(time.RFC3339),
+			expires,
+		)
+	}
+	return w.Flush()
+}
+
+func storeAdminExpire(c *cli.Context) error {
+	if c.Args().Len() == 0 {
+		return errors.New("must specify at least one transfer ID")
+	}
+	client, err := newStoreAdminClient(c)
+	if err != nil {
+		return err
+	}
+	for _, id := range c.Args().Slice() {
+		id = strings.TrimSpace(id)
+		if _, err = client.do(http.MethodPost, "/transfers/"+url.PathEscape(id)+"/expire", nil); err != nil {
+			return fmt.Errorf("expire %s: %w", id, err)
+		}
+		fmt.Fprintf(c.App.Writer, "expired %s\n", id)
+	}
+	return nil
+}
+
+func storeAdminWindows(c *cli.Context) error {
+	client, err := newStoreAdminClient(c)
+	if err != nil {
+		return err
+	}
+	var windows []storeapi.AdminWindow
+	body, err := client.do(http.MethodGet, "/windows", &windows)
+	if err != nil || c.Bool("json") {
+		return writeRawJSON(c, body, err)
+	}
+	w := tabwriter.NewWriter(c.App.Writer, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "IP\tCREATED\tACTIVE UPLOADS\tNEXT SLOT")
+	for _, window := range windows {
+		next := "-"
+		if !window.ResetsAt.IsZero() {
+			next = window.ResetsAt.Local().Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%d/%d\t%d\t%s\n", window.IP, window.Created, window.Limit, window.ActiveUploads, next)
+	}
+	return w.Flush()
+}
+
+func writeRawJSON(c *cli.Context, body []byte, err error) error {
+	if err != nil {
+		return err
+	}
+	_, err = c.App.Writer.Write(body)
+	return err
+}
@@ -6,11 +6,12 @@ import (
 	"errors"
 	"fmt"
 	"net"
-	"net/netip"
 	"os"
+	"os/signal"
 	"runtime"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/schollz/croc/v11/internal/cli"
@@ -47,11 +48,23 @@ func newApp(ctx context.Context) *cli.App {
 	app.UsageText = "croc-web [OPTIONS] [public-host[:port]]"
 	app.ArgsUsage = "[public-host[:port]]"
 	app.Flags = []cli.Flag{
+		&cli.StringFlag{Name: "config", Usage: "read settings from this YAML file and reload it on SIGHUP", EnvVars: []string{"CROC_WEB_CONFIG"}},
 		&cli.StringFlag{Name: "bind", Value: "127.0.0.1:9014", Usage: "local HTTP bind address"},
 		&cli.StringFlag{Name: "relays", Aliases: []string{"relay"}, Value: strings.Join(publicrelay.Relays(), ","), Usage: "ordered comma-separated upstream croc relay hosts"},
 		&cli.StringFlag{Name: "ports", Value: "9009,9010,9011,9012,9013,9014,9015,9016,9017", Usage: "allowed upstream relay ports"},
+		&cli.StringFlag{Name: "origins", Usage: "comma-separated WebSocket origin patterns (default: the public address)"},
 		&cli.StringFlag{Name: "pass", Value: models.DEFAULT_PASSPHRASE, Usage: "password for the relay", EnvVars: []string{"CROC_PASS"}},
 		&cli.BoolFlag{Name: "debug", Usage: "enable debug logging"},
+		&cli.StringFlag{Name: "webrtc-stun", Usage: "comma-separated STUN server URLs for direct browser-to-browser WebRTC transfers"},
+		&cli.BoolFlag{Name: "no-webrtc", Usage: "keep browser-to-browser transfers on the relay"},
+		&cli.StringFlag{Name: "tls-cert", Usage: "serve HTTPS with this PEM certificate file (binds :443 unless --bind is set)"},
+		&cli.StringFlag{Name: "tls-key", Usage: "private key file for --tls-cert"},
+		&cli.StringFlag{Name: "acme-domains", Usage: "serve HTTPS with ACME certificates for these comma-separated DNS names"},
+		&cli.StringFlag{Name: "acme-email", Usage: "contact email for the ACME account", EnvVars: []string{"CROC_ACME_EMAIL"}},
+		&cli.StringFlag{Name: "acme-directory", Usage: "ACME directory URL (default: Let's Encrypt)"},
+		&cli.StringFlag{Name: "acme-cache", Usage: "directory for the ACME account and certificates (default: user cache directory)"},
+		&cli.StringFlag{Name: "redirect-http", Usage: "redirect plain HTTP on this address (such as :80) to HTTPS"},
+		&cli.DurationFlag{Name: "hsts", Value: 365 * 24 * time.Hour, Usage: "Strict-Transport-Security max-age of HTTPS responses (0 disables)"},
 		&cli.StringFlag{Name: "store-dir", Usage: "enable encrypted temporary storage in this directory"},
 		&cli.StringFlag{Name: "store-max-transfer", Value: "1GiB", Usage: "maximum plaintext bytes per stored transfer"},
 		&cli.StringFlag{Name: "store-quota", Value: "5GiB", Usage: "maximum managed stored-transfer bytes"},
@@ -62,7 +75,10 @@ func newApp(ctx context.Context) *cli.App {
 		&cli.IntFlag{Name: "store-create-rate", Value: 5, Usage: "stored transfers created per client IP per hour"},
 		&cli.IntFlag{Name: "store-active-uploads", Value: 2, Usage: "concurrent uploads per client IP"},
 		&cli.StringSliceFlag{Name: "store-trusted-proxy", Usage: "trusted reverse-proxy CIDR for client IP forwarding"},
+		&cli.StringFlag{Name: "store-admin", Usage: "serve the stored-transfer admin API on this loopback address or unix:/path", EnvVars: []string{"CROC_STORE_ADMIN"}},
+		&cli.BoolFlag{Name: "store-notifications", Usage: "deliver sender-requested stored-transfer webhooks", EnvVars: []string{"CROC_STORE_NOTIFICATIONS"}},
 	}
+	app.Commands = []*cli.Command{newStoreCommand()}
 	app.HideHelp = false
 	app.HideVersion = false
 	app.Action = func(c *cli.Context) error {
@@ -75,85 +91,126 @@ func serve(ctx context.Context, c *cli.Context) error {
 	if c.Args().Len() > 1 {
 		return errors.New("croc-web accepts one public website address")
 	}
-	if c.Bool("debug") {
+	config, err := loadServeConfig(c)
+	if err != nil {
+		return err
+	}
+	if config.debug {
 		log.SetLevel("debug")
 		log.Debug("debug mode on")
 	} else {
 		log.SetLevel("info")
 	}
 
-	publicAddress := c.Args().First()
 	bindAddress, origin, err := resolveServeAddress(
-		publicAddress,
-		c.String("bind"),
-		c.IsSet("bind"),
+		config.publicAddress,
+		config.bind,
+		config.bindExplicit,
 	)
 	if err != nil {
 		return err
 	}
 
 	var storeService *storeapi.Service
-	storeDirectory := strings.TrimSpace(c.String("store-dir"))
-	if storeDirectory != "" {
-		if c.Int("store-downloads") < 1 {
-			return errors.New("--store-downloads must be positive")
-		}
-		maxExpiration, parseErr := storeapi.ParseExpiration(c.String("store-max-expiration"), true)
-		if parseErr != nil {
-			return fmt.Errorf("invalid --store-max-expiration: %w", parseErr)
-		}
-		maxTransfer, parseErr := parseByteSize(c.String("store-max-transfer"))
-		if parseErr != nil {
-			return fmt.Errorf("invalid --store-max-transfer: %w", parseErr)
+	if config.storeDir != "" {
+		storeService, err = storeapi.New(config.store)
+		if err != nil {
+			return err
 		}
-		maxTotal, parseErr := parseByteSize(c.String("store-quota"))
-		if parseErr != nil {
-			return fmt.Errorf("invalid --store-quota: %w", parseErr)
+		defer storeService.Close()
+	}
+
+	relayConfig := func(config serveConfig) webrelay.Config {
+		origins := config.origins
+		if len(origins) == 0 {
+			origins = []string{origin}
 		}
-		minFree, parseErr := parseByteSize(c.String("store-min-free"))
-		if parseErr != nil {
-			return fmt.Errorf("invalid --store-min-free: %w", parseErr)
+		return webrelay.Config{
+			ListenAddress:       bindAddress,
+			PublicAddress:       origin,
+			RelayHosts:          config.relays,
+			RelayPassword:       config.pass,
+			AllowedPorts:        config.ports,
+			OriginPatterns:      origins,
+			WebRTCServers:       config.webRTCServers,
+			DisableWebRTC:       config.noWebRTC,
+			StaticFiles:         webassets.Files(),
+			StoreService:        storeService,
+			StoreAdminAddress:   config.storeAdmin,
+			UmamiURL:            config.umamiURL,
+			UmamiWebsiteID:      config.umamiWebsiteID,
+			GoogleAdSense:       config.googleAdSense,
+			GoogleAdsTXT:        config.googleAdsTXT,
+			TLSCertFile:         config.tlsCert,
+			TLSKeyFile:          config.tlsKey,
+			ACMEDomains:         config.acmeDomains,
+			ACMEEmail:           config.acmeEmail,
+			ACMEDirectoryURL:    config.acmeDirectory,
+			ACMECacheDir:        config.acmeCache,
+			HTTPRedirectAddress: config.redirectHTTP,
+			HSTSMaxAge:          config.hsts,
 		}
-		var trusted []netip.Prefix
-		for _, value := range c.StringSlice("store-trusted-proxy") {
-			prefix, prefixErr := netip.ParsePrefix(strings.TrimSpace(value))
-			if prefixErr != nil {
-				return fmt.Errorf("invalid --store-trusted-proxy %q: %w", value, prefixErr)
+	}
+	initial := relayConfig(config)
+	if c.String("config") != "" {
+		reload := make(chan webrelay.Config)
+		initial.Reload = reload
+		hangups := make(chan os.Signal, 1)
+		signal.Notify(hangups, syscall.SIGHUP)
+		defer signal.Stop(hangups)
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-hangups:
+				}
+				next, loadErr := reloadServeConfig(c, config, storeService, relayConfig)
+				if loadErr != nil {
+					log.Warnf("keeping the previous configuration: %v", loadErr)
+					continue
+				}
+				select {
+				case reload <- relayConfig(next):
+					config = next
+				case <-ctx.Done():
+					return
+				}
 			}
-			trusted = append(trusted, prefix)
+		}()
+	}
+	return webrelay.Run(ctx, initial)
+}
+
+// reloadServeConfig reads the configuration again after SIGHUP and applies
+// the new store limits. Settings that need a restart keep their values. The
+// relay settings are validated first, so that a reload applies completely or
+// not at all.
+func reloadServeConfig(c *cli.Context, current serveConfig, storeService *storeapi.Service, relayConfig func(serveConfig) webrelay.Config) (serveConfig, error) {
+	next, err := loadServeConfig(c)
+	if err != nil {
+		return serveConfig{}, err
+	}
+	if changed := current.restartRequired(next); len(changed) > 0 {
+		log.Warnf("restart croc-web to apply changes to %s", strings.Join(changed, ", "))
+		next = current.withRestartSettings(next)
+	}
+	if err = webrelay.ValidateConfig(relayConfig(next)); err != nil {
+		return serveConfig{}, err
+	}
+	if storeService != nil {
+		if err = storeService.Reconfigure(next.store); err != nil {
+			return serveConfig{}, err
 		}
-		storeService, err = storeapi.New(storeapi.Config{
-			Root:             storeDirectory,
-			MaxTransferBytes: maxTransfer,
-			MaxTotalBytes:    maxTotal,
-			MinFreeBytes:     minFree,
-			MaxFiles:         c.Int("store-max-files"),
-			MaxDownloads:     c.Int("store-downloads"),
-			MaxExpiration:    maxExpiration,
-			CreatePerHour:    c.Int("store-create-rate"),
-			MaxActiveUploads: c.Int("store-active-uploads"),
-			TrustedProxies:   trusted,
-		})
-		if err != nil {
-			return err
+	}
+	if next.debug != current.debug {
+		if next.debug {
+			log.SetLevel("debug")
+		} else {
+			log.SetLevel("info")
 		}
-		defer storeService.Close()
 	}
-
-	return webrelay.Run(ctx, webrelay.Config{
-		ListenAddress:  bindAddress,
-		PublicAddress:  origin,
-		RelayHosts:     parseRelayHosts(c.String("relays")),
-		RelayPassword:  determinePass(c.String("pass")),
-		AllowedPorts:   parseRelayPorts(c.String("ports")),
-		OriginPatterns: []string{origin},
-		StaticFiles:    webassets.Files(),
-		StoreService:   storeService,
-		UmamiURL:       os.Getenv("UMAMI_URL"),
-		UmamiWebsiteID: os.Getenv("UMAMI_WEBSITE_ID"),
-		GoogleAdSense:  os.Getenv("GOOGLE_ADSENSE"),
-		GoogleAdsTXT:   os.Getenv("GOOGLE_ADS_TXT"),
-	})
+	return next, nil
 }
 
 func determinePass(value string) string {
@@ -1,13 +1,19 @@
 package webcli
 
 import (
+	"bytes"
 	"context"
+	"net/http"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	internalcli "github.com/schollz/croc/v11/internal/cli"
+	storeapi "github.com/schollz/croc/v11/src/store"
+	"github.com/schollz/croc/v11/src/webrelay"
 )
 
 func TestAppIdentityAndArguments(t *testing.T) {
@@ -244,3 +250,221 @@ func TestDeterminePassReadsFilesAndTrims(t *testing.T) {
 		t.Fatalf("password = %q, want secret", actual)
 	}
 }
+
+func TestStoreAdminRequiresStorage(t *testing.T) {
+	err := newApp(context.Background()).Run([]string{"croc-web", "--store-admin", "127.0.0.1:0"})
+	if err == nil || err.Error() != "--store-admin requires --store-dir" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestStoreAdminCommands(t *testing.T) {
+	service, err := storeapi.New(storeapi.Config{Root: t.TempDir(), MinFreeBytes: 1, DisableRootLock: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer service.Close()
+	socket := "unix:" + filepath.Join(t.TempDir(), "admin.sock")
+	listener, err := storeapi.ListenAdmin(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: service.AdminHandler()}
+	go func() { _ = server.Serve(listener) }()
+	defer server.Close()
+
+	run := func(args ...string) (string, error) {
+		var output bytes.Buffer
+		app := newApp(context.Background())
+		app.Writer = &output
+		err := app.Run(append([]string{"croc-web", "store", "admin", "--admin", socket}, args...))
+		return output.String(), err
+	}
+	output, err := run("usage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output, "reserved") || !strings.Contains(output, "active uploads  0") {
+		t.Fatalf("usage output = %q", output)
+	}
+	output, err = run("--json", "transfers")
+	if err != nil || strings.TrimSpace(output) != "[]" {
+		t.Fatalf("transfers output = %q, %v; want empty JSON list", output, err)
+	}
+	if _, err = run("expire", "AAAAAAAAAAAAAAAAAAAAAA"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Fatalf("expire unknown transfer error = %v", err)
+	}
+	if _, err = run("expire"); err == nil {
+		t.Fatal("expire without IDs unexpectedly succeeded")
+	}
+}
+
+func loadTestServeConfig(t *testing.T, args ...string) (serveConfig, *internalcli.Context, error) {
+	t.Helper()
+	app := newApp(context.Background())
+	var (
+		config  serveConfig
+		parsed  *internalcli.Context
+		loadErr error
+	)
+	app.Action = func(c *internalcli.Context) error {
+		config, loadErr = loadServeConfig(c)
+		parsed = c
+		return nil
+	}
+	if err := app.Run(append([]string{"croc-web"}, args...)); err != nil {
+		t.Fatal(err)
+	}
+	return config, parsed, loadErr
+}
+
+func writeConfigFile(t *testing.T, name, contents string) {
+	t.Helper()
+	if err := os.WriteFile(name, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfigFileSettings(t *testing.T) {
+	t.Setenv("CROC_STORE_DOWNLOADS", "")
+	t.Setenv("UMAMI_URL", "")
+	name := filepath.Join(t.TempDir(), "croc-web.yaml")
+	storeDir := t.TempDir()
+	writeConfigFile(t, name, `
+public: croc.example
+relays: [relay1.example, relay2.example]
+ports: [9009, 9010]
+origins: [croc.example, "*.croc.example"]
+webrtc-stun: ["stun:stun.example:3478"]
+store:
+  dir: `+storeDir+`
+  downloads: 3
+  max-transfer: 10MB
+  max-expiration: 2d
+web:
+  umami-url: https://analytics.example/script.js
+`)
+
+	config, _, err := loadTestServeConfig(t, "--config", name, "--store-downloads", "5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.publicAddress != "croc.example" {
+		t.Fatalf("public address = %q", config.publicAddress)
+	}
+	if !reflect.DeepEqual(config.relays, []string{"relay1.example", "relay2.example"}) ||
+		!reflect.DeepEqual(config.ports, []string{"9009", "9010"}) ||
+		!reflect.DeepEqual(config.origins, []string{"croc.example", "*.croc.example"}) {
+		t.Fatalf("relays = %v, ports = %v, origins = %v", config.relays, config.ports, config.origins)
+	}
+	if config.store.Root != storeDir || config.store.MaxTransferBytes != 10_000_000 || config.store.MaxExpiration != 48*time.Hour {
+		t.Fatalf("store config = %+v", config.store)
+	}
+	if config.store.MaxDownloads != 5 {
+		t.Fatalf("store downloads = %d, want the flag's 5", config.store.MaxDownloads)
+	}
+	if !reflect.DeepEqual(config.webRTCServers, []string{"stun:stun.example:3478"}) || config.noWebRTC {
+		t.Fatalf("WebRTC servers = %v, disabled = %v", config.webRTCServers, config.noWebRTC)
+	}
+	if config.umamiURL != "https://analytics.example/script.js" {
+		t.Fatalf("umami URL = %q", config.umamiURL)
+	}
+
+	t.Setenv("UMAMI_URL", "https://env.example/script.js")
+	config, _, err = loadTestServeConfig(t, "--config", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.umamiURL != "https://env.example/script.js" || config.store.MaxDownloads != 3 {
+		t.Fatalf("umami URL = %q, store downloads = %d", config.umamiURL, config.store.MaxDownloads)
+	}
+}
+
+func TestConfigFileRejectsUnknownSettings(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "croc-web.yaml")
+	writeConfigFile(t, name, "store:\n  downlaods: 3\n")
+	if _, _, err := loadTestServeConfig(t, "--config", name); err == nil || !strings.Contains(err.Error(), "downlaods") {
+		t.Fatalf("unknown setting returned %v", err)
+	}
+	writeConfigFile(t, name, "")
+	if _, _, err := loadTestServeConfig(t, "--config", name); err != nil {
+		t.Fatalf("empty config file returned %v", err)
+	}
+}
+
+func TestReloadServeConfig(t *testing.T) {
+	t.Setenv("CROC_STORE_DOWNLOADS", "")
+	name := filepath.Join(t.TempDir(), "croc-web.yaml")
+	storeDir := t.TempDir()
+	writeConfigFile(t, name, "bind: 127.0.0.1:8080\nstore:\n  dir: "+storeDir+"\n  downloads: 2\n")
+	current, c, err := loadTestServeConfig(t, "--config", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current.store.DisableRootLock = true
+	storeService, err := storeapi.New(current.store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = storeService.Close() })
+
+	writeConfigFile(t, name, "bind: 127.0.0.1:9090\nstore:\n  dir: "+storeDir+"\n  downloads: 4\n  notifications: true\n")
+	relayConfig := func(config serveConfig) webrelay.Config {
+		return webrelay.Config{RelayHosts: config.relays, AllowedPorts: config.ports}
+	}
+	next, err := reloadServeConfig(c, current, storeService, relayConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := storeService.PublicConfig().MaxDownloads; got != 4 {
+		t.Fatalf("store downloads after reload = %d, want 4", got)
+	}
+	if next.bind != current.bind || next.notifications {
+		t.Fatalf("settings that need a restart changed: bind = %q, notifications = %v", next.bind, next.notifications)
+	}
+
+	writeConfigFile(t, name, "store:\n  dir: "+storeDir+"\n  downloads: 0\n")
+	if _, err = reloadServeConfig(c, next, storeService, relayConfig); err == nil {
+		t.Fatal("invalid reload was accepted")
+	}
+	if got := storeService.PublicConfig().MaxDownloads; got != 4 {
+		t.Fatalf("store downloads after a failed reload = %d, want 4", got)
+	}
+
+	writeConfigFile(t, name, "relays: [https://relay.example]\nstore:\n  dir: "+storeDir+"\n  downloads: 5\n")
+	if _, err = reloadServeConfig(c, next, storeService, relayConfig); err == nil || !strings.Contains(err.Error(), "not a URL") {
+		t.Fatalf("reload with an invalid relay returned %v", err)
+	}
+	if got := storeService.PublicConfig().MaxDownloads; got != 4 {
+		t.Fatalf("store downloads after a reload with an invalid relay = %d, want 4", got)
+	}
+}
+
+func TestTLSConfiguration(t *testing.T) {
+	config, _, err := loadTestServeConfig(t, "--acme-domains", "croc.example,www.croc.example", "--acme-cache", "/var/cache/croc-web", "croc.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.bind != ":443" || !reflect.DeepEqual(config.acmeDomains, []string{"croc.example", "www.croc.example"}) {
+		t.Fatalf("bind = %q, ACME domains = %v", config.bind, config.acmeDomains)
+	}
+	if config.hsts != 365*24*time.Hour {
+		t.Fatalf("HSTS max-age = %v", config.hsts)
+	}
+
+	name := filepath.Join(t.TempDir(), "croc-web.yaml")
+	writeConfigFile(t, name, "bind: 0.0.0.0:8443\ntls:\n  cert: cert.pem\n  key: key.pem\n  redirect-http: :8080\n  hsts: 0s\n")
+	config, _, err = loadTestServeConfig(t, "--config", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.bind != "0.0.0.0:8443" || config.tlsCert != "cert.pem" || config.redirectHTTP != ":8080" || config.hsts != 0 {
+		t.Fatalf("TLS config = %+v", config)
+	}
+
+	next := config
+	next.tlsCert = "renewed.pem"
+	if changed := config.restartRequired(next); !reflect.DeepEqual(changed, []string{"tls"}) {
+		t.Fatalf("restart required for %v", changed)
+	}
+}
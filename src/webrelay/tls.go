@@ -0,0 +1,220 @@
+package webrelay
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/schollz/logger"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// certificateCheckInterval bounds how often handshakes look for a renewed
+// static certificate.
+const certificateCheckInterval = time.Minute
+
+// tlsEnabled reports whether the server terminates TLS itself instead of
+// relying on a reverse proxy.
+func (config Config) tlsEnabled() bool {
+	return config.TLSCertFile != "" || len(config.ACMEDomains) > 0
+}
+
+func normalizeTLSConfig(config Config) (Config, error) {
+	if (config.TLSCertFile == "") != (config.TLSKeyFile == "") {
+		return Config{}, errors.New("a TLS certificate needs both a certificate and a key file")
+	}
+	domains := make([]string, 0, len(config.ACMEDomains))
+	for _, rawDomain := range config.ACMEDomains {
+		domain := strings.ToLower(strings.TrimSpace(rawDomain))
+		if domain == "" || strings.ContainsAny(domain, ":/?# \t") || net.ParseIP(domain) != nil {
+			return Config{}, fmt.Errorf("ACME certificates need DNS names, not %q", rawDomain)
+		}
+		domains = append(domains, domain)
+	}
+	config.ACMEDomains = domains
+	if len(domains) > 0 {
+		if config.TLSCertFile != "" {
+			return Config{}, errors.New("use either a TLS certificate file or ACME, not both")
+		}
+		if config.ACMECacheDir == "" {
+			return Config{}, errors.New("ACME certificates need a cache directory")
+		}
+		if config.ACMEDirectoryURL == "" {
+			config.ACMEDirectoryURL = acme.LetsEncryptURL
+		}
+	}
+	if config.HTTPRedirectAddress != "" && !config.tlsEnabled() {
+		return Config{}, errors.New("HTTP redirects need TLS")
+	}
+	return config, nil
+}
+
+// tlsTerminator holds the certificates of a server that terminates TLS.
+type tlsTerminator struct {
+	config *tls.Config
+	// manager answers ACME HTTP-01 challenges; it is nil for static
+	// certificates.
+	manager *autocert.Manager
+	// static is the certificate read from files; it is nil for ACME.
+	static *staticCertificate
+}
+
+func newTLSTerminator(config Config) (*tlsTerminator, error) {
+	if len(config.ACMEDomains) == 0 {
+		static := &staticCertificate{certFile: config.TLSCertFile, keyFile: config.TLSKeyFile, now: time.Now}
+		if err := static.load(); err != nil {
+			return nil, err
+		}
+		return &tlsTerminator{static: static, config: &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: static.getCertificate,
+		}}, nil
+	}
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(config.ACMECacheDir),
+		HostPolicy: autocert.HostWhitelist(config.ACMEDomains...),
+		Email:      config.ACMEEmail,
+		Client:     &acme.Client{DirectoryURL: config.ACMEDirectoryURL},
+	}
+	// The manager's TLS configuration also answers TLS-ALPN-01 challenges.
+	tlsConfig := manager.TLSConfig()
+	tlsConfig.MinVersion = tls.VersionTLS12
+	return &tlsTerminator{config: tlsConfig, manager: manager}, nil
+}
+
+// reload reads a static certificate again, as after SIGHUP. A certificate
+// that does not load keeps the previous one in use.
+func (t *tlsTerminator) reload() {
+	if t == nil || t.static == nil {
+		return
+	}
+	if err := t.static.load(); err != nil {
+		log.Warnf("keeping the previous TLS certificate: %v", err)
+		return
+	}
+	log.Infof("reloaded the TLS certificate from %s", t.static.certFile)
+}
+
+// staticCertificate serves the certificate in certFile and keyFile, and
+// reads them again when their modification times change, so that renewed
+// certificates are used without a restart.
+type staticCertificate struct {
+	certFile, keyFile string
+	now               func() time.Time
+
+	mu          sync.Mutex
+	certificate *tls.Certificate
+	modified    [2]time.Time
+	checked     time.Time
+}
+
+// load reads the certificate and key files.
+func (s *staticCertificate) load() error {
+	modified, err := s.modTimes()
+	if err != nil {
+		return fmt.Errorf("load TLS certificate: %w", err)
+	}
+	certificate, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+	if err != nil {
+		return fmt.Errorf("load TLS certificate: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.certificate, s.modified, s.checked = &certificate, modified, s.now()
+	return nil
+}
+
+func (s *staticCertificate) modTimes() (modified [2]time.Time, err error) {
+	for i, name := range []string{s.certFile, s.keyFile} {
+		info, statErr := os.Stat(name)
+		if statErr != nil {
+			return modified, statErr
+		}
+		modified[i] = info.ModTime()
+	}
+	return modified, nil
+}
+
+func (s *staticCertificate) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.Lock()
+	certificate, stale := s.certificate, s.now().Sub(s.checked) >= certificateCheckInterval
+	if stale {
+		s.checked = s.now()
+	}
+	previous := s.modified
+	s.mu.Unlock()
+	if !stale {
+		return certificate, nil
+	}
+	if modified, err := s.modTimes(); err != nil || modified == previous {
+		return certificate, nil
+	}
+	if err := s.load(); err != nil {
+		// the files may be half written; the next check tries again
+		log.Warnf("keeping the previous TLS certificate: %v", err)
+		return certificate, nil
+	}
+	log.Infof("reloaded the renewed TLS certificate from %s", s.certFile)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.certificate, nil
+}
+
+// httpHandler serves the plain-HTTP listener: ACME challenges when
+// certificates come from ACME, and redirects to HTTPS for everything else.
+func (t *tlsTerminator) httpHandler(listenAddress string) http.Handler {
+	redirect := httpsRedirect(listenAddress)
+	if t.manager == nil {
+		return redirect
+	}
+	return t.manager.HTTPHandler(redirect)
+}
+
+// httpsRedirect permanently redirects requests to the same host on the HTTPS
+// listener.
+func httpsRedirect(listenAddress string) http.Handler {
+	port := ""
+	if _, listenPort, err := net.SplitHostPort(listenAddress); err == nil && listenPort != "443" {
+		port = listenPort
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if splitHost, _, err := net.SplitHostPort(host); err == nil {
+			host = splitHost
+		}
+		host = strings.Trim(host, "[]")
+		if host == "" || strings.ContainsAny(host, "/?#@ \t") {
+			http.Error(w, "invalid host", http.StatusBadRequest)
+			return
+		}
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		if port != "" {
+			host += ":" + port
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
+
+// withHSTS tells browsers to use HTTPS for maxAge. A zero maxAge leaves the
+// header out.
+func withHSTS(next http.Handler, maxAge time.Duration) http.Handler {
+	if maxAge <= 0 {
+		return next
+	}
+	value := "max-age=" + strconv.FormatInt(int64(maxAge/time.Second), 10)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Strict-Transport-Security", value)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,319 @@
+package webrelay
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/acme"
+)
+
+func TestNormalizeTLSConfig(t *testing.T) {
+	for _, config := range []Config{
+		{TLSCertFile: "cert.pem"},
+		{TLSCertFile: "cert.pem", TLSKeyFile: "key.pem", ACMEDomains: []string{"croc.example"}, ACMECacheDir: "cache"},
+		{ACMEDomains: []string{"croc.example"}},
+		{ACMEDomains: []string{"127.0.0.1"}, ACMECacheDir: "cache"},
+		{HTTPRedirectAddress: ":80"},
+	} {
+		_, err := normalizeConfig(config)
+		assert.Error(t, err, "%+v", config)
+	}
+
+	normalized, err := normalizeConfig(Config{ACMEDomains: []string{" Croc.Example "}, ACMECacheDir: "cache"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"croc.example"}, normalized.ACMEDomains)
+	assert.Equal(t, acme.LetsEncryptURL, normalized.ACMEDirectoryURL)
+}
+
+func TestHTTPSRedirectAndHSTS(t *testing.T) {
+	for _, testCase := range []struct {
+		listen, host, want string
+	}{
+		{":443", "croc.example", "https://croc.example/ws?relay=0"},
+		{":443", "croc.example:80", "https://croc.example/ws?relay=0"},
+		{"0.0.0.0:8443", "croc.example:8080", "https://croc.example:8443/ws?relay=0"},
+		{":443", "[::1]:80", "https://[::1]/ws?relay=0"},
+	} {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, "/ws?relay=0", nil)
+		request.Host = testCase.host
+		httpsRedirect(testCase.listen).ServeHTTP(recorder, request)
+		assert.Equal(t, http.StatusPermanentRedirect, recorder.Code)
+		assert.Equal(t, testCase.want, recorder.Header().Get("Location"))
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	recorder := httptest.NewRecorder()
+	withHSTS(handler, 365*24*time.Hour).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, "max-age=31536000", recorder.Header().Get("Strict-Transport-Security"))
+	recorder = httptest.NewRecorder()
+	withHSTS(handler, 0).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Empty(t, recorder.Header().Get("Strict-Transport-Security"))
+}
+
+func TestStaticTLSCertificate(t *testing.T) {
+	authority := newTestAuthority(t)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	certificate := authority.issue(t, &key.PublicKey, "croc.test")
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+
+	terminator, err := newTLSTerminator(Config{TLSCertFile: certFile, TLSKeyFile: keyFile})
+	require.NoError(t, err)
+	assert.Equal(t, "ok\n", getOverTLS(t, terminator, authority, "croc.test"))
+	_, err = newTLSTerminator(Config{TLSCertFile: keyFile, TLSKeyFile: keyFile})
+	assert.Error(t, err)
+}
+
+// writeTestCertificate writes a new key and a certificate for croc.test and
+// returns the certificate.
+func writeTestCertificate(t *testing.T, authority *testAuthority, certFile, keyFile string, modified time.Time) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	certificate := authority.issue(t, &key.PublicKey, "croc.test")
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	require.NoError(t, os.Chtimes(certFile, modified, modified))
+	require.NoError(t, os.Chtimes(keyFile, modified, modified))
+	return certificate
+}
+
+func TestStaticTLSCertificateRenewal(t *testing.T) {
+	authority := newTestAuthority(t)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	modified := time.Now().Add(-time.Hour)
+	first := writeTestCertificate(t, authority, certFile, keyFile, modified)
+
+	terminator, err := newTLSTerminator(Config{TLSCertFile: certFile, TLSKeyFile: keyFile})
+	require.NoError(t, err)
+	now := time.Now()
+	terminator.static.now = func() time.Time { return now }
+	terminator.static.checked = now
+	served := func() []byte {
+		certificate, err := terminator.config.GetCertificate(&tls.ClientHelloInfo{ServerName: "croc.test"})
+		require.NoError(t, err)
+		return certificate.Certificate[0]
+	}
+	assert.Equal(t, first, served())
+
+	renewed := writeTestCertificate(t, authority, certFile, keyFile, modified.Add(time.Minute))
+	assert.Equal(t, first, served(), "the files were read again before the check interval")
+	now = now.Add(certificateCheckInterval)
+	assert.Equal(t, renewed, served())
+	assert.Equal(t, "ok\n", getOverTLS(t, terminator, authority, "croc.test"))
+
+	reloaded := writeTestCertificate(t, authority, certFile, keyFile, modified.Add(2*time.Minute))
+	terminator.reload()
+	assert.Equal(t, reloaded, served())
+
+	require.NoError(t, os.WriteFile(certFile, []byte("half written"), 0o600))
+	terminator.reload()
+	now = now.Add(certificateCheckInterval)
+	assert.Equal(t, reloaded, served(), "a broken certificate replaced the working one")
+}
+
+func TestACMECertificates(t *testing.T) {
+	authority := newTestAuthority(t)
+	directory := newACMEStandIn(t, authority)
+	config, err := normalizeConfig(Config{
+		ACMEDomains:      []string{"croc.test"},
+		ACMECacheDir:     t.TempDir(),
+		ACMEDirectoryURL: directory,
+	})
+	require.NoError(t, err)
+	terminator, err := newTLSTerminator(config)
+	require.NoError(t, err)
+
+	assert.Equal(t, "ok\n", getOverTLS(t, terminator, authority, "croc.test"))
+
+	// ACME challenges take precedence over the HTTPS redirect
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/.well-known/acme-challenge/unknown", nil)
+	request.Host = "croc.test"
+	terminator.httpHandler(":443").ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	request.Host = "croc.test"
+	terminator.httpHandler(":443").ServeHTTP(recorder, request)
+	assert.Equal(t, "https://croc.test/healthz", recorder.Header().Get("Location"))
+}
+
+// getOverTLS requests /healthz from a server using the terminator's TLS
+// configuration, trusting only the test authority.
+func getOverTLS(t *testing.T, terminator *tlsTerminator, authority *testAuthority, serverName string) string {
+	t.Helper()
+	handler, err := Handler(Config{RelayHost: "127.0.0.1", StaticFiles: testSite()})
+	require.NoError(t, err)
+	server := httptest.NewUnstartedServer(handler)
+	server.TLS = terminator.config
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(authority.certificate)
+	client := &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{ServerName: serverName, RootCAs: roots}},
+	}
+	response, err := client.Get(server.URL + "/healthz")
+	require.NoError(t, err)
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+type testAuthority struct {
+	key         *ecdsa.PrivateKey
+	certificate *x509.Certificate
+}
+
+func newTestAuthority(t *testing.T) *testAuthority {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "croc test authority"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	certificate, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testAuthority{key: key, certificate: certificate}
+}
+
+func (a *testAuthority) issue(t *testing.T, publicKey any, names ...string) []byte {
+	t.Helper()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, a.certificate, publicKey, a.key)
+	require.NoError(t, err)
+	return der
+}
+
+// newACMEStandIn serves just enough of an ACME directory for one order that
+// is already authorized, and returns the directory URL.
+func newACMEStandIn(t *testing.T, authority *testAuthority) string {
+	t.Helper()
+	var (
+		server *httptest.Server
+		issued []byte
+	)
+	payload := func(r *http.Request, v any) error {
+		var jws struct {
+			Payload string `json:"payload"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+			return err
+		}
+		data, err := base64.RawURLEncoding.DecodeString(jws.Payload)
+		if err != nil || v == nil {
+			return err
+		}
+		return json.Unmarshal(data, v)
+	}
+	order := func(status string) map[string]any {
+		response := map[string]any{
+			"status":      status,
+			"identifiers": []map[string]string{{"type": "dns", "value": "croc.test"}},
+			"finalize":    server.URL + "/finalize",
+		}
+		if status == "valid" {
+			response["certificate"] = server.URL + "/certificate"
+		}
+		return response
+	}
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Replay-Nonce", base64.RawURLEncoding.EncodeToString(big.NewInt(time.Now().UnixNano()).Bytes()))
+		reply := func(status int, v any) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			_ = json.NewEncoder(w).Encode(v)
+		}
+		switch r.URL.Path {
+		case "/directory":
+			reply(http.StatusOK, map[string]string{
+				"newNonce":   server.URL + "/nonce",
+				"newAccount": server.URL + "/account",
+				"newOrder":   server.URL + "/order",
+			})
+		case "/nonce":
+			w.WriteHeader(http.StatusOK)
+		case "/account":
+			w.Header().Set("Location", server.URL+"/account/1")
+			reply(http.StatusCreated, map[string]string{"status": "valid"})
+		case "/order":
+			w.Header().Set("Location", server.URL+"/order/1")
+			reply(http.StatusCreated, order("ready"))
+		case "/finalize":
+			var finalize struct {
+				CSR string `json:"csr"`
+			}
+			if err := payload(r, &finalize); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			der, err := base64.RawURLEncoding.DecodeString(finalize.CSR)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			request, err := x509.ParseCertificateRequest(der)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			issued = authority.issue(t, request.PublicKey, request.DNSNames...)
+			w.Header().Set("Location", server.URL+"/order/1")
+			reply(http.StatusOK, order("valid"))
+		case "/certificate":
+			w.Header().Set("Content-Type", "application/pem-certificate-chain")
+			_ = pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: issued})
+			_ = pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: authority.certificate.Raw})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server.URL + "/directory"
+}
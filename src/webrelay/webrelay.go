@@ -19,6 +19,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/coder/websocket"
@@ -48,21 +49,59 @@ type Config struct {
 	RelayPassword  string
 	StaticFiles    fs.FS
 	StoreService   *store.Service
-	UmamiURL       string
-	UmamiWebsiteID string
-	GoogleAdSense  string
-	GoogleAdsTXT   string
+	// StoreAdminAddress serves the stored-transfer operator API on a separate
+	// TCP address or "unix:" socket. It is never exposed on ListenAddress.
+	StoreAdminAddress string
+	UmamiURL          string
+	UmamiWebsiteID    string
+	GoogleAdSense     string
+	GoogleAdsTXT      string
+	// WebRTCServers lists the STUN server URLs that browsers use to find a
+	// direct WebRTC data channel to a browser peer. Without them, browsers
+	// only connect directly on the same network. DisableWebRTC keeps every
+	// browser transfer on the relay.
+	WebRTCServers []string
+	DisableWebRTC bool
+	// TLSCertFile and TLSKeyFile make the server terminate TLS with a static
+	// certificate instead of relying on a reverse proxy.
+	TLSCertFile string
+	TLSKeyFile  string
+	// ACMEDomains makes the server obtain and renew certificates for these
+	// DNS names from the ACME directory at ACMEDirectoryURL (Let's Encrypt by
+	// default). ACMECacheDir keeps the account key and certificates.
+	ACMEDomains      []string
+	ACMEEmail        string
+	ACMEDirectoryURL string
+	ACMECacheDir     string
+	// HTTPRedirectAddress serves plain HTTP redirects to HTTPS, and ACME
+	// HTTP-01 challenges, when the server terminates TLS.
+	HTTPRedirectAddress string
+	// HSTSMaxAge sets the Strict-Transport-Security header of HTTPS
+	// responses. Zero leaves it out.
+	HSTSMaxAge time.Duration
+	// Reload delivers configurations to a running server. Only the relay
+	// hosts, allowed ports, origin patterns, relay password, dial timeout,
+	// WebRTC settings and the published store limits are replaced; open WebSocket relays keep
+	// the settings they were accepted with. A static TLS certificate is read
+	// again from its files, which are also checked for renewal every minute.
+	Reload <-chan Config
 
 	trackCurlInstaller func()
 }
 
 type handler struct {
+	settings atomic.Pointer[handlerSettings]
+	mux      *http.ServeMux
+	static   http.Handler
+}
+
+// handlerSettings are the parts of the configuration that can be reloaded.
+type handlerSettings struct {
 	relayHosts     []string
 	allowedPorts   map[string]struct{}
 	originPatterns []string
 	dialTimeout    time.Duration
 	runtimeConfig  runtimeConfig
-	static         http.Handler
 }
 
 type runtimeConfig struct {
@@ -70,11 +109,21 @@ type runtimeConfig struct {
 	RelayAddresses []string           `json:"relayAddresses"`
 	RelayPassword  string             `json:"relayPassword"`
 	Store          store.PublicConfig `json:"store"`
+	// WebRTC is omitted when browsers must keep transfers on the relay.
+	WebRTC *webRTCConfig `json:"webRTC,omitempty"`
+}
+
+type webRTCConfig struct {
+	ICEServers []string `json:"iceServers"`
 }
 
 // Handler returns the unified croc web handler. It serves the embedded client,
 // /config.js, /healthz, and /ws?relay=<index>&port=<allowlisted relay port>.
 func Handler(config Config) (http.Handler, error) {
+	return newHandler(config)
+}
+
+func newHandler(config Config) (*handler, error) {
 	normalized, err := normalizeConfig(config)
 	if err != nil {
 		return nil, err
@@ -90,7 +139,29 @@ func Handler(config Config) (http.Handler, error) {
 		return nil, err
 	}
 
-	h := &handler{
+	h := &handler{static: static}
+	h.settings.Store(newHandlerSettings(normalized))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", h.health)
+	mux.HandleFunc("/ws", h.websocket)
+	mux.HandleFunc("/config.js", h.config)
+	if normalized.GoogleAdsTXT != "" {
+		mux.HandleFunc("/ads.txt", adsTXT(normalized.GoogleAdsTXT))
+	}
+	if normalized.StoreService != nil {
+		mux.Handle("/api/v1/store/transfers", normalized.StoreService)
+		mux.Handle("/api/v1/store/transfers/", normalized.StoreService)
+		mux.Handle("/api/v1/store/requests", normalized.StoreService)
+		mux.Handle("/api/v1/store/requests/", normalized.StoreService)
+	}
+	mux.Handle("/", h.static)
+	h.mux = mux
+	return h, nil
+}
+
+func newHandlerSettings(normalized Config) *handlerSettings {
+	settings := &handlerSettings{
 		relayHosts:     normalized.RelayHosts,
 		allowedPorts:   make(map[string]struct{}, len(normalized.AllowedPorts)),
 		originPatterns: normalized.OriginPatterns,
@@ -112,25 +183,34 @@ func Handler(config Config) (http.Handler, error) {
 				return normalized.StoreService.PublicConfig()
 			}(),
 		},
-		static: static,
+	}
+	if !normalized.DisableWebRTC {
+		settings.runtimeConfig.WebRTC = &webRTCConfig{ICEServers: normalized.WebRTCServers}
 	}
 	for _, port := range normalized.AllowedPorts {
-		h.allowedPorts[port] = struct{}{}
+		settings.allowedPorts[port] = struct{}{}
 	}
+	return settings
+}
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/healthz", h.health)
-	mux.HandleFunc("/ws", h.websocket)
-	mux.HandleFunc("/config.js", h.config)
-	if normalized.GoogleAdsTXT != "" {
-		mux.HandleFunc("/ads.txt", adsTXT(normalized.GoogleAdsTXT))
-	}
-	if normalized.StoreService != nil {
-		mux.Handle("/api/v1/store/transfers", normalized.StoreService)
-		mux.Handle("/api/v1/store/transfers/", normalized.StoreService)
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// reload replaces the reloadable settings. An invalid configuration leaves
+// the current settings in place.
+func (h *handler) reload(config Config) error {
+	normalized, err := normalizeConfig(config)
+	if err != nil {
+		return err
 	}
-	mux.Handle("/", h.static)
-	return mux, nil
+	h.settings.Store(newHandlerSettings(normalized))
+	log.Infof(
+		"reloaded croc web server configuration: relays %s (%s)",
+		strings.Join(normalized.RelayHosts, ","),
+		strings.Join(normalized.AllowedPorts, ","),
+	)
+	return nil
 }
 
 // Run starts the unified web client and relay bridge and blocks until the
@@ -165,7 +245,7 @@ func Run(ctx context.Context, config Config) error {
 			}
 		}
 	}
-	httpHandler, err := Handler(normalized)
+	httpHandler, err := newHandler(normalized)
 	if err != nil {
 		return err
 	}
@@ -175,11 +255,49 @@ func Run(ctx context.Context, config Config) error {
 		Handler:           httpHandler,
 		ReadHeaderTimeout: 10 * time.Second,
 	}
+	var terminator *tlsTerminator
+	if normalized.tlsEnabled() {
+		terminator, err = newTLSTerminator(normalized)
+		if err != nil {
+			return err
+		}
+		server.TLSConfig = terminator.config
+		server.Handler = withHSTS(httpHandler, normalized.HSTSMaxAge)
+	}
 
-	errc := make(chan error, 1)
+	errc := make(chan error, 3)
 	if normalized.StoreService != nil {
 		go normalized.StoreService.RunCleanup(ctx)
 	}
+	// auxiliary servers stop together with the web server
+	var auxiliary []*http.Server
+	if normalized.StoreService != nil && normalized.StoreAdminAddress != "" {
+		adminListener, listenErr := store.ListenAdmin(normalized.StoreAdminAddress)
+		if listenErr != nil {
+			return fmt.Errorf("listen for stored-transfer admin API: %w", listenErr)
+		}
+		adminServer := &http.Server{
+			Handler:           normalized.StoreService.AdminHandler(),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		auxiliary = append(auxiliary, adminServer)
+		go func() {
+			log.Infof("starting stored-transfer admin API on %s", normalized.StoreAdminAddress)
+			errc <- adminServer.Serve(adminListener)
+		}()
+	}
+	if normalized.HTTPRedirectAddress != "" {
+		redirectServer := &http.Server{
+			Addr:              normalized.HTTPRedirectAddress,
+			Handler:           terminator.httpHandler(normalized.ListenAddress),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		auxiliary = append(auxiliary, redirectServer)
+		go func() {
+			log.Infof("redirecting HTTP on %s to HTTPS", normalized.HTTPRedirectAddress)
+			errc <- redirectServer.ListenAndServe()
+		}()
+	}
 	go func() {
 		log.Infof(
 			"starting croc web server on %s for %s via %s (%s)",
@@ -188,18 +306,47 @@ func Run(ctx context.Context, config Config) error {
 			strings.Join(normalized.RelayHosts, ","),
 			strings.Join(normalized.AllowedPorts, ","),
 		)
-		errc <- server.ListenAndServe()
+		if terminator == nil {
+			errc <- server.ListenAndServe()
+			return
+		}
+		if len(normalized.ACMEDomains) > 0 {
+			log.Infof(
+				"serving HTTPS with certificates for %s from %s",
+				strings.Join(normalized.ACMEDomains, ","),
+				normalized.ACMEDirectoryURL,
+			)
+		}
+		errc <- server.ListenAndServeTLS("", "")
 	}()
 
-	select {
-	case <-ctx.Done():
-		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		if shutdownErr := server.Shutdown(shutdownCtx); shutdownErr != nil {
-			return shutdownErr
+serving:
+	for {
+		select {
+		case reloaded := <-normalized.Reload:
+			if reloadErr := httpHandler.reload(reloaded); reloadErr != nil {
+				log.Warnf("keeping the previous configuration: %v", reloadErr)
+			}
+			// renewed certificates at the same paths apply on reload too
+			terminator.reload()
+		case <-ctx.Done():
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			for _, auxiliaryServer := range auxiliary {
+				_ = auxiliaryServer.Shutdown(shutdownCtx)
+			}
+			if shutdownErr := server.Shutdown(shutdownCtx); shutdownErr != nil {
+				return shutdownErr
+			}
+			err = <-errc
+			break serving
+		case err = <-errc:
+			for _, auxiliaryServer := range auxiliary {
+				_ = auxiliaryServer.Close()
+			}
+			_ = server.Close()
+			break serving
 		}
-		err = <-errc
-	case err = <-errc:
 	}
 
 	if errors.Is(err, http.ErrServerClosed) {
@@ -208,6 +355,13 @@ func Run(ctx context.Context, config Config) error {
 	return err
 }
 
+// ValidateConfig reports whether Run and a reload would accept config, so
+// that callers can check a reloaded configuration before applying any of it.
+func ValidateConfig(config Config) error {
+	_, err := normalizeConfig(config)
+	return err
+}
+
 func normalizeConfig(config Config) (Config, error) {
 	if config.ListenAddress == "" {
 		config.ListenAddress = "127.0.0.1:9014"
@@ -277,7 +431,17 @@ func normalizeConfig(config Config) (Config, error) {
 	if config.DialTimeout <= 0 {
 		config.DialTimeout = defaultDialTimeout
 	}
-	return config, nil
+	servers := make([]string, 0, len(config.WebRTCServers))
+	for _, rawServer := range config.WebRTCServers {
+		server := strings.TrimSpace(rawServer)
+		scheme, address, found := strings.Cut(server, ":")
+		if !found || (scheme != "stun" && scheme != "stuns") || address == "" || strings.ContainsAny(address, "/?# ") {
+			return Config{}, fmt.Errorf("WebRTC server must be a stun: or stuns: URL: %q", rawServer)
+		}
+		servers = append(servers, server)
+	}
+	config.WebRTCServers = servers
+	return normalizeTLSConfig(config)
 }
 
 func (h *handler) health(w http.ResponseWriter, r *http.Request) {
@@ -295,7 +459,7 @@ func (h *handler) config(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	payload, err := json.Marshal(h.runtimeConfig)
+	payload, err := json.Marshal(h.settings.Load().runtimeConfig)
 	if err != nil {
 		http.Error(w, "could not create runtime configuration", http.StatusInternalServerError)
 		return
@@ -331,23 +495,24 @@ func (h *handler) websocket(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	settings := h.settings.Load()
 	relayIndex, err := strconv.Atoi(strings.TrimSpace(r.URL.Query().Get("relay")))
-	if err != nil || relayIndex < 0 || relayIndex >= len(h.relayHosts) {
+	if err != nil || relayIndex < 0 || relayIndex >= len(settings.relayHosts) {
 		http.Error(w, "relay index is not allowed", http.StatusForbidden)
 		return
 	}
 	port := strings.TrimSpace(r.URL.Query().Get("port"))
-	if _, allowed := h.allowedPorts[port]; !allowed {
+	if _, allowed := settings.allowedPorts[port]; !allowed {
 		http.Error(w, "relay port is not allowed", http.StatusForbidden)
 		return
 	}
 
-	dialCtx, cancelDial := context.WithTimeout(r.Context(), h.dialTimeout)
+	dialCtx, cancelDial := context.WithTimeout(r.Context(), settings.dialTimeout)
 	defer cancelDial()
 	upstream, err := (&net.Dialer{}).DialContext(
 		dialCtx,
 		"tcp",
-		net.JoinHostPort(h.relayHosts[relayIndex], port),
+		net.JoinHostPort(settings.relayHosts[relayIndex], port),
 	)
 	if err != nil {
 		http.Error(w, "relay is unavailable", http.StatusBadGateway)
@@ -355,7 +520,7 @@ func (h *handler) websocket(w http.ResponseWriter, r *http.Request) {
 	}
 
 	socket, err := websocket.Accept(w, r, &websocket.AcceptOptions{
-		OriginPatterns: h.originPatterns,
+		OriginPatterns: settings.originPatterns,
 	})
 	if err != nil {
 		_ = upstream.Close()
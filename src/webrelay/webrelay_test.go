@@ -161,7 +161,7 @@ func TestServesSiteAndRuntimeConfig(t *testing.T) {
 	assert.Equal(t, "no-store", recorder.Header().Get("Cache-Control"))
 	assert.JSONEq(
 		t,
-		`{"gatewayURL":"/ws","relayAddresses":["relay.example.test:9109"],"relayPassword":"relay-secret","store":{"enabled":false,"maxTransferBytes":0,"maxFiles":0,"maxDownloads":0,"expiresSeconds":0,"maxExpiresSeconds":0}}`,
+		`{"gatewayURL":"/ws","relayAddresses":["relay.example.test:9109"],"relayPassword":"relay-secret","store":{"enabled":false,"maxTransferBytes":0,"maxFiles":0,"maxDownloads":0,"expiresSeconds":0,"maxExpiresSeconds":0},"webRTC":{"iceServers":[]}}`,
 		strings.TrimSuffix(
 			strings.TrimPrefix(recorder.Body.String(), "window.__CROC_RUNTIME_CONFIG__ = "),
 			";\n",
@@ -617,3 +617,65 @@ func TestRejectsUnexpectedOrigin(t *testing.T) {
 	require.NotNil(t, response)
 	assert.Equal(t, http.StatusForbidden, response.StatusCode)
 }
+
+func TestReloadKeepsOpenWebSockets(t *testing.T) {
+	host, port := startEchoServer(t)
+	handler, err := newHandler(Config{
+		RelayHost:      host,
+		AllowedPorts:   []string{port},
+		OriginPatterns: []string{"example.test"},
+		StaticFiles:    testSite(),
+	})
+	require.NoError(t, err)
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?relay=0&port=" + port
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	connection, _, err := websocket.Dial(ctx, url, &websocket.DialOptions{
+		HTTPHeader: http.Header{"Origin": []string{"https://example.test"}},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = connection.CloseNow() })
+
+	require.Error(t, handler.reload(Config{RelayHosts: []string{"https://bad.example"}}))
+	require.NoError(t, handler.reload(Config{
+		RelayHosts:     []string{"relay.example"},
+		AllowedPorts:   []string{"9100"},
+		OriginPatterns: []string{"example.test"},
+		StaticFiles:    testSite(),
+	}))
+
+	require.NoError(t, connection.Write(ctx, websocket.MessageBinary, []byte("still open")))
+	_, received, err := connection.Read(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "still open", string(received))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/ws?relay=0&port="+port, nil))
+	assert.Equal(t, http.StatusForbidden, recorder.Code, "new relays use the reloaded port allowlist")
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/config.js", nil))
+	assert.Contains(t, recorder.Body.String(), `"relayAddresses":["relay.example:9100"]`)
+}
+
+func TestWebRTCRuntimeConfig(t *testing.T) {
+	runtime := func(config Config) string {
+		config.StaticFiles = testSite()
+		handler, err := Handler(config)
+		require.NoError(t, err)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/config.js", nil))
+		return recorder.Body.String()
+	}
+
+	assert.Contains(t, runtime(Config{WebRTCServers: []string{"stun:stun.example:3478", " stuns:stun.example "}}),
+		`"webRTC":{"iceServers":["stun:stun.example:3478","stuns:stun.example"]}`)
+	assert.NotContains(t, runtime(Config{DisableWebRTC: true}), "webRTC")
+
+	for _, server := range []string{"turn:turn.example", "stun:", "https://stun.example", "stun.example:3478"} {
+		_, err := Handler(Config{WebRTCServers: []string{server}, StaticFiles: testSite()})
+		assert.Error(t, err, server)
+	}
+}
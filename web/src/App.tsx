@@ -34,6 +34,7 @@ import {
   sendFiles,
 } from "./protocol/client";
 import {
+  fileRequestFromLocation,
   formatStoredCLIToken,
   inspectStoredTransfer,
   isStoredShareValue,
@@ -69,6 +70,7 @@ import {
   formatStoredExpiration,
   StoredExpirationControl,
   StoredModeSwitch,
+  StoredRequestReceipt,
   StoredShareCard,
   storedExpirationParts,
   storedExpirationSeconds,
@@ -117,6 +119,9 @@ const requestedReceiveValue = requestedReceiveCode || requestedStoredURL;
 const receiveOnly = requestedReceiveValue !== "";
 const storeRuntime = runtimeSettings.store ?? {};
 const storeEnabled = storeRuntime.enabled === true;
+const requestedFileRequest = storeEnabled
+  ? (fileRequestFromLocation() ?? "")
+  : "";
 const storeMaxTransferBytes = storeRuntime.maxTransferBytes || 1024 ** 3;
 const storeMaxFiles = storeRuntime.maxFiles || 100;
 const storeMaxDownloads = storeRuntime.maxDownloads || 1;
@@ -272,6 +277,9 @@ const defaultSettings: TransferSettings = {
     import.meta.env.VITE_CROC_RELAY_PASSWORD ||
     "pass123",
   storeAPI: "/api/v1/store",
+  webRTC: runtimeSettings.webRTC
+    ? { iceServers: runtimeSettings.webRTC.iceServers?.filter(Boolean) ?? [] }
+    : undefined,
 };
 
 function storedValue(key: string, fallback: string) {
@@ -283,7 +291,7 @@ function storedValue(key: string, fallback: string) {
 }
 
 function restoreStoredUpload() {
-  if (!storeEnabled) return undefined;
+  if (!storeEnabled || requestedFileRequest) return undefined;
   try {
     let restored: StoredUploadResult | undefined;
     for (let index = 0; index < sessionStorage.length; index += 1) {
@@ -679,6 +687,7 @@ export function App() {
       defaultSettings.relayPassword,
     ),
     storeAPI: defaultSettings.storeAPI,
+    webRTC: defaultSettings.webRTC,
   }));
   const [rememberPassword, setRememberPassword] = useState(() => {
     try {
@@ -692,7 +701,7 @@ export function App() {
   const [sendContent, setSendContent] = useState<SendContent>("files");
   const [sendText, setSendText] = useState("");
   const [sendMode, setSendMode] = useState<SendMode>(
-    restoredStoredUpload ? "stored" : "direct",
+    restoredStoredUpload || requestedFileRequest ? "stored" : "direct",
   );
   const [mobileTransferPanel, setMobileTransferPanel] =
     useState<MobileTransferPanel>(receiveOnly ? "receive" : "send");
@@ -892,6 +901,8 @@ export function App() {
 
   function rememberStoredUpload(result: StoredUploadResult) {
     setStoredUpload(result);
+    // Request uploads have no link to restore; the requester collects them.
+    if (result.fileRequest) return;
     try {
       sessionStorage.setItem(
         `croc-store-upload:${result.share.id}`,
@@ -954,6 +965,7 @@ export function App() {
         onStatus: setSendStatus,
         onProgress: updateSendProgress,
       },
+      request: requestedFileRequest || undefined,
     });
     rememberStoredUpload(result);
   }
@@ -1450,19 +1462,21 @@ export function App() {
             <div>
               <h2>Send</h2>
               <p>
-                {sendMode === "stored"
-                  ? `Upload encrypted files for ${formatStoredExpiration(
-                      storedExpiration.value,
-                      storedExpiration.unit,
-                    )} or ${storedDownloads} ${
-                      storedDownloads === 1 ? "download" : "downloads"
-                    }.`
-                  : "Choose several files. Share one croc code."}
+                {requestedFileRequest
+                  ? "Upload encrypted files that only the requester can open."
+                  : sendMode === "stored"
+                    ? `Upload encrypted files for ${formatStoredExpiration(
+                        storedExpiration.value,
+                        storedExpiration.unit,
+                      )} or ${storedDownloads} ${
+                        storedDownloads === 1 ? "download" : "downloads"
+                      }.`
+                    : "Choose several files. Share one croc code."}
               </p>
             </div>
           </div>
 
-          {storeEnabled && (
+          {storeEnabled && !requestedFileRequest && (
             <StoredModeSwitch
               mode={sendMode}
               disabled={sendBusy || storedUpload !== undefined}
@@ -1728,7 +1742,14 @@ export function App() {
             </>
           )}
 
-          {storedShareReady && storedUpload && (
+          {storedShareReady && storedUpload?.fileRequest && (
+            <StoredRequestReceipt
+              upload={storedUpload}
+              onRevoke={() => void revokeCurrentStoredUpload()}
+            />
+          )}
+
+          {storedShareReady && storedUpload && !storedUpload.fileRequest && (
             <StoredShareCard
               upload={storedUpload}
               onCopy={copyValue}
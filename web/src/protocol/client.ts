@@ -8,7 +8,15 @@ import {
   textDecoder,
   textEncoder,
 } from "./bytes";
-import { CrocSocket } from "./transport";
+import { CrocSocket, type DataConnection } from "./transport";
+import {
+  RELAY_ROUTE,
+  WEBRTC_RECIPIENT_TIMEOUT_MS,
+  WEBRTC_ROUTE,
+  WEBRTC_SENDER_TIMEOUT_MS,
+  WebRTCNegotiation,
+  webRTCAvailable,
+} from "./webrtc";
 import { normalizeOutgoingFileName, validateSenderInfo } from "./metadata";
 import { verifySink } from "./storage";
 import { hashFileContents, waitForHash, type FileHashProvider } from "./hash";
@@ -26,7 +34,7 @@ import type {
   WireFileInfo,
 } from "./types";
 import { maxTextTransferBytes } from "./types";
-import { wasm } from "../wasm/client";
+import { wasm, type HybridOffer } from "../wasm/client";
 
 const CONTROL_PORT = "9009";
 const CHUNK_SIZE = 32 * 1024;
@@ -40,6 +48,8 @@ const WEAK_RELAY_KEY = new Uint8Array([1, 2, 3]);
 const PAKE_PROTOCOL_VERSION = 2;
 const PAKE_SALT_SIZE = 32;
 const PAKE_PURPOSE_TRANSFER = "peer-transfer";
+const PAKE_HYBRID_MLKEM768 = "mlkem768-hybrid-v1";
+const PAKE_REQUIRE_HYBRID_MLKEM768 = `require-${PAKE_HYBRID_MLKEM768}`;
 const PAKE_PURPOSE_LOCAL_PROBE = "local-ip-probe";
 
 type RelayConnection = {
@@ -314,11 +324,58 @@ async function openDataConnections(
   return connected.map(({ socket }) => socket);
 }
 
-function closeAll(control?: CrocSocket, data: CrocSocket[] = []) {
+async function releaseSession(session?: number, cipherHandle?: number) {
+  if (session !== undefined) {
+    await wasm()
+      .transferRelease(session)
+      .catch(() => {});
+  }
+  if (cipherHandle !== undefined) {
+    await wasm()
+      .cipherRelease(cipherHandle)
+      .catch(() => {});
+  }
+}
+
+function closeAll(control?: CrocSocket, data: DataConnection[] = []) {
   control?.close();
   for (const socket of data) socket.close();
 }
 
+// offerWebRTC starts a data channel negotiation as the recipient. Any failure
+// leaves the transfer on the relay.
+async function offerWebRTC(settings: TransferSettings) {
+  if (!settings.webRTC || !webRTCAvailable()) return undefined;
+  try {
+    return await WebRTCNegotiation.offer(settings.webRTC.iceServers);
+  } catch {
+    return undefined;
+  }
+}
+
+async function answerWebRTC(settings: TransferSettings, offer: Uint8Array) {
+  if (!settings.webRTC || !webRTCAvailable()) return undefined;
+  try {
+    return await WebRTCNegotiation.answer(settings.webRTC.iceServers, offer);
+  } catch {
+    return undefined;
+  }
+}
+
+async function acceptWebRTC(
+  negotiation: WebRTCNegotiation,
+  answer: Uint8Array,
+  signal?: AbortSignal,
+) {
+  try {
+    await negotiation.accept(answer);
+    return await negotiation.channel(WEBRTC_RECIPIENT_TIMEOUT_MS, signal);
+  } catch {
+    checkAbort(signal);
+    return undefined;
+  }
+}
+
 async function reportPeerError(
   control: CrocSocket | undefined,
   key: Uint8Array | undefined,
@@ -429,27 +486,16 @@ function senderInfo(
     SendingText: sendingText,
     NoCompress: false,
     HashAlgorithm: "xxhash",
-    ReconnectVersion: 0,
-    NextReconnectRoom: "",
     Features: [PER_FILE_COMPRESSION_FEATURE],
   };
 }
 
-function requestedOffset(offset: number, ranges: number[] | null | undefined) {
-  if (!ranges || ranges.length === 0) return true;
-  const rangeChunkSize = ranges[0];
-  for (let index = 1; index + 1 < ranges.length; index += 2) {
-    const start = ranges[index];
-    const count = ranges[index + 1];
-    if (offset >= start && offset < start + count * rangeChunkSize) return true;
-  }
-  return false;
-}
-
+// The Go transfer session keeps the requested chunk ranges, so only the
+// chunks it reports as requested are sent, exactly as the CLI does.
 async function sendFileData(
   prepared: PreparedFile,
-  ranges: number[] | null,
-  sockets: CrocSocket[],
+  session: number,
+  sockets: DataConnection[],
   cipherHandle: number,
   compressed: boolean,
   progress: (bytes: number) => void,
@@ -468,7 +514,7 @@ async function sendFileData(
       ) {
         checkAbort(signal);
         const position = chunkIndex * CHUNK_SIZE;
-        if (!requestedOffset(position, ranges)) continue;
+        if (!(await engine.transferRequested(session, position))) continue;
         const data = new Uint8Array(
           await prepared.file
             .slice(position, position + CHUNK_SIZE)
@@ -516,9 +562,11 @@ export async function sendFiles(options: {
   const { room, passphrase } = await wasm().codeComponents(secret);
   const relayIndex = await wasm().relayIndex(secret, settings.relayAddresses.length);
   let control: CrocSocket | undefined;
-  let data: CrocSocket[] = [];
+  let data: DataConnection[] = [];
+  let webRTC: WebRTCNegotiation | undefined;
   let key: Uint8Array | undefined;
   let cipherHandle: number | undefined;
+  let session: number | undefined;
   try {
     callbacks.onStatus?.("Connecting to relay…");
     const relay = await connectRelay(
@@ -547,20 +595,44 @@ export async function sendFiles(options: {
     );
     const finished = await wasm().pakeUpdate(pake.handle, peerPake.b);
     const salt = randomBytes(PAKE_SALT_SIZE);
-    const peerKeys = await wasm().derivePeerKeys(
-      finished.key,
-      salt,
-      PAKE_PURPOSE_TRANSFER,
-      room,
-      curve,
-      peerPake.b,
-      finished.bytes,
-    );
+    // Recipients started with --pq offer an ML-KEM key; answering it mixes
+    // the KEM shared key into the channel keys.
+    const offeredKey =
+      peerPake.m === PAKE_HYBRID_MLKEM768 ? peerPake.b3 : undefined;
+    const hybrid = offeredKey
+      ? await wasm().hybridEncapsulate(PAKE_HYBRID_MLKEM768, offeredKey)
+      : undefined;
+    const peerKeys =
+      hybrid && offeredKey
+        ? await wasm().derivePeerKeysHybrid(
+            finished.key,
+            salt,
+            PAKE_PURPOSE_TRANSFER,
+            room,
+            curve,
+            peerPake.b,
+            finished.bytes,
+            PAKE_HYBRID_MLKEM768,
+            offeredKey,
+            hybrid.ciphertext,
+            hybrid.key,
+          )
+        : await wasm().derivePeerKeys(
+            finished.key,
+            salt,
+            PAKE_PURPOSE_TRANSFER,
+            room,
+            curve,
+            peerPake.b,
+            finished.bytes,
+          );
     await sendControl(control, {
       t: "pake",
       v: PAKE_PROTOCOL_VERSION,
+      m: hybrid ? PAKE_HYBRID_MLKEM768 : undefined,
       b: finished.bytes,
       b2: salt,
+      b3: hybrid?.ciphertext,
     });
     const confirmationA = await receiveControl(control);
     if (confirmationA.t !== "pake-confirm" || !confirmationA.b) {
@@ -592,15 +664,36 @@ export async function sendFiles(options: {
     const peerIP = await receiveControl(control, key);
     if (peerIP.t !== "externalip")
       throw new Error("Recipient did not secure the channel");
-    await sendControl(control, { t: "externalip", m: relay.externalIP }, key);
+    // A browser recipient offers a WebRTC data channel; answering it lets the
+    // recipient choose between the channel and the relay.
+    webRTC = peerIP.b3 ? await answerWebRTC(settings, peerIP.b3) : undefined;
     await sendControl(
       control,
-      {
-        t: "fileinfo",
-        b: textEncoder.encode(JSON.stringify(senderInfo(files, sendingText))),
-      },
+      { t: "externalip", m: relay.externalIP, b3: webRTC?.description() },
       key,
     );
+    if (webRTC) {
+      const route = await receiveControl(control, key);
+      if (route.t === "error")
+        throw new Error(route.m || "Recipient cancelled");
+      if (route.t !== "direct") {
+        throw new Error(`Expected the recipient's connection choice, got ${route.t}`);
+      }
+      if (route.m === WEBRTC_ROUTE) {
+        const channel = await webRTC.channel(WEBRTC_SENDER_TIMEOUT_MS, signal);
+        closeAll(undefined, data);
+        data = [channel];
+        callbacks.onStatus?.("Connected directly to the recipient");
+      } else {
+        webRTC.close();
+      }
+    }
+    // The Go transfer session checks the order of the recipient's messages
+    // and validates its requests, exactly as the CLI does.
+    session = await wasm().transferSender(
+      textEncoder.encode(JSON.stringify(senderInfo(files, sendingText))),
+    );
+    await sendControl(control, await wasm().transferFileInfo(session), key);
 
     let totalTransferred = 0;
     let progressStarted = false;
@@ -609,21 +702,21 @@ export async function sendFiles(options: {
       const message = await receiveControl(control, key);
       if (message.t === "error")
         throw new Error(message.m || "Recipient refused transfer");
-      if (message.t === "finished") {
-        await sendControl(control, { t: "finished" }, key);
+      const step = await wasm().transferHandle(session, message);
+      if (step.reply) await sendControl(control, step.reply, key);
+      if (step.done) {
         callbacks.onStatus?.("Transfer complete");
         return;
       }
-      if (message.t !== "recipientready" || !message.b) {
+      if (!step.request) {
         throw new Error(`Unexpected peer message: ${message.t}`);
       }
 
       const request = JSON.parse(
-        textDecoder.decode(message.b),
+        textDecoder.decode(step.request),
       ) as RemoteFileRequestWire;
       const fileIndex = request.FilesToTransferCurrentNum;
       const prepared = files[fileIndex];
-      if (!prepared) throw new Error("Recipient requested an unknown file");
       const displayName = sendingText ? "Text message" : prepared.name;
       callbacks.onStatus?.(
         sendingText ? "Sending text message" : `Sending ${prepared.name}`,
@@ -643,12 +736,10 @@ export async function sendFiles(options: {
       }
       await sendFileData(
         prepared,
-        request.CurrentFileChunkRanges,
+        session,
         data,
         cipherHandle,
-        (request.Features ?? []).includes(PER_FILE_COMPRESSION_FEATURE)
-          ? (prepared.compressed ?? true)
-          : true,
+        step.compressed,
         (fileBytes) => {
           totalTransferred = beforeFile + fileBytes;
           callbacks.onProgress?.({
@@ -671,19 +762,17 @@ export async function sendFiles(options: {
           `Expected recipient to close the file, got ${closed.t}`,
         );
       }
-      await sendControl(control, { t: "close-recipient" }, key);
+      const close = await wasm().transferHandle(session, closed);
+      if (close.reply) await sendControl(control, close.reply, key);
       callbacks.onFileComplete?.(displayName);
     }
   } catch (error) {
     await reportPeerError(control, key, error);
     throw error;
   } finally {
-    if (cipherHandle !== undefined) {
-      await wasm()
-        .cipherRelease(cipherHandle)
-        .catch(() => {});
-    }
+    await releaseSession(session, cipherHandle);
     closeAll(control, data);
+    webRTC?.close();
   }
 }
 
@@ -704,7 +793,7 @@ export class DataReceiver {
   private failure?: Error;
 
   constructor(
-    private sockets: CrocSocket[],
+    private sockets: DataConnection[],
     private key: Uint8Array,
     private noCompress: boolean,
     private cipherHandle?: number,
@@ -740,7 +829,7 @@ export class DataReceiver {
     this.fail(new Error("Data receiver stopped"));
   }
 
-  private async read(socket: CrocSocket) {
+  private async read(socket: DataConnection) {
     const engine = wasm();
     while (!this.stopped) {
       try {
@@ -842,9 +931,11 @@ export async function receiveFiles(options: {
   const { room, passphrase } = await wasm().codeComponents(secret);
   const relayIndex = await wasm().relayIndex(secret, settings.relayAddresses.length);
   let control: CrocSocket | undefined;
-  let data: CrocSocket[] = [];
+  let data: DataConnection[] = [];
+  let webRTC: WebRTCNegotiation | undefined;
   let key: Uint8Array | undefined;
   let cipherHandle: number | undefined;
+  let session: number | undefined;
   let receiver: DataReceiver | undefined;
   try {
     callbacks.onStatus?.("Connecting to relay…");
@@ -874,10 +965,29 @@ export async function receiveFiles(options: {
       b: pake.bytes,
       b2: textEncoder.encode(curve),
     });
-    const peerPake = await receiveControl(control);
+    let peerPake = await receiveControl(control);
+    // Senders started with --pq ask for an ML-KEM offer and wait for the
+    // initial PAKE value again.
+    let offer: HybridOffer | undefined;
+    if (peerPake.t === "pake" && peerPake.m === PAKE_REQUIRE_HYBRID_MLKEM768) {
+      requirePakeVersion(peerPake.v);
+      offer = await wasm().hybridOffer();
+      await sendControl(control, {
+        t: "pake",
+        v: PAKE_PROTOCOL_VERSION,
+        m: offer.kem,
+        b: pake.bytes,
+        b2: textEncoder.encode(curve),
+        b3: offer.encapsulationKey,
+      });
+      peerPake = await receiveControl(control);
+    }
     if (peerPake.t !== "pake" || !peerPake.b || !peerPake.b2) {
       throw new Error("Sender did not complete the croc PAKE handshake");
     }
+    if (offer && (peerPake.m !== offer.kem || !peerPake.b3)) {
+      throw new Error("Sender did not answer the post-quantum key exchange");
+    }
     requirePakeVersion(peerPake.v);
     if (peerPake.b2.byteLength !== PAKE_SALT_SIZE) {
       throw new Error(
@@ -885,15 +995,30 @@ export async function receiveFiles(options: {
       );
     }
     const finished = await wasm().pakeUpdate(pake.handle, peerPake.b);
-    const peerKeys = await wasm().derivePeerKeys(
-      finished.key,
-      peerPake.b2,
-      PAKE_PURPOSE_TRANSFER,
-      room,
-      curve,
-      pake.bytes,
-      peerPake.b,
-    );
+    const peerKeys =
+      offer && peerPake.b3
+        ? await wasm().derivePeerKeysHybrid(
+            finished.key,
+            peerPake.b2,
+            PAKE_PURPOSE_TRANSFER,
+            room,
+            curve,
+            pake.bytes,
+            peerPake.b,
+            offer.kem,
+            offer.encapsulationKey,
+            peerPake.b3,
+            await wasm().hybridDecapsulate(offer.handle, peerPake.b3),
+          )
+        : await wasm().derivePeerKeys(
+            finished.key,
+            peerPake.b2,
+            PAKE_PURPOSE_TRANSFER,
+            room,
+            curve,
+            pake.bytes,
+            peerPake.b,
+          );
     await sendControl(control, {
       t: "pake-confirm",
       v: PAKE_PROTOCOL_VERSION,
@@ -913,18 +1038,40 @@ export async function receiveFiles(options: {
     cipherHandle = await wasm().cipherInit(key);
     callbacks.onStatus?.("Opening transfer channels…");
     data = await openDataConnections(settings, room, dataPorts(relay.banner), relayIndex, signal);
+    // The relay data connections stay open until the WebRTC data channel
+    // is known to work.
+    webRTC = await offerWebRTC(settings);
     await sendControl(
       control,
       {
         t: "externalip",
         m: relay.externalIP,
         b: peerPake.b,
+        b3: webRTC?.description(),
       },
       key,
     );
     const peerIP = await receiveControl(control, key);
     if (peerIP.t !== "externalip")
       throw new Error("Sender did not secure the channel");
+    if (webRTC && peerIP.b3) {
+      callbacks.onStatus?.("Connecting directly to the sender…");
+      const channel = await acceptWebRTC(webRTC, peerIP.b3, signal);
+      await sendControl(
+        control,
+        { t: "direct", m: channel ? WEBRTC_ROUTE : RELAY_ROUTE },
+        key,
+      );
+      if (channel) {
+        closeAll(undefined, data);
+        data = [channel];
+        callbacks.onStatus?.("Connected directly to the sender");
+      } else {
+        webRTC.close();
+      }
+    } else {
+      webRTC?.close();
+    }
 
     callbacks.onStatus?.("Waiting for file list…");
     const fileInfo = await receiveControl(control, key);
@@ -933,7 +1080,12 @@ export async function receiveFiles(options: {
     if (fileInfo.t !== "fileinfo" || !fileInfo.b) {
       throw new Error("Sender did not provide file metadata");
     }
-    const sender = JSON.parse(textDecoder.decode(fileInfo.b)) as SenderInfoWire;
+    // The Go transfer session validates the file list like the CLI does;
+    // validateSenderInfo then applies what the browser can write.
+    session = await wasm().transferReceiver();
+    const { offer: validated } = await wasm().transferHandle(session, fileInfo);
+    if (!validated) throw new Error("Sender did not provide file metadata");
+    const sender = JSON.parse(textDecoder.decode(validated)) as SenderInfoWire;
     const offer = validateSenderInfo(sender);
     callbacks.onStatus?.("Review the incoming files");
     const destination = await callbacks.onOffer(offer);
@@ -999,27 +1151,31 @@ export async function receiveFiles(options: {
           CurrentFileChunkRanges: [],
           FilesToTransferCurrentNum: fileIndex,
           MachineID: machineID(),
-          ReconnectVersion: 0,
           Features: offer.perFileCompression
             ? [PER_FILE_COMPRESSION_FEATURE]
             : undefined,
         };
         await sendControl(
           control,
-          {
-            t: "recipientready",
-            b: textEncoder.encode(JSON.stringify(request)),
-          },
+          await wasm().transferRequest(
+            session,
+            textEncoder.encode(JSON.stringify(request)),
+          ),
           key,
         );
         await receivePromise;
         await sink.finalize();
-        await sendControl(control, { t: "close-sender" }, key);
+        await sendControl(
+          control,
+          await wasm().transferFileReceived(session),
+          key,
+        );
         const close = await receiveControl(control, key);
         if (close.t === "error") throw new Error(close.m || "Sender cancelled");
         if (close.t !== "close-recipient") {
           throw new Error(`Expected sender to close the file, got ${close.t}`);
         }
+        await wasm().transferHandle(session, close);
         callbacks.onStatus?.(`Verifying ${displayName}`);
         await verifySink(sink, file.hash);
         await sink.commit();
@@ -1031,11 +1187,12 @@ export async function receiveFiles(options: {
       }
     }
 
-    await sendControl(control, { t: "finished" }, key);
+    await sendControl(control, await wasm().transferFinish(session), key);
     const finishedMessage = await receiveControl(control, key);
     if (finishedMessage.t !== "finished") {
       throw new Error(`Expected transfer completion, got ${finishedMessage.t}`);
     }
+    await wasm().transferHandle(session, finishedMessage);
     callbacks.onStatus?.("Transfer complete");
     return offer;
   } catch (error) {
@@ -1043,11 +1200,8 @@ export async function receiveFiles(options: {
     throw error;
   } finally {
     receiver?.stop();
-    if (cipherHandle !== undefined) {
-      await wasm()
-        .cipherRelease(cipherHandle)
-        .catch(() => {});
-    }
+    await releaseSession(session, cipherHandle);
     closeAll(control, data);
+    webRTC?.close();
   }
 }
@@ -1,54 +1,20 @@
-import {
-  base64ToBytes,
-  bytesToBase64,
-  textDecoder,
-  textEncoder,
-} from "./bytes";
-import { MAX_FRAME_SIZE } from "./framing";
 import type { CrocMessage } from "./types";
 import type { CrocWasm } from "../wasm/client";
 
-type WireMessage = {
-  t: CrocMessage["t"];
-  v?: number;
-  m?: string;
-  b?: string;
-  b2?: string;
-  n?: number;
-};
-
-export async function encodeMessage(
+// Control messages are encoded by the Go message package, so that the browser
+// and the CLI share one wire format.
+export function encodeMessage(
   wasm: CrocWasm,
   message: CrocMessage,
   key?: Uint8Array,
 ) {
-  const wire: WireMessage = { t: message.t };
-  if (message.v) wire.v = message.v;
-  if (message.m) wire.m = message.m;
-  if (message.b?.byteLength) wire.b = bytesToBase64(message.b);
-  if (message.b2?.byteLength) wire.b2 = bytesToBase64(message.b2);
-  if (message.n) wire.n = message.n;
-  let bytes = await wasm.compress(textEncoder.encode(JSON.stringify(wire)));
-  if (key) bytes = await wasm.encrypt(bytes, key);
-  return bytes;
+  return wasm.encodeMessage(message, key);
 }
 
-export async function decodeMessage(
+export function decodeMessage(
   wasm: CrocWasm,
   payload: Uint8Array,
   key?: Uint8Array,
 ) {
-  let bytes = payload;
-  if (key) bytes = await wasm.decrypt(bytes, key);
-  bytes = await wasm.decompress(bytes, MAX_FRAME_SIZE);
-  const wire = JSON.parse(textDecoder.decode(bytes)) as WireMessage;
-  if (!wire.t) throw new Error("Peer message did not include a type");
-  return {
-    t: wire.t,
-    v: wire.v,
-    m: wire.m,
-    b: wire.b ? base64ToBytes(wire.b) : undefined,
-    b2: wire.b2 ? base64ToBytes(wire.b2) : undefined,
-    n: wire.n,
-  } satisfies CrocMessage;
+  return wasm.decodeMessage(payload, key);
 }
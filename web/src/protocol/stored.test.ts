@@ -5,6 +5,11 @@ const wasmMocks = vi.hoisted(() => ({
   storeRedeemCapability: vi.fn(async () => new Uint8Array(32)),
   storeSealManifest: vi.fn(async () => new Uint8Array(29)),
   storeSealChunk: vi.fn(async () => new Uint8Array(32)),
+  storeWrapRequestKey: vi.fn(async () => ({
+    origin: window.location.origin,
+    id: "BQUFBQUFBQUFBQUFBQUFBQ",
+    wrappedKey: new Uint8Array(3).fill(6),
+  })),
 }));
 
 vi.mock("../wasm/client", () => ({ wasm: () => wasmMocks }));
@@ -238,6 +243,71 @@ describe("stored-transfer expiration", () => {
   });
 });
 
+describe("stored file requests", () => {
+  const settings = {
+    storeAPI: "/api/v1/store",
+    maxTransferBytes: 1024,
+    maxFiles: 10,
+    maxDownloads: 3,
+    maxExpiresSeconds: 3 * 24 * 60 * 60,
+  };
+  const request = "croc-store-v1-request.example";
+
+  afterEach(() => vi.unstubAllGlobals());
+
+  it("wraps the key to the request and returns no share link", async () => {
+    const bodies: Array<Record<string, unknown>> = [];
+    vi.stubGlobal(
+      "fetch",
+      vi.fn(async (_input: RequestInfo | URL, init?: RequestInit) => {
+        if (init?.method === "POST" && init.body) {
+          bodies.push(JSON.parse(String(init.body)) as Record<string, unknown>);
+          return new Response(
+            JSON.stringify({
+              id: "AwMDAwMDAwMDAwMDAwMDAw",
+              uploadToken: "BAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQ",
+              uploadExpiresAt: "2026-08-11T13:00:00Z",
+              chunkSize: 4 * 1024 * 1024,
+            }),
+            { status: 201, headers: { "Content-Type": "application/json" } },
+          );
+        }
+        if (init?.method === "PUT") return new Response(null, { status: 204 });
+        return new Response(
+          JSON.stringify({ expiresAt: "2026-08-14T12:00:00Z" }),
+          { status: 200, headers: { "Content-Type": "application/json" } },
+        );
+      }),
+    );
+    const result = await uploadStoredFiles({ files: [], settings, request });
+    expect(wasmMocks.storeWrapRequestKey).toHaveBeenCalledWith(
+      request,
+      expect.any(Uint8Array),
+    );
+    expect(bodies[0]).toHaveProperty("request", {
+      id: "BQUFBQUFBQUFBQUFBQUFBQ",
+      wrappedKey: "BgYG",
+    });
+    expect(result.fileRequest).toBe("BQUFBQUFBQUFBQUFBQUFBQ");
+    expect(result.browserURL).toBe("");
+    expect(result.cliToken).toBe("");
+  });
+
+  it("rejects requests issued by another storage service", async () => {
+    wasmMocks.storeWrapRequestKey.mockResolvedValueOnce({
+      origin: "https://files.example.test",
+      id: "BQUFBQUFBQUFBQUFBQUFBQ",
+      wrappedKey: new Uint8Array(3),
+    });
+    const fetchMock = vi.fn();
+    vi.stubGlobal("fetch", fetchMock);
+    await expect(
+      uploadStoredFiles({ files: [], settings, request }),
+    ).rejects.toThrow(/another storage service/i);
+    expect(fetchMock).not.toHaveBeenCalled();
+  });
+});
+
 describe("stored commit recovery", () => {
   afterEach(() => {
     sessionStorage.clear();
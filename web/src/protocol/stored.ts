@@ -14,7 +14,7 @@ import type {
   TransferOffer,
   TransferSettings,
 } from "./types";
-import { wasm } from "../wasm/client";
+import { wasm, type WrappedRequestKey } from "../wasm/client";
 
 export const storedProtocol = "croc-store-v1";
 export const storedChunkSize = 4 * 1024 * 1024;
@@ -48,6 +48,11 @@ export type StoredPreparedFile = PreparedFile & {
   chunkCount: number;
 };
 
+/**
+ * StoredUploadResult describes a finished upload. Uploads to a file request
+ * name the request instead, and leave browserURL and cliToken empty so that
+ * only the requester can decrypt them.
+ */
 export type StoredUploadResult = {
   share: StoredShare;
   uploadToken: string;
@@ -55,6 +60,7 @@ export type StoredUploadResult = {
   browserURL: string;
   cliToken: string;
   downloads: number;
+  fileRequest?: string;
 };
 
 export type StoredInspection = {
@@ -210,6 +216,13 @@ export function storedShareFromLocation(location: Location = window.location) {
   return parseStoredShare(location.href);
 }
 
+/** fileRequestFromLocation returns the file request link the page was opened at. */
+export function fileRequestFromLocation(location: Location = window.location) {
+  if (!/^\/r\/[A-Za-z0-9_-]{22}$/.test(location.pathname)) return undefined;
+  if (!location.hash.startsWith("#v1.")) return undefined;
+  return location.href;
+}
+
 export function isStoredShareValue(value: string) {
   const trimmed = value.trim();
   if (trimmed.startsWith(`${storedProtocol}.`)) return true;
@@ -398,6 +411,7 @@ async function createStoredUpload(
   settings: StoredSettings,
   callbacks: StoredUploadCallbacks,
   signal?: AbortSignal,
+  request?: WrappedRequestKey,
 ): Promise<CreatedStoredUpload> {
   const redeem = await wasm().storeRedeemCapability(key);
   const redeemVerifier = new Uint8Array(
@@ -418,6 +432,14 @@ async function createStoredUpload(
       plaintextBytes: plan.totalSize,
       ...(downloads === 1 ? {} : { downloads }),
       ...(expiresSeconds === 24 * 60 * 60 ? {} : { expiresSeconds }),
+      ...(request
+        ? {
+            request: {
+              id: request.id,
+              wrappedKey: base64URL(request.wrappedKey),
+            },
+          }
+        : {}),
     }),
   });
   if (!response.ok) throw await responseError(response);
@@ -567,6 +589,8 @@ export async function uploadStoredFiles(options: {
   expiresSeconds?: number;
   callbacks?: StoredUploadCallbacks;
   signal?: AbortSignal;
+  /** request is a file request link or token to upload to. */
+  request?: string;
 }) {
   const {
     files,
@@ -575,6 +599,7 @@ export async function uploadStoredFiles(options: {
     expiresSeconds = 24 * 60 * 60,
     callbacks = {},
     signal,
+    request,
   } = options;
   if (!Number.isSafeInteger(downloads) || downloads < 1) {
     throw new Error("Stored-transfer downloads must be a positive integer");
@@ -602,6 +627,15 @@ export async function uploadStoredFiles(options: {
     );
   }
   const key = await wasm().storeGenerateKey();
+  const wrapped =
+    request === undefined
+      ? undefined
+      : await wasm().storeWrapRequestKey(request, key);
+  if (wrapped && wrapped.origin !== window.location.origin) {
+    throw new Error(
+      "This file request belongs to another storage service; open its link there",
+    );
+  }
   const plan = planStoredUpload(files);
   const created = await createStoredUpload(
     key,
@@ -611,6 +645,7 @@ export async function uploadStoredFiles(options: {
     settings,
     callbacks,
     signal,
+    wrapped,
   );
   let finalized = false;
   try {
@@ -623,6 +658,17 @@ export async function uploadStoredFiles(options: {
       signal,
     );
     finalized = true;
+    if (wrapped) {
+      return {
+        share: created.share,
+        uploadToken: created.uploadToken,
+        expiresAt,
+        browserURL: "",
+        cliToken: "",
+        downloads,
+        fileRequest: wrapped.id,
+      } satisfies StoredUploadResult;
+    }
     return {
       share: created.share,
       uploadToken: created.uploadToken,
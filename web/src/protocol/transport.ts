@@ -20,7 +20,14 @@ export function gatewayForPort(gateway: string, relayIndex: number, port: string
   return base.toString();
 }
 
-export class CrocSocket {
+/** DataConnection carries the encrypted file chunks of a transfer. */
+export interface DataConnection {
+  send(payload: Uint8Array): Promise<void>;
+  receive(): Promise<Uint8Array>;
+  close(): void;
+}
+
+export class CrocSocket implements DataConnection {
   private socket: WebSocket;
   private decoder = new FrameDecoder();
   private messages: Uint8Array[] = [];
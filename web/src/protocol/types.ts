@@ -7,7 +7,8 @@ export type MessageType =
   | "close-recipient"
   | "close-sender"
   | "recipientready"
-  | "fileinfo";
+  | "fileinfo"
+  | "direct";
 
 export interface CrocMessage {
   t: MessageType;
@@ -15,6 +16,7 @@ export interface CrocMessage {
   m?: string;
   b?: Uint8Array;
   b2?: Uint8Array;
+  b3?: Uint8Array;
   n?: number;
 }
 
@@ -51,7 +53,7 @@ export interface RemoteFileRequestWire {
   CurrentFileChunkRanges: number[] | null;
   FilesToTransferCurrentNum: number;
   MachineID: string;
-  ReconnectVersion: number;
+  ReconnectVersion?: number;
   Features?: string[];
 }
 
@@ -92,6 +94,8 @@ export interface TransferSettings {
   relayAddresses: string[];
   relayPassword: string;
   storeAPI: string;
+  // Browser peers upgrade to a WebRTC data channel when this is set.
+  webRTC?: { iceServers: string[] };
 }
 
 export interface FileProgress {
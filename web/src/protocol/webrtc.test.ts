@@ -0,0 +1,169 @@
+import { afterEach, beforeEach, describe, expect, it, vi } from "vitest";
+import {
+  decodeDescription,
+  encodeDescription,
+  WebRTCNegotiation,
+  webRTCAvailable,
+} from "./webrtc";
+
+// The fakes connect two peer connections in memory once the offerer accepts
+// the answer, as long as the network is reachable.
+const network = { reachable: true };
+const connections = new Map<string, FakePeerConnection>();
+
+class FakeDataChannel extends EventTarget {
+  readyState: RTCDataChannelState = "connecting";
+  binaryType = "blob";
+  bufferedAmount = 0;
+  bufferedAmountLowThreshold = 0;
+  peer?: FakeDataChannel;
+
+  send(data: Uint8Array) {
+    const copy = data.slice().buffer;
+    queueMicrotask(() => {
+      this.peer?.dispatchEvent(Object.assign(new Event("message"), { data: copy }));
+    });
+  }
+
+  open() {
+    this.readyState = "open";
+    this.dispatchEvent(new Event("open"));
+  }
+
+  close() {
+    if (this.readyState === "closed") return;
+    this.readyState = "closed";
+    this.dispatchEvent(new Event("close"));
+    this.peer?.close();
+  }
+}
+
+class FakePeerConnection extends EventTarget {
+  static created = 0;
+  id = `fake-${(FakePeerConnection.created += 1)}`;
+  iceGatheringState: RTCIceGatheringState = "new";
+  connectionState: RTCPeerConnectionState = "new";
+  localDescription: RTCSessionDescriptionInit | null = null;
+  channel?: FakeDataChannel;
+  remote?: FakePeerConnection;
+
+  constructor() {
+    super();
+    connections.set(this.id, this);
+  }
+
+  createDataChannel() {
+    this.channel = new FakeDataChannel();
+    return this.channel;
+  }
+
+  async createOffer() {
+    return { type: "offer", sdp: this.id } satisfies RTCSessionDescriptionInit;
+  }
+
+  async createAnswer() {
+    return { type: "answer", sdp: this.id } satisfies RTCSessionDescriptionInit;
+  }
+
+  async setLocalDescription(description: RTCSessionDescriptionInit) {
+    this.localDescription = description;
+    this.iceGatheringState = "complete";
+  }
+
+  async setRemoteDescription(description: RTCSessionDescriptionInit) {
+    this.remote = connections.get(description.sdp ?? "");
+    if (!this.remote) throw new Error("unknown description");
+    if (description.type !== "answer" || !network.reachable || !this.channel) {
+      return;
+    }
+    const local = this.channel;
+    const remote = new FakeDataChannel();
+    local.peer = remote;
+    remote.peer = local;
+    this.remote.dispatchEvent(
+      Object.assign(new Event("datachannel"), { channel: remote }),
+    );
+    setTimeout(() => {
+      local.open();
+      remote.open();
+    });
+  }
+
+  close() {
+    this.connectionState = "closed";
+    this.channel?.close();
+  }
+}
+
+beforeEach(() => {
+  network.reachable = true;
+  vi.stubGlobal("RTCPeerConnection", FakePeerConnection);
+});
+
+afterEach(() => {
+  vi.unstubAllGlobals();
+  connections.clear();
+});
+
+async function negotiate() {
+  const recipient = await WebRTCNegotiation.offer(["stun:stun.example"]);
+  const sender = await WebRTCNegotiation.answer([], recipient.description());
+  await recipient.accept(sender.description());
+  return { recipient, sender };
+}
+
+describe("WebRTC data channels", () => {
+  it("detects browsers without WebRTC", () => {
+    expect(webRTCAvailable()).toBe(true);
+    vi.stubGlobal("RTCPeerConnection", undefined);
+    expect(webRTCAvailable()).toBe(false);
+  });
+
+  it("carries binary messages once both peers are connected", async () => {
+    const { recipient, sender } = await negotiate();
+    const [received, sent] = await Promise.all([
+      recipient.channel(1_000),
+      sender.channel(1_000),
+    ]);
+
+    await sent.send(new Uint8Array([1, 2, 3]));
+    await sent.send(new Uint8Array([4]));
+    expect(await received.receive()).toEqual(new Uint8Array([1, 2, 3]));
+    expect(await received.receive()).toEqual(new Uint8Array([4]));
+
+    const pending = sent.receive();
+    received.close();
+    await expect(pending).rejects.toThrow("WebRTC connection closed");
+    await expect(sent.send(new Uint8Array([5]))).rejects.toThrow();
+  });
+
+  it("times out when the peers cannot reach each other", async () => {
+    network.reachable = false;
+    const { recipient, sender } = await negotiate();
+    await expect(recipient.channel(10)).rejects.toThrow("timed out");
+    await expect(sender.channel(10)).rejects.toThrow("timed out");
+  });
+
+  it("stops waiting when the transfer is cancelled", async () => {
+    network.reachable = false;
+    const { recipient } = await negotiate();
+    const controller = new AbortController();
+    const channel = recipient.channel(1_000, controller.signal);
+    controller.abort();
+    await expect(channel).rejects.toThrow("Transfer cancelled");
+  });
+
+  it("accepts only descriptions of the expected type", () => {
+    const offer = encodeDescription({ type: "offer", sdp: "v=0" });
+    expect(decodeDescription(offer, "offer")).toEqual({ type: "offer", sdp: "v=0" });
+    expect(() => decodeDescription(offer, "answer")).toThrow(
+      "Peer did not send a WebRTC answer",
+    );
+    expect(() =>
+      decodeDescription(new TextEncoder().encode("not json"), "offer"),
+    ).toThrow("invalid WebRTC description");
+    expect(() =>
+      decodeDescription(new Uint8Array(64 * 1024 + 1), "offer"),
+    ).toThrow("too large");
+  });
+});
@@ -0,0 +1,276 @@
+import { textDecoder, textEncoder } from "./bytes";
+import type { DataConnection } from "./transport";
+
+const GATHER_TIMEOUT_MS = 2_000;
+const MAX_DESCRIPTION_BYTES = 64 * 1024;
+const MAX_BUFFERED_BYTES = 4 * 1024 * 1024;
+const LOW_BUFFERED_BYTES = 1024 * 1024;
+// The recipient decides whether the data channel is used. The sender waits
+// longer, so that it cannot give up on a channel the recipient just accepted.
+export const WEBRTC_RECIPIENT_TIMEOUT_MS = 5_000;
+export const WEBRTC_SENDER_TIMEOUT_MS = 8_000;
+// Values of the recipient's "direct" message, matching the Go client's
+// direct-connection decision.
+export const WEBRTC_ROUTE = "webrtc";
+export const RELAY_ROUTE = "relay";
+
+type Reader = {
+  resolve(value: Uint8Array): void;
+  reject(reason: Error): void;
+};
+
+export function webRTCAvailable() {
+  return typeof RTCPeerConnection !== "undefined";
+}
+
+/** PeerChannel carries file data over an open WebRTC data channel. */
+export class PeerChannel implements DataConnection {
+  private messages: Uint8Array[] = [];
+  private readers: Reader[] = [];
+  private failure?: Error;
+
+  constructor(
+    private channel: RTCDataChannel,
+    private connection: RTCPeerConnection,
+  ) {
+    channel.binaryType = "arraybuffer";
+    channel.bufferedAmountLowThreshold = LOW_BUFFERED_BYTES;
+    channel.addEventListener("message", (event) => {
+      if (event.data instanceof ArrayBuffer) {
+        this.deliver(new Uint8Array(event.data));
+      } else {
+        this.fail(new Error("Peer sent an unexpected WebRTC message"));
+      }
+    });
+    channel.addEventListener("close", () => {
+      this.fail(new Error("WebRTC connection closed"));
+    });
+    channel.addEventListener("error", () => {
+      this.fail(new Error("WebRTC connection failed"));
+    });
+  }
+
+  async send(payload: Uint8Array) {
+    while (this.channel.bufferedAmount > MAX_BUFFERED_BYTES && !this.failure) {
+      await new Promise<void>((resolve) => {
+        const done = () => {
+          this.channel.removeEventListener("bufferedamountlow", done);
+          this.channel.removeEventListener("close", done);
+          resolve();
+        };
+        this.channel.addEventListener("bufferedamountlow", done);
+        this.channel.addEventListener("close", done);
+      });
+    }
+    if (this.failure) throw this.failure;
+    if (this.channel.readyState !== "open") {
+      throw new Error("WebRTC connection is not open");
+    }
+    this.channel.send(payload.slice());
+  }
+
+  receive(): Promise<Uint8Array> {
+    if (this.messages.length > 0) return Promise.resolve(this.messages.shift()!);
+    if (this.failure) return Promise.reject(this.failure);
+    return new Promise<Uint8Array>((resolve, reject) => {
+      this.readers.push({ resolve, reject });
+    });
+  }
+
+  close() {
+    this.fail(new Error("WebRTC connection closed"));
+    this.channel.close();
+    this.connection.close();
+  }
+
+  private deliver(message: Uint8Array) {
+    const reader = this.readers.shift();
+    if (reader) reader.resolve(message);
+    else this.messages.push(message);
+  }
+
+  private fail(error: Error) {
+    if (this.failure) return;
+    this.failure = error;
+    for (const reader of this.readers.splice(0)) reader.reject(error);
+  }
+}
+
+function newPeerConnection(iceServers: string[]) {
+  return new RTCPeerConnection({
+    iceServers: iceServers.length > 0 ? [{ urls: iceServers }] : [],
+  });
+}
+
+// channelOpened resolves once the channel can carry data.
+function channelOpened(channel: RTCDataChannel, connection: RTCPeerConnection) {
+  return new Promise<PeerChannel>((resolve, reject) => {
+    const cleanup = () => {
+      channel.removeEventListener("open", onOpen);
+      channel.removeEventListener("close", onClose);
+      connection.removeEventListener("connectionstatechange", onState);
+    };
+    const onOpen = () => {
+      cleanup();
+      resolve(new PeerChannel(channel, connection));
+    };
+    const onClose = () => {
+      cleanup();
+      reject(new Error("WebRTC data channel closed before it opened"));
+    };
+    const onState = () => {
+      if (connection.connectionState !== "failed") return;
+      cleanup();
+      reject(new Error("WebRTC connection failed"));
+    };
+    if (channel.readyState === "open") {
+      onOpen();
+      return;
+    }
+    channel.addEventListener("open", onOpen);
+    channel.addEventListener("close", onClose);
+    connection.addEventListener("connectionstatechange", onState);
+  });
+}
+
+// gathered waits until the local description lists its ICE candidates, so
+// that one offer and one answer are enough to connect.
+function gathered(connection: RTCPeerConnection) {
+  if (connection.iceGatheringState === "complete") return Promise.resolve();
+  return new Promise<void>((resolve) => {
+    const done = () => {
+      if (connection.iceGatheringState !== "complete") return;
+      finish();
+    };
+    const finish = () => {
+      clearTimeout(timer);
+      connection.removeEventListener("icegatheringstatechange", done);
+      resolve();
+    };
+    // Unreachable STUN servers must not hold up the transfer; the
+    // candidates gathered so far are still usable.
+    const timer = setTimeout(finish, GATHER_TIMEOUT_MS);
+    connection.addEventListener("icegatheringstatechange", done);
+  });
+}
+
+export function encodeDescription(description: RTCSessionDescriptionInit) {
+  return textEncoder.encode(
+    JSON.stringify({ type: description.type, sdp: description.sdp }),
+  );
+}
+
+export function decodeDescription(payload: Uint8Array, type: RTCSdpType) {
+  if (payload.byteLength > MAX_DESCRIPTION_BYTES) {
+    throw new Error("Peer sent a WebRTC description that is too large");
+  }
+  let description: { type?: unknown; sdp?: unknown };
+  try {
+    description = JSON.parse(textDecoder.decode(payload)) as typeof description;
+  } catch {
+    throw new Error("Peer sent an invalid WebRTC description");
+  }
+  if (description.type !== type || typeof description.sdp !== "string") {
+    throw new Error(`Peer did not send a WebRTC ${type}`);
+  }
+  return { type, sdp: description.sdp } satisfies RTCSessionDescriptionInit;
+}
+
+/**
+ * WebRTCNegotiation upgrades a transfer to a data channel after PAKE. The
+ * recipient offers and the sender answers; both descriptions travel in the
+ * encrypted externalip messages, so the relay never sees them.
+ */
+export class WebRTCNegotiation {
+  private constructor(
+    private connection: RTCPeerConnection,
+    private opened: Promise<PeerChannel>,
+  ) {
+    // The channel is only awaited when the peers choose it.
+    opened.catch(() => {});
+  }
+
+  static async offer(iceServers: string[]) {
+    const connection = newPeerConnection(iceServers);
+    try {
+      const channel = connection.createDataChannel("croc");
+      const negotiation = new WebRTCNegotiation(
+        connection,
+        channelOpened(channel, connection),
+      );
+      await connection.setLocalDescription(await connection.createOffer());
+      await gathered(connection);
+      return negotiation;
+    } catch (error) {
+      connection.close();
+      throw error;
+    }
+  }
+
+  static async answer(iceServers: string[], offer: Uint8Array) {
+    const connection = newPeerConnection(iceServers);
+    try {
+      const opened = new Promise<PeerChannel>((resolve, reject) => {
+        connection.addEventListener(
+          "datachannel",
+          (event) => {
+            channelOpened(event.channel, connection).then(resolve, reject);
+          },
+          { once: true },
+        );
+      });
+      const negotiation = new WebRTCNegotiation(connection, opened);
+      await connection.setRemoteDescription(decodeDescription(offer, "offer"));
+      await connection.setLocalDescription(await connection.createAnswer());
+      await gathered(connection);
+      return negotiation;
+    } catch (error) {
+      connection.close();
+      throw error;
+    }
+  }
+
+  description() {
+    const description = this.connection.localDescription;
+    if (!description) throw new Error("WebRTC description is not ready");
+    return encodeDescription(description);
+  }
+
+  accept(answer: Uint8Array) {
+    return this.connection.setRemoteDescription(
+      decodeDescription(answer, "answer"),
+    );
+  }
+
+  channel(timeoutMs: number, signal?: AbortSignal) {
+    return new Promise<PeerChannel>((resolve, reject) => {
+      const cleanup = () => {
+        clearTimeout(timer);
+        signal?.removeEventListener("abort", onAbort);
+      };
+      const onAbort = () => {
+        cleanup();
+        reject(new DOMException("Transfer cancelled", "AbortError"));
+      };
+      const timer = setTimeout(() => {
+        cleanup();
+        reject(new Error("WebRTC connection timed out"));
+      }, timeoutMs);
+      signal?.addEventListener("abort", onAbort, { once: true });
+      this.opened.then(
+        (channel) => {
+          cleanup();
+          resolve(channel);
+        },
+        (error: unknown) => {
+          cleanup();
+          reject(error instanceof Error ? error : new Error(String(error)));
+        },
+      );
+    });
+  }
+
+  close() {
+    this.connection.close();
+  }
+}
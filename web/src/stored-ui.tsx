@@ -275,3 +275,37 @@ export function StoredShareCard({
     </div>
   );
 }
+
+type StoredRequestReceiptProps = {
+  upload: StoredUploadResult;
+  onRevoke(): void;
+};
+
+export function StoredRequestReceipt({
+  upload,
+  onRevoke,
+}: StoredRequestReceiptProps) {
+  return (
+    <div className="stored-share" aria-live="polite">
+      <div className="offer-heading">
+        <span>Uploaded to the file request</span>
+        <span>
+          {upload.downloads} verified{" "}
+          {upload.downloads === 1 ? "download" : "downloads"}
+          {" · "}expires {new Date(upload.expiresAt).toLocaleString()}
+        </span>
+      </div>
+      <p>
+        Only the person who made this request can decrypt the files. They
+        collect them with their collection token; there is no link to share.
+      </p>
+      <button
+        type="button"
+        className="secondary-button revoke-button"
+        onClick={onRevoke}
+      >
+        <X /> Revoke now
+      </button>
+    </div>
+  );
+}
@@ -22,6 +22,9 @@ interface Window {
     gatewayURL?: string;
     relayAddresses?: string[];
     relayPassword?: string;
+    webRTC?: {
+      iceServers?: string[];
+    };
     store?: {
       enabled?: boolean;
       maxTransferBytes?: number;
@@ -1,3 +1,5 @@
+import type { CrocMessage } from "../protocol/types";
+
 export interface PakeStart {
   handle: number;
   bytes: Uint8Array;
@@ -14,6 +16,41 @@ export interface PeerKeys {
   confirmationB: Uint8Array;
 }
 
+export interface HybridOffer {
+  handle: number;
+  kem: string;
+  encapsulationKey: Uint8Array;
+}
+
+export interface HybridEncapsulation {
+  key: Uint8Array;
+  ciphertext: Uint8Array;
+}
+
+/**
+ * TransferStep is the Go transfer session's answer to a control message:
+ * the reply to send, the validated offer or request as JSON, and whether the
+ * peer closed the file or finished the transfer.
+ */
+export interface TransferStep {
+  reply?: CrocMessage;
+  offer?: Uint8Array;
+  request?: Uint8Array;
+  compressed: boolean;
+  closed: boolean;
+  done: boolean;
+}
+
+/**
+ * WrappedRequestKey is a stored transfer's master key wrapped to the public
+ * key of the file request it is uploaded to.
+ */
+export interface WrappedRequestKey {
+  origin: string;
+  id: string;
+  wrappedKey: Uint8Array;
+}
+
 export interface CodeComponents {
   room: string;
   passphrase: string;
@@ -157,6 +194,49 @@ export class CrocWasm {
     ]);
   }
 
+  hybridOffer() {
+    return this.call<HybridOffer>("hybridOffer", []);
+  }
+
+  hybridEncapsulate(kem: string, encapsulationKey: Uint8Array) {
+    return this.call<HybridEncapsulation>("hybridEncapsulate", [
+      kem,
+      encapsulationKey,
+    ]);
+  }
+
+  hybridDecapsulate(handle: number, ciphertext: Uint8Array) {
+    return this.call<Uint8Array>("hybridDecapsulate", [handle, ciphertext]);
+  }
+
+  derivePeerKeysHybrid(
+    sharedKey: Uint8Array,
+    salt: Uint8Array,
+    purpose: string,
+    room: string,
+    curve: string,
+    initiator: Uint8Array,
+    responder: Uint8Array,
+    kem: string,
+    encapsulationKey: Uint8Array,
+    ciphertext: Uint8Array,
+    kemKey: Uint8Array,
+  ) {
+    return this.call<PeerKeys>("derivePeerKeysHybrid", [
+      sharedKey,
+      salt,
+      purpose,
+      room,
+      curve,
+      initiator,
+      responder,
+      kem,
+      encapsulationKey,
+      ciphertext,
+      kemKey,
+    ]);
+  }
+
   confirmPeerKey(expected: Uint8Array, received: Uint8Array) {
     return this.call<boolean>("confirmPeerKey", [expected, received]);
   }
@@ -310,6 +390,58 @@ export class CrocWasm {
       ciphertext,
     ]);
   }
+
+  storeWrapRequestKey(request: string, key: Uint8Array) {
+    return this.call<WrappedRequestKey>("storeWrapRequestKey", [request, key]);
+  }
+
+  encodeMessage(message: CrocMessage, key?: Uint8Array) {
+    return this.call<Uint8Array>("encodeMessage", [message, key ?? null]);
+  }
+
+  decodeMessage(payload: Uint8Array, key?: Uint8Array) {
+    return this.call<CrocMessage>("decodeMessage", [payload, key ?? null]);
+  }
+
+  transferSender(senderInfo: Uint8Array) {
+    return this.call<number>("transferSender", [senderInfo]);
+  }
+
+  transferReceiver() {
+    return this.call<number>("transferReceiver");
+  }
+
+  transferFileInfo(handle: number) {
+    return this.call<CrocMessage>("transferFileInfo", [handle]);
+  }
+
+  transferRequest(handle: number, request: Uint8Array) {
+    return this.call<CrocMessage>("transferRequest", [handle, request]);
+  }
+
+  transferFileReceived(handle: number) {
+    return this.call<CrocMessage>("transferFileReceived", [handle]);
+  }
+
+  transferFinish(handle: number) {
+    return this.call<CrocMessage>("transferFinish", [handle]);
+  }
+
+  transferHandle(handle: number, message: CrocMessage) {
+    return this.call<TransferStep>("transferHandle", [handle, message]);
+  }
+
+  transferRequested(handle: number, position: number) {
+    return this.call<boolean>("transferRequested", [handle, position]);
+  }
+
+  transferReconnectRoom(handle: number) {
+    return this.call<string>("transferReconnectRoom", [handle]);
+  }
+
+  transferRelease(handle: number) {
+    return this.call<void>("transferRelease", [handle]);
+  }
 }
 
 let shared: CrocWasm | undefined;
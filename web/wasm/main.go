@@ -4,7 +4,9 @@ package main
 
 import (
 	"crypto/cipher"
+	"crypto/mlkem"
 	"crypto/sha256"
+	"encoding/json"
 	"fmt"
 	"hash"
 	"math"
@@ -15,8 +17,10 @@ import (
 	"github.com/schollz/croc/v11/src/codephrase"
 	croccompress "github.com/schollz/croc/v11/src/compress"
 	"github.com/schollz/croc/v11/src/crypt"
+	"github.com/schollz/croc/v11/src/message"
 	"github.com/schollz/croc/v11/src/pakekey"
 	"github.com/schollz/croc/v11/src/storecrypto"
+	"github.com/schollz/croc/v11/src/transfer"
 	"github.com/schollz/pake/v3"
 )
 
@@ -24,18 +28,22 @@ type bridge struct {
 	mu         sync.Mutex
 	nextHandle int
 	pakes      map[int]*pake.Pake
+	kems       map[int]*mlkem.DecapsulationKey768
 	hashes     map[int]*xxhash.Digest
 	sha256s    map[int]hash.Hash
 	ciphers    map[int]cipher.AEAD
+	sessions   map[int]*transfer.Session
 	funcs      []js.Func
 }
 
 func main() {
 	b := &bridge{
-		pakes:   make(map[int]*pake.Pake),
-		hashes:  make(map[int]*xxhash.Digest),
-		sha256s: make(map[int]hash.Hash),
-		ciphers: make(map[int]cipher.AEAD),
+		pakes:    make(map[int]*pake.Pake),
+		kems:     make(map[int]*mlkem.DecapsulationKey768),
+		hashes:   make(map[int]*xxhash.Digest),
+		sha256s:  make(map[int]hash.Hash),
+		ciphers:  make(map[int]cipher.AEAD),
+		sessions: make(map[int]*transfer.Session),
 	}
 	api := js.Global().Get("Object").New()
 	b.expose(api, "pakeInit", b.pakeInit)
@@ -44,6 +52,10 @@ func main() {
 	b.expose(api, "deriveKey", b.deriveKey)
 	b.expose(api, "derivePeerKeys", b.derivePeerKeys)
 	b.expose(api, "confirmPeerKey", b.confirmPeerKey)
+	b.expose(api, "hybridOffer", b.hybridOffer)
+	b.expose(api, "hybridEncapsulate", b.hybridEncapsulate)
+	b.expose(api, "hybridDecapsulate", b.hybridDecapsulate)
+	b.expose(api, "derivePeerKeysHybrid", b.derivePeerKeysHybrid)
 	b.expose(api, "encrypt", b.encrypt)
 	b.expose(api, "decrypt", b.decrypt)
 	b.expose(api, "compress", b.compress)
@@ -66,6 +78,19 @@ func main() {
 	b.expose(api, "storeOpenManifest", b.storeOpenManifest)
 	b.expose(api, "storeSealChunk", b.storeSealChunk)
 	b.expose(api, "storeOpenChunk", b.storeOpenChunk)
+	b.expose(api, "storeWrapRequestKey", b.storeWrapRequestKey)
+	b.expose(api, "encodeMessage", b.encodeMessage)
+	b.expose(api, "decodeMessage", b.decodeMessage)
+	b.expose(api, "transferSender", b.transferSender)
+	b.expose(api, "transferReceiver", b.transferReceiver)
+	b.expose(api, "transferFileInfo", b.transferFileInfo)
+	b.expose(api, "transferRequest", b.transferRequest)
+	b.expose(api, "transferFileReceived", b.transferFileReceived)
+	b.expose(api, "transferFinish", b.transferFinish)
+	b.expose(api, "transferHandle", b.transferHandle)
+	b.expose(api, "transferRequested", b.transferRequested)
+	b.expose(api, "transferReconnectRoom", b.transferReconnectRoom)
+	b.expose(api, "transferRelease", b.transferRelease)
 	js.Global().Set("crocWasm", api)
 	select {}
 }
@@ -223,44 +248,137 @@ func (b *bridge) deriveKey(args []js.Value) (any, error) {
 	return bytesToJS(key), nil
 }
 
+func peerKeyContext(args []js.Value) (sharedKey []byte, context pakekey.Context, err error) {
+	if sharedKey, err = bytesFromJS(args[0]); err != nil {
+		return
+	}
+	if context.Salt, err = bytesFromJS(args[1]); err != nil {
+		return
+	}
+	if context.Initiator, err = bytesFromJS(args[5]); err != nil {
+		return
+	}
+	if context.Responder, err = bytesFromJS(args[6]); err != nil {
+		return
+	}
+	context.Purpose = args[2].String()
+	context.Room = args[3].String()
+	context.Curve = args[4].String()
+	return
+}
+
+func peerKeysToJS(keys pakekey.Keys) js.Value {
+	result := js.Global().Get("Object").New()
+	result.Set("key", bytesToJS(keys.EncryptionKey))
+	result.Set("confirmationA", bytesToJS(keys.ConfirmationA))
+	result.Set("confirmationB", bytesToJS(keys.ConfirmationB))
+	return result
+}
+
 func (b *bridge) derivePeerKeys(args []js.Value) (any, error) {
 	if len(args) != 7 {
 		return nil, fmt.Errorf("derivePeerKeys expects session key, salt, purpose, room, curve, initiator, and responder")
 	}
-	sharedKey, err := bytesFromJS(args[0])
+	sharedKey, context, err := peerKeyContext(args)
 	if err != nil {
 		return nil, err
 	}
-	salt, err := bytesFromJS(args[1])
+	keys, err := pakekey.Derive(sharedKey, context)
 	if err != nil {
 		return nil, err
 	}
-	initiator, err := bytesFromJS(args[5])
+	return peerKeysToJS(keys), nil
+}
+
+func (b *bridge) hybridOffer(args []js.Value) (any, error) {
+	if len(args) != 0 {
+		return nil, fmt.Errorf("hybridOffer expects no arguments")
+	}
+	decapsulationKey, err := pakekey.GenerateHybridKey()
 	if err != nil {
 		return nil, err
 	}
-	responder, err := bytesFromJS(args[6])
+
+	b.mu.Lock()
+	handle := b.allocateHandle()
+	b.kems[handle] = decapsulationKey
+	b.mu.Unlock()
+
+	result := js.Global().Get("Object").New()
+	result.Set("handle", handle)
+	result.Set("kem", pakekey.HybridMLKEM768)
+	result.Set("encapsulationKey", bytesToJS(decapsulationKey.EncapsulationKey().Bytes()))
+	return result, nil
+}
+
+func (b *bridge) hybridEncapsulate(args []js.Value) (any, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("hybridEncapsulate expects mode and encapsulation key")
+	}
+	encapsulationKey, err := bytesFromJS(args[1])
 	if err != nil {
 		return nil, err
 	}
-	keys, err := pakekey.Derive(sharedKey, pakekey.Context{
-		Purpose:   args[2].String(),
-		Room:      args[3].String(),
-		Curve:     args[4].String(),
-		Initiator: initiator,
-		Responder: responder,
-		Salt:      salt,
-	})
+	sharedKey, ciphertext, err := pakekey.Encapsulate(args[0].String(), encapsulationKey)
 	if err != nil {
 		return nil, err
 	}
 	result := js.Global().Get("Object").New()
-	result.Set("key", bytesToJS(keys.EncryptionKey))
-	result.Set("confirmationA", bytesToJS(keys.ConfirmationA))
-	result.Set("confirmationB", bytesToJS(keys.ConfirmationB))
+	result.Set("key", bytesToJS(sharedKey))
+	result.Set("ciphertext", bytesToJS(ciphertext))
 	return result, nil
 }
 
+func (b *bridge) hybridDecapsulate(args []js.Value) (any, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("hybridDecapsulate expects handle and ciphertext")
+	}
+	handle := args[0].Int()
+	ciphertext, err := bytesFromJS(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	decapsulationKey, exists := b.kems[handle]
+	delete(b.kems, handle)
+	b.mu.Unlock()
+	if !exists {
+		return nil, fmt.Errorf("unknown hybrid key handle")
+	}
+	sharedKey, err := decapsulationKey.Decapsulate(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	return bytesToJS(sharedKey), nil
+}
+
+func (b *bridge) derivePeerKeysHybrid(args []js.Value) (any, error) {
+	if len(args) != 11 {
+		return nil, fmt.Errorf("derivePeerKeysHybrid expects session key, salt, purpose, room, curve, initiator, responder, mode, encapsulation key, ciphertext, and KEM key")
+	}
+	sharedKey, context, err := peerKeyContext(args)
+	if err != nil {
+		return nil, err
+	}
+	context.KEM = args[7].String()
+	if context.EncapsulationKey, err = bytesFromJS(args[8]); err != nil {
+		return nil, err
+	}
+	if context.Ciphertext, err = bytesFromJS(args[9]); err != nil {
+		return nil, err
+	}
+	kemSharedKey, err := bytesFromJS(args[10])
+	if err != nil {
+		return nil, err
+	}
+	keys, err := pakekey.DeriveHybrid(sharedKey, kemSharedKey, context)
+	if err != nil {
+		return nil, err
+	}
+	return peerKeysToJS(keys), nil
+}
+
 func (b *bridge) confirmPeerKey(args []js.Value) (any, error) {
 	if len(args) != 2 {
 		return nil, fmt.Errorf("confirmPeerKey expects expected and received tags")
@@ -670,3 +788,301 @@ func (b *bridge) storeOpenChunk(args []js.Value) (any, error) {
 	}
 	return bytesToJS(plaintext), nil
 }
+
+// storeWrapRequestKey parses a file request link or token and wraps a new
+// transfer's master key to the request's public key.
+func (b *bridge) storeWrapRequestKey(args []js.Value) (any, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("storeWrapRequestKey expects a file request and a master key")
+	}
+	request, err := storecrypto.ParseFileRequest(args[0].String())
+	if err != nil {
+		return nil, err
+	}
+	key, err := bytesFromJS(args[1])
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := storecrypto.WrapRequestKey(request, key)
+	if err != nil {
+		return nil, err
+	}
+	result := js.Global().Get("Object").New()
+	result.Set("origin", request.Origin)
+	result.Set("id", request.ID)
+	result.Set("wrappedKey", bytesToJS(wrapped))
+	return result, nil
+}
+
+// optionalBytesFromJS reads a Uint8Array that may be left out.
+func optionalBytesFromJS(value js.Value) ([]byte, error) {
+	if value.IsUndefined() || value.IsNull() {
+		return nil, nil
+	}
+	return bytesFromJS(value)
+}
+
+func messageFromJS(value js.Value) (m message.Message, err error) {
+	if value.Type() != js.TypeObject {
+		return m, fmt.Errorf("expected a message")
+	}
+	m.Type = message.Type(value.Get("t").String())
+	if version := value.Get("v"); version.Type() == js.TypeNumber {
+		m.Version = version.Int()
+	}
+	if text := value.Get("m"); text.Type() == js.TypeString {
+		m.Message = text.String()
+	}
+	if num := value.Get("n"); num.Type() == js.TypeNumber {
+		m.Num = num.Int()
+	}
+	if m.Bytes, err = optionalBytesFromJS(value.Get("b")); err != nil {
+		return
+	}
+	if m.Bytes2, err = optionalBytesFromJS(value.Get("b2")); err != nil {
+		return
+	}
+	m.Bytes3, err = optionalBytesFromJS(value.Get("b3"))
+	return
+}
+
+func messageToJS(m message.Message) js.Value {
+	result := js.Global().Get("Object").New()
+	result.Set("t", string(m.Type))
+	if m.Version != 0 {
+		result.Set("v", m.Version)
+	}
+	if m.Message != "" {
+		result.Set("m", m.Message)
+	}
+	if len(m.Bytes) > 0 {
+		result.Set("b", bytesToJS(m.Bytes))
+	}
+	if len(m.Bytes2) > 0 {
+		result.Set("b2", bytesToJS(m.Bytes2))
+	}
+	if len(m.Bytes3) > 0 {
+		result.Set("b3", bytesToJS(m.Bytes3))
+	}
+	if m.Num != 0 {
+		result.Set("n", m.Num)
+	}
+	return result
+}
+
+func (b *bridge) encodeMessage(args []js.Value) (any, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("encodeMessage expects message and key")
+	}
+	m, err := messageFromJS(args[0])
+	if err != nil {
+		return nil, err
+	}
+	key, err := optionalBytesFromJS(args[1])
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := message.Encode(key, m)
+	if err != nil {
+		return nil, err
+	}
+	return bytesToJS(encoded), nil
+}
+
+func (b *bridge) decodeMessage(args []js.Value) (any, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("decodeMessage expects bytes and key")
+	}
+	payload, err := bytesFromJS(args[0])
+	if err != nil {
+		return nil, err
+	}
+	key, err := optionalBytesFromJS(args[1])
+	if err != nil {
+		return nil, err
+	}
+	m, err := message.Decode(key, payload)
+	if err != nil {
+		return nil, err
+	}
+	if m.Type == "" {
+		return nil, fmt.Errorf("peer message did not include a type")
+	}
+	return messageToJS(m), nil
+}
+
+func (b *bridge) addSession(session *transfer.Session) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	handle := b.allocateHandle()
+	b.sessions[handle] = session
+	return handle
+}
+
+func (b *bridge) session(handle js.Value) (*transfer.Session, error) {
+	b.mu.Lock()
+	session := b.sessions[handle.Int()]
+	b.mu.Unlock()
+	if session == nil {
+		return nil, fmt.Errorf("unknown transfer handle")
+	}
+	return session, nil
+}
+
+func (b *bridge) transferSender(args []js.Value) (any, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("transferSender expects the sender info as JSON")
+	}
+	data, err := bytesFromJS(args[0])
+	if err != nil {
+		return nil, err
+	}
+	var info transfer.SenderInfo
+	if err = json.Unmarshal(data, &info); err != nil {
+		return nil, err
+	}
+	return b.addSession(transfer.NewSenderSession(info)), nil
+}
+
+func (b *bridge) transferReceiver(args []js.Value) (any, error) {
+	if len(args) != 0 {
+		return nil, fmt.Errorf("transferReceiver expects no arguments")
+	}
+	return b.addSession(transfer.NewReceiverSession()), nil
+}
+
+func (b *bridge) transferFileInfo(args []js.Value) (any, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("transferFileInfo expects a handle")
+	}
+	session, err := b.session(args[0])
+	if err != nil {
+		return nil, err
+	}
+	m, err := session.FileInfo()
+	if err != nil {
+		return nil, err
+	}
+	return messageToJS(m), nil
+}
+
+func (b *bridge) transferRequest(args []js.Value) (any, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("transferRequest expects handle and the request as JSON")
+	}
+	session, err := b.session(args[0])
+	if err != nil {
+		return nil, err
+	}
+	data, err := bytesFromJS(args[1])
+	if err != nil {
+		return nil, err
+	}
+	var request transfer.RemoteFileRequest
+	if err = json.Unmarshal(data, &request); err != nil {
+		return nil, err
+	}
+	m, err := session.Request(request)
+	if err != nil {
+		return nil, err
+	}
+	return messageToJS(m), nil
+}
+
+func (b *bridge) transferFileReceived(args []js.Value) (any, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("transferFileReceived expects a handle")
+	}
+	session, err := b.session(args[0])
+	if err != nil {
+		return nil, err
+	}
+	m, err := session.FileReceived()
+	if err != nil {
+		return nil, err
+	}
+	return messageToJS(m), nil
+}
+
+func (b *bridge) transferFinish(args []js.Value) (any, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("transferFinish expects a handle")
+	}
+	session, err := b.session(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return messageToJS(session.Finish()), nil
+}
+
+func (b *bridge) transferHandle(args []js.Value) (any, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("transferHandle expects handle and message")
+	}
+	session, err := b.session(args[0])
+	if err != nil {
+		return nil, err
+	}
+	m, err := messageFromJS(args[1])
+	if err != nil {
+		return nil, err
+	}
+	step, err := session.Handle(m)
+	if err != nil {
+		return nil, err
+	}
+	result := js.Global().Get("Object").New()
+	if step.Reply != nil {
+		result.Set("reply", messageToJS(*step.Reply))
+	}
+	if step.Offer != nil {
+		offer, err := json.Marshal(step.Offer)
+		if err != nil {
+			return nil, err
+		}
+		result.Set("offer", bytesToJS(offer))
+	}
+	if step.Request != nil {
+		request, err := json.Marshal(step.Request)
+		if err != nil {
+			return nil, err
+		}
+		result.Set("request", bytesToJS(request))
+	}
+	result.Set("compressed", step.Compressed)
+	result.Set("closed", step.Closed)
+	result.Set("done", step.Done)
+	return result, nil
+}
+
+func (b *bridge) transferRequested(args []js.Value) (any, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("transferRequested expects handle and position")
+	}
+	session, err := b.session(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return session.Requested(int64(args[1].Float())), nil
+}
+
+func (b *bridge) transferReconnectRoom(args []js.Value) (any, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("transferReconnectRoom expects a handle")
+	}
+	session, err := b.session(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return session.ReconnectRoom(), nil
+}
+
+func (b *bridge) transferRelease(args []js.Value) (any, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("transferRelease expects a handle")
+	}
+	b.mu.Lock()
+	delete(b.sessions, args[0].Int())
+	b.mu.Unlock()
+	return nil, nil
+}